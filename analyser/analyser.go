@@ -15,6 +15,15 @@ import (
 	"github.com/rubenv/topojson"
 )
 
+// The possible types of data identified in the csv
+const (
+	DataTypeNumeric     = "numeric"
+	DataTypeCategorical = "categorical"
+)
+
+// maxClassCount is the largest number of classes for which breaks are suggested
+const maxClassCount = 11
+
 // AnalyseData analyses the given topology and csv file to confirm that they match, returning the csv converted to json
 func AnalyseData(request *models.AnalyseRequest) (*models.AnalyseResponse, error) {
 
@@ -24,10 +33,13 @@ func AnalyseData(request *models.AnalyseRequest) (*models.AnalyseResponse, error
 	}
 
 	messages := parseInfo.messages
+	request.Geography.MatchRowIDs(parseInfo.rows)
 
-	ids := getTopologyIDs(request.Geography.Topojson, request.Geography.IDProperty)
+	ids := getTopologyIDs(request.Geography.SelectedTopology(), request.Geography)
 	unmatchedRows := []string{}
+	rowIDs := make(map[string]bool)
 	for _, row := range parseInfo.rows {
+		rowIDs[row.ID] = true
 		id := ids[row.ID]
 		if len(id) == 0 {
 			unmatchedRows = append(unmatchedRows, row.ID)
@@ -43,15 +55,75 @@ func AnalyseData(request *models.AnalyseRequest) (*models.AnalyseResponse, error
 	count := len(parseInfo.rows) - len(unmatchedRows)
 	messages = append(messages, &models.Message{Level: "info", Text: fmt.Sprintf("Successfully processed %d of %d rows", count, parseInfo.totalRows)})
 
+	diagnostics := models.NewJoinDiagnostics(ids, rowIDs, unmatchedRows, count)
+
+	if parseInfo.categorical {
+		return analyseCategories(parseInfo.rows, messages, diagnostics), nil
+	}
+
 	values := extractValues(parseInfo.rows)
-	breaks := jenks.AllNaturalBreaks(values, 11)
+	mean := sum(values) / float64(len(values))
+	stdDev := math.Sqrt(sumOfSquaredDeviations(values) / float64(len(values)))
+
+	if request.Classification == models.ClassificationStdDev {
+		breaks, firstClass := stdDevBreaks(values, mean, stdDev)
+		breaks = jenks.Round(breaks, values)
+		response := &models.AnalyseResponse{DataType: DataTypeNumeric, Data: parseInfo.rows, Messages: messages, Breaks: [][]float64{breaks}, MinValue: values[0], MaxValue: values[len(values)-1], Mean: mean, StandardDeviation: stdDev, Units: request.Units, BestFitClassCount: len(breaks), JoinDiagnostics: diagnostics, SuggestedPalettes: stdDevPalettes(firstClass, len(breaks)), Histogram: histogram(values, request.HistogramBins)}
+		roundStatistics(response, request.DecimalPlaces)
+		return response, nil
+	}
+
+	var breaks [][]float64
+	switch request.Classification {
+	case models.ClassificationHeadTail:
+		breaks = [][]float64{headTailBreaks(values, maxClassCount)}
+	case models.ClassificationLog:
+		if values[0] > 0 {
+			breaks = logBreaks(values, maxClassCount)
+		} else {
+			messages = append(messages, &models.Message{Level: "warn", Text: "Log classification requires every value to be positive - natural breaks have been used instead"})
+		}
+	}
+	logScale := breaks != nil && request.Classification == models.ClassificationLog
+	if breaks == nil {
+		breaks = jenks.AllNaturalBreaks(values, maxClassCount)
+	}
 	for i := range breaks {
 		breaks[i] = jenks.Round(breaks[i], values)
+		if logScale {
+			breaks[i][0] = values[0] // rounding may take the lowest bound to zero, which can't be shown on a log scale
+		}
+	}
+
+	var classCount int
+	if request.Classification == models.ClassificationHeadTail {
+		classCount = len(breaks[0]) // the number of classes is determined by the distribution of the data
+	} else {
+		classCount = bestFitClassCount(values, breaks)
 	}
 
-	classCount := bestFitClassCount(values, breaks)
+	referenceValue := 0.0
+	if request.ReferenceValue != nil {
+		referenceValue = *request.ReferenceValue
+	}
+	palettes := suggestPalettes(values[0], values[len(values)-1], referenceValue, classCount)
+
+	response := &models.AnalyseResponse{DataType: DataTypeNumeric, Data: parseInfo.rows, Messages: messages, Breaks: breaks, MinValue: values[0], MaxValue: values[len(values)-1], Mean: mean, StandardDeviation: stdDev, Units: request.Units, BestFitClassCount: classCount, JoinDiagnostics: diagnostics, SuggestedPalettes: palettes, Histogram: histogram(values, request.HistogramBins)}
+	roundStatistics(response, request.DecimalPlaces)
+	return response, nil
+}
 
-	return &models.AnalyseResponse{Data: parseInfo.rows, Messages: messages, Breaks: breaks, MinValue: values[0], MaxValue: values[len(values)-1], BestFitClassCount: classCount}, nil
+// roundStatistics rounds the min, max, mean and standard deviation of the response to the given number of decimal places, if given
+func roundStatistics(response *models.AnalyseResponse, decimalPlaces *int) {
+	if decimalPlaces == nil {
+		return
+	}
+	scale := math.Pow(10, float64(*decimalPlaces))
+	round := func(value float64) float64 { return math.Round(value*scale) / scale }
+	response.MinValue = round(response.MinValue)
+	response.MaxValue = round(response.MaxValue)
+	response.Mean = round(response.Mean)
+	response.StandardDeviation = round(response.StandardDeviation)
 }
 
 // extractValues extracts and sorts the values in rows.
@@ -65,6 +137,7 @@ func extractValues(rows []*models.DataRow) []float64 {
 }
 
 // parseData parses the csv file into a slice of DataRows, returning it along with messages about the number of rows parsed and any failed rows.
+// If none of the rows have a numeric value, the data is treated as categorical, and the rows are returned with their Category populated instead.
 func parseData(csvSource string, idIndex int, valueIndex int, hasHeader bool) (*parseInfo, error) {
 	r := csv.NewReader(strings.NewReader(csvSource))
 	r.FieldsPerRecord = -1 // allow variable count of fields per record
@@ -77,9 +150,12 @@ func parseData(csvSource string, idIndex int, valueIndex int, hasHeader bool) (*
 
 	missingColumns := []int{}
 	missingValues := []string{}
+	emptyValues := []string{}
 	rows := []*models.DataRow{}
+	categoryRows := []*models.DataRow{}
 
 	i := 0
+	valueRows := 0 // the number of rows with a value column
 	for {
 		record, err := r.Read()
 		if err == io.EOF {
@@ -94,10 +170,16 @@ func parseData(csvSource string, idIndex int, valueIndex int, hasHeader bool) (*
 			missingColumns = append(missingColumns, i)
 			continue
 		}
+		valueRows++
 		id := record[idIndex]
 		value, err := strconv.ParseFloat(record[valueIndex], 64)
 		if err != nil {
 			missingValues = append(missingValues, id)
+			if category := strings.TrimSpace(record[valueIndex]); len(category) > 0 {
+				categoryRows = append(categoryRows, &models.DataRow{ID: id, Category: category})
+			} else {
+				emptyValues = append(emptyValues, id)
+			}
 			continue
 		}
 		rows = append(rows, &models.DataRow{ID: id, Value: value})
@@ -105,8 +187,15 @@ func parseData(csvSource string, idIndex int, valueIndex int, hasHeader bool) (*
 	if len(missingColumns) == i {
 		return nil, fmt.Errorf("All CSV rows had fewer than %d columns - could not read data", requiredColumns)
 	}
-	if len(missingValues) == i {
-		return nil, fmt.Errorf("No CSV rows had a numeric value - could not read data")
+	categorical := false
+	if len(missingValues) == valueRows {
+		if len(categoryRows) == 0 {
+			return nil, fmt.Errorf("No CSV rows had a numeric value - could not read data")
+		}
+		// no numeric values - treat the data as categorical
+		categorical = true
+		rows = categoryRows
+		missingValues = emptyValues
 	}
 
 	messages := []*models.Message{}
@@ -118,34 +207,30 @@ func parseData(csvSource string, idIndex int, valueIndex int, hasHeader bool) (*
 		messages = append(messages, &models.Message{Level: "warn", Text: fmt.Sprintf("%d rows have missing (or non-numeric) values and could not be parsed. Row IDs: [%v]", len(missingValues), strings.Join(missingValues, ", "))})
 	}
 
-	return &parseInfo{rows: rows, messages: messages, totalRows: i}, nil
+	return &parseInfo{rows: rows, messages: messages, totalRows: i, categorical: categorical}, nil
 }
 
-// getTopologyIDs extracts the id from each object in the topology, using the given idProperty first, or the ID if no such property found
-func getTopologyIDs(topology *topojson.Topology, idProperty string) map[string]string {
+// getTopologyIDs extracts the id from each object in the topology, using the geography's id properties first, or the ID if it has none of them
+func getTopologyIDs(topology *topojson.Topology, geography *models.Geography) map[string]string {
 	o := []*topojson.Geometry{}
 	for _, v := range topology.Objects {
 		o = append(o, v)
 	}
-	return getGeographyIDs(o, idProperty)
+	return getGeographyIDs(o, geography)
 }
 
-// getGeographyIDs extracts the id from each geometry, using the given idProperty first, or the ID if no such property found
-func getGeographyIDs(topologyObjects []*topojson.Geometry, idProperty string) map[string]string {
+// getGeographyIDs extracts the id from each geometry, using the geography's id properties first, or the ID if it has none of them
+func getGeographyIDs(topologyObjects []*topojson.Geometry, geography *models.Geography) map[string]string {
 	m := make(map[string]string)
 	for _, o := range topologyObjects {
 		if o.Type == "GeometryCollection" {
-			om := getGeographyIDs(o.Geometries, idProperty)
+			om := getGeographyIDs(o.Geometries, geography)
 			for k, v := range om {
 				m[k] = v
 			}
 		} else {
-			id, isString := o.Properties[idProperty].(string)
-			if isString && len(id) > 0 {
-				m[id] = id
-			} else {
-				m[o.ID] = o.ID
-			}
+			id := geography.FeatureID(o.Properties, o.ID)
+			m[id] = id
 		}
 	}
 	return m
@@ -153,9 +238,10 @@ func getGeographyIDs(topologyObjects []*topojson.Geometry, idProperty string) ma
 
 // parseInfo contains information about the rows parsed from the csv
 type parseInfo struct {
-	rows      []*models.DataRow
-	messages  []*models.Message
-	totalRows int
+	rows        []*models.DataRow
+	messages    []*models.Message
+	totalRows   int
+	categorical bool // true if the rows have a Category rather than a numeric Value
 }
 
 // bestFitClassCount tries to find the breaks that best fit the data in the fewest classes.
@@ -54,6 +54,275 @@ func TestAnalyseData(t *testing.T) {
 
 }
 
+func TestAnalyseDataReportsJoinDiagnostics(t *testing.T) {
+	Convey("AnalyseData should report rows that do not match the topology and features without data", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.CSV = "S12000013,Eilean Siar (Western Isles),1\nS12000023,Orkney Islands,2\nMyUnknownID,Invalid,2"
+		request.HasHeaderRow = false
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		diagnostics := result.JoinDiagnostics
+		So(diagnostics, ShouldNotBeNil)
+		So(diagnostics.MatchedRowCount, ShouldEqual, 2)
+		So(diagnostics.UnmatchedRowCount, ShouldEqual, 1)
+		So(diagnostics.UnmatchedRowSample, ShouldResemble, []string{"MyUnknownID"})
+		So(diagnostics.FeaturesWithoutDataCount, ShouldBeGreaterThan, 300)
+		So(len(diagnostics.FeaturesWithoutDataSample), ShouldEqual, 10)
+		So(diagnostics.FeaturesWithoutDataSample, ShouldNotContain, "S12000013")
+		So(diagnostics.FeaturesWithoutDataSample[0], ShouldEqual, "E06000001")
+	})
+
+}
+
+func TestAnalyseDataNormalisesIDs(t *testing.T) {
+	Convey("Given data whose ids differ from the topology's in case and whitespace", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.CSV = "s12000013 ,Eilean Siar (Western Isles),1\nS12000023,Orkney Islands,2"
+		request.HasHeaderRow = false
+
+		Convey("The rows should not match the topology by default", func() {
+			result, err := analyser.AnalyseData(request)
+			So(err, ShouldBeNil)
+			So(result.JoinDiagnostics.UnmatchedRowCount, ShouldEqual, 1)
+		})
+
+		Convey("The rows should match the topology, with the topology's ids, when ids are normalised", func() {
+			request.Geography.NormaliseIDs = true
+			result, err := analyser.AnalyseData(request)
+			So(err, ShouldBeNil)
+			So(result.JoinDiagnostics.MatchedRowCount, ShouldEqual, 2)
+			So(result.JoinDiagnostics.UnmatchedRowCount, ShouldEqual, 0)
+			So(result.Data[0].ID, ShouldEqual, "S12000013")
+		})
+	})
+}
+
+func TestAnalyseDataSuggestsPalettes(t *testing.T) {
+	Convey("AnalyseData should suggest sequential palettes when the data does not span the reference value", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(len(result.SuggestedPalettes), ShouldBeGreaterThan, 0)
+		for _, p := range result.SuggestedPalettes {
+			So(p.Type, ShouldEqual, analyser.PaletteTypeSequential)
+			So(len(p.Colours), ShouldEqual, result.BestFitClassCount)
+		}
+		So(result.SuggestedPalettes[0].Name, ShouldEqual, "Blues")
+		So(result.SuggestedPalettes[0].Colours[0], ShouldEqual, "#f7fbff")
+		So(result.SuggestedPalettes[0].Colours[result.BestFitClassCount-1], ShouldEqual, "#08306b")
+	})
+
+	Convey("AnalyseData should suggest diverging palettes when the data spans the reference value", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref := 13.0
+		request.ReferenceValue = &ref
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(len(result.SuggestedPalettes), ShouldBeGreaterThan, 0)
+		for _, p := range result.SuggestedPalettes {
+			So(p.Type, ShouldEqual, analyser.PaletteTypeDiverging)
+			So(len(p.Colours), ShouldEqual, result.BestFitClassCount)
+		}
+		So(result.SuggestedPalettes[0].Colours[2], ShouldEqual, "#f7f7f7") // the middle colour of an odd number of classes is neutral
+	})
+
+}
+
+func TestAnalyseDataReturnsHistogram(t *testing.T) {
+	Convey("AnalyseData should return a histogram with 10 bins by default", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(len(result.Histogram), ShouldEqual, 10)
+		So(result.Histogram[0].LowerBound, ShouldEqual, result.MinValue)
+		So(result.Histogram[9].UpperBound, ShouldEqual, result.MaxValue)
+		total := 0
+		for _, b := range result.Histogram {
+			total += b.Count
+		}
+		So(total, ShouldEqual, len(result.Data))
+	})
+
+	Convey("AnalyseData should return a histogram with the requested number of bins", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.CSV = "S12000013,a,1\nS12000023,b,2\nS12000027,c,2\nS12000033,d,3"
+		request.HasHeaderRow = false
+		request.HistogramBins = 2
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(len(result.Histogram), ShouldEqual, 2)
+		So(*result.Histogram[0], ShouldResemble, models.HistogramBucket{LowerBound: 1, UpperBound: 2, Count: 1})
+		So(*result.Histogram[1], ShouldResemble, models.HistogramBucket{LowerBound: 2, UpperBound: 3, Count: 3})
+	})
+
+}
+
+func TestAnalyseDataRoundsStatistics(t *testing.T) {
+	Convey("AnalyseData should round the statistics to the requested number of decimal places", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.CSV = "S12000013,a,1.123\nS12000023,b,2.5\nS12000027,c,2.5"
+		request.HasHeaderRow = false
+		decimalPlaces := 1
+		request.DecimalPlaces = &decimalPlaces
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(result.MinValue, ShouldEqual, 1.1)
+		So(result.MaxValue, ShouldEqual, 2.5)
+		So(result.Mean, ShouldEqual, 2.0)
+		So(result.StandardDeviation, ShouldEqual, 0.6)
+	})
+
+	Convey("AnalyseData should return the units given in the request", t, func() {
+		request, err := models.CreateAnalyseRequest(bytes.NewReader(testdata.LoadExampleAnalyseRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Units = "percentage points"
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(result.Units, ShouldEqual, "percentage points")
+	})
+
+}
+
+func TestAnalyseDataWithLogClassification(t *testing.T) {
+	Convey("AnalyseData should return breaks evenly spaced on a log scale", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.CSV = "S12000013,a,1\nS12000023,b,10\nS12000027,c,100\nS12000033,d,1000"
+		request.HasHeaderRow = false
+		request.Classification = models.ClassificationLog
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(len(result.Breaks), ShouldEqual, 3)
+		So(result.Breaks[1], ShouldResemble, []float64{1.0, 10.0, 100.0})
+		So(len(filterMessages(result, "warn")), ShouldEqual, 0)
+	})
+
+	Convey("AnalyseData should fall back to natural breaks when values are not positive", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Classification = models.ClassificationLog
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(result.Breaks[0], ShouldResemble, []float64{0.0, 22.0})
+		warnings := filterMessages(result, "warn")
+		So(len(warnings), ShouldEqual, 2)
+		So(warnings[1].Text, ShouldContainSubstring, "Log classification requires every value to be positive")
+	})
+
+}
+
+func TestAnalyseDataWithStdDevClassification(t *testing.T) {
+	Convey("AnalyseData should return breaks at standard deviations from the mean, with diverging palettes centred on the mean", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.CSV = "S12000013,a,2\nS12000023,b,4\nS12000027,c,4\nS12000033,d,4\nS12000034,e,5\nS12000035,f,5\nS12000036,g,7\nS12000038,h,9"
+		request.HasHeaderRow = false
+		request.Classification = models.ClassificationStdDev
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(result.Mean, ShouldEqual, 5.0)
+		So(result.StandardDeviation, ShouldEqual, 2.0)
+		So(result.Breaks, ShouldResemble, [][]float64{{2.0, 3.0, 4.0, 6.0, 7.0}})
+		So(result.BestFitClassCount, ShouldEqual, 5)
+		So(len(result.SuggestedPalettes), ShouldEqual, 3)
+		So(result.SuggestedPalettes[0].Type, ShouldEqual, analyser.PaletteTypeDiverging)
+		So(result.SuggestedPalettes[0].Colours, ShouldHaveLength, 5)
+		So(result.SuggestedPalettes[0].Colours[2], ShouldEqual, "#f7f7f7") // the class centred on the mean
+	})
+
+}
+
+func TestAnalyseDataWithHeadTailClassification(t *testing.T) {
+	Convey("AnalyseData should divide heavy-tailed data at the mean of each head, suggesting the resulting number of classes", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.CSV = "S12000005,a,1\nS12000006,b,1\nS12000008,c,1\nS12000010,d,1\nS12000011,e,1\nS12000013,f,1\nS12000014,g,1\nS12000015,h,20\nS12000017,i,30\nS12000018,j,70"
+		request.HasHeaderRow = false
+		request.Classification = models.ClassificationHeadTail
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(result.Breaks, ShouldResemble, [][]float64{{0.0, 10.0, 40.0}})
+		So(result.BestFitClassCount, ShouldEqual, 3)
+		So(result.SuggestedPalettes[0].Colours, ShouldHaveLength, 3)
+	})
+
+}
+
 func TestAnalyseDataShouldReturnErrorWhenUnableToParse(t *testing.T) {
 	Convey("AnalyseData should return an error message and no data when unable to parse csv", t, func() {
 
@@ -80,7 +349,7 @@ func TestAnalyseDataShouldReturnErrorWhenNoRowsHaveData(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		request.CSV = "S12000013,Eilean Siar (Western Isles),a\nS12000023,Orkney Islands,b"
+		request.CSV = "S12000013,Eilean Siar (Western Isles),\nS12000023,Orkney Islands, "
 		request.HasHeaderRow = false
 
 		result, err := analyser.AnalyseData(request)
@@ -94,6 +363,73 @@ func TestAnalyseDataShouldReturnErrorWhenNoRowsHaveData(t *testing.T) {
 
 }
 
+func TestAnalyseDataDetectsCategoricalData(t *testing.T) {
+	Convey("AnalyseData should return categories with frequencies when no rows have numeric values", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.CSV = "S12000013,Eilean Siar (Western Isles),Rural\nS12000023,Orkney Islands,Rural\nS12000027,Shetland Islands,Urban\nS12000033,Aberdeen City,"
+		request.HasHeaderRow = false
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(result.DataType, ShouldEqual, analyser.DataTypeCategorical)
+		So(len(result.Data), ShouldEqual, 3)
+		So(result.Data[0].Category, ShouldEqual, "Rural")
+		So(len(result.Categories), ShouldEqual, 2)
+		So(*result.Categories[0], ShouldResemble, models.Category{Value: "Rural", Count: 2, Colour: "#8dd3c7"})
+		So(*result.Categories[1], ShouldResemble, models.Category{Value: "Urban", Count: 1, Colour: "#ffffb3"})
+		So(result.Breaks, ShouldBeNil)
+
+		warnings := filterMessages(result, "warn")
+		So(len(warnings), ShouldEqual, 1)
+		So(warnings[0].Text, ShouldContainSubstring, "S12000033")
+		So(warnings[0].Text, ShouldNotContainSubstring, "S12000013")
+	})
+
+	Convey("AnalyseData should identify categorical data in a file with rows that have too few columns", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.CSV = "S12000013,Eilean Siar (Western Isles),Rural\nS12000023\nS12000027,Shetland Islands,Urban\nS12000033,Aberdeen City"
+		request.HasHeaderRow = false
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(result.DataType, ShouldEqual, analyser.DataTypeCategorical)
+		So(len(result.Data), ShouldEqual, 2)
+		So(len(result.Categories), ShouldEqual, 2)
+
+		warnings := filterMessages(result, "warn")
+		So(len(warnings), ShouldEqual, 1)
+		So(warnings[0].Text, ShouldContainSubstring, "2 rows have missing columns")
+	})
+
+	Convey("AnalyseData should identify numeric data", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, err := models.CreateAnalyseRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := analyser.AnalyseData(request)
+
+		So(err, ShouldBeNil)
+		So(result.DataType, ShouldEqual, analyser.DataTypeNumeric)
+		So(result.Categories, ShouldBeNil)
+	})
+
+}
+
 func TestAnalyseDataShouldReturnErrorWhenDataDoesNotMatchTopology(t *testing.T) {
 	Convey("AnalyseData should return an error message and no data when no rows have ids that match the topology", t, func() {
 
@@ -121,7 +457,7 @@ func TestAnalyseDataShouldReturnErrorWhenDataDoesNotMatchTopology(t *testing.T)
 		if err != nil {
 			t.Fatal(err)
 		}
-		request.Geography.IDProperty = "no such property"
+		request.Geography.IDProperty = models.PropertyNames{"no such property"}
 
 		result, err := analyser.AnalyseData(request)
 
@@ -232,3 +568,26 @@ func filterMessages(response *models.AnalyseResponse, level string) []*models.Me
 	}
 	return m
 }
+
+func TestSymmetricBreaks(t *testing.T) {
+	Convey("SymmetricBreaks should return equal width breaks either side of zero", t, func() {
+		breaks, upperBound := analyser.SymmetricBreaks([]float64{-4, 0.5, 7})
+
+		So(len(breaks), ShouldEqual, 6)
+		lowerBounds := make([]float64, len(breaks))
+		for i, b := range breaks {
+			lowerBounds[i] = b.LowerBound
+			So(b.Colour, ShouldStartWith, "#")
+		}
+		So(lowerBounds, ShouldResemble, []float64{-7.5, -5, -2.5, 0, 2.5, 5})
+		So(upperBound, ShouldEqual, 7.5)
+		So(breaks[0].Colour, ShouldNotEqual, breaks[5].Colour)
+	})
+
+	Convey("SymmetricBreaks should return breaks when there is no difference", t, func() {
+		breaks, upperBound := analyser.SymmetricBreaks([]float64{0, 0})
+
+		So(breaks[0].LowerBound, ShouldEqual, -3)
+		So(upperBound, ShouldEqual, 3)
+	})
+}
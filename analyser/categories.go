@@ -0,0 +1,36 @@
+package analyser
+
+import (
+	"sort"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// qualitativePalette is used to propose a colour for each category.
+// Colours are taken from the ColorBrewer 12-class Set3 scheme - see http://colorbrewer2.org
+var qualitativePalette = []string{"#8dd3c7", "#ffffb3", "#bebada", "#fb8072", "#80b1d3", "#fdb462", "#b3de69", "#fccde5", "#d9d9d9", "#bc80bd", "#ccebc5", "#ffed6f"}
+
+// analyseCategories counts the frequency of each distinct category in the rows, proposing a colour for each.
+// Categories are ordered by descending frequency (then alphabetically); colours are reused if there are more categories than colours in the palette.
+func analyseCategories(rows []*models.DataRow, messages []*models.Message, diagnostics *models.JoinDiagnostics) *models.AnalyseResponse {
+	counts := make(map[string]int)
+	for _, row := range rows {
+		counts[row.Category]++
+	}
+
+	categories := make([]*models.Category, 0, len(counts))
+	for value, count := range counts {
+		categories = append(categories, &models.Category{Value: value, Count: count})
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Count == categories[j].Count {
+			return categories[i].Value < categories[j].Value
+		}
+		return categories[i].Count > categories[j].Count
+	})
+	for i, c := range categories {
+		c.Colour = qualitativePalette[i%len(qualitativePalette)]
+	}
+
+	return &models.AnalyseResponse{DataType: DataTypeCategorical, Data: rows, Messages: messages, Categories: categories, JoinDiagnostics: diagnostics}
+}
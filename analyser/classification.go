@@ -0,0 +1,92 @@
+package analyser
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// headTailThreshold is the largest proportion of the values that may be in the head for head/tail breaks to continue dividing it
+const headTailThreshold = 0.4
+
+// stdDevMultiples are the number of standard deviations from the mean of the boundaries between the classes of a standard deviation classification -
+// giving up to 7 classes, with the middle class centred on the mean
+var stdDevMultiples = []float64{-2, -1, -0.5, 0.5, 1, 2}
+
+// logBreaks returns the lower bounds of classes evenly spaced on a logarithmic scale between the minimum and maximum of the (sorted, positive) values,
+// for every number of classes between 2 and maxClasses (limited to the number of distinct values) - in the same form as jenks.AllNaturalBreaks
+func logBreaks(sorted []float64, maxClasses int) [][]float64 {
+	distinct := 1
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] != sorted[i-1] {
+			distinct++
+		}
+	}
+	if maxClasses > distinct {
+		maxClasses = distinct
+	}
+
+	logMin, logMax := math.Log(sorted[0]), math.Log(sorted[len(sorted)-1])
+	allBreaks := [][]float64{}
+	for n := 2; n <= maxClasses; n++ {
+		breaks := make([]float64, n)
+		breaks[0] = sorted[0]
+		for i := 1; i < n; i++ {
+			breaks[i] = math.Exp(logMin + float64(i)*(logMax-logMin)/float64(n))
+		}
+		allBreaks = append(allBreaks, breaks)
+	}
+	return allBreaks
+}
+
+// stdDevBreaks returns the lower bounds of the classes of a standard deviation classification of the (sorted) values -
+// the minimum value followed by each boundary of stdDevMultiples that falls between the minimum and maximum values.
+// Also returns the index (of the 7 possible classes) of the first class, i.e. the number of classes below the minimum value.
+func stdDevBreaks(sorted []float64, mean float64, stdDev float64) ([]float64, int) {
+	minValue, maxValue := sorted[0], sorted[len(sorted)-1]
+	breaks := []float64{minValue}
+	firstClass := 0
+	for _, m := range stdDevMultiples {
+		bound := mean + m*stdDev
+		if bound <= minValue {
+			firstClass++
+		} else if bound < maxValue {
+			breaks = append(breaks, bound)
+		}
+	}
+	return breaks, firstClass
+}
+
+// stdDevPalettes returns the diverging palettes with a colour for each of classCount classes of a standard deviation classification,
+// starting at firstClass - so that the middle colour of each palette is always used for the class centred on the mean
+func stdDevPalettes(firstClass int, classCount int) []*models.Palette {
+	palettes := make([]*models.Palette, len(divergingPalettes))
+	for i, d := range divergingPalettes {
+		colours := interpolateColours(d.stops, len(stdDevMultiples)+1)
+		palettes[i] = &models.Palette{Name: d.name, Type: PaletteTypeDiverging, Colours: colours[firstClass : firstClass+classCount]}
+	}
+	return palettes
+}
+
+// headTailBreaks returns the lower bounds of the classes of a head/tail breaks classification of the (sorted) values, for heavy-tailed data:
+// the values are divided at their mean into a head (the values at or above the mean) and a tail, and the head is divided again in the same way
+// while it contains no more than headTailThreshold of the values being divided - up to maxClasses classes.
+// See https://en.wikipedia.org/wiki/Head/tail_breaks
+func headTailBreaks(sorted []float64, maxClasses int) []float64 {
+	breaks := []float64{sorted[0]}
+	head := sorted
+	for len(breaks) < maxClasses {
+		mean := sum(head) / float64(len(head))
+		next := head[sort.SearchFloat64s(head, mean):]
+		if len(next) == 0 || len(next) == len(head) {
+			break
+		}
+		if len(breaks) > 1 && float64(len(next))/float64(len(head)) > headTailThreshold {
+			break // the first division is always made, so there are at least two classes
+		}
+		breaks = append(breaks, mean)
+		head = next
+	}
+	return breaks
+}
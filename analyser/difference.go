@@ -0,0 +1,45 @@
+package analyser
+
+import (
+	"math"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// differenceClassesPerSide is the number of classes either side of zero in the breaks returned by SymmetricBreaks
+const differenceClassesPerSide = 3
+
+// niceSteps are the multiples of a power of ten that class widths are rounded up to
+var niceSteps = []float64{1, 2, 2.5, 5, 10}
+
+// SymmetricBreaks returns breaks for a diverging choropleth of values either side of zero (e.g. the difference between two series):
+// an equal number of classes of equal width below and above zero, extending to at least the largest absolute value, coloured with a diverging palette.
+// Also returns the upper bound of the highest break.
+func SymmetricBreaks(values []float64) ([]*models.ChoroplethBreak, float64) {
+	max := 0.0
+	for _, v := range values {
+		max = math.Max(max, math.Abs(v))
+	}
+	width := niceCeiling(max / differenceClassesPerSide)
+
+	colours := interpolateColours(divergingPalettes[0].stops, differenceClassesPerSide*2)
+	breaks := make([]*models.ChoroplethBreak, differenceClassesPerSide*2)
+	for i := range breaks {
+		breaks[i] = &models.ChoroplethBreak{LowerBound: float64(i-differenceClassesPerSide) * width, Colour: colours[i]}
+	}
+	return breaks, differenceClassesPerSide * width
+}
+
+// niceCeiling rounds the value up to the nearest 1, 2, 2.5 or 5 times a power of ten. Returns 1 for values that are not positive.
+func niceCeiling(value float64) float64 {
+	if value <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(value)))
+	for _, step := range niceSteps {
+		if step*magnitude >= value {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
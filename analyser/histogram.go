@@ -0,0 +1,38 @@
+package analyser
+
+import (
+	"sort"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// defaultHistogramBins is the number of bins used when the request does not specify a bin count. Requests with more than 100 bins are rejected by ValidateAnalyseRequest
+const defaultHistogramBins = 10
+
+// histogram divides the range of the (sorted) values into binCount bins of equal width and counts the values in each.
+// Each bin includes its lower bound and excludes its upper bound, except for the last bin, which includes both.
+func histogram(values []float64, binCount int) []*models.HistogramBucket {
+	if binCount <= 0 {
+		binCount = defaultHistogramBins
+	}
+	minValue, maxValue := values[0], values[len(values)-1]
+	if minValue == maxValue {
+		return []*models.HistogramBucket{{LowerBound: minValue, UpperBound: maxValue, Count: len(values)}}
+	}
+
+	width := (maxValue - minValue) / float64(binCount)
+	buckets := make([]*models.HistogramBucket, binCount)
+	start := 0
+	for i := range buckets {
+		lower := minValue + (float64(i) * width)
+		upper := minValue + (float64(i+1) * width)
+		end := sort.SearchFloat64s(values, upper)
+		if i == binCount-1 {
+			upper = maxValue
+			end = len(values)
+		}
+		buckets[i] = &models.HistogramBucket{LowerBound: lower, UpperBound: upper, Count: end - start}
+		start = end
+	}
+	return buckets
+}
@@ -0,0 +1,86 @@
+package analyser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// The types of palette that may be suggested
+const (
+	PaletteTypeSequential = "sequential"
+	PaletteTypeDiverging  = "diverging"
+)
+
+// paletteDefinition defines a palette by a list of colour stops, from which any number of colours can be interpolated
+type paletteDefinition struct {
+	name  string
+	stops []string
+}
+
+// sequentialPalettes are suitable for data that progresses from low to high.
+// Colours are taken from the ColorBrewer 9-class schemes - see http://colorbrewer2.org
+var sequentialPalettes = []*paletteDefinition{
+	{name: "Blues", stops: []string{"#f7fbff", "#deebf7", "#c6dbef", "#9ecae1", "#6baed6", "#4292c6", "#2171b5", "#08519c", "#08306b"}},
+	{name: "Greens", stops: []string{"#f7fcf5", "#e5f5e0", "#c7e9c0", "#a1d99b", "#74c476", "#41ab5d", "#238b45", "#006d2c", "#00441b"}},
+	{name: "Purples", stops: []string{"#fcfbfd", "#efedf5", "#dadaeb", "#bcbddc", "#9e9ac8", "#807dba", "#6a51a3", "#54278f", "#3f007d"}},
+	{name: "YlOrRd", stops: []string{"#ffffcc", "#ffeda0", "#fed976", "#feb24c", "#fd8d3c", "#fc4e2a", "#e31a1c", "#bd0026", "#800026"}},
+}
+
+// divergingPalettes are suitable for data that diverges either side of a critical mid-point (such as zero or an average).
+// Colours are taken from the ColorBrewer 11-class schemes - see http://colorbrewer2.org
+var divergingPalettes = []*paletteDefinition{
+	{name: "RdBu", stops: []string{"#67001f", "#b2182b", "#d6604d", "#f4a582", "#fddbc7", "#f7f7f7", "#d1e5f0", "#92c5de", "#4393c3", "#2166ac", "#053061"}},
+	{name: "PuOr", stops: []string{"#7f3b08", "#b35806", "#e08214", "#fdb863", "#fee0b6", "#f7f7f7", "#d8daeb", "#b2abd2", "#8073ac", "#542788", "#2d004b"}},
+	{name: "BrBG", stops: []string{"#543005", "#8c510a", "#bf812d", "#dfc27d", "#f6e8c3", "#f5f5f5", "#c7eae5", "#80cdc1", "#35978f", "#01665e", "#003c30"}},
+}
+
+// suggestPalettes returns a list of palettes with the given number of colours. Diverging palettes are returned if the values
+// span the reference value (i.e. minValue < referenceValue < maxValue), sequential palettes otherwise.
+func suggestPalettes(minValue float64, maxValue float64, referenceValue float64, classCount int) []*models.Palette {
+	if classCount <= 0 {
+		return []*models.Palette{}
+	}
+	paletteType, definitions := PaletteTypeSequential, sequentialPalettes
+	if minValue < referenceValue && referenceValue < maxValue {
+		paletteType, definitions = PaletteTypeDiverging, divergingPalettes
+	}
+	palettes := make([]*models.Palette, len(definitions))
+	for i, d := range definitions {
+		palettes[i] = &models.Palette{Name: d.name, Type: paletteType, Colours: interpolateColours(d.stops, classCount)}
+	}
+	return palettes
+}
+
+// interpolateColours returns n colours evenly spaced along the given stops, interpolating between stops where necessary.
+func interpolateColours(stops []string, n int) []string {
+	if n == 1 {
+		return []string{stops[len(stops)/2]}
+	}
+	colours := make([]string, n)
+	for i := 0; i < n; i++ {
+		pos := float64(i) * float64(len(stops)-1) / float64(n-1)
+		lower := int(math.Floor(pos))
+		upper := int(math.Min(float64(lower+1), float64(len(stops)-1)))
+		colours[i] = blend(stops[lower], stops[upper], pos-float64(lower))
+	}
+	return colours
+}
+
+// blend returns the hex colour that is the given proportion of the way from colour a to colour b
+func blend(a string, b string, proportion float64) string {
+	ra, ga, ba := parseHex(a)
+	rb, gb, bb := parseHex(b)
+	mix := func(x, y int) int {
+		return int(math.Floor(float64(x) + (float64(y-x) * proportion) + 0.5))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", mix(ra, rb), mix(ga, gb), mix(ba, bb))
+}
+
+// parseHex converts a colour in the format #rrggbb into its red, green and blue components
+func parseHex(colour string) (int, int, int) {
+	v, _ := strconv.ParseUint(colour[1:], 16, 32)
+	return int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)
+}
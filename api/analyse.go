@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"encoding/json"
 
@@ -12,40 +13,48 @@ import (
 
 func (api *RendererAPI) analyseData(w http.ResponseWriter, r *http.Request) {
 
-	log.Debug("analyseData", log.Data{"headers": r.Header})
+	start := time.Now()
 	request, err := models.CreateAnalyseRequest(r.Body)
 	if err != nil {
-		log.Error(err, nil)
+		log.ErrorR(r, err, nil)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if _, err = api.fetchTopology(r.Context(), request.Geography); err != nil {
+		log.ErrorR(r, err, nil)
+		setErrorCode(w, err)
+		return
+	}
+
 	if err = request.ValidateAnalyseRequest(); err != nil {
-		log.Error(err, log.Data{"_message": "AnalyseRequest failed validation"})
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.ErrorR(r, err, log.Data{"_message": "AnalyseRequest failed validation"})
+		writeValidationError(w, err)
 		return
 	}
 
 	response, err := analyser.AnalyseData(request)
 	if err != nil {
-		log.Error(err, log.Data{"_message": "Unable to Analyse request"})
+		log.ErrorR(r, err, log.Data{"_message": "Unable to Analyse request"})
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	bytes, err := json.Marshal(response)
 	if err != nil {
-		log.Error(err, log.Data{"_message": "Unable to marshal response"})
+		log.ErrorR(r, err, log.Data{"_message": "Unable to marshal response"})
 		setErrorCode(w, err)
 		return
 	}
 
+	log.InfoR(r, "data analysed", log.Data{"data_type": response.DataType, "data_rows": len(response.Data), "topology_arcs": len(request.Geography.Topojson.Arcs), "duration": time.Since(start).String()})
+
 	setContentType(w, "application/json")
 
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(bytes)
 	if err != nil {
-		log.Error(err, log.Data{})
+		log.ErrorR(r, err, log.Data{})
 		setErrorCode(w, err)
 		return
 	}
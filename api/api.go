@@ -2,8 +2,18 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 
+	"github.com/ONSdigital/dp-map-renderer/config"
+	"github.com/ONSdigital/dp-map-renderer/dataset"
 	"github.com/ONSdigital/dp-map-renderer/health"
+	"github.com/ONSdigital/dp-map-renderer/storage"
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
+	"github.com/ONSdigital/go-ns/handlers/requestID"
 	"github.com/ONSdigital/go-ns/log"
 	"github.com/ONSdigital/go-ns/server"
 	"github.com/gorilla/handlers"
@@ -14,55 +24,220 @@ import (
 
 var httpServer *server.Server
 
+// requestIDSize is the length of the X-Request-Id generated for requests that do not have one
+const requestIDSize = 16
+
+const requestIDHeader = "X-Request-Id"
+
+// renderCacheSize is the maximum number of rendered responses held in the cache
+var renderCacheSize = 100
+
+// authKeys are the api keys required to use the render and analyse routes. If empty, no key is required
+var authKeys map[string]*apiKey
+
+// rateLimit is the default number of requests per minute allowed from each client, and rateLimitBurst the number of requests allowed in a burst. A rateLimit of zero disables rate limiting
+var (
+	rateLimit         = 0
+	rateLimitBurst    = 10
+	trustForwardedFor = false
+)
+
+// maxRequestSize is the maximum size (in bytes) of a request body
+var maxRequestSize int64 = 50 * 1024 * 1024
+
 // RendererAPI manages rendering tables from json
 type RendererAPI struct {
 	router *mux.Router
+	cache  *renderCache
+	keys   map[string]*apiKey
+
+	fetcher  *topoutil.Fetcher
+	datasets *dataset.Client
+	store    *storage.S3Store
+
+	limiter           *rateLimiter
+	trustForwardedFor bool
 }
 
 // CreateRendererAPI manages all the routes configured to the renderer
-func CreateRendererAPI(bindAddr string, allowedOrigins string, errorChan chan error) {
+func CreateRendererAPI(cfg *config.Config, errorChan chan error) {
+	renderCacheSize = cfg.RenderCacheSize
+	maxRequestSize = cfg.MaxRequestSize
+	rateLimit = cfg.RateLimit
+	rateLimitBurst = cfg.RateLimitBurst
+	trustForwardedFor = cfg.RateLimitTrustForwardedFor
+	if len(cfg.TopojsonURLHosts) > 0 {
+		topologyFetcher = topoutil.NewFetcher(cfg.TopojsonURLHosts, cfg.TopojsonURLTimeout, cfg.TopojsonURLMaxSize, cfg.TopojsonCacheSize)
+	}
+	if len(cfg.DatasetAPIURL) > 0 {
+		datasetClient = dataset.NewClient(cfg.DatasetAPIURL, cfg.DatasetAPITimeout)
+	}
+	if len(cfg.S3Bucket) > 0 {
+		credentials := storage.Credentials{AccessKeyID: cfg.AWSAccessKeyID, SecretAccessKey: cfg.AWSSecretAccessKey, SessionToken: cfg.AWSSessionToken}
+		objectStore = storage.NewS3Store(cfg.S3Bucket, cfg.S3Region, cfg.S3Endpoint, cfg.S3KeyPrefix, credentials, cfg.S3Timeout)
+	}
+
+	keys, err := parseAPIKeys(cfg.APIKeys)
+	if err != nil {
+		log.ErrorC("Main", err, log.Data{"MethodInError": "parseAPIKeys()"})
+		errorChan <- err
+		return
+	}
+	authKeys = keys
+
 	router := mux.NewRouter()
-	routes(router)
+	api := routes(router)
 
-	httpServer = server.New(bindAddr, createCORSHandler(allowedOrigins, router))
+	httpServer = server.New(cfg.BindAddr, createCORSHandler(cfg.CORSAllowedOrigins, router))
+	// rendering a detailed topology can take many seconds, so the write timeout must allow for the slowest render
+	httpServer.ReadTimeout = cfg.ReadTimeout
+	httpServer.WriteTimeout = cfg.WriteTimeout
+	httpServer.IdleTimeout = cfg.IdleTimeout
 	// Disable this here to allow main to manage graceful shutdown of the entire app.
 	httpServer.HandleOSSignals = false
+	// request ids and request logging are added by the router (see routes), so remove the server's default middleware to avoid logging each request twice
+	httpServer.MiddlewareOrder = []string{}
+
+	if cfg.TLSEnabled() {
+		tlsConfig, err := createTLSConfig(cfg.TLSClientCAFile)
+		if err != nil {
+			log.ErrorC("Main", err, log.Data{"MethodInError": "createTLSConfig()", "client_ca_file": cfg.TLSClientCAFile})
+			errorChan <- err
+			return
+		}
+		// serving with a cert and key enables HTTP/2 (negotiated using ALPN)
+		httpServer.TLSConfig = tlsConfig
+		httpServer.CertFile = cfg.TLSCertFile
+		httpServer.KeyFile = cfg.TLSKeyFile
+	}
 
 	go func() {
-		log.Debug("Starting map renderer...", nil)
-		if err := httpServer.ListenAndServe(); err != nil {
+		log.Debug("Starting map renderer...", log.Data{"bind_addr": cfg.BindAddr, "tls": cfg.TLSEnabled()})
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.ErrorC("Main", err, log.Data{"MethodInError": "httpServer.ListenAndServe()"})
 			errorChan <- err
 		}
 	}()
+
+	if len(cfg.GRPCBindAddr) > 0 {
+		createGRPCServer(cfg, api, errorChan)
+	}
+	if cfg.KafkaEnabled() {
+		createRenderWorker(cfg, api, errorChan)
+	}
+}
+
+// createTLSConfig creates the tls config for the server, supporting HTTP/2. If clientCAFile is given, clients must present a certificate signed by one of the CAs in that (PEM) file.
+func createTLSConfig(clientCAFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if len(clientCAFile) == 0 {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in client CA file %s", clientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
 }
 
 // createCORSHandler wraps the router in a CORS handler that responds to OPTIONS requests and returns the headers necessary to allow CORS-enabled clients to work
 func createCORSHandler(allowedOrigins string, router *mux.Router) http.Handler {
-	headersOk := handlers.AllowedHeaders([]string{"Accept", "Content-Type", "Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "X-Requested-With"})
+	headersOk := handlers.AllowedHeaders([]string{"Accept", "Content-Type", "Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "X-Requested-With", "If-None-Match", requestIDHeader, apiKeyHeader, authorizationHeader, tracing.TraceparentHeader})
 	originsOk := handlers.AllowedOrigins([]string{allowedOrigins})
 	methodsOk := handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS"})
+	exposedOk := handlers.ExposedHeaders([]string{joinMatchedRowsHeader, joinUnmatchedRowsHeader, joinFeaturesWithoutDataHeader, joinUnmatchedRowSampleHeader, objectURLHeader})
 
-	return handlers.CORS(originsOk, headersOk, methodsOk)(router)
+	return handlers.CORS(originsOk, headersOk, methodsOk, exposedOk)(router)
 }
 
 // routes contain all endpoints for the renderer
 func routes(router *mux.Router) *RendererAPI {
-	api := RendererAPI{router: router}
+	api := RendererAPI{
+		router:            router,
+		cache:             newRenderCache(renderCacheSize, sharedCache),
+		keys:              authKeys,
+		fetcher:           topologyFetcher,
+		datasets:          datasetClient,
+		store:             objectStore,
+		limiter:           newRateLimiter(rateLimit, rateLimitBurst),
+		trustForwardedFor: trustForwardedFor,
+	}
+
+	// compress responses (rendered html can be several MB) for clients that send an appropriate Accept-Encoding header
+	router.Use(handlers.CompressHandler)
+	// ensure every request has an X-Request-Id, which is returned in the response and included as the context of every log event for the request
+	router.Use(requestID.Handler(requestIDSize))
+	router.Use(returnRequestID)
+	router.Use(log.Handler)
+	router.Use(tracing.Middleware)
 
 	router.Path("/healthcheck").Methods("GET").HandlerFunc(health.EmptyHealthcheck)
+	router.Path("/health").Methods("GET").HandlerFunc(health.Healthcheck)
 
-	api.router.HandleFunc("/render/{render_type}", api.renderMap).Methods("POST")
-	api.router.HandleFunc("/analyse", api.analyseData).Methods("POST")
+	// rendering is cpu-heavy, so these routes are protected by api keys (if configured) and rate limits
+	api.router.Handle("/render", api.protect(api.renderAcceptableMap)).Methods("POST")
+	api.router.Handle("/render/{render_type}", api.protect(api.renderMap)).Methods("POST")
+	api.router.Handle("/analyse", api.protect(api.analyseData)).Methods("POST")
+	api.router.Handle("/convert/png", api.protect(api.convertPNG)).Methods("POST")
 	return &api
 }
 
-// Close represents the graceful shutting down of the http server
+// protect wraps a handler so that requests are authenticated, then rate limited, and only then is their body read - topojson payloads can be enormous,
+// so those that are too large are rejected before they are decoded, but a client without a valid key (or over its limit) is rejected without reading its body at all
+func (api *RendererAPI) protect(h http.HandlerFunc) http.Handler {
+	return api.authenticate(api.limitRate(limitRequestSize(maxRequestSize)(h).ServeHTTP))
+}
+
+// returnRequestID copies the X-Request-Id request header to the response, so that clients can correlate a response with the service logs
+func returnRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(requestIDHeader, r.Header.Get(requestIDHeader))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Close represents the graceful shutting down of the http server (and the gRPC server, if started).
+// The server stops accepting new requests, then waits for in-flight renders to complete or for ctx to expire.
 func Close(ctx context.Context) error {
+	log.Info("shutting down http server, waiting for in-flight requests to complete", nil)
 	if err := httpServer.Shutdown(ctx); err != nil {
 		return err
 	}
 
 	log.Info("graceful shutdown of http server complete", nil)
+
+	if grpcServer != nil {
+		log.Info("shutting down gRPC server, waiting for in-flight calls to complete", nil)
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+			return ctx.Err()
+		}
+		log.Info("graceful shutdown of gRPC server complete", nil)
+	}
+
+	if renderWorker != nil {
+		log.Info("shutting down map render worker, waiting for the render in progress to complete", nil)
+		if err := renderWorker.stop(ctx); err != nil {
+			return err
+		}
+		log.Info("graceful shutdown of map render worker complete", nil)
+	}
 	return nil
 }
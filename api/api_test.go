@@ -3,25 +3,51 @@ package api
 import (
 	"testing"
 
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	"bytes"
 
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/ONSdigital/dp-map-renderer/dataset"
 	"github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	pb "github.com/ONSdigital/dp-map-renderer/proto"
 	"github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/dp-map-renderer/storage"
 	"github.com/ONSdigital/dp-map-renderer/testdata"
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
 	"github.com/gorilla/mux"
+	"github.com/segmentio/kafka-go"
 	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 var (
 	host          = "http://localhost:80"
 	requestSVGURL = host + "/render/svg"
 	requestPNGURL = host + "/render/png"
+	requestURL    = host + "/render"
 	analyseURL    = host + "/analyse"
+	convertPNGURL = host + "/convert/png"
 )
 
 var saveTestResponse = true
@@ -51,6 +77,212 @@ func TestSuccessfullyRenderSVGMap(t *testing.T) {
 	})
 }
 
+func TestStreamRenderedMap(t *testing.T) {
+	Convey("An html map should be streamed to the response, and not cached when the cache is disabled", t, func() {
+
+		api := routes(mux.NewRouter())
+		api.cache = newRenderCache(0, nil)
+
+		r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "text/html")
+		So(w.Header().Get("ETag"), ShouldNotBeEmpty)
+		So(w.Header().Get(joinMatchedRowsHeader), ShouldNotBeEmpty)
+		So(w.Body.String(), ShouldContainSubstring, "<svg")
+		So(w.Body.String(), ShouldNotContainSubstring, "[CSS Here]")
+		So(api.cache.get(w.Header().Get("ETag")), ShouldBeNil)
+	})
+
+	Convey("A streamed html map should be cached with the same content as was streamed", t, func() {
+
+		api := routes(mux.NewRouter())
+
+		r, err := http.NewRequest("POST", requestPNGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		cached := api.cache.get(w.Header().Get("ETag"))
+		So(cached, ShouldNotBeNil)
+		So(string(cached.body), ShouldEqual, w.Body.String())
+		So(cached.contentType, ShouldEqual, "text/html")
+	})
+}
+
+func TestRenderFailsWhenConversionFails(t *testing.T) {
+	Convey("Given a png converter that fails", t, func() {
+
+		renderer.UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "exit 1"}))
+		defer renderer.UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "cat testdata/fallback.png >> " + geojson2svg.ArgPNGFilename}))
+		api := routes(mux.NewRouter())
+
+		Convey("A png map should be rendered with the svg in place of the image by default", func() {
+			r, err := http.NewRequest("POST", requestPNGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, "<svg")
+		})
+
+		Convey("A request that should fail when the map can't be converted should return an error", func() {
+			body := bytes.Replace(testdata.LoadExampleRequest(t), []byte("{"), []byte(`{"conversion_failure": "fail",`), 1)
+			r, err := http.NewRequest("POST", requestPNGURL, bytes.NewReader(body))
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			So(w.Body.String(), ShouldContainSubstring, conversionFailed)
+			So(w.Header().Get("ETag"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestRenderResponseIsCompressed(t *testing.T) {
+	Convey("Render response should be gzip compressed when the client accepts gzip encoding", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		r, err := http.NewRequest("POST", requestSVGURL, reader)
+		So(err, ShouldBeNil)
+		r.Header.Set("Accept-Encoding", "gzip")
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+
+		gz, err := gzip.NewReader(w.Body)
+		So(err, ShouldBeNil)
+		body, err := ioutil.ReadAll(gz)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldContainSubstring, "<svg")
+	})
+
+	Convey("Render response should not be compressed when the client does not accept compressed encoding", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		r, err := http.NewRequest("POST", requestSVGURL, reader)
+		So(err, ShouldBeNil)
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Encoding"), ShouldEqual, "")
+		So(w.Body.String(), ShouldContainSubstring, "<svg")
+	})
+}
+
+func TestRenderResponseHasETag(t *testing.T) {
+	Convey("Render response should include an ETag, and return 304 Not Modified when the ETag is sent in If-None-Match", t, func() {
+
+		api := routes(mux.NewRouter())
+
+		r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		etag := w.Header().Get("ETag")
+		So(etag, ShouldStartWith, `"`)
+		So(len(etag), ShouldEqual, 66)
+
+		r, err = http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		r.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusNotModified)
+		So(w.Body.Len(), ShouldEqual, 0)
+	})
+
+	Convey("A repeated render request should return the cached response", t, func() {
+
+		api := routes(mux.NewRouter())
+
+		r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		first := httptest.NewRecorder()
+		api.router.ServeHTTP(first, r)
+		So(first.Code, ShouldEqual, http.StatusOK)
+
+		r, err = http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		second := httptest.NewRecorder()
+		api.router.ServeHTTP(second, r)
+		So(second.Code, ShouldEqual, http.StatusOK)
+		So(second.Header().Get("ETag"), ShouldEqual, first.Header().Get("ETag"))
+		So(second.Header().Get("Content-Type"), ShouldEqual, "text/html")
+		So(second.Body.String(), ShouldEqual, first.Body.String())
+	})
+
+	Convey("The ETag should differ between render types", t, func() {
+
+		api := routes(mux.NewRouter())
+
+		r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		svg := httptest.NewRecorder()
+		api.router.ServeHTTP(svg, r)
+
+		r, err = http.NewRequest("POST", requestPNGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		png := httptest.NewRecorder()
+		api.router.ServeHTTP(png, r)
+
+		So(png.Header().Get("ETag"), ShouldNotEqual, svg.Header().Get("ETag"))
+	})
+}
+
+func TestRenderResponseHasJoinHeaders(t *testing.T) {
+	Convey("Render response should report how the data was joined to the map, including when it is cached", t, func() {
+
+		api := routes(mux.NewRouter())
+		render := func(body string) *httptest.ResponseRecorder {
+			r, err := http.NewRequest("POST", requestSVGURL, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			return w
+		}
+		example := string(testdata.LoadExampleRequest(t))
+		unmatched, err := strconv.Atoi(render(example).Header().Get(joinUnmatchedRowsHeader))
+		So(err, ShouldBeNil)
+
+		body := strings.Replace(example, `"data": [`, `"data": [{"id": "A00000001", "value": 1},`, 1)
+		for i := 0; i < 2; i++ {
+			w := render(body)
+			So(w.Header().Get(joinMatchedRowsHeader), ShouldEqual, "373")
+			So(w.Header().Get(joinUnmatchedRowsHeader), ShouldEqual, strconv.Itoa(unmatched+1))
+			So(w.Header().Get(joinUnmatchedRowSampleHeader), ShouldStartWith, "A00000001,")
+			So(w.Header().Get(joinFeaturesWithoutDataHeader), ShouldEqual, "7")
+		}
+	})
+
+	Convey("The json render type should report the join in its metadata", t, func() {
+
+		api := routes(mux.NewRouter())
+		body := strings.Replace(string(testdata.LoadExampleRequest(t)), `"data": [`, `"data": [{"id": "A00000001", "value": 1},`, 1)
+		r, err := http.NewRequest("POST", host+"/render/json", strings.NewReader(body))
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+
+		var response models.RenderResponse
+		So(json.Unmarshal(w.Body.Bytes(), &response), ShouldBeNil)
+		So(response.Metadata.Join.MatchedRowCount, ShouldEqual, 373)
+		So(response.Metadata.Join.UnmatchedRowSample[0], ShouldEqual, "A00000001")
+		So(response.Metadata.Warnings, ShouldHaveLength, 1)
+		So(response.Metadata.Warnings[0], ShouldContainSubstring, "A00000001")
+	})
+}
+
 func TestSuccessfullyRenderPNGMap(t *testing.T) {
 	Convey("Successfully render an html map with png images", t, func() {
 
@@ -74,6 +306,889 @@ func TestSuccessfullyRenderPNGMap(t *testing.T) {
 	})
 }
 
+func TestRenderRawPNGImage(t *testing.T) {
+	Convey("Given a png converter that returns the svg it is given", t, func() {
+
+		renderer.UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename}))
+		defer renderer.UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "cat testdata/fallback.png >> " + geojson2svg.ArgPNGFilename}))
+
+		request, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		render := func() *httptest.ResponseRecorder {
+			body, err := json.Marshal(request)
+			So(err, ShouldBeNil)
+			r, err := http.NewRequest("POST", requestPNGURL, bytes.NewReader(body))
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api := routes(mux.NewRouter())
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("A request for the map returns a png image of the map alone", func() {
+			request.PNGOutput = models.PNGOutputMap
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "image/png")
+			So(w.Body.String(), ShouldStartWith, "<svg")
+			So(w.Body.String(), ShouldNotContainSubstring, "legend")
+		})
+
+		Convey("A request for the map and legend returns a png image of both", func() {
+			request.PNGOutput = models.PNGOutputMapAndLegend
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "image/png")
+			So(w.Body.String(), ShouldStartWith, "<svg")
+			So(w.Body.String(), ShouldContainSubstring, "legend")
+		})
+	})
+}
+
+func TestRenderTopojsonURL(t *testing.T) {
+	Convey("Given a server of the topology of the example request", t, func() {
+		request, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		topology, err := json.Marshal(request.Geography.Topojson)
+		So(err, ShouldBeNil)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(topology)
+		}))
+		defer server.Close()
+		original := request.Geography.Topojson
+		request.Geography.Topojson = nil
+		request.Geography.TopojsonURL = server.URL + "/topology.json"
+		renderWith := func(api *RendererAPI, ifNoneMatch string) *httptest.ResponseRecorder {
+			body, err := json.Marshal(request)
+			So(err, ShouldBeNil)
+			r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(body))
+			So(err, ShouldBeNil)
+			if len(ifNoneMatch) > 0 {
+				r.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+		render := func() *httptest.ResponseRecorder {
+			return renderWith(routes(mux.NewRouter()), "")
+		}
+
+		Convey("A request for a topology on an allowed host renders the fetched topology", func() {
+			topologyFetcher = topoutil.NewFetcher([]string{strings.TrimPrefix(server.URL, "http://")}, time.Second, 0, 10)
+			defer func() { topologyFetcher = nil }()
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, "<path")
+		})
+
+		Convey("A map rendered from a topology that has since changed isn't returned, or reported as not modified", func() {
+			// without a cache of topologies, the topology is fetched for each request
+			topologyFetcher = topoutil.NewFetcher([]string{strings.TrimPrefix(server.URL, "http://")}, time.Second, 0, 0)
+			defer func() { topologyFetcher = nil }()
+			api := routes(mux.NewRouter())
+			first := renderWith(api, "")
+			So(first.Code, ShouldEqual, http.StatusOK)
+			etag := first.Header().Get("ETag")
+			So(renderWith(api, etag).Code, ShouldEqual, http.StatusNotModified)
+
+			// the region of the first geometry is removed from the topology, so its row no longer matches
+			for _, object := range original.Objects {
+				object.Geometries = object.Geometries[1:]
+			}
+			topology, err = json.Marshal(original)
+			So(err, ShouldBeNil)
+
+			w := renderWith(api, etag)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("ETag"), ShouldNotEqual, etag)
+			So(w.Header().Get(joinUnmatchedRowsHeader), ShouldNotEqual, first.Header().Get(joinUnmatchedRowsHeader))
+			So(w.Body.String(), ShouldNotEqual, first.Body.String())
+
+			So(renderWith(api, "").Header().Get("ETag"), ShouldEqual, w.Header().Get("ETag"))
+		})
+
+		Convey("A request for a topology on another host is rejected", func() {
+			topologyFetcher = topoutil.NewFetcher([]string{"cdn.ons.gov.uk"}, time.Second, 0, 10)
+			defer func() { topologyFetcher = nil }()
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, `"field":"geography.topojson_url"`)
+		})
+
+		Convey("A request for a topology is rejected if no hosts are allowed", func() {
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, `"field":"geography.topojson_url"`)
+		})
+
+		Convey("A topology that can't be fetched is a bad gateway", func() {
+			server.Close()
+			topologyFetcher = topoutil.NewFetcher([]string{strings.TrimPrefix(server.URL, "http://")}, time.Second, 0, 10)
+			defer func() { topologyFetcher = nil }()
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusBadGateway)
+		})
+	})
+}
+
+func TestRenderDataset(t *testing.T) {
+	Convey("Given a dataset API with the observations of the example request", t, func() {
+		request, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		var observations []string
+		for _, row := range request.Data {
+			observations = append(observations, fmt.Sprintf(`{"dimensions":{"geography":{"id":%q}},"observation":"%g"}`, row.ID, row.Value))
+		}
+		data := request.Data
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/datasets/population/editions/2019/versions/1/observations" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `{"observations":[%s]}`, strings.Join(observations, ","))
+		}))
+		defer server.Close()
+		datasetClient = dataset.NewClient(server.URL, time.Second)
+		defer func() { datasetClient = nil }()
+		request.Data = nil
+		request.Dataset = &models.Dataset{ID: "population", Edition: "2019", Version: "1", Options: map[string]string{"sex": "all"}}
+		renderWith := func(api *RendererAPI, ifNoneMatch string) *httptest.ResponseRecorder {
+			body, err := json.Marshal(request)
+			So(err, ShouldBeNil)
+			r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(body))
+			So(err, ShouldBeNil)
+			if len(ifNoneMatch) > 0 {
+				r.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+		render := func() *httptest.ResponseRecorder {
+			return renderWith(routes(mux.NewRouter()), "")
+		}
+
+		Convey("The map is rendered with the observations of the dataset, as if they were the request's data", func() {
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			request.Dataset, request.Data = nil, data
+			inline := render()
+			So(inline.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get(joinMatchedRowsHeader), ShouldEqual, inline.Header().Get(joinMatchedRowsHeader))
+			So(w.Header().Get(joinUnmatchedRowsHeader), ShouldEqual, inline.Header().Get(joinUnmatchedRowsHeader))
+		})
+
+		Convey("A map rendered from observations that have since changed isn't returned, or reported as not modified", func() {
+			api := routes(mux.NewRouter())
+			first := renderWith(api, "")
+			So(first.Code, ShouldEqual, http.StatusOK)
+			etag := first.Header().Get("ETag")
+			So(renderWith(api, etag).Code, ShouldEqual, http.StatusNotModified)
+
+			// the first observation is republished as missing, so its region no longer has data
+			observations[0] = fmt.Sprintf(`{"dimensions":{"geography":{"id":%q}},"observation":""}`, data[0].ID)
+
+			w := renderWith(api, etag)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("ETag"), ShouldNotEqual, etag)
+			So(w.Header().Get(joinFeaturesWithoutDataHeader), ShouldNotEqual, first.Header().Get(joinFeaturesWithoutDataHeader))
+			So(w.Body.String(), ShouldNotEqual, first.Body.String())
+
+			So(renderWith(api, "").Header().Get("ETag"), ShouldEqual, w.Header().Get("ETag"))
+		})
+
+		Convey("A request with both data and a dataset is rejected", func() {
+			request.Data = []*models.DataRow{{ID: "E06000001", Value: 1}}
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, `"field":"data"`)
+		})
+
+		Convey("A dataset the API doesn't have is a bad request", func() {
+			request.Dataset.Version = "2"
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, "404")
+		})
+
+		Convey("A dataset is rejected if no dataset API is configured", func() {
+			datasetClient = nil
+			w := render()
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, `"field":"dataset"`)
+		})
+	})
+}
+
+func TestRenderStoredResponse(t *testing.T) {
+	Convey("Given an S3 bucket in which rendered responses are stored", t, func() {
+		stored := map[string][]byte{}
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if status != http.StatusOK {
+				http.Error(w, "<Error><Code>AccessDenied</Code></Error>", status)
+				return
+			}
+			stored[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+		}))
+		defer server.Close()
+		objectStore = storage.NewS3Store("figures", "eu-west-2", server.URL, "maps/", storage.Credentials{}, time.Second)
+		defer func() { objectStore = nil }()
+		api := routes(mux.NewRouter())
+		render := func(url string) *httptest.ResponseRecorder {
+			r, err := http.NewRequest("POST", url, bytes.NewReader(testdata.LoadExampleRequest(t)))
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("An html response is stored, and the url of the object returned", func() {
+			w := render(requestSVGURL)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			objectURL := w.Header().Get(objectURLHeader)
+			So(objectURL, ShouldStartWith, server.URL+"/figures/maps/")
+			So(objectURL, ShouldEndWith, ".html")
+			So(len(stored), ShouldEqual, 1)
+			So(string(stored[strings.TrimPrefix(objectURL, server.URL)]), ShouldEqual, w.Body.String())
+
+			Convey("A cached response isn't stored again, but returns the same url", func() {
+				cached := render(requestSVGURL)
+				So(cached.Code, ShouldEqual, http.StatusOK)
+				So(cached.Header().Get(objectURLHeader), ShouldEqual, objectURL)
+				So(len(stored), ShouldEqual, 1)
+			})
+		})
+
+		Convey("A json response isn't stored", func() {
+			w := render(host + "/render/json")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get(objectURLHeader), ShouldBeEmpty)
+			So(stored, ShouldBeEmpty)
+		})
+
+		Convey("A response that can't be stored fails the request with a 502", func() {
+			status = http.StatusForbidden
+			w := render(requestSVGURL)
+			So(w.Code, ShouldEqual, http.StatusBadGateway)
+			So(w.Body.String(), ShouldContainSubstring, storeFailed)
+		})
+	})
+}
+
+// mapCache is a shared cache held in a map
+type mapCache map[string][]byte
+
+func (c mapCache) Get(key string) ([]byte, error) {
+	return c[key], nil
+}
+
+func (c mapCache) Set(key string, value []byte) error {
+	c[key] = value
+	return nil
+}
+
+func TestRenderSharedCache(t *testing.T) {
+	Convey("Given instances of the service that share a cache", t, func() {
+		shared := mapCache{}
+		UseSharedCache(shared)
+		defer UseSharedCache(nil)
+		render := func(api *RendererAPI) *httptest.ResponseRecorder {
+			r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+		w := render(routes(mux.NewRouter()))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		etag := w.Header().Get("ETag")
+
+		Convey("A rendered response is added to the shared cache", func() {
+			So(shared, ShouldContainKey, sharedRenderPrefix+etag)
+		})
+
+		Convey("Another instance returns the response from the shared cache, with its headers", func() {
+			var response sharedResponse
+			So(json.Unmarshal(shared[sharedRenderPrefix+etag], &response), ShouldBeNil)
+			response.Body = []byte("<figure>shared</figure>")
+			shared[sharedRenderPrefix+etag], _ = json.Marshal(&response)
+
+			other := render(routes(mux.NewRouter()))
+			So(other.Code, ShouldEqual, http.StatusOK)
+			So(other.Body.String(), ShouldEqual, "<figure>shared</figure>")
+			So(other.Header().Get("Content-Type"), ShouldEqual, w.Header().Get("Content-Type"))
+			So(other.Header().Get(joinMatchedRowsHeader), ShouldEqual, w.Header().Get(joinMatchedRowsHeader))
+		})
+	})
+}
+
+func TestKafkaRenderWorker(t *testing.T) {
+	Convey("Given a worker consuming render events, whose maps are stored in an S3 bucket", t, func() {
+		stored := map[string][]byte{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			stored[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+		}))
+		defer server.Close()
+		objectStore = storage.NewS3Store("figures", "eu-west-2", server.URL, "maps/", storage.Credentials{}, time.Second)
+		defer func() { objectStore = nil }()
+
+		reader := &fakeMessageReader{messages: make(chan kafka.Message, 1), commits: make(chan int64, 1)}
+		writer := &fakeMessageWriter{messages: make(chan kafka.Message, 1)}
+		worker := newRenderWorker(routes(mux.NewRouter()), reader, writer)
+		errorChan := make(chan error, 1)
+		worker.start(errorChan)
+		defer func() { So(worker.stop(context.Background()), ShouldBeNil) }()
+
+		render := func(event string) renderedEvent {
+			reader.messages <- kafka.Message{Offset: 42, Value: []byte(event)}
+			var message kafka.Message
+			select {
+			case message = <-writer.messages:
+			case err := <-errorChan:
+				So(err, ShouldBeNil)
+			case <-time.After(10 * time.Second):
+				So("timed out waiting for the rendered event", ShouldBeEmpty)
+			}
+			var rendered renderedEvent
+			So(json.Unmarshal(message.Value, &rendered), ShouldBeNil)
+			So(string(message.Key), ShouldEqual, rendered.ID)
+			// the message is committed once its event has been published
+			So(<-reader.commits, ShouldEqual, 42)
+			return rendered
+		}
+		event := func(id string, renderType string, request []byte) string {
+			b, err := json.Marshal(renderEvent{ID: id, RenderType: renderType, Request: request})
+			So(err, ShouldBeNil)
+			return string(b)
+		}
+
+		Convey("The map of an event is rendered and stored, and a completed event published", func() {
+			rendered := render(event("map-1", "svg", testdata.LoadExampleRequest(t)))
+			So(rendered.ID, ShouldEqual, "map-1")
+			So(rendered.RenderType, ShouldEqual, "svg")
+			So(rendered.Status, ShouldEqual, renderedStatusCompleted)
+			So(rendered.ContentType, ShouldEqual, contentHTML)
+			So(rendered.ObjectURL, ShouldStartWith, server.URL+"/figures/maps/")
+			So(rendered.Error, ShouldBeEmpty)
+			So(string(stored[strings.TrimPrefix(rendered.ObjectURL, server.URL)]), ShouldContainSubstring, "<svg")
+		})
+
+		Convey("An event with an invalid request publishes a failed event with the validation errors", func() {
+			rendered := render(event("map-2", "svg", []byte(`{"geography": {"topojson": {"type": "Topology"}}}`)))
+			So(rendered.ID, ShouldEqual, "map-2")
+			So(rendered.Status, ShouldEqual, renderedStatusFailed)
+			So(rendered.Error, ShouldNotBeEmpty)
+			So(rendered.ObjectURL, ShouldBeEmpty)
+			So(stored, ShouldBeEmpty)
+		})
+
+		Convey("An event for a render type that isn't stored publishes a failed event", func() {
+			rendered := render(event("map-3", "json", testdata.LoadExampleRequest(t)))
+			So(rendered.Status, ShouldEqual, renderedStatusFailed)
+			So(rendered.Error, ShouldEqual, "Maps rendered as "+contentJSON+" aren't stored")
+			So(stored, ShouldBeEmpty)
+		})
+
+		Convey("An event for an unknown render type publishes a failed event", func() {
+			rendered := render(event("map-4", "gif", testdata.LoadExampleRequest(t)))
+			So(rendered.Status, ShouldEqual, renderedStatusFailed)
+			So(rendered.Error, ShouldEqual, unknownRenderType)
+		})
+
+		Convey("An event that isn't json publishes a failed event", func() {
+			rendered := render("not json")
+			So(rendered.ID, ShouldBeEmpty)
+			So(rendered.Status, ShouldEqual, renderedStatusFailed)
+			So(rendered.Error, ShouldEqual, invalidRenderEvent)
+		})
+	})
+}
+
+// fakeMessageReader returns the messages sent to its channel, sending the offsets of those committed to its commits channel
+type fakeMessageReader struct {
+	messages chan kafka.Message
+	commits  chan int64
+}
+
+func (r *fakeMessageReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case message := <-r.messages:
+		return message, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeMessageReader) CommitMessages(ctx context.Context, messages ...kafka.Message) error {
+	for _, message := range messages {
+		r.commits <- message.Offset
+	}
+	return nil
+}
+
+func (r *fakeMessageReader) Close() error {
+	return nil
+}
+
+// fakeMessageWriter sends the messages written to it to its channel
+type fakeMessageWriter struct {
+	messages chan kafka.Message
+}
+
+func (w *fakeMessageWriter) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	for _, message := range messages {
+		w.messages <- message
+	}
+	return nil
+}
+
+func (w *fakeMessageWriter) Close() error {
+	return nil
+}
+
+func TestRenderProtobufRequest(t *testing.T) {
+	Convey("Successfully render a request encoded as a protocol buffer", t, func() {
+
+		request, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		body, err := request.MarshalProtobuf()
+		So(err, ShouldBeNil)
+
+		r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(body))
+		So(err, ShouldBeNil)
+		r.Header.Set("Content-Type", "application/x-protobuf")
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldContainSubstring, "<svg")
+		So(w.Body.String(), ShouldContainSubstring, "Non-UK born population, Great Britain, 2015")
+	})
+}
+
+func TestRenderUsesAcceptHeader(t *testing.T) {
+	Convey("Render an html map when no Accept header is given", t, func() {
+
+		r, err := http.NewRequest("POST", requestURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "text/html")
+		So(w.Header().Get("Vary"), ShouldContainSubstring, "Accept")
+		So(w.Body.String(), ShouldStartWith, "<figure")
+	})
+
+	Convey("Render a standalone svg when Accept is image/svg+xml", t, func() {
+
+		r, err := http.NewRequest("POST", requestURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		r.Header.Set("Accept", "text/html;q=0.5, image/svg+xml")
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "image/svg+xml")
+		So(w.Body.String(), ShouldStartWith, `<svg xmlns="http://www.w3.org/2000/svg"`)
+	})
+
+	Convey("Render a png image when Accept is image/png", t, func() {
+
+		renderer.UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "cat testdata/fallback.png >> " + geojson2svg.ArgPNGFilename}))
+
+		r, err := http.NewRequest("POST", requestURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		r.Header.Set("Accept", "image/png")
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "image/png")
+		So(w.Body.String(), ShouldStartWith, "\x89PNG")
+	})
+
+	Convey("Render the parts of the map as json when Accept is application/json", t, func() {
+
+		r, err := http.NewRequest("POST", requestURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		r.Header.Set("Accept", "application/json")
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		So(w.Body.String(), ShouldStartWith, `{"map":"\u003csvg`)
+	})
+
+	Convey("Never render a content type excluded with q=0, even if a wildcard is accepted", t, func() {
+
+		r, err := http.NewRequest("POST", requestURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		r.Header.Set("Accept", "text/html;q=0, */*")
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "image/svg+xml")
+
+		So(negotiateFormat("image/svg+xml;q=0, image/*", acceptableFormats).contentType, ShouldEqual, contentPNG)
+		So(negotiateFormat("image/svg+xml;q=0, image/png;q=0, image/tiff;q=0, image/*", acceptableFormats), ShouldBeNil)
+	})
+
+	Convey("Never render a content type in a range excluded with q=0, even if a wildcard is accepted", t, func() {
+
+		r, err := http.NewRequest("POST", requestURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		r.Header.Set("Accept", "text/*;q=0, */*")
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "image/svg+xml")
+
+		So(negotiateFormat("image/*;q=0, image/png, */*", acceptableFormats).contentType, ShouldEqual, contentPNG)
+		So(negotiateFormat("image/*;q=0, text/*;q=0", acceptableFormats), ShouldBeNil)
+	})
+
+	Convey("The most specific media range gives the quality of a content type", t, func() {
+		So(negotiateFormat("*/*, text/html;q=0.5", acceptableFormats).contentType, ShouldEqual, contentSVG)
+		So(negotiateFormat("image/*;q=0.5, image/png", acceptableFormats).contentType, ShouldEqual, contentPNG)
+		So(negotiateFormat("image/png, image/svg+xml", acceptableFormats).contentType, ShouldEqual, contentPNG)
+		So(negotiateFormat("*/*", acceptableFormats).contentType, ShouldEqual, contentHTML)
+	})
+
+	Convey("Reject an Accept header that cannot be rendered with StatusNotAcceptable", t, func() {
+
+		r, err := http.NewRequest("POST", requestURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		r.Header.Set("Accept", "audio/mpeg")
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusNotAcceptable)
+	})
+}
+
+func TestResponseHasRequestID(t *testing.T) {
+	Convey("The X-Request-Id of the request should be returned in the response", t, func() {
+
+		r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		r.Header.Set("X-Request-Id", "abc123")
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("X-Request-Id"), ShouldEqual, "abc123")
+	})
+
+	Convey("An X-Request-Id should be generated for a request that does not have one", t, func() {
+
+		r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(len(w.Header().Get("X-Request-Id")), ShouldEqual, requestIDSize)
+	})
+}
+
+func TestRejectRequestThatIsTooLarge(t *testing.T) {
+	Convey("Reject a request body larger than the maximum size with StatusRequestEntityTooLarge", t, func() {
+		defer func(size int64) { maxRequestSize = size }(maxRequestSize)
+		maxRequestSize = 1024
+
+		r, err := http.NewRequest("POST", requestSVGURL, bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		So(w.Body.String(), ShouldEqual, `{"error":"Request body exceeds the maximum size of 1024 bytes"}`)
+	})
+
+	Convey("Reject a request body larger than the maximum size when the content length is not known", t, func() {
+		defer func(size int64) { maxRequestSize = size }(maxRequestSize)
+		maxRequestSize = 1024
+
+		r, err := http.NewRequest("POST", analyseURL, ioutil.NopCloser(bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))))
+		So(err, ShouldBeNil)
+		So(r.ContentLength, ShouldEqual, 0)
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+	})
+}
+
+func TestCreateTLSConfig(t *testing.T) {
+	Convey("TLS config without a client CA file should not require client certificates", t, func() {
+		tlsConfig, err := createTLSConfig("")
+		So(err, ShouldBeNil)
+		So(tlsConfig.ClientAuth, ShouldEqual, tls.NoClientCert)
+		So(tlsConfig.NextProtos, ShouldContain, "h2")
+	})
+
+	Convey("TLS config with a client CA file should require and verify client certificates", t, func() {
+		server := httptest.NewTLSServer(http.NotFoundHandler())
+		defer server.Close()
+		caFile := writeTempFile(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+		defer os.Remove(caFile)
+
+		tlsConfig, err := createTLSConfig(caFile)
+		So(err, ShouldBeNil)
+		So(tlsConfig.ClientAuth, ShouldEqual, tls.RequireAndVerifyClientCert)
+		So(tlsConfig.ClientCAs, ShouldNotBeNil)
+	})
+
+	Convey("TLS config should fail if the client CA file does not contain a certificate", t, func() {
+		caFile := writeTempFile([]byte("not a certificate"))
+		defer os.Remove(caFile)
+
+		_, err := createTLSConfig(caFile)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("TLS config should fail if the client CA file does not exist", t, func() {
+		_, err := createTLSConfig("does-not-exist.pem")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func writeTempFile(content []byte) string {
+	f, err := ioutil.TempFile("", "ca")
+	So(err, ShouldBeNil)
+	defer f.Close()
+	_, err = f.Write(content)
+	So(err, ShouldBeNil)
+	return f.Name()
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	Convey("Given the api is configured with api keys", t, func() {
+		keys, err := parseAPIKeys("secret1, secret2;limit=1")
+		So(err, ShouldBeNil)
+		authKeys = keys
+		defer func() { authKeys = nil }()
+		api := routes(mux.NewRouter())
+
+		Convey("A request without an api key is rejected with StatusUnauthorized", func() {
+			w := invokeAnalyse(t, api, nil)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			So(w.Header().Get("WWW-Authenticate"), ShouldStartWith, "Bearer")
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		})
+
+		Convey("A request with an invalid api key is rejected with StatusUnauthorized", func() {
+			w := invokeAnalyse(t, api, map[string]string{"X-Api-Key": "secret3"})
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("A request with a valid X-Api-Key header is accepted", func() {
+			w := invokeAnalyse(t, api, map[string]string{"X-Api-Key": "secret1"})
+			So(w.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("A request with a valid bearer token is accepted", func() {
+			w := invokeAnalyse(t, api, map[string]string{"Authorization": "Bearer secret1"})
+			So(w.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("A request that exceeds the rate limit of its api key is rejected with StatusTooManyRequests", func() {
+			w := invokeAnalyse(t, api, map[string]string{"X-Api-Key": "secret2"})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			w = invokeAnalyse(t, api, map[string]string{"X-Api-Key": "secret2"})
+			So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(w.Header().Get("Retry-After"), ShouldNotBeEmpty)
+		})
+
+		Convey("A request without an api key is rejected with StatusUnauthorized before its size is checked", func() {
+			defer func(size int64) { maxRequestSize = size }(maxRequestSize)
+			maxRequestSize = 1024
+			api := routes(mux.NewRouter())
+
+			w := invokeAnalyse(t, api, nil)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			w = invokeAnalyse(t, api, map[string]string{"X-Api-Key": "secret1"})
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("The healthcheck does not require an api key", func() {
+			r, err := http.NewRequest("GET", host+"/healthcheck", nil)
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api.router.ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+
+	Convey("Invalid api key configuration is rejected", t, func() {
+		_, err := parseAPIKeys("secret1;limit=0")
+		So(err, ShouldNotBeNil)
+		_, err = parseAPIKeys("secret1;limit=many")
+		So(err, ShouldNotBeNil)
+		_, err = parseAPIKeys("secret1;rate=10")
+		So(err, ShouldNotBeNil)
+		_, err = parseAPIKeys(";limit=10")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Api keys may contain colons", t, func() {
+		keys, err := parseAPIKeys("client:secret, client:60, other:key;limit=30")
+		So(err, ShouldBeNil)
+		So(keys, ShouldHaveLength, 3)
+
+		Convey("A key containing a colon with no limit uses the default rate limit", func() {
+			So(keys, ShouldContainKey, hashAPIKey("client:secret"))
+			So(keys[hashAPIKey("client:secret")].limiter, ShouldBeNil)
+		})
+
+		Convey("A key ending in a colon and digits is the whole key, not a key with a limit", func() {
+			So(keys, ShouldContainKey, hashAPIKey("client:60"))
+			So(keys, ShouldNotContainKey, hashAPIKey("client"))
+			So(keys[hashAPIKey("client:60")].limiter, ShouldBeNil)
+		})
+
+		Convey("A key containing a colon may have a limit", func() {
+			So(keys, ShouldContainKey, hashAPIKey("other:key"))
+			So(keys[hashAPIKey("other:key")].limiter, ShouldNotBeNil)
+		})
+	})
+
+	Convey("No api keys are configured by default", t, func() {
+		keys, err := parseAPIKeys("")
+		So(err, ShouldBeNil)
+		So(keys, ShouldBeEmpty)
+	})
+}
+
+func invokeAnalyse(t *testing.T, api *RendererAPI, headers map[string]string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", analyseURL, bytes.NewReader(testdata.LoadExampleAnalyseRequest(t)))
+	So(err, ShouldBeNil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	api.router.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimiting(t *testing.T) {
+	Convey("Given the api is configured with a rate limit", t, func() {
+		rateLimit, rateLimitBurst = 1, 2
+		defer func() { rateLimit, rateLimitBurst = 0, 10 }()
+		api := routes(mux.NewRouter())
+
+		Convey("Requests from a client are rejected with StatusTooManyRequests once the burst is exhausted", func() {
+			So(invokeAnalyseFrom(t, api, "10.0.0.1:1234", "").Code, ShouldEqual, http.StatusOK)
+			So(invokeAnalyseFrom(t, api, "10.0.0.1:1234", "").Code, ShouldEqual, http.StatusOK)
+			w := invokeAnalyseFrom(t, api, "10.0.0.1:5678", "")
+			So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(w.Header().Get("Retry-After"), ShouldEqual, "60")
+
+			Convey("But requests from another client are accepted", func() {
+				So(invokeAnalyseFrom(t, api, "10.0.0.2:1234", "").Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("X-Forwarded-For is ignored unless trusted", func() {
+			So(invokeAnalyseFrom(t, api, "10.0.0.3:1234", "192.168.0.1").Code, ShouldEqual, http.StatusOK)
+			So(invokeAnalyseFrom(t, api, "10.0.0.3:1234", "192.168.0.2").Code, ShouldEqual, http.StatusOK)
+			So(invokeAnalyseFrom(t, api, "10.0.0.3:1234", "192.168.0.3").Code, ShouldEqual, http.StatusTooManyRequests)
+		})
+
+		Convey("Clients are identified by X-Forwarded-For when trusted", func() {
+			api.trustForwardedFor = true
+			So(invokeAnalyseFrom(t, api, "10.0.0.4:1234", "192.168.0.1").Code, ShouldEqual, http.StatusOK)
+			So(invokeAnalyseFrom(t, api, "10.0.0.4:1234", "192.168.0.1, 10.0.0.4").Code, ShouldEqual, http.StatusOK)
+			So(invokeAnalyseFrom(t, api, "10.0.0.4:1234", "192.168.0.2").Code, ShouldEqual, http.StatusOK)
+			So(invokeAnalyseFrom(t, api, "10.0.0.4:1234", "192.168.0.1").Code, ShouldEqual, http.StatusTooManyRequests)
+		})
+	})
+
+	Convey("Given a rate limiter tracking the maximum number of clients", t, func() {
+		start := time.Now()
+		clock = func() time.Time { return start }
+		defer func() { clock = time.Now }()
+
+		limiter := newRateLimiter(60, 1)
+		for i := 0; i < maxTrackedClients; i++ {
+			limiter.bucket("client-" + strconv.Itoa(i)).take()
+		}
+		So(limiter.buckets, ShouldHaveLength, maxTrackedClients)
+
+		Convey("New clients share the overflow bucket while none of the tracked clients are idle", func() {
+			b := limiter.bucket("new-client")
+			So(b, ShouldEqual, limiter.overflow)
+			So(limiter.bucket("another-client"), ShouldEqual, b)
+			So(limiter.buckets, ShouldHaveLength, maxTrackedClients)
+			So(limiter.buckets, ShouldNotContainKey, "new-client")
+		})
+
+		Convey("A tracked client keeps its own bucket", func() {
+			b := limiter.bucket("client-1")
+			So(b, ShouldNotEqual, limiter.overflow)
+			allowed, _ := b.take()
+			So(allowed, ShouldBeFalse)
+		})
+
+		Convey("Idle clients are forgotten to make room for a new client", func() {
+			clock = func() time.Time { return start.Add(time.Second) }
+			b := limiter.bucket("new-client")
+			So(b, ShouldNotEqual, limiter.overflow)
+			So(limiter.buckets, ShouldHaveLength, 1)
+			So(limiter.buckets, ShouldContainKey, "new-client")
+		})
+	})
+
+	Convey("A token bucket refills at the sustained rate", t, func() {
+		start := time.Now()
+		clock = func() time.Time { return start }
+		defer func() { clock = time.Now }()
+
+		b := newTokenBucket(0.5, 1)
+		allowed, _ := b.take()
+		So(allowed, ShouldBeTrue)
+		allowed, retryAfter := b.take()
+		So(allowed, ShouldBeFalse)
+		So(retryAfter, ShouldEqual, 2*time.Second)
+
+		clock = func() time.Time { return start.Add(2 * time.Second) }
+		allowed, _ = b.take()
+		So(allowed, ShouldBeTrue)
+	})
+}
+
+func invokeAnalyseFrom(t *testing.T, api *RendererAPI, remoteAddr string, forwardedFor string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", analyseURL, bytes.NewReader(testdata.LoadExampleAnalyseRequest(t)))
+	So(err, ShouldBeNil)
+	r.RemoteAddr = remoteAddr
+	if len(forwardedFor) > 0 {
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	api.router.ServeHTTP(w, r)
+	return w
+}
+
 func TestSuccessfullyAnalyseData(t *testing.T) {
 	Convey("Successfully analyse data and topology", t, func() {
 		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
@@ -92,6 +1207,51 @@ func TestSuccessfullyAnalyseData(t *testing.T) {
 	})
 }
 
+func TestConvertSVGToPNG(t *testing.T) {
+	Convey("Given a png converter that returns the svg it is given", t, func() {
+		renderer.UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename}))
+		defer renderer.UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "cat testdata/fallback.png >> " + geojson2svg.ArgPNGFilename}))
+		convert := func(url string, body string) *httptest.ResponseRecorder {
+			r, err := http.NewRequest("POST", url, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			api := routes(mux.NewRouter())
+			api.router.ServeHTTP(w, r)
+			return w
+		}
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="40" height="20"><path d="M0 0L40 20"/></svg>`
+
+		Convey("The svg is converted to a png", func() {
+			w := convert(convertPNGURL, svg)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "image/png")
+			So(w.Body.String(), ShouldEqual, svg)
+		})
+
+		Convey("The svg is resized to the requested dimensions", func() {
+			w := convert(convertPNGURL+"?width=80", svg)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldStartWith, `<svg width="80" height="40" viewBox="0 0 40 20" xmlns="http://www.w3.org/2000/svg">`)
+		})
+
+		Convey("A body that isn't an svg is rejected", func() {
+			w := convert(convertPNGURL, `{"svg": true}`)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, `"field":"svg"`)
+		})
+
+		Convey("Invalid dimensions are rejected", func() {
+			w := convert(convertPNGURL+"?width=wide&height=20000", svg)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, `"field":"width"`)
+
+			w = convert(convertPNGURL+"?height=20000", svg)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, `"field":"height"`)
+		})
+	})
+}
+
 func TestRejectInvalidRequest(t *testing.T) {
 	Convey("Reject invalid render type in url with StatusNotFound", t, func() {
 		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
@@ -119,6 +1279,28 @@ func TestRejectInvalidJSON(t *testing.T) {
 	})
 }
 
+func TestRejectRequestThatFailsValidation(t *testing.T) {
+	Convey("When a request has invalid fields, a bad request is returned listing each field", t, func() {
+		reader := strings.NewReader(`{"data":[{"id":"a","value":1}],"choropleth":{"breaks":[{"lower_bound":10},{"lower_bound":5},{"lower_bound":20}],"vertical_legend_position":"left"}}`)
+		r, err := http.NewRequest("POST", requestSVGURL, reader)
+		So(err, ShouldBeNil)
+
+		w := httptest.NewRecorder()
+		api := routes(mux.NewRouter())
+		api.router.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+
+		var errs []*models.ValidationError
+		So(json.Unmarshal(w.Body.Bytes(), &errs), ShouldBeNil)
+		So(errs, ShouldResemble, []*models.ValidationError{
+			{Field: "geography", Message: "Missing mandatory field"},
+			{Field: "choropleth.breaks[2].lower_bound", Message: "Lower bounds must be in strictly ascending or descending order: 20 follows 5"},
+			{Field: "choropleth.vertical_legend_position", Message: "Unknown legend position 'left'. Must be one of none, before, after, inside-top-left, inside-top-right, inside-bottom-left, inside-bottom-right"},
+		})
+	})
+}
+
 var exampleResponseStart = `
 <html>
 <head>
@@ -182,3 +1364,123 @@ var exampleResponseEnd = `
 </script>
 </body>
 </html>`
+
+func TestGRPCService(t *testing.T) {
+	Convey("Given the gRPC service of the api", t, func() {
+		renderer.UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "cat testdata/fallback.png >> " + geojson2svg.ArgPNGFilename}))
+		keys, err := parseAPIKeys("secret1, secret2;limit=1")
+		So(err, ShouldBeNil)
+		authKeys = keys
+		defer func() { authKeys = nil }()
+		client, closeClient := newGRPCTestClient(routes(mux.NewRouter()))
+		defer closeClient()
+
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		message, err := renderRequest.Proto()
+		So(err, ShouldBeNil)
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "secret1")
+
+		Convey("Render streams the html map, with the content type in the first chunk", func() {
+			contentType, body, err := receiveRendering(ctx, client, &pb.RenderMapRequest{Request: message})
+			So(err, ShouldBeNil)
+			So(contentType, ShouldEqual, "text/html")
+			So(body, ShouldContainSubstring, "<svg")
+			So(body, ShouldContainSubstring, "Non-UK born population, Great Britain, 2015")
+		})
+
+		Convey("Render returns how the data was joined to the map in its header metadata", func() {
+			stream, err := client.Render(ctx, &pb.RenderMapRequest{Request: message, ContentType: "image/svg+xml"})
+			So(err, ShouldBeNil)
+			header, err := stream.Header()
+			So(err, ShouldBeNil)
+			So(header.Get(joinMatchedRowsHeader), ShouldResemble, []string{"373"})
+			So(header.Get(joinFeaturesWithoutDataHeader), ShouldResemble, []string{"7"})
+			So(header.Get(joinUnmatchedRowsHeader), ShouldHaveLength, 1)
+		})
+
+		Convey("Render renders the content type of the request", func() {
+			contentType, body, err := receiveRendering(ctx, client, &pb.RenderMapRequest{Request: message, ContentType: "image/svg+xml"})
+			So(err, ShouldBeNil)
+			So(contentType, ShouldEqual, "image/svg+xml")
+			So(body, ShouldStartWith, "<svg")
+		})
+
+		Convey("Render rejects an unsupported content type with InvalidArgument", func() {
+			_, _, err := receiveRendering(ctx, client, &pb.RenderMapRequest{Request: message, ContentType: "text/plain"})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		})
+
+		Convey("Render rejects an invalid request with InvalidArgument", func() {
+			_, _, err := receiveRendering(ctx, client, &pb.RenderMapRequest{Request: &pb.RenderRequest{}})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		})
+
+		Convey("Analyse returns the json of the analyse endpoint", func() {
+			analyseRequest, err := models.CreateAnalyseRequest(bytes.NewReader(testdata.LoadExampleAnalyseRequest(t)))
+			So(err, ShouldBeNil)
+			analyseMessage, err := analyseRequest.Proto()
+			So(err, ShouldBeNil)
+			response, err := client.Analyse(ctx, analyseMessage)
+			So(err, ShouldBeNil)
+			var analyseResponse models.AnalyseResponse
+			So(json.Unmarshal(response.Json, &analyseResponse), ShouldBeNil)
+			So(analyseResponse.Data, ShouldNotBeEmpty)
+		})
+
+		Convey("A call without a valid api key is rejected with Unauthenticated", func() {
+			_, _, err := receiveRendering(context.Background(), client, &pb.RenderMapRequest{Request: message})
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+			bearer := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret3")
+			_, err = client.Analyse(bearer, &pb.AnalyseRequest{})
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+		})
+
+		Convey("A call that exceeds the rate limit of its api key is rejected with ResourceExhausted", func() {
+			limited := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret2")
+			_, _, err := receiveRendering(limited, client, &pb.RenderMapRequest{Request: message, ContentType: "image/svg+xml"})
+			So(err, ShouldBeNil)
+			_, _, err = receiveRendering(limited, client, &pb.RenderMapRequest{Request: message, ContentType: "image/svg+xml"})
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+		})
+	})
+}
+
+// newGRPCTestClient serves the gRPC service of the api over an in-memory connection, returning a client of it and a function that closes both
+func newGRPCTestClient(api *RendererAPI) (pb.MapRendererClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := api.newGRPCServer()
+	go server.Serve(listener)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	So(err, ShouldBeNil)
+	return pb.NewMapRendererClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+// receiveRendering calls Render, returning the content type and the concatenated data of the chunks it streams
+func receiveRendering(ctx context.Context, client pb.MapRendererClient, message *pb.RenderMapRequest) (string, string, error) {
+	stream, err := client.Render(ctx, message)
+	if err != nil {
+		return "", "", err
+	}
+	var contentType string
+	var body bytes.Buffer
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return contentType, body.String(), nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if len(contentType) == 0 {
+			contentType = chunk.ContentType
+		}
+		body.Write(chunk.Data)
+	}
+}
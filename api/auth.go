@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// The headers that may contain an api key
+const (
+	apiKeyHeader        = "X-Api-Key"
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+)
+
+const apiKeyContextKey = contextKey("apiKey")
+
+type contextKey string
+
+// apiKey is a key that may be used to access the render and analyse routes
+type apiKey struct {
+	name    string       // a name for the key that is safe to log
+	limiter *tokenBucket // limits the rate of requests using this key. nil to use the default rate limit
+}
+
+// apiKeyLimitParam is the parameter of an api key giving the maximum number of requests per minute allowed with that key
+const apiKeyLimitParam = "limit="
+
+// parseAPIKeys parses a comma-separated list of api keys, each optionally followed by a semicolon and limit=<the maximum number of requests per minute
+// allowed with that key>, e.g. "key1,key2;limit=60". Keys may contain any character except commas, semicolons and whitespace (a semicolon can't appear
+// in a bearer token). The returned map is keyed on the sha256 hash of each key, so that the keys themselves are not held in memory
+// and lookups do not reveal (through timing) how much of a key matched.
+func parseAPIKeys(keys string) (map[string]*apiKey, error) {
+	parsed := make(map[string]*apiKey)
+	for i, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		key := &apiKey{name: fmt.Sprintf("key-%d", i+1)}
+		params := strings.Split(entry, ";")
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, apiKeyLimitParam) {
+				return nil, fmt.Errorf("Invalid parameter for api key %d - must be %s<requests per minute>", i+1, apiKeyLimitParam)
+			}
+			perMinute, err := strconv.Atoi(strings.TrimPrefix(param, apiKeyLimitParam))
+			if err != nil || perMinute <= 0 {
+				return nil, fmt.Errorf("Invalid rate limit for api key %d - must be a positive number of requests per minute", i+1)
+			}
+			key.limiter = newTokenBucket(float64(perMinute)/60.0, float64(perMinute))
+		}
+		entry = strings.TrimSpace(params[0])
+		if len(entry) == 0 {
+			return nil, fmt.Errorf("Api key %d is empty", i+1)
+		}
+		parsed[hashAPIKey(entry)] = key
+	}
+	return parsed, nil
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// getRequestAPIKey returns the api key given in the X-Api-Key header, or as a bearer token in the Authorization header
+func getRequestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); len(key) > 0 {
+		return key
+	}
+	if auth := r.Header.Get(authorizationHeader); strings.HasPrefix(auth, bearerPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(auth, bearerPrefix))
+	}
+	return ""
+}
+
+// authenticate wraps the handler so that requests are rejected with a 401 unless they have one of the api keys.
+// The key is added to the request context (for rate limiting). If no keys are configured, all requests are allowed.
+func (api *RendererAPI) authenticate(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(api.keys) == 0 {
+			h(w, r)
+			return
+		}
+
+		key, ok := api.keys[hashAPIKey(getRequestAPIKey(r))]
+		if !ok {
+			log.ErrorR(r, errors.New("Missing or invalid api key"), nil)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dp-map-renderer"`)
+			writeJSONError(w, http.StatusUnauthorized, "A valid api key must be provided in the X-Api-Key header or as a Bearer token")
+			return
+		}
+
+		h(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, key)))
+	})
+}
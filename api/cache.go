@@ -0,0 +1,148 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// sharedRenderPrefix is the prefix of the keys of rendered responses in the shared cache
+const sharedRenderPrefix = "dp-map-renderer:render:"
+
+// sharedCache is shared with other instances of the service, behind the in-memory render cache. Nil (the default) if there is none.
+var sharedCache renderer.SharedCache
+
+// UseSharedCache shares rendered responses with other instances of the service through the cache, so that a request rendered by one instance
+// isn't rendered again by another. Nil stops sharing. Must be called before CreateRendererAPI.
+func UseSharedCache(cache renderer.SharedCache) {
+	sharedCache = cache
+}
+
+// cachedResponse is a rendered response body with its content type, how the request's data was joined to its regions,
+// and the url at which it was stored (if it was)
+type cachedResponse struct {
+	contentType string
+	body        []byte
+	join        *models.JoinDiagnostics
+	objectURL   string
+}
+
+// sharedResponse is the form of a cachedResponse in the shared cache
+type sharedResponse struct {
+	ContentType string                  `json:"content_type"`
+	Body        []byte                  `json:"body"`
+	Join        *models.JoinDiagnostics `json:"join,omitempty"`
+	ObjectURL   string                  `json:"object_url,omitempty"`
+}
+
+// renderCache is an in-memory cache of rendered responses, keyed by etag, optionally backed by a cache shared with other instances.
+// When full, the oldest entry is evicted to make room for a new one.
+type renderCache struct {
+	mutex      sync.RWMutex
+	entries    map[string]*cachedResponse
+	order      []string
+	maxEntries int
+	shared     renderer.SharedCache
+}
+
+// newRenderCache creates a renderCache holding at most maxEntries responses, backed by the shared cache (if it isn't nil).
+// A size of zero (or less) disables the in-memory cache.
+func newRenderCache(maxEntries int, shared renderer.SharedCache) *renderCache {
+	return &renderCache{entries: make(map[string]*cachedResponse), maxEntries: maxEntries, shared: shared}
+}
+
+// enabled returns true if responses are cached
+func (c *renderCache) enabled() bool {
+	return c.maxEntries > 0 || c.shared != nil
+}
+
+// get returns the cached response for the given etag, or nil if there is none. A response found in the shared cache is added to the in-memory cache.
+func (c *renderCache) get(etag string) *cachedResponse {
+	c.mutex.RLock()
+	response := c.entries[etag]
+	c.mutex.RUnlock()
+	if response != nil || c.shared == nil {
+		return response
+	}
+
+	b, err := c.shared.Get(sharedRenderPrefix + etag)
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to get response from the shared cache", "etag": etag})
+		return nil
+	}
+	if b == nil {
+		return nil
+	}
+	var shared sharedResponse
+	if err = json.Unmarshal(b, &shared); err != nil {
+		log.Error(err, log.Data{"_message": "Unable to decode response from the shared cache", "etag": etag})
+		return nil
+	}
+	response = &cachedResponse{contentType: shared.ContentType, body: shared.Body, join: shared.Join, objectURL: shared.ObjectURL}
+	c.putLocal(etag, response)
+	return response
+}
+
+// put adds the response to the cache, and the shared cache if there is one
+func (c *renderCache) put(etag string, response *cachedResponse) {
+	c.putLocal(etag, response)
+	if c.shared == nil {
+		return
+	}
+	b, err := json.Marshal(&sharedResponse{ContentType: response.contentType, Body: response.body, Join: response.join, ObjectURL: response.objectURL})
+	if err == nil {
+		err = c.shared.Set(sharedRenderPrefix+etag, b)
+	}
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to add response to the shared cache", "etag": etag})
+	}
+}
+
+// putLocal adds the response to the in-memory cache, evicting the oldest entry if the cache is full
+func (c *renderCache) putLocal(etag string, response *cachedResponse) {
+	if c.maxEntries <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, exists := c.entries[etag]; exists {
+		return
+	}
+	if len(c.order) >= c.maxEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[etag] = response
+	c.order = append(c.order, etag)
+}
+
+// createETag returns a strong etag derived from a sha256 hash of the render type and request body, and of the hashes of any content the request refers to
+// (such as a topology fetched from its url), so that the etag changes when that content does
+func createETag(renderType string, body []byte, fetched ...string) string {
+	hash := sha256.New()
+	hash.Write([]byte(renderType))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	for _, s := range fetched {
+		hash.Write([]byte{0})
+		hash.Write([]byte(s))
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+}
+
+// matchesETag returns true if the If-None-Match header value contains the etag (or is the wildcard '*')
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, s := range strings.Split(ifNoneMatch, ",") {
+		s = strings.TrimSpace(s)
+		if s == etag || s == "*" || s == "W/"+etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// convertPNG converts the svg document in the body of the request to a png, at the size given by the width and height query parameters
+func (api *RendererAPI) convertPNG(w http.ResponseWriter, r *http.Request) {
+
+	start := time.Now()
+	request, err := models.CreateConvertRequest(r.Body, r.URL.Query())
+	if err != nil {
+		log.ErrorR(r, err, nil)
+		writeValidationError(w, err)
+		return
+	}
+
+	if err = request.ValidateConvertRequest(); err != nil {
+		log.ErrorR(r, err, log.Data{"_message": "ConvertRequest failed validation"})
+		writeValidationError(w, err)
+		return
+	}
+
+	png, err := renderer.ConvertPNG(r.Context(), request.SVG, request.Width, request.Height)
+	if err != nil {
+		log.ErrorR(r, err, log.Data{"_message": "Unable to convert svg"})
+		setErrorCode(w, err)
+		return
+	}
+
+	log.InfoR(r, "svg converted", log.Data{"svg_size": len(request.SVG), "width": request.Width, "height": request.Height, "response_size": len(png), "duration": time.Since(start).String()})
+
+	setContentType(w, contentPNG)
+
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(png)
+	if err != nil {
+		log.ErrorR(r, err, log.Data{})
+		return
+	}
+
+}
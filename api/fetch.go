@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+
+	"github.com/ONSdigital/dp-map-renderer/dataset"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
+)
+
+// topologyFetcher fetches the topologies of geographies given by url. Nil (the default) if no hosts are allowed, in which case topologies can't be fetched
+var topologyFetcher *topoutil.Fetcher
+
+// datasetClient fetches the observations of requests with a dataset. Nil if no dataset API is configured, in which case such requests are rejected
+var datasetClient *dataset.Client
+
+// topojsonURLNotAllowed is the message of the validation error returned for a topojson url that can't be fetched from
+const topojsonURLNotAllowed = "Topojson can't be fetched from this url - it must be http or https, on one of the hosts in TOPOJSON_URL_HOSTS"
+
+// fetchTopology sets the topology of the geography to the one at its TopojsonURL, unless it has a topology already,
+// returning a hash of the topology fetched (or an empty string if none was).
+// Returns ValidationErrors if the url isn't allowed, or a topoutil.FetchError if the topology can't be fetched.
+func (api *RendererAPI) fetchTopology(ctx context.Context, geography *models.Geography) (string, error) {
+	if geography == nil || geography.Topojson != nil || len(geography.TopojsonURL) == 0 {
+		return "", nil
+	}
+	if api.fetcher == nil {
+		return "", models.ValidationErrors{{Field: "geography.topojson_url", Message: topojsonURLNotAllowed}}
+	}
+	topology, hash, err := api.fetcher.Fetch(ctx, geography.TopojsonURL)
+	if err == topoutil.ErrURLNotAllowed {
+		return "", models.ValidationErrors{{Field: "geography.topojson_url", Message: topojsonURLNotAllowed}}
+	}
+	if err != nil {
+		return "", err
+	}
+	geography.Topojson = topology
+	return hash, nil
+}
+
+// fetchDataset sets the data of the request to the observations of its dataset, if it has one, returning a hash of the observations (or an empty string if it has no dataset).
+// Returns ValidationErrors if the dataset is invalid (or the request has data of its own), or a dataset.Error if the observations can't be fetched.
+func (api *RendererAPI) fetchDataset(ctx context.Context, request *models.RenderRequest) (string, error) {
+	if request.Dataset == nil {
+		return "", nil
+	}
+	if err := request.ValidateDataset(); err != nil {
+		return "", err
+	}
+	if api.datasets == nil {
+		return "", models.ValidationErrors{{Field: "dataset", Message: "No dataset API is configured (DATASET_API_URL)"}}
+	}
+	rows, err := api.datasets.Observations(ctx, request.Dataset)
+	if err != nil {
+		return "", err
+	}
+	return hashRows(rows), request.SetDatasetData(rows)
+}
+
+// hashRows returns a hash (hex sha256) of the rows - which differs if the id, value, category or marker of any row does
+func hashRows(rows []*models.DataRow) string {
+	hash := sha256.New()
+	value := make([]byte, 8)
+	for _, row := range rows {
+		binary.BigEndian.PutUint64(value, math.Float64bits(row.Value))
+		for _, b := range [][]byte{[]byte(row.ID), value, []byte(row.Category), []byte(row.Marker)} {
+			hash.Write(b)
+			hash.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,365 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/analyser"
+	"github.com/ONSdigital/dp-map-renderer/config"
+	"github.com/ONSdigital/dp-map-renderer/dataset"
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	pb "github.com/ONSdigital/dp-map-renderer/proto"
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
+	"github.com/ONSdigital/go-ns/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var grpcServer *grpc.Server
+
+// grpcChunkSize is the maximum size of the data in each RenderResponseChunk
+const grpcChunkSize = 64 * 1024
+
+// The metadata of a gRPC call that may contain an api key
+const (
+	apiKeyMetadata        = "x-api-key"
+	authorizationMetadata = "authorization"
+)
+
+// mapRendererService implements the MapRenderer gRPC service (see proto/maprenderer.proto) using the api that serves it
+type mapRendererService struct {
+	pb.UnimplementedMapRendererServer
+	api *RendererAPI
+}
+
+// createGRPCServer serves the MapRenderer gRPC service of the api on cfg.GRPCBindAddr, using TLS if the http server does
+func createGRPCServer(cfg *config.Config, api *RendererAPI, errorChan chan error) {
+	var options []grpc.ServerOption
+	if cfg.TLSEnabled() {
+		tlsConfig, err := createTLSConfig(cfg.TLSClientCAFile)
+		if err != nil {
+			log.ErrorC("Main", err, log.Data{"MethodInError": "createTLSConfig()", "client_ca_file": cfg.TLSClientCAFile})
+			errorChan <- err
+			return
+		}
+		certificate, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.ErrorC("Main", err, log.Data{"MethodInError": "tls.LoadX509KeyPair()"})
+			errorChan <- err
+			return
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+		tlsConfig.NextProtos = []string{"h2"}
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	listener, err := net.Listen("tcp", cfg.GRPCBindAddr)
+	if err != nil {
+		log.ErrorC("Main", err, log.Data{"MethodInError": "net.Listen()", "grpc_bind_addr": cfg.GRPCBindAddr})
+		errorChan <- err
+		return
+	}
+
+	grpcServer = api.newGRPCServer(options...)
+	go func() {
+		log.Debug("Starting map renderer gRPC service...", log.Data{"bind_addr": cfg.GRPCBindAddr, "tls": cfg.TLSEnabled()})
+		if err := grpcServer.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+			log.ErrorC("Main", err, log.Data{"MethodInError": "grpcServer.Serve()"})
+			errorChan <- err
+		}
+	}()
+}
+
+// newGRPCServer creates a server for the MapRenderer gRPC service, whose calls are protected by api keys (if configured) and rate limits as the http routes are
+func (api *RendererAPI) newGRPCServer(options ...grpc.ServerOption) *grpc.Server {
+	maxMessageSize := math.MaxInt32
+	if maxRequestSize > 0 && maxRequestSize < math.MaxInt32 {
+		maxMessageSize = int(maxRequestSize)
+	}
+	options = append(options,
+		grpc.MaxRecvMsgSize(maxMessageSize),
+		grpc.UnaryInterceptor(api.authorizeUnaryCall),
+		grpc.StreamInterceptor(api.authorizeStreamCall),
+	)
+	server := grpc.NewServer(options...)
+	pb.RegisterMapRendererServer(server, &mapRendererService{api: api})
+	return server
+}
+
+// authorizeUnaryCall authorizes a call (see authorizeCall) before calling its method
+func (api *RendererAPI) authorizeUnaryCall(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := api.authorizeCall(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, request)
+}
+
+// authorizeStreamCall authorizes a streaming call (see authorizeCall) before calling its method
+func (api *RendererAPI) authorizeStreamCall(service interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := api.authorizeCall(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(service, stream)
+}
+
+// authorizeCall returns an Unauthenticated error if the call doesn't have one of the api keys (when keys are configured),
+// or a ResourceExhausted error if its client has exceeded its rate limit
+func (api *RendererAPI) authorizeCall(ctx context.Context, method string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var key *apiKey
+	if len(api.keys) > 0 {
+		var ok bool
+		if key, ok = api.keys[hashAPIKey(getCallAPIKey(md))]; !ok {
+			log.Error(errors.New("Missing or invalid api key"), log.Data{"grpc_method": method})
+			return status.Error(codes.Unauthenticated, "A valid api key must be provided in the x-api-key metadata or as a bearer token")
+		}
+	}
+
+	b, client := api.rateLimitBucket(key, callClientIP(ctx, md, api.trustForwardedFor))
+	if b != nil {
+		if allowed, retryAfter := b.take(); !allowed {
+			log.Error(errors.New("Rate limit exceeded"), log.Data{"grpc_method": method, "client": client})
+			return status.Errorf(codes.ResourceExhausted, "Rate limit exceeded - retry after %d seconds", retryAfterSeconds(retryAfter))
+		}
+	}
+	return nil
+}
+
+// getCallAPIKey returns the api key given in the x-api-key metadata of a gRPC call, or as a bearer token in its authorization metadata
+func getCallAPIKey(md metadata.MD) string {
+	if key := md.Get(apiKeyMetadata); len(key) > 0 && len(key[0]) > 0 {
+		return key[0]
+	}
+	if auth := md.Get(authorizationMetadata); len(auth) > 0 && strings.HasPrefix(auth[0], bearerPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(auth[0], bearerPrefix))
+	}
+	return ""
+}
+
+// callClientIP returns the ip address of the client of a gRPC call - the first address in its x-forwarded-for metadata if trustForwardedFor, otherwise the remote address of the connection
+func callClientIP(ctx context.Context, md metadata.MD, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := md.Get("x-forwarded-for"); len(forwarded) > 0 && len(forwarded[0]) > 0 {
+			return strings.TrimSpace(strings.Split(forwarded[0], ",")[0])
+		}
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// Render renders the map in the content type of the request, streaming it as RenderResponseChunks
+func (s *mapRendererService) Render(message *pb.RenderMapRequest, stream pb.MapRenderer_RenderServer) error {
+	ctx := stream.Context()
+	start := time.Now()
+	logData := log.Data{"grpc_method": "Render"}
+
+	format := grpcRenderFormat(message.ContentType)
+	if format == nil {
+		err := status.Error(codes.InvalidArgument, "Unsupported content type "+message.ContentType)
+		log.Error(err, logData)
+		return err
+	}
+	logData["content_type"] = format.contentType
+
+	renderRequest, err := models.RenderRequestFromProto(message.Request)
+	if err != nil {
+		log.Error(err, logData)
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	join, _, err := s.api.prepareRenderRequest(ctx, renderRequest)
+	if err != nil {
+		log.Error(err, logData)
+		return grpcStatus(err)
+	}
+	logData["data_rows"] = len(renderRequest.Data)
+	logData["unmatched_rows"] = join.UnmatchedRowCount
+	if err = stream.SetHeader(joinMetadata(join)); err != nil {
+		log.Error(err, logData)
+		return err
+	}
+
+	out := &grpcChunkWriter{stream: stream, contentType: format.contentType}
+	if format.stream != nil {
+		// the parts written as the map is rendered are sent in chunks of grpcChunkSize, rather than a chunk per write
+		buffered := bufio.NewWriterSize(out, grpcChunkSize)
+		if err = format.stream(ctx, buffered, renderRequest); err == nil {
+			err = buffered.Flush()
+		}
+	} else {
+		var result []byte
+		if result, err = format.render(ctx, renderRequest); err == nil {
+			_, err = out.Write(result)
+		}
+	}
+	if err == nil && out.chunks == 0 {
+		// an empty rendering is returned as a single chunk, so that the client still receives the content type
+		err = out.writeChunk(nil)
+	}
+	if err != nil {
+		log.Error(err, logData)
+		return grpcStatus(err)
+	}
+
+	logData["response_size"] = out.size
+	logData["duration"] = time.Since(start).String()
+	log.Info("map rendered", logData)
+	return nil
+}
+
+// grpcRenderFormat returns the acceptable format with the given content type, or the default format (html) if no content type is given
+func grpcRenderFormat(contentType string) *renderFormat {
+	if len(contentType) == 0 {
+		return acceptableFormats[0]
+	}
+	for _, format := range acceptableFormats {
+		if format.contentType == contentType {
+			return format
+		}
+	}
+	return nil
+}
+
+// joinMetadata returns the (header) metadata of a Render call reporting how the data was joined to the regions of the map, as the join headers of the http api do
+func joinMetadata(join *models.JoinDiagnostics) metadata.MD {
+	md := metadata.Pairs(
+		joinMatchedRowsHeader, strconv.Itoa(join.MatchedRowCount),
+		joinUnmatchedRowsHeader, strconv.Itoa(join.UnmatchedRowCount),
+		joinFeaturesWithoutDataHeader, strconv.Itoa(join.FeaturesWithoutDataCount),
+	)
+	if len(join.UnmatchedRowSample) > 0 {
+		md.Set(joinUnmatchedRowSampleHeader, strings.Join(join.UnmatchedRowSample, ","))
+	}
+	return md
+}
+
+// Analyse analyses the data of the request, returning the json of the /analyse endpoint
+func (s *mapRendererService) Analyse(ctx context.Context, message *pb.AnalyseRequest) (*pb.AnalyseResponse, error) {
+	start := time.Now()
+	logData := log.Data{"grpc_method": "Analyse"}
+
+	request, err := models.AnalyseRequestFromProto(message)
+	if err != nil {
+		log.Error(err, logData)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err = s.api.fetchTopology(ctx, request.Geography); err != nil {
+		log.Error(err, logData)
+		return nil, grpcStatus(err)
+	}
+	if err = request.ValidateAnalyseRequest(); err != nil {
+		log.Error(err, logData)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response, err := analyser.AnalyseData(request)
+	if err != nil {
+		log.Error(err, logData)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	b, err := json.Marshal(response)
+	if err != nil {
+		log.Error(err, logData)
+		return nil, grpcStatus(err)
+	}
+
+	logData["data_type"] = response.DataType
+	logData["data_rows"] = len(response.Data)
+	logData["duration"] = time.Since(start).String()
+	log.Info("data analysed", logData)
+	return &pb.AnalyseResponse{Json: b}, nil
+}
+
+// grpcChunkWriter writes to a Render stream as RenderResponseChunks of up to grpcChunkSize bytes, the first of which has the content type
+type grpcChunkWriter struct {
+	stream      pb.MapRenderer_RenderServer
+	contentType string
+	chunks      int
+	size        int
+}
+
+func (c *grpcChunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > grpcChunkSize {
+			n = grpcChunkSize
+		}
+		if err := c.writeChunk(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *grpcChunkWriter) writeChunk(data []byte) error {
+	chunk := &pb.RenderResponseChunk{Data: data}
+	if c.chunks == 0 {
+		chunk.ContentType = c.contentType
+	}
+	if err := c.stream.Send(chunk); err != nil {
+		return err
+	}
+	c.chunks++
+	c.size += len(data)
+	return nil
+}
+
+// grpcStatus returns the gRPC status error equivalent to the http status and message of setErrorCode
+func grpcStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		// e.g. the error of sending a chunk
+		return err
+	}
+	if _, ok := err.(models.ValidationErrors); ok {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if datasetError, ok := err.(*dataset.Error); ok {
+		// the dataset api rejects unknown datasets, editions, versions and options as client errors
+		if datasetError.Status >= 400 && datasetError.Status < 500 {
+			return status.Error(codes.InvalidArgument, datasetError.Error())
+		}
+		return status.Error(codes.Unavailable, datasetFailed)
+	}
+	if _, ok := err.(*topoutil.FetchError); ok {
+		return status.Error(codes.Unavailable, fetchFailed)
+	}
+	if conversionError, ok := err.(*g2s.ConversionError); ok {
+		if conversionError.TimedOut {
+			return status.Error(codes.DeadlineExceeded, conversionTimeout)
+		}
+		return status.Error(codes.Internal, conversionFailed)
+	}
+	switch err {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	}
+	if err.Error() == "Bad request" {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, internalError)
+}
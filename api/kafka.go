@@ -0,0 +1,235 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/config"
+	"github.com/ONSdigital/dp-map-renderer/dataset"
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/storage"
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/segmentio/kafka-go"
+)
+
+var renderWorker *kafkaRenderWorker
+
+// The status of a renderedEvent
+const (
+	renderedStatusCompleted = "completed"
+	renderedStatusFailed    = "failed"
+)
+
+const invalidRenderEvent = "Invalid render event"
+
+// renderEvent is a request to render a map, consumed from the render topic
+type renderEvent struct {
+	ID         string          `json:"id"`
+	RenderType string          `json:"render_type"`
+	Request    json.RawMessage `json:"request"`
+}
+
+// renderedEvent is published to the rendered topic once a renderEvent has been handled, giving the url at which the map was stored, or why it couldn't be rendered
+type renderedEvent struct {
+	ID          string `json:"id"`
+	RenderType  string `json:"render_type"`
+	Status      string `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	ObjectURL   string `json:"object_url,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// messageReader consumes the messages of a topic (see kafka.Reader)
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// messageWriter publishes messages to a topic (see kafka.Writer)
+type messageWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// kafkaRenderWorker renders the maps of the renderEvents it consumes, publishing a renderedEvent for each
+type kafkaRenderWorker struct {
+	api    *RendererAPI
+	reader messageReader
+	writer messageWriter
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// createRenderWorker starts consuming renderEvents from cfg.KafkaRenderTopic, publishing renderedEvents to cfg.KafkaRenderedTopic
+func createRenderWorker(cfg *config.Config, api *RendererAPI, errorChan chan error) {
+	readerConfig := kafka.ReaderConfig{Brokers: cfg.KafkaAddr, GroupID: cfg.KafkaConsumerGroup, Topic: cfg.KafkaRenderTopic}
+	if maxRequestSize > 0 {
+		readerConfig.MaxBytes = int(maxRequestSize)
+	}
+	reader := kafka.NewReader(readerConfig)
+	// events are keyed on their id, so that the events of a render are published to the same partition
+	writer := kafka.NewWriter(kafka.WriterConfig{Brokers: cfg.KafkaAddr, Topic: cfg.KafkaRenderedTopic, Balancer: &kafka.Hash{}})
+
+	renderWorker = newRenderWorker(api, reader, writer)
+	log.Debug("Starting map render worker...", log.Data{"kafka_addr": cfg.KafkaAddr, "render_topic": cfg.KafkaRenderTopic, "rendered_topic": cfg.KafkaRenderedTopic})
+	renderWorker.start(errorChan)
+}
+
+func newRenderWorker(api *RendererAPI, reader messageReader, writer messageWriter) *kafkaRenderWorker {
+	return &kafkaRenderWorker{api: api, reader: reader, writer: writer, done: make(chan struct{})}
+}
+
+// start consumes messages until the worker is stopped, sending an error to errorChan if it can't consume or publish messages
+func (w *kafkaRenderWorker) start(errorChan chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	go func() {
+		if err := w.run(ctx); err != nil {
+			log.ErrorC("Main", err, log.Data{"MethodInError": "renderWorker.run()"})
+			errorChan <- err
+		}
+	}()
+}
+
+// run renders the map of each message, publishes its renderedEvent, and then commits the message - so that a message is consumed again if
+// the worker fails before its event is published. Returns nil when the context is cancelled.
+func (w *kafkaRenderWorker) run(ctx context.Context) error {
+	defer close(w.done)
+	for {
+		message, err := w.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// a render that has started is completed when the worker is stopped, rather than being cancelled and rendered again by another instance
+		background := context.Background()
+		rendered := w.api.renderEvent(background, message.Value)
+		value, err := json.Marshal(rendered)
+		if err != nil {
+			return err
+		}
+		if err = w.writer.WriteMessages(background, kafka.Message{Key: []byte(rendered.ID), Value: value}); err != nil {
+			return err
+		}
+		if err = w.reader.CommitMessages(background, message); err != nil {
+			return err
+		}
+	}
+}
+
+// stop stops consuming messages, waiting for the render in progress (if any) to complete until the context is done
+func (w *kafkaRenderWorker) stop(ctx context.Context) error {
+	w.cancel()
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := w.reader.Close(); err != nil {
+		return err
+	}
+	return w.writer.Close()
+}
+
+// renderEvent renders the map of a renderEvent and stores it, returning the renderedEvent to publish. A map that can't be rendered or stored is reported in the event.
+func (api *RendererAPI) renderEvent(ctx context.Context, value []byte) *renderedEvent {
+	start := time.Now()
+
+	var event renderEvent
+	if err := json.Unmarshal(value, &event); err != nil {
+		log.Error(err, log.Data{"message_size": len(value)})
+		return &renderedEvent{Status: renderedStatusFailed, Error: invalidRenderEvent}
+	}
+	rendered := &renderedEvent{ID: event.ID, RenderType: event.RenderType, Status: renderedStatusFailed}
+	logData := log.Data{"render_id": event.ID, "render_type": event.RenderType, "request_size": len(event.Request)}
+
+	format, ok := renderTypes[event.RenderType]
+	if !ok {
+		rendered.Error = unknownRenderType
+		log.Error(errors.New(unknownRenderType), logData)
+		return rendered
+	}
+
+	renderRequest, err := models.CreateRenderRequest(bytes.NewReader(event.Request))
+	if err != nil {
+		rendered.Error = err.Error()
+		log.Error(err, logData)
+		return rendered
+	}
+	join, _, err := api.prepareRenderRequest(ctx, renderRequest)
+	if err != nil {
+		rendered.Error = renderEventError(err)
+		log.Error(err, logData)
+		return rendered
+	}
+	logData["data_rows"] = len(renderRequest.Data)
+	logData["unmatched_rows"] = join.UnmatchedRowCount
+
+	if format.variant != nil {
+		if variant := format.variant(renderRequest); variant != nil {
+			format = variant
+		}
+	}
+	if !api.stores(format.contentType) {
+		rendered.Error = "Maps rendered as " + format.contentType + " aren't stored"
+		log.Error(errors.New(rendered.Error), logData)
+		return rendered
+	}
+
+	result, err := format.render(ctx, renderRequest)
+	if err != nil {
+		rendered.Error = renderEventError(err)
+		log.Error(err, logData)
+		return rendered
+	}
+	response := &cachedResponse{contentType: format.contentType, body: result, join: join}
+	if err = api.storeResponse(ctx, response); err != nil {
+		rendered.Error = renderEventError(err)
+		log.Error(err, logData)
+		return rendered
+	}
+
+	rendered.Status = renderedStatusCompleted
+	rendered.ContentType = response.contentType
+	rendered.ObjectURL = response.objectURL
+	logData["response_size"] = len(result)
+	logData["object_url"] = response.objectURL
+	logData["duration"] = time.Since(start).String()
+	log.Info("map rendered", logData)
+	return rendered
+}
+
+// renderEventError returns the error of a renderedEvent, with the same message as the response of setErrorCode
+func renderEventError(err error) string {
+	if _, ok := err.(models.ValidationErrors); ok {
+		return err.Error()
+	}
+	if datasetError, ok := err.(*dataset.Error); ok {
+		if datasetError.Status >= 400 && datasetError.Status < 500 {
+			return datasetError.Error()
+		}
+		return datasetFailed
+	}
+	if _, ok := err.(*topoutil.FetchError); ok {
+		return fetchFailed
+	}
+	if _, ok := err.(*storage.PutError); ok {
+		return storeFailed
+	}
+	if conversionError, ok := err.(*g2s.ConversionError); ok {
+		if conversionError.TimedOut {
+			return conversionTimeout
+		}
+		return conversionFailed
+	}
+	return internalError
+}
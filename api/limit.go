@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// errorResponse is the json body returned when a request is rejected
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// limitRequestSize returns middleware that rejects requests with a body larger than maxBytes with a 413 and a json error body.
+// The body is read (up to maxBytes) before the request is passed on, so the decoders never consume more than maxBytes. A maxBytes of zero (or less) disables the limit.
+func limitRequestSize(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxBytes <= 0 || r.Body == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > maxBytes {
+				rejectTooLarge(w, r, maxBytes)
+				return
+			}
+
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+			r.Body.Close()
+			if err != nil {
+				log.ErrorR(r, err, nil)
+				http.Error(w, badRequest, http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > maxBytes {
+				rejectTooLarge(w, r, maxBytes)
+				return
+			}
+
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rejectTooLarge writes a 413 response with a json error body
+func rejectTooLarge(w http.ResponseWriter, r *http.Request, maxBytes int64) {
+	message := fmt.Sprintf("Request body exceeds the maximum size of %d bytes", maxBytes)
+	log.ErrorR(r, errors.New(message), log.Data{"content_length": r.ContentLength})
+	writeJSONError(w, http.StatusRequestEntityTooLarge, message)
+}
+
+// writeJSONError writes an errorResponse with the given status code
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	b, err := json.Marshal(&errorResponse{Error: message})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	setContentType(w, "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// writeValidationError writes a 400 response. If err is a models.ValidationErrors, the body is a json array of the invalid fields and their messages,
+// otherwise it is the plain text of the error.
+func writeValidationError(w http.ResponseWriter, err error) {
+	errs, ok := err.(models.ValidationErrors)
+	if !ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, marshalErr := json.Marshal(errs)
+	if marshalErr != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	setContentType(w, "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(b)
+}
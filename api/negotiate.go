@@ -0,0 +1,90 @@
+package api
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// acceptedType is a single media range from an Accept header, with its quality value
+type acceptedType struct {
+	mediaRange string
+	quality    float64
+}
+
+// negotiateFormat returns the format with the highest quality in the Accept header, or nil if none of them are acceptable.
+// The quality of a format is that of the most specific media range that includes it (e.g. image/png rather than image/* or */*), so a format
+// excluded with q=0 is never chosen, even if a less specific range includes it. Formats of equal quality are chosen in the order of the ranges
+// that include them, and then in the order of formats. An empty Accept header accepts any format.
+func negotiateFormat(accept string, formats []*renderFormat) *renderFormat {
+	if len(strings.TrimSpace(accept)) == 0 {
+		return formats[0]
+	}
+	types := parseAccept(accept)
+	var best *renderFormat
+	bestQuality, bestIndex := 0.0, len(types)
+	for _, f := range formats {
+		quality, index := formatQuality(types, f.contentType)
+		if quality > bestQuality || (quality > 0 && quality == bestQuality && index < bestIndex) {
+			best, bestQuality, bestIndex = f, quality, index
+		}
+	}
+	return best
+}
+
+// formatQuality returns the quality of the most specific of the (sorted) media ranges that includes the content type, and the index of that range.
+// Returns a quality of zero if no range includes it.
+func formatQuality(types []*acceptedType, contentType string) (float64, int) {
+	quality, index, specificity := 0.0, len(types), -1
+	for i, t := range types {
+		if !mediaRangeMatches(t.mediaRange, contentType) {
+			continue
+		}
+		if s := mediaRangeSpecificity(t.mediaRange); s > specificity {
+			quality, index, specificity = t.quality, i, s
+		}
+	}
+	return quality, index
+}
+
+// parseAccept parses the Accept header into a list of media ranges, sorted by descending quality (retaining the original order of equal qualities)
+func parseAccept(accept string) []*acceptedType {
+	var types []*acceptedType
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		t := &acceptedType{mediaRange: strings.ToLower(strings.TrimSpace(params[0])), quality: 1.0}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					t.quality = q
+				}
+			}
+		}
+		types = append(types, t)
+	}
+	sort.SliceStable(types, func(i, j int) bool { return types[i].quality > types[j].quality })
+	return types
+}
+
+// mediaRangeMatches returns true if the media range (e.g. image/png, image/* or */*) includes the content type
+func mediaRangeMatches(mediaRange string, contentType string) bool {
+	if mediaRange == "*/*" || mediaRange == contentType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return false
+}
+
+// mediaRangeSpecificity returns 0 for */*, 1 for a range such as image/*, and 2 for a content type
+func mediaRangeSpecificity(mediaRange string) int {
+	if mediaRange == "*/*" {
+		return 0
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return 1
+	}
+	return 2
+}
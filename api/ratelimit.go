@@ -0,0 +1,170 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// maxTrackedClients is the most clients tracked by a rateLimiter. When it is reached, idle clients are forgotten
+const maxTrackedClients = 10000
+
+// rateLimiter limits the rate of requests from each client (identified by api key or ip address) using a tokenBucket per client.
+// Once maxTrackedClients are tracked, and none of them are idle, new clients share the overflow bucket, so that the memory used is bounded
+// however many clients there are (and a client can't escape its limit by forcing its bucket to be forgotten).
+type rateLimiter struct {
+	mutex    sync.Mutex
+	rate     float64
+	burst    float64
+	buckets  map[string]*tokenBucket
+	overflow *tokenBucket
+}
+
+// newRateLimiter creates a rateLimiter allowing a sustained rate of perMinute requests per minute, and bursts of up to burst requests.
+// Returns nil (no limit) if perMinute is zero (or less).
+func newRateLimiter(perMinute int, burst int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	rate := float64(perMinute) / 60.0
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket), overflow: newTokenBucket(rate, float64(burst))}
+}
+
+// bucket returns the tokenBucket for the given client, creating it if necessary, or the overflow bucket if too many clients are tracked
+func (l *rateLimiter) bucket(client string) *tokenBucket {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxTrackedClients {
+			l.forgetIdleClients()
+		}
+		if len(l.buckets) >= maxTrackedClients {
+			return l.overflow
+		}
+		b = newTokenBucket(l.rate, l.burst)
+		l.buckets[client] = b
+	}
+	return b
+}
+
+// forgetIdleClients removes the buckets of clients that have been idle long enough for their bucket to be full, as they are equivalent to a new bucket
+func (l *rateLimiter) forgetIdleClients() {
+	refillTime := time.Duration(l.burst / l.rate * float64(time.Second))
+	t := clock()
+	for client, b := range l.buckets {
+		b.mutex.Lock()
+		idle := t.Sub(b.last)
+		b.mutex.Unlock()
+		if idle >= refillTime {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// limitRate wraps the handler so that requests are rejected with a 429 if the client has exceeded its rate limit.
+// Clients are identified by their api key (which may have its own limit), or by ip address if they have no key.
+func (api *RendererAPI) limitRate(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, _ := r.Context().Value(apiKeyContextKey).(*apiKey)
+		b, client := api.rateLimitBucket(key, clientIP(r, api.trustForwardedFor))
+		if b != nil {
+			if allowed, retryAfter := b.take(); !allowed {
+				rejectRateLimited(w, r, retryAfter, log.Data{"client": client})
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// rateLimitBucket returns the tokenBucket that limits a client (or nil if it isn't limited), and the name of the client.
+// Clients with an api key are identified by the key, and clients without one by their ip address.
+func (api *RendererAPI) rateLimitBucket(key *apiKey, ip string) (*tokenBucket, string) {
+	var b *tokenBucket
+	client := ip
+	if key != nil {
+		client = key.name
+		b = key.limiter
+	}
+	if b == nil && api.limiter != nil {
+		b = api.limiter.bucket(client)
+	}
+	return b, client
+}
+
+// clientIP returns the ip address of the client - the first address in the X-Forwarded-For header if trustForwardedFor, otherwise the remote address of the connection
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); len(forwarded) > 0 {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tokenBucket is a rate limiter that allows bursts of up to burst requests, refilling at rate requests per second
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full tokenBucket
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: clock()}
+}
+
+// clock is the time used by tokenBucket, replaceable in tests
+var clock = time.Now
+
+// take removes a token from the bucket if one is available. If not, it returns false and the time until a token will be available.
+func (b *tokenBucket) take() (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	t := clock()
+	b.tokens = math.Min(b.burst, b.tokens+t.Sub(b.last).Seconds()*b.rate)
+	b.last = t
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / b.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// rejectRateLimited writes a 429 response with a Retry-After header (in whole seconds) and a json error body
+func rejectRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, data log.Data) {
+	seconds := retryAfterSeconds(retryAfter)
+	log.ErrorR(r, errors.New("Rate limit exceeded"), data)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeJSONError(w, http.StatusTooManyRequests, "Rate limit exceeded - retry after "+strconv.Itoa(seconds)+" seconds")
+}
+
+// retryAfterSeconds rounds the time until a rate limited client may retry up to a whole number of seconds (at least one)
+func retryAfterSeconds(retryAfter time.Duration) int {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
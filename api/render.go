@@ -1,12 +1,24 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"errors"
 
+	"github.com/ONSdigital/dp-map-renderer/dataset"
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
 	"github.com/ONSdigital/dp-map-renderer/models"
 	"github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/dp-map-renderer/storage"
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
 	"github.com/ONSdigital/go-ns/log"
 	"github.com/gorilla/mux"
 )
@@ -16,63 +28,343 @@ var (
 	internalError     = "Failed to process the request due to an internal error"
 	badRequest        = "Bad request - Invalid request body"
 	unknownRenderType = "Unknown render type"
+	notAcceptable     = "None of the requested content types can be rendered"
 	statusBadRequest  = "bad request"
+	conversionFailed  = "Failed to convert the map to an image"
+	conversionTimeout = "Timed out converting the map to an image"
+	fetchFailed       = "Failed to fetch the topojson from its url"
+	datasetFailed     = "Failed to fetch the observations of the dataset"
+	storeFailed       = "Failed to store the rendered map"
+)
+
+// The headers of a render response reporting how the data was joined to the regions of the map
+const (
+	joinMatchedRowsHeader         = "X-Join-Matched-Rows"
+	joinUnmatchedRowsHeader       = "X-Join-Unmatched-Rows"
+	joinFeaturesWithoutDataHeader = "X-Join-Features-Without-Data"
+	joinUnmatchedRowSampleHeader  = "X-Join-Unmatched-Row-Sample"
 )
 
 // Content types
 var (
 	contentSVG  = "image/svg+xml"
 	contentHTML = "text/html"
+	contentPNG  = "image/png"
+	contentJSON = "application/json"
+	contentEPS  = "application/postscript"
+	contentPDF  = "application/pdf"
+	contentMVT  = "application/vnd.mapbox-vector-tile"
+	contentZip  = "application/zip"
+	contentKML  = "application/vnd.google-earth.kml+xml"
+	contentKMZ  = "application/vnd.google-earth.kmz"
+	contentTIFF = "image/tiff"
+	contentCSV  = "text/csv"
 )
 
+// renderFunc renders the request in a particular format
+type renderFunc func(context.Context, *models.RenderRequest) ([]byte, error)
+
+// streamFunc renders the request in a particular format, writing it to the writer as it's rendered
+type streamFunc func(context.Context, io.Writer, *models.RenderRequest) error
+
+// renderFormat describes a format in which a map can be rendered. If the format has a stream function, it's used in preference to render.
+type renderFormat struct {
+	render      renderFunc
+	stream      streamFunc
+	contentType string
+	variant     func(*models.RenderRequest) *renderFormat // if set, returns the format a request asks for instead of this one (e.g. with one of its options), or nil
+}
+
+// renderTypes are the formats that can be requested using the render_type path parameter
+var renderTypes = map[string]*renderFormat{
+	"svg":             {render: renderer.RenderHTMLWithSVG, stream: renderer.RenderHTMLWithSVGTo, contentType: contentHTML},
+	"png":             {render: renderer.RenderHTMLWithPNG, stream: renderer.RenderHTMLWithPNGTo, contentType: contentHTML, variant: pngOutput},
+	"json":            {render: renderer.RenderJSON, contentType: contentJSON},
+	"eps":             {render: renderer.RenderEPS, contentType: contentEPS},
+	"pdf":             {render: renderer.RenderPDFAtlas, contentType: contentPDF},
+	"small-multiples": {render: renderer.RenderSmallMultiples, contentType: contentHTML},
+	"comparison":      {render: renderer.RenderComparison, contentType: contentHTML},
+	"difference":      {render: renderer.RenderDifference, contentType: contentHTML},
+	"animated":        {render: renderer.RenderAnimation, contentType: contentHTML},
+	"mvt":             {render: renderer.RenderVectorTile, contentType: contentMVT},
+	"mvt-pyramid":     {render: renderer.RenderVectorTilePyramid, contentType: contentZip},
+	"webmap":          {render: renderer.RenderWebMap, contentType: contentJSON},
+	"kml":             {render: renderer.RenderKML, contentType: contentKML},
+	"kmz":             {render: renderer.RenderKMZ, contentType: contentKMZ},
+	"geotiff":         {render: renderer.RenderGeoTIFF, contentType: contentTIFF},
+	"data-csv":        {render: renderer.RenderDataCSV, contentType: contentCSV},
+	"data-json":       {render: renderer.RenderDataJSON, contentType: contentJSON},
+	"office":          {render: renderer.RenderOfficePNG, contentType: contentPNG},
+}
+
+// pngOutputs are the formats of the png render type that return the image itself, keyed on the request's PNGOutput
+var pngOutputs = map[string]*renderFormat{
+	models.PNGOutputMap:          {render: renderer.RenderPNGImage, contentType: contentPNG},
+	models.PNGOutputMapAndLegend: {render: renderer.RenderPNGImageWithLegend, contentType: contentPNG},
+}
+
+// pngOutput returns the format of the png render type the request asks for with its PNGOutput, or nil for the html figure
+func pngOutput(request *models.RenderRequest) *renderFormat {
+	return pngOutputs[request.PNGOutput]
+}
+
+// acceptableFormats are the formats that can be requested from /render using the Accept header, in order of preference
+var acceptableFormats = []*renderFormat{
+	{render: renderer.RenderHTMLWithSVG, stream: renderer.RenderHTMLWithSVGTo, contentType: contentHTML},
+	{render: renderer.RenderSVGDocument, contentType: contentSVG},
+	{render: renderer.RenderPNGImage, contentType: contentPNG},
+	{render: renderer.RenderJSON, contentType: contentJSON},
+	{render: renderer.RenderEPS, contentType: contentEPS},
+	{render: renderer.RenderPDFAtlas, contentType: contentPDF},
+	{render: renderer.RenderVectorTile, contentType: contentMVT},
+	{render: renderer.RenderKML, contentType: contentKML},
+	{render: renderer.RenderKMZ, contentType: contentKMZ},
+	{render: renderer.RenderGeoTIFF, contentType: contentTIFF},
+	{render: renderer.RenderDataCSV, contentType: contentCSV},
+}
+
 func (api *RendererAPI) renderMap(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	renderType := vars["render_type"]
 
-	log.Debug("renderMap", log.Data{"headers": r.Header, "render_type": renderType})
-	renderRequest, err := models.CreateRenderRequest(r.Body)
+	format, ok := renderTypes[renderType]
+	if !ok {
+		log.ErrorR(r, errors.New("Unknown render type"), log.Data{"render_type": renderType})
+		http.Error(w, unknownRenderType, http.StatusNotFound)
+		return
+	}
+
+	api.render(w, r, renderType, format)
+}
+
+// renderAcceptableMap renders the map in the format that best matches the Accept header
+func (api *RendererAPI) renderAcceptableMap(w http.ResponseWriter, r *http.Request) {
+
+	accept := r.Header.Get("Accept")
+	format := negotiateFormat(accept, acceptableFormats)
+	if format == nil {
+		log.ErrorR(r, errors.New("No acceptable content type"), log.Data{"accept": accept})
+		http.Error(w, notAcceptable, http.StatusNotAcceptable)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+
+	api.render(w, r, format.contentType, format)
+}
+
+// render reads the RenderRequest from the request body and renders it in the given format, returning a cached response if available.
+// renderType distinguishes between formats when generating the etag.
+func (api *RendererAPI) render(w http.ResponseWriter, r *http.Request, renderType string, format *renderFormat) {
+	start := time.Now()
+	logData := log.Data{"render_type": renderType}
+
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Error(err, nil)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.ErrorR(r, err, logData)
+		http.Error(w, models.ErrorReadingBody.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logData["request_size"] = len(body)
+
+	// a request that refers to content to be fetched is never given (or cached by) the etag of its body alone, so only a self-contained request can match it
+	etag := createETag(renderType, body)
+	logData["etag"] = etag
+	if api.writeCachedResponse(w, r, etag, logData) {
 		return
 	}
 
-	if err = renderRequest.ValidateRenderRequest(); err != nil {
-		log.Error(err, nil)
+	renderRequest, err := createRenderRequest(r, body)
+	if err != nil {
+		log.ErrorR(r, err, logData)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	logData["parse_duration"] = time.Since(start).String()
 
-	var bytes []byte
+	join, fetched, err := api.prepareRenderRequest(r.Context(), renderRequest)
+	if err != nil {
+		log.ErrorR(r, err, logData)
+		setErrorCode(w, err)
+		return
+	}
+	if len(fetched) > 0 {
+		// the map depends on the fetched content as well as the body, so a response rendered from other content mustn't be returned
+		etag = createETag(renderType, body, fetched...)
+		logData["etag"] = etag
+		if api.writeCachedResponse(w, r, etag, logData) {
+			return
+		}
+	}
+	logData["topology_arcs"] = len(renderRequest.Geography.Topojson.Arcs)
+	logData["topology_objects"] = len(renderRequest.Geography.Topojson.Objects)
+	logData["data_rows"] = len(renderRequest.Data)
+	logData["unmatched_rows"] = join.UnmatchedRowCount
 
-	switch renderType {
-	case "svg":
-		bytes, err = renderer.RenderHTMLWithSVG(renderRequest)
-		setContentType(w, contentHTML)
-	case "png":
-		bytes, err = renderer.RenderHTMLWithPNG(renderRequest)
-		setContentType(w, contentHTML)
-	default:
-		log.Error(errors.New("Unknown render type"), log.Data{"render_type": renderType})
-		http.Error(w, unknownRenderType, http.StatusNotFound)
+	if format.variant != nil {
+		if variant := format.variant(renderRequest); variant != nil {
+			format = variant
+		}
+	}
+	// a stored response must be rendered in full, so that the url of the object can be returned in its headers
+	if format.stream != nil && !api.stores(format.contentType) {
+		api.stream(w, r, etag, format, renderRequest, join, logData)
 		return
 	}
 
+	renderStart := time.Now()
+	result, err := format.render(r.Context(), renderRequest)
 	if err != nil {
-		log.Error(err, log.Data{})
+		log.ErrorR(r, err, logData)
 		setErrorCode(w, err)
 		return
 	}
+	logData["render_duration"] = time.Since(renderStart).String()
+	logData["response_size"] = len(result)
 
+	response := &cachedResponse{contentType: format.contentType, body: result, join: join}
+	if err = api.storeResponse(r.Context(), response); err != nil {
+		log.ErrorR(r, err, logData)
+		setErrorCode(w, err)
+		return
+	}
+	if len(response.objectURL) > 0 {
+		logData["object_url"] = response.objectURL
+	}
+	log.InfoR(r, "map rendered", logData)
+
+	api.cache.put(etag, response)
+	writeRenderResponse(w, r, etag, response)
+}
+
+// prepareRenderRequest fetches the topology and dataset the request refers to (if any), validates the request, and joins its data to the regions of the map.
+// Returns hashes of the content that was fetched - the topology and the observations of the dataset.
+func (api *RendererAPI) prepareRenderRequest(ctx context.Context, renderRequest *models.RenderRequest) (*models.JoinDiagnostics, []string, error) {
+	var fetched []string
+	topologyHash, err := api.fetchTopology(ctx, renderRequest.Geography)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(topologyHash) > 0 {
+		fetched = append(fetched, topologyHash)
+	}
+	datasetHash, err := api.fetchDataset(ctx, renderRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(datasetHash) > 0 {
+		fetched = append(fetched, datasetHash)
+	}
+	if err := renderRequest.ValidateRenderRequest(); err != nil {
+		return nil, nil, err
+	}
+	renderRequest.MatchDataIDs()
+	renderRequest.DissolveRegions()
+	return renderRequest.JoinDiagnostics(), fetched, nil
+}
+
+// writeCachedResponse writes a not modified response if the If-None-Match header of the request matches the etag, or else the cached response with the etag (if any).
+// Returns false if it wrote neither.
+func (api *RendererAPI) writeCachedResponse(w http.ResponseWriter, r *http.Request, etag string, logData log.Data) bool {
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		log.DebugR(r, "render not modified", logData)
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	if cached := api.cache.get(etag); cached != nil {
+		log.DebugR(r, "render returning cached response", logData)
+		writeRenderResponse(w, r, etag, cached)
+		return true
+	}
+	return false
+}
+
+// stream renders the request in the given format, writing it to the response as it's rendered rather than assembling it in memory.
+// The response is only captured for the cache if the cache is enabled. Errors after the response has started can only be logged.
+func (api *RendererAPI) stream(w http.ResponseWriter, r *http.Request, etag string, format *renderFormat, renderRequest *models.RenderRequest, join *models.JoinDiagnostics, logData log.Data) {
+	renderStart := time.Now()
+	// the status (200) is written with the first part of the body, so a render that fails before writing anything can still return an error
+	writeRenderHeaders(w, etag, format.contentType, join)
+
+	var cached *bytes.Buffer
+	out := &countingWriter{w: w}
+	if api.cache.enabled() {
+		cached = &bytes.Buffer{}
+		out.w = io.MultiWriter(w, cached)
+	}
+	if err := format.stream(r.Context(), out, renderRequest); err != nil {
+		log.ErrorR(r, err, logData)
+		if out.count == 0 {
+			w.Header().Del("ETag")
+			setErrorCode(w, err)
+		}
+		return
+	}
+	logData["render_duration"] = time.Since(renderStart).String()
+	logData["response_size"] = out.count
+	log.InfoR(r, "map rendered", logData)
+
+	if cached != nil {
+		api.cache.put(etag, &cachedResponse{contentType: format.contentType, body: cached.Bytes(), join: join})
+	}
+}
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += n
+	return n, err
+}
+
+// createRenderRequest parses the body as a protocol buffer RenderRequest if the request's Content-Type is application/x-protobuf, otherwise as json
+func createRenderRequest(r *http.Request, body []byte) (*models.RenderRequest, error) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == models.ContentTypeProtobuf {
+		return models.CreateRenderRequestFromProtobuf(bytes.NewReader(body))
+	}
+	return models.CreateRenderRequest(bytes.NewReader(body))
+}
+
+// writeRenderResponse writes the response body with its content type, etag and join headers, and the url at which it was stored
+func writeRenderResponse(w http.ResponseWriter, r *http.Request, etag string, response *cachedResponse) {
+	writeRenderHeaders(w, etag, response.contentType, response.join)
+	if len(response.objectURL) > 0 {
+		w.Header().Set(objectURLHeader, response.objectURL)
+	}
 	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(bytes)
+	_, err := w.Write(response.body)
 	if err != nil {
-		log.Error(err, log.Data{})
+		log.ErrorR(r, err, log.Data{})
 		setErrorCode(w, err)
 		return
 	}
+}
 
+// writeRenderHeaders sets the content type, etag and join headers of a render response
+func writeRenderHeaders(w http.ResponseWriter, etag string, contentType string, join *models.JoinDiagnostics) {
+	setContentType(w, contentType)
+	w.Header().Set("ETag", etag)
+	setJoinHeaders(w, join)
+}
+
+// setJoinHeaders adds headers reporting how the data was joined to the regions of the map, so that clients can check for mismatched ids without parsing the response:
+// the number of matched and unmatched rows, the number of regions without data, and a sample of the ids of unmatched rows (if any)
+func setJoinHeaders(w http.ResponseWriter, join *models.JoinDiagnostics) {
+	if join == nil {
+		return
+	}
+	w.Header().Set(joinMatchedRowsHeader, strconv.Itoa(join.MatchedRowCount))
+	w.Header().Set(joinUnmatchedRowsHeader, strconv.Itoa(join.UnmatchedRowCount))
+	w.Header().Set(joinFeaturesWithoutDataHeader, strconv.Itoa(join.FeaturesWithoutDataCount))
+	if len(join.UnmatchedRowSample) > 0 {
+		w.Header().Set(joinUnmatchedRowSampleHeader, strings.Join(join.UnmatchedRowSample, ","))
+	}
 }
 
 func setContentType(w http.ResponseWriter, contentType string) {
@@ -81,6 +373,35 @@ func setContentType(w http.ResponseWriter, contentType string) {
 
 func setErrorCode(w http.ResponseWriter, err error) {
 	log.Debug("error is", log.Data{"error": err})
+	if _, ok := err.(models.ValidationErrors); ok {
+		writeValidationError(w, err)
+		return
+	}
+	if datasetError, ok := err.(*dataset.Error); ok {
+		// the dataset api rejects unknown datasets, editions, versions and options as client errors
+		if datasetError.Status >= 400 && datasetError.Status < 500 {
+			http.Error(w, datasetError.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, datasetFailed, http.StatusBadGateway)
+		return
+	}
+	if _, ok := err.(*topoutil.FetchError); ok {
+		http.Error(w, fetchFailed, http.StatusBadGateway)
+		return
+	}
+	if _, ok := err.(*storage.PutError); ok {
+		http.Error(w, storeFailed, http.StatusBadGateway)
+		return
+	}
+	if conversionError, ok := err.(*g2s.ConversionError); ok {
+		if conversionError.TimedOut {
+			http.Error(w, conversionTimeout, http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, conversionFailed, http.StatusInternalServerError)
+		return
+	}
 	switch err.Error() {
 	case "Bad request":
 		http.Error(w, "Bad request", http.StatusBadRequest)
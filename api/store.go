@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+
+	"github.com/ONSdigital/dp-map-renderer/storage"
+)
+
+// objectStore persists rendered html, svg and png responses. Nil (the default) if no bucket is configured, in which case nothing is stored
+var objectStore *storage.S3Store
+
+// objectURLHeader is the header of a render response giving the url at which the response was stored
+const objectURLHeader = "X-Object-Url"
+
+// storedExtensions are the extensions of the objects of the content types that are stored, keyed on content type
+var storedExtensions = map[string]string{
+	contentHTML: ".html",
+	contentSVG:  ".svg",
+	contentPNG:  ".png",
+}
+
+// stores returns true if responses of the content type are stored
+func (api *RendererAPI) stores(contentType string) bool {
+	_, ok := storedExtensions[contentType]
+	return api.store != nil && ok
+}
+
+// storeResponse stores the response body, if its content type is stored, setting its objectURL. Returns a storage.PutError if it can't be stored.
+func (api *RendererAPI) storeResponse(ctx context.Context, response *cachedResponse) error {
+	if !api.stores(response.contentType) {
+		return nil
+	}
+	objectURL, err := api.store.Put(ctx, response.body, response.contentType, storedExtensions[response.contentType])
+	if err != nil {
+		return err
+	}
+	response.objectURL = objectURL
+	return nil
+}
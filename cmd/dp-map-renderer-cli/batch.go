@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// extensions are the file extensions of each output format. json output uses .render.json (and webmap .webmap.json, data-json .data.json), so that it is not mistaken for a request.
+var extensions = map[string]string{
+	"html":            ".html",
+	"html-png":        ".html",
+	"svg":             ".svg",
+	"png":             ".png",
+	"json":            ".render.json",
+	"eps":             ".eps",
+	"pdf":             ".pdf",
+	"small-multiples": ".html",
+	"comparison":      ".html",
+	"difference":      ".html",
+	"animated":        ".html",
+	"mvt":             ".pbf",
+	"mvt-pyramid":     ".zip",
+	"webmap":          ".webmap.json",
+	"kml":             ".kml",
+	"kmz":             ".kmz",
+	"geotiff":         ".tif",
+	"data-csv":        ".csv",
+	"data-json":       ".data.json",
+	"office":          ".png",
+}
+
+// requestFiles returns the RenderRequest json files in the directory, with their modification times
+func requestFiles(dir string) (map[string]time.Time, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]time.Time)
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || filepath.Ext(name) != ".json" || strings.HasSuffix(name, extensions["json"]) || strings.HasSuffix(name, extensions["webmap"]) || strings.HasSuffix(name, extensions["data-json"]) {
+			continue
+		}
+		files[filepath.Join(dir, name)] = info.ModTime()
+	}
+	return files, nil
+}
+
+// outputFilename returns the name of the file the map for the request file is written to - the request's filename with the extension of the format
+func outputFilename(requestFile string, format string) string {
+	return strings.TrimSuffix(requestFile, filepath.Ext(requestFile)) + extensions[format]
+}
+
+// renderFile renders the request file to a file alongside it
+func renderFile(requestFile string, format string, render renderFunc) error {
+	f, err := os.Open(requestFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	request, err := models.CreateRenderRequest(f)
+	if err != nil {
+		return err
+	}
+	return renderToFile(request, render, outputFilename(requestFile, format))
+}
+
+// renderFiles renders each of the files (in name order), reporting progress and errors to stderr. Returns the number of files that could not be rendered.
+func renderFiles(files []string, format string, render renderFunc) int {
+	sort.Strings(files)
+	failures := 0
+	for _, file := range files {
+		start := time.Now()
+		if err := renderFile(file, format, render); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			failures++
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s -> %s (%s)\n", file, outputFilename(file, format), time.Since(start).Round(time.Millisecond))
+	}
+	return failures
+}
+
+// renderDirectory renders every request file in the directory, returning an error if any could not be rendered
+func renderDirectory(dir string, format string, render renderFunc) error {
+	files, err := requestFiles(dir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	if failures := renderFiles(names, format, render); failures > 0 {
+		return fmt.Errorf("%d of %d request files could not be rendered", failures, len(names))
+	}
+	return nil
+}
+
+// watchDirectory renders every request file in the directory, then checks the directory at the given interval, rendering files that
+// have been added or modified, until a signal is received on stop. Files that cannot be rendered are reported and retried when next modified.
+func watchDirectory(dir string, format string, render renderFunc, interval time.Duration, stop <-chan os.Signal) error {
+	if interval <= 0 {
+		return errors.New("The watch interval must be greater than zero")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	fmt.Fprintf(os.Stderr, "Watching %s for request files\n", dir)
+	rendered := make(map[string]time.Time)
+	for {
+		files, err := requestFiles(dir)
+		if err != nil {
+			return err
+		}
+		var changed []string
+		for name, modified := range files {
+			if last, ok := rendered[name]; !ok || !modified.Equal(last) {
+				changed = append(changed, name)
+				rendered[name] = modified
+			}
+		}
+		for name := range rendered {
+			if _, ok := files[name]; !ok {
+				delete(rendered, name)
+			}
+		}
+		renderFiles(changed, format, render)
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/renderer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const exampleTitle = "Non-UK born population, Great Britain, 2015"
+
+func TestRenderDirectory(t *testing.T) {
+	Convey("Given a directory of request files", t, func() {
+		dir := t.TempDir()
+		writeRequestFile(t, dir, "a.json", "Map A")
+		writeRequestFile(t, dir, "b.json", "Map B")
+
+		Convey("One map is rendered for each request, alongside it", func() {
+			So(renderDirectory(dir, "svg", renderer.RenderSVGDocument), ShouldBeNil)
+
+			for _, name := range []string{"a.svg", "b.svg"} {
+				b, err := ioutil.ReadFile(filepath.Join(dir, name))
+				So(err, ShouldBeNil)
+				So(string(b), ShouldStartWith, "<svg")
+			}
+			outputs, err := filepath.Glob(filepath.Join(dir, "*.svg"))
+			So(err, ShouldBeNil)
+			So(len(outputs), ShouldEqual, 2)
+		})
+
+		Convey("The maps aren't mistaken for requests when the directory is rendered again", func() {
+			So(renderDirectory(dir, "json", renderer.RenderJSON), ShouldBeNil)
+			So(renderDirectory(dir, "json", renderer.RenderJSON), ShouldBeNil)
+			outputs, err := filepath.Glob(filepath.Join(dir, "*.json"))
+			So(err, ShouldBeNil)
+			So(len(outputs), ShouldEqual, 4)
+		})
+
+		Convey("An invalid request is reported without preventing the other requests from being rendered", func() {
+			So(ioutil.WriteFile(filepath.Join(dir, "0-invalid.json"), []byte(`{"title":`), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, "1-no-geography.json"), []byte(`{"title":"No geography","data":[{"id":"a","value":1}]}`), 0644), ShouldBeNil)
+
+			err := renderDirectory(dir, "svg", renderer.RenderSVGDocument)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "2 of 4 request files could not be rendered")
+
+			for _, name := range []string{"a.svg", "b.svg"} {
+				_, err = os.Stat(filepath.Join(dir, name))
+				So(err, ShouldBeNil)
+			}
+			_, err = os.Stat(filepath.Join(dir, "0-invalid.svg"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+
+	Convey("A missing directory is reported", t, func() {
+		err := renderDirectory(filepath.Join(t.TempDir(), "missing"), "svg", renderer.RenderSVGDocument)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
+
+func TestWatchDirectory(t *testing.T) {
+	Convey("Given a directory being watched", t, func() {
+		dir := t.TempDir()
+		writeRequestFile(t, dir, "a.json", "Map A")
+
+		// the render function reports the title of each request it renders
+		rendered := make(chan string, 10)
+		render := func(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+			rendered <- request.Title
+			return []byte(request.Title), nil
+		}
+		stop := make(chan os.Signal)
+		result := make(chan error, 1)
+		go func() {
+			result <- watchDirectory(dir, "svg", render, 10*time.Millisecond, stop)
+		}()
+
+		So(nextRender(rendered), ShouldEqual, "Map A")
+
+		Convey("A request that is modified is rendered again", func() {
+			writeRequestFile(t, dir, "a.json", "Map A, revised")
+			So(nextRender(rendered), ShouldEqual, "Map A, revised")
+
+			b, err := ioutil.ReadFile(filepath.Join(dir, "a.svg"))
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "Map A, revised")
+		})
+
+		Convey("A request that is added is rendered", func() {
+			writeRequestFile(t, dir, "b.json", "Map B")
+			So(nextRender(rendered), ShouldEqual, "Map B")
+		})
+
+		Convey("A request that can't be rendered is retried when it is modified", func() {
+			So(ioutil.WriteFile(filepath.Join(dir, "c.json"), []byte(`{"title":`), 0644), ShouldBeNil)
+			time.Sleep(50 * time.Millisecond)
+			writeRequestFile(t, dir, "c.json", "Map C")
+			So(nextRender(rendered), ShouldEqual, "Map C")
+		})
+
+		Convey("Unmodified requests are not rendered again", func() {
+			time.Sleep(50 * time.Millisecond)
+			So(len(rendered), ShouldEqual, 0)
+		})
+
+		close(stop)
+		So(<-result, ShouldBeNil)
+	})
+
+	Convey("The watch interval must be greater than zero", t, func() {
+		So(watchDirectory(t.TempDir(), "svg", renderer.RenderSVGDocument, 0, nil), ShouldNotBeNil)
+	})
+}
+
+// writeRequestFile writes the example request, with the given title, to the directory.
+// The file's modification time is moved forward, so that the change is seen even if the file system's timestamps are coarse.
+func writeRequestFile(t *testing.T, dir string, name string, title string) {
+	b, err := ioutil.ReadFile(filepath.Join("..", "..", "testdata", "exampleRequest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, name)
+	modified := time.Now()
+	if info, err := os.Stat(filename); err == nil {
+		modified = info.ModTime().Add(time.Second)
+	}
+	if err = ioutil.WriteFile(filename, bytes.Replace(b, []byte(exampleTitle), []byte(title), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(filename, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextRender returns the title of the next request rendered, or an empty string if none is rendered within a few seconds
+func nextRender(rendered chan string) string {
+	select {
+	case title := <-rendered:
+		return title
+	case <-time.After(5 * time.Second):
+		return ""
+	}
+}
@@ -0,0 +1,280 @@
+// dp-map-renderer-cli renders a map to a file without running the http service, so that maps can be generated in build pipelines.
+//
+// The map is defined either by a RenderRequest json file (-request), or by a topojson file and a csv file of data (-topojson and -csv),
+// in which case the breaks and colours are those suggested by the analyser. Alternatively, every RenderRequest json file in a directory
+// may be rendered (-dir), optionally watching the directory and re-rendering files as they change (-watch).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/analyser"
+	"github.com/ONSdigital/dp-map-renderer/config"
+	"github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/rubenv/topojson"
+)
+
+// renderFunc renders a map in one of the output formats
+type renderFunc func(context.Context, *models.RenderRequest) ([]byte, error)
+
+// formats are the output formats, keyed on the value of the -format flag
+var formats = map[string]renderFunc{
+	"html":            renderer.RenderHTMLWithSVG,
+	"html-png":        renderer.RenderHTMLWithPNG,
+	"svg":             renderer.RenderSVGDocument,
+	"png":             renderer.RenderPNGImage,
+	"json":            renderer.RenderJSON,
+	"eps":             renderer.RenderEPS,
+	"pdf":             renderer.RenderPDFAtlas,
+	"small-multiples": renderer.RenderSmallMultiples,
+	"comparison":      renderer.RenderComparison,
+	"difference":      renderer.RenderDifference,
+	"animated":        renderer.RenderAnimation,
+	"mvt":             renderer.RenderVectorTile,
+	"mvt-pyramid":     renderer.RenderVectorTilePyramid,
+	"webmap":          renderer.RenderWebMap,
+	"kml":             renderer.RenderKML,
+	"kmz":             renderer.RenderKMZ,
+	"geotiff":         renderer.RenderGeoTIFF,
+	"data-csv":        renderer.RenderDataCSV,
+	"data-json":       renderer.RenderDataJSON,
+	"office":          renderer.RenderOfficePNG,
+}
+
+var (
+	requestFile  = flag.String("request", "", "a RenderRequest json file. Use - to read from stdin")
+	topojsonFile = flag.String("topojson", "", "a topojson file (used with -csv instead of -request)")
+	csvFile      = flag.String("csv", "", "a csv file of data to map (used with -topojson instead of -request)")
+	idProperty   = flag.String("id-property", "", "the property of the topojson features that identifies them, or a comma-separated list of properties in order of priority (used with -topojson)")
+	nameProperty = flag.String("name-property", "", "the property of the topojson features that names them (used with -topojson)")
+	idIndex      = flag.Int("id-index", 0, "the index of the csv column containing the feature ids")
+	valueIndex   = flag.Int("value-index", 1, "the index of the csv column containing the values")
+	hasHeader    = flag.Bool("header", true, "whether the csv has a header row")
+	palette      = flag.String("palette", "", "the name of the palette to use (used with -topojson). Defaults to the first palette suggested for the data")
+	classify     = flag.String("classification", "", "how the breaks are calculated (used with -topojson): natural (the default), log, stddev or headtail")
+	legend       = flag.String("legend", models.LegendPositionAfter, "the position of the horizontal legend (used with -topojson)")
+	title        = flag.String("title", "", "the title of the map, overriding the title in the request")
+	subtitle     = flag.String("subtitle", "", "the subtitle of the map, overriding the subtitle in the request")
+	source       = flag.String("source", "", "the source of the data, overriding the source in the request")
+	licence      = flag.String("licence", "", "the licence of the data, overriding the licence in the request")
+	width        = flag.Float64("width", 400, "the width of the map (used with -topojson)")
+	format       = flag.String("format", "html", "the output format: html, html-png, svg, png, json, eps, pdf, small-multiples, comparison, difference, animated, mvt, mvt-pyramid, webmap, kml, kmz, geotiff, data-csv, data-json or office")
+	outFile      = flag.String("out", "-", "the file the map is written to. Use - to write to stdout")
+	dir          = flag.String("dir", "", "a directory of RenderRequest json files to render. Each map is written alongside its request")
+	watch        = flag.Bool("watch", false, "watch the -dir directory, rendering request files when they are added or modified")
+	interval     = flag.Duration("interval", 2*time.Second, "how often the -dir directory is checked for changes when watching")
+	verbose      = flag.Bool("v", false, "log all events to stderr, not just errors")
+)
+
+func main() {
+	log.Namespace = "dp-map-renderer-cli"
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s (-request FILE | -topojson FILE -csv FILE -id-property NAME | -dir DIR [-watch]) [options]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// log events are written to stderr, so that they are not mixed with a map written to stdout
+	log.Event = func(name string, context string, data log.Data) {
+		if *verbose || name == "error" {
+			fmt.Fprintf(os.Stderr, "%s %v\n", name, data)
+		}
+	}
+
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	render, ok := formats[*format]
+	if !ok {
+		return fmt.Errorf("Unknown format '%s'. Must be one of html, html-png, svg, png, json, eps, pdf, small-multiples, comparison, difference, animated, mvt, mvt-pyramid, webmap, kml, kmz, geotiff, data-csv, data-json or office", *format)
+	}
+
+	cfg, err := config.Get()
+	if err != nil {
+		return err
+	}
+	geojson2svg.SetConversionTimeout(cfg.ConversionTimeout)
+	geojson2svg.SetJPEGQuality(cfg.JPEGQuality)
+	pngExecutable, pngArguments := cfg.PNGConverterCommand()
+	pngConverter, err := geojson2svg.NewConverter(cfg.SVG2PNGBackend, geojson2svg.ImageFormatPNG, pngExecutable, pngArguments)
+	if err != nil {
+		return err
+	}
+	renderer.UsePNGConverter(pngConverter)
+	if len(cfg.SVG2WebPExecutable) > 0 {
+		renderer.UseRasterConverter(geojson2svg.ImageFormatWebP, geojson2svg.NewRasterConverter(geojson2svg.ImageFormatWebP, cfg.SVG2WebPExecutable, cfg.SVG2WebPArguments))
+	}
+	if len(cfg.SVG2AVIFExecutable) > 0 {
+		renderer.UseRasterConverter(geojson2svg.ImageFormatAVIF, geojson2svg.NewRasterConverter(geojson2svg.ImageFormatAVIF, cfg.SVG2AVIFExecutable, cfg.SVG2AVIFArguments))
+	}
+	if len(cfg.SVG2JPEGExecutable) > 0 {
+		renderer.UseRasterConverter(geojson2svg.ImageFormatJPEG, geojson2svg.NewRasterConverter(geojson2svg.ImageFormatJPEG, cfg.SVG2JPEGExecutable, cfg.SVG2JPEGArguments))
+	}
+	if len(cfg.SVG2EPSExecutable) > 0 {
+		renderer.UseEPSConverter(geojson2svg.NewRasterConverter("eps", cfg.SVG2EPSExecutable, cfg.SVG2EPSArguments))
+	}
+	if len(cfg.SVG2PDFExecutable) > 0 {
+		renderer.UsePDFConverter(geojson2svg.NewDocumentConverter("pdf", cfg.SVG2PDFExecutable, cfg.SVG2PDFArguments))
+	}
+
+	if len(*dir) > 0 {
+		if *watch {
+			// watch until interrupted
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+			return watchDirectory(*dir, *format, render, *interval, signals)
+		}
+		return renderDirectory(*dir, *format, render)
+	}
+
+	request, err := loadRequest()
+	if err != nil {
+		return err
+	}
+	return renderToFile(request, render, *outFile)
+}
+
+// renderToFile renders the request (overridden by any flags) to the file, or to stdout if the filename is -
+func renderToFile(request *models.RenderRequest, render renderFunc, filename string) error {
+	applyFlags(request)
+	if err := request.ValidateRenderRequest(); err != nil {
+		return err
+	}
+	request.MatchDataIDs()
+	request.DissolveRegions()
+
+	b, err := render(context.Background(), request)
+	if err != nil {
+		return err
+	}
+	return writeOutput(filename, b)
+}
+
+// loadRequest reads the RenderRequest given by -request, or creates one from -topojson and -csv
+func loadRequest() (*models.RenderRequest, error) {
+	switch {
+	case len(*requestFile) > 0:
+		f := os.Stdin
+		if *requestFile != "-" {
+			var err error
+			if f, err = os.Open(*requestFile); err != nil {
+				return nil, err
+			}
+			defer f.Close()
+		}
+		return models.CreateRenderRequest(f)
+	case len(*topojsonFile) > 0 && len(*csvFile) > 0:
+		return createRequestFromCSV(*topojsonFile, *csvFile)
+	default:
+		flag.Usage()
+		return nil, errors.New("Either -request, or -topojson and -csv, must be given")
+	}
+}
+
+// createRequestFromCSV creates a RenderRequest for a choropleth of the data in the csv file, using the breaks and colours suggested by the analyser
+func createRequestFromCSV(topojsonFile string, csvFile string) (*models.RenderRequest, error) {
+	b, err := ioutil.ReadFile(topojsonFile)
+	if err != nil {
+		return nil, err
+	}
+	var topology topojson.Topology
+	if err = json.Unmarshal(b, &topology); err != nil {
+		return nil, fmt.Errorf("Unable to parse topojson file %s: %s", topojsonFile, err)
+	}
+	csv, err := ioutil.ReadFile(csvFile)
+	if err != nil {
+		return nil, err
+	}
+
+	geography := &models.Geography{Topojson: &topology, NameProperty: *nameProperty}
+	if len(*idProperty) > 0 {
+		geography.IDProperty = strings.Split(*idProperty, ",")
+	}
+	analyseRequest := &models.AnalyseRequest{Geography: geography, CSV: string(csv), IDIndex: *idIndex, ValueIndex: *valueIndex, HasHeaderRow: *hasHeader, Classification: *classify}
+	if err = analyseRequest.ValidateAnalyseRequest(); err != nil {
+		return nil, err
+	}
+	analysis, err := analyser.AnalyseData(analyseRequest)
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range analysis.Messages {
+		if message.Level != "info" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", message.Level, message.Text)
+		}
+	}
+	if analysis.DataType != analyser.DataTypeNumeric {
+		return nil, errors.New("Only numeric data can be rendered as a choropleth")
+	}
+
+	colours, err := paletteColours(analysis.SuggestedPalettes, *palette)
+	if err != nil {
+		return nil, err
+	}
+	choropleth := &models.Choropleth{UpperBound: analysis.MaxValue, HorizontalLegendPosition: *legend}
+	for _, breaks := range analysis.Breaks {
+		if len(breaks) == analysis.BestFitClassCount {
+			for i, lowerBound := range breaks {
+				choropleth.Breaks = append(choropleth.Breaks, &models.ChoroplethBreak{LowerBound: lowerBound, Colour: colours[i]})
+			}
+		}
+	}
+
+	filename := strings.TrimSuffix(filepath.Base(csvFile), filepath.Ext(csvFile))
+	return &models.RenderRequest{Filename: filename, Geography: geography, Data: analysis.Data, Choropleth: choropleth, DefaultWidth: *width}, nil
+}
+
+// paletteColours returns the colours of the named palette, or of the first palette if name is empty
+func paletteColours(palettes []*models.Palette, name string) ([]string, error) {
+	names := []string{}
+	for _, p := range palettes {
+		if len(name) == 0 || p.Name == name {
+			return p.Colours, nil
+		}
+		names = append(names, p.Name)
+	}
+	return nil, fmt.Errorf("Unknown palette '%s'. The palettes suggested for this data are: %s", name, strings.Join(names, ", "))
+}
+
+// applyFlags overrides the titles, source and licence of the request with those given as flags
+func applyFlags(request *models.RenderRequest) {
+	if len(*title) > 0 {
+		request.Title = *title
+	}
+	if len(*subtitle) > 0 {
+		request.Subtitle = *subtitle
+	}
+	if len(*source) > 0 {
+		request.Source = *source
+	}
+	if len(*licence) > 0 {
+		request.Licence = *licence
+	}
+}
+
+// writeOutput writes the map to the file, or to stdout if the filename is -
+func writeOutput(filename string, b []byte) error {
+	if filename == "-" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0644)
+}
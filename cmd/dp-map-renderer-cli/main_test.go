@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	topojsonFile, csvFile := writeExampleTopojsonAndCSV(t, dir)
+	request := filepath.Join("..", "..", "testdata", "exampleRequest.json")
+
+	tcs := []struct {
+		name     string
+		args     []string
+		err      string // a substring of the expected error, if any
+		out      string // the file the map is expected to be written to
+		contains string // a substring of the expected map
+	}{
+		{name: "an unknown format is rejected", args: []string{"-format", "gif", "-request", request}, err: "Unknown format 'gif'"},
+		{name: "a request or a topojson and csv is required", args: []string{"-format", "svg"}, err: "Either -request, or -topojson and -csv, must be given"},
+		{name: "a topojson without a csv is rejected", args: []string{"-topojson", topojsonFile}, err: "Either -request, or -topojson and -csv, must be given"},
+		{name: "a missing request file is reported", args: []string{"-request", filepath.Join(dir, "missing.json")}, err: "missing.json"},
+		{name: "the request is rendered to the output file in the format", args: []string{"-request", request, "-format", "svg", "-out", filepath.Join(dir, "request.svg")}, out: "request.svg", contains: "<svg"},
+		{name: "the flags override the titles of the request", args: []string{"-request", request, "-title", "Overridden title", "-subtitle", "Overridden subtitle", "-out", filepath.Join(dir, "request.html")}, out: "request.html", contains: "Overridden subtitle"},
+		{name: "a map is created from the topojson and csv", args: []string{"-topojson", topojsonFile, "-csv", csvFile, "-id-property", "AREACD", "-value-index", "2", "-format", "data-csv", "-out", filepath.Join(dir, "data.csv")}, out: "data.csv", contains: "E06000001"},
+		{name: "an unknown palette is rejected", args: []string{"-topojson", topojsonFile, "-csv", csvFile, "-id-property", "AREACD", "-value-index", "2", "-palette", "tartan"}, err: "Unknown palette 'tartan'"},
+		{name: "a csv that doesn't match the topojson is rejected", args: []string{"-topojson", topojsonFile, "-csv", csvFile, "-value-index", "2"}, err: "id_property"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			Convey(tc.name, t, func() {
+				defer setFlags(t, tc.args...)()
+
+				err := run()
+				if len(tc.err) > 0 {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, tc.err)
+					return
+				}
+				So(err, ShouldBeNil)
+				b, err := ioutil.ReadFile(filepath.Join(dir, tc.out))
+				So(err, ShouldBeNil)
+				So(string(b), ShouldContainSubstring, tc.contains)
+			})
+		})
+	}
+}
+
+func TestApplyFlags(t *testing.T) {
+	Convey("Flags that are set override the request, and flags that aren't leave it unchanged", t, func() {
+		defer setFlags(t, "-title", "Title", "-licence", "Licence")()
+
+		request := &models.RenderRequest{Title: "Original title", Subtitle: "Original subtitle", Source: "Original source"}
+		applyFlags(request)
+		So(request.Title, ShouldEqual, "Title")
+		So(request.Subtitle, ShouldEqual, "Original subtitle")
+		So(request.Source, ShouldEqual, "Original source")
+		So(request.Licence, ShouldEqual, "Licence")
+	})
+}
+
+func TestOutputFilename(t *testing.T) {
+	tcs := []struct {
+		requestFile string
+		format      string
+		expected    string
+	}{
+		{"a.json", "html", "a.html"},
+		{"maps/a.json", "html-png", "maps/a.html"},
+		{"maps/a.json", "svg", "maps/a.svg"},
+		{"maps/a.json", "png", "maps/a.png"},
+		{"maps/a.json", "office", "maps/a.png"},
+		{"maps/a.json", "mvt", "maps/a.pbf"},
+		{"maps/a.json", "mvt-pyramid", "maps/a.zip"},
+		{"maps/a.json", "geotiff", "maps/a.tif"},
+		// json outputs have a second extension, so that they aren't mistaken for requests
+		{"maps/a.json", "json", "maps/a.render.json"},
+		{"maps/a.json", "webmap", "maps/a.webmap.json"},
+		{"maps/a.json", "data-json", "maps/a.data.json"},
+		// only the extension of the request file is replaced
+		{"maps.v2/a.json", "svg", "maps.v2/a.svg"},
+		{"maps/a.b.json", "svg", "maps/a.b.svg"},
+		{"maps/a", "svg", "maps/a.svg"},
+	}
+
+	Convey("The output is written alongside the request, with the extension of the format", t, func() {
+		for _, tc := range tcs {
+			So(outputFilename(tc.requestFile, tc.format), ShouldEqual, tc.expected)
+		}
+	})
+
+	Convey("Every format has an extension", t, func() {
+		for format := range formats {
+			So(extensions[format], ShouldNotBeEmpty)
+		}
+	})
+
+	Convey("Maps of requests with the same name in different directories don't collide", t, func() {
+		So(outputFilename("2015/a.json", "svg"), ShouldNotEqual, outputFilename("2016/a.json", "svg"))
+	})
+}
+
+func TestRequestFiles(t *testing.T) {
+	Convey("Given a directory of request files and rendered maps", t, func() {
+		dir := t.TempDir()
+		for _, name := range []string{"a.json", "b.json", "a.html", "a.render.json", "b.webmap.json", "b.data.json", "notes.txt"} {
+			So(ioutil.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644), ShouldBeNil)
+		}
+		So(os.Mkdir(filepath.Join(dir, "c.json"), 0755), ShouldBeNil)
+
+		Convey("Only the request files are returned, with their modification times", func() {
+			files, err := requestFiles(dir)
+			So(err, ShouldBeNil)
+			var names []string
+			for name, modified := range files {
+				names = append(names, filepath.Base(name))
+				info, err := os.Stat(name)
+				So(err, ShouldBeNil)
+				So(modified, ShouldEqual, info.ModTime())
+			}
+			sort.Strings(names)
+			So(names, ShouldResemble, []string{"a.json", "b.json"})
+		})
+	})
+
+	Convey("A missing directory is reported", t, func() {
+		_, err := requestFiles(filepath.Join(t.TempDir(), "missing"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
+
+// setFlags parses the arguments as the command line, returning a function that restores the flags to their previous values
+// (which must include the flags of the test binary, not just those of the cli)
+func setFlags(t *testing.T, args ...string) func() {
+	previous := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		previous[f.Name] = f.Value.String()
+	})
+	usage := flag.Usage
+	flag.Usage = func() {}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		flag.Usage = usage
+		flag.VisitAll(func(f *flag.Flag) {
+			f.Value.Set(previous[f.Name])
+		})
+	}
+}
+
+// writeExampleTopojsonAndCSV writes the topojson and csv of the example analyse request to files in the directory
+func writeExampleTopojsonAndCSV(t *testing.T, dir string) (string, string) {
+	var request struct {
+		Geography struct {
+			Topojson json.RawMessage `json:"topojson"`
+		} `json:"geography"`
+		CSV string `json:"csv"`
+	}
+	b, err := ioutil.ReadFile(filepath.Join("..", "..", "testdata", "exampleAnalyseRequest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(b, &request); err != nil {
+		t.Fatal(err)
+	}
+	topojsonFile, csvFile := filepath.Join(dir, "example.topojson"), filepath.Join(dir, "example.csv")
+	if err := ioutil.WriteFile(topojsonFile, request.Geography.Topojson, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(csvFile, []byte(strings.TrimSpace(request.CSV)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return topojsonFile, csvFile
+}
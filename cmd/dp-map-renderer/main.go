@@ -10,7 +10,10 @@ import (
 	"github.com/ONSdigital/dp-map-renderer/api"
 	"github.com/ONSdigital/dp-map-renderer/config"
 	"github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/health"
+	"github.com/ONSdigital/dp-map-renderer/rediscache"
 	"github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
 	"github.com/ONSdigital/go-ns/log"
 )
 
@@ -27,36 +30,88 @@ func main() {
 	}
 
 	cfg.Log()
+	log.Info("Starting dp-map-renderer", log.Data{"version": health.Version})
 
 	apiErrors := make(chan error, 1)
 
-	renderer.UsePNGConverter(geojson2svg.NewPNGConverter(cfg.SVG2PNGExecutable, cfg.SVG2PNGArguments))
+	geojson2svg.LimitConcurrentConversions(cfg.MaxConcurrentConversions)
+	geojson2svg.SetConversionTimeout(cfg.ConversionTimeout)
+	geojson2svg.SetJPEGQuality(cfg.JPEGQuality)
+	pngExecutable, pngArguments := cfg.PNGConverterCommand()
+	pngConverter, err := geojson2svg.NewConverter(cfg.SVG2PNGBackend, geojson2svg.ImageFormatPNG, pngExecutable, pngArguments)
+	if err != nil {
+		log.Error(err, nil)
+		os.Exit(1)
+	}
+	renderer.UsePNGConverter(pngConverter)
+	health.RegisterConverter(geojson2svg.ImageFormatPNG, pngConverter)
+	go health.CheckPNGConverter(pngConverter)
+	if len(cfg.SVG2WebPExecutable) > 0 {
+		converter := geojson2svg.NewRasterConverter(geojson2svg.ImageFormatWebP, cfg.SVG2WebPExecutable, cfg.SVG2WebPArguments)
+		renderer.UseRasterConverter(geojson2svg.ImageFormatWebP, converter)
+		health.RegisterConverter(geojson2svg.ImageFormatWebP, converter)
+	}
+	if len(cfg.SVG2AVIFExecutable) > 0 {
+		converter := geojson2svg.NewRasterConverter(geojson2svg.ImageFormatAVIF, cfg.SVG2AVIFExecutable, cfg.SVG2AVIFArguments)
+		renderer.UseRasterConverter(geojson2svg.ImageFormatAVIF, converter)
+		health.RegisterConverter(geojson2svg.ImageFormatAVIF, converter)
+	}
+	if len(cfg.SVG2JPEGExecutable) > 0 {
+		converter := geojson2svg.NewRasterConverter(geojson2svg.ImageFormatJPEG, cfg.SVG2JPEGExecutable, cfg.SVG2JPEGArguments)
+		renderer.UseRasterConverter(geojson2svg.ImageFormatJPEG, converter)
+		health.RegisterConverter(geojson2svg.ImageFormatJPEG, converter)
+	}
+	if len(cfg.SVG2EPSExecutable) > 0 {
+		converter := geojson2svg.NewRasterConverter("eps", cfg.SVG2EPSExecutable, cfg.SVG2EPSArguments)
+		renderer.UseEPSConverter(converter)
+		health.RegisterConverter("eps", converter)
+	}
+	if len(cfg.SVG2PDFExecutable) > 0 {
+		converter := geojson2svg.NewDocumentConverter("pdf", cfg.SVG2PDFExecutable, cfg.SVG2PDFArguments)
+		renderer.UsePDFConverter(converter)
+		health.RegisterConverter("pdf", converter)
+	}
 
-	api.CreateRendererAPI(cfg.BindAddr, cfg.CORSAllowedOrigins, apiErrors)
+	renderer.UseGeographyCache(cfg.GeographyCacheSize)
+	renderer.UseSVGCache(cfg.SVGCacheSize)
+	if len(cfg.RedisAddr) > 0 {
+		// share converted geographies and rendered responses with the other instances of the service
+		shared := rediscache.NewClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, cfg.RedisTimeout, cfg.RedisTTL)
+		renderer.UseSharedCache(shared)
+		api.UseSharedCache(shared)
+	}
 
-	// Gracefully shutdown the application closing any open resources.
-	gracefulShutdown := func() {
+	if cfg.TracingEnabled {
+		tracing.UseTracer(tracing.NewLogTracer())
+	}
+
+	api.CreateRendererAPI(cfg, apiErrors)
+
+	// Gracefully shutdown the application closing any open resources, draining in-flight renders within the shutdown timeout.
+	// Exits with a non-zero code if shutdown was caused by an error or did not complete in time.
+	gracefulShutdown := func(exitCode int) {
 		log.Info(fmt.Sprintf("Shutdown with timeout: %s", cfg.ShutdownTimeout), nil)
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 
 		if err = api.Close(ctx); err != nil {
 			log.Error(err, nil)
+			exitCode = 1
 		}
 
 		cancel()
 
-		log.Info("Shutdown complete", nil)
-		os.Exit(1)
+		log.Info("Shutdown complete", log.Data{"exit_code": exitCode})
+		os.Exit(exitCode)
 	}
 
 	for {
 		select {
 		case err := <-apiErrors:
 			log.ErrorC("api error received", err, nil)
-			gracefulShutdown()
+			gracefulShutdown(1)
 		case <-signals:
 			log.Debug("os signal received", nil)
-			gracefulShutdown()
+			gracefulShutdown(0)
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"time"
 
 	"strings"
@@ -11,12 +12,71 @@ import (
 
 // Config is the configuration for this service
 type Config struct {
-	BindAddr           string        `envconfig:"BIND_ADDR"`
-	CORSAllowedOrigins string        `envconfig:"CORS_ALLOWED_ORIGINS"`
-	ShutdownTimeout    time.Duration `envconfig:"SHUTDOWN_TIMEOUT"`
-	SVG2PNGExecutable  string        `envconfig:"SVG_2_PNG_EXECUTABLE"`
-	SVG2PNGArgLine     string        `envconfig:"SVG_2_PNG_ARG_LINE"`
-	SVG2PNGArguments   []string
+	BindAddr                   string        `envconfig:"BIND_ADDR"`
+	GRPCBindAddr               string        `envconfig:"GRPC_BIND_ADDR"`
+	CORSAllowedOrigins         string        `envconfig:"CORS_ALLOWED_ORIGINS"`
+	ShutdownTimeout            time.Duration `envconfig:"SHUTDOWN_TIMEOUT"`
+	ReadTimeout                time.Duration `envconfig:"READ_TIMEOUT"`
+	WriteTimeout               time.Duration `envconfig:"WRITE_TIMEOUT"`
+	IdleTimeout                time.Duration `envconfig:"IDLE_TIMEOUT"`
+	SVG2PNGExecutable          string        `envconfig:"SVG_2_PNG_EXECUTABLE"`
+	SVG2PNGArgLine             string        `envconfig:"SVG_2_PNG_ARG_LINE"`
+	SVG2PNGBackend             string        `envconfig:"SVG_2_PNG_BACKEND"`
+	SVG2PNGBackendExecutable   string        `envconfig:"SVG_2_PNG_BACKEND_EXECUTABLE"`
+	SVG2WebPExecutable         string        `envconfig:"SVG_2_WEBP_EXECUTABLE"`
+	SVG2WebPArgLine            string        `envconfig:"SVG_2_WEBP_ARG_LINE"`
+	SVG2AVIFExecutable         string        `envconfig:"SVG_2_AVIF_EXECUTABLE"`
+	SVG2AVIFArgLine            string        `envconfig:"SVG_2_AVIF_ARG_LINE"`
+	SVG2JPEGExecutable         string        `envconfig:"SVG_2_JPEG_EXECUTABLE"`
+	SVG2JPEGArgLine            string        `envconfig:"SVG_2_JPEG_ARG_LINE"`
+	JPEGQuality                int           `envconfig:"JPEG_QUALITY"`
+	SVG2EPSExecutable          string        `envconfig:"SVG_2_EPS_EXECUTABLE"`
+	SVG2EPSArgLine             string        `envconfig:"SVG_2_EPS_ARG_LINE"`
+	SVG2PDFExecutable          string        `envconfig:"SVG_2_PDF_EXECUTABLE"`
+	SVG2PDFArgLine             string        `envconfig:"SVG_2_PDF_ARG_LINE"`
+	RenderCacheSize            int           `envconfig:"RENDER_CACHE_SIZE"`
+	GeographyCacheSize         int           `envconfig:"GEOGRAPHY_CACHE_SIZE"`
+	SVGCacheSize               int           `envconfig:"SVG_CACHE_SIZE"`
+	RedisAddr                  string        `envconfig:"REDIS_ADDR"`
+	RedisPassword              string        `envconfig:"REDIS_PASSWORD"`
+	RedisDB                    int           `envconfig:"REDIS_DB"`
+	RedisTimeout               time.Duration `envconfig:"REDIS_TIMEOUT"`
+	RedisTTL                   time.Duration `envconfig:"REDIS_TTL"`
+	MaxConcurrentConversions   int           `envconfig:"MAX_CONCURRENT_CONVERSIONS"`
+	ConversionTimeout          time.Duration `envconfig:"CONVERSION_TIMEOUT"`
+	MaxRequestSize             int64         `envconfig:"MAX_REQUEST_SIZE"`
+	TopojsonURLHosts           []string      `envconfig:"TOPOJSON_URL_HOSTS"`
+	TopojsonURLTimeout         time.Duration `envconfig:"TOPOJSON_URL_TIMEOUT"`
+	TopojsonURLMaxSize         int64         `envconfig:"TOPOJSON_URL_MAX_SIZE"`
+	TopojsonCacheSize          int           `envconfig:"TOPOJSON_CACHE_SIZE"`
+	DatasetAPIURL              string        `envconfig:"DATASET_API_URL"`
+	DatasetAPITimeout          time.Duration `envconfig:"DATASET_API_TIMEOUT"`
+	S3Bucket                   string        `envconfig:"S3_BUCKET"`
+	S3Region                   string        `envconfig:"S3_REGION"`
+	S3Endpoint                 string        `envconfig:"S3_ENDPOINT"`
+	S3KeyPrefix                string        `envconfig:"S3_KEY_PREFIX"`
+	S3Timeout                  time.Duration `envconfig:"S3_TIMEOUT"`
+	AWSAccessKeyID             string        `envconfig:"AWS_ACCESS_KEY_ID"`
+	AWSSecretAccessKey         string        `envconfig:"AWS_SECRET_ACCESS_KEY"`
+	AWSSessionToken            string        `envconfig:"AWS_SESSION_TOKEN"`
+	KafkaAddr                  []string      `envconfig:"KAFKA_ADDR"`
+	KafkaRenderTopic           string        `envconfig:"KAFKA_RENDER_TOPIC"`
+	KafkaRenderedTopic         string        `envconfig:"KAFKA_RENDERED_TOPIC"`
+	KafkaConsumerGroup         string        `envconfig:"KAFKA_CONSUMER_GROUP"`
+	APIKeys                    string        `envconfig:"API_KEYS"`
+	RateLimit                  int           `envconfig:"RATE_LIMIT"`
+	RateLimitBurst             int           `envconfig:"RATE_LIMIT_BURST"`
+	RateLimitTrustForwardedFor bool          `envconfig:"RATE_LIMIT_TRUST_FORWARDED_FOR"`
+	TracingEnabled             bool          `envconfig:"TRACING_ENABLED"`
+	TLSCertFile                string        `envconfig:"TLS_CERT_FILE"`
+	TLSKeyFile                 string        `envconfig:"TLS_KEY_FILE"`
+	TLSClientCAFile            string        `envconfig:"TLS_CLIENT_CA_FILE"`
+	SVG2PNGArguments           []string
+	SVG2WebPArguments          []string
+	SVG2AVIFArguments          []string
+	SVG2JPEGArguments          []string
+	SVG2EPSArguments           []string
+	SVG2PDFArguments           []string
 }
 
 var cfg *Config
@@ -28,27 +88,161 @@ func Get() (*Config, error) {
 	}
 
 	cfg = &Config{
-		BindAddr:           ":23500",
-		CORSAllowedOrigins: "*",
-		ShutdownTimeout:    5 * time.Second,
-		SVG2PNGExecutable:  "rsvg-convert",
-		SVG2PNGArgLine:     "<SVG>|-o|<PNG>",
+		BindAddr:                 ":23500",
+		GRPCBindAddr:             "",
+		CORSAllowedOrigins:       "*",
+		ShutdownTimeout:          30 * time.Second,
+		ReadTimeout:              30 * time.Second,
+		WriteTimeout:             60 * time.Second,
+		IdleTimeout:              120 * time.Second,
+		SVG2PNGExecutable:        "rsvg-convert",
+		SVG2PNGArgLine:           "<SVG>|-o|<PNG>",
+		SVG2PNGBackend:           "command",
+		SVG2WebPArgLine:          "<SVG>|<IMAGE>",
+		SVG2AVIFArgLine:          "<SVG>|<IMAGE>",
+		SVG2JPEGArgLine:          "-quality|<QUALITY>|<SVG>|<IMAGE>",
+		JPEGQuality:              85,
+		SVG2EPSExecutable:        "rsvg-convert",
+		SVG2EPSArgLine:           "-f|eps|-o|<IMAGE>|<SVG>",
+		SVG2PDFExecutable:        "rsvg-convert",
+		SVG2PDFArgLine:           "-f|pdf|-o|<IMAGE>|<SVG>",
+		RenderCacheSize:          100,
+		GeographyCacheSize:       20,
+		SVGCacheSize:             20,
+		RedisTimeout:             time.Second,
+		RedisTTL:                 24 * time.Hour,
+		MaxConcurrentConversions: 4,
+		ConversionTimeout:        30 * time.Second,
+		MaxRequestSize:           50 * 1024 * 1024,
+		TopojsonURLTimeout:       10 * time.Second,
+		TopojsonURLMaxSize:       50 * 1024 * 1024,
+		TopojsonCacheSize:        20,
+		DatasetAPIURL:            "https://api.beta.ons.gov.uk/v1",
+		DatasetAPITimeout:        10 * time.Second,
+		S3Region:                 "eu-west-1",
+		S3Timeout:                30 * time.Second,
+		KafkaRenderTopic:         "map-render-requested",
+		KafkaRenderedTopic:       "map-rendered",
+		KafkaConsumerGroup:       "dp-map-renderer",
+		RateLimitBurst:           10,
+	}
+
+	if err := envconfig.Process("", cfg); err != nil {
+		return cfg, err
 	}
 
 	cfg.SVG2PNGArguments = strings.Split(cfg.SVG2PNGArgLine, "|")
+	cfg.SVG2WebPArguments = strings.Split(cfg.SVG2WebPArgLine, "|")
+	cfg.SVG2AVIFArguments = strings.Split(cfg.SVG2AVIFArgLine, "|")
+	cfg.SVG2JPEGArguments = strings.Split(cfg.SVG2JPEGArgLine, "|")
+	cfg.SVG2EPSArguments = strings.Split(cfg.SVG2EPSArgLine, "|")
+	cfg.SVG2PDFArguments = strings.Split(cfg.SVG2PDFArgLine, "|")
+
+	if err := cfg.validateTLS(); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validateKafka()
+}
+
+// validateTLS checks that the cert and key files are either both set or both empty, and that client certs are only verified when TLS is enabled
+func (cfg *Config) validateTLS() error {
+	if (len(cfg.TLSCertFile) == 0) != (len(cfg.TLSKeyFile) == 0) {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must both be set to enable TLS")
+	}
+	if len(cfg.TLSClientCAFile) > 0 && !cfg.TLSEnabled() {
+		return errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE to be set")
+	}
+	return nil
+}
 
-	return cfg, envconfig.Process("", cfg)
+// validateKafka checks that rendered maps are stored when render requests are consumed from kafka, as the events published when they are rendered give their location
+func (cfg *Config) validateKafka() error {
+	if cfg.KafkaEnabled() && len(cfg.S3Bucket) == 0 {
+		return errors.New("KAFKA_ADDR requires S3_BUCKET to be set")
+	}
+	return nil
+}
+
+// KafkaEnabled returns true if render requests should be consumed from kafka
+func (cfg *Config) KafkaEnabled() bool {
+	return len(cfg.KafkaAddr) > 0
+}
+
+// TLSEnabled returns true if the server should be started with TLS
+func (cfg *Config) TLSEnabled() bool {
+	return len(cfg.TLSCertFile) > 0 && len(cfg.TLSKeyFile) > 0
+}
+
+// PNGConverterCommand returns the executable and arguments of the png converter's backend - SVG2PNGExecutable and SVG2PNGArguments for the command backend,
+// otherwise SVG2PNGBackendExecutable (which, if empty, selects the backend's standard executable) and no arguments
+func (cfg *Config) PNGConverterCommand() (string, []string) {
+	if cfg.SVG2PNGBackend == "command" {
+		return cfg.SVG2PNGExecutable, cfg.SVG2PNGArguments
+	}
+	return cfg.SVG2PNGBackendExecutable, nil
 }
 
 // Log writes all config properties to log.Debug
 func (cfg *Config) Log() {
 	log.Debug("Configuration", log.Data{
-		"BindAddr":           cfg.BindAddr,
-		"CORSAllowedOrigins": cfg.CORSAllowedOrigins,
-		"ShutdownTimeout":    cfg.ShutdownTimeout,
-		"SVG2PNGExecutable":  cfg.SVG2PNGExecutable,
-		"SVG2PNGArgLine":     cfg.SVG2PNGArgLine,
-		"SVG2PNGArguments":   cfg.SVG2PNGArguments,
+		"BindAddr":                   cfg.BindAddr,
+		"GRPCBindAddr":               cfg.GRPCBindAddr,
+		"CORSAllowedOrigins":         cfg.CORSAllowedOrigins,
+		"ShutdownTimeout":            cfg.ShutdownTimeout,
+		"ReadTimeout":                cfg.ReadTimeout,
+		"WriteTimeout":               cfg.WriteTimeout,
+		"IdleTimeout":                cfg.IdleTimeout,
+		"SVG2PNGExecutable":          cfg.SVG2PNGExecutable,
+		"SVG2PNGArgLine":             cfg.SVG2PNGArgLine,
+		"SVG2PNGArguments":           cfg.SVG2PNGArguments,
+		"SVG2PNGBackend":             cfg.SVG2PNGBackend,
+		"SVG2PNGBackendExecutable":   cfg.SVG2PNGBackendExecutable,
+		"SVG2WebPExecutable":         cfg.SVG2WebPExecutable,
+		"SVG2WebPArguments":          cfg.SVG2WebPArguments,
+		"SVG2AVIFExecutable":         cfg.SVG2AVIFExecutable,
+		"SVG2AVIFArguments":          cfg.SVG2AVIFArguments,
+		"SVG2JPEGExecutable":         cfg.SVG2JPEGExecutable,
+		"SVG2JPEGArguments":          cfg.SVG2JPEGArguments,
+		"JPEGQuality":                cfg.JPEGQuality,
+		"SVG2EPSExecutable":          cfg.SVG2EPSExecutable,
+		"SVG2EPSArguments":           cfg.SVG2EPSArguments,
+		"SVG2PDFExecutable":          cfg.SVG2PDFExecutable,
+		"SVG2PDFArguments":           cfg.SVG2PDFArguments,
+		"RenderCacheSize":            cfg.RenderCacheSize,
+		"GeographyCacheSize":         cfg.GeographyCacheSize,
+		"SVGCacheSize":               cfg.SVGCacheSize,
+		"RedisAddr":                  cfg.RedisAddr,
+		"RedisPasswordConfigured":    len(cfg.RedisPassword) > 0,
+		"RedisDB":                    cfg.RedisDB,
+		"RedisTimeout":               cfg.RedisTimeout,
+		"RedisTTL":                   cfg.RedisTTL,
+		"MaxConcurrentConversions":   cfg.MaxConcurrentConversions,
+		"ConversionTimeout":          cfg.ConversionTimeout,
+		"MaxRequestSize":             cfg.MaxRequestSize,
+		"TopojsonURLHosts":           cfg.TopojsonURLHosts,
+		"TopojsonURLTimeout":         cfg.TopojsonURLTimeout,
+		"TopojsonURLMaxSize":         cfg.TopojsonURLMaxSize,
+		"TopojsonCacheSize":          cfg.TopojsonCacheSize,
+		"DatasetAPIURL":              cfg.DatasetAPIURL,
+		"DatasetAPITimeout":          cfg.DatasetAPITimeout,
+		"S3Bucket":                   cfg.S3Bucket,
+		"S3Region":                   cfg.S3Region,
+		"S3Endpoint":                 cfg.S3Endpoint,
+		"S3KeyPrefix":                cfg.S3KeyPrefix,
+		"S3Timeout":                  cfg.S3Timeout,
+		"AWSCredentialsConfigured":   len(cfg.AWSAccessKeyID) > 0,
+		"KafkaAddr":                  cfg.KafkaAddr,
+		"KafkaRenderTopic":           cfg.KafkaRenderTopic,
+		"KafkaRenderedTopic":         cfg.KafkaRenderedTopic,
+		"KafkaConsumerGroup":         cfg.KafkaConsumerGroup,
+		"APIKeysConfigured":          len(cfg.APIKeys) > 0,
+		"RateLimit":                  cfg.RateLimit,
+		"RateLimitBurst":             cfg.RateLimitBurst,
+		"RateLimitTrustForwardedFor": cfg.RateLimitTrustForwardedFor,
+		"TracingEnabled":             cfg.TracingEnabled,
+		"TLSCertFile":                cfg.TLSCertFile,
+		"TLSKeyFile":                 cfg.TLSKeyFile,
+		"TLSClientCAFile":            cfg.TLSClientCAFile,
 	})
 
 }
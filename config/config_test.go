@@ -1,6 +1,7 @@
 package config
 
 import (
+	"os"
 	"testing"
 	"time"
 
@@ -19,8 +20,117 @@ func TestSpec(t *testing.T) {
 
 			Convey("The values should be set to the expected defaults", func() {
 				So(cfg.BindAddr, ShouldEqual, ":23500")
-				So(cfg.ShutdownTimeout, ShouldEqual, 5*time.Second)
+				So(cfg.GRPCBindAddr, ShouldEqual, "")
+				So(cfg.ShutdownTimeout, ShouldEqual, 30*time.Second)
+				So(cfg.ReadTimeout, ShouldEqual, 30*time.Second)
+				So(cfg.WriteTimeout, ShouldEqual, 60*time.Second)
+				So(cfg.IdleTimeout, ShouldEqual, 120*time.Second)
+				So(cfg.SVG2WebPExecutable, ShouldEqual, "")
+				So(cfg.SVG2PNGArguments, ShouldResemble, []string{"<SVG>", "-o", "<PNG>"})
+				So(cfg.SVG2WebPArguments, ShouldResemble, []string{"<SVG>", "<IMAGE>"})
+				So(cfg.SVG2AVIFArguments, ShouldResemble, []string{"<SVG>", "<IMAGE>"})
+				So(cfg.SVG2JPEGArguments, ShouldResemble, []string{"-quality", "<QUALITY>", "<SVG>", "<IMAGE>"})
+				So(cfg.JPEGQuality, ShouldEqual, 85)
+				So(cfg.SVG2EPSArguments, ShouldResemble, []string{"-f", "eps", "-o", "<IMAGE>", "<SVG>"})
+				So(cfg.SVG2PDFArguments, ShouldResemble, []string{"-f", "pdf", "-o", "<IMAGE>", "<SVG>"})
+				So(cfg.GeographyCacheSize, ShouldEqual, 20)
+				So(cfg.SVGCacheSize, ShouldEqual, 20)
+				So(cfg.MaxConcurrentConversions, ShouldEqual, 4)
+				So(cfg.ConversionTimeout, ShouldEqual, 30*time.Second)
+				So(cfg.SVG2PNGBackend, ShouldEqual, "command")
+				So(cfg.TopojsonURLHosts, ShouldBeEmpty)
+				So(cfg.TopojsonURLTimeout, ShouldEqual, 10*time.Second)
+				So(cfg.TopojsonURLMaxSize, ShouldEqual, 50*1024*1024)
+				So(cfg.TopojsonCacheSize, ShouldEqual, 20)
+				So(cfg.DatasetAPIURL, ShouldEqual, "https://api.beta.ons.gov.uk/v1")
+				So(cfg.DatasetAPITimeout, ShouldEqual, 10*time.Second)
+				So(cfg.RedisAddr, ShouldBeEmpty)
+				So(cfg.RedisTimeout, ShouldEqual, time.Second)
+				So(cfg.RedisTTL, ShouldEqual, 24*time.Hour)
+				So(cfg.S3Bucket, ShouldBeEmpty)
+				So(cfg.S3Region, ShouldEqual, "eu-west-1")
+				So(cfg.S3Timeout, ShouldEqual, 30*time.Second)
+				So(cfg.KafkaAddr, ShouldBeEmpty)
+				So(cfg.KafkaEnabled(), ShouldBeFalse)
+				So(cfg.KafkaRenderTopic, ShouldEqual, "map-render-requested")
+				So(cfg.KafkaRenderedTopic, ShouldEqual, "map-rendered")
+				So(cfg.KafkaConsumerGroup, ShouldEqual, "dp-map-renderer")
 			})
 		})
 	})
 }
+
+func TestPNGConverterCommand(t *testing.T) {
+	Convey("The command backend uses the png executable and arguments", t, func() {
+		cfg := &Config{SVG2PNGBackend: "command", SVG2PNGExecutable: "convert", SVG2PNGArguments: []string{"<SVG>", "<PNG>"}, SVG2PNGBackendExecutable: "resvg"}
+		executable, arguments := cfg.PNGConverterCommand()
+		So(executable, ShouldEqual, "convert")
+		So(arguments, ShouldResemble, []string{"<SVG>", "<PNG>"})
+	})
+
+	Convey("Other backends use the backend executable, without arguments", t, func() {
+		cfg := &Config{SVG2PNGBackend: "resvg", SVG2PNGExecutable: "convert", SVG2PNGArguments: []string{"<SVG>", "<PNG>"}, SVG2PNGBackendExecutable: "/opt/resvg"}
+		executable, arguments := cfg.PNGConverterCommand()
+		So(executable, ShouldEqual, "/opt/resvg")
+		So(arguments, ShouldBeNil)
+	})
+}
+
+func TestArgumentsFromEnvironment(t *testing.T) {
+	Convey("Given converter argument lines set in the environment", t, func() {
+		os.Setenv("SVG_2_PNG_ARG_LINE", "-w|1000|<SVG>|<PNG>")
+		os.Setenv("SVG_2_WEBP_ARG_LINE", "-q|80|<SVG>|<IMAGE>")
+		cfg = nil
+		defer func() {
+			os.Unsetenv("SVG_2_PNG_ARG_LINE")
+			os.Unsetenv("SVG_2_WEBP_ARG_LINE")
+			cfg = nil
+		}()
+
+		Convey("The arguments are split from the environment rather than the defaults", func() {
+			config, err := Get()
+			So(err, ShouldBeNil)
+			So(config.SVG2PNGArguments, ShouldResemble, []string{"-w", "1000", "<SVG>", "<PNG>"})
+			So(config.SVG2WebPArguments, ShouldResemble, []string{"-q", "80", "<SVG>", "<IMAGE>"})
+		})
+	})
+}
+
+func TestValidateTLS(t *testing.T) {
+	Convey("TLS config is valid when no files are set", t, func() {
+		cfg := &Config{}
+		So(cfg.validateTLS(), ShouldBeNil)
+		So(cfg.TLSEnabled(), ShouldBeFalse)
+	})
+
+	Convey("TLS config is valid when cert, key and client CA files are set", t, func() {
+		cfg := &Config{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem", TLSClientCAFile: "ca.pem"}
+		So(cfg.validateTLS(), ShouldBeNil)
+		So(cfg.TLSEnabled(), ShouldBeTrue)
+	})
+
+	Convey("TLS config is invalid when only one of cert and key files are set", t, func() {
+		So((&Config{TLSCertFile: "cert.pem"}).validateTLS(), ShouldNotBeNil)
+		So((&Config{TLSKeyFile: "key.pem"}).validateTLS(), ShouldNotBeNil)
+	})
+
+	Convey("TLS config is invalid when a client CA file is set without a cert and key", t, func() {
+		So((&Config{TLSClientCAFile: "ca.pem"}).validateTLS(), ShouldNotBeNil)
+	})
+}
+
+func TestValidateKafka(t *testing.T) {
+	Convey("Kafka config is valid when no brokers are set", t, func() {
+		So((&Config{}).validateKafka(), ShouldBeNil)
+	})
+
+	Convey("Kafka config is valid when brokers and an S3 bucket are set", t, func() {
+		cfg := &Config{KafkaAddr: []string{"localhost:9092"}, S3Bucket: "maps"}
+		So(cfg.validateKafka(), ShouldBeNil)
+		So(cfg.KafkaEnabled(), ShouldBeTrue)
+	})
+
+	Convey("Kafka config is invalid when brokers are set without an S3 bucket", t, func() {
+		So((&Config{KafkaAddr: []string{"localhost:9092"}}).validateKafka(), ShouldNotBeNil)
+	})
+}
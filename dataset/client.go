@@ -0,0 +1,141 @@
+// Package dataset fetches observations from the ONS dataset API, so that a map can be rendered from a published dataset without a service to transform it into data rows
+package dataset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// maxResponseSize is the maximum size (in bytes) of a response from the dataset API. The API returns at most 10,000 observations, which is well within it.
+const maxResponseSize = 20 * 1024 * 1024
+
+// Error describes a failure to fetch observations from the dataset API. Status is the status code of the API's response, or zero if there was no response.
+type Error struct {
+	Status  int
+	Message string
+}
+
+// Error returns the reason the observations couldn't be fetched
+func (e *Error) Error() string {
+	return "Unable to fetch observations from the dataset API: " + e.Message
+}
+
+// Client fetches observations from the ONS dataset API
+type Client struct {
+	apiURL string
+	client *http.Client
+}
+
+// NewClient creates a Client of the dataset API at the given url (e.g. https://api.beta.ons.gov.uk/v1), which fails requests that take longer than the timeout
+func NewClient(apiURL string, timeout time.Duration) *Client {
+	return &Client{apiURL: strings.TrimSuffix(apiURL, "/"), client: &http.Client{Timeout: timeout}}
+}
+
+// observations is the response of the dataset API's observations endpoint
+type observations struct {
+	Observations []*observation `json:"observations"`
+}
+
+// observation is a single observation, with the option of the dimension that was given as a wildcard
+type observation struct {
+	Dimensions  map[string]*option `json:"dimensions"`
+	Observation string             `json:"observation"`
+}
+
+// option is the option of a dimension of an observation
+type option struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// Observations returns a data row for each option of the dataset's geography dimension, with the given option of each of its other dimensions.
+// The id of each row is the code of its geography. An empty observation gives a NaN value (so its region is shown as missing data),
+// and an observation that isn't a number is the row's Marker (such as "x" for suppressed). Returns an Error if the observations can't be fetched.
+func (c *Client) Observations(ctx context.Context, dataset *models.Dataset) ([]*models.DataRow, error) {
+	dimension := dataset.GeographyDimension()
+	query := url.Values{dimension: {"*"}}
+	for name, value := range dataset.Options {
+		query.Set(name, value)
+	}
+	observationsURL := fmt.Sprintf("%s/datasets/%s/editions/%s/versions/%s/observations?%s", c.apiURL,
+		url.PathEscape(dataset.ID), url.PathEscape(dataset.Edition), url.PathEscape(dataset.Version), query.Encode())
+
+	request, err := http.NewRequest("GET", observationsURL, nil)
+	if err != nil {
+		return nil, &Error{Message: err.Error()}
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := c.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, &Error{Message: err.Error()}
+	}
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return nil, &Error{Status: response.StatusCode, Message: err.Error()}
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, &Error{Status: response.StatusCode, Message: fmt.Sprintf("%s %s", response.Status, strings.TrimSpace(string(b)))}
+	}
+
+	var result observations
+	if err = json.Unmarshal(b, &result); err != nil {
+		return nil, &Error{Status: response.StatusCode, Message: err.Error()}
+	}
+	rows := make([]*models.DataRow, 0, len(result.Observations))
+	for _, o := range result.Observations {
+		geography := o.option(dimension)
+		if geography == nil || len(geography.ID) == 0 {
+			continue
+		}
+		rows = append(rows, observationRow(geography.ID, o.Observation))
+	}
+	return rows, nil
+}
+
+// option returns the option of the named dimension of the observation (ignoring case, as the API may use the dimension's label),
+// or of its only dimension if it has no dimension of that name. Nil if it has neither.
+func (o *observation) option(dimension string) *option {
+	if opt, exists := o.Dimensions[dimension]; exists {
+		return opt
+	}
+	names := make([]string, 0, len(o.Dimensions))
+	for name := range o.Dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.EqualFold(name, dimension) {
+			return o.Dimensions[name]
+		}
+	}
+	if len(names) == 1 {
+		return o.Dimensions[names[0]]
+	}
+	return nil
+}
+
+// observationRow returns the data row of the observation of the geography with the given code
+func observationRow(code string, value string) *models.DataRow {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return &models.DataRow{ID: code, Value: math.NaN()}
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return &models.DataRow{ID: code, Value: math.NaN(), Marker: value}
+	}
+	return &models.DataRow{ID: code, Value: f}
+}
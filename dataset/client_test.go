@@ -0,0 +1,73 @@
+package dataset_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/dataset"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const exampleObservations = `{"observations":[
+{"dimensions":{"Geography":{"href":"http://localhost/codes/E06000001","id":"E06000001","label":"Hartlepool"}},"observation":"4.5"},
+{"dimensions":{"Geography":{"href":"http://localhost/codes/E06000002","id":"E06000002","label":"Middlesbrough"}},"observation":"x"},
+{"dimensions":{"Geography":{"href":"http://localhost/codes/E06000003","id":"E06000003","label":"Redcar and Cleveland"}},"observation":""}
+],"total_observations":3}`
+
+func TestObservations(t *testing.T) {
+	Convey("Given a dataset API", t, func() {
+		var requested string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = r.URL.String()
+			if r.URL.Path != "/v1/datasets/cpih01/editions/time-series/versions/6/observations" {
+				http.Error(w, `{"errors":["dataset not found"]}`, http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, exampleObservations)
+		}))
+		defer server.Close()
+		client := dataset.NewClient(server.URL+"/v1/", time.Second)
+		d := &models.Dataset{ID: "cpih01", Edition: "time-series", Version: "6", Options: map[string]string{"time": "Oct-11", "aggregate": "cpih1dim1A0"}}
+
+		Convey("The observation of each geography is returned as a data row", func() {
+			rows, err := client.Observations(context.Background(), d)
+			So(err, ShouldBeNil)
+			So(requested, ShouldEqual, "/v1/datasets/cpih01/editions/time-series/versions/6/observations?aggregate=cpih1dim1A0&geography=%2A&time=Oct-11")
+			So(rows, ShouldHaveLength, 3)
+			So(rows[0], ShouldResemble, &models.DataRow{ID: "E06000001", Value: 4.5})
+			So(rows[1].ID, ShouldEqual, "E06000002")
+			So(rows[1].Marker, ShouldEqual, "x")
+			So(math.IsNaN(rows[1].Value), ShouldBeTrue)
+			So(rows[2].Marker, ShouldBeEmpty)
+			So(math.IsNaN(rows[2].Value), ShouldBeTrue)
+		})
+
+		Convey("The geography dimension may be named", func() {
+			d.Dimension = "geography"
+			_, err := client.Observations(context.Background(), d)
+			So(err, ShouldBeNil)
+			So(requested, ShouldContainSubstring, "geography=%2A")
+		})
+
+		Convey("An unknown dataset is an error with the API's status", func() {
+			d.ID = "unknown"
+			_, err := client.Observations(context.Background(), d)
+			So(err, ShouldHaveSameTypeAs, &dataset.Error{})
+			So(err.(*dataset.Error).Status, ShouldEqual, http.StatusNotFound)
+			So(err.Error(), ShouldContainSubstring, "dataset not found")
+		})
+
+		Convey("A failure to reach the API is an error without a status", func() {
+			server.Close()
+			_, err := client.Observations(context.Background(), d)
+			So(err, ShouldHaveSameTypeAs, &dataset.Error{})
+			So(err.(*dataset.Error).Status, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,382 @@
+// Package geojson2mvt encodes geojson featurecollections as Mapbox Vector Tiles (https://github.com/mapbox/vector-tile-spec),
+// projecting longitude/latitude coordinates into the spherical mercator tile grid used by slippy maps.
+//
+// Only polygon and multipolygon geometries are encoded. Geometries are not clipped to the tile - clients clip when rendering.
+package geojson2mvt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// Extent is the size of a tile in tile coordinates
+const Extent = 4096
+
+// MaxZoom is the highest zoom level of a tile returned by TileContaining
+const MaxZoom = 14
+
+// maxLatitude is the latitude beyond which the mercator projection is truncated
+const maxLatitude = 85.0511287798
+
+// buffer is the distance (as a fraction of a tile) beyond the edge of a tile within which features are included in the tile
+const buffer = 64.0 / Extent
+
+// geometry commands
+const (
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+// geomTypePolygon is the geometry type of a polygon feature
+const geomTypePolygon = 3
+
+// ErrInvalidTile is returned by ParseTile for a string that does not describe a tile
+var ErrInvalidTile = errors.New("tile must be in the form z/x/y, with x and y less than 2^z")
+
+// Tile identifies a tile by its zoom level and x, y position in the tile grid
+type Tile struct {
+	Z, X, Y int
+}
+
+// String returns the tile in the form z/x/y
+func (t Tile) String() string {
+	return fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)
+}
+
+// Valid returns true if the tile's position is within the grid at its zoom level
+func (t Tile) Valid() bool {
+	n := 1 << uint(t.Z)
+	return t.Z >= 0 && t.Z <= 30 && t.X >= 0 && t.X < n && t.Y >= 0 && t.Y < n
+}
+
+// Children returns the four tiles at the next zoom level that cover this tile
+func (t Tile) Children() []Tile {
+	z, x, y := t.Z+1, t.X*2, t.Y*2
+	return []Tile{{z, x, y}, {z, x + 1, y}, {z, x, y + 1}, {z, x + 1, y + 1}}
+}
+
+// ParseTile parses a tile in the form z/x/y
+func ParseTile(s string) (Tile, error) {
+	var t Tile
+	var rest string
+	if n, _ := fmt.Sscanf(s+" ", "%d/%d/%d%s", &t.Z, &t.X, &t.Y, &rest); n != 3 || !t.Valid() {
+		return t, ErrInvalidTile
+	}
+	return t, nil
+}
+
+// TileContaining returns the highest zoom tile (up to MaxZoom) that contains the whole of the featurecollection
+func TileContaining(fc *geojson.FeatureCollection) Tile {
+	minLon, minLat, maxLon, maxLat, ok := Bounds(fc)
+	if !ok {
+		return Tile{}
+	}
+	for z := MaxZoom; z > 0; z-- {
+		x1, y1 := project(minLon, maxLat, z)
+		x2, y2 := project(maxLon, minLat, z)
+		if int(x1) == int(x2) && int(y1) == int(y2) {
+			return Tile{Z: z, X: int(x1), Y: int(y1)}
+		}
+	}
+	return Tile{}
+}
+
+// Intersects returns true if the bounding box of any polygon in the featurecollection overlaps the tile (including its buffer)
+func Intersects(fc *geojson.FeatureCollection, tile Tile) bool {
+	for _, feature := range fc.Features {
+		if featureIntersects(feature, tile) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the minimum and maximum longitude and latitude of the polygons in the featurecollection. ok is false if there are none.
+func Bounds(fc *geojson.FeatureCollection) (minLon, minLat, maxLon, maxLat float64, ok bool) {
+	minLon, minLat, maxLon, maxLat = math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, feature := range fc.Features {
+		for _, polygon := range polygons(feature.Geometry) {
+			for _, ring := range polygon {
+				for _, p := range ring {
+					minLon, maxLon = math.Min(minLon, p[0]), math.Max(maxLon, p[0])
+					minLat, maxLat = math.Min(minLat, p[1]), math.Max(maxLat, p[1])
+					ok = true
+				}
+			}
+		}
+	}
+	return
+}
+
+// Encode returns a vector tile containing a single layer with the given name, holding each polygon feature of the featurecollection that intersects the tile.
+// Feature properties are encoded as tags - strings, numbers and booleans are encoded as such, other values are formatted as strings.
+func Encode(layerName string, fc *geojson.FeatureCollection, tile Tile) []byte {
+	l := &layer{keyIndex: make(map[string]int), valueIndex: make(map[interface{}]int)}
+	layerMessage := &encoder{}
+	layerMessage.varintField(15, 2)
+	layerMessage.bytesField(1, []byte(layerName))
+	for _, feature := range fc.Features {
+		if !featureIntersects(feature, tile) {
+			continue
+		}
+		geometry := encodeGeometry(polygons(feature.Geometry), tile)
+		if len(geometry) == 0 {
+			continue
+		}
+		f := &encoder{}
+		f.packedField(2, l.tags(feature.Properties))
+		f.varintField(3, geomTypePolygon)
+		f.packedField(4, geometry)
+		layerMessage.bytesField(2, f.buf)
+	}
+	for _, key := range l.keys {
+		layerMessage.bytesField(3, []byte(key))
+	}
+	for _, value := range l.values {
+		layerMessage.bytesField(4, encodeValue(value))
+	}
+	layerMessage.varintField(5, Extent)
+
+	tileMessage := &encoder{}
+	tileMessage.bytesField(3, layerMessage.buf)
+	return tileMessage.buf
+}
+
+// layer holds the keys and values of the tags of a layer's features
+type layer struct {
+	keys       []string
+	keyIndex   map[string]int
+	values     []interface{}
+	valueIndex map[interface{}]int
+}
+
+// tags returns the key and value indexes of the properties, in order of property name
+func (l *layer) tags(properties map[string]interface{}) []uint32 {
+	names := make([]string, 0, len(properties))
+	for name, value := range properties {
+		if value != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	tags := make([]uint32, 0, len(names)*2)
+	for _, name := range names {
+		tags = append(tags, uint32(l.key(name)), uint32(l.value(properties[name])))
+	}
+	return tags
+}
+
+func (l *layer) key(key string) int {
+	i, exists := l.keyIndex[key]
+	if !exists {
+		i = len(l.keys)
+		l.keys = append(l.keys, key)
+		l.keyIndex[key] = i
+	}
+	return i
+}
+
+func (l *layer) value(value interface{}) int {
+	switch v := value.(type) {
+	case string, float64, bool:
+	case int:
+		value = float64(v)
+	case float32:
+		value = float64(v)
+	default:
+		value = fmt.Sprintf("%v", v)
+	}
+	i, exists := l.valueIndex[value]
+	if !exists {
+		i = len(l.values)
+		l.values = append(l.values, value)
+		l.valueIndex[value] = i
+	}
+	return i
+}
+
+// encodeValue encodes a tag value as a Value message
+func encodeValue(value interface{}) []byte {
+	e := &encoder{}
+	switch v := value.(type) {
+	case string:
+		e.key(1, 2)
+		e.varint(uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	case float64:
+		e.key(3, 1)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		e.buf = append(e.buf, b[:]...)
+	case bool:
+		e.key(7, 0)
+		if v {
+			e.varint(1)
+		} else {
+			e.varint(0)
+		}
+	}
+	return e.buf
+}
+
+// encodeGeometry returns the geometry commands that draw the polygons in the tile's coordinates.
+// Exterior rings are wound clockwise and interior rings anti-clockwise (in tile coordinates, where y increases downwards), as the specification requires.
+// Rings that collapse to fewer than three points (or no area) at the tile's resolution are omitted, along with the holes of omitted exterior rings.
+func encodeGeometry(polygons [][][][]float64, tile Tile) []uint32 {
+	var commands []uint32
+	var cursorX, cursorY int
+	for _, polygon := range polygons {
+		for i, ring := range polygon {
+			points := tileCoordinates(ring, tile)
+			if len(points) < 3 || area(points) == 0 {
+				if i == 0 {
+					break
+				}
+				continue
+			}
+			if exterior := i == 0; (area(points) < 0) == exterior {
+				reverse(points)
+			}
+			commands = append(commands, command(cmdMoveTo, 1))
+			commands = append(commands, zigzag(points[0][0]-cursorX), zigzag(points[0][1]-cursorY))
+			cursorX, cursorY = points[0][0], points[0][1]
+			commands = append(commands, command(cmdLineTo, len(points)-1))
+			for _, p := range points[1:] {
+				commands = append(commands, zigzag(p[0]-cursorX), zigzag(p[1]-cursorY))
+				cursorX, cursorY = p[0], p[1]
+			}
+			commands = append(commands, command(cmdClosePath, 1))
+		}
+	}
+	return commands
+}
+
+// tileCoordinates projects the ring into integer tile coordinates, removing consecutive duplicate points and the closing point
+func tileCoordinates(ring [][]float64, tile Tile) [][2]int {
+	points := make([][2]int, 0, len(ring))
+	for _, p := range ring {
+		x, y := project(p[0], p[1], tile.Z)
+		point := [2]int{int(math.Floor((x-float64(tile.X))*Extent + .5)), int(math.Floor((y-float64(tile.Y))*Extent + .5))}
+		if len(points) > 0 && points[len(points)-1] == point {
+			continue
+		}
+		points = append(points, point)
+	}
+	if len(points) > 1 && points[0] == points[len(points)-1] {
+		points = points[:len(points)-1]
+	}
+	return points
+}
+
+// area returns the signed area of the ring using the surveyor's formula - positive if the ring is clockwise in tile coordinates
+func area(points [][2]int) int {
+	sum := 0
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		sum += p[0]*q[1] - q[0]*p[1]
+	}
+	return sum
+}
+
+func reverse(points [][2]int) {
+	for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+		points[i], points[j] = points[j], points[i]
+	}
+}
+
+// project converts the longitude and latitude into (fractional) tile grid coordinates at the given zoom level
+func project(longitude, latitude float64, zoom int) (float64, float64) {
+	n := float64(int(1) << uint(zoom))
+	latitude = math.Max(-maxLatitude, math.Min(maxLatitude, latitude))
+	latRad := latitude * math.Pi / 180
+	x := (longitude + 180) / 360 * n
+	y := (1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n
+	return x, y
+}
+
+// featureIntersects returns true if the bounding box of any of the feature's polygons overlaps the tile and its buffer
+func featureIntersects(feature *geojson.Feature, tile Tile) bool {
+	for _, polygon := range polygons(feature.Geometry) {
+		if len(polygon) == 0 || len(polygon[0]) == 0 {
+			continue
+		}
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, p := range polygon[0] {
+			x, y := project(p[0], p[1], tile.Z)
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+		if maxX >= float64(tile.X)-buffer && minX <= float64(tile.X+1)+buffer &&
+			maxY >= float64(tile.Y)-buffer && minY <= float64(tile.Y+1)+buffer {
+			return true
+		}
+	}
+	return false
+}
+
+// polygons returns the polygons of a polygon or multipolygon geometry, or nil for any other geometry
+func polygons(g *geojson.Geometry) [][][][]float64 {
+	if g == nil {
+		return nil
+	}
+	switch g.Type {
+	case geojson.GeometryPolygon:
+		return [][][][]float64{g.Polygon}
+	case geojson.GeometryMultiPolygon:
+		return g.MultiPolygon
+	}
+	return nil
+}
+
+func command(id int, count int) uint32 {
+	return uint32(id&0x7 | count<<3)
+}
+
+func zigzag(n int) uint32 {
+	return uint32((int32(n) << 1) ^ (int32(n) >> 31))
+}
+
+// encoder writes protocol buffer fields
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) key(field int, wireType int) {
+	e.varint(uint64(field<<3 | wireType))
+}
+
+func (e *encoder) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *encoder) varintField(field int, v uint64) {
+	e.key(field, 0)
+	e.varint(v)
+}
+
+func (e *encoder) bytesField(field int, b []byte) {
+	e.key(field, 2)
+	e.varint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// packedField writes the values as a packed repeated field
+func (e *encoder) packedField(field int, values []uint32) {
+	if len(values) == 0 {
+		return
+	}
+	packed := &encoder{}
+	for _, v := range values {
+		packed.varint(uint64(v))
+	}
+	e.bytesField(field, packed.buf)
+}
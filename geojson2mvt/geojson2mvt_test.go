@@ -0,0 +1,67 @@
+package geojson2mvt_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/geojson2mvt"
+	"github.com/paulmach/go.geojson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// square is a polygon whose corners are at the centre and 3/4 right, 1/4 down of tile 0/0/0. Wound anti-clockwise (in longitude/latitude).
+var square = [][][]float64{{{0, 0}, {90, 0}, {90, 66.51326044311186}, {0, 66.51326044311186}, {0, 0}}}
+
+func TestParseTile(t *testing.T) {
+	Convey("ParseTile should parse z/x/y", t, func() {
+		tile, err := geojson2mvt.ParseTile("3/2/5")
+		So(err, ShouldBeNil)
+		So(tile, ShouldResemble, geojson2mvt.Tile{Z: 3, X: 2, Y: 5})
+		So(tile.String(), ShouldEqual, "3/2/5")
+	})
+
+	Convey("ParseTile should reject tiles outside the grid, or not in the form z/x/y", t, func() {
+		for _, s := range []string{"3/8/0", "3/0/-1", "-1/0/0", "3/2", "3/2/5/1", "3/2/5x", "a/b/c", ""} {
+			_, err := geojson2mvt.ParseTile(s)
+			So(err, ShouldEqual, geojson2mvt.ErrInvalidTile)
+		}
+	})
+}
+
+func TestTileContaining(t *testing.T) {
+	Convey("TileContaining should return the smallest tile containing all the features", t, func() {
+		fc := geojson.NewFeatureCollection()
+		fc.AddFeature(geojson.NewPolygonFeature([][][]float64{{{-1, 51}, {-0.5, 51}, {-0.5, 51.5}, {-1, 51}}}))
+		So(geojson2mvt.TileContaining(fc), ShouldResemble, geojson2mvt.Tile{Z: 8, X: 127, Y: 85})
+
+		fc.AddFeature(geojson.NewPolygonFeature(square))
+		So(geojson2mvt.TileContaining(fc), ShouldResemble, geojson2mvt.Tile{})
+	})
+}
+
+func TestEncode(t *testing.T) {
+	Convey("Given a featurecollection with a polygon", t, func() {
+		fc := geojson.NewFeatureCollection()
+		feature := geojson.NewPolygonFeature(square)
+		feature.SetProperty("name", "square")
+		fc.AddFeature(feature)
+
+		Convey("Encode should write the layer name, the property and the polygon's geometry wound clockwise", func() {
+			tile := geojson2mvt.Encode("regions", fc, geojson2mvt.Tile{})
+
+			So(bytes.Contains(tile, []byte("\x0a\x07regions")), ShouldBeTrue)
+			So(bytes.Contains(tile, []byte("\x1a\x04name")), ShouldBeTrue)
+			So(bytes.Contains(tile, []byte("\x22\x08\x0a\x06square")), ShouldBeTrue)
+			// MoveTo(2048, 1024) LineTo(+1024, 0) (0, +1024) (-1024, 0) ClosePath
+			geometry := []byte{0x22, 0x10, 9, 0x80, 0x20, 0x80, 0x10, 26, 0x80, 0x10, 0, 0, 0x80, 0x10, 0xff, 0x0f, 0, 15}
+			So(bytes.Contains(tile, geometry), ShouldBeTrue)
+		})
+
+		Convey("Encode should omit features outside the tile", func() {
+			tile := geojson2mvt.Encode("regions", fc, geojson2mvt.Tile{Z: 2, X: 0, Y: 3})
+			So(bytes.Contains(tile, []byte("square")), ShouldBeFalse)
+			So(geojson2mvt.Intersects(fc, geojson2mvt.Tile{Z: 1, X: 1, Y: 0}), ShouldBeTrue)
+			So(geojson2mvt.Intersects(fc, geojson2mvt.Tile{Z: 2, X: 0, Y: 3}), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,86 @@
+package geojson2svg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The names of the backends that may convert svgs to images
+const (
+	BackendCommand  = "command"      // any executable, invoked with configured arguments
+	BackendRSVG     = "rsvg-convert" // librsvg's rsvg-convert
+	BackendResvg    = "resvg"        // the resvg command line tool
+	BackendChromium = "chromium"     // headless Chromium (or Chrome), driven through the DevTools protocol
+	BackendGo       = "go"           // a rasteriser running in process, without any external dependency
+)
+
+// Backends lists the supported backends
+var Backends = []string{BackendCommand, BackendRSVG, BackendResvg, BackendChromium, BackendGo}
+
+// Backend converts svgs to an image or document - by invoking an external command, or in process
+type Backend interface {
+	// ConvertPages converts the given svg files to a single image or document in the given format, returning it unencoded.
+	// Executables invoked by the backend are killed when the context is done.
+	ConvertPages(ctx context.Context, format string, pages [][]byte) ([]byte, error)
+	// Capabilities describes what the backend can convert
+	Capabilities() Capabilities
+}
+
+// Capabilities describes a backend - the formats it can produce and the parts of an svg it can render
+type Capabilities struct {
+	Backend    string   `json:"backend"`
+	Executable string   `json:"executable,omitempty"` // the executable invoked by the backend, if any
+	Formats    []string `json:"formats,omitempty"`    // the formats the backend can produce. Empty if they depend on the executable (i.e. for the command backend)
+	MultiPage  bool     `json:"multi_page"`           // true if the backend can convert several svgs to a single document (e.g. a pdf)
+	Text       bool     `json:"text"`                 // true if text, e.g. the legend labels, is rendered
+	Patterns   bool     `json:"patterns"`             // true if pattern fills (e.g. for missing data) are rendered, rather than filled in a single colour
+}
+
+// SupportsFormat returns true if the backend can produce the format, or may do so (if its formats depend on its executable)
+func (c Capabilities) SupportsFormat(format string) bool {
+	if len(c.Formats) == 0 {
+		return true
+	}
+	for _, f := range c.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// NewBackend returns the named backend (one of Backends).
+// The command backend invokes the given executable with the given arguments, as described by NewRasterConverter - the executable is required.
+// The other backends ignore the arguments, and invoke the given executable in place of their standard one (e.g. resvg, found on the PATH) if it isn't empty.
+func NewBackend(name string, executable string, arguments []string) (Backend, error) {
+	switch name {
+	case BackendCommand:
+		if len(executable) == 0 {
+			return nil, errors.New("the command backend requires an executable")
+		}
+		return NewCommandBackend(executable, arguments), nil
+	case BackendRSVG:
+		return &commandBackend{executable: executableOrDefault(executable, "rsvg-convert"),
+			arguments:    []string{"-f", ArgFormat, "-o", ArgImageFilename, ArgSVGFilename},
+			capabilities: Capabilities{Backend: BackendRSVG, Formats: []string{ImageFormatPNG, "pdf", "eps"}, MultiPage: true, Text: true, Patterns: true}}, nil
+	case BackendResvg:
+		return &commandBackend{executable: executableOrDefault(executable, "resvg"),
+			arguments:    []string{ArgSVGFilename, ArgImageFilename},
+			capabilities: Capabilities{Backend: BackendResvg, Formats: []string{ImageFormatPNG}, Text: true, Patterns: true}}, nil
+	case BackendChromium:
+		return &chromiumBackend{executable: executableOrDefault(executable, "chromium")}, nil
+	case BackendGo:
+		return &goBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown converter backend '%s'. Must be one of %s", name, strings.Join(Backends, ", "))
+}
+
+// executableOrDefault returns the executable, or the default if it is empty
+func executableOrDefault(executable string, defaultExecutable string) string {
+	if len(executable) == 0 {
+		return defaultExecutable
+	}
+	return executable
+}
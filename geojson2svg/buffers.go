@@ -0,0 +1,26 @@
+package geojson2svg
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize is the capacity above which a buffer isn't returned to the pool, so that drawing one very large map doesn't hold on to its memory
+const maxPooledBufferSize = 8 << 20
+
+// buffers is a pool of buffers reused between draws, to reduce garbage collection under concurrent load
+var buffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// getBuffer returns an empty buffer from the pool. Return it with putBuffer once its content has been copied.
+func getBuffer() *bytes.Buffer {
+	b := buffers.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+// putBuffer returns the buffer to the pool, unless it has grown larger than maxPooledBufferSize
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledBufferSize {
+		buffers.Put(b)
+	}
+}
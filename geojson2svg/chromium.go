@@ -0,0 +1,263 @@
+package geojson2svg
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+	"github.com/gorilla/websocket"
+	"github.com/mailru/easyjson"
+)
+
+// devToolsListening precedes the url of the browser's DevTools websocket in the output of Chromium
+const devToolsListening = "DevTools listening on "
+
+// pageLoadEventFired is the DevTools event sent when a page has loaded
+const pageLoadEventFired = "Page.loadEventFired"
+
+// maxDevToolsMessage is the largest message read from a DevTools websocket - large enough for a screenshot of a big map
+const maxDevToolsMessage = 256 << 20
+
+// chromiumBackend converts an svg to an image by loading it into headless Chromium and taking a screenshot through the DevTools protocol.
+// A browser is started for each conversion, so that a browser that crashes or hangs only affects that conversion.
+type chromiumBackend struct {
+	executable string
+}
+
+// Capabilities describes the backend
+func (b *chromiumBackend) Capabilities() Capabilities {
+	return Capabilities{Backend: BackendChromium, Executable: b.executable, Formats: []string{ImageFormatPNG, ImageFormatWebP, ImageFormatJPEG}, Text: true, Patterns: true}
+}
+
+// ConvertPages converts a single svg to a png, webp or jpeg screenshot of the svg, at the size given by its width and height (or viewBox).
+// Any error is a *ConversionError.
+func (b *chromiumBackend) ConvertPages(ctx context.Context, format string, pages [][]byte) ([]byte, error) {
+	if len(pages) != 1 {
+		return nil, b.conversionError(format, "", errors.New("chromium converts a single svg at a time"))
+	}
+	profile, err := ioutil.TempDir("", "chromium")
+	if err != nil {
+		return nil, b.conversionError(format, "", err)
+	}
+	defer os.RemoveAll(profile)
+
+	cmd := exec.CommandContext(ctx, b.executable, "--headless", "--disable-gpu", "--hide-scrollbars", "--no-first-run",
+		"--remote-debugging-port=0", "--user-data-dir="+profile, "about:blank")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, b.conversionError(format, "", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, b.conversionError(format, "", err)
+	}
+	output := bufio.NewReader(stderr)
+	browserURL, started, err := devToolsURL(output)
+	drained := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, output)
+		close(drained)
+	}()
+	defer func() {
+		cmd.Process.Kill()
+		<-drained
+		cmd.Wait()
+	}()
+	if err != nil {
+		log.Error(err, log.Data{"Command": b.executable, "stderr": started})
+		return nil, b.conversionError(format, started, err)
+	}
+
+	image, err := screenshot(ctx, browserURL, pages[0], format)
+	if err != nil {
+		log.Error(err, log.Data{"Command": b.executable, "timedOut": ctx.Err() == context.DeadlineExceeded})
+		return nil, b.conversionError(format, "", err)
+	}
+	return image, nil
+}
+
+// conversionError returns a ConversionError of the backend
+func (b *chromiumBackend) conversionError(format string, stderr string, err error) *ConversionError {
+	return &ConversionError{Backend: BackendChromium, Executable: b.executable, Format: format, Stderr: stderr, Err: err}
+}
+
+// devToolsURL reads the output of Chromium until it reports the url of its DevTools websocket, returning the url and the output read
+func devToolsURL(r *bufio.Reader) (string, string, error) {
+	var output strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		output.WriteString(line)
+		if i := strings.Index(line, devToolsListening); i >= 0 {
+			return strings.TrimSpace(line[i+len(devToolsListening):]), output.String(), nil
+		}
+		if err != nil {
+			return "", output.String(), errors.New("chromium exited without starting DevTools")
+		}
+	}
+}
+
+// screenshot loads the svg into a page of the browser with the given DevTools url, returning a screenshot of it in the given format
+func screenshot(ctx context.Context, browserURL string, svg []byte, format string) ([]byte, error) {
+	pageURL, err := devToolsPage(ctx, browserURL)
+	if err != nil {
+		return nil, err
+	}
+	// the browser is local, so it is never reached through a proxy (as websocket.DefaultDialer would)
+	var dialer websocket.Dialer
+	conn, _, err := dialer.DialContext(ctx, pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+		conn.SetWriteDeadline(deadline)
+	}
+	conn.SetReadLimit(maxDevToolsMessage)
+	target := &devTools{conn: conn, events: make(map[string]bool)}
+	ctx = cdp.WithExecutor(ctx, target)
+
+	width, height := svgSize(svg)
+	if err := emulation.SetDeviceMetricsOverride(int64(width), int64(height), 1, false).Do(ctx); err != nil {
+		return nil, err
+	}
+	// a transparent page, as for the other backends - a background is drawn by the svg itself (see AddBackground)
+	if err := emulation.SetDefaultBackgroundColorOverride().WithColor(&cdp.RGBA{}).Do(ctx); err != nil {
+		return nil, err
+	}
+	if err := page.Enable().Do(ctx); err != nil {
+		return nil, err
+	}
+	_, _, errorText, err := page.Navigate("data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svg)).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(errorText) > 0 {
+		return nil, errors.New(errorText)
+	}
+	if err := target.waitFor(pageLoadEventFired); err != nil {
+		return nil, err
+	}
+	capture := page.CaptureScreenshot().
+		WithFormat(page.CaptureScreenshotFormat(format)).
+		WithClip(&page.Viewport{Width: width, Height: height, Scale: 1})
+	if format == ImageFormatJPEG {
+		capture = capture.WithQuality(int64(jpegQuality))
+	}
+	return capture.Do(ctx)
+}
+
+// devToolsPage returns the DevTools websocket url of the first page of the browser with the given DevTools url
+func devToolsPage(ctx context.Context, browserURL string) (string, error) {
+	u, err := url.Parse(browserURL)
+	if err != nil {
+		return "", err
+	}
+	request, err := http.NewRequest(http.MethodGet, "http://"+u.Host+"/json/list", nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var targets []struct {
+		Type                 string `json:"type"`
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&targets); err != nil {
+		return "", err
+	}
+	for _, t := range targets {
+		if t.Type == "page" && len(t.WebSocketDebuggerURL) > 0 {
+			return t.WebSocketDebuggerURL, nil
+		}
+	}
+	return "", errors.New("chromium has no page to load the svg into")
+}
+
+// devTools executes commands (see cdp.Executor) on a page over its DevTools websocket, recording the events it receives
+type devTools struct {
+	conn   *websocket.Conn
+	lastID int64
+	events map[string]bool // the methods of the events received
+}
+
+// devToolsMessage is a command sent to, or a response or event received from, the browser
+type devToolsMessage struct {
+	ID     int64           `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Execute sends the command and waits for its response, unmarshalling its result into result (unless nil)
+func (d *devTools) Execute(ctx context.Context, method string, params easyjson.Marshaler, result easyjson.Unmarshaler) error {
+	d.lastID++
+	command := &devToolsMessage{ID: d.lastID, Method: method}
+	if params != nil {
+		b, err := easyjson.Marshal(params)
+		if err != nil {
+			return err
+		}
+		command.Params = b
+	}
+	if err := d.conn.WriteJSON(command); err != nil {
+		return err
+	}
+	for {
+		message, err := d.read()
+		if err != nil {
+			return err
+		}
+		if message.ID != d.lastID {
+			continue
+		}
+		if message.Error != nil {
+			return fmt.Errorf("%s: %s", method, message.Error.Message)
+		}
+		if result == nil {
+			return nil
+		}
+		return easyjson.Unmarshal(message.Result, result)
+	}
+}
+
+// waitFor waits until an event with the given method has been received
+func (d *devTools) waitFor(event string) error {
+	for !d.events[event] {
+		if _, err := d.read(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read reads the next message, recording it if it is an event
+func (d *devTools) read() (*devToolsMessage, error) {
+	message := &devToolsMessage{}
+	if err := d.conn.ReadJSON(message); err != nil {
+		return nil, err
+	}
+	if message.ID == 0 && len(message.Method) > 0 {
+		d.events[message.Method] = true
+	}
+	return message, nil
+}
@@ -0,0 +1,109 @@
+package geojson2svg
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeDevTools is a DevTools server with a single page, which answers each command with an empty result except captureScreenshot,
+// whose data describes the command's parameters
+func fakeDevTools(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/json/list" {
+			fmt.Fprintf(w, `[{"type":"page","webSocketDebuggerUrl":"ws://%s/devtools/page/1"}]`, strings.TrimPrefix(server.URL, "http://"))
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		for {
+			var command struct {
+				ID     int             `json:"id"`
+				Method string          `json:"method"`
+				Params json.RawMessage `json:"params"`
+			}
+			if err := conn.ReadJSON(&command); err != nil {
+				return
+			}
+			result := "{}"
+			if command.Method == "Page.captureScreenshot" {
+				result = fmt.Sprintf(`{"data":"%s"}`, base64.StdEncoding.EncodeToString(command.Params))
+			}
+			if command.Method == "Page.navigate" {
+				// an unrelated event, then the response, then the load event
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"method":"Page.frameStartedLoading","params":{}}`))
+				conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"id":%d,"result":{}}`, command.ID)))
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"method":"Page.loadEventFired","params":{}}`))
+				continue
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"id":%d,"result":%s}`, command.ID, result)))
+		}
+	}))
+	return server
+}
+
+func TestScreenshot(t *testing.T) {
+	Convey("Given a DevTools server", t, func() {
+		server := fakeDevTools(t)
+		defer server.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		Convey("The svg should be loaded into the page and a screenshot of its size taken", func() {
+			image, err := screenshot(ctx, "ws://"+strings.TrimPrefix(server.URL, "http://")+"/devtools/browser/1", []byte(`<svg width="40" height="30"></svg>`), ImageFormatWebP)
+			So(err, ShouldBeNil)
+			var params struct {
+				Format string `json:"format"`
+				Clip   struct {
+					Width  float64 `json:"width"`
+					Height float64 `json:"height"`
+				} `json:"clip"`
+			}
+			So(json.Unmarshal(image, &params), ShouldBeNil)
+			So(params.Format, ShouldEqual, ImageFormatWebP)
+			So(params.Clip.Width, ShouldEqual, 40)
+			So(params.Clip.Height, ShouldEqual, 30)
+		})
+
+		Convey("A jpeg screenshot should be taken with the jpeg quality", func() {
+			image, err := screenshot(ctx, "ws://"+strings.TrimPrefix(server.URL, "http://")+"/devtools/browser/1", []byte(`<svg width="40" height="30"></svg>`), ImageFormatJPEG)
+			So(err, ShouldBeNil)
+			var params struct {
+				Format  string `json:"format"`
+				Quality int    `json:"quality"`
+			}
+			So(json.Unmarshal(image, &params), ShouldBeNil)
+			So(params.Format, ShouldEqual, ImageFormatJPEG)
+			So(params.Quality, ShouldEqual, jpegQuality)
+		})
+	})
+}
+
+func TestDevToolsURL(t *testing.T) {
+	Convey("The DevTools url should be read from the output of Chromium", t, func() {
+		url, _, err := devToolsURL(bufio.NewReader(strings.NewReader("some warning\nDevTools listening on ws://127.0.0.1:1234/devtools/browser/abc\n")))
+		So(err, ShouldBeNil)
+		So(url, ShouldEqual, "ws://127.0.0.1:1234/devtools/browser/abc")
+	})
+
+	Convey("Chromium exiting without starting DevTools should be an error, with its output", t, func() {
+		_, output, err := devToolsURL(bufio.NewReader(strings.NewReader("cannot open display\n")))
+		So(err, ShouldNotBeNil)
+		So(output, ShouldEqual, "cannot open display\n")
+	})
+}
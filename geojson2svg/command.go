@@ -0,0 +1,100 @@
+package geojson2svg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// commandBackend invokes an executable to convert svg files to an image or document
+type commandBackend struct {
+	executable   string
+	arguments    []string
+	capabilities Capabilities
+}
+
+// NewCommandBackend returns a Backend that invokes the executable with the arguments described by NewRasterConverter (and NewDocumentConverter)
+func NewCommandBackend(executable string, arguments []string) Backend {
+	return &commandBackend{executable: executable, arguments: arguments,
+		capabilities: Capabilities{Backend: BackendCommand, MultiPage: true, Text: true, Patterns: true}}
+}
+
+// Capabilities describes the backend, including its executable
+func (b *commandBackend) Capabilities() Capabilities {
+	c := b.capabilities
+	c.Executable = b.executable
+	return c
+}
+
+// ConvertPages converts the given svg files to a single image or document by invoking the executable.
+// Any error is a *ConversionError.
+func (b *commandBackend) ConvertPages(ctx context.Context, format string, pages [][]byte) ([]byte, error) {
+
+	// the svgs and image are written to a directory of their own, which is removed (with anything else the executable wrote to it) when the conversion completes
+	tempDir, err := ioutil.TempDir("", "dp-map-renderer")
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to create temporary directory"})
+		return nil, b.conversionError(format, "", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tempName := filepath.Join(tempDir, "map")
+	tempImage := tempName + "." + format
+	tempSVGs := make([]string, len(pages))
+	for i := range pages {
+		tempSVGs[i] = fmt.Sprintf("%s_%d.svg", tempName, i+1)
+	}
+	if len(pages) == 1 {
+		tempSVGs[0] = tempName + ".svg"
+	}
+
+	for i, svg := range pages {
+		err := ioutil.WriteFile(tempSVGs[i], svg, 0666)
+		if err != nil {
+			log.Error(err, log.Data{"_message": "Unable to write svg file", "filename": tempSVGs[i]})
+			return nil, b.conversionError(format, "", err)
+		}
+	}
+
+	args := make([]string, 0, len(b.arguments)+len(pages))
+	for _, s := range b.arguments {
+		if s == ArgSVGFilename {
+			args = append(args, tempSVGs...)
+			continue
+		}
+		s = strings.Replace(s, ArgSVGFilename, strings.Join(tempSVGs, " "), -1)
+		s = strings.Replace(s, ArgPNGFilename, tempImage, -1)
+		s = strings.Replace(s, ArgFormat, format, -1)
+		s = strings.Replace(s, ArgQuality, strconv.Itoa(jpegQuality), -1)
+		args = append(args, strings.Replace(s, ArgImageFilename, tempImage, -1))
+	}
+
+	cmd := exec.CommandContext(ctx, b.executable, args...)
+	var out bytes.Buffer
+	cmd.Stderr = &out
+	err = cmd.Run()
+	if err != nil {
+		log.Error(err, log.Data{"Command": b.executable, "arguments": args, "stderr": out.String(), "timedOut": ctx.Err() == context.DeadlineExceeded, "tempSVGs": tempSVGs, "tempImage": tempImage})
+		return nil, b.conversionError(format, out.String(), err)
+	}
+
+	image, err := ioutil.ReadFile(tempImage)
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to read image file", "filename": tempImage})
+		return nil, b.conversionError(format, out.String(), err)
+	}
+	return image, nil
+}
+
+// conversionError returns a ConversionError of the backend
+func (b *commandBackend) conversionError(format string, stderr string, err error) *ConversionError {
+	return &ConversionError{Backend: b.capabilities.Backend, Executable: b.executable, Format: format, Stderr: stderr, Err: err}
+}
@@ -0,0 +1,17 @@
+package geojson2svg
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestAppendCoordinate(t *testing.T) {
+	// coordinates are formatted without fmt, but must be identical to %f
+	for _, v := range []float64{0, math.Copysign(0, -1), -0.0000004, 0.0000005, 1.5, -123.4567895, 399.9999999, 1e9 + 0.25, 12345678.123456789, math.NaN(), math.Inf(1)} {
+		expected := fmt.Sprintf("%f", v)
+		if got := string(appendCoordinate([]byte("x"), v)); got != "x"+expected {
+			t.Errorf("expected x%s, got %s", expected, got)
+		}
+	}
+}
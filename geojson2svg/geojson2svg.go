@@ -7,10 +7,11 @@ package geojson2svg
 import (
 	"bytes"
 	"fmt"
+	"html"
 	"io"
 	"math"
 	"sort"
-	"strings"
+	"strconv"
 
 	"github.com/ONSdigital/go-ns/log"
 	"github.com/paulmach/go.geojson"
@@ -44,10 +45,12 @@ type SVG struct {
 	elements       []*SVGElement
 	titleProp      string
 	patterns       []string
-	pngConverter   PNGConverter
+	overlays       []string
+	pngConverter   RasterConverter
 	bounds         *boundingRectangle
 	points         [][]float64
 	responsiveSize bool
+	fitTo          []*geojson.Geometry
 }
 
 // SVGElement represents a single element of an SVG - a Geometry, Feature or FeatureCollection
@@ -64,15 +67,18 @@ type Padding struct{ Top, Right, Bottom, Left float64 }
 // An Option represents a single SVG option.
 type Option func(*SVG)
 
-// PNGConverter converts an svg file to png. Call either Convert or IncludeFallbackImage - there's no need to call both.
-type PNGConverter interface {
-	// Convert converts the given svg file to a base64-encoded png
+// RasterConverter converts an svg file to a raster image (e.g. png, webp, avif or jpeg). Call either Convert or IncludeFallbackImage - there's no need to call both.
+type RasterConverter interface {
+	// Convert converts the given svg file to a base64-encoded image
 	Convert(svg []byte) ([]byte, error)
 	// IncludeFallbackImage generates an svg with the given attributes, content and a fallback image:
 	// <svg svgAttributes><switch><g>svgContent</g><foreignObject><image src="data:image/png;base64,..." /></foreignObject></svg>
 	IncludeFallbackImage(svgAttributes string, svgContent string, width float64, height float64) string
 }
 
+// PNGConverter is a RasterConverter that converts an svg file to png
+type PNGConverter = RasterConverter
+
 // boundingRectangle is used to cache the result of calculations in getBoundingRectangle
 type boundingRectangle struct {
 	minX, minY, maxX, maxY float64
@@ -97,6 +103,12 @@ func (svg *SVG) Draw(width, height float64, opts ...Option) string {
 // DrawWithProjection renders the final SVG with the given options to a string.
 // All coordinates will be converted by the given projection, then scaled to fit into the svg.
 func (svg *SVG) DrawWithProjection(width, height float64, projection ScaleFunc, opts ...Option) string {
+	return svg.Wrap(width, height, svg.DrawContent(width, height, projection, opts...))
+}
+
+// DrawContent renders the patterns, geometries and overlays of the SVG with the given options to a string - everything within the svg element.
+// All coordinates will be converted by the given projection, then scaled to fit into the svg.
+func (svg *SVG) DrawContent(width, height float64, projection ScaleFunc, opts ...Option) string {
 
 	for _, o := range opts {
 		o(svg)
@@ -104,7 +116,9 @@ func (svg *SVG) DrawWithProjection(width, height float64, projection ScaleFunc,
 
 	sf := svg.makeScaleFunc(width, height, projection)
 
-	content := bytes.NewBufferString("")
+	content := getBuffer()
+	defer putBuffer(content)
+	content.WriteString(svg.getPatterns())
 	for _, e := range svg.elements {
 		switch e.elementType {
 		case Geometry:
@@ -120,14 +134,26 @@ func (svg *SVG) DrawWithProjection(width, height float64, projection ScaleFunc,
 		}
 	}
 
-	attributes := makeSVGAttributes(width, height, svg)
+	for _, overlay := range svg.overlays {
+		content.WriteString(overlay)
+	}
+	return content.String()
+}
 
-	patterns := svg.getPatterns()
+// Wrap returns the content (as returned by DrawContent) in an svg element with the attributes of the SVG and the given options,
+// including a fallback image if the SVG has a png converter.
+func (svg *SVG) Wrap(width, height float64, content string, opts ...Option) string {
+
+	for _, o := range opts {
+		o(svg)
+	}
+
+	attributes := makeSVGAttributes(width, height, svg)
 
 	if svg.pngConverter == nil {
-		return fmt.Sprintf(`<svg%s>%s%s</svg>`, attributes, patterns, content)
+		return fmt.Sprintf(`<svg%s>%s</svg>`, attributes, content)
 	}
-	return svg.pngConverter.IncludeFallbackImage(attributes, patterns+content.String(), width, height)
+	return svg.pngConverter.IncludeFallbackImage(attributes, content, width, height)
 }
 
 // makeSVGAttributes converts the avg attributes to a string and adds either width and height or style="width:100%" attributes.
@@ -163,6 +189,13 @@ func (svg *SVG) AppendFeatureCollection(fc *geojson.FeatureCollection) {
 	svg.clearCache()
 }
 
+// FitTo frames the svg to the given geometries, rather than to everything appended to it - anything outside them is clipped by the edge of the svg.
+// Passing no geometries frames the svg to everything appended to it again.
+func (svg *SVG) FitTo(geometries ...*geojson.Geometry) {
+	svg.fitTo = geometries
+	svg.clearCache()
+}
+
 // clearCache deletes all internal cached values
 func (svg *SVG) clearCache() {
 	svg.bounds = nil
@@ -208,8 +241,16 @@ func WithPattern(pattern string) Option {
 	}
 }
 
-// WithPNGFallback configures the SVG to include a png image as a foreignObject fallback for browsers that don't support svg
-func WithPNGFallback(converter PNGConverter) Option {
+// WithOverlay configures the SVG to include the given content (which must be correctly formatted svg) after all elements have been drawn,
+// so that it appears on top of them.
+func WithOverlay(overlay string) Option {
+	return func(svg *SVG) {
+		svg.overlays = append(svg.overlays, overlay)
+	}
+}
+
+// WithPNGFallback configures the SVG to include a png image (or an image in the format of the converter) as a foreignObject fallback for browsers that don't support svg
+func WithPNGFallback(converter RasterConverter) Option {
 	return func(svg *SVG) {
 		svg.pngConverter = converter
 	}
@@ -237,8 +278,15 @@ func UseProperties(props []string) Option {
 	}
 }
 
-// getPoints returns an array of all coordinates (points) in the svg. Note that these points have not had any projection applied.
+// getPoints returns an array of all coordinates (points) in the svg, or of the geometries it's fitted to. Note that these points have not had any projection applied.
 func (svg *SVG) getPoints() [][]float64 {
+	if len(svg.points) == 0 && len(svg.fitTo) > 0 {
+		points := [][]float64{}
+		for _, g := range svg.fitTo {
+			points = append(points, collect(g)...)
+		}
+		svg.points = points
+	}
 	if len(svg.points) == 0 {
 		points := [][]float64{}
 		for _, e := range svg.elements {
@@ -317,14 +365,21 @@ func collect(g *geojson.Geometry) (points [][]float64) {
 	return points
 }
 
-// the draw methods use writer.Write where possible as it is faster than fmt.Fprintf, even if it requires string concatenation
-// fmt.Fprintf is only used where values do actually require formatting, e.g. floats.
+// the draw methods use writer.Write rather than fmt.Fprintf as it is faster, even if it requires string concatenation.
+// Coordinates are formatted with appendCoordinate.
 
 // drawPoint draws an individual point
 func drawPoint(sf ScaleFunc, w io.Writer, p []float64, attributes string, title string) {
 	x, y := sf(p[0], p[1])
-	endTag := endTag("circle", title)
-	fmt.Fprintf(w, `<circle cx="%f" cy="%f" r="1"%s%s`, x, y, attributes, endTag)
+	b := make([]byte, 0, 64+len(attributes)+len(title))
+	b = append(b, `<circle cx="`...)
+	b = appendCoordinate(b, x)
+	b = append(b, `" cy="`...)
+	b = appendCoordinate(b, y)
+	b = append(b, `" r="1"`...)
+	b = append(b, attributes...)
+	b = append(b, endTag("circle", title)...)
+	w.Write(b)
 }
 
 // drawMultiPoint draws multiple points grouped in a <g> tag
@@ -338,13 +393,12 @@ func drawMultiPoint(sf ScaleFunc, w io.Writer, points [][]float64, attributes st
 
 // drawLineString draws a single line (path) defined by the array of points
 func drawLineString(sf ScaleFunc, w io.Writer, points [][]float64, attributes string, title string) {
-	path := bytes.NewBufferString("M")
-	for _, p := range points {
-		x, y := sf(p[0], p[1])
-		fmt.Fprintf(path, "%f %f,", x, y)
-	}
-	endTag := endTag("path", title)
-	w.Write([]byte(`<path d="` + strings.TrimSuffix(path.String(), ",") + `"` + attributes + endTag))
+	path := getBuffer()
+	defer putBuffer(path)
+	path.WriteString(`<path d="M`)
+	writePoints(sf, path, points)
+	path.WriteString(`"` + attributes + endTag("path", title))
+	w.Write(path.Bytes())
 }
 
 // drawMultiLineString draws multiple lines (paths), grouped together in a <g> tag
@@ -358,16 +412,40 @@ func drawMultiLineString(sf ScaleFunc, w io.Writer, paths [][][]float64, attribu
 
 // drawPolygon draws a single polygon, which may be defined by multiple paths. Each path is an array of points.
 func drawPolygon(sf ScaleFunc, w io.Writer, paths [][][]float64, attributes string, title string) {
-	pathBuffer := bytes.NewBufferString("")
-	for _, subPath := range paths {
-		subPathBuffer := bytes.NewBufferString(" M")
-		for _, point := range subPath {
-			x, y := sf(point[0], point[1])
-			fmt.Fprintf(subPathBuffer, "%f %f,", x, y)
+	path := getBuffer()
+	defer putBuffer(path)
+	path.WriteString(`<path d="`)
+	for i, subPath := range paths {
+		if i > 0 {
+			path.WriteByte(' ')
 		}
-		pathBuffer.Write(bytes.TrimRight(subPathBuffer.Bytes(), ","))
+		path.WriteByte('M')
+		writePoints(sf, path, subPath)
 	}
-	w.Write([]byte(`<path d="` + strings.TrimPrefix(pathBuffer.String(), " ") + ` Z"` + attributes + endTag("path", title)))
+	path.WriteString(` Z"` + attributes + endTag("path", title))
+	w.Write(path.Bytes())
+}
+
+// writePoints writes the scaled points to the path as comma-separated "x y" pairs, to 6 decimal places.
+// Each pair is formatted into the same (stack allocated) byte slice - formatting with fmt dominated the time taken to draw maps with many points.
+func writePoints(sf ScaleFunc, path *bytes.Buffer, points [][]float64) {
+	var scratch [64]byte
+	for i, p := range points {
+		b := scratch[:0]
+		if i > 0 {
+			b = append(b, ',')
+		}
+		x, y := sf(p[0], p[1])
+		b = appendCoordinate(b, x)
+		b = append(b, ' ')
+		b = appendCoordinate(b, y)
+		path.Write(b)
+	}
+}
+
+// appendCoordinate appends the coordinate to b to 6 decimal places, as formatted by fmt's %f
+func appendCoordinate(b []byte, v float64) []byte {
+	return strconv.AppendFloat(b, v, 'f', 6, 64)
 }
 
 // drawMultiPolygon draws multiple polygons, grouped together in a <g> tag
@@ -400,7 +478,8 @@ func endTag(tag string, title string) string {
 	return "/>"
 }
 
-// getFeatureAttributesAndTitle converts the properties of the feature into a string of attributes, and extracts the title property into a string
+// getFeatureAttributesAndTitle converts the properties of the feature into a string of attributes, and extracts the title property into a string.
+// Both are escaped, so that property values can't inject markup into the svg.
 func getFeatureAttributesAndTitle(useProp func(string) bool, titleProp string, feature *geojson.Feature) (string, string) {
 	attrs := make(map[string]string)
 	id, isString := feature.ID.(string)
@@ -414,12 +493,12 @@ func getFeatureAttributesAndTitle(useProp func(string) bool, titleProp string, f
 	}
 	titleString := ""
 	if title, ok := feature.Properties[titleProp]; ok {
-		titleString = fmt.Sprintf("%v", title)
+		titleString = html.EscapeString(fmt.Sprintf("%v", title))
 	}
 	return makeAttributes(attrs), titleString
 }
 
-// makeAttributes converts the given map into a string with each key="value" pair in sorted order
+// makeAttributes converts the given map into a string with each key="value" pair in sorted order, escaping the values
 func makeAttributes(as map[string]string) string {
 	keys := make([]string, 0, len(as))
 	for k := range as {
@@ -428,11 +507,17 @@ func makeAttributes(as map[string]string) string {
 	sort.Strings(keys)
 	res := bytes.NewBufferString("")
 	for _, k := range keys {
-		fmt.Fprintf(res, ` %s="%s"`, k, as[k])
+		fmt.Fprintf(res, ` %s="%s"`, k, html.EscapeString(as[k]))
 	}
 	return res.String()
 }
 
+// ScaleFunc returns the function used to scale coordinates when drawing the svg with the given width, height and projection,
+// so that other elements (e.g. labels) can be positioned relative to the geometries.
+func (svg *SVG) ScaleFunc(width, height float64, projection ScaleFunc) ScaleFunc {
+	return svg.makeScaleFunc(width, height, projection)
+}
+
 // makeScaleFunc creates a function that will scale a pair of coordinates so that they fit within the width and height,
 // passing them through the projection first.
 func (svg *SVG) makeScaleFunc(width, height float64, projection ScaleFunc) ScaleFunc {
@@ -286,6 +286,38 @@ func TestSVG(t *testing.T) {
 	}
 }
 
+func TestSVGConcurrentDraws(t *testing.T) {
+	// each svg draws into buffers from a shared pool - concurrent draws must not see each other's content
+	geometries := []string{
+		`{"type": "Polygon", "coordinates": [[[10.4,20.5], [40.3,42.3], [20.2, 10.2], [10.4,20.5]]]}`,
+		`{"type": "LineString", "coordinates": [[10.4,20.5], [40.3,42.3]]}`,
+	}
+	expected := make([]string, len(geometries))
+	for i, g := range geometries {
+		svg := geojson2svg.New()
+		addGeometry(t, svg, g)
+		expected[i] = svg.Draw(400, 400)
+	}
+
+	results := make(chan error, 100)
+	for n := 0; n < cap(results); n++ {
+		go func(i int) {
+			svg := geojson2svg.New()
+			addGeometry(t, svg, geometries[i])
+			if got := svg.Draw(400, 400); got != expected[i] {
+				results <- fmt.Errorf("\nexpected \n%s\ngot \n%s", expected[i], got)
+				return
+			}
+			results <- nil
+		}(n % len(geometries))
+	}
+	for n := 0; n < cap(results); n++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
 func TestSVGAttributeOptions(t *testing.T) {
 	tcs := []struct {
 		name string
@@ -454,6 +486,18 @@ func TestSVGMultiplePatterns(t *testing.T) {
 	}
 }
 
+func TestSVGWithOverlay(t *testing.T) {
+	overlay := `<g id="overlay"><rect width="10" height="10"></rect></g>`
+	expected := `<svg width="200" height="200"><path d="M0.000000 200.000000,0.000000 0.000000,200.000000 0.000000,200.000000 200.000000"/>` + overlay + `</svg>`
+	svg := geojson2svg.New()
+	addGeometry(t, svg, `{"type": "LineString", "coordinates": [[0,0], [0,400], [400,400], [400,0]]}`)
+
+	got := svg.Draw(200, 200, geojson2svg.WithOverlay(overlay))
+	if got != expected {
+		t.Errorf("\nexpected \n%s\ngot \n%s", expected, got)
+	}
+}
+
 func TestFeatureProperties(t *testing.T) {
 	tcs := []struct {
 		name      string
@@ -0,0 +1,422 @@
+package geojson2svg
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+)
+
+// rasterSamples is the number of heights at which each row of pixels is sampled when filling shapes, to antialias their edges
+const rasterSamples = 4
+
+// defaultSVGWidth and defaultSVGHeight are the size of an svg without a width, height or viewBox, as for an html replaced element
+const (
+	defaultSVGWidth  = 300
+	defaultSVGHeight = 150
+)
+
+// patternFill is the colour of shapes filled with a pattern (e.g. the missing data pattern), which the go backend can't render
+var patternFill = color.NRGBA{R: 0xcc, G: 0xcc, B: 0xcc, A: 0xff}
+
+// skippedElements are the elements (and their content) that the go backend doesn't render
+var skippedElements = map[string]bool{"defs": true, "pattern": true, "clipPath": true, "mask": true, "marker": true, "symbol": true, "style": true,
+	"script": true, "title": true, "desc": true, "metadata": true, "text": true, "foreignObject": true, "linearGradient": true, "radialGradient": true}
+
+// goBackend rasterises svgs to png or jpeg in process, without any external dependency. It renders the shapes drawn by this package and the renderer -
+// paths, polygons, polylines, rects, circles, ellipses and lines, filled and stroked in solid colours, within groups that may be transformed -
+// but not text, and shapes filled with a pattern are filled in a single grey.
+type goBackend struct{}
+
+// Capabilities describes the backend
+func (b *goBackend) Capabilities() Capabilities {
+	return Capabilities{Backend: BackendGo, Formats: []string{ImageFormatPNG, ImageFormatJPEG}}
+}
+
+// ConvertPages rasterises a single svg to a png, or to a jpeg (with the quality given to SetJPEGQuality) on a white background. Any error is a *ConversionError.
+func (b *goBackend) ConvertPages(ctx context.Context, format string, pages [][]byte) ([]byte, error) {
+	if format != ImageFormatPNG && format != ImageFormatJPEG {
+		return nil, &ConversionError{Backend: BackendGo, Format: format, Err: fmt.Errorf("the go backend can't convert svg to %s", format)}
+	}
+	if len(pages) != 1 {
+		return nil, &ConversionError{Backend: BackendGo, Format: format, Err: errors.New("the go backend converts a single svg at a time")}
+	}
+	img, err := rasterise(pages[0])
+	if err != nil {
+		return nil, &ConversionError{Backend: BackendGo, Format: format, Err: err}
+	}
+	var buf bytes.Buffer
+	if format == ImageFormatJPEG {
+		// jpeg has no transparency
+		page := image.NewRGBA(img.Bounds())
+		draw.Draw(page, page.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(page, page.Bounds(), img, image.Point{}, draw.Over)
+		err = jpeg.Encode(&buf, page, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, &ConversionError{Backend: BackendGo, Format: format, Err: err}
+	}
+	return buf.Bytes(), nil
+}
+
+// point is a position in pixels
+type point struct{ x, y float64 }
+
+// matrix is an affine transform [a b c d e f], mapping (x, y) to (ax + cy + e, bx + dy + f)
+type matrix [6]float64
+
+// identity is the matrix that leaves points unchanged
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// then returns the transform that applies n, followed by m - i.e. the transform of an element with transform n within an element with transform m
+func (m matrix) then(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[2]*n[1], m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3], m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4], m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+// apply transforms the position
+func (m matrix) apply(x, y float64) point {
+	return point{m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]}
+}
+
+// scale returns the factor by which the transform scales lengths (e.g. stroke widths)
+func (m matrix) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+// paint is the fill or stroke of a shape
+type paint struct {
+	colour color.NRGBA
+	none   bool
+}
+
+// rasterStyle is the style of an element, inherited by its children
+type rasterStyle struct {
+	fill, stroke                                     paint
+	strokeWidth, fillOpacity, strokeOpacity, opacity float64
+}
+
+// rasterState is the transform and style of an element being rasterised
+type rasterState struct {
+	transform matrix
+	style     rasterStyle
+	skip      bool // true if the element (and its content) is not rendered
+}
+
+// rasterise draws the svg onto a transparent image of its size
+func rasterise(svg []byte) (*image.RGBA, error) {
+	width, height := svgSize(svg)
+	img := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(width)), int(math.Ceil(height))))
+
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	decoder.Strict = false
+	stack := []rasterState{{transform: identity, style: rasterStyle{fill: paint{colour: color.NRGBA{A: 0xff}}, stroke: paint{none: true},
+		strokeWidth: 1, fillOpacity: 1, strokeOpacity: 1, opacity: 1}}}
+	root := true
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			parent := stack[len(stack)-1]
+			state := rasterState{transform: parent.transform, style: parent.style, skip: parent.skip || skippedElements[t.Name.Local]}
+			attrs := attributeMap(t.Attr)
+			if !state.skip {
+				state.skip = attrs["display"] == "none" || styleProperties(attrs["style"])["display"] == "none"
+			}
+			if !state.skip {
+				if t.Name.Local == "svg" {
+					state.transform = state.transform.then(viewportTransform(attrs, root, width, height))
+				}
+				state.transform = state.transform.then(parseTransform(attrs["transform"]))
+				state.style = applyStyle(state.style, attrs)
+				drawElement(img, t.Name.Local, attrs, state)
+			}
+			root = false
+			stack = append(stack, state)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return img, nil
+}
+
+// svgSize returns the width and height of the svg's root element - from its width and height attributes, or its viewBox if it has none
+func svgSize(svg []byte) (float64, float64) {
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return defaultSVGWidth, defaultSVGHeight
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			attrs := attributeMap(start.Attr)
+			width, height := parseLength(attrs["width"]), parseLength(attrs["height"])
+			if viewBox := parseNumbers(attrs["viewBox"]); len(viewBox) == 4 && viewBox[2] > 0 && viewBox[3] > 0 {
+				switch {
+				case width <= 0 && height <= 0:
+					width, height = viewBox[2], viewBox[3]
+				case width <= 0:
+					width = height * viewBox[2] / viewBox[3]
+				case height <= 0:
+					height = width * viewBox[3] / viewBox[2]
+				}
+			}
+			if width <= 0 || height <= 0 {
+				return defaultSVGWidth, defaultSVGHeight
+			}
+			return width, height
+		}
+	}
+}
+
+// viewportTransform returns the transform of an svg element - from its viewBox to its position and size, centring the viewBox if it has a different aspect ratio.
+// The root element fills the image.
+func viewportTransform(attrs map[string]string, root bool, imageWidth, imageHeight float64) matrix {
+	x, y, width, height := parseLength(attrs["x"]), parseLength(attrs["y"]), parseLength(attrs["width"]), parseLength(attrs["height"])
+	if root {
+		x, y, width, height = 0, 0, imageWidth, imageHeight
+	}
+	viewBox := parseNumbers(attrs["viewBox"])
+	if len(viewBox) != 4 || viewBox[2] <= 0 || viewBox[3] <= 0 {
+		return matrix{1, 0, 0, 1, x, y}
+	}
+	if width <= 0 {
+		width = viewBox[2]
+	}
+	if height <= 0 {
+		height = viewBox[3]
+	}
+	scale := math.Min(width/viewBox[2], height/viewBox[3])
+	x += (width - viewBox[2]*scale) / 2
+	y += (height - viewBox[3]*scale) / 2
+	return matrix{scale, 0, 0, scale, x - viewBox[0]*scale, y - viewBox[1]*scale}
+}
+
+// drawElement fills and strokes the shape of the element, if it is a shape
+func drawElement(img *image.RGBA, name string, attrs map[string]string, state rasterState) {
+	var subpaths [][]point
+	closed := true
+	switch name {
+	case "path":
+		subpaths, closed = parsePath(attrs["d"], state.transform), false
+	case "rect":
+		x, y, w, h := parseLength(attrs["x"]), parseLength(attrs["y"]), parseLength(attrs["width"]), parseLength(attrs["height"])
+		if w > 0 && h > 0 {
+			subpaths = [][]point{{state.transform.apply(x, y), state.transform.apply(x+w, y), state.transform.apply(x+w, y+h), state.transform.apply(x, y+h), state.transform.apply(x, y)}}
+		}
+	case "circle", "ellipse":
+		rx, ry := parseLength(attrs["rx"]), parseLength(attrs["ry"])
+		if name == "circle" {
+			rx, ry = parseLength(attrs["r"]), parseLength(attrs["r"])
+		}
+		if rx > 0 && ry > 0 {
+			subpaths = [][]point{ellipse(parseLength(attrs["cx"]), parseLength(attrs["cy"]), rx, ry, state.transform)}
+		}
+	case "polygon", "polyline":
+		numbers := parseNumbers(attrs["points"])
+		var points []point
+		for i := 0; i+1 < len(numbers); i += 2 {
+			points = append(points, state.transform.apply(numbers[i], numbers[i+1]))
+		}
+		if len(points) > 0 && name == "polygon" {
+			points = append(points, points[0])
+		}
+		subpaths, closed = [][]point{points}, name == "polygon"
+	case "line":
+		subpaths = [][]point{{state.transform.apply(parseLength(attrs["x1"]), parseLength(attrs["y1"])), state.transform.apply(parseLength(attrs["x2"]), parseLength(attrs["y2"]))}}
+		closed = false
+	default:
+		return
+	}
+	if len(subpaths) == 0 {
+		return
+	}
+	style := state.style
+	if !style.fill.none && name != "line" && name != "polyline" {
+		fillPolygons(img, subpaths, style.fill.colour, style.fillOpacity*style.opacity)
+	}
+	if !style.stroke.none && style.strokeWidth > 0 {
+		fillPolygons(img, strokePolygons(subpaths, closed, style.strokeWidth*state.transform.scale()), style.stroke.colour, style.strokeOpacity*style.opacity)
+	}
+}
+
+// ellipse returns a polygon approximating the ellipse, transformed
+func ellipse(cx, cy, rx, ry float64, transform matrix) []point {
+	segments := int(math.Max(16, math.Min(128, math.Max(rx, ry)*transform.scale())))
+	points := make([]point, segments+1)
+	for i := 0; i <= segments; i++ {
+		a := 2 * math.Pi * float64(i) / float64(segments)
+		points[i] = transform.apply(cx+rx*math.Cos(a), cy+ry*math.Sin(a))
+	}
+	return points
+}
+
+// strokePolygons returns a quadrilateral for each segment of the subpaths, of the given width, all with the same orientation so that they don't cancel out where they overlap
+func strokePolygons(subpaths [][]point, closed bool, width float64) [][]point {
+	var quads [][]point
+	for _, points := range subpaths {
+		if closed && len(points) > 1 && points[0] != points[len(points)-1] {
+			points = append(points, points[0])
+		}
+		for i := 1; i < len(points); i++ {
+			p, q := points[i-1], points[i]
+			length := math.Hypot(q.x-p.x, q.y-p.y)
+			if length == 0 {
+				continue
+			}
+			nx, ny := (p.y-q.y)/length*width/2, (q.x-p.x)/length*width/2
+			quad := []point{{p.x + nx, p.y + ny}, {q.x + nx, q.y + ny}, {q.x - nx, q.y - ny}, {p.x - nx, p.y - ny}}
+			if polygonArea(quad) < 0 {
+				quad[1], quad[3] = quad[3], quad[1]
+			}
+			quads = append(quads, quad)
+		}
+	}
+	return quads
+}
+
+// polygonArea returns the signed area of the polygon
+func polygonArea(points []point) float64 {
+	area := 0.0
+	for i := range points {
+		p, q := points[i], points[(i+1)%len(points)]
+		area += p.x*q.y - q.x*p.y
+	}
+	return area / 2
+}
+
+// edge is an edge of a polygon being filled, from top to bottom
+type edge struct {
+	x0, y0, x1, y1 float64
+	winding        int // 1 if the edge runs down the image, -1 if it runs up
+}
+
+// crossing is where an edge crosses a row of pixels
+type crossing struct {
+	x       float64
+	winding int
+}
+
+// fillPolygons fills the polygons (each implicitly closed) in the colour with the nonzero winding rule,
+// antialiasing the edges by sampling each row of pixels at rasterSamples heights
+func fillPolygons(img *image.RGBA, polygons [][]point, colour color.NRGBA, opacity float64) {
+	bounds := img.Bounds()
+	var edges []edge
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, points := range polygons {
+		for i := range points {
+			p, q := points[i], points[(i+1)%len(points)]
+			if p.y == q.y || math.IsNaN(p.y) || math.IsNaN(q.y) {
+				continue
+			}
+			e := edge{p.x, p.y, q.x, q.y, 1}
+			if p.y > q.y {
+				e = edge{q.x, q.y, p.x, p.y, -1}
+			}
+			edges = append(edges, e)
+			minY, maxY = math.Min(minY, e.y0), math.Max(maxY, e.y1)
+		}
+	}
+	if len(edges) == 0 {
+		return
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].y0 < edges[j].y0 })
+
+	width := bounds.Dx()
+	coverage := make([]float64, width)
+	var active []edge
+	var crossings []crossing
+	next := 0
+	for y := int(math.Max(math.Floor(minY), 0)); y < int(math.Min(math.Ceil(maxY), float64(bounds.Dy()))); y++ {
+		for i := range coverage {
+			coverage[i] = 0
+		}
+		left, right := width, -1
+		for s := 0; s < rasterSamples; s++ {
+			sy := float64(y) + (float64(s)+0.5)/rasterSamples
+			for next < len(edges) && edges[next].y0 <= sy {
+				active = append(active, edges[next])
+				next++
+			}
+			crossings = crossings[:0]
+			remaining := active[:0]
+			for _, e := range active {
+				if e.y1 <= sy {
+					continue
+				}
+				remaining = append(remaining, e)
+				if e.y0 <= sy {
+					crossings = append(crossings, crossing{e.x0 + (sy-e.y0)*(e.x1-e.x0)/(e.y1-e.y0), e.winding})
+				}
+			}
+			active = remaining
+			sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+			winding := 0
+			for i, c := range crossings {
+				winding += c.winding
+				if winding != 0 && i+1 < len(crossings) {
+					l, r := cover(coverage, c.x, crossings[i+1].x)
+					if l < left {
+						left = l
+					}
+					if r > right {
+						right = r
+					}
+				}
+			}
+		}
+		for x := left; x <= right; x++ {
+			blend(img, x, y, colour, math.Min(coverage[x], 1)*opacity)
+		}
+	}
+}
+
+// cover adds the fraction of each pixel of the row between x0 and x1 to its coverage (for one sample), returning the first and last pixels covered
+func cover(coverage []float64, x0, x1 float64) (int, int) {
+	x0, x1 = math.Max(x0, 0), math.Min(x1, float64(len(coverage)))
+	if x1 <= x0 {
+		return len(coverage), -1
+	}
+	first, last := int(x0), int(math.Ceil(x1))-1
+	for x := first; x <= last; x++ {
+		coverage[x] += (math.Min(x1, float64(x+1)) - math.Max(x0, float64(x))) / rasterSamples
+	}
+	return first, last
+}
+
+// blend draws the colour over the pixel with the given opacity
+func blend(img *image.RGBA, x, y int, colour color.NRGBA, opacity float64) {
+	a := float64(colour.A) / 0xff * opacity
+	if a <= 0 {
+		return
+	}
+	i := img.PixOffset(x, y)
+	pix := img.Pix[i : i+4]
+	pix[0] = uint8(float64(colour.R)*a + float64(pix[0])*(1-a) + 0.5)
+	pix[1] = uint8(float64(colour.G)*a + float64(pix[1])*(1-a) + 0.5)
+	pix[2] = uint8(float64(colour.B)*a + float64(pix[2])*(1-a) + 0.5)
+	pix[3] = uint8(0xff*a + float64(pix[3])*(1-a) + 0.5)
+}
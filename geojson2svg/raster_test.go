@@ -0,0 +1,68 @@
+package geojson2svg
+
+import (
+	"image/color"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRasterise(t *testing.T) {
+	Convey("A filled rect should be drawn in its colour, leaving the rest of the image transparent", t, func() {
+		img, err := rasterise([]byte(`<svg width="20" height="10"><rect x="10" width="10" height="10" style="fill: rgb(255, 0, 0)"/></svg>`))
+		So(err, ShouldBeNil)
+		So(img.Bounds().Dx(), ShouldEqual, 20)
+		So(img.Bounds().Dy(), ShouldEqual, 10)
+		So(img.At(15, 5), ShouldResemble, color.RGBA{0xff, 0, 0, 0xff})
+		So(img.At(5, 5), ShouldResemble, color.RGBA{})
+	})
+
+	Convey("The viewBox should be scaled to the size of the svg", t, func() {
+		img, err := rasterise([]byte(`<svg width="40" height="20" viewBox="0 0 20 10"><rect x="10" width="10" height="10" fill="#00f"/></svg>`))
+		So(err, ShouldBeNil)
+		So(img.At(25, 15), ShouldResemble, color.RGBA{0, 0, 0xff, 0xff})
+		So(img.At(15, 15), ShouldResemble, color.RGBA{})
+	})
+
+	Convey("A path with an inner ring in the opposite direction should have a hole", t, func() {
+		img, err := rasterise([]byte(`<svg width="30" height="30"><g transform="translate(10,10)"><path d="M-10 -10 H20 V20 H-10 Z m5 5 v20 h20 v-20 z" fill="black"/></g></svg>`))
+		So(err, ShouldBeNil)
+		So(img.At(2, 2), ShouldResemble, color.RGBA{0, 0, 0, 0xff})
+		So(img.At(15, 15), ShouldResemble, color.RGBA{})
+	})
+
+	Convey("Pattern fills should be drawn in grey, and text and defs should not be drawn", t, func() {
+		img, err := rasterise([]byte(`<svg width="10" height="10"><defs><pattern id="p"><rect width="10" height="10" fill="red"/></pattern></defs>` +
+			`<rect width="10" height="5" fill="url(#p)"/><text x="0" y="10" fill="red">label</text></svg>`))
+		So(err, ShouldBeNil)
+		So(img.At(5, 2), ShouldResemble, color.RGBA{0xcc, 0xcc, 0xcc, 0xff})
+		So(img.At(5, 8), ShouldResemble, color.RGBA{})
+	})
+
+	Convey("Strokes should be drawn with their width", t, func() {
+		img, err := rasterise([]byte(`<svg width="10" height="10"><line x1="0" y1="5" x2="10" y2="5" stroke="black" stroke-width="2"/></svg>`))
+		So(err, ShouldBeNil)
+		So(img.At(5, 4), ShouldResemble, color.RGBA{0, 0, 0, 0xff})
+		So(img.At(5, 7), ShouldResemble, color.RGBA{})
+	})
+}
+
+func TestSVGSize(t *testing.T) {
+	Convey("The size of an svg should be read from its width and height, or its viewBox", t, func() {
+		width, height := svgSize([]byte(`<svg width="400px" height="300"></svg>`))
+		So(width, ShouldEqual, 400)
+		So(height, ShouldEqual, 300)
+
+		width, height = svgSize([]byte(`<svg viewBox="0 0 200 100"></svg>`))
+		So(width, ShouldEqual, 200)
+		So(height, ShouldEqual, 100)
+
+		width, height = svgSize([]byte(`<svg width="50" viewBox="0 0 200 100"></svg>`))
+		So(width, ShouldEqual, 50)
+		So(height, ShouldEqual, 25)
+
+		width, height = svgSize([]byte(`<svg></svg>`))
+		So(width, ShouldEqual, 300)
+		So(height, ShouldEqual, 150)
+	})
+}
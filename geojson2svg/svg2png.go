@@ -2,13 +2,15 @@ package geojson2svg
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/xml"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
-	"os"
-	"os/exec"
+	"html"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ONSdigital/go-ns/log"
 )
@@ -18,6 +20,12 @@ const (
 	ArgSVGFilename = "<SVG>"
 	// ArgPNGFilename is text that will be replaced with name of the png file to write when invoking the PNGConverter executable
 	ArgPNGFilename = "<PNG>"
+	// ArgImageFilename is text that will be replaced with name of the image file to write when invoking a RasterConverter executable. Equivalent to ArgPNGFilename
+	ArgImageFilename = "<IMAGE>"
+	// ArgFormat is text that will be replaced with the format of the image or document to write (e.g. png) when invoking an executable
+	ArgFormat = "<FORMAT>"
+	// ArgQuality is text that will be replaced with the jpeg quality (see SetJPEGQuality) when invoking an executable
+	ArgQuality = "<QUALITY>"
 	// svgSwitchTemplate is a template for formatting an svg switch element to insert a fallback image for browsers that can't render svg
 	svgSwitchTemplate = `<svg %s>
 	<switch>
@@ -27,103 +35,404 @@ const (
 		<foreignObject>%s</foreignObject>
 	</switch>
 </svg>`
-	// letterBytes is used to generate a random text string for use as a file name
-	letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// defaultFallbackAlt is the alt text of a fallback image when none is given
+	defaultFallbackAlt = "Fallback map image for older browsers"
 )
 
-// PNGConverter invokes an executable file to convert an svg file to png
-type executablePNGConverter struct {
-	Executable string
-	Arguments  []string
+// The image formats that a RasterConverter may produce
+const (
+	ImageFormatPNG  = "png"
+	ImageFormatWebP = "webp"
+	ImageFormatAVIF = "avif"
+	ImageFormatJPEG = "jpeg"
+)
+
+// ImageFormats lists the supported image formats
+var ImageFormats = []string{ImageFormatPNG, ImageFormatWebP, ImageFormatAVIF, ImageFormatJPEG}
+
+// DefaultJPEGQuality is the quality (1 to 100) of jpeg images unless SetJPEGQuality is called
+const DefaultJPEGQuality = 85
+
+// jpegQuality is the quality of jpeg images, from 1 to 100
+var jpegQuality = DefaultJPEGQuality
+
+// SetJPEGQuality sets the quality (from 1 to 100) of jpeg images produced by all converters - passed to executables as ArgQuality.
+// A quality of zero (or less) restores DefaultJPEGQuality, and one above 100 is treated as 100. Should be called before any conversions are started.
+func SetJPEGQuality(quality int) {
+	switch {
+	case quality <= 0:
+		quality = DefaultJPEGQuality
+	case quality > 100:
+		quality = 100
+	}
+	jpegQuality = quality
+}
+
+// ImageMimeType returns the mime type of the given image format, e.g. image/png
+func ImageMimeType(format string) string {
+	return "image/" + format
+}
+
+// conversions is a semaphore limiting the number of executables converting svgs at once. Nil (the default) means there is no limit.
+var conversions chan struct{}
+
+// LimitConcurrentConversions limits the number of executables converting svgs (for all converters) that may run at once - further conversions wait for one to finish.
+// This bounds the processes started when the map and its legends (and several requests) are converted concurrently. A limit of zero (or less) removes the limit.
+// Should be called before any conversions are started.
+func LimitConcurrentConversions(n int) {
+	if n <= 0 {
+		conversions = nil
+		return
+	}
+	conversions = make(chan struct{}, n)
+}
+
+// conversionTimeout is the longest a backend may take to convert svgs before its executable is killed. Zero (the default) means there is no timeout.
+var conversionTimeout time.Duration
+
+// SetConversionTimeout sets the longest an executable (for all converters) may take to convert svgs - one that takes longer is killed and the conversion fails
+// with a ConversionError that has TimedOut set. A timeout of zero (or less) removes the timeout. Should be called before any conversions are started.
+func SetConversionTimeout(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	conversionTimeout = d
+}
+
+// ConversionError is returned when a backend fails to convert svgs to an image or document
+type ConversionError struct {
+	Backend    string // the name of the backend, e.g. rsvg-convert
+	Executable string // the executable that was invoked, if any
+	Format     string // the format of the image or document
+	Stderr     string // anything the executable wrote to stderr
+	TimedOut   bool   // true if the executable was killed because it took longer than the conversion timeout (see SetConversionTimeout)
+	Err        error  // the underlying error
+}
+
+// Error describes the failed conversion
+func (e *ConversionError) Error() string {
+	converter := e.Executable
+	if len(converter) == 0 {
+		converter = e.Backend
+	}
+	if e.TimedOut {
+		return fmt.Sprintf("conversion to %s timed out: %s", e.Format, converter)
+	}
+	msg := fmt.Sprintf("conversion to %s failed: %s: %v", e.Format, converter, e.Err)
+	if stderr := strings.TrimSpace(e.Stderr); len(stderr) > 0 {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// converter converts svg files to a raster image or document in one format, using a Backend
+type converter struct {
+	backend Backend
+	format  string
+}
+
+// DocumentConverter converts a number of svg files to a single multi-page document, e.g. a pdf
+type DocumentConverter interface {
+	// ConvertPages converts the given svg files to a document with one page per svg, returning the (unencoded) document
+	ConvertPages(pages [][]byte) ([]byte, error)
+}
+
+// CapabilityReporter is implemented by converters that can describe their backend
+type CapabilityReporter interface {
+	// Capabilities describes the backend of the converter
+	Capabilities() Capabilities
+}
+
+// Converter is a RasterConverter and DocumentConverter (in a single format) that can describe its backend
+type Converter interface {
+	RasterConverter
+	DocumentConverter
+	CapabilityReporter
 }
 
 // NewPNGConverter creates a new PNGConverter that invokes an executable to perform the conversion.
 // Parameters:
 // executable - the path to the executable that converts an svg to png.
 // arguments - the arguments passed to the executable. These should include:
-// 		geojson2svg.ArgSVGFilename as the name of the svg file to convert
-// 		geojson2svg.ArgPNGFilename as the name of the png file to create
+//
+//	geojson2svg.ArgSVGFilename as the name of the svg file to convert
+//	geojson2svg.ArgPNGFilename as the name of the png file to create
 func NewPNGConverter(executable string, arguments []string) PNGConverter {
-	return &executablePNGConverter{Executable: executable, Arguments: arguments}
+	return NewRasterConverter(ImageFormatPNG, executable, arguments)
 }
 
-// Convert converts the given svg file to a base64-encoded png
-func (exe *executablePNGConverter) Convert(svg []byte) ([]byte, error) {
+// NewRasterConverter creates a new RasterConverter that invokes an executable to convert svg to the given image format (png, webp, avif or jpeg).
+// Parameters:
+// format - the image format, which is also used as the extension of the image file
+// executable - the path to the executable that converts an svg to the image format.
+// arguments - the arguments passed to the executable. These should include:
+//
+//	geojson2svg.ArgSVGFilename as the name of the svg file to convert
+//	geojson2svg.ArgImageFilename (or geojson2svg.ArgPNGFilename) as the name of the image file to create
+func NewRasterConverter(format string, executable string, arguments []string) RasterConverter {
+	return NewBackendConverter(NewCommandBackend(executable, arguments), format)
+}
 
-	tempName := "temp_" + randomString(8)
-	tempSVG := tempName + ".svg"
-	tempPNG := tempName + ".png"
+// NewDocumentConverter creates a new DocumentConverter that invokes an executable to convert svg files to a document in the given format (e.g. pdf).
+// The arguments are as for NewRasterConverter, except that an argument that is exactly geojson2svg.ArgSVGFilename is replaced with the names of all the svg files.
+func NewDocumentConverter(format string, executable string, arguments []string) DocumentConverter {
+	return NewBackendConverter(NewCommandBackend(executable, arguments), format)
+}
 
-	defer deleteTemporaryFiles(tempSVG, tempPNG)
+// NewBackendConverter creates a new Converter that uses the backend to convert svgs to the given format
+func NewBackendConverter(backend Backend, format string) Converter {
+	return &converter{backend: backend, format: format}
+}
 
-	err := ioutil.WriteFile(tempSVG, svg, 0666)
+// NewConverter creates a new Converter that converts svgs to the given format with the named backend (see NewBackend).
+// Returns an error if the backend is unknown or can't produce the format.
+func NewConverter(backend string, format string, executable string, arguments []string) (Converter, error) {
+	b, err := NewBackend(backend, executable, arguments)
 	if err != nil {
-		log.Error(err, log.Data{"_message": "Unable to write svg file", "filename": tempSVG})
 		return nil, err
 	}
-
-	args := make([]string, len(exe.Arguments))
-	for i, s := range exe.Arguments {
-		args[i] = strings.Replace(s, ArgSVGFilename, tempSVG, -1)
-		args[i] = strings.Replace(args[i], ArgPNGFilename, tempPNG, -1)
+	if !b.Capabilities().SupportsFormat(format) {
+		return nil, fmt.Errorf("the %s backend can't convert svg to %s", backend, format)
 	}
+	return NewBackendConverter(b, format), nil
+}
 
-	cmd := exec.Command(exe.Executable, args...)
-	var out bytes.Buffer
-	cmd.Stderr = &out
-	err = cmd.Run()
+// Convert converts the given svg file to a base64-encoded image
+func (c *converter) Convert(svg []byte) ([]byte, error) {
+	image, err := c.ConvertPages([][]byte{svg})
 	if err != nil {
-		log.Error(err, log.Data{"Command": exe.Executable, "arguments": args, "stderr": out.String(), "tempSVG": tempSVG, "tempPNG": tempPNG})
 		return nil, err
 	}
 
-	png, err := ioutil.ReadFile(tempPNG)
+	imgBase64Str := base64.StdEncoding.EncodeToString(image)
+
+	return []byte(imgBase64Str), nil
+}
+
+// ConvertPages converts the given svg files to a single image or document using the backend, limited by LimitConcurrentConversions and SetConversionTimeout.
+// Any error is a *ConversionError.
+func (c *converter) ConvertPages(pages [][]byte) ([]byte, error) {
+	if limit := conversions; limit != nil {
+		limit <- struct{}{}
+		defer func() { <-limit }()
+	}
+	ctx := context.Background()
+	if timeout := conversionTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	image, err := c.backend.ConvertPages(ctx, c.format, pages)
 	if err != nil {
-		log.Error(err, log.Data{"_message": "Unable to read png file", "filename": tempPNG})
-		return nil, err
+		conversionError, ok := err.(*ConversionError)
+		if !ok {
+			conversionError = &ConversionError{Backend: c.backend.Capabilities().Backend, Format: c.format, Err: err}
+		}
+		conversionError.TimedOut = ctx.Err() == context.DeadlineExceeded
+		return nil, conversionError
 	}
+	return image, nil
+}
 
-	imgBase64Str := base64.StdEncoding.EncodeToString(png)
+// Capabilities describes the backend of the converter
+func (c *converter) Capabilities() Capabilities {
+	return c.backend.Capabilities()
+}
 
-	return []byte(imgBase64Str), nil
+// IncludeFallbackImage inserts a foreignObject with a fallback image, or with an "Unsupported Browser" message if the svg can't be converted.
+func (c *converter) IncludeFallbackImage(attributes string, content string, width float64, height float64) string {
+	svg, err := FallbackImage(c, c.format, "", attributes, content, width, height)
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to include fallback image", "format": c.format})
+		return fmt.Sprintf(svgSwitchTemplate, sizedAttributes(attributes, width, height), content, "<p>Unsupported Browser</p>")
+	}
+	return svg
 }
 
-// IncludeFallbackImage inserts a foreignObject with a fallback png image.
+// FallbackImage generates an svg with the given attributes and content, with a foreignObject containing a fallback image of the svg created by the converter (in the given format).
+// The image has the given alt text, describing what it shows - or a generic description if alt is empty.
+// Returns the error if the svg can't be converted, so that the caller can decide what to render instead.
 // thanks to http://davidensinger.com/2013/04/inline-svg-with-png-fallback/
-func (exe *executablePNGConverter) IncludeFallbackImage(attributes string, content string, width float64, height float64) string {
+func FallbackImage(converter RasterConverter, format string, alt string, attributes string, content string, width float64, height float64) (string, error) {
+	attributes = sizedAttributes(attributes, width, height)
+	image, err := converter.Convert([]byte(fmt.Sprintf(`<svg %s>%s</svg>`, attributes, content)))
+	if err != nil {
+		return "", err
+	}
+	imageString := fmt.Sprintf(`<img alt="%s" src="data:%s;base64,%s" />`, fallbackAlt(alt), ImageMimeType(format), string(image))
+	return fmt.Sprintf(svgSwitchTemplate, attributes, content, imageString), nil
+}
+
+// HighDPIFallbackImage generates an svg as FallbackImage does, except that the fallback image also has a srcset with an image of twice the size,
+// so that it isn't blurred on high-DPI screens. The srcset uses a density (2x) descriptor, so no sizes attribute is needed, and browsers on
+// standard screens only use the image in src.
+func HighDPIFallbackImage(converter RasterConverter, format string, alt string, attributes string, content string, width float64, height float64) (string, error) {
+	attributes = sizedAttributes(attributes, width, height)
+	image, err := converter.Convert([]byte(fmt.Sprintf(`<svg %s>%s</svg>`, attributes, content)))
+	if err != nil {
+		return "", err
+	}
+	image2x, err := converter.Convert([]byte(fmt.Sprintf(`<svg %s>%s</svg>`, scaledAttributes(attributes, width, height, 2), content)))
+	if err != nil {
+		return "", err
+	}
+	mimeType := ImageMimeType(format)
+	imageString := fmt.Sprintf(`<img alt="%s" src="data:%s;base64,%s" srcset="data:%s;base64,%s 2x" />`, fallbackAlt(alt), mimeType, string(image), mimeType, string(image2x))
+	return fmt.Sprintf(svgSwitchTemplate, attributes, content, imageString), nil
+}
+
+// PictureSource is an image format offered by a picture element (see FallbackPicture), converted by its converter
+type PictureSource struct {
+	Converter RasterConverter
+	Format    string
+}
+
+// FallbackPicture generates a picture element offering the svg with the given attributes and content (as a data uri), followed by an image of it in the format of
+// each of the sources, with an img of it created by the converter (in the given format, usually png) for browsers that support none of them.
+// Browsers use the first source they support, so a single embed degrades gracefully. The img has the given alt text (see FallbackImage) and the size of the svg,
+// or the width of its container if the svg has no width attribute (i.e. it is responsive).
+// Returns the error if the svg can't be converted to any of the formats.
+func FallbackPicture(converter RasterConverter, format string, alt string, attributes string, content string, width float64, height float64, sources ...PictureSource) (string, error) {
+	imgStyle := ""
 	if !strings.Contains(attributes, "width=") {
-		attributes = fmt.Sprintf(` width="%.f" height="%.f"%s`, width, height, attributes)
-	}
-	svgString := fmt.Sprintf(`<svg %s>%s</svg>`, attributes, content)
-	png, err := exe.Convert([]byte(svgString))
-	pngString := "<p>Unsupported Browser</p>"
-	if err == nil {
-		pngString = fmt.Sprintf(`<img alt="Fallback map image for older browsers" src="data:image/png;base64,%s" />`, string(png))
-	} else {
-		log.Error(err, log.Data{"_message": "Unable to include fallback png"})
-	}
-	svgString = fmt.Sprintf(svgSwitchTemplate, attributes, content, pngString)
-	return svgString
-}
-
-// randomString creates a random string of length n consisting of upper and lowercase letters
-// thanks to https://stackoverflow.com/a/31832326
-func randomString(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return string(b)
-}
-
-// deleteTemporaryFiles checks to see if each of the files exist, and tries to delete them if so.
-func deleteTemporaryFiles(files ...string) {
-	for _, s := range files {
-		if _, err := os.Stat(s); err == nil {
-			e := os.Remove(s)
-			if e != nil {
-				log.Debug(e.Error(), log.Data{"problem": "Unable to delete temporary file", "file": s})
-			}
+		imgStyle = ` style="width:100%;height:auto;"`
+	}
+	attributes = sizedAttributes(attributes, width, height)
+	svg := fmt.Sprintf(`<svg %s>%s</svg>`, attributes, content)
+	if !strings.Contains(attributes, "xmlns=") {
+		// required of an svg loaded as an image
+		svg = fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" %s>%s</svg>`, strings.TrimSpace(attributes), content)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`<picture>`)
+	fmt.Fprintf(&buf, `<source type="image/svg+xml" srcset="data:image/svg+xml;base64,%s" />`, base64.StdEncoding.EncodeToString([]byte(svg)))
+	for _, source := range sources {
+		image, err := source.Converter.Convert([]byte(svg))
+		if err != nil {
+			return "", err
 		}
+		fmt.Fprintf(&buf, `<source type="%s" srcset="data:%s;base64,%s" />`, ImageMimeType(source.Format), ImageMimeType(source.Format), string(image))
+	}
+	image, err := converter.Convert([]byte(svg))
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&buf, `<img alt="%s" width="%.f" height="%.f"%s src="data:%s;base64,%s" />`, fallbackAlt(alt), width, height, imgStyle, ImageMimeType(format), string(image))
+	buf.WriteString(`</picture>`)
+	return buf.String(), nil
+}
+
+// fallbackAlt returns the alt text, escaped for an attribute, or the default alt text if it is empty
+func fallbackAlt(alt string) string {
+	if len(strings.TrimSpace(alt)) == 0 {
+		return defaultFallbackAlt
+	}
+	return html.EscapeString(alt)
+}
+
+// sizeAttribute matches a width or height attribute of an svg (but not e.g. stroke-width)
+var sizeAttribute = regexp.MustCompile(`\s(width|height)="[^"]*"`)
+
+// scaledAttributes returns the svg attributes with the width and height (which are given, and may differ from those in the attributes) multiplied by the scale.
+// A viewBox of the given size is added if the attributes have none, so that the content is scaled too.
+func scaledAttributes(attributes string, width float64, height float64, scale float64) string {
+	attributes = strings.TrimSpace(sizeAttribute.ReplaceAllString(" "+attributes, ""))
+	if !strings.Contains(attributes, "viewBox=") {
+		attributes = fmt.Sprintf(`viewBox="0 0 %s %s" %s`, formatNumber(width), formatNumber(height), attributes)
+	}
+	return fmt.Sprintf(`width="%.f" height="%.f" %s`, width*scale, height*scale, attributes)
+}
+
+// AddBackground returns the svg with a rect filling its viewBox (or viewport, if it has no viewBox) in the given colour, drawn beneath its content,
+// so that an image converted from it has that background rather than the converter's default. The svg is returned unchanged if the colour is empty or transparent.
+func AddBackground(svg []byte, colour string) []byte {
+	colour = strings.TrimSpace(colour)
+	if len(colour) == 0 || colour == "transparent" {
+		return svg
+	}
+	start := bytes.Index(svg, []byte("<svg"))
+	if start < 0 {
+		return svg
+	}
+	end := bytes.IndexByte(svg[start:], '>') + start
+	if end < start || svg[end-1] == '/' {
+		return svg
+	}
+	x, y, width, height := "0", "0", "100%", "100%"
+	if viewBox := parseNumbers(svgAttribute(svg[start:end+1], "viewBox")); len(viewBox) == 4 {
+		x, y, width, height = formatNumber(viewBox[0]), formatNumber(viewBox[1]), formatNumber(viewBox[2]), formatNumber(viewBox[3])
+	}
+	rect := fmt.Sprintf(`<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`, x, y, width, height, html.EscapeString(colour))
+	result := make([]byte, 0, len(svg)+len(rect))
+	result = append(result, svg[:end+1]...)
+	result = append(result, rect...)
+	return append(result, svg[end+1:]...)
+}
+
+// ResizeSVG returns the svg with the given width and height, so that an image converted from it is that size. A zero width or height is in proportion
+// to the other (or, if both are zero, the svg is returned unchanged). A viewBox of the svg's original size is added if it has none, so that its content is scaled too.
+func ResizeSVG(svg []byte, width float64, height float64) []byte {
+	if width <= 0 && height <= 0 {
+		return svg
+	}
+	start := bytes.Index(svg, []byte("<svg"))
+	if start < 0 {
+		return svg
+	}
+	end := bytes.IndexByte(svg[start:], '>') + start
+	if end < start {
+		return svg
+	}
+	if svg[end-1] == '/' {
+		end--
+	}
+	originalWidth, originalHeight := svgSize(svg[start:])
+	switch {
+	case width <= 0:
+		width = originalWidth * height / originalHeight
+	case height <= 0:
+		height = originalHeight * width / originalWidth
+	}
+	attributes := strings.TrimSpace(sizeAttribute.ReplaceAllString(string(svg[start+len("<svg"):end]), ""))
+	if !strings.Contains(attributes, "viewBox=") {
+		attributes = strings.TrimSpace(fmt.Sprintf(`viewBox="0 0 %s %s" %s`, formatNumber(originalWidth), formatNumber(originalHeight), attributes))
+	}
+	resized := fmt.Sprintf(`<svg width="%s" height="%s" %s`, formatNumber(width), formatNumber(height), attributes)
+	result := make([]byte, 0, len(svg)+len(resized))
+	result = append(result, svg[:start]...)
+	result = append(result, resized...)
+	return append(result, svg[end:]...)
+}
+
+// svgAttribute returns the value of the named attribute of the element's start tag, or an empty string if it has none
+func svgAttribute(startTag []byte, name string) string {
+	decoder := xml.NewDecoder(bytes.NewReader(append(startTag[:len(startTag):len(startTag)], "</svg>"...)))
+	decoder.Strict = false
+	token, err := decoder.Token()
+	if err != nil {
+		return ""
+	}
+	if start, ok := token.(xml.StartElement); ok {
+		return attributeMap(start.Attr)[name]
+	}
+	return ""
+}
+
+// formatNumber formats the number without trailing zeros
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// sizedAttributes adds width and height attributes to the svg attributes if they don't have them
+func sizedAttributes(attributes string, width float64, height float64) string {
+	if !strings.Contains(attributes, "width=") {
+		attributes = fmt.Sprintf(` width="%.f" height="%.f" %s`, width, height, strings.TrimSpace(attributes))
 	}
+	return attributes
 }
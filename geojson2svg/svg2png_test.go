@@ -2,12 +2,34 @@ package geojson2svg_test
 
 import (
 	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ONSdigital/dp-map-renderer/geojson2svg"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+// TestMain runs the conversions of the tests in a temporary directory of their own, failing if any files are left in it
+func TestMain(m *testing.M) {
+	tempDir, err := ioutil.TempDir("", "geojson2svg_test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Setenv("TMPDIR", tempDir)
+	code := m.Run()
+	if remaining, _ := filepath.Glob(filepath.Join(tempDir, "*")); code == 0 && len(remaining) > 0 {
+		fmt.Println("conversions left files in the temporary directory:", remaining)
+		code = 1
+	}
+	os.RemoveAll(tempDir)
+	os.Exit(code)
+}
+
 func Test_ConvertShouldFailWhenExecutableDoesNotExist(t *testing.T) {
 	Convey("Should invoke (non-existent) executable and return error", t, func() {
 
@@ -31,3 +53,298 @@ func Test_ConvertShouldInvokeExecutableAndBase64EncodeTheResult(t *testing.T) {
 		So(string(result), ShouldResemble, base64.StdEncoding.EncodeToString([]byte("MySVG")))
 	})
 }
+
+func Test_ConvertShouldReturnAConversionErrorWithTheOutputOfAFailedExecutable(t *testing.T) {
+	Convey("Should return a ConversionError with what the executable wrote to stderr", t, func() {
+
+		converter := geojson2svg.NewPNGConverter("sh", []string{"-c", "echo cannot convert >&2; exit 1"})
+
+		result, e := converter.Convert([]byte("MySVG"))
+		So(result, ShouldBeNil)
+		conversionError, ok := e.(*geojson2svg.ConversionError)
+		So(ok, ShouldBeTrue)
+		So(conversionError.Format, ShouldEqual, geojson2svg.ImageFormatPNG)
+		So(conversionError.Stderr, ShouldEqual, "cannot convert\n")
+		So(conversionError.TimedOut, ShouldBeFalse)
+		So(conversionError.Error(), ShouldContainSubstring, "cannot convert")
+	})
+}
+
+func Test_ConvertShouldTimeOut(t *testing.T) {
+	Convey("Given a conversion timeout", t, func() {
+		geojson2svg.SetConversionTimeout(50 * time.Millisecond)
+		defer geojson2svg.SetConversionTimeout(0)
+
+		converter := geojson2svg.NewPNGConverter("sleep", []string{"5"})
+
+		Convey("A conversion that takes longer should be killed and return a ConversionError that has timed out", func() {
+			start := time.Now()
+			result, e := converter.Convert([]byte("MySVG"))
+			So(time.Since(start), ShouldBeLessThan, 4*time.Second)
+			So(result, ShouldBeNil)
+			conversionError, ok := e.(*geojson2svg.ConversionError)
+			So(ok, ShouldBeTrue)
+			So(conversionError.TimedOut, ShouldBeTrue)
+		})
+	})
+}
+
+func Test_ConversionsShouldRemoveTheirFiles(t *testing.T) {
+	Convey("Given a temporary directory", t, func() {
+		tempDir := t.TempDir()
+		t.Setenv("TMPDIR", tempDir)
+		remaining := func() []string {
+			files, err := filepath.Glob(filepath.Join(tempDir, "*"))
+			So(err, ShouldBeNil)
+			return files
+		}
+
+		Convey("A conversion that succeeds should leave no files behind", func() {
+			converter := geojson2svg.NewPNGConverter("sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename + "; touch " + geojson2svg.ArgPNGFilename + ".log"})
+			_, e := converter.Convert([]byte("MySVG"))
+			So(e, ShouldBeNil)
+			So(remaining(), ShouldBeEmpty)
+		})
+
+		Convey("A conversion that fails should leave no files behind", func() {
+			converter := geojson2svg.NewPNGConverter("sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename + "; exit 1"})
+			_, e := converter.Convert([]byte("MySVG"))
+			So(e, ShouldNotBeNil)
+			So(remaining(), ShouldBeEmpty)
+		})
+
+		Convey("A conversion that times out should leave no files behind", func() {
+			geojson2svg.SetConversionTimeout(50 * time.Millisecond)
+			defer geojson2svg.SetConversionTimeout(0)
+
+			converter := geojson2svg.NewPNGConverter("sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename + "; exec sleep 5"})
+			_, e := converter.Convert([]byte("MySVG"))
+			conversionError, ok := e.(*geojson2svg.ConversionError)
+			So(ok, ShouldBeTrue)
+			So(conversionError.TimedOut, ShouldBeTrue)
+			So(remaining(), ShouldBeEmpty)
+		})
+	})
+}
+
+func Test_FallbackImageShouldReturnTheConversionError(t *testing.T) {
+	Convey("Should return the error rather than an svg with an unsupported browser message", t, func() {
+
+		converter := geojson2svg.NewPNGConverter("sh", []string{"-c", "exit 1"})
+
+		result, e := geojson2svg.FallbackImage(converter, geojson2svg.ImageFormatPNG, "", `viewBox="0 0 10 10"`, "", 10, 10)
+		So(e, ShouldNotBeNil)
+		So(result, ShouldBeEmpty)
+		So(converter.IncludeFallbackImage(`viewBox="0 0 10 10"`, "", 10, 10), ShouldContainSubstring, "<p>Unsupported Browser</p>")
+	})
+}
+
+func Test_ConversionsShouldBeLimited(t *testing.T) {
+	Convey("Given a limit of one conversion at a time", t, func() {
+		geojson2svg.LimitConcurrentConversions(1)
+		defer geojson2svg.LimitConcurrentConversions(0)
+
+		lock := t.TempDir() + "/converting"
+		// fails if another conversion is running
+		converter := geojson2svg.NewPNGConverter("sh", []string{"-c", "mkdir " + lock + " || exit 1; sleep 0.05; rmdir " + lock + "; cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename})
+
+		Convey("Concurrent conversions should run one after the other", func() {
+			errs := make(chan error, 4)
+			for i := 0; i < cap(errs); i++ {
+				go func() {
+					_, err := converter.Convert([]byte("MySVG"))
+					errs <- err
+				}()
+			}
+			for i := 0; i < cap(errs); i++ {
+				So(<-errs, ShouldBeNil)
+			}
+		})
+	})
+}
+
+func Test_RasterConverterShouldIncludeFallbackImageInItsFormat(t *testing.T) {
+	Convey("Should invoke executable with the image filename and include the image with its mime type", t, func() {
+
+		converter := geojson2svg.NewRasterConverter(geojson2svg.ImageFormatWebP, "sh", []string{"-c", "case " + geojson2svg.ArgImageFilename + " in *.webp) echo -n webp >> " + geojson2svg.ArgImageFilename + ";; esac"})
+		So(converter, ShouldNotBeNil)
+
+		result := converter.IncludeFallbackImage(`viewBox="0 0 10 10"`, "", 10, 10)
+		So(result, ShouldContainSubstring, `src="data:image/webp;base64,`+base64.StdEncoding.EncodeToString([]byte("webp"))+`"`)
+	})
+}
+
+func Test_DocumentConverterShouldPassEachPageToTheExecutable(t *testing.T) {
+	Convey("Should invoke executable with all the svg filenames and return the unencoded document", t, func() {
+
+		converter := geojson2svg.NewDocumentConverter("pdf", "sh", []string{"-c", `cat "$@" >> ` + geojson2svg.ArgImageFilename, "sh", geojson2svg.ArgSVGFilename})
+		So(converter, ShouldNotBeNil)
+
+		result, e := converter.ConvertPages([][]byte{[]byte("Page1"), []byte("Page2"), []byte("Page3")})
+		So(e, ShouldBeNil)
+		So(string(result), ShouldEqual, "Page1Page2Page3")
+	})
+}
+
+func Test_NewConverterShouldSelectTheBackend(t *testing.T) {
+	Convey("An unknown backend should be an error", t, func() {
+		converter, err := geojson2svg.NewConverter("inkscape", geojson2svg.ImageFormatPNG, "", nil)
+		So(converter, ShouldBeNil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("The command backend should require an executable", t, func() {
+		_, err := geojson2svg.NewConverter(geojson2svg.BackendCommand, geojson2svg.ImageFormatPNG, "", nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A backend that can't produce the format should be an error", t, func() {
+		_, err := geojson2svg.NewConverter(geojson2svg.BackendGo, "pdf", "", nil)
+		So(err, ShouldNotBeNil)
+		_, err = geojson2svg.NewConverter(geojson2svg.BackendResvg, geojson2svg.ImageFormatWebP, "", nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("The rsvg-convert backend should invoke the given executable in place of rsvg-convert, passing the format", t, func() {
+		converter, err := geojson2svg.NewConverter(geojson2svg.BackendRSVG, "pdf", "echo", nil)
+		So(err, ShouldBeNil)
+		So(converter.Capabilities().Backend, ShouldEqual, geojson2svg.BackendRSVG)
+		So(converter.Capabilities().Executable, ShouldEqual, "echo")
+		So(converter.Capabilities().MultiPage, ShouldBeTrue)
+
+		// echo writes nothing to the image file, so the conversion fails - but the error reports the backend
+		_, err = converter.ConvertPages([][]byte{[]byte("MySVG")})
+		conversionError, ok := err.(*geojson2svg.ConversionError)
+		So(ok, ShouldBeTrue)
+		So(conversionError.Backend, ShouldEqual, geojson2svg.BackendRSVG)
+		So(conversionError.Format, ShouldEqual, "pdf")
+	})
+
+	Convey("The go backend should convert svg to png in process", t, func() {
+		converter, err := geojson2svg.NewConverter(geojson2svg.BackendGo, geojson2svg.ImageFormatPNG, "", nil)
+		So(err, ShouldBeNil)
+		So(converter.Capabilities(), ShouldResemble, geojson2svg.Capabilities{Backend: geojson2svg.BackendGo, Formats: []string{geojson2svg.ImageFormatPNG, geojson2svg.ImageFormatJPEG}})
+
+		result, err := converter.Convert([]byte(`<svg xmlns="http://www.w3.org/2000/svg" width="8" height="4"><rect width="8" height="4" fill="blue"/></svg>`))
+		So(err, ShouldBeNil)
+		image, err := base64.StdEncoding.DecodeString(string(result))
+		So(err, ShouldBeNil)
+		So(string(image[1:4]), ShouldEqual, "PNG")
+	})
+}
+
+func Test_CommandBackendShouldReplaceTheFormatArgument(t *testing.T) {
+	Convey("The format argument should be replaced with the format of the converter", t, func() {
+		converter := geojson2svg.NewDocumentConverter("eps", "sh", []string{"-c", "echo " + geojson2svg.ArgFormat + " > " + geojson2svg.ArgImageFilename})
+
+		result, e := converter.ConvertPages([][]byte{[]byte("MySVG")})
+		So(e, ShouldBeNil)
+		So(string(result), ShouldEqual, "eps\n")
+	})
+}
+
+func Test_AddBackgroundShouldFillTheViewBox(t *testing.T) {
+	Convey("A background should be drawn beneath the content, filling the viewBox", t, func() {
+		svg := geojson2svg.AddBackground([]byte(`<?xml version="1.0"?><svg width="20" viewBox="-5 -5 10.5 10"><path d="M0 0"/></svg>`), "#ff0000")
+		So(string(svg), ShouldEqual, `<?xml version="1.0"?><svg width="20" viewBox="-5 -5 10.5 10"><rect x="-5" y="-5" width="10.5" height="10" fill="#ff0000"/><path d="M0 0"/></svg>`)
+	})
+
+	Convey("A background should fill an svg without a viewBox", t, func() {
+		svg := geojson2svg.AddBackground([]byte(`<svg width="20" height="10"></svg>`), "white")
+		So(string(svg), ShouldEqual, `<svg width="20" height="10"><rect x="0" y="0" width="100%" height="100%" fill="white"/></svg>`)
+	})
+
+	Convey("An empty or transparent background should leave the svg unchanged", t, func() {
+		So(string(geojson2svg.AddBackground([]byte(`<svg></svg>`), "")), ShouldEqual, `<svg></svg>`)
+		So(string(geojson2svg.AddBackground([]byte(`<svg></svg>`), "transparent")), ShouldEqual, `<svg></svg>`)
+		So(string(geojson2svg.AddBackground([]byte(`not svg`), "red")), ShouldEqual, `not svg`)
+	})
+}
+
+func Test_JPEGQualityShouldBePassedToTheExecutable(t *testing.T) {
+	Convey("The quality argument should be replaced with the jpeg quality", t, func() {
+		geojson2svg.SetJPEGQuality(60)
+		defer geojson2svg.SetJPEGQuality(0)
+
+		converter := geojson2svg.NewDocumentConverter(geojson2svg.ImageFormatJPEG, "sh", []string{"-c", "echo " + geojson2svg.ArgQuality + " > " + geojson2svg.ArgImageFilename})
+		result, e := converter.ConvertPages([][]byte{[]byte("MySVG")})
+		So(e, ShouldBeNil)
+		So(string(result), ShouldEqual, "60\n")
+	})
+
+	Convey("The go backend should convert svg to jpeg", t, func() {
+		converter, err := geojson2svg.NewConverter(geojson2svg.BackendGo, geojson2svg.ImageFormatJPEG, "", nil)
+		So(err, ShouldBeNil)
+		image, err := converter.ConvertPages([][]byte{[]byte(`<svg width="8" height="4"><rect width="4" height="4" fill="blue"/></svg>`)})
+		So(err, ShouldBeNil)
+		So(image[:2], ShouldResemble, []byte{0xff, 0xd8})
+	})
+}
+
+func Test_HighDPIFallbackImageShouldIncludeAnImageOfTwiceTheSize(t *testing.T) {
+	Convey("Given a converter that returns the svg it is given", t, func() {
+		converter := geojson2svg.NewPNGConverter("sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename})
+		encoded := func(svg string) string { return base64.StdEncoding.EncodeToString([]byte(svg)) }
+
+		Convey("The srcset should have an image of an svg of twice the size, with the same viewBox", func() {
+			result, err := geojson2svg.HighDPIFallbackImage(converter, geojson2svg.ImageFormatPNG, "", `id="map" width="40" height="20" viewBox="0 0 40 20"`, `<path stroke-width="1"/>`, 40, 20)
+			So(err, ShouldBeNil)
+			So(result, ShouldContainSubstring, `src="data:image/png;base64,`+encoded(`<svg id="map" width="40" height="20" viewBox="0 0 40 20"><path stroke-width="1"/></svg>`)+`"`)
+			So(result, ShouldContainSubstring, `srcset="data:image/png;base64,`+encoded(`<svg width="80" height="40" id="map" viewBox="0 0 40 20"><path stroke-width="1"/></svg>`)+` 2x"`)
+		})
+
+		Convey("A viewBox should be added to an svg without one, so that its content is scaled", func() {
+			result, err := geojson2svg.HighDPIFallbackImage(converter, geojson2svg.ImageFormatPNG, "", `id="map"`, ``, 40, 20)
+			So(err, ShouldBeNil)
+			So(result, ShouldContainSubstring, encoded(`<svg width="80" height="40" viewBox="0 0 40 20" id="map"></svg>`)+` 2x"`)
+		})
+	})
+}
+
+func Test_FallbackPictureShouldOfferTheSVGAndEachImage(t *testing.T) {
+	Convey("Given a converter that returns the svg it is given", t, func() {
+		converter := geojson2svg.NewPNGConverter("sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename})
+		encoded := func(svg string) string { return base64.StdEncoding.EncodeToString([]byte(svg)) }
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" id="map" width="40" height="20"><path/></svg>`
+
+		Convey("The picture should have the svg as its first source, then the other sources, then the img", func() {
+			webp := geojson2svg.PictureSource{Converter: converter, Format: geojson2svg.ImageFormatWebP}
+			result, err := geojson2svg.FallbackPicture(converter, geojson2svg.ImageFormatPNG, "A map", `id="map" width="40" height="20"`, `<path/>`, 40, 20, webp)
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, `<picture>`+
+				`<source type="image/svg+xml" srcset="data:image/svg+xml;base64,`+encoded(svg)+`" />`+
+				`<source type="image/webp" srcset="data:image/webp;base64,`+encoded(svg)+`" />`+
+				`<img alt="A map" width="40" height="20" src="data:image/png;base64,`+encoded(svg)+`" />`+
+				`</picture>`)
+		})
+
+		Convey("The img of a responsive svg should fill the width of its container", func() {
+			result, err := geojson2svg.FallbackPicture(converter, geojson2svg.ImageFormatPNG, "", `id="map" style="width:100%;"`, `<path/>`, 40, 20)
+			So(err, ShouldBeNil)
+			So(result, ShouldContainSubstring, `width="40" height="20" style="width:100%;height:auto;" src=`)
+		})
+
+		Convey("An error converting a source should be returned", func() {
+			failing := geojson2svg.PictureSource{Converter: geojson2svg.NewPNGConverter("false", nil), Format: geojson2svg.ImageFormatWebP}
+			_, err := geojson2svg.FallbackPicture(converter, geojson2svg.ImageFormatPNG, "", `id="map"`, `<path/>`, 40, 20, failing)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func Test_ResizeSVG(t *testing.T) {
+	Convey("An svg should be given the width and height, with a viewBox of its original size", t, func() {
+		resized := geojson2svg.ResizeSVG([]byte(`<?xml version="1.0"?><svg id="map" width="40" height="20"><path/></svg>`), 80, 30)
+		So(string(resized), ShouldEqual, `<?xml version="1.0"?><svg width="80" height="30" viewBox="0 0 40 20" id="map"><path/></svg>`)
+	})
+
+	Convey("A missing dimension should be in proportion to the viewBox", t, func() {
+		resized := geojson2svg.ResizeSVG([]byte(`<svg viewBox="0 0 40 20"/>`), 0, 50)
+		So(string(resized), ShouldEqual, `<svg width="100" height="50" viewBox="0 0 40 20"/>`)
+	})
+
+	Convey("An svg should be unchanged without dimensions", t, func() {
+		svg := []byte(`<svg width="40" height="20"></svg>`)
+		So(geojson2svg.ResizeSVG(svg, 0, 0), ShouldResemble, svg)
+	})
+}
@@ -0,0 +1,336 @@
+package geojson2svg
+
+import (
+	"encoding/xml"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// attributeMap returns the attributes by (local) name
+func attributeMap(attrs []xml.Attr) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		m[a.Name.Local] = a.Value
+	}
+	return m
+}
+
+// styleProperties parses the declarations of a style attribute, e.g. "fill: red; stroke: black"
+func styleProperties(style string) map[string]string {
+	properties := make(map[string]string)
+	for _, declaration := range strings.Split(style, ";") {
+		if i := strings.Index(declaration, ":"); i > 0 {
+			properties[strings.TrimSpace(declaration[:i])] = strings.TrimSpace(declaration[i+1:])
+		}
+	}
+	return properties
+}
+
+// applyStyle returns the style with the presentation attributes and style properties of an element applied - style properties take precedence
+func applyStyle(style rasterStyle, attrs map[string]string) rasterStyle {
+	properties := styleProperties(attrs["style"])
+	value := func(name string) (string, bool) {
+		if v, ok := properties[name]; ok {
+			return v, true
+		}
+		v, ok := attrs[name]
+		return v, ok
+	}
+	if v, ok := value("fill"); ok {
+		style.fill = parsePaint(v, style.fill)
+	}
+	if v, ok := value("stroke"); ok {
+		style.stroke = parsePaint(v, style.stroke)
+	}
+	if v, ok := value("stroke-width"); ok {
+		style.strokeWidth = parseLength(v)
+	}
+	if v, ok := value("fill-opacity"); ok {
+		style.fillOpacity = parseOpacity(v)
+	}
+	if v, ok := value("stroke-opacity"); ok {
+		style.strokeOpacity = parseOpacity(v)
+	}
+	if v, ok := value("opacity"); ok {
+		style.opacity *= parseOpacity(v)
+	}
+	return style
+}
+
+// namedColours are the colour keywords understood by the go backend
+var namedColours = map[string]color.NRGBA{
+	"black": {0, 0, 0, 0xff}, "white": {0xff, 0xff, 0xff, 0xff}, "red": {0xff, 0, 0, 0xff}, "green": {0, 0x80, 0, 0xff}, "blue": {0, 0, 0xff, 0xff},
+	"yellow": {0xff, 0xff, 0, 0xff}, "orange": {0xff, 0xa5, 0, 0xff}, "purple": {0x80, 0, 0x80, 0xff}, "grey": {0x80, 0x80, 0x80, 0xff},
+	"gray": {0x80, 0x80, 0x80, 0xff}, "dimgrey": {0x69, 0x69, 0x69, 0xff}, "dimgray": {0x69, 0x69, 0x69, 0xff}, "lightgrey": {0xd3, 0xd3, 0xd3, 0xff},
+	"lightgray": {0xd3, 0xd3, 0xd3, 0xff}, "darkgrey": {0xa9, 0xa9, 0xa9, 0xff}, "darkgray": {0xa9, 0xa9, 0xa9, 0xff}, "silver": {0xc0, 0xc0, 0xc0, 0xff},
+}
+
+// parsePaint parses a fill or stroke - none, a colour, or a pattern (url(#...)), which is painted in patternFill. Unrecognised values leave the paint unchanged.
+func parsePaint(value string, current paint) paint {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch {
+	case value == "none" || value == "transparent":
+		return paint{none: true}
+	case value == "inherit":
+		return current
+	case strings.HasPrefix(value, "url("):
+		return paint{colour: patternFill}
+	case strings.HasPrefix(value, "#"):
+		hex := value[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if n, err := strconv.ParseUint(hex, 16, 32); err == nil && len(hex) == 6 {
+			return paint{colour: color.NRGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 0xff}}
+		}
+	case strings.HasPrefix(value, "rgb"):
+		if i, j := strings.Index(value, "("), strings.Index(value, ")"); i > 0 && j > i {
+			parts := parseNumbers(value[i+1 : j])
+			if len(parts) >= 3 {
+				c := color.NRGBA{clampByte(parts[0]), clampByte(parts[1]), clampByte(parts[2]), 0xff}
+				if len(parts) == 4 {
+					c.A = clampByte(parts[3] * 0xff)
+				}
+				return paint{colour: c}
+			}
+		}
+	default:
+		if c, ok := namedColours[value]; ok {
+			return paint{colour: c}
+		}
+	}
+	return current
+}
+
+// clampByte returns the value rounded and clamped to 0-255
+func clampByte(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(0xff, math.Round(v))))
+}
+
+// parseOpacity parses an opacity between 0 and 1, or a percentage
+func parseOpacity(value string) float64 {
+	value = strings.TrimSpace(value)
+	scale := 1.0
+	if strings.HasSuffix(value, "%") {
+		value, scale = strings.TrimSuffix(value, "%"), 0.01
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 1
+	}
+	return math.Max(0, math.Min(1, v*scale))
+}
+
+// parseLength parses a length in user units (or px). Other units, and percentages, are not supported and parse as 0.
+func parseLength(value string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "px"), 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// parseNumbers parses a list of numbers separated by commas and/or whitespace, e.g. a viewBox or the points of a polygon
+func parseNumbers(value string) []float64 {
+	var numbers []float64
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r' }) {
+		if v, err := strconv.ParseFloat(field, 64); err == nil {
+			numbers = append(numbers, v)
+		}
+	}
+	return numbers
+}
+
+// parseTransform parses a transform attribute, e.g. "translate(10, 20) scale(2)"
+func parseTransform(value string) matrix {
+	m := identity
+	for {
+		open := strings.Index(value, "(")
+		end := strings.Index(value, ")")
+		if open < 0 || end < open {
+			return m
+		}
+		name, args := strings.TrimSpace(strings.Trim(value[:open], " ,")), parseNumbers(value[open+1:end])
+		value = value[end+1:]
+		var t matrix
+		switch {
+		case name == "translate" && len(args) >= 1:
+			t = matrix{1, 0, 0, 1, args[0], 0}
+			if len(args) > 1 {
+				t[5] = args[1]
+			}
+		case name == "scale" && len(args) >= 1:
+			t = matrix{args[0], 0, 0, args[0], 0, 0}
+			if len(args) > 1 {
+				t[3] = args[1]
+			}
+		case name == "rotate" && len(args) >= 1:
+			a := args[0] * math.Pi / 180
+			t = matrix{math.Cos(a), math.Sin(a), -math.Sin(a), math.Cos(a), 0, 0}
+			if len(args) == 3 {
+				t = matrix{1, 0, 0, 1, args[1], args[2]}.then(t).then(matrix{1, 0, 0, 1, -args[1], -args[2]})
+			}
+		case name == "matrix" && len(args) == 6:
+			copy(t[:], args)
+		default:
+			continue
+		}
+		m = m.then(t)
+	}
+}
+
+// curveSegments is the number of lines that each curve of a path is approximated with
+const curveSegments = 16
+
+// parsePath parses the d attribute of a path into transformed subpaths. Curves are approximated by lines, and arcs by a line to their end.
+func parsePath(d string, transform matrix) [][]point {
+	var subpaths [][]point
+	var current []point
+	var x, y, startX, startY float64
+	add := func(px, py float64) {
+		x, y = px, py
+		current = append(current, transform.apply(x, y))
+	}
+	tokens := pathTokens(d)
+	command := byte(0)
+	for i := 0; i < len(tokens); {
+		if tokens[i].command != 0 {
+			command = tokens[i].command
+			i++
+			if command == 'Z' || command == 'z' {
+				if len(current) > 0 {
+					add(startX, startY)
+				}
+				continue
+			}
+		}
+		args := pathArgs[command|0x20]
+		if args == 0 || i+args > len(tokens) || !allNumbers(tokens[i:i+args]) {
+			i++
+			continue
+		}
+		n := make([]float64, args)
+		for j := range n {
+			n[j] = tokens[i+j].number
+		}
+		i += args
+		relative := command >= 'a'
+		ox, oy := 0.0, 0.0
+		if relative {
+			ox, oy = x, y
+		}
+		switch command | 0x20 {
+		case 'm':
+			if len(current) > 1 {
+				subpaths = append(subpaths, current)
+			}
+			current = nil
+			add(ox+n[0], oy+n[1])
+			startX, startY = x, y
+			// subsequent pairs are lines
+			command = 'L' | (command & 0x20)
+		case 'l', 't':
+			add(ox+n[0], oy+n[1])
+		case 'h':
+			add(ox+n[0], y)
+		case 'v':
+			add(x, oy+n[0])
+		case 'c':
+			x0, y0 := x, y
+			for s := 1; s <= curveSegments; s++ {
+				t := float64(s) / curveSegments
+				u := 1 - t
+				add(u*u*u*x0+3*u*u*t*(ox+n[0])+3*u*t*t*(ox+n[2])+t*t*t*(ox+n[4]), u*u*u*y0+3*u*u*t*(oy+n[1])+3*u*t*t*(oy+n[3])+t*t*t*(oy+n[5]))
+			}
+		case 's', 'q':
+			x0, y0 := x, y
+			for s := 1; s <= curveSegments; s++ {
+				t := float64(s) / curveSegments
+				u := 1 - t
+				add(u*u*x0+2*u*t*(ox+n[0])+t*t*(ox+n[2]), u*u*y0+2*u*t*(oy+n[1])+t*t*(oy+n[3]))
+			}
+		case 'a':
+			add(ox+n[5], oy+n[6])
+		}
+	}
+	if len(current) > 1 {
+		subpaths = append(subpaths, current)
+	}
+	return subpaths
+}
+
+// pathArgs is the number of arguments of each (lower case) path command
+var pathArgs = map[byte]int{'m': 2, 'l': 2, 'h': 1, 'v': 1, 'c': 6, 's': 4, 'q': 4, 't': 2, 'a': 7}
+
+// pathToken is a command or number of a path's d attribute
+type pathToken struct {
+	command byte
+	number  float64
+}
+
+// allNumbers returns true if none of the tokens is a command
+func allNumbers(tokens []pathToken) bool {
+	for _, t := range tokens {
+		if t.command != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// pathTokens splits the d attribute of a path into commands and numbers
+func pathTokens(d string) []pathToken {
+	var tokens []pathToken
+	for i := 0; i < len(d); {
+		c := d[i]
+		switch {
+		case c == ' ' || c == ',' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0:
+			tokens = append(tokens, pathToken{command: c})
+			i++
+		default:
+			j := numberEnd(d, i)
+			if j == i {
+				i++
+				continue
+			}
+			if v, err := strconv.ParseFloat(d[i:j], 64); err == nil {
+				tokens = append(tokens, pathToken{number: v})
+			}
+			i = j
+		}
+	}
+	return tokens
+}
+
+// numberEnd returns the index after the number starting at i - an optional sign, digits with at most one decimal point, and an optional exponent
+func numberEnd(s string, i int) int {
+	j := i
+	if j < len(s) && (s[j] == '-' || s[j] == '+') {
+		j++
+	}
+	point := false
+	for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' && !point) {
+		point = point || s[j] == '.'
+		j++
+	}
+	if j < len(s) && (s[j] == 'e' || s[j] == 'E') {
+		k := j + 1
+		if k < len(s) && (s[k] == '-' || s[k] == '+') {
+			k++
+		}
+		if k < len(s) && s[k] >= '0' && s[k] <= '9' {
+			for k < len(s) && s[k] >= '0' && s[k] <= '9' {
+				k++
+			}
+			j = k
+		}
+	}
+	if j == i+1 && (s[i] == '-' || s[i] == '+' || s[i] == '.') {
+		return i
+	}
+	return j
+}
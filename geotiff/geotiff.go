@@ -0,0 +1,143 @@
+// Package geotiff encodes paletted images as GeoTIFFs, georeferenced to longitude/latitude (WGS 84) with square pixels.
+package geotiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"strconv"
+)
+
+// tiff field types
+const (
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+	typeASCII  = 2
+)
+
+// ErrPaletteTooLarge is returned when encoding an image with more than 256 colours in its palette
+var ErrPaletteTooLarge = errors.New("palette must have no more than 256 colours")
+
+// Georeference locates an image on the earth
+type Georeference struct {
+	West      float64 // the longitude of the left edge of the image
+	North     float64 // the latitude of the top edge of the image
+	PixelSize float64 // the width and height of each pixel, in degrees
+	NoData    *uint8  // the palette index of pixels without data, if any
+}
+
+// entry is a tiff directory entry
+type entry struct {
+	tag      uint16
+	dataType uint16
+	count    uint32
+	data     []byte
+}
+
+// Encode writes the image as an uncompressed, single strip, little-endian GeoTIFF with a colour map, in the geographic (EPSG:4326) coordinate system.
+func Encode(w io.Writer, img *image.Paletted, geo Georeference) error {
+	if len(img.Palette) > 256 {
+		return ErrPaletteTooLarge
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	pixels := make([]byte, 0, width*height)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		offset := img.PixOffset(img.Rect.Min.X, y)
+		pixels = append(pixels, img.Pix[offset:offset+width]...)
+	}
+
+	colourMap := make([]uint16, 256*3)
+	for i, c := range img.Palette {
+		r, g, b, _ := c.RGBA()
+		colourMap[i], colourMap[256+i], colourMap[512+i] = uint16(r), uint16(g), uint16(b)
+	}
+
+	const headerSize = 8
+	entries := []*entry{
+		longEntry(256, uint32(width)),
+		longEntry(257, uint32(height)),
+		shortEntry(258, 8),
+		shortEntry(259, 1),             // no compression
+		shortEntry(262, 3),             // palette colour
+		longEntry(273, headerSize),     // the pixels immediately follow the header
+		shortEntry(277, 1),             // samples per pixel
+		longEntry(278, uint32(height)), // rows per strip
+		longEntry(279, uint32(len(pixels))),
+		shortEntry(284, 1), // planar configuration
+		shortEntry(320, colourMap...),
+		doubleEntry(33550, geo.PixelSize, geo.PixelSize, 0), // ModelPixelScale
+		doubleEntry(33922, 0, 0, 0, geo.West, geo.North, 0), // ModelTiepoint
+		// GeoKeyDirectory: version 1.1.0 with 3 keys - GTModelType geographic, GTRasterType pixel is area, GeographicType WGS 84
+		shortEntry(34735, 1, 1, 0, 3, 1024, 0, 1, 2, 1025, 0, 1, 1, 2048, 0, 1, 4326),
+	}
+	if geo.NoData != nil {
+		entries = append(entries, asciiEntry(42113, strconv.Itoa(int(*geo.NoData)))) // GDAL_NODATA
+	}
+
+	// the directory follows the pixels (word aligned), and values too large to fit in an entry follow the directory
+	ifdOffset := headerSize + len(pixels) + len(pixels)%2
+	valuesOffset := ifdOffset + 2 + len(entries)*12 + 4
+
+	var buf bytes.Buffer
+	buf.WriteString("II")
+	binary.Write(&buf, binary.LittleEndian, uint16(42))
+	binary.Write(&buf, binary.LittleEndian, uint32(ifdOffset))
+	buf.Write(pixels)
+	if len(pixels)%2 == 1 {
+		buf.WriteByte(0)
+	}
+
+	var values bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, e.tag)
+		binary.Write(&buf, binary.LittleEndian, e.dataType)
+		binary.Write(&buf, binary.LittleEndian, e.count)
+		if len(e.data) <= 4 {
+			var value [4]byte
+			copy(value[:], e.data)
+			buf.Write(value[:])
+			continue
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(valuesOffset+values.Len()))
+		values.Write(e.data)
+		if values.Len()%2 == 1 {
+			values.WriteByte(0)
+		}
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0)) // no further directories
+	buf.Write(values.Bytes())
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func shortEntry(tag uint16, values ...uint16) *entry {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, values)
+	return &entry{tag: tag, dataType: typeShort, count: uint32(len(values)), data: data.Bytes()}
+}
+
+func longEntry(tag uint16, value uint32) *entry {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, value)
+	return &entry{tag: tag, dataType: typeLong, count: 1, data: data.Bytes()}
+}
+
+func doubleEntry(tag uint16, values ...float64) *entry {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(data[i*8:], math.Float64bits(v))
+	}
+	return &entry{tag: tag, dataType: typeDouble, count: uint32(len(values)), data: data}
+}
+
+func asciiEntry(tag uint16, value string) *entry {
+	data := append([]byte(value), 0)
+	return &entry{tag: tag, dataType: typeASCII, count: uint32(len(data)), data: data}
+}
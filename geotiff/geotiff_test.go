@@ -0,0 +1,66 @@
+package geotiff_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/geotiff"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tiffEntry returns the type, count and value (or offset of the value) of the tag in the first directory of the little-endian tiff
+func tiffEntry(b []byte, tag uint16) (uint16, uint32, uint32) {
+	ifd := binary.LittleEndian.Uint32(b[4:])
+	count := int(binary.LittleEndian.Uint16(b[ifd:]))
+	for i := 0; i < count; i++ {
+		e := b[int(ifd)+2+i*12:]
+		if binary.LittleEndian.Uint16(e) == tag {
+			return binary.LittleEndian.Uint16(e[2:]), binary.LittleEndian.Uint32(e[4:]), binary.LittleEndian.Uint32(e[8:])
+		}
+	}
+	return 0, 0, 0
+}
+
+func TestEncode(t *testing.T) {
+	Convey("Given a 3x2 paletted image", t, func() {
+		img := image.NewPaletted(image.Rect(0, 0, 3, 2), color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}})
+		copy(img.Pix, []uint8{0, 1, 0, 1, 0, 1})
+		noData := uint8(1)
+
+		Convey("Encode should write the pixels, colour map and georeferencing", func() {
+			var buf bytes.Buffer
+			So(geotiff.Encode(&buf, img, geotiff.Georeference{West: -2, North: 52, PixelSize: 0.5, NoData: &noData}), ShouldBeNil)
+			b := buf.Bytes()
+
+			So(string(b[:4]), ShouldEqual, "II*\x00")
+			_, _, width := tiffEntry(b, 256)
+			_, _, height := tiffEntry(b, 257)
+			So(width, ShouldEqual, 3)
+			So(height, ShouldEqual, 2)
+			_, _, offset := tiffEntry(b, 273)
+			So(b[offset:offset+6], ShouldResemble, []byte{0, 1, 0, 1, 0, 1})
+
+			_, count, colourMap := tiffEntry(b, 320)
+			So(count, ShouldEqual, 768)
+			So(binary.LittleEndian.Uint16(b[colourMap:]), ShouldEqual, 0xffff)         // red of colour 0
+			So(binary.LittleEndian.Uint16(b[colourMap+2*512+2:]), ShouldEqual, 0xffff) // blue of colour 1
+
+			dataType, count, tiepoint := tiffEntry(b, 33922)
+			So(dataType, ShouldEqual, 12)
+			So(count, ShouldEqual, 6)
+			So(math.Float64frombits(binary.LittleEndian.Uint64(b[tiepoint+24:])), ShouldEqual, -2)
+			So(math.Float64frombits(binary.LittleEndian.Uint64(b[tiepoint+32:])), ShouldEqual, 52)
+
+			_, _, scale := tiffEntry(b, 33550)
+			So(math.Float64frombits(binary.LittleEndian.Uint64(b[scale:])), ShouldEqual, 0.5)
+
+			_, count, noDataValue := tiffEntry(b, 42113)
+			So(count, ShouldEqual, 2)
+			So(noDataValue&0xffff, ShouldEqual, '1')
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// The possible health statuses, in increasing order of severity
+const (
+	StatusOK       = "OK"
+	StatusWarning  = "WARNING"
+	StatusCritical = "CRITICAL"
+)
+
+// PNGConverterCheckName is the name of the check that confirms the png converter is working
+const PNGConverterCheckName = "png_converter"
+
+// Version is the build version of the service, set at build time using -ldflags "-X github.com/ONSdigital/dp-map-renderer/health.Version=..."
+var Version = "development"
+
+// testSVG is a minimal svg used to confirm that the png converter is working
+const testSVG = `<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1"><rect width="1" height="1" /></svg>`
+
+var (
+	startTime = time.Now()
+
+	checksMutex sync.RWMutex
+	checks      = map[string]*Check{
+		PNGConverterCheckName: {Name: PNGConverterCheckName, Status: StatusWarning, Message: "png converter has not yet been checked"},
+	}
+	converters = map[string]g2s.Capabilities{}
+)
+
+// Check is the result of checking a single component of the service
+type Check struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Message     string     `json:"message,omitempty"`
+	LastChecked *time.Time `json:"last_checked,omitempty"`
+}
+
+// Response is the body returned by Healthcheck
+type Response struct {
+	Status     string                      `json:"status"`
+	Version    string                      `json:"version"`
+	StartTime  string                      `json:"start_time"`
+	Uptime     string                      `json:"uptime"`
+	Checks     []*Check                    `json:"checks"`
+	Converters map[string]g2s.Capabilities `json:"converters,omitempty"` // the capabilities of the converter for each format, e.g. png
+}
+
+// CheckPNGConverter confirms that the png converter is available by converting a tiny svg, recording the result for Healthcheck.
+// A converter that is missing or fails is critical, as png maps cannot be rendered.
+func CheckPNGConverter(converter g2s.PNGConverter) error {
+	err := errors.New("no png converter is configured")
+	if converter != nil {
+		_, err = converter.Convert([]byte(testSVG))
+	}
+
+	now := time.Now()
+	check := &Check{Name: PNGConverterCheckName, Status: StatusOK, LastChecked: &now}
+	if err != nil {
+		log.ErrorC("png converter check", err, nil)
+		check.Status = StatusCritical
+		check.Message = err.Error()
+	}
+
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+	checks[PNGConverterCheckName] = check
+	return err
+}
+
+// RegisterConverter records the capabilities of the converter for the given format (e.g. png), for Healthcheck to report.
+// Converters that can't describe their backend (i.e. that aren't a g2s.CapabilityReporter) are ignored.
+func RegisterConverter(format string, converter interface{}) {
+	reporter, ok := converter.(g2s.CapabilityReporter)
+	if !ok {
+		return
+	}
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+	converters[format] = reporter.Capabilities()
+}
+
+// Healthcheck returns the health of the service, its version and the result of each check.
+// Returns 200 if all checks are OK, 429 if any check is a warning (e.g. has not yet completed) and 500 if any check is critical.
+func Healthcheck(w http.ResponseWriter, req *http.Request) {
+	response := getResponse()
+
+	healthJSON, err := json.Marshal(response)
+	if err != nil {
+		log.ErrorC("marshal json", err, log.Data{"struct": response})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode(response.Status))
+	if _, err = w.Write(healthJSON); err != nil {
+		log.ErrorC("writing json body", err, log.Data{"json": string(healthJSON)})
+	}
+}
+
+// getResponse creates a Response from the current state of the checks, with the overall status being the most severe status of any check
+func getResponse() *Response {
+	checksMutex.RLock()
+	defer checksMutex.RUnlock()
+
+	response := &Response{
+		Status:    StatusOK,
+		Version:   Version,
+		StartTime: startTime.UTC().Format(time.RFC3339),
+		Uptime:    time.Since(startTime).Round(time.Second).String(),
+		Checks:    []*Check{},
+	}
+	if len(converters) > 0 {
+		response.Converters = make(map[string]g2s.Capabilities, len(converters))
+		for format, capabilities := range converters {
+			response.Converters[format] = capabilities
+		}
+	}
+	names := []string{}
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		check := checks[name]
+		response.Checks = append(response.Checks, check)
+		if severity(check.Status) > severity(response.Status) {
+			response.Status = check.Status
+		}
+	}
+	return response
+}
+
+func severity(status string) int {
+	switch status {
+	case StatusOK:
+		return 0
+	case StatusWarning:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func statusCode(status string) int {
+	switch status {
+	case StatusOK:
+		return http.StatusOK
+	case StatusWarning:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeConverter is a PNGConverter that returns the given error
+type fakeConverter struct {
+	err error
+}
+
+func (f *fakeConverter) Convert(svg []byte) ([]byte, error) {
+	return []byte("cG5n"), f.err
+}
+
+func (f *fakeConverter) IncludeFallbackImage(attributes string, content string, width float64, height float64) string {
+	return ""
+}
+
+func TestHealthcheck(t *testing.T) {
+	Convey("Healthcheck should return 429 before the png converter has been checked", t, func() {
+		w := invokeHealthcheck()
+		So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+		So(decodeResponse(w).Status, ShouldEqual, StatusWarning)
+	})
+
+	Convey("Healthcheck should return 200 when the png converter is working", t, func() {
+		So(CheckPNGConverter(&fakeConverter{}), ShouldBeNil)
+
+		w := invokeHealthcheck()
+		So(w.Code, ShouldEqual, http.StatusOK)
+		response := decodeResponse(w)
+		So(response.Status, ShouldEqual, StatusOK)
+		So(response.Version, ShouldEqual, Version)
+		So(len(response.Checks), ShouldEqual, 1)
+		So(response.Checks[0].Name, ShouldEqual, PNGConverterCheckName)
+		So(response.Checks[0].LastChecked, ShouldNotBeNil)
+	})
+
+	Convey("Healthcheck should return 500 when the png converter fails", t, func() {
+		So(CheckPNGConverter(&fakeConverter{err: errors.New("executable not found")}), ShouldNotBeNil)
+
+		w := invokeHealthcheck()
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+		response := decodeResponse(w)
+		So(response.Status, ShouldEqual, StatusCritical)
+		So(response.Checks[0].Message, ShouldEqual, "executable not found")
+	})
+
+	Convey("Healthcheck should return 500 when there is no png converter", t, func() {
+		So(CheckPNGConverter(nil), ShouldNotBeNil)
+
+		w := invokeHealthcheck()
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+	})
+}
+
+func TestRegisterConverter(t *testing.T) {
+	Convey("Healthcheck should report the capabilities of registered converters", t, func() {
+		converter, err := g2s.NewConverter(g2s.BackendGo, g2s.ImageFormatPNG, "", nil)
+		So(err, ShouldBeNil)
+		RegisterConverter(g2s.ImageFormatPNG, converter)
+		RegisterConverter("webp", &fakeConverter{})
+		defer func() { converters = map[string]g2s.Capabilities{} }()
+
+		response := decodeResponse(invokeHealthcheck())
+		So(len(response.Converters), ShouldEqual, 1)
+		So(response.Converters[g2s.ImageFormatPNG].Backend, ShouldEqual, g2s.BackendGo)
+		So(response.Converters[g2s.ImageFormatPNG].Text, ShouldBeFalse)
+	})
+}
+
+func invokeHealthcheck() *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	Healthcheck(w, httptest.NewRequest("GET", "/health", nil))
+	So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+	return w
+}
+
+func decodeResponse(w *httptest.ResponseRecorder) *Response {
+	var response Response
+	So(json.Unmarshal(w.Body.Bytes(), &response), ShouldBeNil)
+	return &response
+}
@@ -0,0 +1,105 @@
+package htmlutil
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedSVGElements are the elements kept by SanitiseSVG - shapes, groups, paint servers and the elements that reference them.
+// Everything else (script, foreignObject, style, a, image, animation elements etc) is removed along with its content.
+var allowedSVGElements = map[string]bool{
+	"defs":           true,
+	"pattern":        true,
+	"g":              true,
+	"path":           true,
+	"rect":           true,
+	"circle":         true,
+	"ellipse":        true,
+	"line":           true,
+	"polyline":       true,
+	"polygon":        true,
+	"linearGradient": true,
+	"radialGradient": true,
+	"stop":           true,
+	"clipPath":       true,
+	"mask":           true,
+	"use":            true,
+	"title":          true,
+	"desc":           true,
+}
+
+var (
+	// externalReference matches a css url() that doesn't refer to an element of the document
+	externalReference = regexp.MustCompile(`(?i)url\(\s*['"]?\s*[^#'"\s]`)
+	// scriptValue matches attribute values that may run script
+	scriptValue = regexp.MustCompile(`(?i)javascript:|expression\(`)
+)
+
+// SanitiseSVG returns the svg fragment with everything that could run script or load external content removed:
+// elements other than allowedSVGElements, event handler (on*) attributes, links to anything other than an element of the document,
+// and attributes with external url() references or script.
+// The fragment is parsed as the content of an svg element within html, so any content after a closing </svg> tag is html and is removed.
+func SanitiseSVG(fragment string) (string, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader("<svg>"+fragment), body)
+	if err != nil || len(nodes) == 0 {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for n := nodes[0].FirstChild; n != nil; n = n.NextSibling {
+		if !sanitiseSVGNode(n) {
+			continue
+		}
+		if err := html.Render(&buf, n); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// sanitiseSVGNode removes unsafe attributes from the node and unsafe descendants, returning false if the node itself should be removed
+func sanitiseSVGNode(n *html.Node) bool {
+	switch n.Type {
+	case html.TextNode:
+		return true
+	case html.ElementNode:
+		if n.Namespace != "svg" || !allowedSVGElements[n.Data] {
+			return false
+		}
+	default:
+		return false
+	}
+
+	attributes := n.Attr[:0]
+	for _, a := range n.Attr {
+		if isSafeSVGAttribute(a) {
+			attributes = append(attributes, a)
+		}
+	}
+	n.Attr = attributes
+
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if !sanitiseSVGNode(c) {
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+	return true
+}
+
+// isSafeSVGAttribute returns false for event handlers, links outside the document, and values that reference external resources or may run script
+func isSafeSVGAttribute(a html.Attribute) bool {
+	key := strings.ToLower(a.Key)
+	if strings.HasPrefix(key, "on") {
+		return false
+	}
+	if key == "href" {
+		return strings.HasPrefix(strings.TrimSpace(a.Val), "#")
+	}
+	return !externalReference.MatchString(a.Val) && !scriptValue.MatchString(a.Val)
+}
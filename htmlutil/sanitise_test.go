@@ -0,0 +1,43 @@
+package htmlutil_test
+
+import (
+	"testing"
+
+	. "github.com/ONSdigital/dp-map-renderer/htmlutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSanitiseSVG(t *testing.T) {
+	Convey("SanitiseSVG should keep a safe pattern unchanged", t, func() {
+		pattern := `<pattern id="hatch" width="8" height="8" patternUnits="userSpaceOnUse" patternTransform="rotate(45)"><line x1="0" y1="0" x2="0" y2="8" style="stroke: black; stroke-width: 2;"></line></pattern>`
+		result, err := SanitiseSVG(pattern)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, pattern)
+	})
+
+	Convey("SanitiseSVG should keep references to elements of the document", t, func() {
+		result, err := SanitiseSVG(`<pattern id="p"><use href="#dot"></use><rect fill="url(#gradient)"></rect></pattern>`)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, `<pattern id="p"><use href="#dot"></use><rect fill="url(#gradient)"></rect></pattern>`)
+	})
+
+	Convey("SanitiseSVG should remove scripts and foreignObject", t, func() {
+		result, err := SanitiseSVG(`<pattern id="p"><script>alert(1)</script><foreignObject><body><img src="x"/></body></foreignObject><circle r="1"></circle></pattern><script>alert(2)</script>`)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, `<pattern id="p"><circle r="1"></circle></pattern>`)
+	})
+
+	Convey("SanitiseSVG should remove event handlers and external references", t, func() {
+		result, err := SanitiseSVG(`<pattern id="p" onload="alert(1)"><use xlink:href="http://example.com/x.svg#a"></use><use href="javascript:alert(1)"></use>` +
+			`<rect style="fill: url('http://example.com/x.png')" fill="url( http://example.com )" width="1"></rect><image href="x.png"></image></pattern>`)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, `<pattern id="p"><use></use><use></use><rect width="1"></rect></pattern>`)
+	})
+
+	Convey("SanitiseSVG should remove elements that aren't svg", t, func() {
+		result, err := SanitiseSVG(`</svg><img src="x" onerror="alert(1)"><pattern id="p"></pattern>`)
+		So(err, ShouldBeNil)
+		So(result, ShouldNotContainSubstring, "img")
+		So(result, ShouldNotContainSubstring, "alert")
+	})
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"math"
+
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
+)
+
+// DissolveRegions merges the regions of the geography with the same value of its dissolve property (if it has one) into a single region identified by that value,
+// aggregating their data (and that of each series) with the dissolve's aggregate function. Rows of regions without the property are unchanged.
+// A merged region whose rows are all suppressed is suppressed, with the marker of its first row. Should be called after the request has been validated.
+func (r *RenderRequest) DissolveRegions() {
+	if r.Geography == nil || r.Geography.Topojson == nil || r.Geography.Dissolve == nil {
+		return
+	}
+	d := r.Geography.Dissolve
+	groups := regionGroups(r.Geography, d.Property)
+	r.Geography.Topojson = topoutil.Dissolve(r.Geography.Topojson, d.Property, r.Geography.IDProperty, r.Geography.NameProperty)
+	for _, s := range r.Series {
+		s.Data, s.Suppressed = aggregateRows(s.Data, s.Suppressed, groups, d.Aggregate)
+	}
+	if r.Version == RequestVersion2 && len(r.Series) > 0 {
+		r.Data, r.Suppressed = r.Series[0].Data, r.Series[0].Suppressed
+		return
+	}
+	r.Data, r.Suppressed = aggregateRows(r.Data, r.Suppressed, groups, d.Aggregate)
+}
+
+// regionGroups returns the value of the dissolve property of each region of the geography's objects, keyed by the region's id
+func regionGroups(geography *Geography, property string) map[string]string {
+	groups := make(map[string]string)
+	for _, o := range geography.SelectedTopology().Objects {
+		if o == nil {
+			continue
+		}
+		for _, g := range o.Geometries {
+			value, ok := topoutil.DissolvedValue(g, property)
+			if !ok {
+				continue
+			}
+			if id := geography.FeatureID(g.Properties, g.ID); len(id) > 0 {
+				groups[id] = value
+			}
+		}
+	}
+	return groups
+}
+
+// aggregateRows returns the rows with those of each group replaced by a single row with the group's id and the sum (or mean) of their values
+func aggregateRows(data []*DataRow, suppressed []*DataRow, groups map[string]string, aggregate string) ([]*DataRow, []*DataRow) {
+	var aggregated, suppressedRows []*DataRow
+	rows := make(map[string]*DataRow)
+	counts := make(map[string]int)
+	for _, row := range data {
+		group, ok := groups[row.ID]
+		if !ok {
+			aggregated = append(aggregated, row)
+			continue
+		}
+		if existing, exists := rows[group]; exists {
+			existing.Value += row.Value
+		} else {
+			rows[group] = &DataRow{ID: group, Value: row.Value}
+			aggregated = append(aggregated, rows[group])
+		}
+		counts[group]++
+	}
+	if aggregate == AggregateMean {
+		for group, row := range rows {
+			row.Value = row.Value / float64(counts[group])
+		}
+	}
+
+	for _, row := range suppressed {
+		group, ok := groups[row.ID]
+		if !ok {
+			suppressedRows = append(suppressedRows, row)
+			continue
+		}
+		if _, hasData := rows[group]; hasData {
+			continue
+		}
+		rows[group] = &DataRow{ID: group, Value: math.NaN(), Marker: row.Marker}
+		suppressedRows = append(suppressedRows, rows[group])
+	}
+	return aggregated, suppressedRows
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rubenv/topojson"
+)
+
+// NormaliseID returns the id in lower case, with whitespace removed from its start and end and runs of whitespace within it replaced by a single space,
+// so that e.g. "E06000001 " and "e06000001" match
+func NormaliseID(id string) string {
+	return strings.ToLower(strings.Join(strings.Fields(id), " "))
+}
+
+// RegionIDs returns the ids of the regions of the geography's topology (or of its selected object)
+func (g *Geography) RegionIDs() []string {
+	var ids []string
+	topology := g.SelectedTopology()
+	if topology == nil {
+		return ids
+	}
+	for _, o := range topology.Objects {
+		if o == nil {
+			continue
+		}
+		geometries := o.Geometries
+		if o.Type != "GeometryCollection" {
+			geometries = []*topojson.Geometry{o}
+		}
+		for _, geometry := range geometries {
+			if id := g.FeatureID(geometry.Properties, geometry.ID); len(id) > 0 {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// MatchRowIDs replaces the id of each row with the region id it's aliased to in the geography's IDAliases, if any. Then, if the geography has NormaliseIDs,
+// replaces the id of each row that matches the id of a region once both are normalised (see NormaliseID) with the region's id - so that the rows are joined to the regions
+// when rendered. With NormaliseIDs, the ids in IDAliases are also normalised.
+func (g *Geography) MatchRowIDs(rows ...[]*DataRow) {
+	if !g.NormaliseIDs && len(g.IDAliases) == 0 {
+		return
+	}
+	normalise := func(id string) string { return id }
+	if g.NormaliseIDs {
+		normalise = NormaliseID
+	}
+	aliases := make(map[string]string, len(g.IDAliases))
+	for id, alias := range g.IDAliases {
+		aliases[normalise(id)] = alias
+	}
+	regions := make(map[string]string)
+	if g.NormaliseIDs {
+		for _, id := range g.RegionIDs() {
+			regions[NormaliseID(id)] = id
+		}
+	}
+	for _, r := range rows {
+		for _, row := range r {
+			if alias, exists := aliases[normalise(row.ID)]; exists {
+				row.ID = alias
+			}
+			if id, exists := regions[NormaliseID(row.ID)]; exists {
+				row.ID = id
+			}
+		}
+	}
+}
+
+// MatchDataIDs matches the ids of the request's data rows (and those of each series) to the ids of the regions of its geography, using its aliases and
+// normalising ids as described by MatchRowIDs.
+// Should be called after the request has been validated, before DissolveRegions.
+func (r *RenderRequest) MatchDataIDs() {
+	if r.Geography == nil || r.Geography.Topojson == nil {
+		return
+	}
+	var rows [][]*DataRow
+	if r.Version != RequestVersion2 { // the data of a version 2 request is that of its first series
+		rows = append(rows, r.Data, r.Suppressed)
+	}
+	for _, s := range r.Series {
+		rows = append(rows, s.Data, s.Suppressed)
+	}
+	r.Geography.MatchRowIDs(rows...)
+}
+
+// joinDiagnosticsSampleSize is the maximum number of ids included in each sample in the JoinDiagnostics
+const joinDiagnosticsSampleSize = 10
+
+// NewJoinDiagnostics reports the number of rows that matched a feature in the topology, the rows that did not, and the features (of the given ids) that have no data.
+// Only the first joinDiagnosticsSampleSize ids (in sorted order) of unmatched rows and features are included.
+func NewJoinDiagnostics(featureIDs map[string]string, rowIDs map[string]bool, unmatchedRows []string, matchedCount int) *JoinDiagnostics {
+	featuresWithoutData := []string{}
+	for id := range featureIDs {
+		if !rowIDs[id] {
+			featuresWithoutData = append(featuresWithoutData, id)
+		}
+	}
+	return &JoinDiagnostics{
+		MatchedRowCount:           matchedCount,
+		UnmatchedRowCount:         len(unmatchedRows),
+		UnmatchedRowSample:        sample(unmatchedRows, joinDiagnosticsSampleSize),
+		FeaturesWithoutDataCount:  len(featuresWithoutData),
+		FeaturesWithoutDataSample: sample(featuresWithoutData, joinDiagnosticsSampleSize),
+	}
+}
+
+// JoinDiagnostics reports how the request's data rows (including suppressed rows) were joined to the regions of its geography
+func (r *RenderRequest) JoinDiagnostics() *JoinDiagnostics {
+	featureIDs := make(map[string]string)
+	if r.Geography != nil {
+		for _, id := range r.Geography.RegionIDs() {
+			featureIDs[id] = id
+		}
+	}
+	rowIDs := make(map[string]bool)
+	unmatchedRows := []string{}
+	for _, rows := range [][]*DataRow{r.Data, r.Suppressed} {
+		for _, row := range rows {
+			rowIDs[row.ID] = true
+			if _, exists := featureIDs[row.ID]; !exists {
+				unmatchedRows = append(unmatchedRows, row.ID)
+			}
+		}
+	}
+	return NewJoinDiagnostics(featureIDs, rowIDs, unmatchedRows, len(r.Data)+len(r.Suppressed)-len(unmatchedRows))
+}
+
+// Warnings describes any problems joining the data to the regions - rows that don't match a region, with a sample of their ids
+func (d *JoinDiagnostics) Warnings() []string {
+	if d.UnmatchedRowCount == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("IDs of %d rows could not be found in the topology. Row IDs: [%s]", d.UnmatchedRowCount, strings.Join(d.UnmatchedRowSample, ", "))}
+}
+
+// sample returns (a copy of) the first n of the given values, in sorted order
+func sample(values []string, n int) []string {
+	sorted := make([]string, len(values))
+	copy(sorted, values)
+	sort.Strings(sorted)
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
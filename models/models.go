@@ -1,14 +1,20 @@
 package models
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/ONSdigital/go-ns/log"
 	"github.com/json-iterator/go"
+	"github.com/paulmach/go.geojson"
 	"github.com/rubenv/topojson"
 )
 
@@ -19,42 +25,426 @@ var (
 )
 
 // possible values for the 2 LegendPositions. 'None' is the default.
+// The 'inside' positions draw the key within the map svg itself, rather than as a separate svg before or after the map.
 var (
-	LegendPositionBefore = "before"
-	LegendPositionAfter  = "after"
+	LegendPositionBefore            = "before"
+	LegendPositionAfter             = "after"
+	LegendPositionInsideTopLeft     = "inside-top-left"
+	LegendPositionInsideTopRight    = "inside-top-right"
+	LegendPositionInsideBottomLeft  = "inside-bottom-left"
+	LegendPositionInsideBottomRight = "inside-bottom-right"
+)
+
+// possible values for ComparisonMode. 'side-by-side' is the default.
+// 'toggle' shows one map at a time with a control to switch between them, 'slider' overlays the maps with a slider revealing the second map.
+var (
+	ComparisonModeSideBySide = "side-by-side"
+	ComparisonModeToggle     = "toggle"
+	ComparisonModeSlider     = "slider"
+)
+
+// possible values for DifferenceMode. 'absolute' is the default.
+// 'percentage' maps the change in each value as a percentage of its value in the first series.
+var (
+	DifferenceModeAbsolute   = "absolute"
+	DifferenceModePercentage = "percentage"
+)
+
+// possible values for OfficePreset - the page size of the image returned by the office render type. '16:9' (a widescreen slide) is the default.
+var (
+	OfficePresetWidescreen  = "16:9"
+	OfficePresetA4Landscape = "a4-landscape"
+	OfficePresetA4Portrait  = "a4-portrait"
+)
+
+// possible values for the BoundInclusion of a Choropleth - which bound of a break includes a value exactly on the boundary between two breaks.
+// 'lower' (the default) puts the value in the break whose lower bound it equals (lower <= value < upper);
+// 'upper' puts it in the break below, whose upper bound it equals (lower < value <= upper).
+var (
+	BoundInclusionLower = "lower"
+	BoundInclusionUpper = "upper"
+)
+
+// possible values for the ReferenceLabelPlacement of a Choropleth - which side of the reference tick its text is drawn, with the reference value on the other side.
+// 'before' is left of the tick in the horizontal key and above it in the vertical key; 'after' is right of and below the tick.
+// By default the longer of the text and value is drawn on the side of the horizontal key with the most space, and the text is above the tick in the vertical key.
+var (
+	ReferenceLabelBefore = "before"
+	ReferenceLabelAfter  = "after"
+)
+
+// possible values for the SwatchShape of a Choropleth - how the colour of each break is drawn in the legends.
+// 'bar' (the default) is a continuous bar divided at the breaks; 'square' and 'circle' are a swatch in the middle of each break.
+var (
+	SwatchShapeBar    = "bar"
+	SwatchShapeSquare = "square"
+	SwatchShapeCircle = "circle"
+)
+
+// possible values for the KeyScale of a Choropleth - how values map to distances along the legends.
+// 'linear' (the default) makes the size of each break proportional to its range; 'log' makes it proportional to the range of the logarithm of its values,
+// for heavily skewed data. The log scale is only used if every value in the legend is positive.
+var (
+	KeyScaleLinear = "linear"
+	KeyScaleLog    = "log"
+)
+
+// possible values for the Classification of an AnalyseRequest - how the suggested breaks are calculated.
+// 'natural' (the default) uses Jenks natural breaks; 'log' spaces the breaks evenly on a logarithmic scale, and requires every value to be positive;
+// 'stddev' places the breaks at 0.5, 1 and 2 standard deviations either side of the mean, and suggests diverging palettes centred on the mean;
+// 'headtail' repeatedly divides the values at the mean of the head (the values at or above the previous mean), for heavy-tailed data such as population counts.
+var (
+	ClassificationNatural  = "natural"
+	ClassificationLog      = "log"
+	ClassificationStdDev   = "stddev"
+	ClassificationHeadTail = "headtail"
+)
+
+// possible values for Fit - which regions the map is framed to. 'all' (the whole geography) is the default.
+// 'data' frames the regions with a data row (including suppressed rows), and 'highlight' the highlighted regions.
+// Regions outside the frame are clipped by the edge of the map.
+var (
+	FitAll       = "all"
+	FitData      = "data"
+	FitHighlight = "highlight"
+)
+
+// possible values for ConversionFailure - what happens when the svg can't be converted to a fallback image (or to the images of the png render type).
+// 'fallback' (the default) renders the svg alone, without a fallback image (or, for the png render type, in place of the image), and 'fail' fails the request.
+var (
+	ConversionFailureFallback = "fallback"
+	ConversionFailureFail     = "fail"
+)
+
+// possible values for FallbackStructure - how the fallback image is embedded with the svg.
+// 'switch' (the default) puts the image in a foreignObject of the svg, shown by browsers that don't support svg,
+// and 'picture' wraps the svg in a picture element with the fallback images as its sources, so that browsers use the first format they support.
+var (
+	FallbackStructureSwitch  = "switch"
+	FallbackStructurePicture = "picture"
+)
+
+// possible values for PNGOutput - what the png render type returns.
+// 'html' (the default) is an html figure with png images of the map and legends, 'map' is a png image of the map alone (with any legend positioned inside it),
+// and 'map-and-legend' is a png image of the map with its legend drawn alongside it.
+var (
+	PNGOutputHTML         = "html"
+	PNGOutputMap          = "map"
+	PNGOutputMapAndLegend = "map-and-legend"
+)
+
+// BackgroundTransparent is the default Background - raster images are transparent where the map and legend aren't drawn
+const BackgroundTransparent = "transparent"
+
+// possible values for the Aggregate of a Dissolve - how the values of the merged regions are combined
+var (
+	AggregateSum  = "sum"
+	AggregateMean = "mean"
+)
+
+// possible values for the Pattern of a ChoroplethBreak, drawn over the break's colour (or white if it has no colour) so that breaks can be distinguished when printed in greyscale.
+// A pattern may instead be custom svg content (starting with '<') of an 8x8 pattern tile, from which scripts and external references are removed.
+var (
+	PatternHatching   = "hatching"
+	PatternDots       = "dots"
+	PatternCrossHatch = "cross-hatch"
+)
+
+// The supported versions of RenderRequest. A request without a version is treated as version 1.
+// Version 2 replaces data with series - a list of named data series - to allow for multiple series. Most render types only render the first series,
+// but the pdf, small-multiples, comparison and animated render types render a map for each series, and the difference render type maps the difference between the first two.
+const (
+	RequestVersion1 = 1
+	RequestVersion2 = 2
 )
 
 // RenderRequest represents a structure for a map render job
 type RenderRequest struct {
-	Title              string      `json:"title,omitempty"`
-	Subtitle           string      `json:"subtitle,omitempty"`
-	Source             string      `json:"source,omitempty"`
-	SourceLink         string      `json:"source_link,omitempty"`
-	Licence            string      `json:"licence,omitempty"`
-	Filename           string      `json:"filename,omitempty"`
-	Footnotes          []string    `json:"footnotes,omitempty"`
-	MapType            string      `json:"map_type,omitempty"`
-	Geography          *Geography  `json:"geography,omitempty"`
-	Data               []*DataRow  `json:"data,omitempty"` // ID's in Data should match values of IDProperty in Geography
-	Choropleth         *Choropleth `json:"choropleth,omitempty"`
-	DefaultWidth       float64     `json:"width,omitempty"`     // used when determining the viewBox dimensions and the switch point between displaying the horizontal and vertical legends in responsive design. Optional if min and max width specified
-	MinWidth           float64     `json:"min_width,omitempty"` // the minimum width in a responsive design. optional.
-	MaxWidth           float64     `json:"max_width,omitempty"` // the maximum width in a responsive design. Required if min width specified.
-	IncludeFallbackPng bool        `json:"include_fallback_png"`
-	FontSize           int         `json:"font_size"`
+	Version              int             `json:"version,omitempty"` // 1 (the default) or 2
+	Title                string          `json:"title,omitempty"`
+	Subtitle             string          `json:"subtitle,omitempty"`
+	Source               string          `json:"source,omitempty"`
+	SourceLink           string          `json:"source_link,omitempty"`
+	SourceLinkAttributes *LinkAttributes `json:"source_link_attributes,omitempty"` // the target, rel and data attributes of the source links in the html footer
+	Licence              string          `json:"licence,omitempty"`
+	Sources              []*Source       `json:"sources,omitempty"`  // additional sources, each with its own link, following Source
+	Licences             []string        `json:"licences,omitempty"` // additional licence lines, following Licence
+	Filename             string          `json:"filename,omitempty"`
+	Footnotes            []string        `json:"footnotes,omitempty"`
+	MapType              string          `json:"map_type,omitempty"`
+	Geography            *Geography      `json:"geography,omitempty"`
+	Data                 []*DataRow      `json:"data,omitempty"`   // ID's in Data should match values of IDProperty in Geography. Version 1 only - in version 2 this is populated from the first Series
+	Series               []*DataSeries   `json:"series,omitempty"` // Version 2 only
+	Suppressed           []*DataRow      `json:"-"`                // the rows of Data with one of the choropleth's suppressed values, which are moved out of Data when the request is created
+	Choropleth           *Choropleth     `json:"choropleth,omitempty"`
+	DefaultWidth         float64         `json:"width,omitempty"`     // used when determining the viewBox dimensions and the switch point between displaying the horizontal and vertical legends in responsive design. Optional if min and max width specified
+	MinWidth             float64         `json:"min_width,omitempty"` // the minimum width in a responsive design. optional.
+	MaxWidth             float64         `json:"max_width,omitempty"` // the maximum width in a responsive design. Required if min width specified.
+	IncludeFallbackPng   bool            `json:"include_fallback_png"`
+	FallbackImageFormat  string          `json:"fallback_image_format,omitempty"` // png (the default), webp, avif or jpeg. Used for the fallback image and by the png render type
+	FontSize             int             `json:"font_size"`
+	ComparisonMode       string          `json:"comparison_mode,omitempty"`       // side-by-side (the default), toggle or slider. Used by the comparison render type
+	DifferenceMode       string          `json:"difference_mode,omitempty"`       // absolute (the default) or percentage. Used by the difference render type
+	IncludeStyles        bool            `json:"include_styles"`                  // if true, html output includes styles scoped to the map for region hover/focus highlighting and legend layout, for pages without the site css
+	Resolution           float64         `json:"resolution,omitempty"`            // the size of each pixel, in degrees, of the geotiff render type. Defaults to the extent of the geography divided by the width
+	Tile                 string          `json:"tile,omitempty"`                  // z/x/y of the vector tile rendered by the mvt render type, or of the root of the mvt-pyramid. Defaults to the smallest tile containing the geography
+	OfficePreset         string          `json:"office_preset,omitempty"`         // 16:9 (the default), a4-landscape or a4-portrait. The page size of the image rendered by the office render type
+	Minify               bool            `json:"minify"`                          // if true, svg output is minified - whitespace and default attributes removed, coordinates rounded and ids shortened
+	Deterministic        bool            `json:"deterministic"`                   // if true, identical requests produce byte-identical output - regions are rendered in a stable order regardless of how the topology is decoded
+	IDPrefix             string          `json:"id_prefix,omitempty"`             // the prefix of all element ids. Defaults to map-{filename}. Characters other than letters, digits, hyphens and underscores are replaced
+	Greyscale            bool            `json:"greyscale"`                       // if true, the colours of the breaks are replaced by greys, ordered by the lightness of the colours, for printing
+	CaptionHeadingLevel  int             `json:"caption_heading_level,omitempty"` // 1 to 6 - the title in the html figcaption is a heading of this level. Optional - by default the title is plain text
+	CaptionClass         string          `json:"caption_class,omitempty"`         // classes added to the html figcaption, separated by spaces
+	EmbedTitle           bool            `json:"embed_title"`                     // if true, the svg and png render types draw the title and subtitle above the map, for use without the html figure
+	RegionClass          string          `json:"region_class,omitempty"`          // the class of every map region, e.g. "map__region" to match the page's BEM conventions. Defaults to mapRegion
+	Highlight            *Highlight      `json:"highlight,omitempty"`             // regions drawn with a distinct outline above the other regions, e.g. the subject of an article
+	Fit                  string          `json:"fit,omitempty"`                   // all (the default), data or highlight - the map is framed to the whole geography, the regions with data, or the highlighted regions
+	ConversionFailure    string          `json:"conversion_failure,omitempty"`    // fallback (the default) or fail - whether a failure to convert the svg to an image renders the svg alone or fails the request
+	HighDPIFallback      bool            `json:"high_dpi_fallback"`               // if true, the fallback image has a srcset with an image of twice the size, for high-DPI screens
+	FallbackStructure    string          `json:"fallback_structure,omitempty"`    // switch (the default) or picture - whether the fallback image is in a foreignObject of the svg or the svg and fallback images are the sources of a picture element
+	PNGOutput            string          `json:"png_output,omitempty"`            // html (the default), map or map-and-legend - whether the png render type returns an html figure or the png image itself
+	Dataset              *Dataset        `json:"dataset,omitempty"`               // observations in the ONS dataset API, fetched by the service as the Data of the request (which must not have Data of its own)
+	Background           string          `json:"background,omitempty"`            // transparent (the default) or a colour filling the background of raster images - the fallback image, png images and the images of the png and office render types
+}
+
+// DefaultDatasetDimension is the default Dimension of a Dataset
+const DefaultDatasetDimension = "geography"
+
+// Dataset identifies observations in the ONS dataset API - one for each option of the dataset's geography dimension, with the given option of each
+// of its other dimensions (e.g. {"time": "2019", "aggregate": "cpih1dim1A0"}). The observations are joined to the regions of the map on the geography codes.
+type Dataset struct {
+	ID        string            `json:"id"`
+	Edition   string            `json:"edition"`
+	Version   string            `json:"version"`
+	Dimension string            `json:"dimension,omitempty"` // the geography dimension, whose option codes are joined to the regions. Defaults to geography
+	Options   map[string]string `json:"options,omitempty"`   // the option of each of the other dimensions of the dataset
+}
+
+// GeographyDimension returns the Dimension of the dataset, or the default (geography) if it has none
+func (d *Dataset) GeographyDimension() string {
+	if len(d.Dimension) == 0 {
+		return DefaultDatasetDimension
+	}
+	return d.Dimension
+}
+
+// SetDatasetData sets the Data of the request to the rows fetched from its Dataset, moving rows with a suppressed value to Suppressed (as when the request is created).
+// Returns an error if a row has a marker that isn't one of the choropleth's suppressed values.
+func (r *RenderRequest) SetDatasetData(rows []*DataRow) (err error) {
+	var suppressedValues []string
+	if r.Choropleth != nil {
+		suppressedValues = r.Choropleth.SuppressedValues
+	}
+	r.Data, r.Suppressed, err = splitRows(rows, suppressedValues)
+	return err
+}
+
+// ValidateDataset checks the request's Dataset, if it has one, and that the request has no other data, returning ValidationErrors listing every invalid field -
+// so that the dataset can be checked before its observations are fetched
+func (r *RenderRequest) ValidateDataset() error {
+	var errs ValidationErrors
+	validateDataset(r, &errs)
+	if r.Dataset != nil && len(r.Data)+len(r.Suppressed) > 0 {
+		errs.invalid("data", "Data must not be given with a dataset")
+	}
+	return errs.asError()
+}
+
+// Highlight picks out regions of the map (e.g. Manchester, in a map for an article about Manchester) - they're drawn above the other regions
+// with a distinct outline, and optionally labelled with their names
+type Highlight struct {
+	IDs    []string `json:"ids"`              // the ids of the highlighted regions, as in the data
+	Colour string   `json:"colour,omitempty"` // the colour of the outline. Defaults to black
+	Label  bool     `json:"label"`            // if true, each highlighted region is labelled with its name
+}
+
+// LinkAttributes are additional attributes of a link, e.g. to open an external link in a new tab, or to identify it to analytics
+type LinkAttributes struct {
+	Target string            `json:"target,omitempty"` // e.g. _blank
+	Rel    string            `json:"rel,omitempty"`    // defaults to "noopener noreferrer" if the target is _blank
+	Data   map[string]string `json:"data,omitempty"`   // data attributes, with names given without the data- prefix, e.g. {"gtm-label": "source"} for data-gtm-label="source"
+}
+
+// Source is one of the sources of the data in the map, with an optional link
+type Source struct {
+	Text string `json:"text"`
+	Link string `json:"link,omitempty"`
+}
+
+// AllSources returns the sources of the request - Source (with SourceLink), if given, followed by Sources
+func (r *RenderRequest) AllSources() []*Source {
+	var sources []*Source
+	if len(r.Source) > 0 {
+		sources = append(sources, &Source{Text: r.Source, Link: r.SourceLink})
+	}
+	return append(sources, r.Sources...)
+}
+
+// AllLicences returns the licence lines of the request - Licence, if given, followed by Licences
+func (r *RenderRequest) AllLicences() []string {
+	var licences []string
+	if len(r.Licence) > 0 {
+		licences = append(licences, r.Licence)
+	}
+	return append(licences, r.Licences...)
 }
 
 // Geography holds the topojson topology and supporting information
 type Geography struct {
-	Topojson     *topojson.Topology `json:"topojson,omitempty"`
-	IDProperty   string             `json:"id_property,omitempty"`
-	NameProperty string             `json:"name_property,omitempty"`
+	Topojson      *topojson.Topology `json:"topojson,omitempty"`
+	TopojsonURL   string             `json:"topojson_url,omitempty"` // the url of the topology, fetched by the service (from an allowed host) if Topojson isn't given
+	IDProperty    PropertyNames      `json:"id_property,omitempty"`  // the property identifying each region, or a prioritised list of properties - the first a region has is used
+	NameProperty  string             `json:"name_property,omitempty"`
+	ClassProperty string             `json:"class_property,omitempty"` // a property (e.g. country) whose value is added to the class of each region, for styling groups of regions differently
+	Include       *FeatureFilter     `json:"include,omitempty"`        // if given, only the regions matching the filter are rendered
+	Exclude       *FeatureFilter     `json:"exclude,omitempty"`        // regions matching the filter are not rendered
+	Dissolve      *Dissolve          `json:"dissolve,omitempty"`       // if given, regions with the same value of a property are merged into one region
+	ObjectName    string             `json:"object_name,omitempty"`    // the name of the topology object to render (e.g. "lad" in a topology that also has "region" and "country"). Defaults to all objects
+	NormaliseIDs  bool               `json:"normalise_ids"`            // if true, data rows are joined to regions ignoring case and whitespace at the start and end of (or repeated within) their ids
+	IDAliases     map[string]string  `json:"id_aliases,omitempty"`     // data row ids mapped to the ids of the regions they're joined to, e.g. to reconcile old and new codes of merged local authorities
+}
+
+// PropertyNames is a prioritised list of the names of properties of the regions of a geography, e.g. ["AREACD", "lad19cd", "id"] for a topology
+// whose regions come from several boundary files. In json it may be a single name or an array of names.
+type PropertyNames []string
+
+// UnmarshalJSON decodes a single property name (an empty name giving an empty list) or an array of names
+func (p *PropertyNames) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := jsoniter.Unmarshal(b, &name); err == nil {
+		*p = nil
+		if len(name) > 0 {
+			*p = PropertyNames{name}
+		}
+		return nil
+	}
+	var names []string
+	if err := jsoniter.Unmarshal(b, &names); err != nil {
+		return err
+	}
+	*p = names
+	return nil
+}
+
+// MarshalJSON encodes a single property name as a string, so that requests with one id property are unchanged, and several names as an array
+func (p PropertyNames) MarshalJSON() ([]byte, error) {
+	if len(p) == 1 {
+		return jsoniter.Marshal(p[0])
+	}
+	return jsoniter.Marshal([]string(p))
+}
+
+// String returns the property names separated by commas
+func (p PropertyNames) String() string {
+	return strings.Join(p, ", ")
+}
+
+// FeatureID returns the id of a region with the given properties and (topology or geojson) id - the value of the first of the geography's id properties
+// that the region has, falling back to its id. Empty if the region has none of them.
+func (g *Geography) FeatureID(properties map[string]interface{}, id interface{}) string {
+	for _, name := range g.IDProperty {
+		if value, isString := properties[name].(string); isString && len(value) > 0 {
+			return value
+		}
+	}
+	value, _ := id.(string)
+	return value
+}
+
+// SelectedTopology returns the topology with only the object named by ObjectName, or the whole topology if no object is named
+func (g *Geography) SelectedTopology() *topojson.Topology {
+	if g.Topojson == nil || len(g.ObjectName) == 0 {
+		return g.Topojson
+	}
+	t := g.Topojson
+	return &topojson.Topology{Type: t.Type, Transform: t.Transform, BoundingBox: t.BoundingBox, Arcs: t.Arcs,
+		Objects: map[string]*topojson.Geometry{g.ObjectName: t.Objects[g.ObjectName]}}
+}
+
+// Dissolve merges the regions with the same value of a property (e.g. the local authorities of each region) into a single region,
+// so that one high-resolution topology can be used for several geography levels. The merged region's id and name are the property's value.
+type Dissolve struct {
+	Property  string `json:"property"`
+	Aggregate string `json:"aggregate,omitempty"` // sum (the default) or mean - how the values of the merged regions are combined
+}
+
+// FeatureFilter matches the regions of a geography with one of the given ids, or with one of the given values of a property,
+// so that a subset of a topology (e.g. the regions of one country) can be rendered without preprocessing it
+type FeatureFilter struct {
+	IDs      []string `json:"ids,omitempty"`      // values of the geography's IDProperty
+	Property string   `json:"property,omitempty"` // the name of the property compared with Values
+	Values   []string `json:"values,omitempty"`
 }
 
 // DataRow holds a single row of data.
 type DataRow struct {
-	ID    string  `json:"id,omitempty"`
-	Value float64 `json:"value,omitempty"`
+	ID       string  `json:"id,omitempty"`
+	Value    float64 `json:"value,omitempty"`    // NaN if the value is null in the request. Such rows are removed from render requests, so their regions are shown as missing data
+	Category string  `json:"category,omitempty"` // only populated by /analyse for categorical (non-numeric) data
+	Marker   string  `json:"marker,omitempty"`   // a non-numeric value, such as "c" for confidential, in which case Value is NaN. Must be one of the choropleth's suppressed values
+}
+
+// UnmarshalJSON decodes a data row, giving a null value the value NaN (rather than 0) so that it can be distinguished from a row with the value 0.
+// A string value is the row's Marker.
+func (r *DataRow) UnmarshalJSON(b []byte) error {
+	var row struct {
+		ID       string              `json:"id"`
+		Value    jsoniter.RawMessage `json:"value"`
+		Category string              `json:"category"`
+		Marker   string              `json:"marker"`
+	}
+	if err := jsoniter.Unmarshal(b, &row); err != nil {
+		return err
+	}
+	r.ID, r.Value, r.Category, r.Marker = row.ID, 0, row.Category, row.Marker
+	value := bytes.TrimSpace(row.Value)
+	if len(r.Marker) > 0 || string(value) == "null" {
+		r.Value = math.NaN()
+	} else if len(value) > 0 && value[0] == '"' {
+		r.Value = math.NaN()
+		return jsoniter.Unmarshal(value, &r.Marker)
+	} else if len(value) > 0 {
+		return jsoniter.Unmarshal(value, &r.Value)
+	}
+	return nil
+}
+
+// isSuppressed returns true if the row's marker, or its value, is one of the suppressed values
+func (r *DataRow) isSuppressed(suppressedValues []string) bool {
+	for _, s := range suppressedValues {
+		if len(r.Marker) > 0 {
+			if r.Marker == s {
+				return true
+			}
+		} else if v, err := strconv.ParseFloat(s, 64); err == nil && v == r.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRows separates rows with a suppressed value from the data, removing rows whose value is null (NaN).
+// Returns an error if a row has a marker that isn't one of the suppressed values.
+func splitRows(rows []*DataRow, suppressedValues []string) (data []*DataRow, suppressed []*DataRow, err error) {
+	for _, row := range rows {
+		switch {
+		case row.isSuppressed(suppressedValues):
+			suppressed = append(suppressed, row)
+		case len(row.Marker) > 0:
+			return nil, nil, fmt.Errorf("The value '%s' of data row '%s' is not a number or one of the choropleth's suppressed_values", row.Marker, row.ID)
+		case !math.IsNaN(row.Value):
+			data = append(data, row)
+		}
+	}
+	return data, suppressed, nil
+}
+
+// DataSeries is a named series of data
+type DataSeries struct {
+	ID         string     `json:"id,omitempty"`
+	Title      string     `json:"title,omitempty"`
+	Data       []*DataRow `json:"data,omitempty"` // ID's in Data should match values of IDProperty in Geography
+	Suppressed []*DataRow `json:"-"`              // the rows of Data with one of the choropleth's suppressed values, which are moved out of Data when the request is created
 }
 
 // Choropleth contains details required to create a choropleth map
@@ -65,33 +455,193 @@ type Choropleth struct {
 	ValueSuffix              string             `json:"value_suffix,omitempty"`
 	Breaks                   []*ChoroplethBreak `json:"breaks,omitempty"`
 	UpperBound               float64            `json:"upper_bound,omitempty"`                 // used only in displaying the upperbound in the legend
-	HorizontalLegendPosition string             `json:"horizontal_legend_position, omitempty"` // before, after or none (the default)
-	VerticalLegendPosition   string             `json:"vertical_legend_position, omitempty"`   // before, after or none (the default)
+	HorizontalLegendPosition string             `json:"horizontal_legend_position, omitempty"` // before, after, inside-top-left, inside-top-right, inside-bottom-left, inside-bottom-right or none (the default)
+	VerticalLegendPosition   string             `json:"vertical_legend_position, omitempty"`   // before, after, inside-top-left, inside-top-right, inside-bottom-left, inside-bottom-right or none (the default)
+	MissingDataText          string             `json:"missing_data_text,omitempty"`           // the label of regions without data, in the legends and region titles. Defaults to 'data unavailable'
+	HideMissingDataKey       bool               `json:"hide_missing_data_key,omitempty"`       // if true, the legends don't include an entry for regions without data
+	BoundInclusion           string             `json:"bound_inclusion,omitempty"`             // lower (the default) or upper - the bound of a break that includes values on the boundary
+	SuppressedValues         []string           `json:"suppressed_values,omitempty"`           // sentinel values (e.g. -999, or "c" for confidential) of regions whose data is suppressed. Such regions have their own style and legend entry
+	SuppressedText           string             `json:"suppressed_text,omitempty"`             // the label of regions with suppressed data, in the legends and region titles. Defaults to 'data suppressed'
+	ReferenceColour          string             `json:"reference_colour,omitempty"`            // the colour of the reference tick and its labels. Defaults to DimGrey
+	ReferenceDash            string             `json:"reference_dash,omitempty"`              // the stroke-dasharray of the reference tick, e.g. "4 2". Solid by default
+	ReferenceLabelPlacement  string             `json:"reference_label_placement,omitempty"`   // before or after - the side of the reference tick on which its text is drawn. Placed automatically by default
+	References               []*Reference       `json:"references,omitempty"`                  // further reference values (e.g. 'England average' as well as 'UK average'), marked on the legends in the same way as ReferenceValue
+	ReverseLegend            bool               `json:"reverse_legend,omitempty"`              // if true, the legends are drawn with the highest class at the bottom of the vertical key and on the left of the horizontal key
+	SwatchShape              string             `json:"swatch_shape,omitempty"`                // bar (the default), square or circle - the shape of the colour of each break in the legends
+	SwatchSize               float64            `json:"swatch_size,omitempty"`                 // the thickness of the bar, or the size of the squares or circles, in the legends. Defaults to 8
+	KeyScale                 string             `json:"key_scale,omitempty"`                   // linear (the default) or log - the scale of the legends
+	DecimalPlaces            *int               `json:"decimal_places,omitempty"`              // the number of decimal places of the values in region titles. Optional - values are shown in full by default
+	TooltipTemplate          string             `json:"tooltip_template,omitempty"`            // the title of regions with data, e.g. "{name}: {value} {suffix} ({class_label})". Defaults to the name followed by the value with its prefix and suffix
+	Units                    string             `json:"units,omitempty"`                       // the units of the values, e.g. "percentage points" - the title of the legends and part of the map's alt text. ValuePrefix and ValueSuffix are only used to format values
 }
 
 // ChoroplethBreak represents a single break - the point at which a colour changes
 type ChoroplethBreak struct {
 	LowerBound float64 `json:"lower_bound"` // the lower bound for this colour
 	Colour     string  `json:"color,omitempty"`
+	Pattern    string  `json:"pattern,omitempty"` // hatching, dots, cross-hatch or custom svg content, drawn over the colour
+	Label      string  `json:"label,omitempty"`   // the label of the break (e.g. "under 10" or "50+") - in the legends, replacing the computed minimum for the first break or maximum for the last, or alongside each break if every break has a label
+}
+
+// Reference is a value marked on the legends with a labelled tick, such as a national average
+type Reference struct {
+	Value float64 `json:"value"`
+	Text  string  `json:"text,omitempty"`
+}
+
+// RenderResponse is the response to a json render request, with each part of the map rendered separately so that clients can compose them
+type RenderResponse struct {
+	Map           string          `json:"map"`                      // the svg map
+	HorizontalKey string          `json:"horizontal_key,omitempty"` // the svg horizontal legend, if positioned before or after the map
+	VerticalKey   string          `json:"vertical_key,omitempty"`   // the svg vertical legend, if positioned before or after the map
+	CSS           string          `json:"css"`                      // a style element enabling the map to resize responsively and switch between legends
+	Caption       string          `json:"caption,omitempty"`        // the html figcaption containing the title and subtitle
+	Footer        string          `json:"footer"`                   // the html footer containing the licence, source and footnotes
+	Metadata      *RenderMetadata `json:"metadata"`
+}
+
+// RenderMetadata describes a rendered map
+type RenderMetadata struct {
+	ID               string           `json:"id"`     // the id of the map element, used as the prefix of all ids in the svg, css and html
+	Width            float64          `json:"width"`  // the width of the map's viewBox
+	Height           float64          `json:"height"` // the height of the map's viewBox
+	Responsive       bool             `json:"responsive"`
+	FeatureCount     int              `json:"feature_count"`
+	DataRowCount     int              `json:"data_row_count"`
+	VerticalKeyWidth float64          `json:"vertical_key_width,omitempty"` // the width of the vertical legend's viewBox
+	Join             *JoinDiagnostics `json:"join"`                         // how the data rows were joined to the regions of the map
+	Warnings         []string         `json:"warnings,omitempty"`           // problems joining the data to the map, e.g. rows whose ids don't match any region
+}
+
+// WebMapResponse is the response to a webmap render request - the classified regions as geojson, with the style and legend needed to show them
+// in an interactive web map such as Leaflet or MapLibre GL
+type WebMapResponse struct {
+	ID      string                     `json:"id"` // the id of the map, for use as the id of the MapLibre source and layer
+	Title   string                     `json:"title,omitempty"`
+	Bounds  []float64                  `json:"bounds"` // the extent of the regions: [min longitude, min latitude, max longitude, max latitude]
+	GeoJSON *geojson.FeatureCollection `json:"geojson"`
+	Style   *WebMapStyle               `json:"style"`
+	Legend  *WebMapLegend              `json:"legend,omitempty"` // omitted if the request has no choropleth
+}
+
+// WebMapStyle describes how the regions should be drawn - the fill of each region is its fill property
+type WebMapStyle struct {
+	Leaflet  *LeafletStyle  `json:"leaflet"`
+	MapLibre *MapLibreLayer `json:"maplibre"`
+}
+
+// LeafletStyle holds the path options returned by the style function of a Leaflet geoJSON layer. fillColor should be set from the feature's fill property.
+type LeafletStyle struct {
+	Color       string  `json:"color"`
+	Weight      float64 `json:"weight"`
+	FillOpacity float64 `json:"fillOpacity"`
+}
+
+// MapLibreLayer is a MapLibre GL style layer that draws the regions from a geojson source with the same id
+type MapLibreLayer struct {
+	ID     string                 `json:"id"`
+	Type   string                 `json:"type"`
+	Source string                 `json:"source"`
+	Paint  map[string]interface{} `json:"paint"`
+}
+
+// WebMapLegend holds the breaks of the choropleth, in ascending order, and the colour of regions without data
+type WebMapLegend struct {
+	Breaks        []*WebMapLegendBreak `json:"breaks"`
+	MissingColour string               `json:"missing_colour,omitempty"` // omitted if the request hides the missing data key
+	MissingText   string               `json:"missing_text,omitempty"`
+}
+
+// WebMapLegendBreak is a single class of the legend
+type WebMapLegendBreak struct {
+	Class      int     `json:"class"` // matches the class property of the regions in the class
+	LowerBound float64 `json:"lower_bound"`
+	UpperBound float64 `json:"upper_bound"`
+	Colour     string  `json:"colour"`
+	Label      string  `json:"label"` // the bounds with the value prefix and suffix, e.g. "10% to 20%"
+}
+
+// JoinedDataRow is a region of the geography joined to its data and choropleth class
+type JoinedDataRow struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Value  *float64 `json:"value,omitempty"`  // omitted if the region has no data
+	Class  *int     `json:"class,omitempty"`  // the index of the region's break, numbered from 0 in ascending order of lower bound. Omitted if the region has no data
+	Colour string   `json:"colour,omitempty"` // the colour of the region's break
 }
 
 // AnalyseRequest represents the structure of a request to analyse data and ensure it matches a topology
 type AnalyseRequest struct {
-	Geography    *Geography `json:"geography"`
-	CSV          string     `json:"csv"`
-	IDIndex      int        `json:"id_index"`
-	ValueIndex   int        `json:"value_index"`
-	HasHeaderRow bool       `json:"has_header_row"`
+	Geography      *Geography `json:"geography"`
+	CSV            string     `json:"csv"`
+	IDIndex        int        `json:"id_index"`
+	ValueIndex     int        `json:"value_index"`
+	HasHeaderRow   bool       `json:"has_header_row"`
+	ReferenceValue *float64   `json:"reference_value,omitempty"` // used to determine whether a sequential or diverging palette is suggested. Optional - defaults to zero
+	HistogramBins  int        `json:"histogram_bins,omitempty"`  // the number of bins in the histogram, at most 100. Optional - defaults to 10
+	Classification string     `json:"classification,omitempty"`  // natural (the default), log, stddev or headtail - how the suggested breaks are calculated
+	DecimalPlaces  *int       `json:"decimal_places,omitempty"`  // the number of decimal places the min, max, mean and standard deviation are rounded to. Optional - not rounded by default
+	Units          string     `json:"units,omitempty"`           // the units of the values, e.g. "percentage points", returned in the response for use in a render request
+}
+
+// MaxConvertDimension is the largest width or height of an image that an svg can be converted to
+const MaxConvertDimension = 10000
+
+// ConvertRequest represents a request to convert an svg document to a raster image
+type ConvertRequest struct {
+	SVG    []byte  // the svg document
+	Width  float64 // the width of the image. Optional - defaults to the width of the svg, or to a width in proportion to Height if only that is given
+	Height float64 // the height of the image. Optional - defaults to the height of the svg, or to a height in proportion to Width if only that is given
 }
 
 // AnalyseResponse represents the structure of an analyse data response
+// Breaks, BestFitClassCount, MinValue, MaxValue, Mean, StandardDeviation, SuggestedPalettes and Histogram are only populated for numeric data;
+// Categories is only populated for categorical data.
 type AnalyseResponse struct {
-	Data              []*DataRow  `json:"data"`
-	Messages          []*Message  `json:"messages"`
-	Breaks            [][]float64 `json:"breaks"`
-	BestFitClassCount int         `json:"best_fit_class_count"`
-	MinValue          float64     `json:"min_value"`
-	MaxValue          float64     `json:"max_value"`
+	DataType          string             `json:"data_type"` // numeric or categorical
+	Data              []*DataRow         `json:"data"`
+	Messages          []*Message         `json:"messages"`
+	Breaks            [][]float64        `json:"breaks"`
+	BestFitClassCount int                `json:"best_fit_class_count"`
+	MinValue          float64            `json:"min_value"`
+	MaxValue          float64            `json:"max_value"`
+	Mean              float64            `json:"mean"`
+	StandardDeviation float64            `json:"standard_deviation"` // the population standard deviation of the values
+	Units             string             `json:"units,omitempty"`    // the units of the values, as given in the request
+	JoinDiagnostics   *JoinDiagnostics   `json:"join_diagnostics"`
+	SuggestedPalettes []*Palette         `json:"suggested_palettes"` // palettes with one colour for each of BestFitClassCount classes
+	Histogram         []*HistogramBucket `json:"histogram"`
+	Categories        []*Category        `json:"categories,omitempty"`
+}
+
+// Category represents a distinct value in categorical data, with its frequency and a proposed colour for the legend
+type Category struct {
+	Value  string `json:"value"`
+	Count  int    `json:"count"`
+	Colour string `json:"color"`
+}
+
+// HistogramBucket represents a single bin of a histogram of the data values
+type HistogramBucket struct {
+	LowerBound float64 `json:"lower_bound"`
+	UpperBound float64 `json:"upper_bound"`
+	Count      int     `json:"count"`
+}
+
+// Palette is a named list of colours suitable for a choropleth map
+type Palette struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"` // sequential or diverging
+	Colours []string `json:"colours"`
+}
+
+// JoinDiagnostics describes how well the ids in the data match the ids of features in the topology
+type JoinDiagnostics struct {
+	MatchedRowCount           int      `json:"matched_row_count"`
+	UnmatchedRowCount         int      `json:"unmatched_row_count"`
+	UnmatchedRowSample        []string `json:"unmatched_row_sample"` // a sample of the ids of rows that do not match any feature
+	FeaturesWithoutDataCount  int      `json:"features_without_data_count"`
+	FeaturesWithoutDataSample []string `json:"features_without_data_sample"` // a sample of the ids of features that have no data
 }
 
 // Message represents a message with a level type
@@ -116,6 +666,11 @@ func CreateRenderRequest(reader io.Reader) (*RenderRequest, error) {
 		return nil, err
 	}
 
+	if err = request.upgrade(); err != nil {
+		log.Error(err, log.Data{"version": request.Version})
+		return nil, err
+	}
+
 	// This should be the last check before returning RenderRequest
 	if len(bytes) == 2 {
 		return &request, ErrorNoData
@@ -124,31 +679,109 @@ func CreateRenderRequest(reader io.Reader) (*RenderRequest, error) {
 	return &request, nil
 }
 
-// ValidateRenderRequest checks the content of the request structure
+// upgrade converts a request of any supported version to the structure used by the renderer, returning an error if the version is not supported.
+// Rows with a null (NaN) value are removed, so that their regions are treated as missing data, and rows with a suppressed value are moved to Suppressed.
+func (r *RenderRequest) upgrade() (err error) {
+	var suppressedValues []string
+	if r.Choropleth != nil {
+		suppressedValues = r.Choropleth.SuppressedValues
+	}
+	switch r.Version {
+	case 0, RequestVersion1:
+		r.Series = nil
+		r.Data, r.Suppressed, err = splitRows(r.Data, suppressedValues)
+	case RequestVersion2:
+		if len(r.Data) > 0 {
+			return errors.New("data is not supported in version 2 requests - use series instead")
+		}
+		for _, s := range r.Series {
+			if s.Data, s.Suppressed, err = splitRows(s.Data, suppressedValues); err != nil {
+				return err
+			}
+		}
+		if len(r.Series) > 0 {
+			r.Data, r.Suppressed = r.Series[0].Data, r.Series[0].Suppressed
+		}
+	default:
+		return fmt.Errorf("Unsupported request version: %d. Supported versions are %d and %d", r.Version, RequestVersion1, RequestVersion2)
+	}
+	return err
+}
+
+// ValidateRenderRequest checks the content of the request structure, returning ValidationErrors listing every invalid field
 func (r *RenderRequest) ValidateRenderRequest() error {
 
-	var missingFields []string
+	var errs ValidationErrors
 
 	if r.Geography == nil {
-		missingFields = append(missingFields, "geography")
+		errs.missing("geography")
 	} else {
 		if r.Geography.Topojson == nil {
-			missingFields = append(missingFields, "geography.topojson")
+			errs.missing("geography.topojson")
+		} else {
+			validateObjectName(r.Geography, &errs)
 		}
 		if len(r.Geography.IDProperty) == 0 {
-			missingFields = append(missingFields, "geography.id_property")
+			errs.missing("geography.id_property")
+		}
+		validateIDAliases(r.Geography.IDAliases, &errs)
+		if r.Geography.Include != nil {
+			validateFeatureFilter("geography.include", r.Geography.Include, &errs)
+		}
+		if r.Geography.Exclude != nil {
+			validateFeatureFilter("geography.exclude", r.Geography.Exclude, &errs)
+		}
+		if r.Geography.Dissolve != nil {
+			validateDissolve(r.Geography.Dissolve, &errs)
 		}
 	}
 
+	validateDataset(r, &errs)
 	if len(r.Data) == 0 {
-		missingFields = append(missingFields, "data")
+		if r.Version == RequestVersion2 {
+			errs.missing("series[0].data")
+		} else {
+			errs.missing("data")
+		}
 	}
 
-	if missingFields != nil {
-		return fmt.Errorf("Missing mandatory field(s): %v", missingFields)
+	if r.Choropleth != nil {
+		validateChoropleth(r.Choropleth, &errs)
+	}
+	validateLink("source_link", r.SourceLink, &errs)
+	for i, s := range r.Sources {
+		if len(s.Text) == 0 {
+			errs.missing(fmt.Sprintf("sources[%d].text", i))
+		}
+		validateLink(fmt.Sprintf("sources[%d].link", i), s.Link, &errs)
+	}
+	if r.SourceLinkAttributes != nil {
+		validateLinkAttributes("source_link_attributes", r.SourceLinkAttributes, &errs)
+	}
+	validateFallbackImageFormat(r.FallbackImageFormat, &errs)
+	validateComparisonMode(r.ComparisonMode, &errs)
+	validateDifferenceMode(r.DifferenceMode, &errs)
+	validateTile(r.Tile, &errs)
+	validateOfficePreset(r.OfficePreset, &errs)
+	if r.Resolution < 0 {
+		errs.invalid("resolution", "Resolution must not be negative")
+	}
+	if r.CaptionHeadingLevel < 0 || r.CaptionHeadingLevel > 6 {
+		errs.invalid("caption_heading_level", "Must be between 1 and 6 (or 0 for no heading)")
+	}
+	validateClassName("region_class", r.RegionClass, &errs)
+	if r.Highlight != nil {
+		validateHighlight(r.Highlight, &errs)
+	}
+	validateFit(r, &errs)
+	validateConversionFailure(r.ConversionFailure, &errs)
+	validateFallbackStructure(r.FallbackStructure, &errs)
+	validatePNGOutput(r.PNGOutput, &errs)
+	if !validColour.MatchString(r.Background) {
+		errs.invalid("background", "Invalid colour '%s'", r.Background)
 	}
 
-	return nil
+	return errs.asError()
 }
 
 // CreateAnalyseRequest manages the creation of an AnalyseRequest from a reader
@@ -174,34 +807,94 @@ func CreateAnalyseRequest(reader io.Reader) (*AnalyseRequest, error) {
 	return &request, nil
 }
 
-// ValidateAnalyseRequest checks the content of the request structure
+// CreateConvertRequest manages the creation of a ConvertRequest from a reader of the svg document and the width and height query parameters.
+// Returns ValidationErrors if either parameter isn't a number.
+func CreateConvertRequest(reader io.Reader, query url.Values) (*ConvertRequest, error) {
+	bytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		log.Error(err, nil)
+		return nil, ErrorReadingBody
+	}
+
+	request := &ConvertRequest{SVG: bytes}
+	var errs ValidationErrors
+	request.Width = parseDimension("width", query.Get("width"), &errs)
+	request.Height = parseDimension("height", query.Get("height"), &errs)
+	if err = errs.asError(); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// parseDimension parses the value of the named query parameter, which is zero if it's empty
+func parseDimension(name string, value string, errs *ValidationErrors) float64 {
+	if len(value) == 0 {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) {
+		errs.invalid(name, "%s must be a number: %s=%s", name, name, value)
+		return 0
+	}
+	return f
+}
+
+// ValidateConvertRequest checks that the request has an svg document and that its dimensions are positive and no more than MaxConvertDimension,
+// returning ValidationErrors listing every invalid field
+func (r *ConvertRequest) ValidateConvertRequest() error {
+
+	var errs ValidationErrors
+
+	if len(bytes.TrimSpace(r.SVG)) == 0 {
+		errs.missing("svg")
+	} else if !bytes.Contains(r.SVG, []byte("<svg")) {
+		errs.invalid("svg", "The body is not an svg document")
+	}
+	if r.Width < 0 || r.Width > MaxConvertDimension {
+		errs.invalid("width", "width must be between 0 and %d: width=%v", MaxConvertDimension, r.Width)
+	}
+	if r.Height < 0 || r.Height > MaxConvertDimension {
+		errs.invalid("height", "height must be between 0 and %d: height=%v", MaxConvertDimension, r.Height)
+	}
+
+	return errs.asError()
+}
+
+// ValidateAnalyseRequest checks the content of the request structure, returning ValidationErrors listing every invalid field
 func (r *AnalyseRequest) ValidateAnalyseRequest() error {
 
-	var missingFields []string
+	var errs ValidationErrors
 
 	if r.Geography == nil {
-		missingFields = append(missingFields, "geography")
+		errs.missing("geography")
 	} else {
 		if r.Geography.Topojson == nil {
-			missingFields = append(missingFields, "geography.topojson")
+			errs.missing("geography.topojson")
+		} else {
+			validateObjectName(r.Geography, &errs)
 		}
 		if len(r.Geography.IDProperty) == 0 {
-			missingFields = append(missingFields, "geography.id_property")
+			errs.missing("geography.id_property")
 		}
+		validateIDAliases(r.Geography.IDAliases, &errs)
 	}
 
 	if len(r.CSV) == 0 {
-		missingFields = append(missingFields, "csv")
+		errs.missing("csv")
 	}
 
-	if missingFields != nil {
-		return fmt.Errorf("Missing mandatory field(s): %v", missingFields)
+	if r.IDIndex < 0 {
+		errs.invalid("id_index", "id_index must be >=0: id_index=%v", r.IDIndex)
 	}
-	if r.IDIndex < 0 || r.ValueIndex < 0 {
-		return fmt.Errorf("id_index and value_index must be >=0: id_index=%v, value_index=%v", r.IDIndex, r.ValueIndex)
+	if r.ValueIndex < 0 {
+		errs.invalid("value_index", "value_index must be >=0: value_index=%v", r.ValueIndex)
 	}
+	validateHistogramBins(r.HistogramBins, &errs)
+	validateClassification(r.Classification, &errs)
+	validateDecimalPlaces("decimal_places", r.DecimalPlaces, &errs)
 	if r.IDIndex == r.ValueIndex {
-		return fmt.Errorf("id_index and value_index cannot refer to the same column: id_index=%v, value_index=%v", r.IDIndex, r.ValueIndex)
+		errs.invalid("value_index", "id_index and value_index cannot refer to the same column: id_index=%v, value_index=%v", r.IDIndex, r.ValueIndex)
 	}
-	return nil
+
+	return errs.asError()
 }
@@ -1,12 +1,15 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"strings"
 	"testing"
 
 	"bytes"
 
+	pb "github.com/ONSdigital/dp-map-renderer/proto"
 	"github.com/ONSdigital/dp-map-renderer/testdata"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -55,6 +58,222 @@ func TestCreateRenderRequestWithInvalidJSON(t *testing.T) {
 	})
 }
 
+func TestCreateRenderRequestVersions(t *testing.T) {
+	Convey("A request without a version is treated as version 1", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(`{"data":[{"id":"a","value":1}],"series":[{"data":[{"id":"b","value":2}]}]}`))
+		So(err, ShouldBeNil)
+		So(len(request.Data), ShouldEqual, 1)
+		So(request.Data[0].ID, ShouldEqual, "a")
+		So(request.Series, ShouldBeNil)
+	})
+
+	Convey("A version 2 request takes its data from the first series", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(`{"version":2,"series":[{"id":"2015","data":[{"id":"a","value":1}]},{"id":"2016","data":[{"id":"a","value":2}]}]}`))
+		So(err, ShouldBeNil)
+		So(request.Version, ShouldEqual, RequestVersion2)
+		So(len(request.Series), ShouldEqual, 2)
+		So(len(request.Data), ShouldEqual, 1)
+		So(request.Data[0].Value, ShouldEqual, 1)
+	})
+
+	Convey("A version 2 request with data is rejected", t, func() {
+		_, err := CreateRenderRequest(strings.NewReader(`{"version":2,"data":[{"id":"a","value":1}]}`))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A version 2 request without series fails validation", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(`{"version":2}`))
+		So(err, ShouldBeNil)
+		So(request.ValidateRenderRequest().Error(), ShouldContainSubstring, "series[0].data")
+	})
+
+	Convey("A request with an unsupported version is rejected", t, func() {
+		_, err := CreateRenderRequest(strings.NewReader(`{"version":3}`))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, "Unsupported request version: 3")
+	})
+}
+
+func TestCreateRenderRequestWithNullValues(t *testing.T) {
+	Convey("Rows with a null value are removed, so that their regions are treated as missing data", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(`{"data":[{"id":"a","value":null},{"id":"b","value":0},{"id":"c"},{"id":"d","value":1.5}]}`))
+		So(err, ShouldBeNil)
+		So(len(request.Data), ShouldEqual, 3)
+		So(*request.Data[0], ShouldResemble, DataRow{ID: "b", Value: 0})
+		So(*request.Data[1], ShouldResemble, DataRow{ID: "c", Value: 0})
+		So(*request.Data[2], ShouldResemble, DataRow{ID: "d", Value: 1.5})
+	})
+
+	Convey("Rows with a null value are removed from every series of a version 2 request", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(`{"version":2,"series":[{"data":[{"id":"a","value":null},{"id":"b","value":1}]},{"data":[{"id":"a","value":null}]}]}`))
+		So(err, ShouldBeNil)
+		So(len(request.Data), ShouldEqual, 1)
+		So(request.Data[0].ID, ShouldEqual, "b")
+		So(request.Series[1].Data, ShouldBeEmpty)
+	})
+
+	Convey("A value that isn't a number is rejected", t, func() {
+		_, err := CreateRenderRequest(strings.NewReader(`{"data":[{"id":"a","value":"x"}]}`))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCreateRenderRequestWithSuppressedValues(t *testing.T) {
+	Convey("Rows with one of the choropleth's suppressed values are moved from the data to the suppressed rows", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(`{"choropleth":{"suppressed_values":["-999","c"]},"data":[{"id":"a","value":-999},{"id":"b","value":"c"},{"id":"c","value":1},{"id":"d","value":null}]}`))
+		So(err, ShouldBeNil)
+		So(len(request.Data), ShouldEqual, 1)
+		So(request.Data[0].ID, ShouldEqual, "c")
+		So(len(request.Suppressed), ShouldEqual, 2)
+		So(request.Suppressed[0].ID, ShouldEqual, "a")
+		So(request.Suppressed[1].ID, ShouldEqual, "b")
+		So(request.Suppressed[1].Marker, ShouldEqual, "c")
+	})
+
+	Convey("The suppressed rows of a version 2 request are those of the first series", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(`{"version":2,"choropleth":{"suppressed_values":["c"]},"series":[{"data":[{"id":"a","value":"c"}]},{"data":[{"id":"a","value":1}]}]}`))
+		So(err, ShouldBeNil)
+		So(request.Data, ShouldBeEmpty)
+		So(len(request.Suppressed), ShouldEqual, 1)
+		So(request.Series[1].Suppressed, ShouldBeEmpty)
+	})
+
+	Convey("A value that isn't one of the suppressed values is rejected", t, func() {
+		_, err := CreateRenderRequest(strings.NewReader(`{"choropleth":{"suppressed_values":["c"]},"data":[{"id":"a","value":"x"}]}`))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRenderRequestFromProto(t *testing.T) {
+	Convey("A request converted to a protocol buffer message is converted back to the same request", t, func() {
+		request, err := CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+
+		message, err := request.Proto()
+		So(err, ShouldBeNil)
+		So(message.Geography.Topojson, ShouldNotBeEmpty)
+
+		converted, err := RenderRequestFromProto(message)
+		So(err, ShouldBeNil)
+		So(converted.ValidateRenderRequest(), ShouldBeNil)
+		So(converted.Title, ShouldEqual, request.Title)
+		So(converted.Footnotes, ShouldResemble, request.Footnotes)
+		So(converted.Data, ShouldResemble, request.Data)
+		So(converted.Choropleth, ShouldResemble, request.Choropleth)
+		So(converted.Geography.IDProperty, ShouldResemble, request.Geography.IDProperty)
+		So(len(converted.Geography.Topojson.Arcs), ShouldEqual, len(request.Geography.Topojson.Arcs))
+		So(converted.DefaultWidth, ShouldEqual, request.DefaultWidth)
+		So(converted.FontSize, ShouldEqual, request.FontSize)
+	})
+
+	Convey("A nil message returns ErrorNoData", t, func() {
+		_, err := RenderRequestFromProto(nil)
+		So(err, ShouldEqual, ErrorNoData)
+	})
+}
+
+func TestCreateRenderRequestFromProtobuf(t *testing.T) {
+	Convey("A request encoded as a protocol buffer is decoded to the same request as the json", t, func() {
+		request, err := CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		request.Version = RequestVersion1
+		request.Choropleth.ReferenceValue = -1.5
+		zero := 0
+		request.Choropleth.DecimalPlaces = &zero // encoded even though it is zero
+		request.FontSize = -1
+		request.Choropleth.Units = "percentage points"
+		request.RegionClass = "map__region"
+		request.Highlight = &Highlight{IDs: []string{"E06000001", "E06000002"}, Colour: "#ff0000", Label: true}
+		request.Fit = FitHighlight
+		request.ConversionFailure = ConversionFailureFail
+		request.Background = "#ffffff"
+		request.HighDPIFallback = true
+		request.FallbackStructure = FallbackStructurePicture
+		request.PNGOutput = PNGOutputMapAndLegend
+		request.Dataset = &Dataset{ID: "wellbeing-local-authority", Edition: "time-series", Version: "2", Dimension: "area", Options: map[string]string{"time": "2015-16"}}
+		request.Geography.ClassProperty = "country"
+		request.Geography.Include = &FeatureFilter{IDs: []string{"E06000001", "E06000002"}}
+		request.Geography.Exclude = &FeatureFilter{Property: "country", Values: []string{"Wales"}}
+		request.Geography.Dissolve = &Dissolve{Property: "region", Aggregate: AggregateMean}
+		request.Geography.ObjectName = "LA2014merc"
+		request.Geography.NormaliseIDs = true
+		request.Geography.IDAliases = map[string]string{"E06000060": "E07000004"}
+		request.Geography.TopojsonURL = "https://cdn.ons.gov.uk/maptiles/lad2019.json"
+		request.SourceLinkAttributes = &LinkAttributes{Target: "_blank", Data: map[string]string{"gtm-label": "source", "category": "map"}}
+		request.Sources = []*Source{{Text: "Annual Population Survey", Link: "http://foo/aps"}, {Text: "Local authorities"}}
+		request.Licences = []string{"Contains OS data"}
+
+		b, err := request.MarshalProtobuf()
+		So(err, ShouldBeNil)
+
+		decoded, err := CreateRenderRequestFromProtobuf(bytes.NewReader(b))
+		So(err, ShouldBeNil)
+		So(decoded.ValidateRenderRequest(), ShouldBeNil)
+		So(decoded.Title, ShouldEqual, request.Title)
+		So(decoded.Footnotes, ShouldResemble, request.Footnotes)
+		So(decoded.Data, ShouldResemble, request.Data)
+		So(decoded.Choropleth, ShouldResemble, request.Choropleth)
+		So(decoded.Geography.IDProperty, ShouldResemble, request.Geography.IDProperty)
+		So(decoded.Geography.ClassProperty, ShouldEqual, "country")
+		So(decoded.Geography.Include, ShouldResemble, request.Geography.Include)
+		So(decoded.Geography.Exclude, ShouldResemble, request.Geography.Exclude)
+		So(decoded.Geography.Dissolve, ShouldResemble, request.Geography.Dissolve)
+		So(decoded.Geography.ObjectName, ShouldEqual, request.Geography.ObjectName)
+		So(decoded.Geography.NormaliseIDs, ShouldBeTrue)
+		So(decoded.Geography.IDAliases, ShouldResemble, request.Geography.IDAliases)
+		So(decoded.Geography.TopojsonURL, ShouldEqual, request.Geography.TopojsonURL)
+		So(len(decoded.Geography.Topojson.Arcs), ShouldEqual, len(request.Geography.Topojson.Arcs))
+		So(decoded.DefaultWidth, ShouldEqual, request.DefaultWidth)
+		So(decoded.IncludeFallbackPng, ShouldEqual, request.IncludeFallbackPng)
+		So(decoded.FontSize, ShouldEqual, -1)
+		So(decoded.SourceLinkAttributes, ShouldResemble, request.SourceLinkAttributes)
+		So(decoded.Sources, ShouldResemble, request.Sources)
+		So(decoded.Licences, ShouldResemble, request.Licences)
+		So(decoded.RegionClass, ShouldEqual, request.RegionClass)
+		So(decoded.Highlight, ShouldResemble, request.Highlight)
+		So(decoded.Fit, ShouldEqual, request.Fit)
+		So(decoded.ConversionFailure, ShouldEqual, request.ConversionFailure)
+		So(decoded.Background, ShouldEqual, request.Background)
+		So(decoded.HighDPIFallback, ShouldBeTrue)
+		So(decoded.FallbackStructure, ShouldEqual, FallbackStructurePicture)
+		So(decoded.PNGOutput, ShouldEqual, PNGOutputMapAndLegend)
+		So(decoded.Dataset, ShouldResemble, request.Dataset)
+	})
+
+	Convey("Version 2 series are decoded", t, func() {
+		request := &RenderRequest{Version: RequestVersion2, Series: []*DataSeries{{ID: "2015", Data: []*DataRow{{ID: "a", Value: 1}}}}}
+		b, err := request.MarshalProtobuf()
+		So(err, ShouldBeNil)
+
+		decoded, err := CreateRenderRequestFromProtobuf(bytes.NewReader(b))
+		So(err, ShouldBeNil)
+		So(decoded.Series, ShouldResemble, request.Series)
+		So(decoded.Data, ShouldResemble, request.Series[0].Data)
+	})
+
+	Convey("Unknown fields are ignored", t, func() {
+		// field 99 (varint 7), followed by field 1 (title "a")
+		decoded, err := CreateRenderRequestFromProtobuf(bytes.NewReader([]byte{0x98, 0x06, 0x07, 0x0a, 0x01, 'a'}))
+		So(err, ShouldBeNil)
+		So(decoded.Title, ShouldEqual, "a")
+	})
+
+	Convey("A truncated message is rejected", t, func() {
+		_, err := CreateRenderRequestFromProtobuf(bytes.NewReader([]byte{0x0a, 0x05, 'a'}))
+		So(err, ShouldEqual, ErrorInvalidProtobuf)
+	})
+
+	Convey("A string that isn't valid utf-8 is rejected", t, func() {
+		_, err := CreateRenderRequestFromProtobuf(bytes.NewReader([]byte{0x0a, 0x01, 0xff}))
+		So(err, ShouldEqual, ErrorInvalidProtobuf)
+	})
+
+	Convey("An empty body returns ErrorNoData", t, func() {
+		_, err := CreateRenderRequestFromProtobuf(bytes.NewReader([]byte{}))
+		So(err, ShouldEqual, ErrorNoData)
+	})
+}
+
 func TestValidateRenderRequestRejectsMissingFields(t *testing.T) {
 	Convey("When a Render request has missing fields, an error is returned", t, func() {
 		request := RenderRequest{}
@@ -69,7 +288,7 @@ func TestValidateRenderRequestRejectsMissingFields(t *testing.T) {
 		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
 		request, _ := CreateRenderRequest(reader)
 		request.Geography.Topojson = nil
-		request.Geography.IDProperty = ""
+		request.Geography.IDProperty = nil
 
 		err := request.ValidateRenderRequest()
 		So(err, ShouldNotBeNil)
@@ -80,6 +299,396 @@ func TestValidateRenderRequestRejectsMissingFields(t *testing.T) {
 
 }
 
+func TestValidateRenderRequestRejectsInvalidChoropleth(t *testing.T) {
+	Convey("Given a valid render request", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		request, _ := CreateRenderRequest(reader)
+		So(request.ValidateRenderRequest(), ShouldBeNil)
+
+		Convey("Breaks in descending order are valid", func() {
+			request.Choropleth.Breaks = []*ChoroplethBreak{{LowerBound: 20}, {LowerBound: 10}, {LowerBound: 0}}
+			request.Choropleth.UpperBound = 30
+			So(request.ValidateRenderRequest(), ShouldBeNil)
+		})
+
+		Convey("Empty breaks are rejected", func() {
+			request.Choropleth.Breaks = nil
+			err := request.ValidateRenderRequest()
+			So(err, ShouldHaveSameTypeAs, ValidationErrors{})
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "choropleth.breaks")
+		})
+
+		Convey("Non-monotonic breaks are rejected", func() {
+			request.Choropleth.Breaks = []*ChoroplethBreak{{LowerBound: 0}, {LowerBound: 10}, {LowerBound: 10}, {LowerBound: 5}}
+			request.Choropleth.UpperBound = 0
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(len(err.(ValidationErrors)), ShouldEqual, 2)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "choropleth.breaks[2].lower_bound")
+			So(err.(ValidationErrors)[1].Field, ShouldEqual, "choropleth.breaks[3].lower_bound")
+		})
+
+		Convey("An upper bound below the highest break is rejected", func() {
+			request.Choropleth.UpperBound = 1
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "choropleth.upper_bound")
+		})
+
+		Convey("Unknown legend positions are rejected", func() {
+			request.Choropleth.HorizontalLegendPosition = "top"
+			request.Choropleth.VerticalLegendPosition = LegendPositionInsideTopLeft
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(len(err.(ValidationErrors)), ShouldEqual, 1)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "choropleth.horizontal_legend_position")
+		})
+
+		Convey("Unknown comparison modes are rejected", func() {
+			request.ComparisonMode = ComparisonModeSlider
+			So(request.ValidateRenderRequest(), ShouldBeNil)
+
+			request.ComparisonMode = "overlay"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "comparison_mode")
+		})
+
+		Convey("Unknown difference modes are rejected", func() {
+			request.DifferenceMode = DifferenceModePercentage
+			So(request.ValidateRenderRequest(), ShouldBeNil)
+
+			request.DifferenceMode = "ratio"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "difference_mode")
+		})
+
+		Convey("Colours that could break out of a style attribute are rejected", func() {
+			request.Choropleth.Breaks[0].Colour = "#fff"
+			request.Choropleth.Breaks[1].Colour = "hsl(120, 100%, 25%)"
+			So(request.ValidateRenderRequest(), ShouldBeNil)
+
+			request.Choropleth.Breaks[1].Colour = `red;" onmouseover="alert(1)`
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "choropleth.breaks[1].colour")
+		})
+
+		Convey("Invalid reference tick styles are rejected", func() {
+			request.Choropleth.ReferenceColour = "#c00"
+			request.Choropleth.ReferenceDash = "4, 2"
+			request.Choropleth.ReferenceLabelPlacement = ReferenceLabelAfter
+			So(request.ValidateRenderRequest(), ShouldBeNil)
+
+			request.Choropleth.ReferenceDash = "4;stroke:red"
+			request.Choropleth.ReferenceLabelPlacement = "above"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "choropleth.reference_dash")
+			So(err.(ValidationErrors)[1].Field, ShouldEqual, "choropleth.reference_label_placement")
+		})
+
+		Convey("Unknown swatch shapes and sizes out of range are rejected", func() {
+			request.Choropleth.SwatchShape = SwatchShapeCircle
+			request.Choropleth.SwatchSize = 12
+			So(request.ValidateRenderRequest(), ShouldBeNil)
+
+			request.Choropleth.SwatchShape = "star"
+			request.Choropleth.SwatchSize = -1
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "choropleth.swatch_size")
+			So(err.(ValidationErrors)[1].Field, ShouldEqual, "choropleth.swatch_shape")
+		})
+
+		Convey("Caption heading levels other than 1 to 6 are rejected", func() {
+			request.CaptionHeadingLevel = 7
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "caption_heading_level")
+		})
+
+		Convey("Invalid source link attributes are rejected", func() {
+			request.SourceLinkAttributes = &LinkAttributes{Target: "_blank", Data: map[string]string{"GTM label": "source"}}
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "source_link_attributes.data")
+		})
+
+		Convey("Sources without text or with an unsupported link are rejected", func() {
+			request.Sources = []*Source{{Link: "http://foo/aps"}, {Text: "ONS", Link: "javascript:alert(1)"}}
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "sources[0].text")
+			So(err.(ValidationErrors)[1].Field, ShouldEqual, "sources[1].link")
+		})
+
+		Convey("Region classes that aren't a single class name are rejected", func() {
+			request.RegionClass = "map region"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "region_class")
+		})
+
+		Convey("Feature filters without ids, or with a property but no values, are rejected", func() {
+			request.Geography.Include = &FeatureFilter{}
+			request.Geography.Exclude = &FeatureFilter{Property: "country"}
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "geography.include")
+			So(err.(ValidationErrors)[1].Field, ShouldEqual, "geography.exclude.values")
+		})
+
+		Convey("A highlight without ids, or with an invalid colour, is rejected", func() {
+			request.Highlight = &Highlight{Colour: "red;"}
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "highlight.ids")
+			So(err.(ValidationErrors)[1].Field, ShouldEqual, "highlight.colour")
+		})
+
+		Convey("An unknown fit, or fitting to the highlight without one, is rejected", func() {
+			request.Fit = "zoom"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "fit")
+
+			request.Fit = FitHighlight
+			err = request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "highlight")
+		})
+
+		Convey("An unknown conversion failure is rejected", func() {
+			request.ConversionFailure = "ignore"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "conversion_failure")
+		})
+
+		Convey("An unknown fallback structure is rejected", func() {
+			request.FallbackStructure = "object"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "fallback_structure")
+		})
+
+		Convey("An unknown png output is rejected", func() {
+			request.PNGOutput = "legend"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "png_output")
+		})
+
+		Convey("A dataset without an id, edition and version is rejected", func() {
+			request.Dataset = &Dataset{ID: "wellbeing-local-authority"}
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "dataset.edition")
+			So(err.(ValidationErrors)[1].Field, ShouldEqual, "dataset.version")
+		})
+
+		Convey("A dataset with an option for its geography dimension is rejected", func() {
+			request.Dataset = &Dataset{ID: "wellbeing-local-authority", Edition: "time-series", Version: "2", Options: map[string]string{"time": "2015-16"}}
+			So(request.ValidateRenderRequest(), ShouldBeNil)
+
+			request.Dataset.Options[DefaultDatasetDimension] = "E06000001"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "dataset.options")
+		})
+
+		Convey("A dataset given with data is rejected before its observations are fetched", func() {
+			request.Dataset = &Dataset{ID: "wellbeing-local-authority", Edition: "time-series", Version: "2"}
+			err := request.ValidateDataset()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "data")
+
+			request.Data = nil
+			So(request.ValidateDataset(), ShouldBeNil)
+		})
+
+		Convey("A background that could break out of the svg attribute is rejected", func() {
+			request.Background = `red"/><script>`
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "background")
+		})
+
+		Convey("An id aliased to an empty region id is rejected", func() {
+			request.Geography.IDAliases = map[string]string{"E06000001": "E06000002", "E06000003": ""}
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "geography.id_aliases[E06000003]")
+		})
+
+		Convey("An object name that isn't in the topology is rejected", func() {
+			request.Geography.ObjectName = "country"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "geography.object_name")
+		})
+
+		Convey("Decimal places out of range are rejected", func() {
+			decimalPlaces := 11
+			request.Choropleth.DecimalPlaces = &decimalPlaces
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "choropleth.decimal_places")
+		})
+
+		Convey("Unknown key scales are rejected", func() {
+			request.Choropleth.KeyScale = KeyScaleLog
+			So(request.ValidateRenderRequest(), ShouldBeNil)
+
+			request.Choropleth.KeyScale = "sqrt"
+			err := request.ValidateRenderRequest()
+			So(err, ShouldNotBeNil)
+			So(err.(ValidationErrors)[0].Field, ShouldEqual, "choropleth.key_scale")
+		})
+	})
+}
+
+func TestValidateRenderRequestRejectsUnsafeLinks(t *testing.T) {
+	Convey("Given a valid render request", t, func() {
+		request, _ := CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+
+		Convey("http, https, mailto and relative source links are valid", func() {
+			for _, link := range []string{"http://www.ons.gov.uk", "HTTPS://www.ons.gov.uk", "mailto:census@ons.gov.uk", "/census"} {
+				request.SourceLink = link
+				So(request.ValidateRenderRequest(), ShouldBeNil)
+			}
+		})
+
+		Convey("Links with other schemes are rejected", func() {
+			for _, link := range []string{"javascript:alert(1)", "JavaScript:alert(1)", "data:text/html,<script>alert(1)</script>"} {
+				request.SourceLink = link
+				err := request.ValidateRenderRequest()
+				So(err, ShouldNotBeNil)
+				So(err.(ValidationErrors)[0].Field, ShouldEqual, "source_link")
+			}
+		})
+	})
+}
+
+func TestDissolveRegions(t *testing.T) {
+	topology := `{"type":"Topology","objects":{"areas":{"type":"GeometryCollection","geometries":[` +
+		`{"type":"Polygon","arcs":[[0,1]],"properties":{"code":"a","region":"r1"}},` +
+		`{"type":"Polygon","arcs":[[2,-1]],"properties":{"code":"b","region":"r1"}},` +
+		`{"type":"Polygon","arcs":[[3]],"properties":{"code":"c"}}]}},` +
+		`"arcs":[[[1,0],[1,1]],[[1,1],[0,1],[0,0],[1,0]],[[1,0],[2,0],[2,1],[1,1]],[[2,0],[3,0],[3,1],[2,1],[2,0]]]}`
+	body := `{"geography":{"topojson":` + topology + `,"id_property":"code","name_property":"name","dissolve":{"property":"region"%s}},` +
+		`"choropleth":{"breaks":[{"lower_bound":0,"colour":"red"}],"upper_bound":10,"suppressed_values":["c"]},"data":[{"id":"a","value":1},{"id":"b","value":3},{"id":"c","value":"c"}]}`
+
+	Convey("The values of merged regions are summed by default", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(fmt.Sprintf(body, "")))
+		So(err, ShouldBeNil)
+		So(request.ValidateRenderRequest(), ShouldBeNil)
+		request.DissolveRegions()
+
+		So(request.Geography.Topojson.Objects["areas"].Geometries, ShouldHaveLength, 2)
+		So(request.Data, ShouldHaveLength, 1)
+		So(request.Data[0].ID, ShouldEqual, "r1")
+		So(request.Data[0].Value, ShouldEqual, 4)
+		So(request.Suppressed, ShouldHaveLength, 1)
+		So(request.Suppressed[0].ID, ShouldEqual, "c")
+	})
+
+	Convey("The values of merged regions are averaged with the mean aggregate", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(fmt.Sprintf(body, `,"aggregate":"mean"`)))
+		So(err, ShouldBeNil)
+		request.DissolveRegions()
+
+		So(request.Data[0].Value, ShouldEqual, 2)
+	})
+
+	Convey("Unknown aggregates are rejected", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(fmt.Sprintf(body, `,"aggregate":"median"`)))
+		So(err, ShouldBeNil)
+		err = request.ValidateRenderRequest()
+		So(err, ShouldNotBeNil)
+		So(err.(ValidationErrors)[0].Field, ShouldEqual, "geography.dissolve.aggregate")
+	})
+}
+
+func TestMatchDataIDs(t *testing.T) {
+	topology := `{"type":"Topology","objects":{"areas":{"type":"GeometryCollection","geometries":[` +
+		`{"type":"Polygon","arcs":[[0]],"properties":{"code":"E06000001"}},{"type":"Polygon","arcs":[[0]],"properties":{"code":"E06000002"}}]}},` +
+		`"arcs":[[[0,0],[1,0],[1,1],[0,0]]]}`
+	body := `{"geography":{"topojson":` + topology + `,"id_property":"code","normalise_ids":%t},` +
+		`"series":[{"data":[{"id":" e06000001","value":1},{"id":"E06000002","value":2},{"id":"W06000001","value":3}]}],"version":2}`
+
+	Convey("The ids of data rows that match a region once normalised are replaced by the region's id", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(fmt.Sprintf(body, true)))
+		So(err, ShouldBeNil)
+		request.MatchDataIDs()
+
+		So(request.Data[0].ID, ShouldEqual, "E06000001")
+		So(request.Data[1].ID, ShouldEqual, "E06000002")
+		So(request.Data[2].ID, ShouldEqual, "W06000001")
+		So(request.Series[0].Data[0].ID, ShouldEqual, "E06000001")
+	})
+
+	Convey("The ids of data rows are unchanged unless the geography normalises ids", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(fmt.Sprintf(body, false)))
+		So(err, ShouldBeNil)
+		request.MatchDataIDs()
+
+		So(request.Data[0].ID, ShouldEqual, " e06000001")
+	})
+
+	Convey("The ids of data rows with an alias are replaced by the aliased region id", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(fmt.Sprintf(body, false)))
+		So(err, ShouldBeNil)
+		request.Geography.IDAliases = map[string]string{"W06000001": "E06000002", "E06000002": "E06000001"}
+		request.MatchDataIDs()
+
+		So(request.Data[1].ID, ShouldEqual, "E06000001")
+		So(request.Data[2].ID, ShouldEqual, "E06000002")
+	})
+
+	Convey("Aliases are normalised along with the ids of data rows", t, func() {
+		request, err := CreateRenderRequest(strings.NewReader(fmt.Sprintf(body, true)))
+		So(err, ShouldBeNil)
+		request.Geography.IDAliases = map[string]string{"w06000001": "e06000002"}
+		request.MatchDataIDs()
+
+		So(request.Data[0].ID, ShouldEqual, "E06000001")
+		So(request.Data[2].ID, ShouldEqual, "E06000002")
+	})
+
+	Convey("Ids are normalised to lower case with single spaces", t, func() {
+		So(NormaliseID("  Isle of\tWight "), ShouldEqual, "isle of wight")
+	})
+}
+
+func TestGeographyIDProperties(t *testing.T) {
+	Convey("A single id property is decoded as a list of one name, and encoded as a string", t, func() {
+		var g Geography
+		So(json.Unmarshal([]byte(`{"id_property":"code"}`), &g), ShouldBeNil)
+		So(g.IDProperty, ShouldResemble, PropertyNames{"code"})
+
+		b, err := json.Marshal(&g)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldStartWith, `{"id_property":"code",`)
+	})
+
+	Convey("A list of id properties is decoded in order", t, func() {
+		var g Geography
+		So(json.Unmarshal([]byte(`{"id_property":["AREACD","lad19cd","id"]}`), &g), ShouldBeNil)
+		So(g.IDProperty, ShouldResemble, PropertyNames{"AREACD", "lad19cd", "id"})
+	})
+
+	Convey("The id of a region is its first id property, falling back to its id", t, func() {
+		g := &Geography{IDProperty: PropertyNames{"AREACD", "lad19cd"}}
+		So(g.FeatureID(map[string]interface{}{"AREACD": "E06000001", "lad19cd": "E06000002"}, "f0"), ShouldEqual, "E06000001")
+		So(g.FeatureID(map[string]interface{}{"AREACD": "", "lad19cd": "E06000002"}, "f0"), ShouldEqual, "E06000002")
+		So(g.FeatureID(map[string]interface{}{"AREACD": 1}, "f0"), ShouldEqual, "f0")
+		So(g.FeatureID(map[string]interface{}{}, nil), ShouldEqual, "")
+	})
+}
+
 func TestCreateAnalyseRequestFromFile(t *testing.T) {
 	Convey("When an analyse request is passed, a valid struct is returned", t, func() {
 		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
@@ -116,6 +725,36 @@ func TestCreateAnalyseRequestWithInvalidJSON(t *testing.T) {
 	})
 }
 
+func TestAnalyseRequestFromProto(t *testing.T) {
+	Convey("A request converted to a protocol buffer message is converted back to the same request", t, func() {
+		request, err := CreateAnalyseRequest(bytes.NewReader(testdata.LoadExampleAnalyseRequest(t)))
+		So(err, ShouldBeNil)
+		referenceValue := 2.5
+		request.ReferenceValue = &referenceValue
+		request.HistogramBins = 5
+
+		message, err := request.Proto()
+		So(err, ShouldBeNil)
+
+		converted, err := AnalyseRequestFromProto(message)
+		So(err, ShouldBeNil)
+		So(converted.ValidateAnalyseRequest(), ShouldBeNil)
+		So(converted.CSV, ShouldEqual, request.CSV)
+		So(converted.IDIndex, ShouldEqual, request.IDIndex)
+		So(converted.ValueIndex, ShouldEqual, request.ValueIndex)
+		So(converted.HasHeaderRow, ShouldEqual, request.HasHeaderRow)
+		So(converted.ReferenceValue, ShouldResemble, request.ReferenceValue)
+		So(converted.HistogramBins, ShouldEqual, 5)
+		So(len(converted.Geography.Topojson.Arcs), ShouldEqual, len(request.Geography.Topojson.Arcs))
+	})
+
+	Convey("A message without a reference value is converted to a request without one", t, func() {
+		converted, err := AnalyseRequestFromProto(&pb.AnalyseRequest{Csv: "a,1"})
+		So(err, ShouldBeNil)
+		So(converted.ReferenceValue, ShouldBeNil)
+	})
+}
+
 func TestValidateAnalyseRequestRejectsMissingFields(t *testing.T) {
 	Convey("When an analyse request has missing fields, an error is returned", t, func() {
 		request := AnalyseRequest{}
@@ -161,5 +800,61 @@ func TestValidateAnalyseRequestRejectsInvalidValues(t *testing.T) {
 		So(err, ShouldNotBeNil)
 		So(err.Error(), ShouldContainSubstring, "id_index and value_index cannot refer to the same column")
 	})
+	Convey("When an analyse request has a negative histogram bin count, an error is returned", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, _ := CreateAnalyseRequest(reader)
+		request.HistogramBins = -1
+
+		err := request.ValidateAnalyseRequest()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "histogram_bins")
+	})
+	Convey("When an analyse request has too many histogram bins, an error is returned", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, _ := CreateAnalyseRequest(reader)
+		request.HistogramBins = maxHistogramBins + 1
 
+		err := request.ValidateAnalyseRequest()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "histogram_bins")
+
+		request.HistogramBins = maxHistogramBins
+		So(request.ValidateAnalyseRequest(), ShouldBeNil)
+	})
+	Convey("When an analyse request has an unknown classification, an error is returned", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleAnalyseRequest(t))
+		request, _ := CreateAnalyseRequest(reader)
+		request.Classification = "quantile"
+
+		err := request.ValidateAnalyseRequest()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "classification")
+	})
+
+}
+
+func TestCreateConvertRequest(t *testing.T) {
+	Convey("A convert request has the svg and the dimensions in the query", t, func() {
+		request, err := CreateConvertRequest(strings.NewReader(`<svg></svg>`), url.Values{"width": {"80"}, "height": {"40.5"}})
+		So(err, ShouldBeNil)
+		So(string(request.SVG), ShouldEqual, `<svg></svg>`)
+		So(request.Width, ShouldEqual, 80)
+		So(request.Height, ShouldEqual, 40.5)
+		So(request.ValidateConvertRequest(), ShouldBeNil)
+	})
+
+	Convey("Dimensions that aren't numbers are rejected", t, func() {
+		_, err := CreateConvertRequest(strings.NewReader(`<svg></svg>`), url.Values{"width": {"wide"}, "height": {"NaN"}})
+		So(err, ShouldNotBeNil)
+		So(err.(ValidationErrors), ShouldHaveLength, 2)
+	})
+
+	Convey("A convert request without an svg, or with dimensions out of range, is rejected", t, func() {
+		request := &ConvertRequest{SVG: []byte(`{}`), Width: -1, Height: MaxConvertDimension + 1}
+		err := request.ValidateConvertRequest()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "svg")
+		So(err.Error(), ShouldContainSubstring, "width")
+		So(err.Error(), ShouldContainSubstring, "height")
+	})
 }
@@ -0,0 +1,713 @@
+package models
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+
+	pb "github.com/ONSdigital/dp-map-renderer/proto"
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/json-iterator/go"
+	"github.com/rubenv/topojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentTypeProtobuf is the content type of a request body containing a protocol buffer encoded RenderRequest (see proto/maprenderer.proto)
+const ContentTypeProtobuf = "application/x-protobuf"
+
+// ErrorInvalidProtobuf is returned when a protocol buffer message cannot be decoded
+var ErrorInvalidProtobuf = errors.New("Invalid protocol buffer message")
+
+// CreateRenderRequestFromProtobuf manages the creation of a RenderRequest from a reader containing a protocol buffer encoded RenderRequest message.
+// The topojson in the geography is json encoded, as in a json request.
+func CreateRenderRequestFromProtobuf(reader io.Reader) (*RenderRequest, error) {
+
+	bytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		log.Error(err, log.Data{"request_size": len(bytes)})
+		return nil, ErrorReadingBody
+	}
+
+	var message pb.RenderRequest
+	if err = proto.Unmarshal(bytes, &message); err != nil {
+		log.Error(err, log.Data{"request_size": len(bytes)})
+		return nil, ErrorInvalidProtobuf
+	}
+
+	request, err := RenderRequestFromProto(&message)
+	if err != nil {
+		return nil, err
+	}
+
+	// This should be the last check before returning RenderRequest
+	if len(bytes) == 0 {
+		return request, ErrorNoData
+	}
+
+	return request, nil
+}
+
+// MarshalProtobuf encodes the request as a protocol buffer RenderRequest message
+func (r *RenderRequest) MarshalProtobuf() ([]byte, error) {
+	message, err := r.Proto()
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(message)
+}
+
+// RenderRequestFromProto converts a RenderRequest message (see proto/maprenderer.proto) to a RenderRequest, upgrading it as a json request is upgraded.
+// The topojson in the geography is json encoded, as in a json request. Returns ErrorNoData if the message is nil.
+func RenderRequestFromProto(message *pb.RenderRequest) (*RenderRequest, error) {
+	if message == nil {
+		return nil, ErrorNoData
+	}
+	geography, err := geographyFromProto(message.Geography)
+	if err != nil {
+		return nil, err
+	}
+	r := &RenderRequest{
+		Version:              int(message.Version),
+		Title:                message.Title,
+		Subtitle:             message.Subtitle,
+		Source:               message.Source,
+		SourceLink:           message.SourceLink,
+		Licence:              message.Licence,
+		Filename:             message.Filename,
+		Footnotes:            message.Footnotes,
+		MapType:              message.MapType,
+		Geography:            geography,
+		Data:                 dataRowsFromProto(message.Data),
+		Series:               dataSeriesListFromProto(message.Series),
+		Choropleth:           choroplethFromProto(message.Choropleth),
+		DefaultWidth:         message.Width,
+		MinWidth:             message.MinWidth,
+		MaxWidth:             message.MaxWidth,
+		IncludeFallbackPng:   message.IncludeFallbackPng,
+		FallbackImageFormat:  message.FallbackImageFormat,
+		FontSize:             int(message.FontSize),
+		ComparisonMode:       message.ComparisonMode,
+		DifferenceMode:       message.DifferenceMode,
+		IncludeStyles:        message.IncludeStyles,
+		Tile:                 message.Tile,
+		Resolution:           message.Resolution,
+		OfficePreset:         message.OfficePreset,
+		Minify:               message.Minify,
+		Deterministic:        message.Deterministic,
+		IDPrefix:             message.IdPrefix,
+		Greyscale:            message.Greyscale,
+		CaptionHeadingLevel:  int(message.CaptionHeadingLevel),
+		CaptionClass:         message.CaptionClass,
+		EmbedTitle:           message.EmbedTitle,
+		SourceLinkAttributes: linkAttributesFromProto(message.SourceLinkAttributes),
+		Sources:              sourcesFromProto(message.Sources),
+		Licences:             message.Licences,
+		RegionClass:          message.RegionClass,
+		Highlight:            highlightFromProto(message.Highlight),
+		Fit:                  message.Fit,
+		ConversionFailure:    message.ConversionFailure,
+		Background:           message.Background,
+		HighDPIFallback:      message.HighDpiFallback,
+		FallbackStructure:    message.FallbackStructure,
+		PNGOutput:            message.PngOutput,
+		Dataset:              datasetFromProto(message.Dataset),
+	}
+	if err = r.upgrade(); err != nil {
+		log.Error(err, log.Data{"version": r.Version})
+		return nil, err
+	}
+	return r, nil
+}
+
+// Proto converts the request to a RenderRequest message, with the topojson in the geography json encoded
+func (r *RenderRequest) Proto() (*pb.RenderRequest, error) {
+	geography, err := geographyToProto(r.Geography)
+	if err != nil {
+		return nil, err
+	}
+	message := &pb.RenderRequest{
+		Version:              int32(r.Version),
+		Title:                r.Title,
+		Subtitle:             r.Subtitle,
+		Source:               r.Source,
+		SourceLink:           r.SourceLink,
+		Licence:              r.Licence,
+		Filename:             r.Filename,
+		Footnotes:            r.Footnotes,
+		MapType:              r.MapType,
+		Geography:            geography,
+		Series:               dataSeriesListToProto(r.Series),
+		Choropleth:           choroplethToProto(r.Choropleth),
+		Width:                r.DefaultWidth,
+		MinWidth:             r.MinWidth,
+		MaxWidth:             r.MaxWidth,
+		IncludeFallbackPng:   r.IncludeFallbackPng,
+		FallbackImageFormat:  r.FallbackImageFormat,
+		FontSize:             int32(r.FontSize),
+		ComparisonMode:       r.ComparisonMode,
+		DifferenceMode:       r.DifferenceMode,
+		IncludeStyles:        r.IncludeStyles,
+		Tile:                 r.Tile,
+		Resolution:           r.Resolution,
+		OfficePreset:         r.OfficePreset,
+		Minify:               r.Minify,
+		Deterministic:        r.Deterministic,
+		IdPrefix:             r.IDPrefix,
+		Greyscale:            r.Greyscale,
+		CaptionHeadingLevel:  int32(r.CaptionHeadingLevel),
+		CaptionClass:         r.CaptionClass,
+		EmbedTitle:           r.EmbedTitle,
+		SourceLinkAttributes: linkAttributesToProto(r.SourceLinkAttributes),
+		Sources:              sourcesToProto(r.Sources),
+		Licences:             r.Licences,
+		RegionClass:          r.RegionClass,
+		Highlight:            highlightToProto(r.Highlight),
+		Fit:                  r.Fit,
+		ConversionFailure:    r.ConversionFailure,
+		Background:           r.Background,
+		HighDpiFallback:      r.HighDPIFallback,
+		FallbackStructure:    r.FallbackStructure,
+		PngOutput:            r.PNGOutput,
+		Dataset:              datasetToProto(r.Dataset),
+	}
+	// the data of a version 2 request is the data of its first series, which is already encoded
+	if r.Version != RequestVersion2 {
+		message.Data = dataRowsToProto(r.Data)
+	}
+	return message, nil
+}
+
+// geographyFromProto converts a Geography message to a Geography
+func geographyFromProto(message *pb.Geography) (*Geography, error) {
+	if message == nil {
+		return nil, nil
+	}
+	g := &Geography{
+		IDProperty:    PropertyNames(message.IdProperty),
+		NameProperty:  message.NameProperty,
+		ClassProperty: message.ClassProperty,
+		Include:       featureFilterFromProto(message.Include),
+		Exclude:       featureFilterFromProto(message.Exclude),
+		Dissolve:      dissolveFromProto(message.Dissolve),
+		ObjectName:    message.ObjectName,
+		NormaliseIDs:  message.NormaliseIds,
+		IDAliases:     message.IdAliases,
+		TopojsonURL:   message.TopojsonUrl,
+	}
+	if len(message.Topojson) > 0 {
+		g.Topojson = &topojson.Topology{}
+		if err := jsoniter.Unmarshal(message.Topojson, g.Topojson); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// geographyToProto converts a Geography to a Geography message
+func geographyToProto(g *Geography) (*pb.Geography, error) {
+	if g == nil {
+		return nil, nil
+	}
+	message := &pb.Geography{
+		IdProperty:    []string(g.IDProperty),
+		NameProperty:  g.NameProperty,
+		ClassProperty: g.ClassProperty,
+		Include:       featureFilterToProto(g.Include),
+		Exclude:       featureFilterToProto(g.Exclude),
+		Dissolve:      dissolveToProto(g.Dissolve),
+		ObjectName:    g.ObjectName,
+		NormaliseIds:  g.NormaliseIDs,
+		IdAliases:     g.IDAliases,
+		TopojsonUrl:   g.TopojsonURL,
+	}
+	if g.Topojson != nil {
+		var err error
+		if message.Topojson, err = jsoniter.Marshal(g.Topojson); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+// featureFilterFromProto converts a FeatureFilter message to a FeatureFilter
+func featureFilterFromProto(message *pb.FeatureFilter) *FeatureFilter {
+	if message == nil {
+		return nil
+	}
+	f := &FeatureFilter{
+		IDs:      message.Ids,
+		Property: message.Property,
+		Values:   message.Values,
+	}
+	return f
+}
+
+// featureFilterToProto converts a FeatureFilter to a FeatureFilter message
+func featureFilterToProto(f *FeatureFilter) *pb.FeatureFilter {
+	if f == nil {
+		return nil
+	}
+	message := &pb.FeatureFilter{
+		Ids:      f.IDs,
+		Property: f.Property,
+		Values:   f.Values,
+	}
+	return message
+}
+
+// dissolveFromProto converts a Dissolve message to a Dissolve
+func dissolveFromProto(message *pb.Dissolve) *Dissolve {
+	if message == nil {
+		return nil
+	}
+	d := &Dissolve{
+		Property:  message.Property,
+		Aggregate: message.Aggregate,
+	}
+	return d
+}
+
+// dissolveToProto converts a Dissolve to a Dissolve message
+func dissolveToProto(d *Dissolve) *pb.Dissolve {
+	if d == nil {
+		return nil
+	}
+	message := &pb.Dissolve{
+		Property:  d.Property,
+		Aggregate: d.Aggregate,
+	}
+	return message
+}
+
+// dataRowFromProto converts a DataRow message to a DataRow
+func dataRowFromProto(message *pb.DataRow) *DataRow {
+	if message == nil {
+		return nil
+	}
+	row := &DataRow{
+		ID:       message.Id,
+		Value:    message.Value,
+		Category: message.Category,
+		Marker:   message.Marker,
+	}
+	return row
+}
+
+// dataRowToProto converts a DataRow to a DataRow message
+func dataRowToProto(row *DataRow) *pb.DataRow {
+	if row == nil {
+		return nil
+	}
+	message := &pb.DataRow{
+		Id:       row.ID,
+		Value:    row.Value,
+		Category: row.Category,
+		Marker:   row.Marker,
+	}
+	return message
+}
+
+// dataRowsFromProto converts a list of DataRow messages to a list of DataRow
+func dataRowsFromProto(messages []*pb.DataRow) []*DataRow {
+	var list []*DataRow
+	for _, message := range messages {
+		list = append(list, dataRowFromProto(message))
+	}
+	return list
+}
+
+// dataRowsToProto converts a list of DataRow to a list of DataRow messages
+func dataRowsToProto(list []*DataRow) []*pb.DataRow {
+	var messages []*pb.DataRow
+	for _, row := range list {
+		messages = append(messages, dataRowToProto(row))
+	}
+	return messages
+}
+
+// dataSeriesFromProto converts a DataSeries message to a DataSeries
+func dataSeriesFromProto(message *pb.DataSeries) *DataSeries {
+	if message == nil {
+		return nil
+	}
+	s := &DataSeries{
+		ID:    message.Id,
+		Title: message.Title,
+		Data:  dataRowsFromProto(message.Data),
+	}
+	return s
+}
+
+// dataSeriesToProto converts a DataSeries to a DataSeries message
+func dataSeriesToProto(s *DataSeries) *pb.DataSeries {
+	if s == nil {
+		return nil
+	}
+	message := &pb.DataSeries{
+		Id:    s.ID,
+		Title: s.Title,
+		Data:  dataRowsToProto(s.Data),
+	}
+	return message
+}
+
+// dataSeriesListFromProto converts a list of DataSeries messages to a list of DataSeries
+func dataSeriesListFromProto(messages []*pb.DataSeries) []*DataSeries {
+	var list []*DataSeries
+	for _, message := range messages {
+		list = append(list, dataSeriesFromProto(message))
+	}
+	return list
+}
+
+// dataSeriesListToProto converts a list of DataSeries to a list of DataSeries messages
+func dataSeriesListToProto(list []*DataSeries) []*pb.DataSeries {
+	var messages []*pb.DataSeries
+	for _, s := range list {
+		messages = append(messages, dataSeriesToProto(s))
+	}
+	return messages
+}
+
+// choroplethFromProto converts a Choropleth message to a Choropleth
+func choroplethFromProto(message *pb.Choropleth) *Choropleth {
+	if message == nil {
+		return nil
+	}
+	c := &Choropleth{
+		ReferenceValue:           message.ReferenceValue,
+		ReferenceValueText:       message.ReferenceValueText,
+		ValuePrefix:              message.ValuePrefix,
+		ValueSuffix:              message.ValueSuffix,
+		Breaks:                   choroplethBreaksFromProto(message.Breaks),
+		UpperBound:               message.UpperBound,
+		HorizontalLegendPosition: message.HorizontalLegendPosition,
+		VerticalLegendPosition:   message.VerticalLegendPosition,
+		MissingDataText:          message.MissingDataText,
+		HideMissingDataKey:       message.HideMissingDataKey,
+		BoundInclusion:           message.BoundInclusion,
+		SuppressedValues:         message.SuppressedValues,
+		SuppressedText:           message.SuppressedText,
+		ReferenceColour:          message.ReferenceColour,
+		ReferenceDash:            message.ReferenceDash,
+		ReferenceLabelPlacement:  message.ReferenceLabelPlacement,
+		References:               referencesFromProto(message.References),
+		ReverseLegend:            message.ReverseLegend,
+		SwatchShape:              message.SwatchShape,
+		SwatchSize:               message.SwatchSize,
+		KeyScale:                 message.KeyScale,
+		DecimalPlaces:            intFromProto(message.DecimalPlaces),
+		TooltipTemplate:          message.TooltipTemplate,
+		Units:                    message.Units,
+	}
+	return c
+}
+
+// choroplethToProto converts a Choropleth to a Choropleth message
+func choroplethToProto(c *Choropleth) *pb.Choropleth {
+	if c == nil {
+		return nil
+	}
+	message := &pb.Choropleth{
+		ReferenceValue:           c.ReferenceValue,
+		ReferenceValueText:       c.ReferenceValueText,
+		ValuePrefix:              c.ValuePrefix,
+		ValueSuffix:              c.ValueSuffix,
+		Breaks:                   choroplethBreaksToProto(c.Breaks),
+		UpperBound:               c.UpperBound,
+		HorizontalLegendPosition: c.HorizontalLegendPosition,
+		VerticalLegendPosition:   c.VerticalLegendPosition,
+		MissingDataText:          c.MissingDataText,
+		HideMissingDataKey:       c.HideMissingDataKey,
+		BoundInclusion:           c.BoundInclusion,
+		SuppressedValues:         c.SuppressedValues,
+		SuppressedText:           c.SuppressedText,
+		ReferenceColour:          c.ReferenceColour,
+		ReferenceDash:            c.ReferenceDash,
+		ReferenceLabelPlacement:  c.ReferenceLabelPlacement,
+		References:               referencesToProto(c.References),
+		ReverseLegend:            c.ReverseLegend,
+		SwatchShape:              c.SwatchShape,
+		SwatchSize:               c.SwatchSize,
+		KeyScale:                 c.KeyScale,
+		DecimalPlaces:            int32ToProto(c.DecimalPlaces),
+		TooltipTemplate:          c.TooltipTemplate,
+		Units:                    c.Units,
+	}
+	return message
+}
+
+// choroplethBreakFromProto converts a ChoroplethBreak message to a ChoroplethBreak
+func choroplethBreakFromProto(message *pb.ChoroplethBreak) *ChoroplethBreak {
+	if message == nil {
+		return nil
+	}
+	b := &ChoroplethBreak{
+		LowerBound: message.LowerBound,
+		Colour:     message.Color,
+		Pattern:    message.Pattern,
+		Label:      message.Label,
+	}
+	return b
+}
+
+// choroplethBreakToProto converts a ChoroplethBreak to a ChoroplethBreak message
+func choroplethBreakToProto(b *ChoroplethBreak) *pb.ChoroplethBreak {
+	if b == nil {
+		return nil
+	}
+	message := &pb.ChoroplethBreak{
+		LowerBound: b.LowerBound,
+		Color:      b.Colour,
+		Pattern:    b.Pattern,
+		Label:      b.Label,
+	}
+	return message
+}
+
+// choroplethBreaksFromProto converts a list of ChoroplethBreak messages to a list of ChoroplethBreak
+func choroplethBreaksFromProto(messages []*pb.ChoroplethBreak) []*ChoroplethBreak {
+	var list []*ChoroplethBreak
+	for _, message := range messages {
+		list = append(list, choroplethBreakFromProto(message))
+	}
+	return list
+}
+
+// choroplethBreaksToProto converts a list of ChoroplethBreak to a list of ChoroplethBreak messages
+func choroplethBreaksToProto(list []*ChoroplethBreak) []*pb.ChoroplethBreak {
+	var messages []*pb.ChoroplethBreak
+	for _, b := range list {
+		messages = append(messages, choroplethBreakToProto(b))
+	}
+	return messages
+}
+
+// referenceFromProto converts a Reference message to a Reference
+func referenceFromProto(message *pb.Reference) *Reference {
+	if message == nil {
+		return nil
+	}
+	ref := &Reference{
+		Value: message.Value,
+		Text:  message.Text,
+	}
+	return ref
+}
+
+// referenceToProto converts a Reference to a Reference message
+func referenceToProto(ref *Reference) *pb.Reference {
+	if ref == nil {
+		return nil
+	}
+	message := &pb.Reference{
+		Value: ref.Value,
+		Text:  ref.Text,
+	}
+	return message
+}
+
+// referencesFromProto converts a list of Reference messages to a list of Reference
+func referencesFromProto(messages []*pb.Reference) []*Reference {
+	var list []*Reference
+	for _, message := range messages {
+		list = append(list, referenceFromProto(message))
+	}
+	return list
+}
+
+// referencesToProto converts a list of Reference to a list of Reference messages
+func referencesToProto(list []*Reference) []*pb.Reference {
+	var messages []*pb.Reference
+	for _, ref := range list {
+		messages = append(messages, referenceToProto(ref))
+	}
+	return messages
+}
+
+// linkAttributesFromProto converts a LinkAttributes message to a LinkAttributes
+func linkAttributesFromProto(message *pb.LinkAttributes) *LinkAttributes {
+	if message == nil {
+		return nil
+	}
+	a := &LinkAttributes{
+		Target: message.Target,
+		Rel:    message.Rel,
+		Data:   message.Data,
+	}
+	return a
+}
+
+// linkAttributesToProto converts a LinkAttributes to a LinkAttributes message
+func linkAttributesToProto(a *LinkAttributes) *pb.LinkAttributes {
+	if a == nil {
+		return nil
+	}
+	message := &pb.LinkAttributes{
+		Target: a.Target,
+		Rel:    a.Rel,
+		Data:   a.Data,
+	}
+	return message
+}
+
+// sourceFromProto converts a Source message to a Source
+func sourceFromProto(message *pb.Source) *Source {
+	if message == nil {
+		return nil
+	}
+	s := &Source{
+		Text: message.Text,
+		Link: message.Link,
+	}
+	return s
+}
+
+// sourceToProto converts a Source to a Source message
+func sourceToProto(s *Source) *pb.Source {
+	if s == nil {
+		return nil
+	}
+	message := &pb.Source{
+		Text: s.Text,
+		Link: s.Link,
+	}
+	return message
+}
+
+// sourcesFromProto converts a list of Source messages to a list of Source
+func sourcesFromProto(messages []*pb.Source) []*Source {
+	var list []*Source
+	for _, message := range messages {
+		list = append(list, sourceFromProto(message))
+	}
+	return list
+}
+
+// sourcesToProto converts a list of Source to a list of Source messages
+func sourcesToProto(list []*Source) []*pb.Source {
+	var messages []*pb.Source
+	for _, s := range list {
+		messages = append(messages, sourceToProto(s))
+	}
+	return messages
+}
+
+// highlightFromProto converts a Highlight message to a Highlight
+func highlightFromProto(message *pb.Highlight) *Highlight {
+	if message == nil {
+		return nil
+	}
+	h := &Highlight{
+		IDs:    message.Ids,
+		Colour: message.Colour,
+		Label:  message.Label,
+	}
+	return h
+}
+
+// highlightToProto converts a Highlight to a Highlight message
+func highlightToProto(h *Highlight) *pb.Highlight {
+	if h == nil {
+		return nil
+	}
+	message := &pb.Highlight{
+		Ids:    h.IDs,
+		Colour: h.Colour,
+		Label:  h.Label,
+	}
+	return message
+}
+
+// datasetFromProto converts a Dataset message to a Dataset
+func datasetFromProto(message *pb.Dataset) *Dataset {
+	if message == nil {
+		return nil
+	}
+	d := &Dataset{
+		ID:        message.Id,
+		Edition:   message.Edition,
+		Version:   message.Version,
+		Dimension: message.Dimension,
+		Options:   message.Options,
+	}
+	return d
+}
+
+// datasetToProto converts a Dataset to a Dataset message
+func datasetToProto(d *Dataset) *pb.Dataset {
+	if d == nil {
+		return nil
+	}
+	message := &pb.Dataset{
+		Id:        d.ID,
+		Edition:   d.Edition,
+		Version:   d.Version,
+		Dimension: d.Dimension,
+		Options:   d.Options,
+	}
+	return message
+}
+
+// AnalyseRequestFromProto converts an AnalyseRequest message (see proto/maprenderer.proto) to an AnalyseRequest. Returns ErrorNoData if the message is nil.
+func AnalyseRequestFromProto(message *pb.AnalyseRequest) (*AnalyseRequest, error) {
+	if message == nil {
+		return nil, ErrorNoData
+	}
+	geography, err := geographyFromProto(message.Geography)
+	if err != nil {
+		return nil, err
+	}
+	r := &AnalyseRequest{
+		Geography:      geography,
+		CSV:            message.Csv,
+		IDIndex:        int(message.IdIndex),
+		ValueIndex:     int(message.ValueIndex),
+		HasHeaderRow:   message.HasHeaderRow,
+		ReferenceValue: message.ReferenceValue,
+		HistogramBins:  int(message.HistogramBins),
+		Classification: message.Classification,
+		DecimalPlaces:  intFromProto(message.DecimalPlaces),
+		Units:          message.Units,
+	}
+	return r, nil
+}
+
+// Proto converts the request to a AnalyseRequest message, with the topojson in the geography json encoded
+func (r *AnalyseRequest) Proto() (*pb.AnalyseRequest, error) {
+	geography, err := geographyToProto(r.Geography)
+	if err != nil {
+		return nil, err
+	}
+	message := &pb.AnalyseRequest{
+		Geography:      geography,
+		Csv:            r.CSV,
+		IdIndex:        int32(r.IDIndex),
+		ValueIndex:     int32(r.ValueIndex),
+		HasHeaderRow:   r.HasHeaderRow,
+		ReferenceValue: r.ReferenceValue,
+		HistogramBins:  int32(r.HistogramBins),
+		Classification: r.Classification,
+		DecimalPlaces:  int32ToProto(r.DecimalPlaces),
+		Units:          r.Units,
+	}
+	return message, nil
+}
+
+// intFromProto converts an optional int32 field to an optional int
+func intFromProto(v *int32) *int {
+	if v == nil {
+		return nil
+	}
+	i := int(*v)
+	return &i
+}
+
+// int32ToProto converts an optional int to an optional int32 field
+func int32ToProto(v *int) *int32 {
+	if v == nil {
+		return nil
+	}
+	i := int32(*v)
+	return &i
+}
@@ -0,0 +1,496 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// missingFieldMessage is the message of a ValidationError for a mandatory field that has no value
+const missingFieldMessage = "Missing mandatory field"
+
+// ValidationError describes a problem with a single field of a request
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors lists all the problems found when validating a request
+type ValidationErrors []*ValidationError
+
+// Error summarises the errors, listing missing fields together, followed by the field and message of any other errors
+func (errs ValidationErrors) Error() string {
+	var missingFields []string
+	var messages []string
+	for _, e := range errs {
+		if e.Message == missingFieldMessage {
+			missingFields = append(missingFields, e.Field)
+		} else {
+			messages = append(messages, fmt.Sprintf("%s: %s", e.Field, e.Message))
+		}
+	}
+	if missingFields != nil {
+		messages = append([]string{fmt.Sprintf("Missing mandatory field(s): %v", missingFields)}, messages...)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// missing adds an error for each of the mandatory fields
+func (errs *ValidationErrors) missing(fields ...string) {
+	for _, field := range fields {
+		*errs = append(*errs, &ValidationError{Field: field, Message: missingFieldMessage})
+	}
+}
+
+// invalid adds an error for the field
+func (errs *ValidationErrors) invalid(field string, format string, a ...interface{}) {
+	*errs = append(*errs, &ValidationError{Field: field, Message: fmt.Sprintf(format, a...)})
+}
+
+// asError returns the errors as an error, or nil if there are none
+func (errs ValidationErrors) asError() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validLegendPositions are the values allowed for HorizontalLegendPosition and VerticalLegendPosition
+var validLegendPositions = []string{
+	"",
+	"none",
+	LegendPositionBefore,
+	LegendPositionAfter,
+	LegendPositionInsideTopLeft,
+	LegendPositionInsideTopRight,
+	LegendPositionInsideBottomLeft,
+	LegendPositionInsideBottomRight,
+}
+
+// validFallbackImageFormats are the values allowed for FallbackImageFormat
+var validFallbackImageFormats = []string{"", "png", "webp", "avif", "jpeg"}
+
+// validateFallbackImageFormat checks that the fallback image format is one of the supported formats
+func validateFallbackImageFormat(format string, errs *ValidationErrors) {
+	for _, f := range validFallbackImageFormats {
+		if format == f {
+			return
+		}
+	}
+	errs.invalid("fallback_image_format", "Unknown image format '%s'. Must be one of %v", format, strings.Join(validFallbackImageFormats[1:], ", "))
+}
+
+// validComparisonModes are the values allowed for ComparisonMode
+var validComparisonModes = []string{"", ComparisonModeSideBySide, ComparisonModeToggle, ComparisonModeSlider}
+
+// validateComparisonMode checks that the comparison mode is one of the supported modes
+func validateComparisonMode(mode string, errs *ValidationErrors) {
+	for _, m := range validComparisonModes {
+		if mode == m {
+			return
+		}
+	}
+	errs.invalid("comparison_mode", "Unknown comparison mode '%s'. Must be one of %v", mode, strings.Join(validComparisonModes[1:], ", "))
+}
+
+// validDifferenceModes are the values allowed for DifferenceMode
+var validDifferenceModes = []string{"", DifferenceModeAbsolute, DifferenceModePercentage}
+
+// validateDifferenceMode checks that the difference mode is one of the supported modes
+func validateDifferenceMode(mode string, errs *ValidationErrors) {
+	for _, m := range validDifferenceModes {
+		if mode == m {
+			return
+		}
+	}
+	errs.invalid("difference_mode", "Unknown difference mode '%s'. Must be one of %v", mode, strings.Join(validDifferenceModes[1:], ", "))
+}
+
+// validFits are the values allowed for Fit
+var validFits = []string{"", FitAll, FitData, FitHighlight}
+
+// validateFit checks that the fit is one of the supported values, and that there are highlighted regions to fit the map to
+func validateFit(r *RenderRequest, errs *ValidationErrors) {
+	for _, f := range validFits {
+		if r.Fit == f {
+			if f == FitHighlight && r.Highlight == nil {
+				errs.missing("highlight")
+			}
+			return
+		}
+	}
+	errs.invalid("fit", "Unknown fit '%s'. Must be one of %v", r.Fit, strings.Join(validFits[1:], ", "))
+}
+
+// validConversionFailures are the values allowed for ConversionFailure
+var validConversionFailures = []string{"", ConversionFailureFallback, ConversionFailureFail}
+
+// validateConversionFailure checks that the conversion failure is one of the supported values
+func validateConversionFailure(failure string, errs *ValidationErrors) {
+	for _, f := range validConversionFailures {
+		if failure == f {
+			return
+		}
+	}
+	errs.invalid("conversion_failure", "Unknown conversion failure '%s'. Must be one of %v", failure, strings.Join(validConversionFailures[1:], ", "))
+}
+
+// validFallbackStructures are the values allowed for FallbackStructure
+var validFallbackStructures = []string{"", FallbackStructureSwitch, FallbackStructurePicture}
+
+// validateFallbackStructure checks that the fallback structure is one of the supported values
+func validateFallbackStructure(structure string, errs *ValidationErrors) {
+	for _, s := range validFallbackStructures {
+		if structure == s {
+			return
+		}
+	}
+	errs.invalid("fallback_structure", "Unknown fallback structure '%s'. Must be one of %v", structure, strings.Join(validFallbackStructures[1:], ", "))
+}
+
+// validPNGOutputs are the values allowed for PNGOutput
+var validPNGOutputs = []string{"", PNGOutputHTML, PNGOutputMap, PNGOutputMapAndLegend}
+
+// validatePNGOutput checks that the png output is one of the supported values
+func validatePNGOutput(output string, errs *ValidationErrors) {
+	for _, o := range validPNGOutputs {
+		if output == o {
+			return
+		}
+	}
+	errs.invalid("png_output", "Unknown png output '%s'. Must be one of %v", output, strings.Join(validPNGOutputs[1:], ", "))
+}
+
+// validateDataset checks that the request's dataset (if it has one) identifies a version of a dataset, and that the request has no other data
+func validateDataset(r *RenderRequest, errs *ValidationErrors) {
+	d := r.Dataset
+	if d == nil {
+		return
+	}
+	if len(d.ID) == 0 {
+		errs.missing("dataset.id")
+	}
+	if len(d.Edition) == 0 {
+		errs.missing("dataset.edition")
+	}
+	if len(d.Version) == 0 {
+		errs.missing("dataset.version")
+	}
+	if _, exists := d.Options[d.GeographyDimension()]; exists {
+		errs.invalid("dataset.options", "The geography dimension '%s' must not have an option", d.GeographyDimension())
+	}
+	if r.Version == RequestVersion2 {
+		errs.invalid("dataset", "A dataset is not supported in version 2 requests")
+	}
+}
+
+// validOfficePresets are the values allowed for OfficePreset
+var validOfficePresets = []string{"", OfficePresetWidescreen, OfficePresetA4Landscape, OfficePresetA4Portrait}
+
+var validBoundInclusions = []string{"", BoundInclusionLower, BoundInclusionUpper}
+
+// validateBoundInclusion checks that the bound inclusion is lower or upper
+func validateBoundInclusion(inclusion string, errs *ValidationErrors) {
+	for _, b := range validBoundInclusions {
+		if inclusion == b {
+			return
+		}
+	}
+	errs.invalid("choropleth.bound_inclusion", "Unknown bound inclusion '%s'. Must be one of %v", inclusion, strings.Join(validBoundInclusions[1:], ", "))
+}
+
+var validSwatchShapes = []string{"", SwatchShapeBar, SwatchShapeSquare, SwatchShapeCircle}
+
+// maxSwatchSize is the largest swatch size allowed, to keep the legends a sensible size
+const maxSwatchSize = 40
+
+// validateSwatch checks that the swatch shape is bar, square or circle, and the size is between 0 and maxSwatchSize
+func validateSwatch(c *Choropleth, errs *ValidationErrors) {
+	if c.SwatchSize < 0 || c.SwatchSize > maxSwatchSize {
+		errs.invalid("choropleth.swatch_size", "Must be between 0 and %d", maxSwatchSize)
+	}
+	for _, s := range validSwatchShapes {
+		if c.SwatchShape == s {
+			return
+		}
+	}
+	errs.invalid("choropleth.swatch_shape", "Unknown swatch shape '%s'. Must be one of %v", c.SwatchShape, strings.Join(validSwatchShapes[1:], ", "))
+}
+
+// maxDecimalPlaces is the largest number of decimal places values may be formatted or rounded to
+const maxDecimalPlaces = 10
+
+// validateDecimalPlaces checks that the number of decimal places (if given) is between 0 and maxDecimalPlaces
+func validateDecimalPlaces(field string, decimalPlaces *int, errs *ValidationErrors) {
+	if decimalPlaces != nil && (*decimalPlaces < 0 || *decimalPlaces > maxDecimalPlaces) {
+		errs.invalid(field, "Must be between 0 and %d", maxDecimalPlaces)
+	}
+}
+
+// maxHistogramBins is the largest number of bins in the histogram of an analyse response, to keep the response a sensible size
+const maxHistogramBins = 100
+
+// validateHistogramBins checks that the number of histogram bins is between 0 (the default) and maxHistogramBins
+func validateHistogramBins(bins int, errs *ValidationErrors) {
+	if bins < 0 || bins > maxHistogramBins {
+		errs.invalid("histogram_bins", "Must be between 0 and %d", maxHistogramBins)
+	}
+}
+
+var validKeyScales = []string{"", KeyScaleLinear, KeyScaleLog}
+
+// validateKeyScale checks that the key scale is linear or log
+func validateKeyScale(scale string, errs *ValidationErrors) {
+	for _, s := range validKeyScales {
+		if scale == s {
+			return
+		}
+	}
+	errs.invalid("choropleth.key_scale", "Unknown key scale '%s'. Must be one of %v", scale, strings.Join(validKeyScales[1:], ", "))
+}
+
+var validClassifications = []string{"", ClassificationNatural, ClassificationLog, ClassificationStdDev, ClassificationHeadTail}
+
+// validateClassification checks that the classification of an analyse request is one of the supported classifications
+func validateClassification(classification string, errs *ValidationErrors) {
+	for _, c := range validClassifications {
+		if classification == c {
+			return
+		}
+	}
+	errs.invalid("classification", "Unknown classification '%s'. Must be one of %v", classification, strings.Join(validClassifications[1:], ", "))
+}
+
+var validPatterns = []string{"", PatternHatching, PatternDots, PatternCrossHatch}
+
+// validatePattern checks that the pattern is one of the predefined patterns or custom svg content
+func validatePattern(field string, pattern string, errs *ValidationErrors) {
+	if strings.HasPrefix(strings.TrimSpace(pattern), "<") {
+		return
+	}
+	for _, p := range validPatterns {
+		if pattern == p {
+			return
+		}
+	}
+	errs.invalid(field, "Unknown pattern '%s'. Must be one of %v, or svg content", pattern, strings.Join(validPatterns[1:], ", "))
+}
+
+// validateOfficePreset checks that the office preset is one of the supported page sizes
+func validateOfficePreset(preset string, errs *ValidationErrors) {
+	for _, p := range validOfficePresets {
+		if preset == p {
+			return
+		}
+	}
+	errs.invalid("office_preset", "Unknown office preset '%s'. Must be one of %v", preset, strings.Join(validOfficePresets[1:], ", "))
+}
+
+// validateTile checks that the tile, if given, is in the form z/x/y with x and y within the grid at zoom level z
+func validateTile(tile string, errs *ValidationErrors) {
+	if len(tile) == 0 {
+		return
+	}
+	var z, x, y int
+	var rest string
+	if n, _ := fmt.Sscanf(tile+" ", "%d/%d/%d%s", &z, &x, &y, &rest); n != 3 || z < 0 || z > 30 || x < 0 || x >= 1<<uint(z) || y < 0 || y >= 1<<uint(z) {
+		errs.invalid("tile", "Invalid tile '%s'. Must be in the form z/x/y, with x and y less than 2^z", tile)
+	}
+}
+
+// validColour matches the characters allowed in a break colour - enough for hex, rgb(), hsl() and named colours,
+// but not the quotes, semicolons or angle brackets needed to break out of the style attribute the colour is written to
+var validColour = regexp.MustCompile(`^[#A-Za-z0-9(),.%\s-]*$`)
+
+// validDash matches a stroke-dasharray - a list of lengths separated by commas and/or whitespace
+var validDash = regexp.MustCompile(`^[0-9.,\s]*$`)
+
+var validReferenceLabelPlacements = []string{"", ReferenceLabelBefore, ReferenceLabelAfter}
+
+// validateReferenceStyle checks the colour, dash pattern and label placement of the choropleth's reference tick
+func validateReferenceStyle(c *Choropleth, errs *ValidationErrors) {
+	if !validColour.MatchString(c.ReferenceColour) {
+		errs.invalid("choropleth.reference_colour", "Invalid colour '%s'", c.ReferenceColour)
+	}
+	if !validDash.MatchString(c.ReferenceDash) {
+		errs.invalid("choropleth.reference_dash", "Invalid dash pattern '%s'. Must be a list of lengths, e.g. '4 2'", c.ReferenceDash)
+	}
+	for _, p := range validReferenceLabelPlacements {
+		if c.ReferenceLabelPlacement == p {
+			return
+		}
+	}
+	errs.invalid("choropleth.reference_label_placement", "Unknown reference label placement '%s'. Must be one of %v", c.ReferenceLabelPlacement, strings.Join(validReferenceLabelPlacements[1:], ", "))
+}
+
+// validLinkSchemes are the schemes allowed in links. Links without a scheme are relative.
+var validLinkSchemes = []string{"", "http", "https", "mailto"}
+
+// validateLink checks that the link is a url with an allowed scheme, so that it can't run script (e.g. javascript:) when clicked
+func validateLink(field string, link string, errs *ValidationErrors) {
+	u, err := url.Parse(link)
+	if err != nil {
+		errs.invalid(field, "Invalid url '%s'", link)
+		return
+	}
+	for _, s := range validLinkSchemes {
+		if strings.ToLower(u.Scheme) == s {
+			return
+		}
+	}
+	errs.invalid(field, "Unsupported url scheme '%s'. Must be one of %v", u.Scheme, strings.Join(validLinkSchemes[1:], ", "))
+}
+
+var (
+	validLinkTarget   = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+	validLinkRel      = regexp.MustCompile(`^[A-Za-z0-9 -]*$`)
+	validDataAttrName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+var validAggregates = []string{"", AggregateSum, AggregateMean}
+
+// validateDissolve checks that the dissolve has a property and a known aggregate function
+func validateDissolve(d *Dissolve, errs *ValidationErrors) {
+	if len(d.Property) == 0 {
+		errs.missing("geography.dissolve.property")
+	}
+	for _, a := range validAggregates {
+		if d.Aggregate == a {
+			return
+		}
+	}
+	errs.invalid("geography.dissolve.aggregate", "Unknown aggregate '%s'. Must be one of %v", d.Aggregate, strings.Join(validAggregates[1:], ", "))
+}
+
+// validateObjectName checks that the topology has an object with the geography's object name, if given
+func validateObjectName(g *Geography, errs *ValidationErrors) {
+	if len(g.ObjectName) == 0 {
+		return
+	}
+	if o, exists := g.Topojson.Objects[g.ObjectName]; exists && o != nil {
+		return
+	}
+	names := make([]string, 0, len(g.Topojson.Objects))
+	for name := range g.Topojson.Objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs.invalid("geography.object_name", "Unknown object '%s'. The topology has objects %s", g.ObjectName, strings.Join(names, ", "))
+}
+
+// validateIDAliases checks that no data id is aliased to an empty region id
+func validateIDAliases(aliases map[string]string, errs *ValidationErrors) {
+	ids := make([]string, 0, len(aliases))
+	for id := range aliases {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if len(aliases[id]) == 0 {
+			errs.missing(fmt.Sprintf("geography.id_aliases[%s]", id))
+		}
+	}
+}
+
+// validateFeatureFilter checks that the filter has ids or a property, and that a property is given with values to compare it with
+func validateFeatureFilter(field string, filter *FeatureFilter, errs *ValidationErrors) {
+	if len(filter.IDs) == 0 && len(filter.Property) == 0 {
+		errs.invalid(field, "Must have ids or a property")
+	}
+	if len(filter.Property) > 0 && len(filter.Values) == 0 {
+		errs.missing(field + ".values")
+	}
+	if len(filter.Values) > 0 && len(filter.Property) == 0 {
+		errs.missing(field + ".property")
+	}
+}
+
+// validateHighlight checks that the highlight has at least one region, and a valid colour
+func validateHighlight(h *Highlight, errs *ValidationErrors) {
+	if len(h.IDs) == 0 {
+		errs.missing("highlight.ids")
+	}
+	for i, id := range h.IDs {
+		if len(id) == 0 {
+			errs.missing(fmt.Sprintf("highlight.ids[%d]", i))
+		}
+	}
+	if !validColour.MatchString(h.Colour) {
+		errs.invalid("highlight.colour", "Invalid colour '%s'", h.Colour)
+	}
+}
+
+// validClassName matches a single css class name that can be used unescaped in a selector
+var validClassName = regexp.MustCompile(`^-?[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// validateClassName checks that the class name, if given, is a single css class name
+func validateClassName(field string, className string, errs *ValidationErrors) {
+	if len(className) > 0 && !validClassName.MatchString(className) {
+		errs.invalid(field, "Invalid class name '%s'. Must be a single class name of letters, digits, hyphens and underscores, not starting with a digit", className)
+	}
+}
+
+// validateLinkAttributes checks that the target is a browsing context name or keyword, the rel a list of link types,
+// and the names of the data attributes are valid attribute names (in lower case, without the data- prefix)
+func validateLinkAttributes(field string, attributes *LinkAttributes, errs *ValidationErrors) {
+	if !validLinkTarget.MatchString(attributes.Target) {
+		errs.invalid(field+".target", "Invalid target '%s'", attributes.Target)
+	}
+	if !validLinkRel.MatchString(attributes.Rel) {
+		errs.invalid(field+".rel", "Invalid rel '%s'", attributes.Rel)
+	}
+	for name := range attributes.Data {
+		if !validDataAttrName.MatchString(name) {
+			errs.invalid(field+".data", "Invalid data attribute name '%s'. Must contain only lower case letters, digits and hyphens", name)
+		}
+	}
+}
+
+// validateChoropleth checks that the choropleth has breaks with valid colours and patterns, and monotonic (ascending or descending) lower bounds, an upper bound greater than all lower bounds, and known legend positions
+func validateChoropleth(c *Choropleth, errs *ValidationErrors) {
+	if len(c.Breaks) == 0 {
+		errs.invalid("choropleth.breaks", "At least one break is required")
+	}
+
+	ascending := len(c.Breaks) < 2 || c.Breaks[1].LowerBound > c.Breaks[0].LowerBound
+	max := 0.0
+	for i, b := range c.Breaks {
+		if !validColour.MatchString(b.Colour) {
+			errs.invalid(fmt.Sprintf("choropleth.breaks[%d].colour", i), "Invalid colour '%s'", b.Colour)
+		}
+		validatePattern(fmt.Sprintf("choropleth.breaks[%d].pattern", i), b.Pattern, errs)
+		if i == 0 || b.LowerBound > max {
+			max = b.LowerBound
+		}
+		if i == 0 {
+			continue
+		}
+		previous := c.Breaks[i-1].LowerBound
+		if (ascending && b.LowerBound <= previous) || (!ascending && b.LowerBound >= previous) {
+			errs.invalid(fmt.Sprintf("choropleth.breaks[%d].lower_bound", i), "Lower bounds must be in strictly ascending or descending order: %v follows %v", b.LowerBound, previous)
+		}
+	}
+
+	if c.UpperBound != 0 && len(c.Breaks) > 0 && c.UpperBound <= max {
+		errs.invalid("choropleth.upper_bound", "Must be greater than the lower bound of every break (%v)", max)
+	}
+
+	validateLegendPosition("choropleth.horizontal_legend_position", c.HorizontalLegendPosition, errs)
+	validateLegendPosition("choropleth.vertical_legend_position", c.VerticalLegendPosition, errs)
+	validateBoundInclusion(c.BoundInclusion, errs)
+	validateReferenceStyle(c, errs)
+	validateSwatch(c, errs)
+	validateKeyScale(c.KeyScale, errs)
+	validateDecimalPlaces("choropleth.decimal_places", c.DecimalPlaces, errs)
+}
+
+func validateLegendPosition(field string, position string, errs *ValidationErrors) {
+	for _, p := range validLegendPositions {
+		if position == p {
+			return
+		}
+	}
+	errs.invalid(field, "Unknown legend position '%s'. Must be one of %v", position, strings.Join(validLegendPositions[1:], ", "))
+}
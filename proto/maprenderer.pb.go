@@ -0,0 +1,1996 @@
+// Protocol buffer equivalents of the json models (see models/models.go) and the MapRenderer gRPC service,
+// offering the render and analyse operations to internal services.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: maprenderer.proto
+
+package maprenderer
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// RenderMapRequest is a RenderRequest plus the format it should be rendered in
+type RenderMapRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the output format - text/html (the default), or any of the other content types that /render can return for an Accept header, e.g. image/svg+xml or image/png
+	ContentType   string         `protobuf:"bytes,1,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Request       *RenderRequest `protobuf:"bytes,2,opt,name=request,proto3" json:"request,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderMapRequest) Reset() {
+	*x = RenderMapRequest{}
+	mi := &file_maprenderer_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderMapRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderMapRequest) ProtoMessage() {}
+
+func (x *RenderMapRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderMapRequest.ProtoReflect.Descriptor instead.
+func (*RenderMapRequest) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RenderMapRequest) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *RenderMapRequest) GetRequest() *RenderRequest {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+// RenderResponseChunk is part of a rendered map. The content type is only populated in the first chunk
+type RenderResponseChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ContentType   string                 `protobuf:"bytes,1,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderResponseChunk) Reset() {
+	*x = RenderResponseChunk{}
+	mi := &file_maprenderer_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderResponseChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderResponseChunk) ProtoMessage() {}
+
+func (x *RenderResponseChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderResponseChunk.ProtoReflect.Descriptor instead.
+func (*RenderResponseChunk) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RenderResponseChunk) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *RenderResponseChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// RenderRequest represents a structure for a map render job.
+// It may also be posted to the render endpoints of the http api with a Content-Type of application/x-protobuf
+type RenderRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// 1 (the default) or 2
+	Version    int32      `protobuf:"varint,17,opt,name=version,proto3" json:"version,omitempty"`
+	Title      string     `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Subtitle   string     `protobuf:"bytes,2,opt,name=subtitle,proto3" json:"subtitle,omitempty"`
+	Source     string     `protobuf:"bytes,3,opt,name=source,proto3" json:"source,omitempty"`
+	SourceLink string     `protobuf:"bytes,4,opt,name=source_link,json=sourceLink,proto3" json:"source_link,omitempty"`
+	Licence    string     `protobuf:"bytes,5,opt,name=licence,proto3" json:"licence,omitempty"`
+	Filename   string     `protobuf:"bytes,6,opt,name=filename,proto3" json:"filename,omitempty"`
+	Footnotes  []string   `protobuf:"bytes,7,rep,name=footnotes,proto3" json:"footnotes,omitempty"`
+	MapType    string     `protobuf:"bytes,8,opt,name=map_type,json=mapType,proto3" json:"map_type,omitempty"`
+	Geography  *Geography `protobuf:"bytes,9,opt,name=geography,proto3" json:"geography,omitempty"`
+	// ids in data should match values of id_property in geography. Version 1 only
+	Data []*DataRow `protobuf:"bytes,10,rep,name=data,proto3" json:"data,omitempty"`
+	// version 2 only
+	Series             []*DataSeries `protobuf:"bytes,18,rep,name=series,proto3" json:"series,omitempty"`
+	Choropleth         *Choropleth   `protobuf:"bytes,11,opt,name=choropleth,proto3" json:"choropleth,omitempty"`
+	Width              float64       `protobuf:"fixed64,12,opt,name=width,proto3" json:"width,omitempty"`
+	MinWidth           float64       `protobuf:"fixed64,13,opt,name=min_width,json=minWidth,proto3" json:"min_width,omitempty"`
+	MaxWidth           float64       `protobuf:"fixed64,14,opt,name=max_width,json=maxWidth,proto3" json:"max_width,omitempty"`
+	IncludeFallbackPng bool          `protobuf:"varint,15,opt,name=include_fallback_png,json=includeFallbackPng,proto3" json:"include_fallback_png,omitempty"`
+	// png (the default), webp, avif or jpeg
+	FallbackImageFormat string `protobuf:"bytes,19,opt,name=fallback_image_format,json=fallbackImageFormat,proto3" json:"fallback_image_format,omitempty"`
+	FontSize            int32  `protobuf:"varint,16,opt,name=font_size,json=fontSize,proto3" json:"font_size,omitempty"`
+	// side-by-side (the default), toggle or slider. Used by the comparison render type
+	ComparisonMode string `protobuf:"bytes,20,opt,name=comparison_mode,json=comparisonMode,proto3" json:"comparison_mode,omitempty"`
+	// absolute (the default) or percentage. Used by the difference render type
+	DifferenceMode string `protobuf:"bytes,21,opt,name=difference_mode,json=differenceMode,proto3" json:"difference_mode,omitempty"`
+	// if true, html output includes styles scoped to the map for region hover/focus highlighting and legend layout
+	IncludeStyles bool `protobuf:"varint,22,opt,name=include_styles,json=includeStyles,proto3" json:"include_styles,omitempty"`
+	// z/x/y of the vector tile rendered by the mvt render type, or of the root of the mvt-pyramid
+	Tile string `protobuf:"bytes,23,opt,name=tile,proto3" json:"tile,omitempty"`
+	// the size of each pixel, in degrees, of the geotiff render type
+	Resolution float64 `protobuf:"fixed64,24,opt,name=resolution,proto3" json:"resolution,omitempty"`
+	// 16:9 (the default), a4-landscape or a4-portrait. The page size of the image rendered by the office render type
+	OfficePreset string `protobuf:"bytes,25,opt,name=office_preset,json=officePreset,proto3" json:"office_preset,omitempty"`
+	// if true, svg output is minified
+	Minify        bool   `protobuf:"varint,26,opt,name=minify,proto3" json:"minify,omitempty"`
+	Deterministic bool   `protobuf:"varint,27,opt,name=deterministic,proto3" json:"deterministic,omitempty"`
+	IdPrefix      string `protobuf:"bytes,28,opt,name=id_prefix,json=idPrefix,proto3" json:"id_prefix,omitempty"`
+	Greyscale     bool   `protobuf:"varint,29,opt,name=greyscale,proto3" json:"greyscale,omitempty"`
+	// 1 to 6 - the title in the html figcaption is a heading of this level
+	CaptionHeadingLevel int32 `protobuf:"varint,30,opt,name=caption_heading_level,json=captionHeadingLevel,proto3" json:"caption_heading_level,omitempty"`
+	// classes added to the html figcaption, separated by spaces
+	CaptionClass string `protobuf:"bytes,31,opt,name=caption_class,json=captionClass,proto3" json:"caption_class,omitempty"`
+	// if true, the svg and png render types draw the title and subtitle above the map
+	EmbedTitle bool `protobuf:"varint,32,opt,name=embed_title,json=embedTitle,proto3" json:"embed_title,omitempty"`
+	// the target, rel and data attributes of the source link in the html footer
+	SourceLinkAttributes *LinkAttributes `protobuf:"bytes,33,opt,name=source_link_attributes,json=sourceLinkAttributes,proto3" json:"source_link_attributes,omitempty"`
+	// additional sources, each with its own link, following source
+	Sources []*Source `protobuf:"bytes,34,rep,name=sources,proto3" json:"sources,omitempty"`
+	// additional licence lines, following licence
+	Licences []string `protobuf:"bytes,35,rep,name=licences,proto3" json:"licences,omitempty"`
+	// the class of every map region. Defaults to mapRegion
+	RegionClass string `protobuf:"bytes,36,opt,name=region_class,json=regionClass,proto3" json:"region_class,omitempty"`
+	// regions drawn with a distinct outline above the other regions
+	Highlight *Highlight `protobuf:"bytes,37,opt,name=highlight,proto3" json:"highlight,omitempty"`
+	// all (the default), data or highlight - the regions the map is framed to
+	Fit string `protobuf:"bytes,38,opt,name=fit,proto3" json:"fit,omitempty"`
+	// fallback (the default) or fail - whether a failure to convert the svg to an image renders the svg alone or fails the request
+	ConversionFailure string `protobuf:"bytes,39,opt,name=conversion_failure,json=conversionFailure,proto3" json:"conversion_failure,omitempty"`
+	// transparent (the default) or a colour filling the background of raster images
+	Background string `protobuf:"bytes,40,opt,name=background,proto3" json:"background,omitempty"`
+	// if true, the fallback image has a srcset with an image of twice the size, for high-DPI screens
+	HighDpiFallback bool `protobuf:"varint,41,opt,name=high_dpi_fallback,json=highDpiFallback,proto3" json:"high_dpi_fallback,omitempty"`
+	// switch (the default) or picture - whether the fallback image is in a foreignObject of the svg or the svg and images are the sources of a picture element
+	FallbackStructure string `protobuf:"bytes,42,opt,name=fallback_structure,json=fallbackStructure,proto3" json:"fallback_structure,omitempty"`
+	// html (the default), map or map-and-legend - whether the png render type returns an html figure or the png image itself
+	PngOutput string `protobuf:"bytes,43,opt,name=png_output,json=pngOutput,proto3" json:"png_output,omitempty"`
+	// observations in the ONS dataset API, fetched by the service as the data of the request
+	Dataset       *Dataset `protobuf:"bytes,44,opt,name=dataset,proto3" json:"dataset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderRequest) Reset() {
+	*x = RenderRequest{}
+	mi := &file_maprenderer_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderRequest) ProtoMessage() {}
+
+func (x *RenderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderRequest.ProtoReflect.Descriptor instead.
+func (*RenderRequest) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RenderRequest) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *RenderRequest) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetSubtitle() string {
+	if x != nil {
+		return x.Subtitle
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetSourceLink() string {
+	if x != nil {
+		return x.SourceLink
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetLicence() string {
+	if x != nil {
+		return x.Licence
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetFootnotes() []string {
+	if x != nil {
+		return x.Footnotes
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetMapType() string {
+	if x != nil {
+		return x.MapType
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetGeography() *Geography {
+	if x != nil {
+		return x.Geography
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetData() []*DataRow {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetSeries() []*DataSeries {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetChoropleth() *Choropleth {
+	if x != nil {
+		return x.Choropleth
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetWidth() float64 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *RenderRequest) GetMinWidth() float64 {
+	if x != nil {
+		return x.MinWidth
+	}
+	return 0
+}
+
+func (x *RenderRequest) GetMaxWidth() float64 {
+	if x != nil {
+		return x.MaxWidth
+	}
+	return 0
+}
+
+func (x *RenderRequest) GetIncludeFallbackPng() bool {
+	if x != nil {
+		return x.IncludeFallbackPng
+	}
+	return false
+}
+
+func (x *RenderRequest) GetFallbackImageFormat() string {
+	if x != nil {
+		return x.FallbackImageFormat
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetFontSize() int32 {
+	if x != nil {
+		return x.FontSize
+	}
+	return 0
+}
+
+func (x *RenderRequest) GetComparisonMode() string {
+	if x != nil {
+		return x.ComparisonMode
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetDifferenceMode() string {
+	if x != nil {
+		return x.DifferenceMode
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetIncludeStyles() bool {
+	if x != nil {
+		return x.IncludeStyles
+	}
+	return false
+}
+
+func (x *RenderRequest) GetTile() string {
+	if x != nil {
+		return x.Tile
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetResolution() float64 {
+	if x != nil {
+		return x.Resolution
+	}
+	return 0
+}
+
+func (x *RenderRequest) GetOfficePreset() string {
+	if x != nil {
+		return x.OfficePreset
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetMinify() bool {
+	if x != nil {
+		return x.Minify
+	}
+	return false
+}
+
+func (x *RenderRequest) GetDeterministic() bool {
+	if x != nil {
+		return x.Deterministic
+	}
+	return false
+}
+
+func (x *RenderRequest) GetIdPrefix() string {
+	if x != nil {
+		return x.IdPrefix
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetGreyscale() bool {
+	if x != nil {
+		return x.Greyscale
+	}
+	return false
+}
+
+func (x *RenderRequest) GetCaptionHeadingLevel() int32 {
+	if x != nil {
+		return x.CaptionHeadingLevel
+	}
+	return 0
+}
+
+func (x *RenderRequest) GetCaptionClass() string {
+	if x != nil {
+		return x.CaptionClass
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetEmbedTitle() bool {
+	if x != nil {
+		return x.EmbedTitle
+	}
+	return false
+}
+
+func (x *RenderRequest) GetSourceLinkAttributes() *LinkAttributes {
+	if x != nil {
+		return x.SourceLinkAttributes
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetSources() []*Source {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetLicences() []string {
+	if x != nil {
+		return x.Licences
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetRegionClass() string {
+	if x != nil {
+		return x.RegionClass
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetHighlight() *Highlight {
+	if x != nil {
+		return x.Highlight
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetFit() string {
+	if x != nil {
+		return x.Fit
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetConversionFailure() string {
+	if x != nil {
+		return x.ConversionFailure
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetBackground() string {
+	if x != nil {
+		return x.Background
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetHighDpiFallback() bool {
+	if x != nil {
+		return x.HighDpiFallback
+	}
+	return false
+}
+
+func (x *RenderRequest) GetFallbackStructure() string {
+	if x != nil {
+		return x.FallbackStructure
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetPngOutput() string {
+	if x != nil {
+		return x.PngOutput
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetDataset() *Dataset {
+	if x != nil {
+		return x.Dataset
+	}
+	return nil
+}
+
+// Dataset identifies observations in the ONS dataset API - one for each option of the geography dimension, with the given option of each other dimension
+type Dataset struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Id      string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Edition string                 `protobuf:"bytes,2,opt,name=edition,proto3" json:"edition,omitempty"`
+	Version string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	// the geography dimension, whose option codes are joined to the regions. Defaults to geography
+	Dimension string `protobuf:"bytes,4,opt,name=dimension,proto3" json:"dimension,omitempty"`
+	// the option of each of the other dimensions of the dataset
+	Options       map[string]string `protobuf:"bytes,5,rep,name=options,proto3" json:"options,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Dataset) Reset() {
+	*x = Dataset{}
+	mi := &file_maprenderer_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Dataset) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Dataset) ProtoMessage() {}
+
+func (x *Dataset) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Dataset.ProtoReflect.Descriptor instead.
+func (*Dataset) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Dataset) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Dataset) GetEdition() string {
+	if x != nil {
+		return x.Edition
+	}
+	return ""
+}
+
+func (x *Dataset) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *Dataset) GetDimension() string {
+	if x != nil {
+		return x.Dimension
+	}
+	return ""
+}
+
+func (x *Dataset) GetOptions() map[string]string {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+// Highlight picks out regions of the map, drawn above the other regions with a distinct outline
+type Highlight struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the ids of the highlighted regions
+	Ids []string `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
+	// the colour of the outline. Defaults to black
+	Colour string `protobuf:"bytes,2,opt,name=colour,proto3" json:"colour,omitempty"`
+	// if true, each highlighted region is labelled with its name
+	Label         bool `protobuf:"varint,3,opt,name=label,proto3" json:"label,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Highlight) Reset() {
+	*x = Highlight{}
+	mi := &file_maprenderer_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Highlight) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Highlight) ProtoMessage() {}
+
+func (x *Highlight) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Highlight.ProtoReflect.Descriptor instead.
+func (*Highlight) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Highlight) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+func (x *Highlight) GetColour() string {
+	if x != nil {
+		return x.Colour
+	}
+	return ""
+}
+
+func (x *Highlight) GetLabel() bool {
+	if x != nil {
+		return x.Label
+	}
+	return false
+}
+
+// Source is one of the sources of the data in the map, with an optional link
+type Source struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Text          string                 `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	Link          string                 `protobuf:"bytes,2,opt,name=link,proto3" json:"link,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Source) Reset() {
+	*x = Source{}
+	mi := &file_maprenderer_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Source) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Source) ProtoMessage() {}
+
+func (x *Source) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Source.ProtoReflect.Descriptor instead.
+func (*Source) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Source) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *Source) GetLink() string {
+	if x != nil {
+		return x.Link
+	}
+	return ""
+}
+
+// LinkAttributes are additional attributes of a link, e.g. to open an external link in a new tab
+type LinkAttributes struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Target string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	// defaults to "noopener noreferrer" if the target is _blank
+	Rel string `protobuf:"bytes,2,opt,name=rel,proto3" json:"rel,omitempty"`
+	// data attributes, with names given without the data- prefix
+	Data          map[string]string `protobuf:"bytes,3,rep,name=data,proto3" json:"data,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LinkAttributes) Reset() {
+	*x = LinkAttributes{}
+	mi := &file_maprenderer_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LinkAttributes) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LinkAttributes) ProtoMessage() {}
+
+func (x *LinkAttributes) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LinkAttributes.ProtoReflect.Descriptor instead.
+func (*LinkAttributes) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *LinkAttributes) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *LinkAttributes) GetRel() string {
+	if x != nil {
+		return x.Rel
+	}
+	return ""
+}
+
+func (x *LinkAttributes) GetData() map[string]string {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// Geography holds the topojson topology and supporting information
+type Geography struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the json-encoded topojson topology
+	Topojson     []byte   `protobuf:"bytes,1,opt,name=topojson,proto3" json:"topojson,omitempty"`
+	IdProperty   []string `protobuf:"bytes,2,rep,name=id_property,json=idProperty,proto3" json:"id_property,omitempty"`
+	NameProperty string   `protobuf:"bytes,3,opt,name=name_property,json=nameProperty,proto3" json:"name_property,omitempty"`
+	// a property whose value is added to the class of each region
+	ClassProperty string `protobuf:"bytes,4,opt,name=class_property,json=classProperty,proto3" json:"class_property,omitempty"`
+	// if given, only the regions matching the filter are rendered
+	Include *FeatureFilter `protobuf:"bytes,5,opt,name=include,proto3" json:"include,omitempty"`
+	// regions matching the filter are not rendered
+	Exclude *FeatureFilter `protobuf:"bytes,6,opt,name=exclude,proto3" json:"exclude,omitempty"`
+	// if given, regions with the same value of a property are merged into one region
+	Dissolve *Dissolve `protobuf:"bytes,7,opt,name=dissolve,proto3" json:"dissolve,omitempty"`
+	// the name of the topology object to render. Defaults to all objects
+	ObjectName string `protobuf:"bytes,8,opt,name=object_name,json=objectName,proto3" json:"object_name,omitempty"`
+	// if true, data rows are joined to regions ignoring case and surrounding whitespace
+	NormaliseIds bool `protobuf:"varint,9,opt,name=normalise_ids,json=normaliseIds,proto3" json:"normalise_ids,omitempty"`
+	// data row ids mapped to the ids of the regions they're joined to
+	IdAliases map[string]string `protobuf:"bytes,10,rep,name=id_aliases,json=idAliases,proto3" json:"id_aliases,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// the url of the topology, fetched by the service if topojson is empty
+	TopojsonUrl   string `protobuf:"bytes,11,opt,name=topojson_url,json=topojsonUrl,proto3" json:"topojson_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Geography) Reset() {
+	*x = Geography{}
+	mi := &file_maprenderer_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Geography) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Geography) ProtoMessage() {}
+
+func (x *Geography) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Geography.ProtoReflect.Descriptor instead.
+func (*Geography) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Geography) GetTopojson() []byte {
+	if x != nil {
+		return x.Topojson
+	}
+	return nil
+}
+
+func (x *Geography) GetIdProperty() []string {
+	if x != nil {
+		return x.IdProperty
+	}
+	return nil
+}
+
+func (x *Geography) GetNameProperty() string {
+	if x != nil {
+		return x.NameProperty
+	}
+	return ""
+}
+
+func (x *Geography) GetClassProperty() string {
+	if x != nil {
+		return x.ClassProperty
+	}
+	return ""
+}
+
+func (x *Geography) GetInclude() *FeatureFilter {
+	if x != nil {
+		return x.Include
+	}
+	return nil
+}
+
+func (x *Geography) GetExclude() *FeatureFilter {
+	if x != nil {
+		return x.Exclude
+	}
+	return nil
+}
+
+func (x *Geography) GetDissolve() *Dissolve {
+	if x != nil {
+		return x.Dissolve
+	}
+	return nil
+}
+
+func (x *Geography) GetObjectName() string {
+	if x != nil {
+		return x.ObjectName
+	}
+	return ""
+}
+
+func (x *Geography) GetNormaliseIds() bool {
+	if x != nil {
+		return x.NormaliseIds
+	}
+	return false
+}
+
+func (x *Geography) GetIdAliases() map[string]string {
+	if x != nil {
+		return x.IdAliases
+	}
+	return nil
+}
+
+func (x *Geography) GetTopojsonUrl() string {
+	if x != nil {
+		return x.TopojsonUrl
+	}
+	return ""
+}
+
+// Dissolve merges the regions with the same value of the property into a single region
+type Dissolve struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Property string                 `protobuf:"bytes,1,opt,name=property,proto3" json:"property,omitempty"`
+	// sum (the default) or mean - how the values of the merged regions are combined
+	Aggregate     string `protobuf:"bytes,2,opt,name=aggregate,proto3" json:"aggregate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Dissolve) Reset() {
+	*x = Dissolve{}
+	mi := &file_maprenderer_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Dissolve) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Dissolve) ProtoMessage() {}
+
+func (x *Dissolve) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Dissolve.ProtoReflect.Descriptor instead.
+func (*Dissolve) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Dissolve) GetProperty() string {
+	if x != nil {
+		return x.Property
+	}
+	return ""
+}
+
+func (x *Dissolve) GetAggregate() string {
+	if x != nil {
+		return x.Aggregate
+	}
+	return ""
+}
+
+// FeatureFilter matches the regions with one of the ids, or with one of the values of the property
+type FeatureFilter struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ids           []string               `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
+	Property      string                 `protobuf:"bytes,2,opt,name=property,proto3" json:"property,omitempty"`
+	Values        []string               `protobuf:"bytes,3,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FeatureFilter) Reset() {
+	*x = FeatureFilter{}
+	mi := &file_maprenderer_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FeatureFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FeatureFilter) ProtoMessage() {}
+
+func (x *FeatureFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FeatureFilter.ProtoReflect.Descriptor instead.
+func (*FeatureFilter) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *FeatureFilter) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+func (x *FeatureFilter) GetProperty() string {
+	if x != nil {
+		return x.Property
+	}
+	return ""
+}
+
+func (x *FeatureFilter) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+// DataRow holds a single row of data
+type DataRow struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Id       string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Value    float64                `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	Category string                 `protobuf:"bytes,3,opt,name=category,proto3" json:"category,omitempty"`
+	// a non-numeric value, such as "c" for confidential. Must be one of the choropleth's suppressed_values
+	Marker        string `protobuf:"bytes,4,opt,name=marker,proto3" json:"marker,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DataRow) Reset() {
+	*x = DataRow{}
+	mi := &file_maprenderer_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DataRow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DataRow) ProtoMessage() {}
+
+func (x *DataRow) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DataRow.ProtoReflect.Descriptor instead.
+func (*DataRow) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *DataRow) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DataRow) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *DataRow) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *DataRow) GetMarker() string {
+	if x != nil {
+		return x.Marker
+	}
+	return ""
+}
+
+// DataSeries is a named series of data
+type DataSeries struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Title         string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Data          []*DataRow             `protobuf:"bytes,3,rep,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DataSeries) Reset() {
+	*x = DataSeries{}
+	mi := &file_maprenderer_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DataSeries) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DataSeries) ProtoMessage() {}
+
+func (x *DataSeries) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DataSeries.ProtoReflect.Descriptor instead.
+func (*DataSeries) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *DataSeries) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DataSeries) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *DataSeries) GetData() []*DataRow {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// Choropleth contains details required to create a choropleth map
+type Choropleth struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	ReferenceValue     float64                `protobuf:"fixed64,1,opt,name=reference_value,json=referenceValue,proto3" json:"reference_value,omitempty"`
+	ReferenceValueText string                 `protobuf:"bytes,2,opt,name=reference_value_text,json=referenceValueText,proto3" json:"reference_value_text,omitempty"`
+	ValuePrefix        string                 `protobuf:"bytes,3,opt,name=value_prefix,json=valuePrefix,proto3" json:"value_prefix,omitempty"`
+	ValueSuffix        string                 `protobuf:"bytes,4,opt,name=value_suffix,json=valueSuffix,proto3" json:"value_suffix,omitempty"`
+	Breaks             []*ChoroplethBreak     `protobuf:"bytes,5,rep,name=breaks,proto3" json:"breaks,omitempty"`
+	UpperBound         float64                `protobuf:"fixed64,6,opt,name=upper_bound,json=upperBound,proto3" json:"upper_bound,omitempty"`
+	// before, after, inside-top-left, inside-top-right, inside-bottom-left, inside-bottom-right or none (the default)
+	HorizontalLegendPosition string `protobuf:"bytes,7,opt,name=horizontal_legend_position,json=horizontalLegendPosition,proto3" json:"horizontal_legend_position,omitempty"`
+	VerticalLegendPosition   string `protobuf:"bytes,8,opt,name=vertical_legend_position,json=verticalLegendPosition,proto3" json:"vertical_legend_position,omitempty"`
+	MissingDataText          string `protobuf:"bytes,9,opt,name=missing_data_text,json=missingDataText,proto3" json:"missing_data_text,omitempty"`
+	HideMissingDataKey       bool   `protobuf:"varint,10,opt,name=hide_missing_data_key,json=hideMissingDataKey,proto3" json:"hide_missing_data_key,omitempty"`
+	// lower (the default) or upper - the bound of a break that includes values on the boundary
+	BoundInclusion string `protobuf:"bytes,11,opt,name=bound_inclusion,json=boundInclusion,proto3" json:"bound_inclusion,omitempty"`
+	// sentinel values (e.g. -999, or "c" for confidential) of regions whose data is suppressed
+	SuppressedValues []string `protobuf:"bytes,12,rep,name=suppressed_values,json=suppressedValues,proto3" json:"suppressed_values,omitempty"`
+	SuppressedText   string   `protobuf:"bytes,13,opt,name=suppressed_text,json=suppressedText,proto3" json:"suppressed_text,omitempty"`
+	// the colour (DimGrey by default) and stroke-dasharray (solid by default) of the reference tick
+	ReferenceColour string `protobuf:"bytes,14,opt,name=reference_colour,json=referenceColour,proto3" json:"reference_colour,omitempty"`
+	ReferenceDash   string `protobuf:"bytes,15,opt,name=reference_dash,json=referenceDash,proto3" json:"reference_dash,omitempty"`
+	// before or after - the side of the reference tick on which its text is drawn. Placed automatically by default
+	ReferenceLabelPlacement string `protobuf:"bytes,16,opt,name=reference_label_placement,json=referenceLabelPlacement,proto3" json:"reference_label_placement,omitempty"`
+	// further reference values, marked on the legends in the same way as reference_value
+	References []*Reference `protobuf:"bytes,17,rep,name=references,proto3" json:"references,omitempty"`
+	// if true, the legends are drawn with the highest class at the bottom of the vertical key and on the left of the horizontal key
+	ReverseLegend bool `protobuf:"varint,18,opt,name=reverse_legend,json=reverseLegend,proto3" json:"reverse_legend,omitempty"`
+	// bar (the default), square or circle - the shape of the colour of each break in the legends
+	SwatchShape string `protobuf:"bytes,19,opt,name=swatch_shape,json=swatchShape,proto3" json:"swatch_shape,omitempty"`
+	// the thickness of the bar, or the size of the squares or circles, in the legends. Defaults to 8
+	SwatchSize float64 `protobuf:"fixed64,20,opt,name=swatch_size,json=swatchSize,proto3" json:"swatch_size,omitempty"`
+	// linear (the default) or log - the scale of the legends
+	KeyScale string `protobuf:"bytes,21,opt,name=key_scale,json=keyScale,proto3" json:"key_scale,omitempty"`
+	// the number of decimal places of the values in region titles - values are shown in full if not set
+	DecimalPlaces *int32 `protobuf:"varint,22,opt,name=decimal_places,json=decimalPlaces,proto3,oneof" json:"decimal_places,omitempty"`
+	// the title of regions with data, e.g. "{name}: {value} {suffix} ({class_label})"
+	TooltipTemplate string `protobuf:"bytes,23,opt,name=tooltip_template,json=tooltipTemplate,proto3" json:"tooltip_template,omitempty"`
+	// the units of the values, e.g. "percentage points" - the title of the legends and part of the map's alt text
+	Units         string `protobuf:"bytes,24,opt,name=units,proto3" json:"units,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Choropleth) Reset() {
+	*x = Choropleth{}
+	mi := &file_maprenderer_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Choropleth) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Choropleth) ProtoMessage() {}
+
+func (x *Choropleth) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Choropleth.ProtoReflect.Descriptor instead.
+func (*Choropleth) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Choropleth) GetReferenceValue() float64 {
+	if x != nil {
+		return x.ReferenceValue
+	}
+	return 0
+}
+
+func (x *Choropleth) GetReferenceValueText() string {
+	if x != nil {
+		return x.ReferenceValueText
+	}
+	return ""
+}
+
+func (x *Choropleth) GetValuePrefix() string {
+	if x != nil {
+		return x.ValuePrefix
+	}
+	return ""
+}
+
+func (x *Choropleth) GetValueSuffix() string {
+	if x != nil {
+		return x.ValueSuffix
+	}
+	return ""
+}
+
+func (x *Choropleth) GetBreaks() []*ChoroplethBreak {
+	if x != nil {
+		return x.Breaks
+	}
+	return nil
+}
+
+func (x *Choropleth) GetUpperBound() float64 {
+	if x != nil {
+		return x.UpperBound
+	}
+	return 0
+}
+
+func (x *Choropleth) GetHorizontalLegendPosition() string {
+	if x != nil {
+		return x.HorizontalLegendPosition
+	}
+	return ""
+}
+
+func (x *Choropleth) GetVerticalLegendPosition() string {
+	if x != nil {
+		return x.VerticalLegendPosition
+	}
+	return ""
+}
+
+func (x *Choropleth) GetMissingDataText() string {
+	if x != nil {
+		return x.MissingDataText
+	}
+	return ""
+}
+
+func (x *Choropleth) GetHideMissingDataKey() bool {
+	if x != nil {
+		return x.HideMissingDataKey
+	}
+	return false
+}
+
+func (x *Choropleth) GetBoundInclusion() string {
+	if x != nil {
+		return x.BoundInclusion
+	}
+	return ""
+}
+
+func (x *Choropleth) GetSuppressedValues() []string {
+	if x != nil {
+		return x.SuppressedValues
+	}
+	return nil
+}
+
+func (x *Choropleth) GetSuppressedText() string {
+	if x != nil {
+		return x.SuppressedText
+	}
+	return ""
+}
+
+func (x *Choropleth) GetReferenceColour() string {
+	if x != nil {
+		return x.ReferenceColour
+	}
+	return ""
+}
+
+func (x *Choropleth) GetReferenceDash() string {
+	if x != nil {
+		return x.ReferenceDash
+	}
+	return ""
+}
+
+func (x *Choropleth) GetReferenceLabelPlacement() string {
+	if x != nil {
+		return x.ReferenceLabelPlacement
+	}
+	return ""
+}
+
+func (x *Choropleth) GetReferences() []*Reference {
+	if x != nil {
+		return x.References
+	}
+	return nil
+}
+
+func (x *Choropleth) GetReverseLegend() bool {
+	if x != nil {
+		return x.ReverseLegend
+	}
+	return false
+}
+
+func (x *Choropleth) GetSwatchShape() string {
+	if x != nil {
+		return x.SwatchShape
+	}
+	return ""
+}
+
+func (x *Choropleth) GetSwatchSize() float64 {
+	if x != nil {
+		return x.SwatchSize
+	}
+	return 0
+}
+
+func (x *Choropleth) GetKeyScale() string {
+	if x != nil {
+		return x.KeyScale
+	}
+	return ""
+}
+
+func (x *Choropleth) GetDecimalPlaces() int32 {
+	if x != nil && x.DecimalPlaces != nil {
+		return *x.DecimalPlaces
+	}
+	return 0
+}
+
+func (x *Choropleth) GetTooltipTemplate() string {
+	if x != nil {
+		return x.TooltipTemplate
+	}
+	return ""
+}
+
+func (x *Choropleth) GetUnits() string {
+	if x != nil {
+		return x.Units
+	}
+	return ""
+}
+
+// Reference is a value marked on the legends with a labelled tick, such as a national average
+type Reference struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Value         float64                `protobuf:"fixed64,1,opt,name=value,proto3" json:"value,omitempty"`
+	Text          string                 `protobuf:"bytes,2,opt,name=text,proto3" json:"text,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Reference) Reset() {
+	*x = Reference{}
+	mi := &file_maprenderer_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Reference) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Reference) ProtoMessage() {}
+
+func (x *Reference) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Reference.ProtoReflect.Descriptor instead.
+func (*Reference) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Reference) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *Reference) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+// ChoroplethBreak represents a single break - the point at which a colour changes
+type ChoroplethBreak struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	LowerBound    float64                `protobuf:"fixed64,1,opt,name=lower_bound,json=lowerBound,proto3" json:"lower_bound,omitempty"`
+	Color         string                 `protobuf:"bytes,2,opt,name=color,proto3" json:"color,omitempty"`
+	Pattern       string                 `protobuf:"bytes,3,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	Label         string                 `protobuf:"bytes,4,opt,name=label,proto3" json:"label,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChoroplethBreak) Reset() {
+	*x = ChoroplethBreak{}
+	mi := &file_maprenderer_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChoroplethBreak) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChoroplethBreak) ProtoMessage() {}
+
+func (x *ChoroplethBreak) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChoroplethBreak.ProtoReflect.Descriptor instead.
+func (*ChoroplethBreak) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ChoroplethBreak) GetLowerBound() float64 {
+	if x != nil {
+		return x.LowerBound
+	}
+	return 0
+}
+
+func (x *ChoroplethBreak) GetColor() string {
+	if x != nil {
+		return x.Color
+	}
+	return ""
+}
+
+func (x *ChoroplethBreak) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+func (x *ChoroplethBreak) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+// AnalyseRequest represents the structure of a request to analyse data and ensure it matches a topology
+type AnalyseRequest struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Geography    *Geography             `protobuf:"bytes,1,opt,name=geography,proto3" json:"geography,omitempty"`
+	Csv          string                 `protobuf:"bytes,2,opt,name=csv,proto3" json:"csv,omitempty"`
+	IdIndex      int32                  `protobuf:"varint,3,opt,name=id_index,json=idIndex,proto3" json:"id_index,omitempty"`
+	ValueIndex   int32                  `protobuf:"varint,4,opt,name=value_index,json=valueIndex,proto3" json:"value_index,omitempty"`
+	HasHeaderRow bool                   `protobuf:"varint,5,opt,name=has_header_row,json=hasHeaderRow,proto3" json:"has_header_row,omitempty"`
+	// optional - defaults to zero
+	ReferenceValue *float64 `protobuf:"fixed64,6,opt,name=reference_value,json=referenceValue,proto3,oneof" json:"reference_value,omitempty"`
+	HistogramBins  int32    `protobuf:"varint,7,opt,name=histogram_bins,json=histogramBins,proto3" json:"histogram_bins,omitempty"`
+	// natural (the default), log, stddev or headtail - how the suggested breaks are calculated
+	Classification string `protobuf:"bytes,8,opt,name=classification,proto3" json:"classification,omitempty"`
+	// the number of decimal places the min, max, mean and standard deviation are rounded to - not rounded if not set
+	DecimalPlaces *int32 `protobuf:"varint,9,opt,name=decimal_places,json=decimalPlaces,proto3,oneof" json:"decimal_places,omitempty"`
+	// the units of the values, returned in the response
+	Units         string `protobuf:"bytes,10,opt,name=units,proto3" json:"units,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalyseRequest) Reset() {
+	*x = AnalyseRequest{}
+	mi := &file_maprenderer_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyseRequest) ProtoMessage() {}
+
+func (x *AnalyseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyseRequest.ProtoReflect.Descriptor instead.
+func (*AnalyseRequest) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *AnalyseRequest) GetGeography() *Geography {
+	if x != nil {
+		return x.Geography
+	}
+	return nil
+}
+
+func (x *AnalyseRequest) GetCsv() string {
+	if x != nil {
+		return x.Csv
+	}
+	return ""
+}
+
+func (x *AnalyseRequest) GetIdIndex() int32 {
+	if x != nil {
+		return x.IdIndex
+	}
+	return 0
+}
+
+func (x *AnalyseRequest) GetValueIndex() int32 {
+	if x != nil {
+		return x.ValueIndex
+	}
+	return 0
+}
+
+func (x *AnalyseRequest) GetHasHeaderRow() bool {
+	if x != nil {
+		return x.HasHeaderRow
+	}
+	return false
+}
+
+func (x *AnalyseRequest) GetReferenceValue() float64 {
+	if x != nil && x.ReferenceValue != nil {
+		return *x.ReferenceValue
+	}
+	return 0
+}
+
+func (x *AnalyseRequest) GetHistogramBins() int32 {
+	if x != nil {
+		return x.HistogramBins
+	}
+	return 0
+}
+
+func (x *AnalyseRequest) GetClassification() string {
+	if x != nil {
+		return x.Classification
+	}
+	return ""
+}
+
+func (x *AnalyseRequest) GetDecimalPlaces() int32 {
+	if x != nil && x.DecimalPlaces != nil {
+		return *x.DecimalPlaces
+	}
+	return 0
+}
+
+func (x *AnalyseRequest) GetUnits() string {
+	if x != nil {
+		return x.Units
+	}
+	return ""
+}
+
+// AnalyseResponse is the json-encoded response of the /analyse endpoint (see AnalyseResponse in swagger.yaml)
+type AnalyseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Json          []byte                 `protobuf:"bytes,1,opt,name=json,proto3" json:"json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalyseResponse) Reset() {
+	*x = AnalyseResponse{}
+	mi := &file_maprenderer_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyseResponse) ProtoMessage() {}
+
+func (x *AnalyseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_maprenderer_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyseResponse.ProtoReflect.Descriptor instead.
+func (*AnalyseResponse) Descriptor() ([]byte, []int) {
+	return file_maprenderer_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *AnalyseResponse) GetJson() []byte {
+	if x != nil {
+		return x.Json
+	}
+	return nil
+}
+
+var File_maprenderer_proto protoreflect.FileDescriptor
+
+const file_maprenderer_proto_rawDesc = "" +
+	"\n" +
+	"\x11maprenderer.proto\x12\vmaprenderer\"k\n" +
+	"\x10RenderMapRequest\x12!\n" +
+	"\fcontent_type\x18\x01 \x01(\tR\vcontentType\x124\n" +
+	"\arequest\x18\x02 \x01(\v2\x1a.maprenderer.RenderRequestR\arequest\"L\n" +
+	"\x13RenderResponseChunk\x12!\n" +
+	"\fcontent_type\x18\x01 \x01(\tR\vcontentType\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"\xe7\f\n" +
+	"\rRenderRequest\x12\x18\n" +
+	"\aversion\x18\x11 \x01(\x05R\aversion\x12\x14\n" +
+	"\x05title\x18\x01 \x01(\tR\x05title\x12\x1a\n" +
+	"\bsubtitle\x18\x02 \x01(\tR\bsubtitle\x12\x16\n" +
+	"\x06source\x18\x03 \x01(\tR\x06source\x12\x1f\n" +
+	"\vsource_link\x18\x04 \x01(\tR\n" +
+	"sourceLink\x12\x18\n" +
+	"\alicence\x18\x05 \x01(\tR\alicence\x12\x1a\n" +
+	"\bfilename\x18\x06 \x01(\tR\bfilename\x12\x1c\n" +
+	"\tfootnotes\x18\a \x03(\tR\tfootnotes\x12\x19\n" +
+	"\bmap_type\x18\b \x01(\tR\amapType\x124\n" +
+	"\tgeography\x18\t \x01(\v2\x16.maprenderer.GeographyR\tgeography\x12(\n" +
+	"\x04data\x18\n" +
+	" \x03(\v2\x14.maprenderer.DataRowR\x04data\x12/\n" +
+	"\x06series\x18\x12 \x03(\v2\x17.maprenderer.DataSeriesR\x06series\x127\n" +
+	"\n" +
+	"choropleth\x18\v \x01(\v2\x17.maprenderer.ChoroplethR\n" +
+	"choropleth\x12\x14\n" +
+	"\x05width\x18\f \x01(\x01R\x05width\x12\x1b\n" +
+	"\tmin_width\x18\r \x01(\x01R\bminWidth\x12\x1b\n" +
+	"\tmax_width\x18\x0e \x01(\x01R\bmaxWidth\x120\n" +
+	"\x14include_fallback_png\x18\x0f \x01(\bR\x12includeFallbackPng\x122\n" +
+	"\x15fallback_image_format\x18\x13 \x01(\tR\x13fallbackImageFormat\x12\x1b\n" +
+	"\tfont_size\x18\x10 \x01(\x05R\bfontSize\x12'\n" +
+	"\x0fcomparison_mode\x18\x14 \x01(\tR\x0ecomparisonMode\x12'\n" +
+	"\x0fdifference_mode\x18\x15 \x01(\tR\x0edifferenceMode\x12%\n" +
+	"\x0einclude_styles\x18\x16 \x01(\bR\rincludeStyles\x12\x12\n" +
+	"\x04tile\x18\x17 \x01(\tR\x04tile\x12\x1e\n" +
+	"\n" +
+	"resolution\x18\x18 \x01(\x01R\n" +
+	"resolution\x12#\n" +
+	"\roffice_preset\x18\x19 \x01(\tR\fofficePreset\x12\x16\n" +
+	"\x06minify\x18\x1a \x01(\bR\x06minify\x12$\n" +
+	"\rdeterministic\x18\x1b \x01(\bR\rdeterministic\x12\x1b\n" +
+	"\tid_prefix\x18\x1c \x01(\tR\bidPrefix\x12\x1c\n" +
+	"\tgreyscale\x18\x1d \x01(\bR\tgreyscale\x122\n" +
+	"\x15caption_heading_level\x18\x1e \x01(\x05R\x13captionHeadingLevel\x12#\n" +
+	"\rcaption_class\x18\x1f \x01(\tR\fcaptionClass\x12\x1f\n" +
+	"\vembed_title\x18  \x01(\bR\n" +
+	"embedTitle\x12Q\n" +
+	"\x16source_link_attributes\x18! \x01(\v2\x1b.maprenderer.LinkAttributesR\x14sourceLinkAttributes\x12-\n" +
+	"\asources\x18\" \x03(\v2\x13.maprenderer.SourceR\asources\x12\x1a\n" +
+	"\blicences\x18# \x03(\tR\blicences\x12!\n" +
+	"\fregion_class\x18$ \x01(\tR\vregionClass\x124\n" +
+	"\thighlight\x18% \x01(\v2\x16.maprenderer.HighlightR\thighlight\x12\x10\n" +
+	"\x03fit\x18& \x01(\tR\x03fit\x12-\n" +
+	"\x12conversion_failure\x18' \x01(\tR\x11conversionFailure\x12\x1e\n" +
+	"\n" +
+	"background\x18( \x01(\tR\n" +
+	"background\x12*\n" +
+	"\x11high_dpi_fallback\x18) \x01(\bR\x0fhighDpiFallback\x12-\n" +
+	"\x12fallback_structure\x18* \x01(\tR\x11fallbackStructure\x12\x1d\n" +
+	"\n" +
+	"png_output\x18+ \x01(\tR\tpngOutput\x12.\n" +
+	"\adataset\x18, \x01(\v2\x14.maprenderer.DatasetR\adataset\"\xe4\x01\n" +
+	"\aDataset\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\aedition\x18\x02 \x01(\tR\aedition\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12\x1c\n" +
+	"\tdimension\x18\x04 \x01(\tR\tdimension\x12;\n" +
+	"\aoptions\x18\x05 \x03(\v2!.maprenderer.Dataset.OptionsEntryR\aoptions\x1a:\n" +
+	"\fOptionsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"K\n" +
+	"\tHighlight\x12\x10\n" +
+	"\x03ids\x18\x01 \x03(\tR\x03ids\x12\x16\n" +
+	"\x06colour\x18\x02 \x01(\tR\x06colour\x12\x14\n" +
+	"\x05label\x18\x03 \x01(\bR\x05label\"0\n" +
+	"\x06Source\x12\x12\n" +
+	"\x04text\x18\x01 \x01(\tR\x04text\x12\x12\n" +
+	"\x04link\x18\x02 \x01(\tR\x04link\"\xae\x01\n" +
+	"\x0eLinkAttributes\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x12\x10\n" +
+	"\x03rel\x18\x02 \x01(\tR\x03rel\x129\n" +
+	"\x04data\x18\x03 \x03(\v2%.maprenderer.LinkAttributes.DataEntryR\x04data\x1a7\n" +
+	"\tDataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xa0\x04\n" +
+	"\tGeography\x12\x1a\n" +
+	"\btopojson\x18\x01 \x01(\fR\btopojson\x12\x1f\n" +
+	"\vid_property\x18\x02 \x03(\tR\n" +
+	"idProperty\x12#\n" +
+	"\rname_property\x18\x03 \x01(\tR\fnameProperty\x12%\n" +
+	"\x0eclass_property\x18\x04 \x01(\tR\rclassProperty\x124\n" +
+	"\ainclude\x18\x05 \x01(\v2\x1a.maprenderer.FeatureFilterR\ainclude\x124\n" +
+	"\aexclude\x18\x06 \x01(\v2\x1a.maprenderer.FeatureFilterR\aexclude\x121\n" +
+	"\bdissolve\x18\a \x01(\v2\x15.maprenderer.DissolveR\bdissolve\x12\x1f\n" +
+	"\vobject_name\x18\b \x01(\tR\n" +
+	"objectName\x12#\n" +
+	"\rnormalise_ids\x18\t \x01(\bR\fnormaliseIds\x12D\n" +
+	"\n" +
+	"id_aliases\x18\n" +
+	" \x03(\v2%.maprenderer.Geography.IdAliasesEntryR\tidAliases\x12!\n" +
+	"\ftopojson_url\x18\v \x01(\tR\vtopojsonUrl\x1a<\n" +
+	"\x0eIdAliasesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"D\n" +
+	"\bDissolve\x12\x1a\n" +
+	"\bproperty\x18\x01 \x01(\tR\bproperty\x12\x1c\n" +
+	"\taggregate\x18\x02 \x01(\tR\taggregate\"U\n" +
+	"\rFeatureFilter\x12\x10\n" +
+	"\x03ids\x18\x01 \x03(\tR\x03ids\x12\x1a\n" +
+	"\bproperty\x18\x02 \x01(\tR\bproperty\x12\x16\n" +
+	"\x06values\x18\x03 \x03(\tR\x06values\"c\n" +
+	"\aDataRow\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value\x12\x1a\n" +
+	"\bcategory\x18\x03 \x01(\tR\bcategory\x12\x16\n" +
+	"\x06marker\x18\x04 \x01(\tR\x06marker\"\\\n" +
+	"\n" +
+	"DataSeries\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12(\n" +
+	"\x04data\x18\x03 \x03(\v2\x14.maprenderer.DataRowR\x04data\"\xa8\b\n" +
+	"\n" +
+	"Choropleth\x12'\n" +
+	"\x0freference_value\x18\x01 \x01(\x01R\x0ereferenceValue\x120\n" +
+	"\x14reference_value_text\x18\x02 \x01(\tR\x12referenceValueText\x12!\n" +
+	"\fvalue_prefix\x18\x03 \x01(\tR\vvaluePrefix\x12!\n" +
+	"\fvalue_suffix\x18\x04 \x01(\tR\vvalueSuffix\x124\n" +
+	"\x06breaks\x18\x05 \x03(\v2\x1c.maprenderer.ChoroplethBreakR\x06breaks\x12\x1f\n" +
+	"\vupper_bound\x18\x06 \x01(\x01R\n" +
+	"upperBound\x12<\n" +
+	"\x1ahorizontal_legend_position\x18\a \x01(\tR\x18horizontalLegendPosition\x128\n" +
+	"\x18vertical_legend_position\x18\b \x01(\tR\x16verticalLegendPosition\x12*\n" +
+	"\x11missing_data_text\x18\t \x01(\tR\x0fmissingDataText\x121\n" +
+	"\x15hide_missing_data_key\x18\n" +
+	" \x01(\bR\x12hideMissingDataKey\x12'\n" +
+	"\x0fbound_inclusion\x18\v \x01(\tR\x0eboundInclusion\x12+\n" +
+	"\x11suppressed_values\x18\f \x03(\tR\x10suppressedValues\x12'\n" +
+	"\x0fsuppressed_text\x18\r \x01(\tR\x0esuppressedText\x12)\n" +
+	"\x10reference_colour\x18\x0e \x01(\tR\x0freferenceColour\x12%\n" +
+	"\x0ereference_dash\x18\x0f \x01(\tR\rreferenceDash\x12:\n" +
+	"\x19reference_label_placement\x18\x10 \x01(\tR\x17referenceLabelPlacement\x126\n" +
+	"\n" +
+	"references\x18\x11 \x03(\v2\x16.maprenderer.ReferenceR\n" +
+	"references\x12%\n" +
+	"\x0ereverse_legend\x18\x12 \x01(\bR\rreverseLegend\x12!\n" +
+	"\fswatch_shape\x18\x13 \x01(\tR\vswatchShape\x12\x1f\n" +
+	"\vswatch_size\x18\x14 \x01(\x01R\n" +
+	"swatchSize\x12\x1b\n" +
+	"\tkey_scale\x18\x15 \x01(\tR\bkeyScale\x12*\n" +
+	"\x0edecimal_places\x18\x16 \x01(\x05H\x00R\rdecimalPlaces\x88\x01\x01\x12)\n" +
+	"\x10tooltip_template\x18\x17 \x01(\tR\x0ftooltipTemplate\x12\x14\n" +
+	"\x05units\x18\x18 \x01(\tR\x05unitsB\x11\n" +
+	"\x0f_decimal_places\"5\n" +
+	"\tReference\x12\x14\n" +
+	"\x05value\x18\x01 \x01(\x01R\x05value\x12\x12\n" +
+	"\x04text\x18\x02 \x01(\tR\x04text\"x\n" +
+	"\x0fChoroplethBreak\x12\x1f\n" +
+	"\vlower_bound\x18\x01 \x01(\x01R\n" +
+	"lowerBound\x12\x14\n" +
+	"\x05color\x18\x02 \x01(\tR\x05color\x12\x18\n" +
+	"\apattern\x18\x03 \x01(\tR\apattern\x12\x14\n" +
+	"\x05label\x18\x04 \x01(\tR\x05label\"\xa0\x03\n" +
+	"\x0eAnalyseRequest\x124\n" +
+	"\tgeography\x18\x01 \x01(\v2\x16.maprenderer.GeographyR\tgeography\x12\x10\n" +
+	"\x03csv\x18\x02 \x01(\tR\x03csv\x12\x19\n" +
+	"\bid_index\x18\x03 \x01(\x05R\aidIndex\x12\x1f\n" +
+	"\vvalue_index\x18\x04 \x01(\x05R\n" +
+	"valueIndex\x12$\n" +
+	"\x0ehas_header_row\x18\x05 \x01(\bR\fhasHeaderRow\x12,\n" +
+	"\x0freference_value\x18\x06 \x01(\x01H\x00R\x0ereferenceValue\x88\x01\x01\x12%\n" +
+	"\x0ehistogram_bins\x18\a \x01(\x05R\rhistogramBins\x12&\n" +
+	"\x0eclassification\x18\b \x01(\tR\x0eclassification\x12*\n" +
+	"\x0edecimal_places\x18\t \x01(\x05H\x01R\rdecimalPlaces\x88\x01\x01\x12\x14\n" +
+	"\x05units\x18\n" +
+	" \x01(\tR\x05unitsB\x12\n" +
+	"\x10_reference_valueB\x11\n" +
+	"\x0f_decimal_places\"%\n" +
+	"\x0fAnalyseResponse\x12\x12\n" +
+	"\x04json\x18\x01 \x01(\fR\x04json2\xa0\x01\n" +
+	"\vMapRenderer\x12K\n" +
+	"\x06Render\x12\x1d.maprenderer.RenderMapRequest\x1a .maprenderer.RenderResponseChunk0\x01\x12D\n" +
+	"\aAnalyse\x12\x1b.maprenderer.AnalyseRequest\x1a\x1c.maprenderer.AnalyseResponseB9Z7github.com/ONSdigital/dp-map-renderer/proto;maprendererb\x06proto3"
+
+var (
+	file_maprenderer_proto_rawDescOnce sync.Once
+	file_maprenderer_proto_rawDescData []byte
+)
+
+func file_maprenderer_proto_rawDescGZIP() []byte {
+	file_maprenderer_proto_rawDescOnce.Do(func() {
+		file_maprenderer_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_maprenderer_proto_rawDesc), len(file_maprenderer_proto_rawDesc)))
+	})
+	return file_maprenderer_proto_rawDescData
+}
+
+var file_maprenderer_proto_msgTypes = make([]protoimpl.MessageInfo, 20)
+var file_maprenderer_proto_goTypes = []any{
+	(*RenderMapRequest)(nil),    // 0: maprenderer.RenderMapRequest
+	(*RenderResponseChunk)(nil), // 1: maprenderer.RenderResponseChunk
+	(*RenderRequest)(nil),       // 2: maprenderer.RenderRequest
+	(*Dataset)(nil),             // 3: maprenderer.Dataset
+	(*Highlight)(nil),           // 4: maprenderer.Highlight
+	(*Source)(nil),              // 5: maprenderer.Source
+	(*LinkAttributes)(nil),      // 6: maprenderer.LinkAttributes
+	(*Geography)(nil),           // 7: maprenderer.Geography
+	(*Dissolve)(nil),            // 8: maprenderer.Dissolve
+	(*FeatureFilter)(nil),       // 9: maprenderer.FeatureFilter
+	(*DataRow)(nil),             // 10: maprenderer.DataRow
+	(*DataSeries)(nil),          // 11: maprenderer.DataSeries
+	(*Choropleth)(nil),          // 12: maprenderer.Choropleth
+	(*Reference)(nil),           // 13: maprenderer.Reference
+	(*ChoroplethBreak)(nil),     // 14: maprenderer.ChoroplethBreak
+	(*AnalyseRequest)(nil),      // 15: maprenderer.AnalyseRequest
+	(*AnalyseResponse)(nil),     // 16: maprenderer.AnalyseResponse
+	nil,                         // 17: maprenderer.Dataset.OptionsEntry
+	nil,                         // 18: maprenderer.LinkAttributes.DataEntry
+	nil,                         // 19: maprenderer.Geography.IdAliasesEntry
+}
+var file_maprenderer_proto_depIdxs = []int32{
+	2,  // 0: maprenderer.RenderMapRequest.request:type_name -> maprenderer.RenderRequest
+	7,  // 1: maprenderer.RenderRequest.geography:type_name -> maprenderer.Geography
+	10, // 2: maprenderer.RenderRequest.data:type_name -> maprenderer.DataRow
+	11, // 3: maprenderer.RenderRequest.series:type_name -> maprenderer.DataSeries
+	12, // 4: maprenderer.RenderRequest.choropleth:type_name -> maprenderer.Choropleth
+	6,  // 5: maprenderer.RenderRequest.source_link_attributes:type_name -> maprenderer.LinkAttributes
+	5,  // 6: maprenderer.RenderRequest.sources:type_name -> maprenderer.Source
+	4,  // 7: maprenderer.RenderRequest.highlight:type_name -> maprenderer.Highlight
+	3,  // 8: maprenderer.RenderRequest.dataset:type_name -> maprenderer.Dataset
+	17, // 9: maprenderer.Dataset.options:type_name -> maprenderer.Dataset.OptionsEntry
+	18, // 10: maprenderer.LinkAttributes.data:type_name -> maprenderer.LinkAttributes.DataEntry
+	9,  // 11: maprenderer.Geography.include:type_name -> maprenderer.FeatureFilter
+	9,  // 12: maprenderer.Geography.exclude:type_name -> maprenderer.FeatureFilter
+	8,  // 13: maprenderer.Geography.dissolve:type_name -> maprenderer.Dissolve
+	19, // 14: maprenderer.Geography.id_aliases:type_name -> maprenderer.Geography.IdAliasesEntry
+	10, // 15: maprenderer.DataSeries.data:type_name -> maprenderer.DataRow
+	14, // 16: maprenderer.Choropleth.breaks:type_name -> maprenderer.ChoroplethBreak
+	13, // 17: maprenderer.Choropleth.references:type_name -> maprenderer.Reference
+	7,  // 18: maprenderer.AnalyseRequest.geography:type_name -> maprenderer.Geography
+	0,  // 19: maprenderer.MapRenderer.Render:input_type -> maprenderer.RenderMapRequest
+	15, // 20: maprenderer.MapRenderer.Analyse:input_type -> maprenderer.AnalyseRequest
+	1,  // 21: maprenderer.MapRenderer.Render:output_type -> maprenderer.RenderResponseChunk
+	16, // 22: maprenderer.MapRenderer.Analyse:output_type -> maprenderer.AnalyseResponse
+	21, // [21:23] is the sub-list for method output_type
+	19, // [19:21] is the sub-list for method input_type
+	19, // [19:19] is the sub-list for extension type_name
+	19, // [19:19] is the sub-list for extension extendee
+	0,  // [0:19] is the sub-list for field type_name
+}
+
+func init() { file_maprenderer_proto_init() }
+func file_maprenderer_proto_init() {
+	if File_maprenderer_proto != nil {
+		return
+	}
+	file_maprenderer_proto_msgTypes[12].OneofWrappers = []any{}
+	file_maprenderer_proto_msgTypes[15].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_maprenderer_proto_rawDesc), len(file_maprenderer_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   20,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_maprenderer_proto_goTypes,
+		DependencyIndexes: file_maprenderer_proto_depIdxs,
+		MessageInfos:      file_maprenderer_proto_msgTypes,
+	}.Build()
+	File_maprenderer_proto = out.File
+	file_maprenderer_proto_goTypes = nil
+	file_maprenderer_proto_depIdxs = nil
+}
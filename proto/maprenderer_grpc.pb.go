@@ -0,0 +1,174 @@
+// Protocol buffer equivalents of the json models (see models/models.go) and the MapRenderer gRPC service,
+// offering the render and analyse operations to internal services.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: maprenderer.proto
+
+package maprenderer
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MapRenderer_Render_FullMethodName  = "/maprenderer.MapRenderer/Render"
+	MapRenderer_Analyse_FullMethodName = "/maprenderer.MapRenderer/Analyse"
+)
+
+// MapRendererClient is the client API for MapRenderer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// MapRenderer renders choropleth maps and analyses data for them
+type MapRendererClient interface {
+	// Render renders the map in the requested format, streaming the output in chunks
+	Render(ctx context.Context, in *RenderMapRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RenderResponseChunk], error)
+	// Analyse parses a csv file and confirms that it matches the topology
+	Analyse(ctx context.Context, in *AnalyseRequest, opts ...grpc.CallOption) (*AnalyseResponse, error)
+}
+
+type mapRendererClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMapRendererClient(cc grpc.ClientConnInterface) MapRendererClient {
+	return &mapRendererClient{cc}
+}
+
+func (c *mapRendererClient) Render(ctx context.Context, in *RenderMapRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RenderResponseChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MapRenderer_ServiceDesc.Streams[0], MapRenderer_Render_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RenderMapRequest, RenderResponseChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MapRenderer_RenderClient = grpc.ServerStreamingClient[RenderResponseChunk]
+
+func (c *mapRendererClient) Analyse(ctx context.Context, in *AnalyseRequest, opts ...grpc.CallOption) (*AnalyseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AnalyseResponse)
+	err := c.cc.Invoke(ctx, MapRenderer_Analyse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MapRendererServer is the server API for MapRenderer service.
+// All implementations must embed UnimplementedMapRendererServer
+// for forward compatibility.
+//
+// MapRenderer renders choropleth maps and analyses data for them
+type MapRendererServer interface {
+	// Render renders the map in the requested format, streaming the output in chunks
+	Render(*RenderMapRequest, grpc.ServerStreamingServer[RenderResponseChunk]) error
+	// Analyse parses a csv file and confirms that it matches the topology
+	Analyse(context.Context, *AnalyseRequest) (*AnalyseResponse, error)
+	mustEmbedUnimplementedMapRendererServer()
+}
+
+// UnimplementedMapRendererServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMapRendererServer struct{}
+
+func (UnimplementedMapRendererServer) Render(*RenderMapRequest, grpc.ServerStreamingServer[RenderResponseChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method Render not implemented")
+}
+func (UnimplementedMapRendererServer) Analyse(context.Context, *AnalyseRequest) (*AnalyseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Analyse not implemented")
+}
+func (UnimplementedMapRendererServer) mustEmbedUnimplementedMapRendererServer() {}
+func (UnimplementedMapRendererServer) testEmbeddedByValue()                     {}
+
+// UnsafeMapRendererServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MapRendererServer will
+// result in compilation errors.
+type UnsafeMapRendererServer interface {
+	mustEmbedUnimplementedMapRendererServer()
+}
+
+func RegisterMapRendererServer(s grpc.ServiceRegistrar, srv MapRendererServer) {
+	// If the following call pancis, it indicates UnimplementedMapRendererServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MapRenderer_ServiceDesc, srv)
+}
+
+func _MapRenderer_Render_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RenderMapRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MapRendererServer).Render(m, &grpc.GenericServerStream[RenderMapRequest, RenderResponseChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MapRenderer_RenderServer = grpc.ServerStreamingServer[RenderResponseChunk]
+
+func _MapRenderer_Analyse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AnalyseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MapRendererServer).Analyse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MapRenderer_Analyse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MapRendererServer).Analyse(ctx, req.(*AnalyseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MapRenderer_ServiceDesc is the grpc.ServiceDesc for MapRenderer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MapRenderer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "maprenderer.MapRenderer",
+	HandlerType: (*MapRendererServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Analyse",
+			Handler:    _MapRenderer_Analyse_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Render",
+			Handler:       _MapRenderer_Render_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "maprenderer.proto",
+}
@@ -0,0 +1,163 @@
+// Package rediscache is a minimal Redis client for caches shared between instances of the service, so that a horizontally-scaled deployment
+// shares cache hits rather than each instance repeating the same work
+package rediscache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// maxIdleConnections is the number of connections kept open for reuse
+const maxIdleConnections = 8
+
+// Client gets and sets values in a Redis server. Values are set with a time to live, so that the server's memory is released
+// once they're no longer used. It's safe for concurrent use.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	ttl      time.Duration
+	idle     chan *conn
+}
+
+// conn is a connection to the server, with a reader of its replies
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// NewClient creates a Client of the Redis server at addr (host:port), authenticating with the password (unless it's empty) and using the numbered database.
+// Commands that take longer than the timeout fail, and values are set to expire after ttl (if it's greater than zero).
+func NewClient(addr string, password string, db int, timeout time.Duration, ttl time.Duration) *Client {
+	return &Client{addr: addr, password: password, db: db, timeout: timeout, ttl: ttl, idle: make(chan *conn, maxIdleConnections)}
+}
+
+// Get returns the value of the key, or nil if it has none
+func (c *Client) Get(key string) ([]byte, error) {
+	return c.do("GET", key)
+}
+
+// Set sets the value of the key, expiring after the client's time to live
+func (c *Client) Set(key string, value []byte) error {
+	args := []string{key, string(value)}
+	if c.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(c.ttl/time.Millisecond), 10))
+	}
+	_, err := c.do("SET", args...)
+	return err
+}
+
+// do sends the command to the server and returns its reply, using an idle connection if there is one.
+// The connection is closed rather than reused if the command fails, as its state is then unknown.
+func (c *Client) do(command string, args ...string) ([]byte, error) {
+	cn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.send(c.timeout, command, args...)
+	if err != nil {
+		if _, isServerError := err.(serverError); !isServerError {
+			cn.Close()
+			return nil, err
+		}
+	}
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+	return reply, err
+}
+
+// connection returns an idle connection, or opens (and authenticates) a new one
+func (c *Client) connection() (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+	netConn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	if len(c.password) > 0 {
+		if _, err = cn.send(c.timeout, "AUTH", c.password); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err = cn.send(c.timeout, "SELECT", strconv.Itoa(c.db)); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// serverError is an error reply from the server, after which the connection can still be used
+type serverError string
+
+func (e serverError) Error() string {
+	return "Redis error: " + string(e)
+}
+
+// send writes the command as an array of bulk strings and reads the reply - nil for a nil bulk string, otherwise the bytes of the string (or integer).
+// Returns a serverError if the reply is an error.
+func (cn *conn) send(timeout time.Duration, command string, args ...string) ([]byte, error) {
+	if timeout > 0 {
+		cn.SetDeadline(time.Now().Add(timeout))
+	}
+	w := bufio.NewWriter(cn.Conn)
+	fmt.Fprintf(w, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(command), command)
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	line, err := cn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, serverError(line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Redis bulk string length %q", line[1:])
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		b := make([]byte, size+2)
+		if _, err = io.ReadFull(cn.reader, b); err != nil {
+			return nil, err
+		}
+		return b[:size], nil
+	}
+	return nil, fmt.Errorf("Unexpected Redis reply %q", line)
+}
+
+// readLine reads a line of the reply, without its terminating \r\n
+func (cn *conn) readLine() (string, error) {
+	line, err := cn.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", errors.New("Invalid Redis reply")
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,149 @@
+package rediscache_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/rediscache"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeServer is a Redis server supporting the commands used by the client, recording the commands it receives
+type fakeServer struct {
+	listener net.Listener
+	password string
+	mutex    sync.Mutex
+	values   map[string]string
+	commands []string
+}
+
+func newFakeServer(password string) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := &fakeServer{listener: listener, password: password, values: map[string]string{}}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	authenticated := len(s.password) == 0
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.commands = append(s.commands, strings.Join(args, " "))
+		switch {
+		case args[0] == "AUTH":
+			authenticated = args[1] == s.password
+			if authenticated {
+				fmt.Fprint(c, "+OK\r\n")
+			} else {
+				fmt.Fprint(c, "-WRONGPASS invalid password\r\n")
+			}
+		case !authenticated:
+			fmt.Fprint(c, "-NOAUTH Authentication required.\r\n")
+		case args[0] == "SELECT":
+			fmt.Fprint(c, "+OK\r\n")
+		case args[0] == "SET":
+			s.values[args[1]] = args[2]
+			fmt.Fprint(c, "+OK\r\n")
+		case args[0] == "GET":
+			if value, exists := s.values[args[1]]; exists {
+				fmt.Fprintf(c, "$%d\r\n%s\r\n", len(value), value)
+			} else {
+				fmt.Fprint(c, "$-1\r\n")
+			}
+		default:
+			fmt.Fprintf(c, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, count)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		b := make([]byte, size+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func TestClient(t *testing.T) {
+	Convey("Given a Redis server", t, func() {
+		server := newFakeServer("secret")
+		defer server.listener.Close()
+		client := rediscache.NewClient(server.listener.Addr().String(), "secret", 2, time.Second, time.Hour)
+
+		Convey("A value that is set can be got", func() {
+			value := []byte("line 1\r\nline 2 \x00 binary")
+			So(client.Set("map:1", value), ShouldBeNil)
+			got, err := client.Get("map:1")
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, value)
+		})
+
+		Convey("A key without a value returns nil", func() {
+			got, err := client.Get("map:2")
+			So(err, ShouldBeNil)
+			So(got, ShouldBeNil)
+		})
+
+		Convey("The connection is authenticated, selects the database and is reused, and values expire", func() {
+			So(client.Set("map:1", []byte("a")), ShouldBeNil)
+			_, err := client.Get("map:1")
+			So(err, ShouldBeNil)
+			So(server.commands, ShouldResemble, []string{"AUTH secret", "SELECT 2", "SET map:1 a PX 3600000", "GET map:1"})
+		})
+
+		Convey("An error is returned if the password is wrong", func() {
+			client = rediscache.NewClient(server.listener.Addr().String(), "wrong", 0, time.Second, 0)
+			_, err := client.Get("map:1")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "WRONGPASS")
+		})
+	})
+
+	Convey("An error is returned if the server can't be reached", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		addr := listener.Addr().String()
+		listener.Close()
+		client := rediscache.NewClient(addr, "", 0, time.Second, 0)
+		So(client.Set("map:1", []byte("a")), ShouldNotBeNil)
+	})
+}
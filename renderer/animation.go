@@ -0,0 +1,195 @@
+package renderer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	h "github.com/ONSdigital/dp-map-renderer/htmlutil"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
+	"github.com/ONSdigital/go-ns/log"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// scriptReplacementText is a placeholder inserted into the html to be replaced with javascript
+const scriptReplacementText = "[Script Here]"
+
+// animationInterval is the time (in milliseconds) each period of an animated map is shown for
+const animationInterval = 1000
+
+// text that will need internationalising at some point:
+var (
+	playText  = "Play"
+	pauseText = "Pause"
+)
+
+// animationScript is the fmt template of the script that steps through the periods of an animated map. Parameters are the json animationData, the id prefix and the interval.
+const animationScript = `
+<script type="text/javascript">
+(function() {
+	var data = %s;
+	var prefix = %q;
+	var label = document.getElementById(prefix + "-period");
+	var button = document.getElementById(prefix + "-play");
+	var period = 0, timer = null;
+	function show(p) {
+		period = p;
+		label.textContent = data.periods[p];
+		for (var id in data.regions) {
+			var region = document.getElementById(id);
+			if (!region) {
+				continue;
+			}
+			region.style.fill = data.regions[id].fills[p];
+			if (data.regions[id].values[p]) {
+				region.setAttribute("data-value", data.regions[id].values[p]);
+			} else {
+				region.removeAttribute("data-value");
+			}
+			var title = region.querySelector("title");
+			if (title) {
+				title.textContent = data.regions[id].titles[p];
+			}
+		}
+	}
+	button.addEventListener("click", function() {
+		if (timer) {
+			clearInterval(timer);
+			timer = null;
+			button.textContent = data.play;
+			button.setAttribute("aria-pressed", "false");
+			return;
+		}
+		timer = setInterval(function() { show((period + 1) %% data.periods.length); }, %d);
+		button.textContent = data.pause;
+		button.setAttribute("aria-pressed", "true");
+	});
+})();
+</script>
+`
+
+// animationData is the data used by the animation script - the label of each period, and the fill and title of each region in each period
+type animationData struct {
+	Periods []string                    `json:"periods"`
+	Regions map[string]*animationRegion `json:"regions"`
+	Play    string                      `json:"play"`
+	Pause   string                      `json:"pause"`
+}
+
+// animationRegion holds the fill, title and data-value attribute (empty if the region has no data) of a region in each period
+type animationRegion struct {
+	Fills  []string `json:"fills"`
+	Titles []string `json:"titles"`
+	Values []string `json:"values"`
+}
+
+// RenderAnimation returns an HTML figure element with caption and footer, and an SVG map that steps through each data series (e.g. time periods)
+// with a play/pause control and a label showing the current period. The map initially shows the first series. All series share the choropleth breaks.
+func RenderAnimation(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	series := requestSeries(request)
+
+	first := *request
+	first.Data, first.Suppressed = series[0].Data, series[0].Suppressed
+	first.Series = nil
+
+	s := renderAnimationHTML(ctx, &first, series)
+	svgRequest := prepareSVGRequest(ctx, &first)
+	names := featureNames(svgRequest)
+	s, err := replaceSVGPlaceholders(ctx, svgRequest, s)
+	if err != nil {
+		return nil, err
+	}
+	s = strings.Replace(s, scriptReplacementText, renderAnimationScript(svgRequest, series, names), 1)
+	return []byte(s), nil
+}
+
+// renderAnimationHTML returns an HTML figure element with caption and footer, the animation controls, and divs with placeholder text for the map,
+// legend, css and script
+func renderAnimationHTML(ctx context.Context, request *models.RenderRequest, series []*models.DataSeries) string {
+	_, span := tracing.Start(ctx, "renderAnimationHTML")
+	defer span.End()
+	prefix := idPrefix(request)
+	figure := createFigure(request)
+	container := h.CreateNode("div", atom.Div, h.Attr("class", "map_container map_container__animated"))
+	figure.AppendChild(container)
+	addCssPlaceholder(request, container)
+	container.AppendChild(h.CreateNode("div", atom.Div,
+		h.Attr("class", "map_animation__controls"),
+		h.CreateNode("button", atom.Button,
+			h.Attr("type", "button"),
+			h.Attr("id", prefix+"-play"),
+			h.Attr("class", "map_animation__play"),
+			h.Attr("aria-pressed", "false"),
+			playText),
+		h.CreateNode("span", atom.Span,
+			h.Attr("id", prefix+"-period"),
+			h.Attr("class", "map_animation__period"),
+			h.Attr("aria-live", "polite"),
+			seriesTitle(series[0]))))
+	addSVGDivs(request, container)
+	container.AppendChild(h.Text(scriptReplacementText))
+	addFooter(request, figure)
+	var buf bytes.Buffer
+	html.Render(&buf, figure)
+	buf.WriteString("\n")
+	return buf.String()
+}
+
+// featureNames returns the name of each feature in the request's geography, by index. Must be called before the features' titles are set by RenderSVG.
+func featureNames(svgRequest *SVGRequest) []interface{} {
+	if svgRequest.geoJSON == nil {
+		return nil
+	}
+	names := make([]interface{}, len(svgRequest.geoJSON.Features))
+	for i, feature := range svgRequest.geoJSON.Features {
+		name, ok := feature.Properties[svgRequest.request.Geography.NameProperty]
+		if !ok {
+			name = ""
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// renderAnimationScript returns the script that animates the map rendered for the svgRequest, with the fill and title of each region in each series
+func renderAnimationScript(svgRequest *SVGRequest, series []*models.DataSeries, names []interface{}) string {
+	request := svgRequest.request
+	if request.Choropleth == nil || svgRequest.geoJSON == nil {
+		return ""
+	}
+	id := idPrefix(request)
+	data := &animationData{Regions: make(map[string]*animationRegion), Play: playText, Pause: pauseText}
+	for _, s := range series {
+		data.Periods = append(data.Periods, seriesTitle(s))
+		dataMap := mapDataToColour(s.Data, s.Suppressed, request, id)
+		for i, feature := range svgRequest.geoJSON.Features {
+			featureID, isString := feature.ID.(string)
+			if !isString || len(featureID) == 0 {
+				continue
+			}
+			region, exists := data.Regions[featureID]
+			if !exists {
+				region = &animationRegion{}
+				data.Regions[featureID] = region
+			}
+			fill, title := choroplethFillAndTitle(feature, names[i], dataMap, request.Choropleth, id)
+			region.Fills = append(region.Fills, fill)
+			region.Titles = append(region.Titles, title)
+			value := ""
+			if vc, exists := dataMap[feature.ID]; exists && !vc.suppressed {
+				value = fmt.Sprintf("%g", vc.value)
+			}
+			region.Values = append(region.Values, value)
+		}
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to marshal animation data"})
+		return ""
+	}
+	return fmt.Sprintf(animationScript, b, id, animationInterval)
+}
@@ -0,0 +1,202 @@
+package renderer
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/paulmach/go.geojson"
+)
+
+// lruCache is an in-memory cache of intermediate results of rendering, keyed by a hash of (part of) the request.
+// When full, the least recently used entry is evicted to make room for a new one.
+type lruCache struct {
+	mutex      sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List // of *lruCacheEntry, most recently used first
+	maxEntries int
+}
+
+// lruCacheEntry is the cached value with the given key
+type lruCacheEntry struct {
+	key   string
+	value interface{}
+}
+
+// geographies caches the geojson converted from each geography's topology, used by getGeoJSON. Nil (the default) disables the cache.
+var geographies *lruCache
+
+// drawings caches the content of each map drawn by RenderSVG. Nil (the default) disables the cache.
+var drawings *lruCache
+
+// SharedCache is a cache shared between instances of the service (such as Redis), used behind the in-memory caches so that
+// an instance can use the work of others. Get returns nil if the key has no value.
+type SharedCache interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+}
+
+// sharedCache is the cache behind the geography cache. Nil (the default) if there is none.
+var sharedCache SharedCache
+
+// sharedGeographyPrefix is the prefix of the keys of geographies in the shared cache
+const sharedGeographyPrefix = "dp-map-renderer:geography:"
+
+// newLRUCache creates an lruCache holding at most maxEntries values, or returns nil (disabling the cache) if maxEntries is zero (or less)
+func newLRUCache(maxEntries int) *lruCache {
+	if maxEntries <= 0 {
+		return nil
+	}
+	return &lruCache{entries: make(map[string]*list.Element), order: list.New(), maxEntries: maxEntries}
+}
+
+// UseGeographyCache caches the geojson of up to maxEntries geographies, so that repeated renders of the same boundaries (with different data)
+// don't repeat the conversion from topojson. A size of zero (or less) disables the cache.
+func UseGeographyCache(maxEntries int) {
+	geographies = newLRUCache(maxEntries)
+}
+
+// UseSVGCache caches the content of up to maxEntries drawn maps, so that rendering the same request as another type (e.g. png after svg)
+// only repeats the work specific to that type, such as rasterising the map. A size of zero (or less) disables the cache.
+func UseSVGCache(maxEntries int) {
+	drawings = newLRUCache(maxEntries)
+}
+
+// UseSharedCache shares the geojson converted from geographies with other instances of the service through the cache, as a second level
+// behind the geography cache (so it's only used if the geography cache is enabled). Nil stops sharing.
+func UseSharedCache(cache SharedCache) {
+	sharedCache = cache
+}
+
+// getSharedGeoJSON returns the geojson with the given key from the shared cache, or nil if there is none (or it can't be read)
+func getSharedGeoJSON(key string) *geojson.FeatureCollection {
+	cache := sharedCache
+	if cache == nil {
+		return nil
+	}
+	b, err := cache.Get(sharedGeographyPrefix + key)
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to get geojson from the shared cache"})
+		return nil
+	}
+	if b == nil {
+		return nil
+	}
+	geoJSON, err := geojson.UnmarshalFeatureCollection(b)
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to decode geojson from the shared cache"})
+		return nil
+	}
+	return geoJSON
+}
+
+// putSharedGeoJSON adds the geojson to the shared cache, if there is one
+func putSharedGeoJSON(key string, geoJSON *geojson.FeatureCollection) {
+	cache := sharedCache
+	if cache == nil {
+		return
+	}
+	b, err := geoJSON.MarshalJSON()
+	if err == nil {
+		err = cache.Set(sharedGeographyPrefix+key, b)
+	}
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to add geojson to the shared cache"})
+	}
+}
+
+// get returns the cached value for the given key, or nil if there is none
+func (c *lruCache) get(key string) interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, exists := c.entries[key]
+	if !exists {
+		return nil
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruCacheEntry).value
+}
+
+// put adds the value to the cache, evicting the least recently used entry if the cache is full.
+// The value must not be modified after it is added.
+func (c *lruCache) put(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, exists := c.entries[key]; exists {
+		return
+	}
+	if c.order.Len() >= c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruCacheEntry).key)
+	}
+	c.entries[key] = c.order.PushFront(&lruCacheEntry{key: key, value: value})
+}
+
+// hashKey returns a sha256 hash of the json of the value, followed by the given extra values
+func hashKey(value interface{}, extra ...interface{}) (string, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write(b)
+	for _, e := range extra {
+		b, err = json.Marshal(e)
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte{0})
+		hash.Write(b)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// geographyKey returns a hash of the request's geography (its topology, filters and properties), and whether its features are ordered deterministically
+func geographyKey(request *models.RenderRequest) (string, error) {
+	return hashKey(request.Geography, request.Deterministic)
+}
+
+// drawingKey returns a hash of the request and the size of the map, ignoring whether (and at what densities) a fallback image is included,
+// which only affects the svg element around the map's content. The suppressed rows aren't part of the request's json, so are hashed separately.
+func drawingKey(request *models.RenderRequest, width float64, height float64) (string, error) {
+	r := *request
+	r.IncludeFallbackPng = false
+	r.HighDPIFallback = false
+	return hashKey(&r, suppressedRows(request), width, height)
+}
+
+// suppressedRows returns the id and marker of each of the suppressed rows of the request and of each of its series.
+// (The value of a suppressed row is NaN, which can't be encoded as json.)
+func suppressedRows(request *models.RenderRequest) [][][2]string {
+	series := [][]*models.DataRow{request.Suppressed}
+	for _, s := range request.Series {
+		series = append(series, s.Suppressed)
+	}
+	rows := make([][][2]string, len(series))
+	for i, suppressed := range series {
+		for _, row := range suppressed {
+			rows[i] = append(rows[i], [2]string{row.ID, row.Marker})
+		}
+	}
+	return rows
+}
+
+// copyFeatureCollection returns a copy of the featurecollection with a copy of each feature and its properties, which rendering modifies.
+// The geometries are shared.
+func copyFeatureCollection(fc *geojson.FeatureCollection) *geojson.FeatureCollection {
+	result := geojson.NewFeatureCollection()
+	for _, f := range fc.Features {
+		feature := *f
+		feature.Properties = make(map[string]interface{}, len(f.Properties))
+		for k, v := range f.Properties {
+			feature.Properties[k] = v
+		}
+		result.AddFeature(&feature)
+	}
+	return result
+}
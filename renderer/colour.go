@@ -0,0 +1,108 @@
+package renderer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// parseColour returns the red, green and blue components of a colour in the form #rgb, #rrggbb or rgb(r, g, b). ok is false if the colour is in any other form.
+func parseColour(colour string) (r, g, b uint8, ok bool) {
+	colour = strings.TrimSpace(colour)
+	if strings.HasPrefix(colour, "#") {
+		hex := colour[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return 0, 0, 0, false
+		}
+		return uint8(v >> 16), uint8(v >> 8), uint8(v), true
+	}
+	var ri, gi, bi int
+	if n, _ := fmt.Sscanf(strings.Replace(colour, " ", "", -1), "rgb(%d,%d,%d)", &ri, &gi, &bi); n != 3 {
+		return 0, 0, 0, false
+	}
+	return clampColour(ri), clampColour(gi), clampColour(bi), true
+}
+
+// clampColour limits a colour component to the range 0-255
+func clampColour(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// the range of lightness (CIE L*) of the greys that replace the colours of greyscale maps, from the lightest to the darkest
+const (
+	greyscaleLightest = 95.0
+	greyscaleDarkest  = 25.0
+)
+
+// greyscaleBreaks returns copies of the breaks with their colours replaced by greys evenly spaced in lightness, assigned in order of the lightness of the original colours,
+// so that the lightest colour becomes the lightest grey. Breaks without a colour are treated as white.
+func greyscaleBreaks(breaks []*models.ChoroplethBreak) []*models.ChoroplethBreak {
+	order := make([]int, len(breaks))
+	lightnesses := make([]float64, len(breaks))
+	for i, b := range breaks {
+		order[i] = i
+		lightnesses[i] = 100
+		if r, g, b, ok := parseColour(b.Colour); ok {
+			lightnesses[i] = lightness(r, g, b)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return lightnesses[order[i]] > lightnesses[order[j]] })
+
+	grey := make([]*models.ChoroplethBreak, len(breaks))
+	for rank, i := range order {
+		l := (greyscaleLightest + greyscaleDarkest) / 2
+		if len(breaks) > 1 {
+			l = greyscaleLightest - float64(rank)*(greyscaleLightest-greyscaleDarkest)/float64(len(breaks)-1)
+		}
+		b := *breaks[i]
+		b.Colour = greyOfLightness(l)
+		grey[i] = &b
+	}
+	return grey
+}
+
+// lightness returns the perceptual lightness (CIE L*, from 0 for black to 100 for white) of an sRGB colour
+func lightness(r, g, b uint8) float64 {
+	y := 0.2126*linearRGB(r) + 0.7152*linearRGB(g) + 0.0722*linearRGB(b)
+	if y <= 216.0/24389 {
+		return y * 24389 / 27
+	}
+	return 116*math.Cbrt(y) - 16
+}
+
+// greyOfLightness returns the sRGB grey (#rrggbb) with the given lightness (CIE L*)
+func greyOfLightness(l float64) string {
+	y := l * 27 / 24389
+	if l > 8 {
+		y = math.Pow((l+16)/116, 3)
+	}
+	v := 12.92 * y
+	if y > 0.0031308 {
+		v = 1.055*math.Pow(y, 1/2.4) - 0.055
+	}
+	c := clampColour(int(math.Floor(v*255 + 0.5)))
+	return fmt.Sprintf("#%02x%02x%02x", c, c, c)
+}
+
+// linearRGB converts an sRGB colour component to linear light, from 0 to 1
+func linearRGB(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
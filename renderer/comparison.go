@@ -0,0 +1,167 @@
+package renderer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	h "github.com/ONSdigital/dp-map-renderer/htmlutil"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// errTwoSeries is returned when a comparison or difference is requested without two data series to compare
+var errTwoSeries = models.ValidationErrors{{Field: "series", Message: "Two data series are required to compare"}}
+
+// RenderComparison returns an HTML figure element with caption and footer, containing maps of the first two data series with a shared horizontal legend.
+// The request's ComparisonMode determines whether the maps are shown side by side, one at a time with a toggle, or overlaid with a slider.
+func RenderComparison(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	if len(request.Series) < 2 {
+		return nil, errTwoSeries
+	}
+	series := request.Series[:2]
+
+	s, legendRequest := renderFacets(ctx, request, series, renderComparisonHTML(ctx, request, series))
+	if err := failedConversion(legendRequest); err != nil {
+		return nil, err
+	}
+	s = strings.Replace(s, cssReplacementText, renderComparisonCss(request, legendRequest), 1)
+	return []byte(s), nil
+}
+
+// comparisonMode returns the request's comparison mode, defaulting to side-by-side
+func comparisonMode(request *models.RenderRequest) string {
+	if len(request.ComparisonMode) == 0 {
+		return models.ComparisonModeSideBySide
+	}
+	return request.ComparisonMode
+}
+
+// renderComparisonHTML returns an HTML figure element with caption and footer, the controls for the comparison mode, and divs with placeholder text
+// for the legend and the map of each series
+func renderComparisonHTML(ctx context.Context, request *models.RenderRequest, series []*models.DataSeries) string {
+	_, span := tracing.Start(ctx, "renderComparisonHTML")
+	defer span.End()
+	figure := createFigure(request)
+	container := h.CreateNode("div", atom.Div, h.Attr("class", "map_container map_container__comparison"))
+	figure.AppendChild(container)
+	addCssPlaceholder(request, container)
+
+	legendBefore := request.Choropleth != nil && request.Choropleth.HorizontalLegendPosition == models.LegendPositionBefore
+	if legendBefore {
+		addFacetsLegendDiv(request, container)
+	}
+
+	prefix := idPrefix(request)
+	mode := comparisonMode(request)
+	switch mode {
+	case models.ComparisonModeToggle:
+		addComparisonToggle(request, series, container)
+	case models.ComparisonModeSlider:
+		addComparisonSlider(request, series, container)
+	}
+
+	maps := h.CreateNode("div", atom.Div,
+		h.Attr("id", prefix+"-comparison"),
+		h.Attr("class", "map_comparison map_comparison__"+mode),
+		"\n")
+	addFacetDivs(request, series, maps)
+	container.AppendChild(maps)
+
+	if request.Choropleth != nil && !legendBefore {
+		addFacetsLegendDiv(request, container)
+	}
+
+	addFooter(request, figure)
+	var buf bytes.Buffer
+	html.Render(&buf, figure)
+	buf.WriteString("\n")
+	return buf.String()
+}
+
+// addComparisonToggle adds a radio button for each series. The css rendered by renderComparisonCss shows only the map of the checked series,
+// so the inputs must precede the maps as siblings.
+func addComparisonToggle(request *models.RenderRequest, series []*models.DataSeries, parent *html.Node) {
+	prefix := idPrefix(request)
+	for i, s := range series {
+		id := fmt.Sprintf("%s-compare-%d", prefix, i+1)
+		input := h.CreateNode("input", atom.Input,
+			h.Attr("type", "radio"),
+			h.Attr("id", id),
+			h.Attr("name", prefix+"-compare"),
+			h.Attr("class", "map_comparison__toggle"))
+		if i == 0 {
+			h.AddAttribute(input, "checked", "checked")
+		}
+		parent.AppendChild(input)
+		parent.AppendChild(h.CreateNode("label", atom.Label,
+			h.Attr("for", id),
+			h.Attr("class", "map_comparison__label"),
+			seriesTitle(s)))
+		parent.AppendChild(h.Text("\n"))
+	}
+}
+
+// addComparisonSlider adds a range input that reveals the map of the second series over the first
+func addComparisonSlider(request *models.RenderRequest, series []*models.DataSeries, parent *html.Node) {
+	prefix := idPrefix(request)
+	parent.AppendChild(h.CreateNode("input", atom.Input,
+		h.Attr("type", "range"),
+		h.Attr("id", prefix+"-compare-slider"),
+		h.Attr("class", "map_comparison__slider"),
+		h.Attr("min", "0"),
+		h.Attr("max", "100"),
+		h.Attr("value", "50"),
+		h.Attr("aria-label", fmt.Sprintf("Compare %s with %s", seriesTitle(series[0]), seriesTitle(series[1]))),
+		h.Attr("oninput", fmt.Sprintf("document.getElementById('%s-facet-2').style.clipPath = 'inset(0 0 0 ' + this.value + '%%)';", prefix))))
+	parent.AppendChild(h.Text("\n"))
+}
+
+// renderComparisonCss creates a <style> block that lays out the maps according to the comparison mode, limiting the legend to the width of a map
+func renderComparisonCss(request *models.RenderRequest, svgRequest *SVGRequest) string {
+	id := idPrefix(request)
+	css := bytes.NewBufferString("\n<style type=\"text/css\">")
+	switch comparisonMode(request) {
+	case models.ComparisonModeToggle:
+		fmt.Fprintf(css, "\n\t#%s-compare-1:checked ~ #%s-comparison #%s-facet-2, #%s-compare-2:checked ~ #%s-comparison #%s-facet-1 {", id, id, id, id, id, id)
+		fmt.Fprintf(css, "\n\t\tdisplay: none;")
+		fmt.Fprintf(css, "\n\t}")
+		fmt.Fprintf(css, "\n\t#%s-comparison {", id)
+		fmt.Fprintf(css, "\n\t\tmax-width: %.0fpx;", svgRequest.ViewBoxWidth)
+		fmt.Fprintf(css, "\n\t}")
+	case models.ComparisonModeSlider:
+		fmt.Fprintf(css, "\n\t#%s-comparison {", id)
+		fmt.Fprintf(css, "\n\t\tposition: relative;")
+		fmt.Fprintf(css, "\n\t\tmax-width: %.0fpx;", svgRequest.ViewBoxWidth)
+		fmt.Fprintf(css, "\n\t}")
+		fmt.Fprintf(css, "\n\t#%s-facet-2 {", id)
+		fmt.Fprintf(css, "\n\t\tposition: absolute;")
+		fmt.Fprintf(css, "\n\t\ttop: 0;")
+		fmt.Fprintf(css, "\n\t\twidth: 100%%;")
+		fmt.Fprintf(css, "\n\t\tbackground: #fff;")
+		fmt.Fprintf(css, "\n\t\ttext-align: right;")
+		fmt.Fprintf(css, "\n\t\tclip-path: inset(0 0 0 50%%);")
+		fmt.Fprintf(css, "\n\t}")
+		fmt.Fprintf(css, "\n\t#%s-compare-slider {", id)
+		fmt.Fprintf(css, "\n\t\twidth: 100%%;")
+		fmt.Fprintf(css, "\n\t\tmax-width: %.0fpx;", svgRequest.ViewBoxWidth)
+		fmt.Fprintf(css, "\n\t}")
+	default:
+		fmt.Fprintf(css, "\n\t#%s-comparison {", id)
+		fmt.Fprintf(css, "\n\t\tdisplay: grid;")
+		fmt.Fprintf(css, "\n\t\tgrid-template-columns: repeat(auto-fit, minmax(%.0fpx, 1fr));", facetMinWidth)
+		fmt.Fprintf(css, "\n\t\tgrid-gap: 10px;")
+		fmt.Fprintf(css, "\n\t}")
+	}
+	fmt.Fprintf(css, "\n\t#%s-legend-horizontal {", id)
+	fmt.Fprintf(css, "\n\t\tmax-width: %.0fpx;", svgRequest.ViewBoxWidth)
+	fmt.Fprintf(css, "\n\t}")
+	if request.IncludeStyles {
+		writeScopedStyles(css, request)
+	}
+	fmt.Fprintf(css, "\n</style>\n")
+	return css.String()
+}
@@ -0,0 +1,116 @@
+package renderer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// conversionFailures records the first error converting the svgs of a render to images. It is shared by the map and legends, which may be rendered concurrently.
+type conversionFailures struct {
+	mutex sync.Mutex
+	err   error
+}
+
+// record keeps the error if it is the first
+func (f *conversionFailures) record(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// first returns the first error recorded, or nil if there was none
+func (f *conversionFailures) first() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.err
+}
+
+// recordingConverter converts svgs to the request's fallback image format, with the request's background. If a fallback image can't be created, the error is recorded
+// and the svg is rendered alone, so that the render can either fail or fall back to svg-only output (see models.RenderRequest.ConversionFailure).
+type recordingConverter struct {
+	g2s.RasterConverter
+	format     string
+	background string
+	highDPI    bool   // if true, fallback images include an image of twice the size for high-DPI screens (see g2s.HighDPIFallbackImage)
+	alt        string // the alt text of the fallback image
+	// if picture is true, the svg and fallback images are the sources of a picture element (see g2s.FallbackPicture), with an image from each of the sources before the img
+	picture  bool
+	sources  []g2s.PictureSource
+	failures *conversionFailures
+}
+
+// Convert converts the svg to a base64-encoded image, with its background filled (see g2s.AddBackground)
+func (c *recordingConverter) Convert(svg []byte) ([]byte, error) {
+	return c.RasterConverter.Convert(g2s.AddBackground(svg, c.background))
+}
+
+// IncludeFallbackImage generates an svg with the given attributes, content and a fallback image, or without the fallback image if the svg can't be converted
+func (c *recordingConverter) IncludeFallbackImage(attributes string, content string, width float64, height float64) string {
+	var svg string
+	var err error
+	switch {
+	case c.picture:
+		svg, err = g2s.FallbackPicture(c, c.format, c.alt, attributes, content, width, height, c.sources...)
+	case c.highDPI:
+		svg, err = g2s.HighDPIFallbackImage(c, c.format, c.alt, attributes, content, width, height)
+	default:
+		svg, err = g2s.FallbackImage(c, c.format, c.alt, attributes, content, width, height)
+	}
+	if err != nil {
+		log.Error(err, log.Data{"_message": "Unable to include fallback image - rendering svg only", "format": c.format})
+		c.failures.record(err)
+		return fmt.Sprintf("<svg %s>%s</svg>", strings.TrimSpace(attributes), content)
+	}
+	return svg
+}
+
+// fallbackImageConverter returns the converter for the fallback images of the svgRequest (see fallbackConverter), which records any failure in the svgRequest.
+// The images have the given alt text. Nil if there is no converter.
+// If the request's FallbackStructure is picture, the fallback image is a png img, preceded by a source in the request's fallback image format if it isn't png.
+func (svgRequest *SVGRequest) fallbackImageConverter(alt string) g2s.RasterConverter {
+	request := svgRequest.request
+	converter, format := fallbackConverter(request)
+	if converter == nil {
+		return nil
+	}
+	c := &recordingConverter{RasterConverter: converter, format: format, background: imageBackground(request, format),
+		highDPI: request.HighDPIFallback, alt: alt, failures: svgRequest.conversionFailures}
+	if request.FallbackStructure != models.FallbackStructurePicture {
+		return c
+	}
+	c.picture = true
+	png := rasterConverters[g2s.ImageFormatPNG]
+	if format == g2s.ImageFormatPNG || png == nil {
+		return c
+	}
+	source := *c
+	c.RasterConverter, c.format, c.background = png, g2s.ImageFormatPNG, imageBackground(request, g2s.ImageFormatPNG)
+	c.sources = []g2s.PictureSource{{Converter: &source, Format: format}}
+	return c
+}
+
+// jpegBackground is the background of jpeg images when the request doesn't give one, as jpeg has no transparency
+const jpegBackground = "#ffffff"
+
+// imageBackground returns the background of the request's images in the given format - the request's Background, or white for jpeg images without one
+func imageBackground(request *models.RenderRequest, format string) string {
+	if format == g2s.ImageFormatJPEG && (len(request.Background) == 0 || request.Background == models.BackgroundTransparent) {
+		return jpegBackground
+	}
+	return request.Background
+}
+
+// failedConversion returns the first error converting the svgs of the svgRequest to images if the request should fail when they can't be converted, otherwise nil
+func failedConversion(svgRequest *SVGRequest) error {
+	if svgRequest.request.ConversionFailure != models.ConversionFailureFail {
+		return nil
+	}
+	return svgRequest.conversionFailures.first()
+}
@@ -0,0 +1,57 @@
+package renderer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
+)
+
+// joinedDataHeader is the header row of the csv returned by RenderDataCSV
+var joinedDataHeader = []string{"id", "name", "value", "class", "colour"}
+
+// RenderDataCSV returns a csv of each region joined to its data - its id, name, value, class (numbered from 0, in ascending order of lower bound) and colour -
+// for use as a figure's "download the data" link. value, class and colour are empty for regions without data.
+func RenderDataCSV(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(joinedDataHeader)
+	for _, row := range joinedData(ctx, request) {
+		record := []string{row.ID, row.Name, "", "", row.Colour}
+		if row.Value != nil {
+			record[2] = strconv.FormatFloat(*row.Value, 'g', -1, 64)
+		}
+		if row.Class != nil {
+			record[3] = strconv.Itoa(*row.Class)
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// RenderDataJSON returns a json array of each region joined to its data, as models.JoinedDataRow
+func RenderDataJSON(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	return json.Marshal(joinedData(ctx, request))
+}
+
+// joinedData returns each region of the request's geography joined to its data
+func joinedData(ctx context.Context, request *models.RenderRequest) []*models.JoinedDataRow {
+	_, span := tracing.Start(ctx, "joinedData")
+	defer span.End()
+	features := classifyFeatures(request)
+	rows := make([]*models.JoinedDataRow, len(features))
+	for i, f := range features {
+		rows[i] = &models.JoinedDataRow{ID: f.ID, Name: f.Name, Value: f.Value, Colour: f.Colour}
+		if f.Class >= 0 {
+			class := f.Class
+			rows[i].Class = &class
+		}
+	}
+	span.SetAttribute("row_count", len(rows))
+	return rows
+}
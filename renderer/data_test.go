@@ -0,0 +1,97 @@
+package renderer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	. "github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/dp-map-renderer/testdata"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderJoinedData(t *testing.T) {
+	Convey("Given the example request", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		row := renderRequest.Data[0]
+
+		Convey("RenderDataJSON should return each region with its value, class and colour", func() {
+			result, err := RenderDataJSON(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			var rows []*models.JoinedDataRow
+			So(json.Unmarshal(result, &rows), ShouldBeNil)
+			So(len(rows), ShouldBeGreaterThan, 0)
+
+			var joined *models.JoinedDataRow
+			for _, r := range rows {
+				if r.ID == row.ID {
+					joined = r
+				}
+			}
+			So(joined, ShouldNotBeNil)
+			So(joined.Name, ShouldNotBeEmpty)
+			So(*joined.Value, ShouldEqual, row.Value)
+			So(joined.Class, ShouldNotBeNil)
+			So(joined.Colour, ShouldNotBeEmpty)
+		})
+
+		Convey("RenderDataCSV should return the same rows as csv, with a header", func() {
+			result, err := RenderDataCSV(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			records, err := csv.NewReader(bytes.NewReader(result)).ReadAll()
+			So(err, ShouldBeNil)
+			So(records[0], ShouldResemble, []string{"id", "name", "value", "class", "colour"})
+
+			jsonResult, _ := RenderDataJSON(context.Background(), renderRequest)
+			var rows []*models.JoinedDataRow
+			json.Unmarshal(jsonResult, &rows)
+			So(records, ShouldHaveLength, len(rows)+1)
+			for i, r := range rows {
+				So(records[i+1][0], ShouldEqual, r.ID)
+				So(records[i+1][4], ShouldEqual, r.Colour)
+				if r.Value == nil {
+					So(records[i+1][2], ShouldBeEmpty)
+					So(records[i+1][3], ShouldBeEmpty)
+				}
+			}
+		})
+	})
+}
+
+func TestRenderJoinedDataBoundInclusion(t *testing.T) {
+	Convey("Given the example request with a value on the boundary between the second and third breaks", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		row := renderRequest.Data[0]
+		row.Value = 11
+		classOf := func() int {
+			result, err := RenderDataJSON(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			var rows []*models.JoinedDataRow
+			So(json.Unmarshal(result, &rows), ShouldBeNil)
+			for _, r := range rows {
+				if r.ID == row.ID {
+					return *r.Class
+				}
+			}
+			return -1
+		}
+
+		Convey("By default the value should be in the break whose lower bound it equals", func() {
+			So(classOf(), ShouldEqual, 2)
+		})
+
+		Convey("With upper bound inclusion the value should be in the break below", func() {
+			renderRequest.Choropleth.BoundInclusion = models.BoundInclusionUpper
+			So(classOf(), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,73 @@
+package renderer
+
+import (
+	"context"
+
+	"github.com/ONSdigital/dp-map-renderer/analyser"
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// noChangeText labels the zero point of the key of a difference map. Will need internationalising at some point.
+var noChangeText = "No change"
+
+// RenderDifference returns an HTML figure element with caption and footer, and an SVG map of the difference between the first two data series -
+// the value of the second series minus the value of the first, or that change as a percentage of the first value, according to the request's DifferenceMode.
+// The choropleth breaks and reference value are replaced with breaks symmetric around zero and a reference of no change, retaining the other choropleth settings. Regions without a value in both series
+// (or with a first value of zero, for a percentage) are shown as missing data.
+func RenderDifference(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	if len(request.Series) < 2 {
+		return nil, errTwoSeries
+	}
+	return RenderHTMLWithSVG(ctx, differenceRequest(request))
+}
+
+// differenceRequest returns a copy of the request with the difference between the first two series as its data, and a diverging choropleth for that data
+func differenceRequest(request *models.RenderRequest) *models.RenderRequest {
+	percentage := request.DifferenceMode == models.DifferenceModePercentage
+
+	difference := *request
+	difference.Data = differenceData(request.Series[0].Data, request.Series[1].Data, percentage)
+	// a region suppressed in either series has no difference, and is shown as suppressed
+	difference.Suppressed = append(append([]*models.DataRow{}, request.Series[0].Suppressed...), request.Series[1].Suppressed...)
+	difference.Series = nil
+
+	choropleth := &models.Choropleth{HorizontalLegendPosition: models.LegendPositionAfter}
+	if request.Choropleth != nil {
+		c := *request.Choropleth
+		choropleth = &c
+	}
+	values := make([]float64, len(difference.Data))
+	for i, row := range difference.Data {
+		values[i] = row.Value
+	}
+	choropleth.Breaks, choropleth.UpperBound = analyser.SymmetricBreaks(values)
+	choropleth.ReferenceValue = 0
+	choropleth.ReferenceValueText = noChangeText
+	choropleth.References = nil
+	if percentage && len(choropleth.ValueSuffix) == 0 {
+		choropleth.ValueSuffix = "%"
+	}
+	difference.Choropleth = choropleth
+	return &difference
+}
+
+// differenceData returns a row for each id with a value in both from and to, with the value to minus from, or that change as a percentage of from
+func differenceData(from []*models.DataRow, to []*models.DataRow, percentage bool) []*models.DataRow {
+	fromValues := make(map[string]float64, len(from))
+	for _, row := range from {
+		fromValues[row.ID] = row.Value
+	}
+	rows := []*models.DataRow{}
+	for _, row := range to {
+		value, ok := fromValues[row.ID]
+		if !ok || (percentage && value == 0) {
+			continue
+		}
+		change := row.Value - value
+		if percentage {
+			change = change / value * 100
+		}
+		rows = append(rows, &models.DataRow{ID: row.ID, Value: change})
+	}
+	return rows
+}
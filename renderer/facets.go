@@ -0,0 +1,165 @@
+package renderer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	h "github.com/ONSdigital/dp-map-renderer/htmlutil"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// facetReplacementText is the fmt template for the placeholder inserted into the html to be replaced with the svg map of each facet
+const facetReplacementText = "[Facet %d Here]"
+
+// facetMinWidth is the minimum width (in pixels) of each map in a small multiples grid
+const facetMinWidth = 150.0
+
+// RenderSmallMultiples returns an HTML figure element with caption and footer, containing a grid of small maps - one for each data series -
+// that share a single horizontal legend, and therefore the same breaks. A request without series is rendered as a single map of its data.
+func RenderSmallMultiples(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	series := requestSeries(request)
+	s, legendRequest := renderFacets(ctx, request, series, renderFacetsHTML(ctx, request, series))
+	if err := failedConversion(legendRequest); err != nil {
+		return nil, err
+	}
+	s = strings.Replace(s, cssReplacementText, renderFacetsCss(request, legendRequest), 1)
+	return []byte(s), nil
+}
+
+// renderFacets replaces the facet marker text with the svg map of each series, and the horizontal key marker text with the key shared by all facets.
+// Returns the result and the SVGRequest of the first facet.
+func renderFacets(ctx context.Context, request *models.RenderRequest, series []*models.DataSeries, original string) (string, *SVGRequest) {
+	result := original
+	var first *SVGRequest
+	for i, data := range series {
+		svgRequest := prepareSVGRequest(ctx, facetRequest(request, data, i))
+		svgRequest.responsiveSize = true
+		if first == nil {
+			first = svgRequest
+		}
+		// the facets (and their key) share the record of conversion failures, so the first can be checked for all of them
+		svgRequest.conversionFailures = first.conversionFailures
+		result = strings.Replace(result, fmt.Sprintf(facetReplacementText, i+1), "\n"+renderSVG(ctx, svgRequest)+"\n", 1)
+	}
+
+	if strings.Contains(result, horizontalKeyReplacementText) {
+		// the key is the same for every facet, but is rendered with the ids of the figure
+		key := *first
+		key.request = request
+		result = strings.Replace(result, horizontalKeyReplacementText, "\n"+traced(ctx, "RenderHorizontalKey", RenderHorizontalKey, &key)+"\n", 1)
+	}
+	return result, first
+}
+
+// requestSeries returns the data series of the request, or a single series of the request's data if it has none
+func requestSeries(request *models.RenderRequest) []*models.DataSeries {
+	if len(request.Series) > 0 {
+		return request.Series
+	}
+	return []*models.DataSeries{{Title: request.Title, Data: request.Data, Suppressed: request.Suppressed}}
+}
+
+// seriesTitle returns the title of the series, falling back to its id
+func seriesTitle(series *models.DataSeries) string {
+	if len(series.Title) > 0 {
+		return series.Title
+	}
+	return series.ID
+}
+
+// facetRequest returns a copy of the request for rendering the map of the given series (the nth of the request), with a unique id and without any legends
+func facetRequest(request *models.RenderRequest, series *models.DataSeries, n int) *models.RenderRequest {
+	facet := *request
+	facet.Filename = fmt.Sprintf("%s-facet-%d", request.Filename, n+1)
+	if len(request.IDPrefix) > 0 {
+		facet.IDPrefix = fmt.Sprintf("%s-facet-%d", idPrefix(request), n+1)
+	}
+	facet.Data, facet.Suppressed = series.Data, series.Suppressed
+	facet.Series = nil
+	if request.Choropleth != nil {
+		choropleth := *request.Choropleth
+		choropleth.HorizontalLegendPosition = ""
+		choropleth.VerticalLegendPosition = ""
+		facet.Choropleth = &choropleth
+	}
+	return &facet
+}
+
+// renderFacetsHTML returns an HTML figure element with caption and footer, and divs with placeholder text for the legend and the map of each series
+func renderFacetsHTML(ctx context.Context, request *models.RenderRequest, series []*models.DataSeries) string {
+	_, span := tracing.Start(ctx, "renderFacetsHTML")
+	defer span.End()
+	figure := createFigure(request)
+	container := h.CreateNode("div", atom.Div, h.Attr("class", "map_container map_container__facets"))
+	figure.AppendChild(container)
+	addCssPlaceholder(request, container)
+
+	legendBefore := request.Choropleth != nil && request.Choropleth.HorizontalLegendPosition == models.LegendPositionBefore
+	if legendBefore {
+		addFacetsLegendDiv(request, container)
+	}
+
+	grid := h.CreateNode("div", atom.Div,
+		h.Attr("id", idPrefix(request)+"-facets"),
+		h.Attr("class", "map_facets"),
+		"\n")
+	addFacetDivs(request, series, grid)
+	container.AppendChild(grid)
+
+	if request.Choropleth != nil && !legendBefore {
+		addFacetsLegendDiv(request, container)
+	}
+
+	addFooter(request, figure)
+	var buf bytes.Buffer
+	html.Render(&buf, figure)
+	buf.WriteString("\n")
+	return buf.String()
+}
+
+// addFacetDivs adds a div for each series, with its title and marker text for its map
+func addFacetDivs(request *models.RenderRequest, series []*models.DataSeries, parent *html.Node) {
+	prefix := idPrefix(request)
+	for i, s := range series {
+		parent.AppendChild(h.CreateNode("div", atom.Div,
+			h.Attr("id", fmt.Sprintf("%s-facet-%d", prefix, i+1)),
+			h.Attr("class", "map_facet"),
+			h.CreateNode("p", atom.P,
+				h.Attr("class", "map_facet__title"),
+				seriesTitle(s)),
+			fmt.Sprintf(facetReplacementText, i+1)))
+		parent.AppendChild(h.Text("\n"))
+	}
+}
+
+// addFacetsLegendDiv adds a div with marker text for the horizontal legend shared by all facets
+func addFacetsLegendDiv(request *models.RenderRequest, parent *html.Node) {
+	parent.AppendChild(h.CreateNode("div", atom.Div,
+		h.Attr("id", idPrefix(request)+"-legend-horizontal"),
+		h.Attr("class", "map_key map_key__horizontal"),
+		horizontalKeyReplacementText))
+}
+
+// renderFacetsCss creates a <style> block that lays out the facets in a grid with as many columns as will fit, limiting the legend to the width of a map
+func renderFacetsCss(request *models.RenderRequest, svgRequest *SVGRequest) string {
+	id := idPrefix(request)
+	css := bytes.NewBufferString("\n<style type=\"text/css\">")
+	fmt.Fprintf(css, "\n\t#%s-facets {", id)
+	fmt.Fprintf(css, "\n\t\tdisplay: grid;")
+	fmt.Fprintf(css, "\n\t\tgrid-template-columns: repeat(auto-fill, minmax(%.0fpx, 1fr));", facetMinWidth)
+	fmt.Fprintf(css, "\n\t\tgrid-gap: 10px;")
+	fmt.Fprintf(css, "\n\t}")
+	fmt.Fprintf(css, "\n\t#%s-legend-horizontal {", id)
+	fmt.Fprintf(css, "\n\t\tmax-width: %.0fpx;", svgRequest.ViewBoxWidth)
+	fmt.Fprintf(css, "\n\t}")
+	if request.IncludeStyles {
+		writeScopedStyles(css, request)
+	}
+	fmt.Fprintf(css, "\n</style>\n")
+	return css.String()
+}
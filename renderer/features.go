@@ -0,0 +1,157 @@
+package renderer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/paulmach/go.geojson"
+)
+
+// classifiedFeature is a region of the request's geography joined with its data and assigned to a choropleth class
+type classifiedFeature struct {
+	ID      string
+	Name    string
+	Value   *float64 // nil if the region has no data
+	Class   int      // the index of the region's break, with breaks in ascending order of lower bound. -1 if the region has no data or there is no choropleth
+	Colour  string   // the colour of the region's break. Empty if the region has no class
+	Feature *geojson.Feature
+}
+
+// classifyFeatures joins each feature of the request's geography to its data row, assigning it a class and colour from the choropleth breaks in the same way as the svg map.
+// Returns nil if the request has no geography.
+func classifyFeatures(request *models.RenderRequest) []*classifiedFeature {
+	geoJSON := getGeoJSON(request)
+	if geoJSON == nil {
+		return nil
+	}
+
+	data := make(map[string]float64)
+	for _, row := range request.Data {
+		data[row.ID] = row.Value
+	}
+	breaks := requestBreaks(request)
+
+	features := make([]*classifiedFeature, len(geoJSON.Features))
+	for i, feature := range geoJSON.Features {
+		f := &classifiedFeature{Class: -1, Feature: feature}
+		id := request.Geography.FeatureID(feature.Properties, feature.ID)
+		f.ID = id
+		if name, ok := feature.Properties[request.Geography.NameProperty]; ok {
+			f.Name = fmt.Sprintf("%v", name)
+		}
+		if value, exists := data[id]; exists && len(id) > 0 {
+			f.Value = &value
+			if len(breaks) > 0 {
+				f.Class = getClass(value, breaks, upperInclusive(request.Choropleth))
+				f.Colour = breaks[f.Class].Colour
+			}
+		}
+		features[i] = f
+	}
+	return features
+}
+
+// getClass returns the index of the break the value falls in, given breaks sorted in ascending order. Values below the lowest lowerbound are in the lowest break.
+// A value equal to the lower bound of a break is in that break, or in the break below if upperInclusive.
+func getClass(value float64, ascending []*models.ChoroplethBreak, upperInclusive bool) int {
+	for i := len(ascending) - 1; i > 0; i-- {
+		if value > ascending[i].LowerBound || (value == ascending[i].LowerBound && !upperInclusive) {
+			return i
+		}
+	}
+	return 0
+}
+
+// upperInclusive returns true if values on the boundary between two breaks of the choropleth are in the lower break
+func upperInclusive(choropleth *models.Choropleth) bool {
+	return choropleth != nil && choropleth.BoundInclusion == models.BoundInclusionUpper
+}
+
+// classifiedFeatureCollection returns a featurecollection of the classified features, with the properties id, name, value, class and colour replacing their original properties.
+// value, class and colour are omitted for regions without data.
+func classifiedFeatureCollection(features []*classifiedFeature) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for _, f := range features {
+		feature := geojson.NewFeature(f.Feature.Geometry)
+		feature.ID = f.ID
+		feature.SetProperty("id", f.ID)
+		feature.SetProperty("name", f.Name)
+		if f.Value != nil {
+			feature.SetProperty("value", *f.Value)
+		}
+		if f.Class >= 0 {
+			feature.SetProperty("class", f.Class)
+			feature.SetProperty("colour", f.Colour)
+		}
+		fc.AddFeature(feature)
+	}
+	return fc
+}
+
+// formatValue returns the value with the choropleth's prefix and suffix, e.g. "12.5%", to the choropleth's decimal places if it has them
+func formatValue(choropleth *models.Choropleth, value float64) string {
+	if choropleth == nil {
+		return fmt.Sprintf("%g", value)
+	}
+	return choropleth.ValuePrefix + formatNumber(choropleth, value) + choropleth.ValueSuffix
+}
+
+// formatNumber returns the value to the choropleth's decimal places if it has them, otherwise in full
+func formatNumber(choropleth *models.Choropleth, value float64) string {
+	if choropleth == nil || choropleth.DecimalPlaces == nil {
+		return fmt.Sprintf("%g", value)
+	}
+	return strconv.FormatFloat(value, 'f', *choropleth.DecimalPlaces, 64)
+}
+
+// rangeLabel returns the range of a break with the choropleth's prefix and suffix, e.g. "10% to 20%"
+func rangeLabel(choropleth *models.Choropleth, lowerBound float64, upperBound float64) string {
+	return fmt.Sprintf("%s%g%s to %s%g%s", choropleth.ValuePrefix, lowerBound, choropleth.ValueSuffix, choropleth.ValuePrefix, upperBound, choropleth.ValueSuffix)
+}
+
+// classLabel returns the label of the break with the given class (its index in the ascending breaks), or its range if it has no label -
+// from its lower bound to the lower bound of the next break, or the choropleth's upper bound for the last break
+func classLabel(choropleth *models.Choropleth, ascending []*models.ChoroplethBreak, class int) string {
+	b := ascending[class]
+	if len(b.Label) > 0 {
+		return b.Label
+	}
+	upperBound := choropleth.UpperBound
+	if class < len(ascending)-1 {
+		upperBound = ascending[class+1].LowerBound
+	}
+	return rangeLabel(choropleth, b.LowerBound, upperBound)
+}
+
+// geometryPolygons returns the polygons of a polygon or multipolygon geometry, or nil for any other geometry
+func geometryPolygons(g *geojson.Geometry) [][][][]float64 {
+	if g == nil {
+		return nil
+	}
+	switch g.Type {
+	case geojson.GeometryPolygon:
+		return [][][][]float64{g.Polygon}
+	case geojson.GeometryMultiPolygon:
+		return g.MultiPolygon
+	}
+	return nil
+}
+
+// classifiedBounds returns the minimum and maximum longitude and latitude of the features' polygons. ok is false if there are none.
+func classifiedBounds(features []*classifiedFeature) (minLon, minLat, maxLon, maxLat float64, ok bool) {
+	minLon, minLat, maxLon, maxLat = math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, f := range features {
+		for _, polygon := range geometryPolygons(f.Feature.Geometry) {
+			for _, ring := range polygon {
+				for _, p := range ring {
+					minLon, maxLon = math.Min(minLon, p[0]), math.Max(maxLon, p[0])
+					minLat, maxLat = math.Min(minLat, p[1]), math.Max(maxLat, p[1])
+					ok = true
+				}
+			}
+		}
+	}
+	return
+}
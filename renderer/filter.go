@@ -0,0 +1,61 @@
+package renderer
+
+import (
+	"fmt"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/paulmach/go.geojson"
+)
+
+// filterFeatures returns the features of the collection matching the geography's include filter (if it has one) and not matching its exclude filter
+func filterFeatures(fc *geojson.FeatureCollection, geography *models.Geography) *geojson.FeatureCollection {
+	if geography.Include == nil && geography.Exclude == nil {
+		return fc
+	}
+	include := newFeatureMatcher(geography.Include, geography)
+	exclude := newFeatureMatcher(geography.Exclude, geography)
+	features := make([]*geojson.Feature, 0, len(fc.Features))
+	for _, feature := range fc.Features {
+		if (include == nil || include.matches(feature)) && (exclude == nil || !exclude.matches(feature)) {
+			features = append(features, feature)
+		}
+	}
+	fc.Features = features
+	return fc
+}
+
+// featureMatcher matches features against a models.FeatureFilter
+type featureMatcher struct {
+	geography *models.Geography
+	ids       map[string]bool
+	property  string
+	values    map[string]bool
+}
+
+// newFeatureMatcher returns a matcher for the filter of regions of the geography, or nil if there is no filter
+func newFeatureMatcher(filter *models.FeatureFilter, geography *models.Geography) *featureMatcher {
+	if filter == nil {
+		return nil
+	}
+	m := &featureMatcher{geography: geography, ids: make(map[string]bool), property: filter.Property, values: make(map[string]bool)}
+	for _, id := range filter.IDs {
+		m.ids[id] = true
+	}
+	for _, value := range filter.Values {
+		m.values[value] = true
+	}
+	return m
+}
+
+// matches returns true if the feature's id (its first id property, falling back to the feature id) is one of the filter's ids,
+// or the value of the filter's property is one of its values
+func (m *featureMatcher) matches(feature *geojson.Feature) bool {
+	if id := m.geography.FeatureID(feature.Properties, feature.ID); len(id) > 0 && m.ids[id] {
+		return true
+	}
+	if len(m.property) == 0 {
+		return false
+	}
+	value, ok := feature.Properties[m.property]
+	return ok && value != nil && m.values[fmt.Sprintf("%v", value)]
+}
@@ -0,0 +1,35 @@
+package renderer
+
+import (
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/paulmach/go.geojson"
+)
+
+// fittedGeometries returns the geometries of the features the map should be framed to, given the request's fit - the features with a data row
+// (including suppressed rows), or the highlighted features. Returns nil (frame the whole map) for the default fit, or if no features match.
+func fittedGeometries(features []*geojson.Feature, request *models.RenderRequest) []*geojson.Geometry {
+	var matcher *featureMatcher
+	switch request.Fit {
+	case models.FitData:
+		filter := &models.FeatureFilter{}
+		for _, row := range request.Data {
+			filter.IDs = append(filter.IDs, row.ID)
+		}
+		for _, row := range request.Suppressed {
+			filter.IDs = append(filter.IDs, row.ID)
+		}
+		matcher = newFeatureMatcher(filter, request.Geography)
+	case models.FitHighlight:
+		matcher = highlightMatcher(request)
+	}
+	if matcher == nil {
+		return nil
+	}
+	var geometries []*geojson.Geometry
+	for _, feature := range features {
+		if feature.Geometry != nil && matcher.matches(feature) {
+			geometries = append(geometries, feature.Geometry)
+		}
+	}
+	return geometries
+}
@@ -0,0 +1,127 @@
+package renderer
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/ONSdigital/dp-map-renderer/geotiff"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
+)
+
+// palette indexes of pixels that aren't in a class. Classes are numbered from 0.
+const (
+	geoTIFFMissingData = 254 // pixels in regions without data
+	geoTIFFNoData      = 255 // pixels outside all regions
+)
+
+// maxGeoTIFFPixels limits the size of rendered GeoTIFFs
+const maxGeoTIFFPixels = 25000000
+
+// errTooManyPixels is returned when the requested resolution would produce a GeoTIFF larger than maxGeoTIFFPixels
+var errTooManyPixels = models.ValidationErrors{{Field: "resolution", Message: "The resolution is too fine for the extent of the geography"}}
+
+// RenderGeoTIFF returns a GeoTIFF (in longitude/latitude) of the regions rasterised at the request's Resolution - by default, the extent of the geography divided by the width.
+// The value of each pixel is the choropleth class of its region (numbered from 0 in ascending order), 254 for regions without data, or 255 (the nodata value) outside all regions.
+// The colour map holds the colours of the classes.
+func RenderGeoTIFF(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	_, span := tracing.Start(ctx, "RenderGeoTIFF")
+	defer span.End()
+
+	features := classifyFeatures(request)
+	west, south, east, north, ok := classifiedBounds(features)
+	if !ok {
+		return nil, models.ValidationErrors{{Field: "geography", Message: "The geography has no regions"}}
+	}
+	resolution := request.Resolution
+	if resolution <= 0 {
+		resolution = (east - west) / getWidth(request)
+	}
+	width, height := int(math.Ceil((east-west)/resolution)), int(math.Ceil((north-south)/resolution))
+	if width*height > maxGeoTIFFPixels || width <= 0 || height <= 0 {
+		return nil, errTooManyPixels
+	}
+	span.SetAttribute("width", width)
+	span.SetAttribute("height", height)
+
+	img := image.NewPaletted(image.Rect(0, 0, width, height), geoTIFFPalette(request))
+	for i := range img.Pix {
+		img.Pix[i] = geoTIFFNoData
+	}
+	for _, f := range features {
+		index := uint8(geoTIFFMissingData)
+		if f.Class >= 0 {
+			index = uint8(f.Class)
+		}
+		for _, polygon := range geometryPolygons(f.Feature.Geometry) {
+			fillPolygon(img, polygon, index, west, north, resolution)
+		}
+	}
+
+	var buf bytes.Buffer
+	noData := uint8(geoTIFFNoData)
+	err := geotiff.Encode(&buf, img, geotiff.Georeference{West: west, North: north, PixelSize: resolution, NoData: &noData})
+	return buf.Bytes(), err
+}
+
+// geoTIFFPalette returns a palette of the colours of the request's breaks, in ascending order, followed by MissingDataColour for regions without data
+// and transparent for pixels outside all regions
+func geoTIFFPalette(request *models.RenderRequest) color.Palette {
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.RGBA{}
+	}
+	for i, b := range requestBreaks(request) {
+		if i < geoTIFFMissingData {
+			palette[i] = rgbColour(b.Colour)
+		}
+	}
+	palette[geoTIFFMissingData] = rgbColour(MissingDataColour)
+	return palette
+}
+
+// rgbColour converts the colour to an opaque color.RGBA, using MissingDataColour for colours that can't be parsed
+func rgbColour(colour string) color.RGBA {
+	r, g, b, ok := parseColour(colour)
+	if !ok {
+		r, g, b, _ = parseColour(MissingDataColour)
+	}
+	return color.RGBA{R: r, G: g, B: b, A: 255}
+}
+
+// fillPolygon sets each pixel whose centre is within the polygon (using the even-odd rule, so holes are not filled) to the palette index.
+// The top left of the image is at (west, north), with square pixels of the given size in degrees.
+func fillPolygon(img *image.Paletted, polygon [][][]float64, index uint8, west float64, north float64, pixelSize float64) {
+	width := img.Rect.Dx()
+	for row := 0; row < img.Rect.Dy(); row++ {
+		lat := north - (float64(row)+0.5)*pixelSize
+		var crossings []float64
+		for _, ring := range polygon {
+			for i := 1; i < len(ring); i++ {
+				a, b := ring[i-1], ring[i]
+				if (a[1] > lat) != (b[1] > lat) {
+					crossings = append(crossings, a[0]+(lat-a[1])/(b[1]-a[1])*(b[0]-a[0]))
+				}
+			}
+		}
+		sort.Float64s(crossings)
+		for i := 0; i+1 < len(crossings); i += 2 {
+			// the first and last pixel columns whose centres lie between the crossings
+			from := int(math.Ceil((crossings[i]-west)/pixelSize - 0.5))
+			to := int(math.Floor((crossings[i+1]-west)/pixelSize - 0.5))
+			if from < 0 {
+				from = 0
+			}
+			if to >= width {
+				to = width - 1
+			}
+			for col := from; col <= to; col++ {
+				img.Pix[img.PixOffset(col, row)] = index
+			}
+		}
+	}
+}
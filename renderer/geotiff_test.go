@@ -0,0 +1,47 @@
+package renderer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	. "github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/dp-map-renderer/testdata"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderGeoTIFF(t *testing.T) {
+	Convey("Given the example request", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Convey("RenderGeoTIFF should rasterise the regions' classes at the requested resolution", func() {
+			renderRequest.Resolution = 0.05
+			result, err := RenderGeoTIFF(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result[:4]), ShouldEqual, "II*\x00")
+
+			// the pixels immediately follow the 8 byte header, and the directory follows the pixels
+			ifd := binary.LittleEndian.Uint32(result[4:])
+			pixels := result[8:ifd]
+			counts := make(map[byte]int)
+			for _, p := range pixels {
+				counts[p]++
+			}
+			So(counts[255], ShouldBeGreaterThan, 0) // outside all regions
+			for class := range renderRequest.Choropleth.Breaks {
+				So(counts[byte(class)], ShouldBeGreaterThan, 0)
+			}
+		})
+
+		Convey("RenderGeoTIFF should reject a resolution that would produce too many pixels", func() {
+			renderRequest.Resolution = 0.0000001
+			_, err := RenderGeoTIFF(context.Background(), renderRequest)
+			So(err, ShouldHaveSameTypeAs, models.ValidationErrors{})
+		})
+	})
+}
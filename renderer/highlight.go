@@ -0,0 +1,119 @@
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/paulmach/go.geojson"
+)
+
+// HighlightLabelClass is the class of the labels of highlighted regions
+const HighlightLabelClass = "mapHighlightLabel"
+
+// defaultHighlightColour is the colour of the outline of highlighted regions if the highlight doesn't specify one
+const defaultHighlightColour = "black"
+
+// highlightStrokeWidth is the width of the outline of highlighted regions
+const highlightStrokeWidth = 2.0
+
+// highlightClassName returns the class added to highlighted regions - the region class with a BEM modifier, e.g. mapRegion--highlighted
+func highlightClassName(request *models.RenderRequest) string {
+	return regionClassName(request) + "--highlighted"
+}
+
+// highlightMatcher returns a matcher for the request's highlighted regions, or nil if it has none
+func highlightMatcher(request *models.RenderRequest) *featureMatcher {
+	if request.Highlight == nil || request.Geography == nil {
+		return nil
+	}
+	return newFeatureMatcher(&models.FeatureFilter{IDs: request.Highlight.IDs}, request.Geography)
+}
+
+// raiseHighlighted moves the highlighted features to the end of the features (otherwise keeping their order),
+// so that they're drawn above the other regions and their outlines aren't hidden by those of their neighbours.
+// Must be called before the feature ids are prefixed by setFeatureIDs.
+func raiseHighlighted(features []*geojson.Feature, request *models.RenderRequest) {
+	highlight := highlightMatcher(request)
+	if highlight == nil {
+		return
+	}
+	sort.SliceStable(features, func(i, j int) bool { return !highlight.matches(features[i]) && highlight.matches(features[j]) })
+}
+
+// setHighlights adds the highlight class and outline to the style of each highlighted feature, returning the highlighted features.
+// Must be called before the feature ids are prefixed by setFeatureIDs.
+func setHighlights(features []*geojson.Feature, request *models.RenderRequest) []*geojson.Feature {
+	highlight := highlightMatcher(request)
+	if highlight == nil {
+		return nil
+	}
+	colour := request.Highlight.Colour
+	if len(colour) == 0 {
+		colour = defaultHighlightColour
+	}
+	var highlighted []*geojson.Feature
+	for _, feature := range features {
+		if highlight.matches(feature) {
+			appendProperty(feature, "class", highlightClassName(request))
+			appendProperty(feature, "style", fmt.Sprintf("stroke: %s; stroke-width: %g;", colour, highlightStrokeWidth))
+			highlighted = append(highlighted, feature)
+		}
+	}
+	return highlighted
+}
+
+// renderHighlightLabels returns an overlay of the names of the highlighted features (from their data-label), each centred on its largest polygon,
+// scaled to the svg in the same way as the map. Returns an empty string if the highlighted regions aren't labelled.
+func renderHighlightLabels(svgRequest *SVGRequest, highlighted []*geojson.Feature) string {
+	if len(highlighted) == 0 || !svgRequest.request.Highlight.Label {
+		return ""
+	}
+	sf := svgRequest.svg.ScaleFunc(svgRequest.ViewBoxWidth, svgRequest.ViewBoxHeight, g2s.MercatorProjection)
+	buf := bytes.NewBufferString("")
+	for _, feature := range highlighted {
+		name, _ := feature.Properties[DataLabelAttribute].(string)
+		x, y, ok := labelPosition(sf, feature.Geometry)
+		if len(name) == 0 || !ok {
+			continue
+		}
+		fmt.Fprintf(buf, `<text class="%s" x="%f" y="%f" dy=".35em" style="text-anchor: middle; font-weight: bold; pointer-events: none; paint-order: stroke; stroke: white; stroke-width: 3;">%s</text>`,
+			HighlightLabelClass, x, y, html.EscapeString(name))
+	}
+	return buf.String()
+}
+
+// labelPosition returns the centroid of the exterior ring of the geometry's largest polygon, after scaling, and false if it has no polygons
+func labelPosition(sf g2s.ScaleFunc, g *geojson.Geometry) (float64, float64, bool) {
+	var x, y, largest float64
+	for _, polygon := range geometryPolygons(g) {
+		if len(polygon) == 0 {
+			continue
+		}
+		area, cx, cy := scaledRingCentroid(sf, polygon[0])
+		if math.Abs(area) > largest {
+			largest, x, y = math.Abs(area), cx, cy
+		}
+	}
+	return x, y, largest > 0
+}
+
+// scaledRingCentroid returns the signed area and the centroid of the ring, after scaling
+func scaledRingCentroid(sf g2s.ScaleFunc, ring [][]float64) (area float64, x float64, y float64) {
+	for i := range ring {
+		x0, y0 := sf(ring[i][0], ring[i][1])
+		x1, y1 := sf(ring[(i+1)%len(ring)][0], ring[(i+1)%len(ring)][1])
+		cross := x0*y1 - x1*y0
+		area += cross
+		x += (x0 + x1) * cross
+		y += (y0 + y1) * cross
+	}
+	if area == 0 {
+		return 0, 0, 0
+	}
+	return area / 2, x / (3 * area), y / (3 * area)
+}
@@ -2,14 +2,21 @@ package renderer
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 
 	"regexp"
 
+	"sort"
 	"strings"
+	"sync"
+	"unicode"
 
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
 	h "github.com/ONSdigital/dp-map-renderer/htmlutil"
 	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
 	"github.com/ONSdigital/go-ns/log"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
@@ -33,35 +40,52 @@ var (
 
 	// text that will need internationalising at some point:
 	sourceText         = "Source: "
+	sourcesText        = "Sources: "
+	sourceSeparator    = "; "
 	notesText          = "Notes"
 	footnoteHiddenText = "Footnote "
 )
 
 // RenderHTMLWithSVG returns an HTML figure element with caption and footer, and an SVG version of the map and (optional) legend
-func RenderHTMLWithSVG(request *models.RenderRequest) ([]byte, error) {
-	s := renderHTML(request)
-	result := renderSVGs(request, s)
-	return []byte(result), nil
+func RenderHTMLWithSVG(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	var buf bytes.Buffer
+	err := RenderHTMLWithSVGTo(ctx, &buf, request)
+	return buf.Bytes(), err
+}
+
+// RenderHTMLWithSVGTo writes the html of RenderHTMLWithSVG to w as each part is rendered, rather than assembling it in memory
+func RenderHTMLWithSVGTo(ctx context.Context, w io.Writer, request *models.RenderRequest) error {
+	s := renderHTML(ctx, request)
+	return writeSVGs(ctx, w, request, s)
 }
 
 // RenderHTMLWithPNG returns an HTML figure element with caption and footer, and a PNG version of the map and (optional) legend
-func RenderHTMLWithPNG(request *models.RenderRequest) ([]byte, error) {
+func RenderHTMLWithPNG(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	var buf bytes.Buffer
+	err := RenderHTMLWithPNGTo(ctx, &buf, request)
+	return buf.Bytes(), err
+}
+
+// RenderHTMLWithPNGTo writes the html of RenderHTMLWithPNG to w as each part is rendered, rather than assembling it in memory
+func RenderHTMLWithPNGTo(ctx context.Context, w io.Writer, request *models.RenderRequest) error {
 	request.IncludeFallbackPng = false
-	s := renderHTML(request)
-	result := renderPNGs(request, s)
-	return []byte(result), nil
+	s := renderHTML(ctx, request)
+	return writePNGs(ctx, w, request, s)
 }
 
 // renderHTML returns an HTML figure element with caption and footer, and divs with placeholder text for the map and legend
-func renderHTML(request *models.RenderRequest) string {
+func renderHTML(ctx context.Context, request *models.RenderRequest) string {
+	_, span := tracing.Start(ctx, "renderHTML")
+	defer span.End()
 	figure := createFigure(request)
 	svgContainer := h.CreateNode("div", atom.Div, h.Attr("class", "map_container"))
 	figure.AppendChild(svgContainer)
 	addCssPlaceholder(request, svgContainer)
 	addSVGDivs(request, svgContainer)
 	addFooter(request, figure)
-	var buf bytes.Buffer
-	html.Render(&buf, figure)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	html.Render(buf, figure)
 	buf.WriteString("\n")
 	return buf.String()
 }
@@ -70,31 +94,77 @@ func renderHTML(request *models.RenderRequest) string {
 func createFigure(request *models.RenderRequest) *html.Node {
 	figure := h.CreateNode("figure", atom.Figure,
 		h.Attr("class", "figure"),
-		h.Attr("id", idPrefix(request) + "-figure"),
+		h.Attr("id", idPrefix(request)+"-figure"),
 		"\n")
 	// add title and subtitle as a caption
-	if len(request.Title) > 0 || len(request.Subtitle) > 0 {
-		caption := h.CreateNode("figcaption", atom.Figcaption,
-			h.Attr("class", "map__caption"),
-			parseValue(request, request.Title))
-		if len(request.Subtitle) > 0 {
-			subtitle := h.CreateNode("span", atom.Span,
-				h.Attr("class", "map__subtitle"),
-				parseValue(request, request.Subtitle))
-
-			caption.AppendChild(h.CreateNode("br", atom.Br))
-			caption.AppendChild(subtitle)
-
-		}
+	if caption := createCaption(request); caption != nil {
 		figure.AppendChild(caption)
 		figure.AppendChild(h.Text("\n"))
 	}
 	return figure
 }
 
-// idPrefix returns the prefix that should be used for all ids
+// headingAtoms are the heading elements the title may be rendered as, indexed by the caption heading level
+var headingAtoms = []atom.Atom{0, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6}
+
+// createCaption creates a figcaption element with the title and subtitle, returning nil if there is neither.
+// If the request has a caption heading level, the title is a heading of that level and the subtitle a paragraph below it.
+func createCaption(request *models.RenderRequest) *html.Node {
+	if len(request.Title) == 0 && len(request.Subtitle) == 0 {
+		return nil
+	}
+	class := strings.TrimSpace("map__caption " + request.CaptionClass)
+	if request.CaptionHeadingLevel > 0 && request.CaptionHeadingLevel < len(headingAtoms) {
+		caption := h.CreateNode("figcaption", atom.Figcaption, h.Attr("class", class))
+		if len(request.Title) > 0 {
+			a := headingAtoms[request.CaptionHeadingLevel]
+			caption.AppendChild(h.CreateNode(a.String(), a, h.Attr("class", "map__title"), parseValue(request, request.Title)))
+		}
+		if len(request.Subtitle) > 0 {
+			caption.AppendChild(h.CreateNode("p", atom.P, h.Attr("class", "map__subtitle"), parseValue(request, request.Subtitle)))
+		}
+		return caption
+	}
+	caption := h.CreateNode("figcaption", atom.Figcaption,
+		h.Attr("class", class),
+		parseValue(request, request.Title))
+	if len(request.Subtitle) > 0 {
+		subtitle := h.CreateNode("span", atom.Span,
+			h.Attr("class", "map__subtitle"),
+			parseValue(request, request.Subtitle))
+
+		caption.AppendChild(h.CreateNode("br", atom.Br))
+		caption.AppendChild(subtitle)
+
+	}
+	return caption
+}
+
+// idPrefix returns the prefix that should be used for all ids - the request's IDPrefix if given, otherwise derived from its filename
 func idPrefix(request *models.RenderRequest) string {
-	return "map-" + request.Filename
+	if len(request.IDPrefix) > 0 {
+		return sanitiseID(request.IDPrefix)
+	}
+	if request.Minify {
+		return shortIDPrefix(request.Filename)
+	}
+	return sanitiseID("map-" + request.Filename)
+}
+
+// invalidIDCharacters matches the characters that aren't allowed in ids, so that they can be used unescaped in css selectors and urls
+var invalidIDCharacters = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// sanitiseID returns the id with invalid characters replaced by hyphens, prefixed with "map-" if it doesn't start with a letter.
+// If the id is changed, a hash of the original is appended so that different ids (e.g. "a b" and "a.b") remain different.
+func sanitiseID(id string) string {
+	sanitised := invalidIDCharacters.ReplaceAllString(id, "-")
+	if len(sanitised) == 0 || !unicode.IsLetter(rune(sanitised[0])) {
+		sanitised = "map-" + sanitised
+	}
+	if sanitised != id {
+		sanitised += "-" + shortHash(id)
+	}
+	return sanitised
 }
 
 // mapID returns the id for the map, as used in links etc
@@ -145,27 +215,38 @@ func addSVGDivs(request *models.RenderRequest, parent *html.Node) {
 
 // addFooter adds a footer to the given element, containing the source and footnotes
 func addFooter(request *models.RenderRequest, parent *html.Node) {
+	parent.AppendChild(createFooter(request))
+	parent.AppendChild(h.Text("\n"))
+}
+
+// createFooter creates a footer element containing the licence, source and footnotes
+func createFooter(request *models.RenderRequest) *html.Node {
 	footer := h.CreateNode("footer", atom.Footer,
 		h.Attr("class", "figure__footer"),
 		"\n")
-	if len(request.Licence) > 0 {
+	for _, licence := range request.AllLicences() {
 		footer.AppendChild(h.CreateNode("p", atom.P,
 			h.Attr("class", "figure__licence"),
-			request.Licence))
+			licence))
 		footer.AppendChild(h.Text("\n"))
 	}
-	if len(request.Source) > 0 {
-		var source interface{} = request.Source
-		if len(request.SourceLink) > 0 {
-			source = h.CreateNode("a", atom.A,
-				h.Attr("href", request.SourceLink),
-				request.Source)
+	if sources := request.AllSources(); len(sources) > 0 {
+		label := sourceText
+		if len(sources) > 1 {
+			label = sourcesText
 		}
-
-		footer.AppendChild(h.CreateNode("p", atom.P,
+		p := h.CreateNode("p", atom.P,
 			h.Attr("class", "figure__source"),
-			sourceText,
-			source))
+			label)
+		for i, source := range sources {
+			if i > 0 {
+				p.AppendChild(h.Text(sourceSeparator))
+			}
+			for _, node := range sourceNodes(request, source) {
+				p.AppendChild(node)
+			}
+		}
+		footer.AppendChild(p)
 		footer.AppendChild(h.Text("\n"))
 	}
 	if len(request.Footnotes) > 0 {
@@ -181,8 +262,7 @@ func addFooter(request *models.RenderRequest, parent *html.Node) {
 		footer.AppendChild(ol)
 		footer.AppendChild(h.Text("\n"))
 	}
-	parent.AppendChild(footer)
-	parent.AppendChild(h.Text("\n"))
+	return footer
 }
 
 // addFooterItemsToList adds one li node for each footnote to the given list node
@@ -202,25 +282,87 @@ func addCssPlaceholder(request *models.RenderRequest, parent *html.Node) {
 	parent.AppendChild(h.Text(cssReplacementText))
 }
 
-// renderSVGs replaces the SVG marker text with the actual SVG(s)
-func renderSVGs(request *models.RenderRequest, original string) string {
-	svgRequest := PrepareSVGRequest(request)
-	result := strings.Replace(original, svgReplacementText, "\n" + RenderSVG(svgRequest) + "\n", 1)
-	if strings.Contains(result, verticalKeyReplacementText) {
-		result = strings.Replace(result, verticalKeyReplacementText, "\n" + RenderVerticalKey(svgRequest) + "\n", 1)
+// writeSVGs writes the html to w, with the SVG marker text replaced by the actual SVG(s)
+func writeSVGs(ctx context.Context, w io.Writer, request *models.RenderRequest, original string) error {
+	return writeSVGPlaceholders(ctx, w, prepareSVGRequest(ctx, request), original)
+}
+
+// replaceSVGPlaceholders replaces the SVG marker text with the SVG(s) of the prepared request
+func replaceSVGPlaceholders(ctx context.Context, svgRequest *SVGRequest, original string) (string, error) {
+	result := getBuffer()
+	defer putBuffer(result)
+	err := writeSVGPlaceholders(ctx, result, svgRequest, original)
+	return result.String(), err
+}
+
+// writeSVGPlaceholders writes the html to w, with the SVG marker text replaced by the SVG(s) of the prepared request.
+// The legends don't depend on the map (or each other), so are rendered concurrently with it.
+// Nothing is written if a fallback image can't be created and the request should fail when that happens.
+func writeSVGPlaceholders(ctx context.Context, w io.Writer, svgRequest *SVGRequest, original string) error {
+	var wg sync.WaitGroup
+	var verticalKey, horizontalKey string
+	if strings.Contains(original, verticalKeyReplacementText) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verticalKey = traced(ctx, "RenderVerticalKey", RenderVerticalKey, svgRequest)
+		}()
+	}
+	if strings.Contains(original, horizontalKeyReplacementText) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			horizontalKey = traced(ctx, "RenderHorizontalKey", RenderHorizontalKey, svgRequest)
+		}()
+	}
+	svg := renderSVG(ctx, svgRequest)
+	wg.Wait()
+	if err := failedConversion(svgRequest); err != nil {
+		return err
+	}
+
+	return writeReplaced(w, original, map[string][]string{
+		svgReplacementText:           {"\n", svg, "\n"},
+		verticalKeyReplacementText:   {"\n", verticalKey, "\n"},
+		horizontalKeyReplacementText: {"\n", horizontalKey, "\n"},
+		cssReplacementText:           {renderCss(svgRequest)},
+	})
+}
+
+// writeReplaced writes the original to w, with each occurrence of a placeholder (a key of replacements) replaced by its parts, written in turn.
+// The parts are written separately so that large replacements (e.g. the svg) aren't copied.
+func writeReplaced(w io.Writer, original string, replacements map[string][]string) error {
+	var parts []string
+	for len(original) > 0 {
+		next, placeholder := -1, ""
+		for p := range replacements {
+			if i := strings.Index(original, p); i >= 0 && (next < 0 || i < next) {
+				next, placeholder = i, p
+			}
+		}
+		if next < 0 {
+			break
+		}
+		parts = append(parts, original[:next])
+		parts = append(parts, replacements[placeholder]...)
+		original = original[next+len(placeholder):]
 	}
-	if strings.Contains(result, horizontalKeyReplacementText) {
-		result = strings.Replace(result, horizontalKeyReplacementText, "\n" + RenderHorizontalKey(svgRequest) + "\n", 1)
+	parts = append(parts, original)
+	for _, part := range parts {
+		if _, err := io.WriteString(w, part); err != nil {
+			return err
+		}
 	}
-	result = strings.Replace(result, cssReplacementText, renderCss(svgRequest), 1)
-	return result
+	return nil
 }
 
 // renderCss creates a <script> block that has styles specific to this svg that allow it to be responsive and
 // switch between the horizontal and vertical legends according to window width
 func renderCss(svgRequest *SVGRequest) string {
 	id := idPrefix(svgRequest.request)
-	css := bytes.NewBufferString("\n<style type=\"text/css\">")
+	css := getBuffer()
+	defer putBuffer(css)
+	css.WriteString("\n<style type=\"text/css\">")
 	if svgRequest.responsiveSize {
 		// min/max width for svg
 		fmt.Fprintf(css, "\n\t#%s-map, #%s-legend-horizontal {", id, id)
@@ -244,7 +386,7 @@ func renderCss(svgRequest *SVGRequest) string {
 			// switch between both legends
 			switchPoint := svgRequest.ViewBoxWidth + svgRequest.VerticalLegendWidth
 
-			fmt.Fprintf(css, "\n\t@media (min-width: %.0fpx) {", switchPoint + 1.0)
+			fmt.Fprintf(css, "\n\t@media (min-width: %.0fpx) {", switchPoint+1.0)
 			fmt.Fprintf(css, "\n\t\t#%s-legend-horizontal { display: none;}", id)
 			fmt.Fprintf(css, "\n\t\t#%s-map { display: inline-block; width: %.0f%%;}", id, svgWidthPercent)
 			fmt.Fprintf(css, "\n\t\t#%s-legend-vertical { display: inline-block; width: %.0f%%; max-width: %.0fpx;}", id, vlWidthPercent, vlMaxWidth)
@@ -262,48 +404,70 @@ func renderCss(svgRequest *SVGRequest) string {
 		}
 	}
 
+	if svgRequest.request.IncludeStyles {
+		writeScopedStyles(css, svgRequest.request)
+	}
+
 	fmt.Fprintf(css, "\n</style>\n")
 	return css.String()
 }
 
-// renderPNGs replaces the SVG marker text with png images. It will not return a responsive design, and will ensure that only one of the legends is included.
-func renderPNGs(request *models.RenderRequest, original string) string {
-	svgRequest := PrepareSVGRequest(request)
+// writeScopedStyles writes styles for the caption, legends and region hover/focus highlighting, scoped to the request's figure,
+// for pages that don't include the site css
+func writeScopedStyles(css *bytes.Buffer, request *models.RenderRequest) {
+	id := idPrefix(request) + "-figure"
+	fmt.Fprintf(css, "\n\t#%s .map__caption { font-size: 150%%; font-weight: bold;}", id)
+	fmt.Fprintf(css, "\n\t#%s .map__subtitle { font-size: 75%%;}", id)
+	fmt.Fprintf(css, "\n\t#%s .map_key { vertical-align: top;}", id)
+	regionClass := regionClassName(request)
+	fmt.Fprintf(css, "\n\t#%s .%s { stroke: #323132; stroke-width: 0.5;}", id, regionClass)
+	fmt.Fprintf(css, "\n\t#%s .%s:hover, #%s .%s:focus { stroke: purple; stroke-width: 1.5; outline: none;}", id, regionClass, id, regionClass)
+}
+
+// writePNGs writes the html to w, with the SVG marker text replaced by png images. It will not return a responsive design, and will ensure that only one of the legends is included.
+// Nothing is written if an svg can't be converted and the request should fail when that happens.
+func writePNGs(ctx context.Context, w io.Writer, request *models.RenderRequest, original string) error {
+	svgRequest := prepareSVGRequest(ctx, request)
 	svgRequest.responsiveSize = false
 
-	svg := RenderSVG(svgRequest)
-	result := strings.Replace(original, svgReplacementText, renderPNG(svg), 1)
-	if strings.Contains(result, verticalKeyReplacementText) {
-		key := RenderVerticalKey(svgRequest)
-		result = strings.Replace(result, verticalKeyReplacementText, renderPNG(key), 1)
+	replacements := map[string][]string{
+		svgReplacementText:           {renderPNG(ctx, svgRequest, renderSVG(ctx, svgRequest))},
+		verticalKeyReplacementText:   {""},
+		horizontalKeyReplacementText: {""},
+		cssReplacementText:           {""},
 	}
-	if strings.Contains(result, horizontalKeyReplacementText) {
-		// only render horizontal if we won't have vertical
-		if hasVerticalLegend(request) {
-			result = strings.Replace(result, horizontalKeyReplacementText, "", 1)
-		} else {
-			key := RenderHorizontalKey(svgRequest)
-			result = strings.Replace(result, horizontalKeyReplacementText, renderPNG(key), 1)
-		}
+	if strings.Contains(original, verticalKeyReplacementText) {
+		key := traced(ctx, "RenderVerticalKey", RenderVerticalKey, svgRequest)
+		replacements[verticalKeyReplacementText] = []string{renderPNG(ctx, svgRequest, key)}
+	}
+	// only render horizontal if we won't have vertical
+	if strings.Contains(original, horizontalKeyReplacementText) && !hasVerticalLegend(request) {
+		key := traced(ctx, "RenderHorizontalKey", RenderHorizontalKey, svgRequest)
+		replacements[horizontalKeyReplacementText] = []string{renderPNG(ctx, svgRequest, key)}
+	}
+	if err := failedConversion(svgRequest); err != nil {
+		return err
 	}
-	result = strings.Replace(result, cssReplacementText, "", 1)
-	return result
+	return writeReplaced(w, original, replacements)
 }
 
-// renderPNG converts the given svg to a png, retaining the width and height attributes
-func renderPNG(svg string) string {
-	if pngConverter == nil {
+// renderPNG converts the given svg to a png (or the request's fallback image format), retaining the width and height attributes.
+// Returns the svg if it can't be converted, recording the error in the svgRequest.
+func renderPNG(ctx context.Context, svgRequest *SVGRequest, svg string) string {
+	converter, format := fallbackConverter(svgRequest.request)
+	if converter == nil {
 		log.Error(fmt.Errorf("pngConverter is nil - cannot convert svg to png"), nil)
 		return svg
 	}
 	png := svg
-	b64, err := pngConverter.Convert([]byte(svg))
+	b64, err := convertImage(ctx, converter, format, imageBackground(svgRequest.request, format), svg)
 	if err == nil {
 		width := widthPattern.FindString(svg)
 		height := heightPattern.FindString(svg)
-		png = fmt.Sprintf(`<img %s %s src="data:image/png;base64,%s" />`, width, height, string(b64))
+		png = fmt.Sprintf(`<img %s %s src="data:%s;base64,%s" />`, width, height, g2s.ImageMimeType(format), string(b64))
 	} else {
-		log.Error(err, log.Data{"_message": "Unable to convert svg to png"})
+		log.Error(err, log.Data{"_message": "Unable to convert svg to png", "format": format})
+		svgRequest.conversionFailures.record(err)
 	}
 	return png
 }
@@ -318,16 +482,69 @@ func parseValue(request *models.RenderRequest, value string) []*html.Node {
 	return []*html.Node{{Type: html.TextNode, Data: value}}
 }
 
-// replaceValues uses regexp to replace new lines and footnotes with <br/> and <a>.../<a> tags, then parses the result into an array of nodes
+// sourceNodes returns the text of the source, as a link if it has one. Footnote markers can't be nested within the link, so follow it.
+func sourceNodes(request *models.RenderRequest, source *models.Source) []*html.Node {
+	if len(source.Link) == 0 {
+		return parseValue(request, source.Text)
+	}
+	text, markerText := splitFootnoteMarkers(source.Text)
+	link := h.CreateNode("a", atom.A,
+		h.Attr("href", source.Link),
+		text)
+	link.Attr = append(link.Attr, linkAttributes(request.SourceLinkAttributes)...)
+	nodes := []*html.Node{link}
+	if len(markerText) > 0 {
+		nodes = append(nodes, parseValue(request, markerText)...)
+	}
+	return nodes
+}
+
+// linkAttributes returns the html attributes of a link with the given attributes (if any) - its target, rel and data attributes (sorted by name).
+// A link that opens in a new tab has a rel of "noopener noreferrer" unless otherwise given.
+func linkAttributes(attributes *models.LinkAttributes) []html.Attribute {
+	if attributes == nil {
+		return nil
+	}
+	var attrs []html.Attribute
+	if len(attributes.Target) > 0 {
+		attrs = append(attrs, h.Attr("target", attributes.Target))
+	}
+	rel := attributes.Rel
+	if len(rel) == 0 && attributes.Target == "_blank" {
+		rel = "noopener noreferrer"
+	}
+	if len(rel) > 0 {
+		attrs = append(attrs, h.Attr("rel", rel))
+	}
+	names := make([]string, 0, len(attributes.Data))
+	for name := range attributes.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attrs = append(attrs, h.Attr("data-"+name, attributes.Data[name]))
+	}
+	return attrs
+}
+
+// splitFootnoteMarkers returns the value with its footnote markers (e.g. "[1]") removed, and the markers
+func splitFootnoteMarkers(value string) (string, string) {
+	markers := strings.Join(footnoteLink.FindAllString(value, -1), "")
+	return strings.TrimSpace(footnoteLink.ReplaceAllString(value, "")), markers
+}
+
+// replaceValues uses regexp to replace new lines and footnotes with <br/> and <sup><a>.../<a></sup> tags, then parses the result into an array of nodes.
+// The value is escaped first, so that it can't contain any other markup.
 func replaceValues(request *models.RenderRequest, value string, hasBr bool, hasFootnote bool) []*html.Node {
 	original := value
+	value = html.EscapeString(value)
 	if hasBr {
 		value = newLine.ReplaceAllLiteralString(value, "<br />")
 	}
 	if hasFootnote {
 		for i := range request.Footnotes {
 			n := i + 1
-			linkText := fmt.Sprintf("<a href=\"#%s-note-%d\" class=\"footnote__link\"><span class=\"visuallyhidden\">%s</span>%d</a>", idPrefix(request), n, footnoteHiddenText, n)
+			linkText := fmt.Sprintf("<sup class=\"footnote__marker\"><a href=\"#%s-note-%d\" class=\"footnote__link\"><span class=\"visuallyhidden\">%s</span>%d</a></sup>", idPrefix(request), n, footnoteHiddenText, n)
 			value = strings.Replace(value, fmt.Sprintf("[%d]", n), linkText, -1)
 		}
 	}
@@ -2,16 +2,21 @@ package renderer_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"testing"
 
 	"fmt"
 
 	"strings"
 
+	"github.com/ONSdigital/dp-map-renderer/geojson2svg"
 	. "github.com/ONSdigital/dp-map-renderer/htmlutil"
 	"github.com/ONSdigital/dp-map-renderer/models"
 	"github.com/ONSdigital/dp-map-renderer/renderer"
 	"github.com/ONSdigital/dp-map-renderer/testdata"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
 	. "github.com/smartystreets/goconvey/convey"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
@@ -48,6 +53,41 @@ func TestRenderHTMLWithSVG(t *testing.T) {
 	})
 }
 
+func TestRenderHTMLWithSVGTo(t *testing.T) {
+
+	Convey("Rendering an html map to a writer should write the same html as RenderHTMLWithSVG", t, func() {
+		request := func() *models.RenderRequest {
+			renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+			So(err, ShouldBeNil)
+			renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionAfter
+			return renderRequest
+		}
+		expected, err := renderer.RenderHTMLWithSVG(context.Background(), request())
+		So(err, ShouldBeNil)
+
+		var buf bytes.Buffer
+		So(renderer.RenderHTMLWithSVGTo(context.Background(), &buf, request()), ShouldBeNil)
+		So(buf.String(), ShouldEqual, string(expected))
+		So(buf.String(), ShouldNotContainSubstring, "Here]")
+	})
+
+	Convey("An error writing the html should be returned", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		So(err, ShouldBeNil)
+		So(renderer.RenderHTMLWithSVGTo(context.Background(), failingWriter{}, renderRequest), ShouldEqual, errWriteFailed)
+	})
+}
+
+// errWriteFailed is returned by failingWriter
+var errWriteFailed = errors.New("write failed")
+
+// failingWriter is an io.Writer that always fails
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errWriteFailed
+}
+
 func TestRenderHTMLWithPNGWithVerticalLegend(t *testing.T) {
 
 	Convey("Successfully render a png image of the map with no horizontal legend", t, func() {
@@ -155,6 +195,87 @@ func TestRenderHTMLWithPNGWithHorizontalLegend(t *testing.T) {
 	})
 }
 
+func TestRenderHTMLWithPNGIsTraced(t *testing.T) {
+
+	Convey("Rendering a png map should create a span for each phase", t, func() {
+
+		renderer.UsePNGConverter(pngConverter)
+		recorder := &spanRecorder{}
+		tracing.UseTracer(recorder)
+		defer tracing.UseTracer(nil)
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.VerticalLegendPosition = "after"
+
+		invokeRenderHTMLWithPNG(renderRequest)
+
+		So(recorder.names, ShouldResemble, []string{"renderHTML", "PrepareSVGRequest", "RenderSVG", "ConvertPNG", "RenderVerticalKey", "ConvertPNG"})
+	})
+}
+
+// spanRecorder is a tracing.Tracer that records the names of the spans started
+type spanRecorder struct {
+	names []string
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	r.names = append(r.names, name)
+	return ctx, r
+}
+
+func (r *spanRecorder) SetAttribute(key string, value interface{}) {}
+func (r *spanRecorder) End()                                       {}
+
+func TestRenderHTML_ConversionFailure(t *testing.T) {
+
+	Convey("Given a converter that fails", t, func() {
+
+		renderer.UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "echo cannot convert >&2; exit 1"}))
+		defer renderer.UsePNGConverter(pngConverter)
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.IncludeFallbackPng = true
+		renderRequest.Choropleth.VerticalLegendPosition = "after"
+
+		Convey("By default the svg is rendered without a fallback image", func() {
+			result, err := renderer.RenderHTMLWithSVG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `id="map-`+renderRequest.Filename+`-map-svg"`)
+			So(string(result), ShouldNotContainSubstring, "<foreignObject>")
+			So(string(result), ShouldNotContainSubstring, "Unsupported Browser")
+		})
+
+		Convey("By default the png render includes the svg in place of the image", func() {
+			result, err := renderer.RenderHTMLWithPNG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, "<svg")
+			So(string(result), ShouldNotContainSubstring, "<img")
+		})
+
+		Convey("When the request should fail, the conversion error is returned and nothing is rendered", func() {
+			renderRequest.ConversionFailure = models.ConversionFailureFail
+
+			result, err := renderer.RenderHTMLWithSVG(context.Background(), renderRequest)
+			So(result, ShouldBeEmpty)
+			conversionError, ok := err.(*geojson2svg.ConversionError)
+			So(ok, ShouldBeTrue)
+			So(conversionError.Stderr, ShouldContainSubstring, "cannot convert")
+
+			result, err = renderer.RenderHTMLWithPNG(context.Background(), renderRequest)
+			So(result, ShouldBeEmpty)
+			So(err, ShouldHaveSameTypeAs, &geojson2svg.ConversionError{})
+		})
+	})
+}
+
 func TestRenderHTMLWithPNG_ConverterNotAvailable(t *testing.T) {
 
 	Convey("Return the svg version when a png converter is not available", t, func() {
@@ -299,6 +420,65 @@ func TestRenderHTML_BothLegends(t *testing.T) {
 		So(GetAttribute(key.NextSibling, "class"), ShouldContainSubstring, "vertical")
 		So(GetAttribute(key.NextSibling.NextSibling, "class"), ShouldEqual, "map")
 
+		// each legend and the map (rendered concurrently) are in their own div
+		prefix := "map-" + renderRequest.Filename
+		So(len(FindNodesWithAttributes(key, atom.Svg, map[string]string{"id": prefix + "-legend-horizontal-svg"})), ShouldEqual, 1)
+		So(len(FindNodesWithAttributes(key.NextSibling, atom.Svg, map[string]string{"id": prefix + "-legend-vertical-svg"})), ShouldEqual, 1)
+		So(len(FindNodesWithAttributes(key.NextSibling.NextSibling, atom.Svg, map[string]string{"id": prefix + "-map-svg"})), ShouldEqual, 1)
+
+	})
+}
+
+func TestRenderHTML_LegendInsideMap(t *testing.T) {
+
+	Convey("Should not create a legend div when the legend is positioned inside the map", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomRight
+		renderRequest.Choropleth.VerticalLegendPosition = "none"
+
+		container, result := invokeRenderHTMLWithSVG(renderRequest)
+
+		So(findNodeWithClass(container, atom.Div, "map_key__horizontal"), ShouldBeNil)
+		So(findNodeWithClass(container, atom.Div, "map_key__vertical"), ShouldBeNil)
+		So(len(FindAllNodes(container, atom.Svg)), ShouldEqual, 1)
+		So(result, ShouldContainSubstring, `<g id="map-abcd1234-legend-horizontal-overlay"`)
+	})
+}
+
+func TestRenderJSON(t *testing.T) {
+
+	Convey("Successfully render the parts of a map as json", t, func() {
+
+		renderer.UsePNGConverter(nil)
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionBefore
+		renderRequest.Choropleth.VerticalLegendPosition = ""
+
+		result, err := renderer.RenderJSON(context.Background(), renderRequest)
+		So(err, ShouldBeNil)
+
+		var response models.RenderResponse
+		So(json.Unmarshal(result, &response), ShouldBeNil)
+		So(response.Map, ShouldStartWith, "<svg")
+		So(response.HorizontalKey, ShouldStartWith, "<svg")
+		So(response.VerticalKey, ShouldBeEmpty)
+		So(response.CSS, ShouldStartWith, "\n<style")
+		So(response.Caption, ShouldStartWith, "<figcaption")
+		So(response.Footer, ShouldStartWith, "<footer")
+		So(response.Metadata.ID, ShouldEqual, "map-"+renderRequest.Filename)
+		So(response.Metadata.Width, ShouldEqual, 400)
+		So(response.Metadata.Height, ShouldBeGreaterThan, 0)
+		So(response.Metadata.FeatureCount, ShouldBeGreaterThan, 0)
+		So(response.Metadata.DataRowCount, ShouldEqual, len(renderRequest.Data))
 	})
 }
 
@@ -397,7 +577,6 @@ func TestRenderCssWithBothLegends(t *testing.T) {
 	})
 }
 
-
 func TestRenderHTMLWithNoSVG(t *testing.T) {
 
 	Convey("Successfully render an html response when no geography provided", t, func() {
@@ -427,6 +606,33 @@ func TestRenderHTMLWithNoSVG(t *testing.T) {
 	})
 }
 
+func TestRenderHTML_Caption(t *testing.T) {
+
+	Convey("A renderRequest with a title and subtitle should have a figcaption containing both", t, func() {
+		request := models.RenderRequest{Filename: "myId", Title: "myTitle", Subtitle: "mySubtitle"}
+		container, _ := invokeRenderHTMLWithSVG(&request)
+
+		caption := FindNodeWithAttributes(container, atom.Figcaption, map[string]string{"class": "map__caption"})
+		So(caption, ShouldNotBeNil)
+		So(caption.FirstChild.Data, ShouldEqual, "myTitle")
+		So(FindNodeWithAttributes(caption, atom.Span, map[string]string{"class": "map__subtitle"}), ShouldNotBeNil)
+	})
+
+	Convey("A renderRequest with a caption heading level and class should have the title as a heading in a figcaption with the class", t, func() {
+		request := models.RenderRequest{Filename: "myId", Title: "myTitle", Subtitle: "mySubtitle", CaptionHeadingLevel: 3, CaptionClass: "my-caption"}
+		container, _ := invokeRenderHTMLWithSVG(&request)
+
+		caption := FindNodeWithAttributes(container, atom.Figcaption, map[string]string{"class": "map__caption my-caption"})
+		So(caption, ShouldNotBeNil)
+		heading := FindNodeWithAttributes(caption, atom.H3, map[string]string{"class": "map__title"})
+		So(heading, ShouldNotBeNil)
+		So(heading.FirstChild.Data, ShouldEqual, "myTitle")
+		subtitle := FindNodeWithAttributes(caption, atom.P, map[string]string{"class": "map__subtitle"})
+		So(subtitle, ShouldNotBeNil)
+		So(subtitle.FirstChild.Data, ShouldEqual, "mySubtitle")
+	})
+}
+
 func TestRenderHTML_Source(t *testing.T) {
 
 	Convey("A renderRequest without a source should not have a source paragraph", t, func() {
@@ -461,6 +667,44 @@ func TestRenderHTML_Source(t *testing.T) {
 		So(link, ShouldNotBeNil)
 		So(link.FirstChild.Data, ShouldResemble, request.Source)
 	})
+
+	Convey("A source link that opens in a new tab should have the given data attributes and a noopener rel", t, func() {
+		request := models.RenderRequest{Filename: "myId", Source: "mySource", SourceLink: "http://foo/bar",
+			SourceLinkAttributes: &models.LinkAttributes{Target: "_blank", Data: map[string]string{"gtm-label": "source"}}}
+		container, _ := invokeRenderHTMLWithSVG(&request)
+
+		link := FindNodeWithAttributes(container, atom.A, map[string]string{"href": "http://foo/bar"})
+		So(link, ShouldNotBeNil)
+		So(GetAttribute(link, "target"), ShouldEqual, "_blank")
+		So(GetAttribute(link, "rel"), ShouldEqual, "noopener noreferrer")
+		So(GetAttribute(link, "data-gtm-label"), ShouldEqual, "source")
+	})
+
+	Convey("A source link with a rel should keep it", t, func() {
+		request := models.RenderRequest{Filename: "myId", Source: "mySource", SourceLink: "http://foo/bar",
+			SourceLinkAttributes: &models.LinkAttributes{Target: "_blank", Rel: "external"}}
+		container, _ := invokeRenderHTMLWithSVG(&request)
+
+		link := FindNodeWithAttributes(container, atom.A, map[string]string{"href": "http://foo/bar"})
+		So(link, ShouldNotBeNil)
+		So(GetAttribute(link, "rel"), ShouldEqual, "external")
+	})
+
+	Convey("A renderRequest with several sources should list them in one source paragraph, each with its own link", t, func() {
+		request := models.RenderRequest{Filename: "myId", Source: "Census 2011", SourceLink: "http://foo/census",
+			Sources:              []*models.Source{{Text: "Annual Population Survey", Link: "http://foo/aps"}, {Text: "Local authorities"}},
+			SourceLinkAttributes: &models.LinkAttributes{Target: "_blank"}}
+		container, _ := invokeRenderHTMLWithSVG(&request)
+
+		source := FindNodeWithAttributes(container, atom.P, map[string]string{"class": "figure__source"})
+		So(source, ShouldNotBeNil)
+		So(GetText(source), ShouldEqual, "Sources: Census 2011; Annual Population Survey; Local authorities")
+		links := FindNodes(source, atom.A)
+		So(len(links), ShouldEqual, 2)
+		So(GetAttribute(links[0], "href"), ShouldEqual, "http://foo/census")
+		So(GetAttribute(links[1], "href"), ShouldEqual, "http://foo/aps")
+		So(GetAttribute(links[1], "target"), ShouldEqual, "_blank")
+	})
 }
 
 func TestRenderHTML_Licence(t *testing.T) {
@@ -484,6 +728,16 @@ func TestRenderHTML_Licence(t *testing.T) {
 		So(licence, ShouldNotBeNil)
 		So(licence.FirstChild.Data, ShouldResemble, request.Licence)
 	})
+
+	Convey("A renderRequest with several licences should have a licence paragraph for each", t, func() {
+		request := models.RenderRequest{Filename: "myId", Licence: "© Crown copyright 2015", Licences: []string{"Contains OS data © Crown copyright 2015"}}
+		container, _ := invokeRenderHTMLWithSVG(&request)
+
+		licences := FindNodesWithAttributes(container, atom.P, map[string]string{"class": "figure__licence"})
+		So(len(licences), ShouldEqual, 2)
+		So(licences[0].FirstChild.Data, ShouldEqual, request.Licence)
+		So(licences[1].FirstChild.Data, ShouldEqual, request.Licences[0])
+	})
 }
 
 func TestRenderHTML_Footer(t *testing.T) {
@@ -521,6 +775,33 @@ func TestRenderHTML_Footer(t *testing.T) {
 		}
 	})
 
+	Convey("Footnote markers in the title and source should render as superscript links to the footnotes", t, func() {
+		request := models.RenderRequest{Filename: "myId", Title: "myTitle[1]", Source: "mySource[2]", Footnotes: []string{"Note1", "Note2"}}
+		container, _ := invokeRenderHTMLWithSVG(&request)
+
+		caption := FindNode(container, atom.Figcaption)
+		marker := FindNodeWithAttributes(caption, atom.Sup, map[string]string{"class": "footnote__marker"})
+		So(marker, ShouldNotBeNil)
+		So(FindNodeWithAttributes(marker, atom.A, map[string]string{"href": "#map-myId-note-1"}), ShouldNotBeNil)
+
+		source := FindNodeWithAttributes(container, atom.P, map[string]string{"class": "figure__source"})
+		marker = FindNodeWithAttributes(source, atom.Sup, map[string]string{"class": "footnote__marker"})
+		So(marker, ShouldNotBeNil)
+		So(FindNodeWithAttributes(marker, atom.A, map[string]string{"href": "#map-myId-note-2"}), ShouldNotBeNil)
+	})
+
+	Convey("Footnote markers in a linked source should follow the link", t, func() {
+		request := models.RenderRequest{Filename: "myId", Source: "mySource [1]", SourceLink: "http://foo/bar", Footnotes: []string{"Note1"}}
+		container, _ := invokeRenderHTMLWithSVG(&request)
+
+		source := FindNodeWithAttributes(container, atom.P, map[string]string{"class": "figure__source"})
+		link := FindNodeWithAttributes(source, atom.A, map[string]string{"href": "http://foo/bar"})
+		So(link, ShouldNotBeNil)
+		So(link.FirstChild.Data, ShouldEqual, "mySource")
+		So(link.NextSibling, ShouldNotBeNil)
+		So(GetAttribute(link.NextSibling, "class"), ShouldEqual, "footnote__marker")
+	})
+
 	Convey("Footnotes should be properly parsed", t, func() {
 		request := models.RenderRequest{Filename: "myId", Footnotes: []string{"Note1", "Note2\nOn Two Lines"}}
 		_, result := invokeRenderHTMLWithSVG(&request)
@@ -530,7 +811,7 @@ func TestRenderHTML_Footer(t *testing.T) {
 }
 
 func invokeRenderHTMLWithSVG(renderRequest *models.RenderRequest) (*html.Node, string) {
-	response, err := renderer.RenderHTMLWithSVG(renderRequest)
+	response, err := renderer.RenderHTMLWithSVG(context.Background(), renderRequest)
 	So(err, ShouldBeNil)
 	nodes, err := html.ParseFragment(bytes.NewReader([]byte(response)), &html.Node{
 		Type:     html.ElementNode,
@@ -546,7 +827,7 @@ func invokeRenderHTMLWithSVG(renderRequest *models.RenderRequest) (*html.Node, s
 }
 
 func invokeRenderHTMLWithPNG(renderRequest *models.RenderRequest) (*html.Node, string) {
-	response, err := renderer.RenderHTMLWithPNG(renderRequest)
+	response, err := renderer.RenderHTMLWithPNG(context.Background(), renderRequest)
 	So(err, ShouldBeNil)
 	nodes, err := html.ParseFragment(bytes.NewReader([]byte(response)), &html.Node{
 		Type:     html.ElementNode,
@@ -582,3 +863,343 @@ func findNodesWithClass(parent *html.Node, a atom.Atom, class string) []*html.No
 	}
 	return result
 }
+
+func TestRenderSmallMultiples(t *testing.T) {
+
+	Convey("Successfully render a grid of maps, one per series, with a single legend", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionAfter
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionBefore
+		renderRequest.Series = []*models.DataSeries{
+			{ID: "2016", Title: "2016", Data: renderRequest.Data},
+			{ID: "2017", Data: renderRequest.Data[:10]},
+			{ID: "2018", Title: "2018", Data: renderRequest.Data[10:]},
+		}
+
+		response, err := renderer.RenderSmallMultiples(context.Background(), renderRequest)
+		So(err, ShouldBeNil)
+		nodes, err := html.ParseFragment(bytes.NewReader(response), &html.Node{
+			Type:     html.ElementNode,
+			Data:     "body",
+			DataAtom: atom.Body,
+		})
+		So(err, ShouldBeNil)
+		container := nodes[0]
+		prefix := "map-" + renderRequest.Filename
+
+		So(GetAttribute(container, "id"), ShouldEqual, prefix+"-figure")
+		grid := FindNodeWithAttributes(container, atom.Div, map[string]string{"id": prefix + "-facets"})
+		So(grid, ShouldNotBeNil)
+
+		facets := FindNodesWithAttributes(grid, atom.Div, map[string]string{"class": "map_facet"})
+		So(len(facets), ShouldEqual, 3)
+		for i, facet := range facets {
+			So(GetAttribute(facet, "id"), ShouldEqual, fmt.Sprintf("%s-facet-%d", prefix, i+1))
+			svg := FindNode(facet, atom.Svg)
+			So(svg, ShouldNotBeNil)
+			So(GetAttribute(svg, "id"), ShouldEqual, fmt.Sprintf("%s-facet-%d-map-svg", prefix, i+1))
+		}
+		So(GetText(FindNode(facets[1], atom.P)), ShouldEqual, "2017")
+
+		So(len(FindNodesWithAttributes(container, atom.Svg, map[string]string{"id": prefix + "-legend-horizontal-svg"})), ShouldEqual, 1)
+		So(string(response), ShouldNotContainSubstring, "legend-vertical")
+		So(string(response), ShouldContainSubstring, "grid-template-columns")
+
+		So(FindNode(container, atom.Footer), ShouldNotBeNil)
+	})
+}
+
+func TestRenderComparison(t *testing.T) {
+
+	Convey("Given a request with two data series", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionBefore
+		renderRequest.Series = []*models.DataSeries{
+			{ID: "before", Title: "Before", Data: renderRequest.Data},
+			{ID: "after", Title: "After", Data: renderRequest.Data[:10]},
+		}
+		prefix := "map-" + renderRequest.Filename
+
+		invokeRenderComparison := func() (*html.Node, string) {
+			response, err := renderer.RenderComparison(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			nodes, err := html.ParseFragment(bytes.NewReader(response), &html.Node{
+				Type:     html.ElementNode,
+				Data:     "body",
+				DataAtom: atom.Body,
+			})
+			So(err, ShouldBeNil)
+			return nodes[0], string(response)
+		}
+
+		Convey("Side by side maps are rendered in a grid with a single legend", func() {
+			container, response := invokeRenderComparison()
+
+			comparison := FindNodeWithAttributes(container, atom.Div, map[string]string{"id": prefix + "-comparison"})
+			So(comparison, ShouldNotBeNil)
+			So(GetAttribute(comparison, "class"), ShouldEqual, "map_comparison map_comparison__side-by-side")
+			So(len(FindNodes(comparison, atom.Svg)), ShouldEqual, 2)
+			So(len(FindNodesWithAttributes(container, atom.Svg, map[string]string{"id": prefix + "-legend-horizontal-svg"})), ShouldEqual, 1)
+			So(FindNode(container, atom.Input), ShouldBeNil)
+			So(response, ShouldContainSubstring, "grid-template-columns")
+		})
+
+		Convey("The toggle mode adds a radio button for each series", func() {
+			renderRequest.ComparisonMode = models.ComparisonModeToggle
+			container, response := invokeRenderComparison()
+
+			inputs := FindNodesWithAttributes(container, atom.Input, map[string]string{"type": "radio"})
+			So(len(inputs), ShouldEqual, 2)
+			So(GetAttribute(inputs[0], "checked"), ShouldEqual, "checked")
+			So(GetText(FindNodeWithAttributes(container, atom.Label, map[string]string{"for": prefix + "-compare-2"})), ShouldEqual, "After")
+			So(response, ShouldContainSubstring, fmt.Sprintf("#%s-compare-1:checked ~ #%s-comparison #%s-facet-2", prefix, prefix, prefix))
+		})
+
+		Convey("The slider mode adds a range input that reveals the second map", func() {
+			renderRequest.ComparisonMode = models.ComparisonModeSlider
+			container, response := invokeRenderComparison()
+
+			slider := FindNodeWithAttributes(container, atom.Input, map[string]string{"type": "range"})
+			So(slider, ShouldNotBeNil)
+			So(GetAttribute(slider, "aria-label"), ShouldEqual, "Compare Before with After")
+			So(GetAttribute(slider, "oninput"), ShouldContainSubstring, prefix+"-facet-2")
+			So(response, ShouldContainSubstring, "clip-path: inset(0 0 0 50%);")
+		})
+	})
+
+	Convey("A comparison without two data series is rejected", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := renderer.RenderComparison(context.Background(), renderRequest)
+		So(response, ShouldBeNil)
+		So(err, ShouldHaveSameTypeAs, models.ValidationErrors{})
+	})
+}
+
+func TestRenderDifference(t *testing.T) {
+
+	Convey("Given a request with two data series", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionAfter
+		renderRequest.Choropleth.ValueSuffix = ""
+		changed := []*models.DataRow{}
+		for _, row := range renderRequest.Data[:5] {
+			changed = append(changed, &models.DataRow{ID: row.ID, Value: row.Value * 1.5})
+		}
+		renderRequest.Series = []*models.DataSeries{
+			{ID: "before", Data: renderRequest.Data},
+			{ID: "after", Data: changed},
+		}
+
+		Convey("The percentage difference is mapped, with regions missing from either series shown as missing data", func() {
+			renderRequest.DifferenceMode = models.DifferenceModePercentage
+			response, err := renderer.RenderDifference(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			result := string(response)
+
+			So(strings.Count(result, " 50%</title>"), ShouldEqual, 5)
+			So(strings.Count(result, renderer.MissingDataText+"</title>"), ShouldBeGreaterThan, 0)
+			So(result, ShouldContainSubstring, "No change")
+		})
+
+		Convey("The absolute difference is mapped", func() {
+			response, err := renderer.RenderDifference(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(response), ShouldContainSubstring, fmt.Sprintf(" %g</title>", renderRequest.Data[0].Value*0.5))
+		})
+	})
+
+	Convey("A difference without two data series is rejected", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := renderer.RenderDifference(context.Background(), renderRequest)
+		So(response, ShouldBeNil)
+		So(err, ShouldHaveSameTypeAs, models.ValidationErrors{})
+	})
+}
+
+func TestRenderAnimation(t *testing.T) {
+
+	Convey("Successfully render a map that steps through each series", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.ValueSuffix = ""
+		later := []*models.DataRow{}
+		for _, row := range renderRequest.Data {
+			later = append(later, &models.DataRow{ID: row.ID, Value: row.Value + 100})
+		}
+		renderRequest.Series = []*models.DataSeries{
+			{ID: "2015", Data: renderRequest.Data},
+			{ID: "2016", Data: later[1:]},
+		}
+		prefix := "map-" + renderRequest.Filename
+
+		response, err := renderer.RenderAnimation(context.Background(), renderRequest)
+		So(err, ShouldBeNil)
+		nodes, err := html.ParseFragment(bytes.NewReader(response), &html.Node{
+			Type:     html.ElementNode,
+			Data:     "body",
+			DataAtom: atom.Body,
+		})
+		So(err, ShouldBeNil)
+		container := nodes[0]
+
+		So(len(FindNodes(container, atom.Svg)), ShouldBeGreaterThanOrEqualTo, 1)
+		button := FindNodeWithAttributes(container, atom.Button, map[string]string{"id": prefix + "-play"})
+		So(button, ShouldNotBeNil)
+		So(GetText(button), ShouldEqual, "Play")
+		So(GetText(FindNodeWithAttributes(container, atom.Span, map[string]string{"id": prefix + "-period"})), ShouldEqual, "2015")
+
+		script := FindNode(container, atom.Script)
+		So(script, ShouldNotBeNil)
+		So(GetText(script), ShouldContainSubstring, `"periods":["2015","2016"]`)
+		first := renderRequest.Data[0]
+		So(GetText(script), ShouldContainSubstring, fmt.Sprintf(`"titles":["Hartlepool %g","Hartlepool %s"]`, first.Value, renderer.MissingDataText))
+		So(GetText(script), ShouldContainSubstring, fmt.Sprintf(`"url(#%s-nodata)"]`, prefix))
+	})
+}
+
+func TestRenderHTMLWithSVGIncludesScopedStyles(t *testing.T) {
+
+	Convey("Given an html map request", t, func() {
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		focusRule := fmt.Sprintf("#map-%s-figure .mapRegion:hover, #map-%s-figure .mapRegion:focus {", renderRequest.Filename, renderRequest.Filename)
+
+		Convey("Styles are not included by default", func() {
+			container, response := invokeRenderHTMLWithSVG(renderRequest)
+			So(GetText(FindNode(container, atom.Style)), ShouldNotContainSubstring, focusRule)
+			So(response, ShouldNotContainSubstring, `tabindex="0"`)
+		})
+
+		Convey("Scoped hover and focus styles are included, and regions are focusable, when requested", func() {
+			renderRequest.IncludeStyles = true
+			container, response := invokeRenderHTMLWithSVG(renderRequest)
+			So(GetText(FindNode(container, atom.Style)), ShouldContainSubstring, focusRule)
+			So(strings.Count(response, `tabindex="0"`), ShouldBeGreaterThan, 0)
+		})
+
+		Convey("Scoped styles use the request's region class", func() {
+			renderRequest.IncludeStyles = true
+			renderRequest.RegionClass = "map__region"
+			container, response := invokeRenderHTMLWithSVG(renderRequest)
+			So(GetText(FindNode(container, atom.Style)), ShouldContainSubstring, fmt.Sprintf("#map-%s-figure .map__region:hover", renderRequest.Filename))
+			So(response, ShouldContainSubstring, `class="map__region"`)
+			So(response, ShouldNotContainSubstring, "mapRegion")
+		})
+	})
+}
+
+func TestRenderHTMLWithIDPrefix(t *testing.T) {
+	Convey("Given the example request", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Convey("An id prefix should replace the prefix derived from the filename", func() {
+			renderRequest.IDPrefix = "census-map"
+			result, err := renderer.RenderHTMLWithSVG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldNotContainSubstring, "map-abcd1234")
+			So(string(result), ShouldContainSubstring, `id="census-map-map-svg"`)
+		})
+
+		Convey("Invalid characters should be replaced, keeping different ids distinct", func() {
+			renderRequest.IDPrefix = "1 map"
+			first, err := renderer.RenderHTMLWithSVG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(first), ShouldNotContainSubstring, `"1 map`)
+			So(string(first), ShouldContainSubstring, `id="map-1-map-`)
+
+			renderRequest.IDPrefix = "1.map"
+			second, err := renderer.RenderHTMLWithSVG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(regexp.MustCompile(`id="(map-1-map-\w+)-map-svg"`).FindStringSubmatch(string(first))[1], ShouldNotEqual,
+				regexp.MustCompile(`id="(map-1-map-\w+)-map-svg"`).FindStringSubmatch(string(second))[1])
+		})
+
+		Convey("Invalid characters in the filename should be replaced", func() {
+			renderRequest.Filename = `a"b`
+			result, err := renderer.RenderHTMLWithSVG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldNotContainSubstring, `map-a"b`)
+			So(string(result), ShouldContainSubstring, `id="map-a-b-`)
+		})
+	})
+}
+
+func TestRenderHTMLEscapesHostileInput(t *testing.T) {
+	Convey("Given a request with markup in its text and topology properties", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hostile := `<script>alert(1)</script>"><img src=x onerror=alert(1)>`
+		renderRequest.Title = "Title\n" + hostile + "[1]"
+		renderRequest.Subtitle = hostile
+		renderRequest.Source = hostile
+		renderRequest.Licence = hostile
+		renderRequest.Footnotes = []string{hostile}
+		renderRequest.Choropleth.ValuePrefix = hostile
+		renderRequest.Choropleth.ReferenceValueText = hostile
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionAfter
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionAfter
+		for _, o := range renderRequest.Geography.Topojson.Objects {
+			for _, g := range o.Geometries {
+				g.Properties[renderRequest.Geography.NameProperty] = hostile
+				g.Properties["class"] = hostile
+			}
+			o.Geometries[0].ID = hostile
+			o.Geometries[0].Properties[renderRequest.Geography.IDProperty[0]] = hostile
+		}
+
+		Convey("No markup should be injected into the html or svg", func() {
+			result, err := renderer.RenderHTMLWithSVG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			s := string(result)
+			So(s, ShouldNotContainSubstring, "<script>alert")
+			So(s, ShouldNotContainSubstring, "<img src=x")
+			So(s, ShouldContainSubstring, "&lt;script&gt;alert(1)&lt;/script&gt;")
+
+			Convey("And the footnote link and line break in the title should still be rendered", func() {
+				So(s, ShouldContainSubstring, `class="footnote__link"`)
+				So(s, ShouldContainSubstring, "Title<br/>")
+			})
+		})
+
+		Convey("No markup should be injected into the standalone svg", func() {
+			renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideTopLeft
+			result, err := renderer.RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldNotContainSubstring, "<script>alert")
+			So(string(result), ShouldNotContainSubstring, "<img src=x")
+		})
+	})
+}
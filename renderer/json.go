@@ -0,0 +1,56 @@
+package renderer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"golang.org/x/net/html"
+)
+
+// RenderJSON returns a json models.RenderResponse, with the svg map, legends, css, caption and footer rendered separately so that clients can compose them
+func RenderJSON(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	svgRequest := prepareSVGRequest(ctx, request)
+
+	response := &models.RenderResponse{
+		Map:     renderSVG(ctx, svgRequest),
+		CSS:     renderCss(svgRequest),
+		Caption: renderNode(createCaption(request)),
+		Footer:  renderNode(createFooter(request)),
+		Metadata: &models.RenderMetadata{
+			ID:           idPrefix(request),
+			Width:        svgRequest.ViewBoxWidth,
+			Height:       svgRequest.ViewBoxHeight,
+			Responsive:   svgRequest.responsiveSize,
+			DataRowCount: len(request.Data),
+			Join:         request.JoinDiagnostics(),
+		},
+	}
+	response.Metadata.Warnings = response.Metadata.Join.Warnings()
+	if svgRequest.geoJSON != nil {
+		response.Metadata.FeatureCount = len(svgRequest.geoJSON.Features)
+	}
+	if hasHorizontalLegend(request) {
+		response.HorizontalKey = traced(ctx, "RenderHorizontalKey", RenderHorizontalKey, svgRequest)
+	}
+	if hasVerticalLegend(request) {
+		response.VerticalKey = traced(ctx, "RenderVerticalKey", RenderVerticalKey, svgRequest)
+		response.Metadata.VerticalKeyWidth = svgRequest.VerticalLegendWidth
+	}
+	if err := failedConversion(svgRequest); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(response)
+}
+
+// renderNode renders the html node as a string, returning an empty string if node is nil
+func renderNode(node *html.Node) string {
+	if node == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	html.Render(&buf, node)
+	return buf.String()
+}
@@ -0,0 +1,143 @@
+package renderer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
+	"github.com/paulmach/go.geojson"
+)
+
+// kmlMissingStyle is the id of the style of regions without data
+const kmlMissingStyle = "nodata"
+
+// RenderKML returns a KML document with a placemark for each region, styled by its choropleth class and described by its value, for viewing in e.g. Google Earth.
+func RenderKML(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	_, span := tracing.Start(ctx, "RenderKML")
+	defer span.End()
+	return renderKML(request), nil
+}
+
+// RenderKMZ returns the KML document rendered by RenderKML, compressed in a KMZ (zip) archive
+func RenderKMZ(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	kml, err := RenderKML(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	w, err := archive.Create("doc.kml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(kml); err != nil {
+		return nil, err
+	}
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderKML(request *models.RenderRequest) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2">` + "\n<Document>\n")
+	writeKMLElement(&buf, "name", request.Title)
+	writeKMLElement(&buf, "description", request.Subtitle)
+
+	for i, b := range requestBreaks(request) {
+		writeKMLStyle(&buf, fmt.Sprintf("class-%d", i), b.Colour)
+	}
+	writeKMLStyle(&buf, kmlMissingStyle, MissingDataColour)
+
+	for _, f := range classifyFeatures(request) {
+		buf.WriteString("<Placemark>\n")
+		writeKMLElement(&buf, "name", f.Name)
+		style := kmlMissingStyle
+		description := missingDataText(request.Choropleth)
+		if f.Value != nil {
+			description = formatValue(request.Choropleth, *f.Value)
+		}
+		if f.Class >= 0 {
+			style = fmt.Sprintf("class-%d", f.Class)
+		}
+		writeKMLElement(&buf, "description", description)
+		writeKMLElement(&buf, "styleUrl", "#"+style)
+		buf.WriteString("<ExtendedData>\n")
+		writeKMLData(&buf, "id", f.ID)
+		if f.Value != nil {
+			writeKMLData(&buf, "value", strconv.FormatFloat(*f.Value, 'g', -1, 64))
+		}
+		buf.WriteString("</ExtendedData>\n")
+		writeKMLGeometry(&buf, f.Feature.Geometry)
+		buf.WriteString("</Placemark>\n")
+	}
+
+	buf.WriteString("</Document>\n</kml>\n")
+	return buf.Bytes()
+}
+
+// writeKMLElement writes an element with the given (escaped) text content, or nothing if the text is empty
+func writeKMLElement(buf *bytes.Buffer, name string, text string) {
+	if len(text) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "<%s>", name)
+	xml.EscapeText(buf, []byte(text))
+	fmt.Fprintf(buf, "</%s>\n", name)
+}
+
+// writeKMLData writes a Data element of ExtendedData
+func writeKMLData(buf *bytes.Buffer, name string, value string) {
+	fmt.Fprintf(buf, `<Data name="%s">`, name)
+	writeKMLElement(buf, "value", value)
+	buf.WriteString("</Data>\n")
+}
+
+// writeKMLStyle writes a style with the given id, filling polygons with the colour and outlining them in white
+func writeKMLStyle(buf *bytes.Buffer, id string, colour string) {
+	fmt.Fprintf(buf, "<Style id=\"%s\"><LineStyle><color>ffffffff</color><width>1</width></LineStyle><PolyStyle><color>%s</color></PolyStyle></Style>\n", id, kmlColour(colour))
+}
+
+// kmlColour converts the colour into KML's aabbggrr form. Colours that can't be parsed are shown as MissingDataColour.
+func kmlColour(colour string) string {
+	r, g, b, ok := parseColour(colour)
+	if !ok {
+		r, g, b, _ = parseColour(MissingDataColour)
+	}
+	return fmt.Sprintf("ff%02x%02x%02x", b, g, r)
+}
+
+// writeKMLGeometry writes the polygons of a polygon or multipolygon geometry in a MultiGeometry. Other geometries are ignored.
+func writeKMLGeometry(buf *bytes.Buffer, g *geojson.Geometry) {
+	polygons := geometryPolygons(g)
+	if len(polygons) == 0 {
+		return
+	}
+	buf.WriteString("<MultiGeometry>\n")
+	for _, polygon := range polygons {
+		buf.WriteString("<Polygon>")
+		for i, ring := range polygon {
+			boundary := "innerBoundaryIs"
+			if i == 0 {
+				boundary = "outerBoundaryIs"
+			}
+			fmt.Fprintf(buf, "<%s><LinearRing><coordinates>", boundary)
+			for j, p := range ring {
+				if j > 0 {
+					buf.WriteString(" ")
+				}
+				buf.WriteString(strconv.FormatFloat(p[0], 'f', -1, 64) + "," + strconv.FormatFloat(p[1], 'f', -1, 64))
+			}
+			fmt.Fprintf(buf, "</coordinates></LinearRing></%s>", boundary)
+		}
+		buf.WriteString("</Polygon>\n")
+	}
+	buf.WriteString("</MultiGeometry>\n")
+}
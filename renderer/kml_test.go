@@ -0,0 +1,71 @@
+package renderer_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	. "github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/dp-map-renderer/testdata"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderKML(t *testing.T) {
+	Convey("Given the example request", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Convey("RenderKML should return a well-formed document with a style for each class and a placemark for each region", func() {
+			renderRequest.Title = "Title with <markup> & ampersand"
+			result, err := RenderKML(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(wellFormed(result), ShouldBeNil)
+
+			s := string(result)
+			So(s, ShouldContainSubstring, "<name>Title with &lt;markup&gt; &amp; ampersand</name>")
+			// rgb(241, 238, 246) in aabbggrr
+			So(s, ShouldContainSubstring, `<Style id="class-0"><LineStyle><color>ffffffff</color><width>1</width></LineStyle><PolyStyle><color>fff6eef1</color></PolyStyle></Style>`)
+			So(strings.Count(s, "<Placemark>"), ShouldEqual, strings.Count(s, "<MultiGeometry>"))
+			So(strings.Count(s, "<Placemark>"), ShouldBeGreaterThan, len(renderRequest.Data)/2)
+			So(s, ShouldContainSubstring, "<description>"+renderRequest.Choropleth.ValuePrefix)
+			So(s, ShouldContainSubstring, "<styleUrl>#class-")
+			So(s, ShouldContainSubstring, `<Data name="id"><value>`+renderRequest.Data[0].ID+"</value>")
+		})
+
+		Convey("RenderKMZ should return the document in a zip archive", func() {
+			result, err := RenderKMZ(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			archive, err := zip.NewReader(bytes.NewReader(result), int64(len(result)))
+			So(err, ShouldBeNil)
+			So(archive.File, ShouldHaveLength, 1)
+			So(archive.File[0].Name, ShouldEqual, "doc.kml")
+			r, err := archive.File[0].Open()
+			So(err, ShouldBeNil)
+			kml, err := ioutil.ReadAll(r)
+			So(err, ShouldBeNil)
+			So(string(kml), ShouldContainSubstring, "<Placemark>")
+		})
+	})
+}
+
+// wellFormed returns an error if the document is not well-formed xml
+func wellFormed(document []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package renderer
+
+import (
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// minifiedPrecision is the number of decimal places kept in the coordinates of minified paths - a hundredth of a pixel of the viewBox
+const minifiedPrecision = 2
+
+var (
+	// whitespaceBetweenElements matches whitespace between the end of one element and the start of the next
+	whitespaceBetweenElements = regexp.MustCompile(`>\s+<`)
+	// coordinateAttributes matches the attributes holding path and polygon coordinates
+	coordinateAttributes = regexp.MustCompile(` (d|points)="[^"]*"`)
+	// decimalNumber matches a number with a fractional part
+	decimalNumber = regexp.MustCompile(`-?\d+\.\d+`)
+)
+
+// defaultAttributes are attributes with the svg default value, which minified svg omits
+var defaultAttributes = []string{
+	` x="0"`,
+	` y="0"`,
+	` opacity="1"`,
+	` fill-opacity="1"`,
+	` stroke-opacity="1"`,
+	` preserveAspectRatio="xMidYMid meet"`,
+}
+
+// minifySVG reduces the size of the svg: removing whitespace between elements and attributes with default values, and rounding coordinates to minifiedPrecision
+// decimal places without trailing zeros
+func minifySVG(svg string) string {
+	svg = whitespaceBetweenElements.ReplaceAllString(svg, "><")
+	for _, attribute := range defaultAttributes {
+		svg = strings.Replace(svg, attribute, "", -1)
+	}
+	return coordinateAttributes.ReplaceAllStringFunc(svg, func(attribute string) string {
+		return decimalNumber.ReplaceAllStringFunc(attribute, shortenNumber)
+	})
+}
+
+// shortenNumber rounds the number to minifiedPrecision decimal places, omitting trailing zeros
+func shortenNumber(number string) string {
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return number
+	}
+	scale := math.Pow10(minifiedPrecision)
+	s := strconv.FormatFloat(math.Floor(v*scale+.5)/scale, 'f', -1, 64)
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// shortIDPrefix returns a short prefix for the ids of a map, derived from its filename so that maps with different filenames have different ids
+func shortIDPrefix(filename string) string {
+	return "m" + shortHash(filename)
+}
+
+// shortHash returns a short (base 36) hash of the string
+func shortHash(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
@@ -0,0 +1,90 @@
+package renderer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+
+	"github.com/ONSdigital/dp-map-renderer/geojson2mvt"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
+	"github.com/paulmach/go.geojson"
+)
+
+// VectorTileLayer is the name of the layer holding the regions in vector tiles
+const VectorTileLayer = "regions"
+
+// vectorTilePyramidDepth is the number of zoom levels below the top tile included in a vector tile pyramid
+const vectorTilePyramidDepth = 3
+
+// RenderVectorTile returns a Mapbox Vector Tile of the request's Tile (defaulting to the smallest tile containing the geography), with a single layer of the regions,
+// each with the properties id, name, value, class and colour, classified in the same way as the svg map.
+func RenderVectorTile(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	fc := vectorTileFeatures(ctx, request)
+	tile, err := requestTile(request, fc)
+	if err != nil {
+		return nil, err
+	}
+	return encodeVectorTile(ctx, fc, tile), nil
+}
+
+// RenderVectorTilePyramid returns a zip archive of vector tiles, named z/x/y.pbf, for the request's Tile (defaulting to the smallest tile containing the geography)
+// and each tile that contains part of the geography in the levels below it.
+func RenderVectorTilePyramid(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	fc := vectorTileFeatures(ctx, request)
+	tile, err := requestTile(request, fc)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	level := []geojson2mvt.Tile{tile}
+	for z := 0; z <= vectorTilePyramidDepth && len(level) > 0; z++ {
+		var next []geojson2mvt.Tile
+		for _, t := range level {
+			w, err := archive.Create(t.String() + ".pbf")
+			if err != nil {
+				return nil, err
+			}
+			if _, err = w.Write(encodeVectorTile(ctx, fc, t)); err != nil {
+				return nil, err
+			}
+			for _, child := range t.Children() {
+				if child.Valid() && geojson2mvt.Intersects(fc, child) {
+					next = append(next, child)
+				}
+			}
+		}
+		level = next
+	}
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// vectorTileFeatures returns the classified features of the request within a span
+func vectorTileFeatures(ctx context.Context, request *models.RenderRequest) *geojson.FeatureCollection {
+	_, span := tracing.Start(ctx, "classifyFeatures")
+	defer span.End()
+	fc := classifiedFeatureCollection(classifyFeatures(request))
+	span.SetAttribute("feature_count", len(fc.Features))
+	return fc
+}
+
+// requestTile returns the tile given in the request, or the smallest tile containing all the features
+func requestTile(request *models.RenderRequest, fc *geojson.FeatureCollection) (geojson2mvt.Tile, error) {
+	if len(request.Tile) == 0 {
+		return geojson2mvt.TileContaining(fc), nil
+	}
+	return geojson2mvt.ParseTile(request.Tile)
+}
+
+// encodeVectorTile encodes the features as the given vector tile within a span
+func encodeVectorTile(ctx context.Context, fc *geojson.FeatureCollection, tile geojson2mvt.Tile) []byte {
+	_, span := tracing.Start(ctx, "EncodeVectorTile")
+	defer span.End()
+	span.SetAttribute("tile", tile.String())
+	return geojson2mvt.Encode(VectorTileLayer, fc, tile)
+}
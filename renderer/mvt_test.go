@@ -0,0 +1,57 @@
+package renderer_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	. "github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/dp-map-renderer/testdata"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderVectorTile(t *testing.T) {
+	Convey("Given the example request", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Convey("RenderVectorTile should return a tile with a layer of regions with their classification", func() {
+			result, err := RenderVectorTile(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(result, []byte(VectorTileLayer)), ShouldBeTrue)
+			for _, key := range []string{"id", "name", "value", "class", "colour"} {
+				So(bytes.Contains(result, append([]byte{byte(len(key))}, key...)), ShouldBeTrue)
+			}
+			So(bytes.Contains(result, []byte(renderRequest.Data[0].ID)), ShouldBeTrue)
+			So(bytes.Contains(result, []byte(renderRequest.Choropleth.Breaks[0].Colour)), ShouldBeTrue)
+		})
+
+		Convey("RenderVectorTile should omit regions outside the requested tile", func() {
+			renderRequest.Tile = "6/0/0"
+			result, err := RenderVectorTile(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(result, []byte(renderRequest.Data[0].ID)), ShouldBeFalse)
+		})
+
+		Convey("RenderVectorTilePyramid should return a zip of the containing tile and the tiles below it", func() {
+			result, err := RenderVectorTilePyramid(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			archive, err := zip.NewReader(bytes.NewReader(result), int64(len(result)))
+			So(err, ShouldBeNil)
+			levels := make(map[string]int)
+			for _, f := range archive.File {
+				So(f.Name, ShouldEndWith, ".pbf")
+				levels[strings.Split(f.Name, "/")[0]]++
+			}
+			So(len(levels), ShouldEqual, 4)
+			// the geography spans the prime meridian, so is only contained by the top level tile
+			So(archive.File[0].Name, ShouldEqual, "0/0/0.pbf")
+			So(levels["1"], ShouldEqual, 2)
+		})
+	})
+}
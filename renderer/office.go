@@ -0,0 +1,71 @@
+package renderer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// officeDPI is the resolution of images rendered for office documents
+const officeDPI = 300
+
+// officeMargin is the margin around the map in office images, as a proportion of the page's shorter side
+const officeMargin = 0.05
+
+// officeBackground is the colour of the page of office images, unless the request has a Background
+const officeBackground = "#ffffff"
+
+// officePageSizes are the width and height, in inches, of each office preset
+var officePageSizes = map[string][2]float64{
+	models.OfficePresetWidescreen:  {13.333, 7.5},
+	models.OfficePresetA4Landscape: {11.693, 8.268},
+	models.OfficePresetA4Portrait:  {8.268, 11.693},
+}
+
+// RenderOfficePNG returns a high resolution PNG image of the map and its legend (positioned as for RenderEPS), centred on a page of the size given by the request's
+// OfficePreset - a widescreen slide by default - for pasting into presentations and documents. The page is white unless the request has a Background.
+func RenderOfficePNG(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	converter := rasterConverters[g2s.ImageFormatPNG]
+	if converter == nil {
+		return nil, errors.New("pngConverter is nil - cannot convert svg to png")
+	}
+	svg := renderOfficeSVG(ctx, request)
+	if len(svg) == 0 {
+		return nil, errors.New("Unable to render png - request has no geography")
+	}
+	background := request.Background
+	if len(background) == 0 {
+		background = officeBackground
+	}
+	b64, err := convertImage(ctx, converter, g2s.ImageFormatPNG, background, svg)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(string(b64))
+}
+
+// renderOfficeSVG renders the map and legend scaled to fit within the margins of a page of the request's office preset, sized in pixels at officeDPI
+func renderOfficeSVG(ctx context.Context, request *models.RenderRequest) string {
+	content, width, height := renderPrintContent(ctx, request)
+	if len(content) == 0 {
+		return ""
+	}
+	page, exists := officePageSizes[request.OfficePreset]
+	if !exists {
+		page = officePageSizes[models.OfficePresetWidescreen]
+	}
+	pageWidth, pageHeight := page[0]*officeDPI, page[1]*officeDPI
+	margin := officeMargin * pageHeight
+	if pageWidth < pageHeight {
+		margin = officeMargin * pageWidth
+	}
+	// the nested svg's default preserveAspectRatio scales the content to fit, centred
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%.f" height="%.f" viewBox="0 0 %.f %.f">`+
+		`<svg x="%.f" y="%.f" width="%.f" height="%.f" viewBox="0 0 %.f %.f">%s</svg></svg>`,
+		pageWidth, pageHeight, pageWidth, pageHeight,
+		margin, margin, pageWidth-2*margin, pageHeight-2*margin, width, height, content)
+}
@@ -0,0 +1,71 @@
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ONSdigital/dp-map-renderer/htmlutil"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// patternSize is the width and height of the tile of a break's pattern
+const patternSize = 8
+
+// patternBackground is the background of a pattern whose break has no colour
+const patternBackground = "white"
+
+// presetPatterns are the contents of the predefined pattern tiles
+var presetPatterns = map[string]string{
+	models.PatternHatching: `<path d="M-2 2L2 -2M0 8L8 0M6 10L10 6" style="stroke: black; stroke-width: 1.5;"></path>`,
+	models.PatternDots:     `<circle cx="4" cy="4" r="1.5" style="fill: black;"></circle>`,
+	models.PatternCrossHatch: `<path d="M-2 2L2 -2M0 8L8 0M6 10L10 6" style="stroke: black; stroke-width: 1;"></path>` +
+		`<path d="M-2 6L2 10M0 0L8 8M6 -2L10 2" style="stroke: black; stroke-width: 1;"></path>`,
+}
+
+// breakPatternID returns the id of the pattern of the break with the given class (its index in ascending order) within the svg with the given id prefix
+func breakPatternID(prefix string, class int) string {
+	return fmt.Sprintf("%s-pattern-%d", prefix, class)
+}
+
+// breakFill returns the fill of regions in the break with the given class - its pattern, if it has one, otherwise its colour
+func breakFill(b *models.ChoroplethBreak, prefix string, class int) string {
+	if len(b.Pattern) == 0 {
+		return b.Colour
+	}
+	return "url(#" + breakPatternID(prefix, class) + ")"
+}
+
+// breakPatterns returns a pattern element for each of the request's breaks that has a pattern, drawn over the break's colour.
+// Returns an empty string if no break has a pattern.
+func breakPatterns(request *models.RenderRequest, prefix string) string {
+	buf := bytes.NewBufferString("")
+	for class, b := range requestBreaks(request) {
+		if len(b.Pattern) == 0 {
+			continue
+		}
+		background := b.Colour
+		if len(background) == 0 {
+			background = patternBackground
+		}
+		fmt.Fprintf(buf, `<pattern id="%s" width="%d" height="%d" patternUnits="userSpaceOnUse">`, breakPatternID(prefix, class), patternSize, patternSize)
+		fmt.Fprintf(buf, `<rect width="%d" height="%d" style="fill: %s;"></rect>`, patternSize, patternSize, background)
+		buf.WriteString(patternContent(b.Pattern))
+		buf.WriteString(`</pattern>`)
+	}
+	return buf.String()
+}
+
+// patternContent returns the content of the predefined pattern, or the sanitised content of a custom pattern
+func patternContent(pattern string) string {
+	if content, ok := presetPatterns[pattern]; ok {
+		return content
+	}
+	content, err := htmlutil.SanitiseSVG(strings.TrimSpace(pattern))
+	if err != nil {
+		log.Error(err, log.Data{"patternContent": "Unable to sanitise pattern", "pattern": pattern})
+		return ""
+	}
+	return content
+}
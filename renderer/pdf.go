@@ -0,0 +1,124 @@
+package renderer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// pageTitleHeight is the height allowed for the title at the top of each page of an atlas
+const pageTitleHeight = 30.0
+
+// pageLineHeight is the height of each line of text on the legend page of an atlas
+const pageLineHeight = 20.0
+
+var pdfConverter g2s.DocumentConverter
+
+// UsePDFConverter assigns a DocumentConverter that will be used to convert svg pages to a pdf by RenderPDFAtlas
+func UsePDFConverter(c g2s.DocumentConverter) {
+	pdfConverter = c
+}
+
+// RenderPDFAtlas returns a pdf with one page per data series, each showing the map of that series, followed by a page with the legend,
+// titles, source, licence and footnotes shared by all the maps. A request without series is rendered as a single map of its data.
+func RenderPDFAtlas(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	if pdfConverter == nil {
+		return nil, errors.New("pdfConverter is nil - cannot convert svg to pdf")
+	}
+
+	pages, err := renderAtlasPages(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return pdfConverter.ConvertPages(pages)
+}
+
+// renderAtlasPages renders an svg page for each series, followed by the legend page
+func renderAtlasPages(ctx context.Context, request *models.RenderRequest) ([][]byte, error) {
+	pages := [][]byte{}
+	var legendRequest *SVGRequest
+	for _, s := range requestSeries(request) {
+		page := *request
+		page.Data, page.Suppressed = s.Data, s.Suppressed
+		page.Series = nil
+		page.IncludeFallbackPng = false
+
+		svgRequest := prepareSVGRequest(ctx, &page)
+		svgRequest.responsiveSize = false
+		svg := renderSVG(ctx, svgRequest)
+		if len(svg) == 0 {
+			return nil, errors.New("Unable to render pdf - request has no geography")
+		}
+		if legendRequest == nil {
+			legendRequest = svgRequest
+		}
+
+		pages = append(pages, []byte(renderPage(svgRequest.ViewBoxWidth, svgRequest.ViewBoxHeight+pageTitleHeight,
+			pageText(0, seriesTitle(s), true)+positionSVG(svg, 0, pageTitleHeight))))
+	}
+
+	return append(pages, []byte(renderLegendPage(ctx, legendRequest))), nil
+}
+
+// renderLegendPage renders a page with the request's title and subtitle, the horizontal legend, and the source, licence and footnotes
+func renderLegendPage(ctx context.Context, svgRequest *SVGRequest) string {
+	request := svgRequest.request
+	content := &bytes.Buffer{}
+	y := 0.0
+	if len(request.Title) > 0 {
+		content.WriteString(pageText(y, request.Title, true))
+		y += pageTitleHeight
+	}
+	if len(request.Subtitle) > 0 {
+		content.WriteString(pageText(y, request.Subtitle, false))
+		y += pageLineHeight
+	}
+	if request.Choropleth != nil {
+		content.WriteString(positionSVG(traced(ctx, "RenderHorizontalKey", RenderHorizontalKey, svgRequest), 0, y))
+		y += getHorizontalKeyHeight(svgRequest)
+	}
+	if sources := request.AllSources(); len(sources) > 0 {
+		content.WriteString(pageText(y, sourcesLine(sources), false))
+		y += pageLineHeight
+	}
+	for _, licence := range request.AllLicences() {
+		content.WriteString(pageText(y, licence, false))
+		y += pageLineHeight
+	}
+	for i, footnote := range request.Footnotes {
+		content.WriteString(pageText(y, fmt.Sprintf("%d. %s", i+1, footnote), false))
+		y += pageLineHeight
+	}
+	return renderPage(svgRequest.ViewBoxWidth, y, content.String())
+}
+
+// renderPage wraps the content in a standalone svg of the given size
+func renderPage(width float64, height float64, content string) string {
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%.f" height="%.f" viewBox="0 0 %.f %.f">%s</svg>`, width, height, width, height, content)
+}
+
+// sourcesLine returns the text of the sources, without their links, e.g. "Sources: Census 2011; ONS"
+func sourcesLine(sources []*models.Source) string {
+	if len(sources) == 1 {
+		return sourceText + sources[0].Text
+	}
+	texts := make([]string, len(sources))
+	for i, s := range sources {
+		texts[i] = s.Text
+	}
+	return sourcesText + strings.Join(texts, sourceSeparator)
+}
+
+// pageText returns an svg text element for a line of text on a page, with its top at y
+func pageText(y float64, text string, isTitle bool) string {
+	if isTitle {
+		return fmt.Sprintf(`<text x="0" y="%.f" font-size="16" font-weight="bold">%s</text>`, y+pageTitleHeight*0.7, html.EscapeString(text))
+	}
+	return fmt.Sprintf(`<text x="0" y="%.f" font-size="12">%s</text>`, y+pageLineHeight*0.7, html.EscapeString(text))
+}
@@ -0,0 +1,64 @@
+package renderer_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/geojson2svg"
+	"github.com/ONSdigital/dp-map-renderer/models"
+	. "github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/dp-map-renderer/testdata"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderPDFAtlas(t *testing.T) {
+	Convey("Given a pdf converter that concatenates the svg pages it is given", t, func() {
+
+		UsePDFConverter(geojson2svg.NewDocumentConverter("pdf", "sh", []string{"-c", `cat "$@" >> ` + geojson2svg.ArgImageFilename, "sh", geojson2svg.ArgSVGFilename}))
+		defer UsePDFConverter(nil)
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.DefaultWidth = 400
+
+		Convey("A request with several series has a page per series followed by a legend page", func() {
+			renderRequest.Series = []*models.DataSeries{
+				{ID: "2016", Title: "Series <2016>", Data: renderRequest.Data},
+				{ID: "2017", Data: renderRequest.Data[:10]},
+			}
+
+			result, err := RenderPDFAtlas(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			pdf := string(result)
+			So(strings.Count(pdf, `<svg xmlns="http://www.w3.org/2000/svg" width="400"`), ShouldEqual, 3)
+			So(pdf, ShouldContainSubstring, `font-weight="bold">Series &lt;2016&gt;</text>`)
+			So(pdf, ShouldContainSubstring, `font-weight="bold">2017</text>`)
+			So(pdf, ShouldContainSubstring, `font-weight="bold">`+renderRequest.Title+`</text>`)
+			So(strings.Count(pdf, "legend-horizontal-svg"), ShouldEqual, 1)
+		})
+
+		Convey("A request without series has a single map page and a legend page", func() {
+			result, err := RenderPDFAtlas(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(strings.Count(string(result), `<svg xmlns="http://www.w3.org/2000/svg" width="400"`), ShouldEqual, 2)
+		})
+	})
+
+	Convey("RenderPDFAtlas returns an error when there is no pdf converter", t, func() {
+		UsePDFConverter(nil)
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := RenderPDFAtlas(context.Background(), renderRequest)
+		So(err, ShouldNotBeNil)
+		So(result, ShouldBeNil)
+	})
+}
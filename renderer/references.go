@@ -0,0 +1,124 @@
+package renderer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ONSdigital/dp-map-renderer/htmlutil"
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// referenceRowHeight is the distance between rows of reference labels in the horizontal key.
+// In the vertical key each reference label (text above value) is twice this height.
+const referenceRowHeight = 11.0
+
+// referenceLabelGap is the minimum space between the labels of neighbouring reference ticks in the horizontal key
+const referenceLabelGap = 4.0
+
+// referenceTick is a reference value marked on the legends, with its position relative to the length of the key
+type referenceTick struct {
+	Value float64
+	Text  string
+	Pos   float64
+}
+
+// choroplethReferences returns the choropleth's reference values - its ReferenceValue (if it has ReferenceValueText) and its References - in ascending order of value
+func choroplethReferences(choropleth *models.Choropleth) []*models.Reference {
+	var references []*models.Reference
+	if len(choropleth.ReferenceValueText) > 0 {
+		references = append(references, &models.Reference{Value: choropleth.ReferenceValue, Text: choropleth.ReferenceValueText})
+	}
+	references = append(references, choropleth.References...)
+	sort.SliceStable(references, func(i, j int) bool { return references[i].Value < references[j].Value })
+	return references
+}
+
+// horizontalRefLabel is the placement of the labels of a reference tick in the horizontal key - the text either side of the tick, and the row they're drawn in
+type horizontalRefLabel struct {
+	tick     *referenceTick
+	left     string
+	leftLen  float64
+	right    string
+	rightLen float64
+	row      int
+}
+
+// getHorizontalRefLabel returns the labels of the reference tick, with the reference text and value either side of the tick.
+// Unless the text has been placed before or after the tick, the longer of the two is given the side with the most space.
+func getHorizontalRefLabel(request *models.RenderRequest, tick *referenceTick) *horizontalRefLabel {
+	text, value := tick.Text, fmt.Sprintf("%g", tick.Value)
+	textLen := htmlutil.GetApproximateTextWidth(text, request.FontSize)
+	valueLen := htmlutil.GetApproximateTextWidth(value, request.FontSize)
+
+	textLeft := textLen > valueLen // the longer text on the left...
+	if tick.Pos < 0.5 {            // ...unless the reference tick is less than halfway
+		textLeft = !textLeft
+	}
+	switch request.Choropleth.ReferenceLabelPlacement {
+	case models.ReferenceLabelBefore:
+		textLeft = true
+	case models.ReferenceLabelAfter:
+		textLeft = false
+	}
+	if textLeft {
+		return &horizontalRefLabel{tick: tick, left: text, leftLen: textLen, right: value, rightLen: valueLen}
+	}
+	return &horizontalRefLabel{tick: tick, left: value, leftLen: valueLen, right: text, rightLen: textLen}
+}
+
+// placeHorizontalRefLabels assigns each label (from left to right) to the first row in which it doesn't overlap the label before it,
+// returning the number of rows used
+func placeHorizontalRefLabels(labels []*horizontalRefLabel, keyWidth float64) int {
+	ordered := make([]*horizontalRefLabel, len(labels))
+	copy(ordered, labels)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].tick.Pos < ordered[j].tick.Pos })
+
+	var rowEnds []float64 // the right hand end of the last label in each row
+	for _, label := range ordered {
+		xPos := keyWidth * label.tick.Pos
+		label.row = len(rowEnds)
+		for row, end := range rowEnds {
+			if xPos-label.leftLen >= end+referenceLabelGap {
+				label.row = row
+				break
+			}
+		}
+		if label.row == len(rowEnds) {
+			rowEnds = append(rowEnds, 0)
+		}
+		rowEnds[label.row] = xPos + label.rightLen
+	}
+	return len(rowEnds)
+}
+
+// getHorizontalKeyHeight returns the height of the horizontal key - horizontalKeyHeight, plus the height of any extra rows of reference labels
+func getHorizontalKeyHeight(svgRequest *SVGRequest) float64 {
+	if len(svgRequest.breaks) == 0 {
+		return horizontalKeyHeight
+	}
+	return horizontalKeyHeight + getHorizontalKeyInfo(svgRequest.ViewBoxWidth, svgRequest).extraHeight()
+}
+
+// getVerticalRefLabelOffsets returns the offset of the labels of each reference tick from the tick in the vertical key,
+// moving labels down where they would overlap the label of the tick above
+func getVerticalRefLabelOffsets(ticks []*referenceTick, keyHeight float64) []float64 {
+	order := make([]int, len(ticks)) // the indexes of the ticks from top to bottom
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return ticks[order[i]].Pos > ticks[order[j]].Pos })
+
+	offsets := make([]float64, len(ticks))
+	for n, i := range order {
+		yPos := keyHeight - keyHeight*ticks[i].Pos
+		labelPos := yPos
+		if n > 0 {
+			above := order[n-1]
+			previous := keyHeight - keyHeight*ticks[above].Pos + offsets[above]
+			labelPos = math.Max(yPos, previous+2*referenceRowHeight)
+		}
+		offsets[i] = labelPos - yPos
+	}
+	return offsets
+}
@@ -2,7 +2,11 @@ package renderer
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"html"
 	"math"
 	"sort"
 
@@ -11,13 +15,31 @@ import (
 	g2s "github.com/ONSdigital/dp-map-renderer/geojson2svg"
 	"github.com/ONSdigital/dp-map-renderer/htmlutil"
 	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/tracing"
 	"github.com/paulmach/go.geojson"
+	"github.com/rubenv/topojson"
 )
 
-// RegionClassName is the name of the class assigned to all map regions (denoted by features in the input topology)
+// RegionClassName is the default name of the class assigned to all map regions (denoted by features in the input topology)
 const RegionClassName = "mapRegion"
 
-// MissingDataText is the text appended to the title of a region that has missing data
+// regionClassName returns the class assigned to all map regions - the request's RegionClass, or RegionClassName by default
+func regionClassName(request *models.RenderRequest) string {
+	if len(request.RegionClass) > 0 {
+		return request.RegionClass
+	}
+	return RegionClassName
+}
+
+// The data attributes added to each map region, so that client-side scripts can build tooltips etc without parsing the title.
+// data-id is the region's id in the topology, data-label its name, and data-value its value (omitted if the region has no data)
+const (
+	DataIDAttribute    = "data-id"
+	DataLabelAttribute = "data-label"
+	DataValueAttribute = "data-value"
+)
+
+// MissingDataText is the text appended to the title of a region that has missing data, and the label of its legend entry, unless the choropleth has its own missing data text
 const MissingDataText = "data unavailable"
 
 // MissingDataPattern is the fmt template used to generate the pattern used for regions with missing data
@@ -36,17 +58,56 @@ const MissingDataPattern = `<pattern id="%s-nodata" width="20" height="20" patte
 </g>
 </pattern>`
 
-var pngConverter g2s.PNGConverter
+// SuppressedDataText is the text appended to the title of a region whose data is suppressed, and the label of its legend entry, unless the choropleth has its own suppressed text
+const SuppressedDataText = "data suppressed"
 
-// UsePNGConverter assigns a PNGConverter that will be used to generate fallback png images for svgs.
+// SuppressedDataPattern is the fmt template used to generate the pattern used for regions with suppressed data
+const SuppressedDataPattern = `<pattern id="%s-suppressed" width="6" height="6" patternUnits="userSpaceOnUse">
+<circle cx="3" cy="3" r="1.2" fill="#6D6E72"></circle>
+</pattern>`
+
+// horizontalKeyHeight is the height of the viewBox of the horizontal key with a single row of tick labels and of reference labels
+const horizontalKeyHeight = 90.0
+
+// overlayKeyScale is the factor by which a key is scaled when drawn inside the map
+const overlayKeyScale = 0.5
+
+// rasterConverters are the converters used to generate fallback images, keyed on image format
+var rasterConverters = map[string]g2s.RasterConverter{}
+
+// UsePNGConverter assigns a PNGConverter that will be used to generate fallback png images for svgs, and png images of the map.
 func UsePNGConverter(p g2s.PNGConverter) {
-	pngConverter = p
+	UseRasterConverter(g2s.ImageFormatPNG, p)
+}
+
+// UseRasterConverter assigns a RasterConverter that will be used to generate fallback images in the given format (webp, avif or jpeg) for requests
+// with that fallback_image_format. Requests for a format without a converter fall back to png.
+func UseRasterConverter(format string, c g2s.RasterConverter) {
+	rasterConverters[format] = c
+}
+
+var epsConverter g2s.RasterConverter
+
+// UseEPSConverter assigns a converter that will be used to convert svg to eps (encapsulated postscript) by RenderEPS
+func UseEPSConverter(c g2s.RasterConverter) {
+	epsConverter = c
+}
+
+// fallbackConverter returns the converter for the request's fallback image format and that format, or the png converter if the format has no converter.
+// The converter is nil if there is no png converter.
+func fallbackConverter(request *models.RenderRequest) (g2s.RasterConverter, string) {
+	if c := rasterConverters[request.FallbackImageFormat]; c != nil {
+		return c, request.FallbackImageFormat
+	}
+	return rasterConverters[g2s.ImageFormatPNG], g2s.ImageFormatPNG
 }
 
 // valueAndColour represents a choropleth data point, which has both a numeric value and an associated colour
 type valueAndColour struct {
-	value  float64
-	colour string
+	value      float64
+	colour     string
+	suppressed bool   // true if the value is one of the choropleth's suppressed values, in which case the colour is the suppressed data pattern
+	classLabel string // the label of the value's break, or its range if it has no label
 }
 
 // SVGRequest wraps a models.RenderRequest and allows caching of expensive calculations (such as converting topojson to geojson)
@@ -54,13 +115,14 @@ type SVGRequest struct {
 	request             *models.RenderRequest
 	geoJSON             *geojson.FeatureCollection
 	svg                 *g2s.SVG
-	ViewBoxWidth        float64      // the width dimension of the svg (for the viewBox). The FixedWidth if provided, otherwise the average of min and max width, falling back to 400 if nothing specified
-	ViewBoxHeight       float64      // the height dimension of the svg (for the viewBox). Relative to width.
-	breaks              []*breakInfo // sorted breaks
-	referencePos        float64      // the relative position of the reference tick
-	VerticalLegendWidth float64      // the view box width of the vertical legend
-	verticalKeyOffset   float64      // offset for the position of the key. // I.e. the middle of the key should be positioned in the middle of the legend, plus the offset.
-	responsiveSize      bool         // if true, the svg should scale with the size of the page. Otherwise the size is fixed.
+	ViewBoxWidth        float64             // the width dimension of the svg (for the viewBox). The FixedWidth if provided, otherwise the average of min and max width, falling back to 400 if nothing specified
+	ViewBoxHeight       float64             // the height dimension of the svg (for the viewBox). Relative to width.
+	breaks              []*breakInfo        // sorted breaks
+	references          []*referenceTick    // the reference ticks, in ascending order of value
+	VerticalLegendWidth float64             // the view box width of the vertical legend
+	verticalKeyOffset   float64             // offset for the position of the key. // I.e. the middle of the key should be positioned in the middle of the legend, plus the offset.
+	responsiveSize      bool                // if true, the svg should scale with the size of the page. Otherwise the size is fixed.
+	conversionFailures  *conversionFailures // the first error converting the map or legends to a fallback image
 }
 
 // PrepareSVGRequest wraps the request in an SVGRequest, caching expensive calculations up front
@@ -71,23 +133,26 @@ func PrepareSVGRequest(request *models.RenderRequest) *SVGRequest {
 
 	width, height := 0.0, 0.0
 	if geoJSON != nil {
+		raiseHighlighted(geoJSON.Features, request)
 		svg.AppendFeatureCollection(geoJSON)
+		svg.FitTo(fittedGeometries(geoJSON.Features, request)...)
 		width, height = getViewBoxDimensions(svg, request)
 	}
 
 	responsiveSize := request.MinWidth > 0 && request.MaxWidth > 0
 
 	svgRequest := &SVGRequest{
-		request:        request,
-		geoJSON:        geoJSON,
-		svg:            svg,
-		ViewBoxWidth:   width,
-		ViewBoxHeight:  height,
-		responsiveSize: responsiveSize,
+		request:            request,
+		geoJSON:            geoJSON,
+		svg:                svg,
+		ViewBoxWidth:       width,
+		ViewBoxHeight:      height,
+		responsiveSize:     responsiveSize,
+		conversionFailures: &conversionFailures{},
 	}
 
 	if request.Choropleth != nil && len(request.Choropleth.Breaks) > 0 {
-		svgRequest.breaks, svgRequest.referencePos = getSortedBreakInfo(request)
+		svgRequest.breaks, svgRequest.references = getSortedBreakInfo(request)
 
 		svgRequest.VerticalLegendWidth, svgRequest.verticalKeyOffset = getVerticalLegendWidth(request, svgRequest.breaks)
 	}
@@ -107,29 +172,271 @@ func RenderSVG(svgRequest *SVGRequest) string {
 	vbHeight := svgRequest.ViewBoxHeight
 
 	id := idPrefix(request)
-	setFeatureIDs(geoJSON.Features, request.Geography.IDProperty, id+ "-")
-	setClassProperty(geoJSON.Features, RegionClassName)
+	setDataAttributes(geoJSON.Features, request.Geography)
+	highlighted := setHighlights(geoJSON.Features, request)
+	setFeatureIDs(geoJSON.Features, request.Geography, id+"-")
+	if len(request.Geography.ClassProperty) > 0 {
+		setPropertyClasses(geoJSON.Features, request.Geography.ClassProperty)
+	}
+	setClassProperty(geoJSON.Features, regionClassName(request))
+	if request.IncludeStyles {
+		setFocusable(geoJSON.Features)
+	}
 	setChoroplethColoursAndTitles(geoJSON.Features, request)
 
-	converter := pngConverter
+	converter := svgRequest.fallbackImageConverter(mapFallbackAlt(svgRequest))
 	if !request.IncludeFallbackPng {
 		converter = nil
 	}
 
 	missingDataPattern := strings.Replace(fmt.Sprintf(MissingDataPattern, id), "\n", "", -1)
 
-	return svgRequest.svg.DrawWithProjection(vbWidth, vbHeight, g2s.MercatorProjection,
-		g2s.UseProperties([]string{"style", "class"}),
+	options := []g2s.Option{
+		g2s.UseProperties([]string{"style", "class", "tabindex", DataIDAttribute, DataLabelAttribute, DataValueAttribute}),
 		g2s.WithTitles(request.Geography.NameProperty),
 		g2s.WithAttribute("id", mapID(request)+"-svg"),
 		g2s.WithAttribute("viewBox", fmt.Sprintf("0 0 %.f %.f", vbWidth, vbHeight)),
 		g2s.WithPNGFallback(converter),
 		g2s.WithPattern(missingDataPattern),
 		g2s.WithResponsiveSize(svgRequest.responsiveSize),
-	)
+	}
+	if altText := mapAltText(request); len(altText) > 0 {
+		options = append(options, g2s.WithAttribute("aria-label", altText))
+	}
+	if patterns := breakPatterns(request, id); len(patterns) > 0 {
+		options = append(options, g2s.WithPattern(patterns))
+	}
+	if hasSuppressedValues(request.Choropleth) {
+		options = append(options, g2s.WithPattern(strings.Replace(fmt.Sprintf(SuppressedDataPattern, id), "\n", "", -1)))
+	}
+	if labels := renderHighlightLabels(svgRequest, highlighted); len(labels) > 0 {
+		options = append(options, g2s.WithOverlay(labels))
+	}
+	for _, overlay := range renderOverlayKeys(svgRequest) {
+		options = append(options, g2s.WithOverlay(overlay))
+	}
+
+	return drawSVG(svgRequest, options)
+}
+
+// drawSVG draws the map with the given options, taking its content from the svg cache (see UseSVGCache) if the same request has been drawn before
+func drawSVG(svgRequest *SVGRequest, options []g2s.Option) string {
+	width, height := svgRequest.ViewBoxWidth, svgRequest.ViewBoxHeight
+	cache := drawings
+	if cache == nil {
+		return svgRequest.svg.DrawWithProjection(width, height, g2s.MercatorProjection, options...)
+	}
+	key, err := drawingKey(svgRequest.request, width, height)
+	if err != nil {
+		return svgRequest.svg.DrawWithProjection(width, height, g2s.MercatorProjection, options...)
+	}
+	if content, ok := cache.get(key).(string); ok {
+		return svgRequest.svg.Wrap(width, height, content, options...)
+	}
+	content := svgRequest.svg.DrawContent(width, height, g2s.MercatorProjection, options...)
+	cache.put(key, content)
+	return svgRequest.svg.Wrap(width, height, content)
 }
 
-// getGeoJSON performs a sanity check for missing properties, then converts the topojson to geojson
+// RenderSVGDocument returns a standalone SVG document of the map (without html, caption or footer). Legends are only included if positioned inside the map.
+func RenderSVGDocument(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	svg := renderStandaloneSVG(ctx, request)
+	if len(svg) == 0 {
+		return nil, errors.New("Unable to render svg - request has no geography")
+	}
+	return []byte(svg), nil
+}
+
+// RenderPNGImage returns a PNG image of the map (without html, caption or footer). Legends are only included if positioned inside the map.
+func RenderPNGImage(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	converter := rasterConverters[g2s.ImageFormatPNG]
+	if converter == nil {
+		return nil, errors.New("pngConverter is nil - cannot convert svg to png")
+	}
+	svg := renderStandaloneSVG(ctx, request)
+	if len(svg) == 0 {
+		return nil, errors.New("Unable to render png - request has no geography")
+	}
+	b64, err := convertImage(ctx, converter, g2s.ImageFormatPNG, request.Background, svg)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(string(b64))
+}
+
+// RenderPNGImageWithLegend returns a PNG image of the map with its legend drawn alongside it (positioned as for RenderEPS), without html, caption or footer
+func RenderPNGImageWithLegend(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	converter := rasterConverters[g2s.ImageFormatPNG]
+	if converter == nil {
+		return nil, errors.New("pngConverter is nil - cannot convert svg to png")
+	}
+	svg := renderPrintSVG(ctx, request)
+	if len(svg) == 0 {
+		return nil, errors.New("Unable to render png - request has no geography")
+	}
+	b64, err := convertImage(ctx, converter, g2s.ImageFormatPNG, request.Background, svg)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(string(b64))
+}
+
+// ConvertPNG returns a PNG image of the svg document at the given width and height (see g2s.ResizeSVG), converted by the png converter
+func ConvertPNG(ctx context.Context, svg []byte, width float64, height float64) ([]byte, error) {
+	converter := rasterConverters[g2s.ImageFormatPNG]
+	if converter == nil {
+		return nil, errors.New("pngConverter is nil - cannot convert svg to png")
+	}
+	b64, err := convertImage(ctx, converter, g2s.ImageFormatPNG, "", string(g2s.ResizeSVG(svg, width, height)))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(string(b64))
+}
+
+// RenderEPS returns an EPS (encapsulated postscript) document of the map and its legend, for placing in print layouts.
+// A legend positioned before or after the map is drawn above or below it (horizontal) or to its left or right (vertical). If both are given, the horizontal legend is used.
+func RenderEPS(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	if epsConverter == nil {
+		return nil, errors.New("epsConverter is nil - cannot convert svg to eps")
+	}
+	svg := renderPrintSVG(ctx, request)
+	if len(svg) == 0 {
+		return nil, errors.New("Unable to render eps - request has no geography")
+	}
+	b64, err := convertImage(ctx, epsConverter, "eps", "", svg)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(string(b64))
+}
+
+// renderPrintSVG renders a standalone svg of the map at a fixed size, with a legend that is positioned before or after the map drawn alongside it
+func renderPrintSVG(ctx context.Context, request *models.RenderRequest) string {
+	content, width, height := renderPrintContent(ctx, request)
+	if len(content) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%.f" height="%.f" viewBox="0 0 %.f %.f">%s</svg>`,
+		width, height, width, height, content)
+}
+
+// renderPrintContent renders the map and its legend, positioned for print as described by renderPrintSVG, returning them along with their total width and height
+func renderPrintContent(ctx context.Context, request *models.RenderRequest) (string, float64, float64) {
+	request.IncludeFallbackPng = false
+	svgRequest := prepareSVGRequest(ctx, request)
+	svgRequest.responsiveSize = false
+	svg := renderSVG(ctx, svgRequest)
+	if len(svg) == 0 {
+		return "", 0, 0
+	}
+
+	width, height := svgRequest.ViewBoxWidth, svgRequest.ViewBoxHeight
+	mapX, mapY, keyX, keyY := 0.0, 0.0, 0.0, 0.0
+	key := ""
+	switch {
+	case hasHorizontalLegend(request):
+		key = traced(ctx, "RenderHorizontalKey", RenderHorizontalKey, svgRequest)
+		keyHeight := getHorizontalKeyHeight(svgRequest)
+		height += keyHeight
+		if request.Choropleth.HorizontalLegendPosition == models.LegendPositionBefore {
+			mapY = keyHeight
+		} else {
+			keyY = svgRequest.ViewBoxHeight
+		}
+	case hasVerticalLegend(request):
+		key = traced(ctx, "RenderVerticalKey", RenderVerticalKey, svgRequest)
+		width += svgRequest.VerticalLegendWidth
+		if request.Choropleth.VerticalLegendPosition == models.LegendPositionBefore {
+			mapX = svgRequest.VerticalLegendWidth
+		} else {
+			keyX = svgRequest.ViewBoxWidth
+		}
+	}
+
+	return positionSVG(svg, mapX, mapY) + positionSVG(key, keyX, keyY), width, height
+}
+
+// positionSVG adds x and y attributes to the (nested) svg
+func positionSVG(svg string, x float64, y float64) string {
+	if len(svg) == 0 {
+		return ""
+	}
+	return strings.Replace(svg, "<svg", fmt.Sprintf(`<svg x="%.f" y="%.f"`, x, y), 1)
+}
+
+// renderStandaloneSVG renders the map at a fixed size, with the svg namespace declared so that it can be used outside of an html document
+func renderStandaloneSVG(ctx context.Context, request *models.RenderRequest) string {
+	request.IncludeFallbackPng = false
+	svgRequest := prepareSVGRequest(ctx, request)
+	svgRequest.responsiveSize = false
+	svg := renderSVG(ctx, svgRequest)
+	if len(svg) == 0 {
+		return ""
+	}
+	if request.EmbedTitle && (len(request.Title) > 0 || len(request.Subtitle) > 0) {
+		return embedTitles(request, svg, svgRequest.ViewBoxWidth, svgRequest.ViewBoxHeight)
+	}
+	return strings.Replace(svg, "<svg", `<svg xmlns="http://www.w3.org/2000/svg"`, 1)
+}
+
+// embedTitles returns a standalone svg of the given size plus room for the request's title and subtitle, drawn above the (nested) map svg
+func embedTitles(request *models.RenderRequest, svg string, width float64, height float64) string {
+	content := &bytes.Buffer{}
+	y := 0.0
+	if len(request.Title) > 0 {
+		content.WriteString(pageText(y, request.Title, true))
+		y += pageTitleHeight
+	}
+	if len(request.Subtitle) > 0 {
+		content.WriteString(pageText(y, request.Subtitle, false))
+		y += pageLineHeight
+	}
+	return renderPage(width, height+y, content.String()+positionSVG(svg, 0, y))
+}
+
+// prepareSVGRequest calls PrepareSVGRequest within a span
+func prepareSVGRequest(ctx context.Context, request *models.RenderRequest) *SVGRequest {
+	_, span := tracing.Start(ctx, "PrepareSVGRequest")
+	defer span.End()
+	svgRequest := PrepareSVGRequest(request)
+	if svgRequest.geoJSON != nil {
+		span.SetAttribute("feature_count", len(svgRequest.geoJSON.Features))
+	}
+	return svgRequest
+}
+
+// renderSVG calls RenderSVG within a span
+func renderSVG(ctx context.Context, svgRequest *SVGRequest) string {
+	return traced(ctx, "RenderSVG", RenderSVG, svgRequest)
+}
+
+// traced calls the given render function within a span with the given name, minifying the svg if the request asks for it
+func traced(ctx context.Context, name string, render func(*SVGRequest) string, svgRequest *SVGRequest) string {
+	_, span := tracing.Start(ctx, name)
+	defer span.End()
+	svg := render(svgRequest)
+	if svgRequest.request.Minify {
+		svg = minifySVG(svg)
+	}
+	return svg
+}
+
+// convertImage converts the svg to a base64-encoded image in the converter's format within a span, e.g. ConvertPNG, filling the background of the image with
+// the given colour (see g2s.AddBackground) - or leaving it transparent if the colour is empty
+func convertImage(ctx context.Context, converter g2s.RasterConverter, format string, background string, svg string) ([]byte, error) {
+	_, span := tracing.Start(ctx, "Convert"+strings.ToUpper(format))
+	defer span.End()
+	span.SetAttribute("svg_size", len(svg))
+	b64, err := converter.Convert(g2s.AddBackground([]byte(svg), background))
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+	}
+	return b64, err
+}
+
+// getGeoJSON performs a sanity check for missing properties, then converts the topojson to geojson, keeping only the features selected by the geography's filters.
+// The geojson is taken from the geography cache (see UseGeographyCache) if the same geography has been rendered before.
 func getGeoJSON(request *models.RenderRequest) *geojson.FeatureCollection {
 	// sanity check
 	if request.Geography == nil ||
@@ -138,13 +445,72 @@ func getGeoJSON(request *models.RenderRequest) *geojson.FeatureCollection {
 		len(request.Geography.Topojson.Objects) == 0 {
 		return nil
 	}
+	cache := geographies
+	if cache == nil {
+		return convertGeoJSON(request)
+	}
+	key, err := geographyKey(request)
+	if err != nil {
+		return convertGeoJSON(request)
+	}
+	if geoJSON, ok := cache.get(key).(*geojson.FeatureCollection); ok {
+		return copyFeatureCollection(geoJSON)
+	}
+	geoJSON := getSharedGeoJSON(key)
+	if geoJSON == nil {
+		geoJSON = convertGeoJSON(request)
+		putSharedGeoJSON(key, geoJSON)
+	}
+	cache.put(key, geoJSON)
+	return copyFeatureCollection(geoJSON)
+}
 
-	return request.Geography.Topojson.ToGeoJSON()
+// convertGeoJSON converts the topojson of the request's geography to geojson, keeping only the features selected by the geography's filters
+func convertGeoJSON(request *models.RenderRequest) *geojson.FeatureCollection {
+	topology := request.Geography.SelectedTopology()
+	if request.Deterministic {
+		return filterFeatures(orderedGeoJSON(topology), request.Geography)
+	}
+
+	return filterFeatures(topology.ToGeoJSON(), request.Geography)
+}
+
+// orderedGeoJSON converts the topology to geojson with the features of its objects in order of object name,
+// rather than the (random) order in which ToGeoJSON iterates over the objects.
+// Each feature has a copy of its object's properties, so rendering the features doesn't modify the topology (and the output of rendering it again).
+func orderedGeoJSON(topology *topojson.Topology) *geojson.FeatureCollection {
+	names := make([]string, 0, len(topology.Objects))
+	for name := range topology.Objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fc := geojson.NewFeatureCollection()
+	for _, name := range names {
+		object := &topojson.Topology{Type: topology.Type, Transform: topology.Transform, Arcs: topology.Arcs,
+			Objects: map[string]*topojson.Geometry{name: topology.Objects[name]}}
+		for _, feature := range object.ToGeoJSON().Features {
+			properties := make(map[string]interface{}, len(feature.Properties))
+			for k, v := range feature.Properties {
+				properties[k] = v
+			}
+			feature.Properties = properties
+			fc.AddFeature(feature)
+		}
+	}
+	return fc
 }
 
 // getViewBoxDimensions assigns the viewbox a fixed width (400) and calculates the height relative to this,
 // returning (width, height)
 func getViewBoxDimensions(svg *g2s.SVG, request *models.RenderRequest) (float64, float64) {
+	width := getWidth(request)
+	height := svg.GetHeightForWidth(width, g2s.MercatorProjection)
+	return width, height
+}
+
+// getWidth returns the width of the map - the DefaultWidth if given, otherwise the average of the min and max width, falling back to 400
+func getWidth(request *models.RenderRequest) float64 {
 	width := request.DefaultWidth
 	if width <= 0.0 { // average the min and max width
 		width = (request.MinWidth + request.MaxWidth) / 2
@@ -152,25 +518,38 @@ func getViewBoxDimensions(svg *g2s.SVG, request *models.RenderRequest) (float64,
 	if width <= 0.0 { // use a default width of 400
 		width = 400.0
 	}
-	height := svg.GetHeightForWidth(width, g2s.MercatorProjection)
-	return width, height
+	return width
 }
 
-// setFeatureIDs looks in each Feature for a property with the given idProperty, using it as the feature id.
-func setFeatureIDs(features []*geojson.Feature, idProperty string, prefix string) {
+// setFeatureIDs looks in each Feature for the first of the geography's id properties, using it (with the prefix) as the feature id.
+func setFeatureIDs(features []*geojson.Feature, geography *models.Geography, prefix string) {
 	for _, feature := range features {
-		id, isString := feature.Properties[idProperty].(string)
-		if isString && len(id) > 0 {
+		if id := geography.FeatureID(feature.Properties, feature.ID); len(id) > 0 {
 			feature.ID = prefix + id
-		} else {
-			id, isString := feature.ID.(string)
-			if isString && len(id) > 0 {
-				feature.ID = prefix + id
-			}
 		}
 	}
 }
 
+// setDataAttributes populates the data-id and data-label properties of each feature, from the geography's id properties (falling back to the feature's id) and name property.
+// Must be called before the feature ids are prefixed by setFeatureIDs.
+func setDataAttributes(features []*geojson.Feature, geography *models.Geography) {
+	for _, feature := range features {
+		if id := geography.FeatureID(feature.Properties, feature.ID); len(id) > 0 {
+			feature.Properties[DataIDAttribute] = id
+		}
+		if name, ok := feature.Properties[geography.NameProperty]; ok {
+			feature.Properties[DataLabelAttribute] = fmt.Sprintf("%v", name)
+		}
+	}
+}
+
+// setFocusable adds a tabindex property to each feature, so that regions can be focused (and highlighted) using the keyboard
+func setFocusable(features []*geojson.Feature) {
+	for _, feature := range features {
+		feature.Properties["tabindex"] = "0"
+	}
+}
+
 // setClassProperty populates a class property in each feature with the given class name, appending any existing class property.
 func setClassProperty(features []*geojson.Feature, className string) {
 	for _, feature := range features {
@@ -178,6 +557,20 @@ func setClassProperty(features []*geojson.Feature, className string) {
 	}
 }
 
+// setPropertyClasses adds the value of the given property of each feature (e.g. "Northern Ireland") to its class property, as a class name (e.g. "Northern-Ireland").
+// Features without the property are unchanged.
+func setPropertyClasses(features []*geojson.Feature, property string) {
+	for _, feature := range features {
+		value, ok := feature.Properties[property]
+		if !ok || value == nil {
+			continue
+		}
+		if className := invalidIDCharacters.ReplaceAllString(fmt.Sprintf("%v", value), "-"); len(className) > 0 {
+			appendProperty(feature, "class", className)
+		}
+	}
+}
+
 // appendProperty sets a property by the given name, appending any existing value
 // (appending existing value rather than the new value so that, in the case of style, we can ensure there's a semi-colon between values)
 func appendProperty(feature *geojson.Feature, propertyName string, value string) {
@@ -192,48 +585,189 @@ func appendProperty(feature *geojson.Feature, propertyName string, value string)
 // then iterates through the features assigning a title and style for the colour.
 func setChoroplethColoursAndTitles(features []*geojson.Feature, request *models.RenderRequest) {
 	choropleth := request.Choropleth
-	if choropleth == nil || request.Data == nil {
+	if choropleth == nil || (request.Data == nil && request.Suppressed == nil) {
 		return
 	}
 	id := idPrefix(request)
-	dataMap := mapDataToColour(request.Data, choropleth, id+ "-")
-	missingValueStyle := "fill: url(#" + id + "-nodata);"
+	dataMap := mapDataToColour(request.Data, request.Suppressed, request, id)
 	for _, feature := range features {
-		style := missingValueStyle
-		title, ok := feature.Properties[request.Geography.NameProperty]
+		name, ok := feature.Properties[request.Geography.NameProperty]
 		if !ok {
-			title = ""
+			name = ""
 		}
-		if vc, exists := dataMap[feature.ID]; exists {
-			style = "fill: " + vc.colour + ";"
-			title = fmt.Sprintf("%v %s%g%s", title, choropleth.ValuePrefix, vc.value, choropleth.ValueSuffix)
-		} else {
-			title = fmt.Sprintf("%v %s", title, MissingDataText)
+		fill, title := choroplethFillAndTitle(feature, name, dataMap, choropleth, id)
+		if vc, exists := dataMap[feature.ID]; exists && !vc.suppressed {
+			feature.Properties[DataValueAttribute] = fmt.Sprintf("%g", vc.value)
 		}
 		feature.Properties[request.Geography.NameProperty] = title
-		appendProperty(feature, "style", style)
+		appendProperty(feature, "style", "fill: "+fill+";")
+	}
+}
+
+// choroplethFillAndTitle returns the fill of the feature - its colour, or the suppressed or missing data pattern - and its title: the given name followed by its value, suppressed text or missing data text
+func choroplethFillAndTitle(feature *geojson.Feature, name interface{}, dataMap map[interface{}]valueAndColour, choropleth *models.Choropleth, id string) (string, string) {
+	if vc, exists := dataMap[feature.ID]; exists && vc.suppressed {
+		return vc.colour, fmt.Sprintf("%v %s", name, suppressedDataText(choropleth))
+	} else if exists && len(choropleth.TooltipTemplate) > 0 {
+		return vc.colour, formatTooltip(choropleth, name, vc)
+	} else if exists {
+		return vc.colour, fmt.Sprintf("%v %s", name, formatValue(choropleth, vc.value))
+	}
+	return "url(#" + id + "-nodata)", fmt.Sprintf("%v %s", name, missingDataText(choropleth))
+}
+
+// formatTooltip returns the title of a region with data from the choropleth's tooltip template, replacing {name}, {value}, {prefix}, {suffix}, {units} and {class_label}
+func formatTooltip(choropleth *models.Choropleth, name interface{}, vc valueAndColour) string {
+	return strings.NewReplacer(
+		"{name}", fmt.Sprintf("%v", name),
+		"{value}", formatNumber(choropleth, vc.value),
+		"{prefix}", choropleth.ValuePrefix,
+		"{suffix}", choropleth.ValueSuffix,
+		"{units}", choropleth.Units,
+		"{class_label}", vc.classLabel,
+	).Replace(choropleth.TooltipTemplate)
+}
+
+// legendTitle returns the title of the legends - the choropleth's units, or its prefix and suffix if it has no units
+func legendTitle(choropleth *models.Choropleth) string {
+	if len(choropleth.Units) > 0 {
+		return choropleth.Units
+	}
+	return choropleth.ValuePrefix + " " + choropleth.ValueSuffix
+}
+
+// mapAltText returns the accessible name of the map svg, e.g. "Map of Non-UK born population, in percentage points".
+// Returns an empty string if the choropleth has no units, in which case the map is described by its caption alone.
+func mapAltText(request *models.RenderRequest) string {
+	if request.Choropleth == nil || len(request.Choropleth.Units) == 0 {
+		return ""
+	}
+	if len(request.Title) == 0 {
+		return "Map in " + request.Choropleth.Units
+	}
+	return "Map of " + request.Title + ", in " + request.Choropleth.Units
+}
+
+// mapFallbackAlt returns the alt text of the map's fallback image, describing what the map shows for readers who can't see it - its subject, units,
+// the number of areas, the range of their values and how many have no data. Must be called after setDataAttributes, e.g. "Map of Non-UK born population, in percentage points, showing 348 areas. Values range from 1.2% to 38.5%. 2 areas have no data."
+func mapFallbackAlt(svgRequest *SVGRequest) string {
+	request := svgRequest.request
+	alt := "Map"
+	if len(request.Title) > 0 {
+		alt += " of " + request.Title
+	}
+	if request.Choropleth != nil && len(request.Choropleth.Units) > 0 {
+		alt += ", in " + request.Choropleth.Units
+	}
+	if svgRequest.geoJSON == nil || len(svgRequest.geoJSON.Features) == 0 {
+		return alt + "."
+	}
+	features := svgRequest.geoJSON.Features
+	alt += fmt.Sprintf(", showing %d %s.", len(features), plural(len(features), "area", "areas"))
+
+	data := make(map[string]float64, len(request.Data))
+	for _, row := range request.Data {
+		data[row.ID] = row.Value
+	}
+	min, max, missing := math.Inf(1), math.Inf(-1), 0
+	for _, feature := range features {
+		value, exists := data[fmt.Sprintf("%v", feature.Properties[DataIDAttribute])]
+		if !exists || math.IsNaN(value) {
+			missing++
+			continue
+		}
+		min, max = math.Min(min, value), math.Max(max, value)
+	}
+	if missing < len(features) {
+		alt += fmt.Sprintf(" Values range from %s to %s.", formatValue(request.Choropleth, min), formatValue(request.Choropleth, max))
+	}
+	if missing > 0 {
+		alt += fmt.Sprintf(" %d %s no data.", missing, plural(missing, "area has", "areas have"))
+	}
+	return alt
+}
+
+// legendFallbackAlt returns the alt text of the fallback images of the legends - the label of each break, e.g. "Legend, in percentage points: 0% to 10%, 10% to 20%, 20% to 30%"
+func legendFallbackAlt(request *models.RenderRequest) string {
+	alt := "Legend"
+	if request.Choropleth == nil {
+		return alt
+	}
+	if len(request.Choropleth.Units) > 0 {
+		alt += ", in " + request.Choropleth.Units
+	}
+	breaks := requestBreaks(request)
+	labels := make([]string, len(breaks))
+	for i := range breaks {
+		labels[i] = classLabel(request.Choropleth, breaks, i)
+	}
+	if len(labels) > 0 {
+		alt += ": " + strings.Join(labels, ", ")
+	}
+	return alt
+}
+
+// plural returns singular if n is 1, otherwise plural
+func plural(n int, singular string, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+// missingDataText returns the choropleth's label for regions without data, defaulting to MissingDataText
+func missingDataText(choropleth *models.Choropleth) string {
+	if choropleth != nil && len(choropleth.MissingDataText) > 0 {
+		return choropleth.MissingDataText
 	}
+	return MissingDataText
 }
 
-// mapDataToColour creates a map of DataRow.ID=valueAndColour
-func mapDataToColour(data []*models.DataRow, choropleth *models.Choropleth, prefix string) map[interface{}]valueAndColour {
-	breaks := sortBreaks(choropleth.Breaks, false)
+// showMissingDataKey returns true if the legends should include an entry for regions without data
+func showMissingDataKey(choropleth *models.Choropleth) bool {
+	return choropleth != nil && !choropleth.HideMissingDataKey
+}
+
+// suppressedDataText returns the choropleth's label for regions with suppressed data, defaulting to SuppressedDataText
+func suppressedDataText(choropleth *models.Choropleth) string {
+	if choropleth != nil && len(choropleth.SuppressedText) > 0 {
+		return choropleth.SuppressedText
+	}
+	return SuppressedDataText
+}
+
+// hasSuppressedValues returns true if the choropleth declares suppressed values, in which case the map uses the suppressed data pattern and the legends include an entry for it
+func hasSuppressedValues(choropleth *models.Choropleth) bool {
+	return choropleth != nil && len(choropleth.SuppressedValues) > 0
+}
+
+// mapDataToColour creates a map of DataRow.ID (prefixed with the id prefix of the map, as the feature ids are) to valueAndColour,
+// where the colour is the fill of the row's break - its colour or pattern - or the suppressed data pattern for suppressed rows
+func mapDataToColour(data []*models.DataRow, suppressed []*models.DataRow, request *models.RenderRequest, id string) map[interface{}]valueAndColour {
+	breaks := requestBreaks(request)
 
 	dataMap := make(map[interface{}]valueAndColour)
 	for _, row := range data {
-		dataMap[prefix+row.ID] = valueAndColour{value: row.Value, colour: getColour(row.Value, breaks)}
+		class := getClass(row.Value, breaks, upperInclusive(request.Choropleth))
+		dataMap[id+"-"+row.ID] = valueAndColour{value: row.Value, colour: breakFill(breaks[class], id, class), classLabel: classLabel(request.Choropleth, breaks, class)}
+	}
+	for _, row := range suppressed {
+		dataMap[id+"-"+row.ID] = valueAndColour{value: row.Value, colour: "url(#" + id + "-suppressed)", suppressed: true}
 	}
 	return dataMap
 }
 
-// getColour returns the colour for the given value. If the value is below the lowest lowerbound, returns the colour for the lowest.
-func getColour(value float64, breaks []*models.ChoroplethBreak) string {
-	for _, b := range breaks {
-		if value >= b.LowerBound {
-			return b.Colour
-		}
+// requestBreaks returns the breaks of the request's choropleth sorted in ascending order, with their colours converted to greys if the request is for a greyscale map.
+// Returns nil if the request has no choropleth.
+func requestBreaks(request *models.RenderRequest) []*models.ChoroplethBreak {
+	if request.Choropleth == nil {
+		return nil
 	}
-	return breaks[len(breaks)-1].Colour
+	breaks := sortBreaks(request.Choropleth.Breaks, true)
+	if request.Greyscale {
+		return greyscaleBreaks(breaks)
+	}
+	return breaks
 }
 
 // sortBreaks returns a copy of the breaks slice, sorted ascending or descending according to asc.
@@ -258,23 +792,43 @@ func RenderHorizontalKey(svgRequest *SVGRequest) string {
 	}
 	request := svgRequest.request
 
+	id := idPrefix(request)
+	keyClass := getKeyClass(request, "horizontal")
+	vbHeight := getHorizontalKeyHeight(svgRequest)
+	svgAttributes := fmt.Sprintf(`id="%s-legend-horizontal-svg" class="%s" viewBox="0 0 %.f %.f"`, id, keyClass, svgRequest.ViewBoxWidth, vbHeight)
+	if !svgRequest.responsiveSize {
+		svgAttributes += fmt.Sprintf(` width="%.f" height="%.f"`, svgRequest.ViewBoxWidth, vbHeight)
+	}
+
+	content := horizontalKeyContent(svgRequest)
+
+	converter := svgRequest.fallbackImageConverter(legendFallbackAlt(request))
+	if converter == nil || request.IncludeFallbackPng == false {
+		return fmt.Sprintf("<svg %s>%s</svg>", svgAttributes, content)
+	}
+	return converter.IncludeFallbackImage(svgAttributes, content, svgRequest.ViewBoxWidth, vbHeight)
+}
+
+// horizontalKeyContent returns the content of the horizontal key (i.e. everything within the svg element), with dimensions svgRequest.ViewBoxWidth x getHorizontalKeyHeight(svgRequest)
+func horizontalKeyContent(svgRequest *SVGRequest) string {
+	request := svgRequest.request
+
 	keyInfo := getHorizontalKeyInfo(svgRequest.ViewBoxWidth, svgRequest)
 	id := idPrefix(request)
 	missingId := id + "-horizontal"
 
-	content := bytes.NewBufferString("")
-	ticks := bytes.NewBufferString("")
+	content := getBuffer()
+	defer putBuffer(content)
+	ticks := getBuffer()
+	defer putBuffer(ticks)
 
 	fmt.Fprintf(content, "<defs>")
 	fmt.Fprintf(content, MissingDataPattern, missingId)
-	fmt.Fprintf(content, "</defs>")
-
-	keyClass := getKeyClass(request, "horizontal")
-	vbHeight := 90.0
-	svgAttributes := fmt.Sprintf(`id="%s-legend-horizontal-svg" class="%s" viewBox="0 0 %.f %.f"`, id, keyClass, svgRequest.ViewBoxWidth, vbHeight)
-	if !svgRequest.responsiveSize {
-		svgAttributes += fmt.Sprintf(` width="%.f" height="%.f"`, svgRequest.ViewBoxWidth, vbHeight)
+	if hasSuppressedValues(request.Choropleth) {
+		fmt.Fprintf(content, SuppressedDataPattern, missingId)
 	}
+	content.WriteString(breakPatterns(request, missingId))
+	fmt.Fprintf(content, "</defs>")
 
 	fmt.Fprintf(content, `<g id="%s-legend-horizontal-container">`, id)
 	writeHorizontalKeyTitle(request, svgRequest.ViewBoxWidth, content)
@@ -283,25 +837,48 @@ func RenderHorizontalKey(svgRequest *SVGRequest) string {
 	breaks := svgRequest.breaks
 	for i := 0; i < len(breaks); i++ {
 		width := breaks[i].RelativeSize * keyInfo.keyWidth
-		fmt.Fprintf(content, `<rect class="keyColour" height="8" width="%f" x="%f" style="stroke-width: 0.5; stroke: black; fill: %s;">`, width, left, breaks[i].Colour)
-		content.WriteString(`</rect>`)
-		writeHorizontalKeyTick(ticks, left, breaks[i].LowerBound)
+		rectX := left
+		if request.Choropleth.ReverseLegend { // drawn right-to-left
+			rectX = keyInfo.keyWidth - left - width
+		}
+		writeHorizontalKeySwatch(content, request.Choropleth, rectX, width, breaks[i].fill(missingId, i))
 		left += width
 	}
-	writeHorizontalKeyTick(ticks, left, breaks[len(breaks)-1].UpperBound)
-	if len(request.Choropleth.ReferenceValueText) > 0 {
-		writeHorizontalKeyRefTick(ticks, keyInfo, svgRequest)
+	positions, texts := getHorizontalTicks(breaks, keyInfo.keyWidth, request.Choropleth.ReverseLegend)
+	for i := range positions {
+		writeHorizontalKeyTick(ticks, positions[i], texts[i], i%keyInfo.tickRows)
+	}
+	if allBreaksLabelled(breaks) {
+		positions, texts = getHorizontalBreakLabels(breaks, keyInfo.keyWidth, request.Choropleth.ReverseLegend)
+		for i := range positions {
+			writeHorizontalKeyBreakLabel(ticks, positions[i], texts[i], i%keyInfo.tickRows)
+		}
+	}
+	for _, label := range keyInfo.references {
+		writeHorizontalKeyRefTick(ticks, keyInfo, label, svgRequest)
+	}
+	if keyInfo.swatchExtra > 0 { // the ticks start at the bottom of swatches larger than the default
+		fmt.Fprintf(content, `<g transform="translate(0, %g)">`, keyInfo.swatchExtra)
+		content.Write(ticks.Bytes())
+		content.WriteString(`</g>`)
+	} else {
+		content.Write(ticks.Bytes())
 	}
-	fmt.Fprint(content, ticks.String())
 
-	writeKeyMissingPattern(content, missingId, 0.0, 55.0, request.FontSize)
+	// the missing and suppressed data entries are below any larger swatches and extra rows of tick and reference labels
+	yPos := 55.0 + keyInfo.extraHeight()
+	suppressedX := 0.0
+	if showMissingDataKey(request.Choropleth) {
+		text := missingDataText(request.Choropleth)
+		writeKeyPattern(content, "missingPattern", missingId+"-nodata", text, 0.0, yPos, request.FontSize)
+		suppressedX = htmlutil.GetApproximateTextWidth(text, request.FontSize) + 32
+	}
+	if hasSuppressedValues(request.Choropleth) {
+		writeKeyPattern(content, "suppressedPattern", missingId+"-suppressed", suppressedDataText(request.Choropleth), suppressedX, yPos, request.FontSize)
+	}
 
 	content.WriteString(`</g></g>`)
-
-	if pngConverter == nil || request.IncludeFallbackPng == false {
-		return fmt.Sprintf("<svg %s>%s</svg>", svgAttributes, content)
-	}
-	return pngConverter.IncludeFallbackImage(svgAttributes, content.String(), svgRequest.ViewBoxWidth, vbHeight)
+	return content.String()
 }
 
 // RenderVerticalKey creates an SVG containing a vertically-oriented key for the choropleth
@@ -313,6 +890,29 @@ func RenderVerticalKey(svgRequest *SVGRequest) string {
 	}
 	request := svgRequest.request
 	svgHeight := svgRequest.ViewBoxHeight
+	keyWidth := svgRequest.VerticalLegendWidth
+
+	id := idPrefix(request)
+
+	keyClass := getKeyClass(request, "vertical")
+	attributes := fmt.Sprintf(`id="%s-legend-vertical-svg" class="%s" viewBox="0 0 %.f %.f"`, id, keyClass, keyWidth, svgHeight)
+	if !svgRequest.responsiveSize {
+		attributes += fmt.Sprintf(` width="%.f" height="%.f"`, keyWidth, svgHeight)
+	}
+
+	content := verticalKeyContent(svgRequest)
+
+	converter := svgRequest.fallbackImageConverter(legendFallbackAlt(request))
+	if converter == nil || request.IncludeFallbackPng == false {
+		return fmt.Sprintf("<svg %s>%s</svg>", attributes, content)
+	}
+	return converter.IncludeFallbackImage(attributes, content, keyWidth, svgHeight)
+}
+
+// verticalKeyContent returns the content of the vertical key (i.e. everything within the svg element), with dimensions svgRequest.VerticalLegendWidth x svgRequest.ViewBoxHeight
+func verticalKeyContent(svgRequest *SVGRequest) string {
+	request := svgRequest.request
+	svgHeight := svgRequest.ViewBoxHeight
 
 	breaks := svgRequest.breaks
 
@@ -321,55 +921,113 @@ func RenderVerticalKey(svgRequest *SVGRequest) string {
 
 	id := idPrefix(request)
 
-	content := bytes.NewBufferString("")
-	ticks := bytes.NewBufferString("")
+	content := getBuffer()
+	defer putBuffer(content)
+	ticks := getBuffer()
+	defer putBuffer(ticks)
 
 	missingId := id + "-vertical"
 
 	fmt.Fprintf(content, "<defs>")
 	fmt.Fprintf(content, MissingDataPattern, missingId)
-	fmt.Fprintf(content, "</defs>")
-
-	keyClass := getKeyClass(request, "vertical")
-	attributes := fmt.Sprintf(`id="%s-legend-vertical-svg" class="%s" viewBox="0 0 %.f %.f"`, id, keyClass, keyWidth, svgHeight)
-	if !svgRequest.responsiveSize {
-		attributes += fmt.Sprintf(` width="%.f" height="%.f"`, keyWidth, svgHeight)
+	if hasSuppressedValues(request.Choropleth) {
+		fmt.Fprintf(content, SuppressedDataPattern, missingId)
 	}
+	content.WriteString(breakPatterns(request, missingId))
+	fmt.Fprintf(content, "</defs>")
 
 	fmt.Fprintf(content, `<g id="%s-legend-vertical-container">`, id)
 	writeVerticalLegendTitle(content, keyWidth, svgHeight, request)
 	fmt.Fprintf(content, `<g id="%s-legend-vertical-key" transform="translate(%f, %f)">`, id, (keyWidth+offset)/2, svgHeight*0.1)
+	reverse := request.Choropleth.ReverseLegend
 	position := 0.0
 	for i := 0; i < len(breaks); i++ {
 		height := breaks[i].RelativeSize * keyHeight
-		adjustedPosition := keyHeight - position
-		fmt.Fprintf(content, `<rect class="keyColour" height="%f" width="8" y="%f" style="stroke-width: 0.5; stroke: black; fill: %s;">`, height, adjustedPosition-height, breaks[i].Colour)
-		content.WriteString(`</rect>`)
-		writeVerticalKeyTick(ticks, adjustedPosition, breaks[i].LowerBound)
+		adjustedPosition, rectY := keyHeight-position, keyHeight-position-height
+		if reverse { // drawn top-to-bottom
+			adjustedPosition, rectY = position, position
+		}
+		writeVerticalKeySwatch(content, request.Choropleth, rectY, height, breaks[i].fill(missingId, i))
+		writeVerticalKeyTick(ticks, adjustedPosition, lowerTickText(breaks, i), swatchSize(request.Choropleth))
+		if allBreaksLabelled(breaks) {
+			writeVerticalKeyBreakLabel(ticks, rectY+height/2, breaks[i].Label)
+		}
 		position += height
 	}
-	writeVerticalKeyTick(ticks, keyHeight-position, breaks[len(breaks)-1].UpperBound)
-	if len(request.Choropleth.ReferenceValueText) > 0 {
-		writeVerticalKeyRefTick(ticks, keyHeight-(keyHeight*svgRequest.referencePos), request)
+	if !reverse {
+		position = keyHeight - position
+	}
+	writeVerticalKeyTick(ticks, position, upperTickText(breaks), swatchSize(request.Choropleth))
+	offsets := getVerticalRefLabelOffsets(svgRequest.references, keyHeight)
+	for i, ref := range svgRequest.references {
+		writeVerticalKeyRefTick(ticks, keyHeight-(keyHeight*ref.Pos), offsets[i], ref, request)
 	}
-	fmt.Fprint(content, ticks.String())
+	content.Write(ticks.Bytes())
 	content.WriteString(`</g>`)
 
-	xPos := (keyWidth - float64(htmlutil.GetApproximateTextWidth(MissingDataText, request.FontSize)+12)) / 2
-	writeKeyMissingPattern(content, missingId, xPos, svgHeight*0.95, request.FontSize)
+	// when there are two entries, they're placed either side of the position of a single entry
+	yPos, spacing := svgHeight*0.95, 0.0
+	if showMissingDataKey(request.Choropleth) && hasSuppressedValues(request.Choropleth) {
+		spacing = float64(request.FontSize) + 4
+		yPos -= spacing / 2
+	}
+	if showMissingDataKey(request.Choropleth) {
+		text := missingDataText(request.Choropleth)
+		xPos := (keyWidth - float64(htmlutil.GetApproximateTextWidth(text, request.FontSize)+12)) / 2
+		writeKeyPattern(content, "missingPattern", missingId+"-nodata", text, xPos, yPos, request.FontSize)
+		yPos += spacing
+	}
+	if hasSuppressedValues(request.Choropleth) {
+		text := suppressedDataText(request.Choropleth)
+		xPos := (keyWidth - float64(htmlutil.GetApproximateTextWidth(text, request.FontSize)+12)) / 2
+		writeKeyPattern(content, "suppressedPattern", missingId+"-suppressed", text, xPos, yPos, request.FontSize)
+	}
 
 	content.WriteString(`</g>`)
+	return content.String()
+}
 
-	if pngConverter == nil || request.IncludeFallbackPng == false {
-		return fmt.Sprintf("<svg %s>%s</svg>", attributes, content)
+// renderOverlayKeys returns a group for each key that should be drawn inside the map (i.e. has one of the 'inside' legend positions),
+// scaled down and positioned in the appropriate corner of the map
+func renderOverlayKeys(svgRequest *SVGRequest) []string {
+	request := svgRequest.request
+	if request.Choropleth == nil || len(svgRequest.breaks) == 0 {
+		return nil
 	}
-	return pngConverter.IncludeFallbackImage(attributes, content.String(), keyWidth, svgHeight)
+	var overlays []string
+	if isInsidePosition(request.Choropleth.HorizontalLegendPosition) {
+		overlays = append(overlays, overlayKey(svgRequest, "horizontal", request.Choropleth.HorizontalLegendPosition, horizontalKeyContent(svgRequest), svgRequest.ViewBoxWidth, getHorizontalKeyHeight(svgRequest)))
+	}
+	if isInsidePosition(request.Choropleth.VerticalLegendPosition) {
+		overlays = append(overlays, overlayKey(svgRequest, "vertical", request.Choropleth.VerticalLegendPosition, verticalKeyContent(svgRequest), svgRequest.VerticalLegendWidth, svgRequest.ViewBoxHeight))
+	}
+	return overlays
+}
+
+// overlayKey wraps the key content in a group with a semi-opaque background, scaled by overlayKeyScale and translated to the corner of the map given by position
+func overlayKey(svgRequest *SVGRequest, keyType string, position string, content string, width float64, height float64) string {
+	scaledWidth, scaledHeight := width*overlayKeyScale, height*overlayKeyScale
+	x, y := 0.0, 0.0
+	if position == models.LegendPositionInsideTopRight || position == models.LegendPositionInsideBottomRight {
+		x = svgRequest.ViewBoxWidth - scaledWidth
+	}
+	if position == models.LegendPositionInsideBottomLeft || position == models.LegendPositionInsideBottomRight {
+		y = svgRequest.ViewBoxHeight - scaledHeight
+	}
+	id := idPrefix(svgRequest.request)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	fmt.Fprintf(buf, `<g id="%s-legend-%s-overlay" class="map_key_%s map_key_overlay" transform="translate(%f, %f) scale(%g)">`, id, keyType, keyType, x, y, overlayKeyScale)
+	fmt.Fprintf(buf, `<rect class="map_key_overlay_background" width="%f" height="%f" style="fill: white; fill-opacity: 0.8;"></rect>`, width, height)
+	buf.WriteString(content)
+	buf.WriteString(`</g>`)
+	return buf.String()
 }
 
 func writeVerticalLegendTitle(content *bytes.Buffer, keyWidth float64, svgHeight float64, request *models.RenderRequest) (int, error) {
-	text := request.Choropleth.ValuePrefix + " " + request.Choropleth.ValueSuffix
+	text := legendTitle(request.Choropleth)
 	textLen := htmlutil.GetApproximateTextWidth(text, request.FontSize)
-	return fmt.Fprintf(content, `<text x="%f" y="%f" dy=".5em" style="text-anchor: middle;" class="keyText" textLength="%.f" lengthAdjust="spacingAndGlyphs">%s</text>`, keyWidth/2, svgHeight*0.05, textLen, text)
+	return fmt.Fprintf(content, `<text x="%f" y="%f" dy=".5em" style="text-anchor: middle;" class="keyText" textLength="%.f" lengthAdjust="spacingAndGlyphs">%s</text>`, keyWidth/2, svgHeight*0.05, textLen, html.EscapeString(text))
 }
 
 // getKeyClass returns the class of the map key - with an additional class if both keys are rendered.
@@ -381,6 +1039,14 @@ func getKeyClass(request *models.RenderRequest, keyType string) string {
 	return keyClass
 }
 
+// isInsidePosition returns true if the legend position is one that places the key inside the map
+func isInsidePosition(position string) bool {
+	return position == models.LegendPositionInsideTopLeft ||
+		position == models.LegendPositionInsideTopRight ||
+		position == models.LegendPositionInsideBottomLeft ||
+		position == models.LegendPositionInsideBottomRight
+}
+
 // hasVerticalLegend returns true if the request includes a vertical legend
 func hasVerticalLegend(request *models.RenderRequest) bool {
 	return request.Choropleth != nil &&
@@ -398,8 +1064,14 @@ func hasHorizontalLegend(request *models.RenderRequest) bool {
 // getVerticalLegendWidth determines the approximate width required for the legend
 // it also returns an offset for the position of the key. I.e. the middle of the key should be positioned in the middle of the legend, plus the offset.
 func getVerticalLegendWidth(request *models.RenderRequest, breaks []*breakInfo) (float64, float64) {
-	missingWidth := htmlutil.GetApproximateTextWidth(MissingDataText, request.FontSize) + 12
-	titleWidth := htmlutil.GetApproximateTextWidth(request.Choropleth.ValuePrefix+" "+request.Choropleth.ValueSuffix, request.FontSize)
+	missingWidth := 0.0
+	if showMissingDataKey(request.Choropleth) {
+		missingWidth = htmlutil.GetApproximateTextWidth(missingDataText(request.Choropleth), request.FontSize) + 12
+	}
+	if hasSuppressedValues(request.Choropleth) {
+		missingWidth = math.Max(missingWidth, htmlutil.GetApproximateTextWidth(suppressedDataText(request.Choropleth), request.FontSize)+12)
+	}
+	titleWidth := htmlutil.GetApproximateTextWidth(legendTitle(request.Choropleth), request.FontSize)
 	maxWidth := math.Max(float64(missingWidth), float64(titleWidth))
 	keyWidth, offset := getVerticalTickTextWidth(request, breaks)
 	return math.Max(maxWidth, keyWidth) + 10, offset
@@ -408,85 +1080,222 @@ func getVerticalLegendWidth(request *models.RenderRequest, breaks []*breakInfo)
 // getVerticalTickTextWidth calculates the approximate total width of the ticks on both sides of the key, allowing 38 pixels for the colour bar
 // it also returns an offset for the position of the key. I.e. the middle of the key should be positioned in the middle of the legend, plus the offset.
 func getVerticalTickTextWidth(request *models.RenderRequest, breaks []*breakInfo) (float64, float64) {
-	maxTick := 0.0
-	for _, b := range breaks {
-		lbound := htmlutil.GetApproximateTextWidth(fmt.Sprintf("%g", b.LowerBound), request.FontSize)
+	maxTick := htmlutil.GetApproximateTextWidth(upperTickText(breaks), request.FontSize)
+	for i := range breaks {
+		lbound := htmlutil.GetApproximateTextWidth(lowerTickText(breaks, i), request.FontSize)
 		if lbound > maxTick {
 			maxTick = lbound
 		}
-		ubound := htmlutil.GetApproximateTextWidth(fmt.Sprintf("%g", b.UpperBound), request.FontSize)
-		if ubound > maxTick {
-			maxTick = ubound
+		if allBreaksLabelled(breaks) {
+			maxTick = math.Max(maxTick, htmlutil.GetApproximateTextWidth(breaks[i].Label, request.FontSize))
 		}
 	}
-	refTick := htmlutil.GetApproximateTextWidth(request.Choropleth.ReferenceValueText, request.FontSize)
-	refValue := htmlutil.GetApproximateTextWidth(fmt.Sprintf("%g", request.Choropleth.ReferenceValue), request.FontSize)
-	refWidth := math.Max(refTick, refValue)
-	return maxTick + refWidth + 38.0, maxTick - refWidth
+	refWidth := htmlutil.GetApproximateTextWidth(fmt.Sprintf("%g", request.Choropleth.ReferenceValue), request.FontSize)
+	for _, ref := range choroplethReferences(request.Choropleth) {
+		refWidth = math.Max(refWidth, htmlutil.GetApproximateTextWidth(ref.Text, request.FontSize))
+		refWidth = math.Max(refWidth, htmlutil.GetApproximateTextWidth(fmt.Sprintf("%g", ref.Value), request.FontSize))
+	}
+	return maxTick + refWidth + 38.0 + swatchExtra(request.Choropleth), maxTick - refWidth
 }
 
 // writeHorizontalKeyTitle write the title above the key for a horizontal legend, ensuring that the text fits within the svg
 func writeHorizontalKeyTitle(request *models.RenderRequest, svgWidth float64, content *bytes.Buffer) {
 	textAdjust := ""
-	titleText := request.Choropleth.ValuePrefix + " " + request.Choropleth.ValueSuffix
+	titleText := legendTitle(request.Choropleth)
 	titleTextLen := htmlutil.GetApproximateTextWidth(titleText, request.FontSize)
 	if titleTextLen >= svgWidth {
 		textAdjust = fmt.Sprintf(` textLength="%.f" lengthAdjust="spacingAndGlyphs"`, svgWidth-2)
 	}
-	fmt.Fprintf(content, `<text x="%f" y="6" dy=".5em" style="text-anchor: middle;" class="keyText"%s>%s</text>`, svgWidth/2.0, textAdjust, titleText)
+	fmt.Fprintf(content, `<text x="%f" y="6" dy=".5em" style="text-anchor: middle;" class="keyText"%s>%s</text>`, svgWidth/2.0, textAdjust, html.EscapeString(titleText))
+}
+
+// allBreaksLabelled returns true if every break has a label (e.g. "Low", "Medium" and "High"), in which case the legends show the label of each break
+// alongside it in place of the numeric bounds at the ticks
+func allBreaksLabelled(breaks []*breakInfo) bool {
+	for _, b := range breaks {
+		if len(b.Label) == 0 {
+			return false
+		}
+	}
+	return len(breaks) > 0
 }
 
-// writeHorizontalKeyTick draws a vertical line (the tick) at the given position, labelling it with the given value
-func writeHorizontalKeyTick(w *bytes.Buffer, xPos float64, value float64) {
+// lowerTickText returns the label of the tick at the lower bound of the ith break - the break's lower bound,
+// or, for the first of several breaks, its label if it has one (e.g. "under 10") in place of the computed minimum.
+// Returns an empty string if every break is labelled.
+func lowerTickText(breaks []*breakInfo, i int) string {
+	if allBreaksLabelled(breaks) {
+		return ""
+	}
+	if i == 0 && len(breaks) > 1 && len(breaks[0].Label) > 0 {
+		return breaks[0].Label
+	}
+	return fmt.Sprintf("%g", breaks[i].LowerBound)
+}
+
+// upperTickText returns the label of the tick at the upper bound of the last break - its label if it has one (e.g. "50+"), otherwise the computed maximum.
+// Returns an empty string if every break is labelled.
+func upperTickText(breaks []*breakInfo) string {
+	if allBreaksLabelled(breaks) {
+		return ""
+	}
+	last := breaks[len(breaks)-1]
+	if len(last.Label) > 0 {
+		return last.Label
+	}
+	return fmt.Sprintf("%g", last.UpperBound)
+}
+
+// writeHorizontalKeyTick draws a vertical line (the tick) at the given position, labelling it with the given text (if any) in the given row below the key
+func writeHorizontalKeyTick(w *bytes.Buffer, xPos float64, text string, row int) {
+	offset := float64(row) * referenceRowHeight
 	fmt.Fprintf(w, `<g class="map__tick" transform="translate(%f, 0)">`, xPos)
-	w.WriteString(`<line x2="0" y2="15" style="stroke-width: 1; stroke: Black;"></line>`)
-	fmt.Fprintf(w, `<text x="0" y="18" dy=".74em" style="text-anchor: middle;" class="keyText">%g</text>`, value)
+	fmt.Fprintf(w, `<line x2="0" y2="%.f" style="stroke-width: 1; stroke: Black;"></line>`, 15+offset)
+	if len(text) > 0 {
+		fmt.Fprintf(w, `<text x="0" y="%.f" dy=".74em" style="text-anchor: middle;" class="keyText">%s</text>`, 18+offset, html.EscapeString(text))
+	}
 	w.WriteString(`</g>`)
 }
 
-// writeVerticalKeyTick draws a horizontal line (the tick) at the given position, labelling it with the given value
-func writeVerticalKeyTick(w *bytes.Buffer, yPos float64, value float64) {
+// writeHorizontalKeyBreakLabel draws the label of a break centred on the given position, in the given row below the key
+func writeHorizontalKeyBreakLabel(w *bytes.Buffer, xPos float64, text string, row int) {
+	offset := float64(row) * referenceRowHeight
+	fmt.Fprintf(w, `<text x="%f" y="%.f" dy=".74em" style="text-anchor: middle;" class="keyText">%s</text>`, xPos, 18+offset, html.EscapeString(text))
+}
+
+// getHorizontalBreakLabels returns the positions and labels of the breaks of the horizontal key with the given width - the middle of each break
+func getHorizontalBreakLabels(breaks []*breakInfo, keyWidth float64, reverse bool) ([]float64, []string) {
+	positions := make([]float64, len(breaks))
+	texts := make([]string, len(breaks))
+	left := 0.0
+	for i, b := range breaks {
+		width := b.RelativeSize * keyWidth
+		positions[i], texts[i] = left+width/2, b.Label
+		if reverse { // drawn right-to-left
+			positions[i] = keyWidth - positions[i]
+		}
+		left += width
+	}
+	return positions, texts
+}
+
+// getHorizontalTicks returns the positions and labels of the ticks of the horizontal key with the given width - the lower bound of each break, followed by the upper bound of the last
+func getHorizontalTicks(breaks []*breakInfo, keyWidth float64, reverse bool) ([]float64, []string) {
+	positions := make([]float64, 0, len(breaks)+1)
+	texts := make([]string, 0, len(breaks)+1)
+	left := 0.0
+	for i, b := range breaks {
+		positions = append(positions, left)
+		texts = append(texts, lowerTickText(breaks, i))
+		left += b.RelativeSize * keyWidth
+	}
+	positions = append(positions, left)
+	texts = append(texts, upperTickText(breaks))
+	if reverse { // drawn right-to-left
+		for i := range positions {
+			positions[i] = keyWidth - positions[i]
+		}
+	}
+	return positions, texts
+}
+
+// getHorizontalTickRows returns 2 if the labels of any neighbouring ticks of the horizontal key would overlap, in which case alternate labels are moved to a second row, otherwise 1
+func getHorizontalTickRows(request *models.RenderRequest, breaks []*breakInfo, keyWidth float64) int {
+	positions, texts := getHorizontalTicks(breaks, keyWidth, false)
+	if allBreaksLabelled(breaks) {
+		positions, texts = getHorizontalBreakLabels(breaks, keyWidth, false)
+	}
+	for i := 1; i < len(positions); i++ {
+		halfWidths := (htmlutil.GetApproximateTextWidth(texts[i-1], request.FontSize) + htmlutil.GetApproximateTextWidth(texts[i], request.FontSize)) / 2
+		if positions[i]-positions[i-1] < halfWidths+referenceLabelGap {
+			return 2
+		}
+	}
+	return 1
+}
+
+// writeVerticalKeyTick draws a horizontal line (the tick) from the right hand edge of the swatches (of the given size) at the given position, labelling it with the given text (if any)
+func writeVerticalKeyTick(w *bytes.Buffer, yPos float64, text string, swatchSize float64) {
 	fmt.Fprintf(w, `<g class="map__tick" transform="translate(0, %f)">`, yPos)
-	w.WriteString(`<line x1="8" x2="-15" style="stroke-width: 1; stroke: Black;"></line>`)
-	fmt.Fprintf(w, `<text x="-18" y="0" dy="0.32em" style="text-anchor: end;" class="keyText">%g</text>`, value)
+	fmt.Fprintf(w, `<line x1="%g" x2="-15" style="stroke-width: 1; stroke: Black;"></line>`, swatchSize)
+	if len(text) > 0 {
+		fmt.Fprintf(w, `<text x="-18" y="0" dy="0.32em" style="text-anchor: end;" class="keyText">%s</text>`, html.EscapeString(text))
+	}
 	w.WriteString(`</g>`)
 }
 
-// writeHorizontalKeyRefTick draws a vertical line at the correct position for the reference value, labelling it with the reference value and reference text.
-func writeHorizontalKeyRefTick(w *bytes.Buffer, keyInfo *horizontalKeyInfo, svgRequest *SVGRequest) {
-	xPos := keyInfo.keyWidth * svgRequest.referencePos
+// writeVerticalKeyBreakLabel draws the label of a break to the left of the key, centred on the given position
+func writeVerticalKeyBreakLabel(w *bytes.Buffer, yPos float64, text string) {
+	fmt.Fprintf(w, `<text x="-18" y="%f" dy="0.32em" style="text-anchor: end;" class="keyText">%s</text>`, yPos, html.EscapeString(text))
+}
+
+// referenceColour returns the colour of the reference tick and its labels, defaulting to DimGrey
+func referenceColour(choropleth *models.Choropleth) string {
+	if len(choropleth.ReferenceColour) > 0 {
+		return choropleth.ReferenceColour
+	}
+	return "DimGrey"
+}
+
+// referenceLineStyle returns the style of the line of the reference tick - its colour and dash pattern
+func referenceLineStyle(choropleth *models.Choropleth) string {
+	style := "stroke-width: 1; stroke: " + referenceColour(choropleth) + ";"
+	if len(strings.TrimSpace(choropleth.ReferenceDash)) > 0 {
+		style += " stroke-dasharray: " + strings.TrimSpace(choropleth.ReferenceDash) + ";"
+	}
+	return style
+}
+
+// writeHorizontalKeyRefTick draws a vertical line at the correct position for the reference value, labelling it with the reference value and reference text
+// in the label's row below the key.
+func writeHorizontalKeyRefTick(w *bytes.Buffer, keyInfo *horizontalKeyInfo, label *horizontalRefLabel, svgRequest *SVGRequest) {
+	xPos := keyInfo.keyWidth * label.tick.Pos
+	yPos := 33 + keyInfo.tickExtraHeight() + float64(label.row)*referenceRowHeight
 	svgWidth := svgRequest.ViewBoxWidth
 	fmt.Fprintf(w, `<g class="map__tick" transform="translate(%f, 0)">`, xPos)
-	w.WriteString(`<line x2="0" y1="8" y2="45" style="stroke-width: 1; stroke: DimGrey;"></line>`)
+	choropleth := svgRequest.request.Choropleth
+	colour := html.EscapeString(referenceColour(choropleth))
+	fmt.Fprintf(w, `<line x2="0" y1="8" y2="%.f" style="%s"></line>`, yPos+12, html.EscapeString(referenceLineStyle(choropleth)))
 	textAttr := ""
-	if keyInfo.referenceTextLeftLen > xPos+keyInfo.keyX { // adjust the text length so it will fit
+	if label.leftLen > xPos+keyInfo.keyX { // adjust the text length so it will fit
 		textAttr = fmt.Sprintf(` textLength="%.f" lengthAdjust="spacingAndGlyphs"`, xPos+keyInfo.keyX-1)
 	}
-	fmt.Fprintf(w, `<text x="0" y="33" dx="-0.1em" dy=".74em" style="text-anchor: end; fill: DimGrey;" class="keyText"%s>%s</text>`, textAttr, keyInfo.referenceTextLeft)
+	fmt.Fprintf(w, `<text x="0" y="%.f" dx="-0.1em" dy=".74em" style="text-anchor: end; fill: %s;" class="keyText"%s>%s</text>`, yPos, colour, textAttr, html.EscapeString(label.left))
 	textAttr = ""
-	if keyInfo.referenceTextRightLen > svgWidth-(xPos+keyInfo.keyX) { // adjust the text length so it will fit
+	if label.rightLen > svgWidth-(xPos+keyInfo.keyX) { // adjust the text length so it will fit
 		textAttr = fmt.Sprintf(` textLength="%.f" lengthAdjust="spacingAndGlyphs"`, svgWidth-(xPos+keyInfo.keyX)-2)
 	}
-	fmt.Fprintf(w, `<text x="0" y="33" dx="0.1em" dy=".74em" style="text-anchor: start; fill: DimGrey;" class="keyText"%s>%s</text>`, textAttr, keyInfo.referenceTextRight)
+	fmt.Fprintf(w, `<text x="0" y="%.f" dx="0.1em" dy=".74em" style="text-anchor: start; fill: %s;" class="keyText"%s>%s</text>`, yPos, colour, textAttr, html.EscapeString(label.right))
 	fmt.Fprintf(w, `</g>`)
 }
 
-// writeVerticalKeyRefTick draws a horizontal line at the correct position for the reference value, labelling it with the reference value and reference text.
-func writeVerticalKeyRefTick(w *bytes.Buffer, yPos float64, request *models.RenderRequest) {
-	text, value := request.Choropleth.ReferenceValueText, request.Choropleth.ReferenceValue
-	textLen := htmlutil.GetApproximateTextWidth(text, request.FontSize)
+// writeVerticalKeyRefTick draws a horizontal line at the correct position for the reference value, labelling it with the reference text above the line
+// and the reference value below it (or the other way round if the text is placed after the tick). The labels are moved down by the given offset.
+func writeVerticalKeyRefTick(w *bytes.Buffer, yPos float64, offset float64, ref *referenceTick, request *models.RenderRequest) {
+	choropleth := request.Choropleth
+	textLen := htmlutil.GetApproximateTextWidth(ref.Text, request.FontSize)
+	textDy, valueDy := "-.32em", "1em"
+	if choropleth.ReferenceLabelPlacement == models.ReferenceLabelAfter {
+		textDy, valueDy = "1em", "-.32em"
+	}
+	y := ""
+	if offset > 0 {
+		y = fmt.Sprintf(` y="%f"`, offset)
+	}
+	extra := swatchExtra(choropleth) // the tick and its labels are moved right of swatches larger than the default
+	colour := html.EscapeString(referenceColour(choropleth))
 	fmt.Fprintf(w, `<g class="map__tick" transform="translate(0, %f)">`, yPos)
-	w.WriteString(`<line x2="45" x1="8" style="stroke-width: 1; stroke: DimGrey;"></line>`)
-	fmt.Fprintf(w, `<text x="18" dy="-.32em" style="text-anchor: start; fill: DimGrey;" class="keyText" textLength="%.f" lengthAdjust="spacingAndGlyphs">%s</text>`, textLen, text)
-	fmt.Fprintf(w, `<text x="18" dy="1em" style="text-anchor: start; fill: DimGrey;" class="keyText">%g</text>`, value)
+	fmt.Fprintf(w, `<line x2="%g" x1="%g" style="%s"></line>`, 45+extra, 8+extra, html.EscapeString(referenceLineStyle(choropleth)))
+	fmt.Fprintf(w, `<text x="%g"%s dy="%s" style="text-anchor: start; fill: %s;" class="keyText" textLength="%.f" lengthAdjust="spacingAndGlyphs">%s</text>`, 18+extra, y, textDy, colour, textLen, html.EscapeString(ref.Text))
+	fmt.Fprintf(w, `<text x="%g"%s dy="%s" style="text-anchor: start; fill: %s;" class="keyText">%g</text>`, 18+extra, y, valueDy, colour, ref.Value)
 	w.WriteString(`</g>`)
 }
 
-// writeKeyMissingPattern draws a square filled with the missing pattern at the given position, labelling it with MissingDataText
-func writeKeyMissingPattern(w *bytes.Buffer, id string, xPos float64, yPos float64, fontSize int) {
-	fmt.Fprintf(w, `<g class="missingPattern" transform="translate(%f, %f)">`, xPos, yPos)
-	fmt.Fprintf(w, `<rect class="keyColour" height="8" width="8" style="stroke-width: 0.8; stroke: black; fill: url(#%s-nodata);"></rect>`, id)
-	fmt.Fprintf(w, `<text x="12" dy=".55em" style="text-anchor: start; fill: DimGrey;" class="keyText" textLength="%.f" lengthAdjust="spacingAndGlyphs">%s</text>`, htmlutil.GetApproximateTextWidth(MissingDataText, fontSize), MissingDataText)
+// writeKeyPattern draws a square filled with the pattern with the given id at the given position, labelling it with the given text
+func writeKeyPattern(w *bytes.Buffer, class string, patternID string, text string, xPos float64, yPos float64, fontSize int) {
+	fmt.Fprintf(w, `<g class="%s" transform="translate(%f, %f)">`, class, xPos, yPos)
+	fmt.Fprintf(w, `<rect class="keyColour" height="8" width="8" style="stroke-width: 0.8; stroke: black; fill: url(#%s);"></rect>`, patternID)
+	fmt.Fprintf(w, `<text x="12" dy=".55em" style="text-anchor: start; fill: DimGrey;" class="keyText" textLength="%.f" lengthAdjust="spacingAndGlyphs">%s</text>`, htmlutil.GetApproximateTextWidth(text, fontSize), html.EscapeString(text))
 	w.WriteString(`</g>`)
 }
 
@@ -496,55 +1305,91 @@ type breakInfo struct {
 	UpperBound   float64
 	RelativeSize float64
 	Colour       string
+	Pattern      string
+	Label        string
+}
+
+// fill returns the fill of the break's swatch in a legend - its pattern (with the given id prefix and class), if it has one, otherwise its colour
+func (b *breakInfo) fill(prefix string, class int) string {
+	return breakFill(&models.ChoroplethBreak{Colour: b.Colour, Pattern: b.Pattern}, prefix, class)
 }
 
 // getSortedBreakInfo returns information about the breaks - lowerBound, upperBound and relative size
 // where the lowerBound of the first break is the lowest of the LowerBound and the lowest value in data
 // and the upperBound of the last break is the maximum value in the data
-// also returns the relative position of the reference value
-func getSortedBreakInfo(request *models.RenderRequest) ([]*breakInfo, float64) {
+// also returns the reference ticks, with the relative positions of the reference values along the key (from the right or top of the key if the legend is reversed)
+func getSortedBreakInfo(request *models.RenderRequest) ([]*breakInfo, []*referenceTick) {
 
 	data := make([]*models.DataRow, len(request.Data))
 	copy(data, request.Data)
 	sort.Slice(data, func(i, j int) bool { return data[i].Value < data[j].Value })
 
-	breaks := sortBreaks(request.Choropleth.Breaks, true)
+	breaks := requestBreaks(request)
 	minValue := math.Min(data[0].Value, breaks[0].LowerBound)
 	maxValue := request.Choropleth.UpperBound
 	if maxValue < breaks[len(breaks)-1].LowerBound {
 		maxValue = data[len(data)-1].Value
 	}
-	totalRange := maxValue - minValue
+	scale := keyScale(request.Choropleth, minValue)
+	totalRange := scale(maxValue) - scale(minValue)
 
 	breakCount := len(breaks)
 	info := make([]*breakInfo, breakCount)
 	for i := 0; i < breakCount-1; i++ {
-		info[i] = &breakInfo{LowerBound: breaks[i].LowerBound, UpperBound: breaks[i+1].LowerBound, Colour: breaks[i].Colour}
+		info[i] = &breakInfo{LowerBound: breaks[i].LowerBound, UpperBound: breaks[i+1].LowerBound, Colour: breaks[i].Colour, Pattern: breaks[i].Pattern, Label: breaks[i].Label}
 	}
 	info[0].LowerBound = minValue
-	info[breakCount-1] = &breakInfo{LowerBound: breaks[breakCount-1].LowerBound, UpperBound: maxValue, Colour: breaks[breakCount-1].Colour}
+	info[breakCount-1] = &breakInfo{LowerBound: breaks[breakCount-1].LowerBound, UpperBound: maxValue, Colour: breaks[breakCount-1].Colour, Pattern: breaks[breakCount-1].Pattern, Label: breaks[breakCount-1].Label}
 	for _, b := range info {
-		b.RelativeSize = (b.UpperBound - b.LowerBound) / totalRange
+		b.RelativeSize = (scale(b.UpperBound) - scale(b.LowerBound)) / totalRange
+	}
+	var references []*referenceTick
+	for _, ref := range choroplethReferences(request.Choropleth) {
+		pos := (scale(ref.Value) - scale(minValue)) / totalRange
+		if math.IsNaN(pos) || math.IsInf(pos, 0) {
+			pos = 0 // a reference value that can't be shown on a log scale is placed at the start of the key
+		}
+		if request.Choropleth.ReverseLegend {
+			pos = 1 - pos
+		}
+		references = append(references, &referenceTick{Value: ref.Value, Text: ref.Text, Pos: pos})
+	}
+	return info, references
+}
+
+// keyScale returns the function that maps values to distances along the legends - the logarithm of the value if the choropleth has a log key scale
+// and every value in the legends (i.e. the minimum value) is positive, otherwise the value itself
+func keyScale(choropleth *models.Choropleth, minValue float64) func(float64) float64 {
+	if choropleth.KeyScale == models.KeyScaleLog && minValue > 0 {
+		return math.Log
 	}
-	referencePos := (request.Choropleth.ReferenceValue - minValue) / totalRange
-	return info, referencePos
+	return func(value float64) float64 { return value }
 }
 
-// horizontalKeyInfo contains break info, the width of the key, the x position of the key, and reference tick values
+// horizontalKeyInfo contains the width of the key, the x position of the key, and the labels of the reference ticks
 type horizontalKeyInfo struct {
-	referenceTextLeft     string
-	referenceTextLeftLen  float64
-	referenceTextRight    string
-	referenceTextRightLen float64
-	keyWidth              float64
-	keyX                  float64
+	references    []*horizontalRefLabel
+	referenceRows int     // the number of rows of reference labels
+	tickRows      int     // the number of rows of tick labels - 2 if alternate labels are moved down to stop them overlapping
+	swatchExtra   float64 // the distance the ticks are moved down to make room for swatches larger than the default
+	keyWidth      float64
+	keyX          float64
+}
+
+// extraHeight returns the height of the rows of tick and reference labels after the first of each, plus the extra height of larger swatches
+func (info *horizontalKeyInfo) extraHeight() float64 {
+	return info.swatchExtra + info.tickExtraHeight() + math.Max(float64(info.referenceRows-1), 0)*referenceRowHeight
+}
+
+// tickExtraHeight returns the height of the second row of tick labels, if there is one
+func (info *horizontalKeyInfo) tickExtraHeight() float64 {
+	return float64(info.tickRows-1) * referenceRowHeight
 }
 
-// getHorizontalKeyInfo returns the width of the key, the x position of the key, the breaks within the key, and reference tick values
-// (making sure that the longer of the reference value and text is given the most space)
+// getHorizontalKeyInfo returns the width of the key, the x position of the key, and the labels of the reference ticks
+// (making sure that the longer of each reference value and text is given the most space, and that labels of neighbouring ticks don't overlap)
 func getHorizontalKeyInfo(svgWidth float64, svgRequest *SVGRequest) *horizontalKeyInfo {
 	request := svgRequest.request
-	refInfo := getHorizontalRefTextInfo(request)
 	info := horizontalKeyInfo{}
 
 	// assume a default width of 90% of svg
@@ -553,61 +1398,32 @@ func getHorizontalKeyInfo(svgWidth float64, svgRequest *SVGRequest) *horizontalK
 
 	// half of the upper and lower bound text will sit outside the key
 	breaks := svgRequest.breaks
-	left := htmlutil.GetApproximateTextWidth(fmt.Sprintf("%g", breaks[0].LowerBound), request.FontSize) / 2
-	right := htmlutil.GetApproximateTextWidth(fmt.Sprintf("%g", breaks[len(breaks)-1].UpperBound), request.FontSize) / 2
-
-	// the longer bit of reference text should sit on the side of the tick with the most space
-	info.referenceTextLeft = refInfo.referenceTextLong
-	info.referenceTextLeftLen = refInfo.referenceTextLongLen
-	info.referenceTextRight = refInfo.referenceTextShort
-	info.referenceTextRightLen = refInfo.referenceTextShortLen
-	if svgRequest.referencePos < 0.5 { // the reference tick is less than halfway - switch the text
-		info.referenceTextRight = refInfo.referenceTextLong
-		info.referenceTextRightLen = refInfo.referenceTextLongLen
-		info.referenceTextLeft = refInfo.referenceTextShort
-		info.referenceTextLeftLen = refInfo.referenceTextShortLen
-	}
-	// now see if reference text is long enough to go beyond the bounds of the key
-	refPos := info.keyWidth * svgRequest.referencePos // the actual pixel position of the reference tick within the key
-	if refPos-info.referenceTextLeftLen < 0.0-left {
-		left = math.Abs(refPos - info.referenceTextLeftLen)
-	}
-	if (refPos+info.referenceTextRightLen)-info.keyWidth > right {
-		right = (refPos + info.referenceTextRightLen) - info.keyWidth
+	left := htmlutil.GetApproximateTextWidth(lowerTickText(breaks, 0), request.FontSize) / 2
+	right := htmlutil.GetApproximateTextWidth(upperTickText(breaks), request.FontSize) / 2
+	if request.Choropleth.ReverseLegend {
+		left, right = right, left
+	}
+
+	for _, tick := range svgRequest.references {
+		label := getHorizontalRefLabel(request, tick)
+		info.references = append(info.references, label)
+		// now see if reference text is long enough to go beyond the bounds of the key
+		refPos := info.keyWidth * tick.Pos // the actual pixel position of the reference tick within the key
+		if refPos-label.leftLen < 0.0-left {
+			left = math.Abs(refPos - label.leftLen)
+		}
+		if (refPos+label.rightLen)-info.keyWidth > right {
+			right = (refPos + label.rightLen) - info.keyWidth
+		}
 	}
 	// if any text goes beyond the bounds of the svg, shorten the key
 	if info.keyWidth+left+right > svgWidth {
 		info.keyWidth = svgWidth - (left + right)
 		info.keyX = left
 	}
+	info.referenceRows = placeHorizontalRefLabels(info.references, info.keyWidth)
+	info.tickRows = getHorizontalTickRows(request, breaks, info.keyWidth)
+	info.swatchExtra = swatchExtra(request.Choropleth)
 
 	return &info
 }
-
-// horizontalRefTextInfo contains the reference value and label with information about their length
-type horizontalRefTextInfo struct {
-	referenceTextShort    string
-	referenceTextShortLen float64
-	referenceTextLong     string
-	referenceTextLongLen  float64
-}
-
-// getHorizontalRefTextInfo calculates the approximate width of the reference value and text, dividing them into short and long values.
-func getHorizontalRefTextInfo(request *models.RenderRequest) *horizontalRefTextInfo {
-	info := horizontalRefTextInfo{}
-	refTextLen := htmlutil.GetApproximateTextWidth(request.Choropleth.ReferenceValueText, request.FontSize)
-	refValue := fmt.Sprintf("%g", request.Choropleth.ReferenceValue)
-	refValueLen := htmlutil.GetApproximateTextWidth(refValue, request.FontSize)
-	if refTextLen > refValueLen {
-		info.referenceTextLong = request.Choropleth.ReferenceValueText
-		info.referenceTextLongLen = refTextLen
-		info.referenceTextShort = refValue
-		info.referenceTextShortLen = refValueLen
-	} else {
-		info.referenceTextLong = refValue
-		info.referenceTextLongLen = refValueLen
-		info.referenceTextShort = request.Choropleth.ReferenceValueText
-		info.referenceTextShortLen = refTextLen
-	}
-	return &info
-}
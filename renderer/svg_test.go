@@ -2,13 +2,17 @@ package renderer_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"testing"
 
 	"encoding/xml"
 	"fmt"
+	"math"
 
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/ONSdigital/dp-map-renderer/geojson2svg"
 	"github.com/ONSdigital/dp-map-renderer/models"
@@ -19,7 +23,7 @@ import (
 )
 
 var pngConverter = geojson2svg.NewPNGConverter("sh", []string{"-c", `echo "test" >> ` + geojson2svg.ArgPNGFilename})
-var expectedFallbackImage = `<img alt="Fallback map image for older browsers" src="data:image/png;base64,dGVzdAo=" />`
+var expectedFallbackImage = `src="data:image/png;base64,dGVzdAo=" />`
 
 func TestRenderSVGWithFixedSize(t *testing.T) {
 
@@ -96,6 +100,27 @@ func TestRenderSVGIncludesFallbackPng(t *testing.T) {
 		So(result, ShouldStartWith, `<svg `)
 		So(result, ShouldContainSubstring, `<foreignObject>`)
 		So(result, ShouldContainSubstring, expectedFallbackImage)
+		So(result, ShouldContainSubstring, `<img alt="Map of Non-UK born population, Great Britain, 2015, showing 380 areas. Values range from 0% non-UK born to 54% non-UK born. 7 areas have no data." `)
+	})
+}
+
+func TestRenderSVGFallbackImageAlt(t *testing.T) {
+	Convey("The alt text of the fallback image describes the units and the areas without data", t, func() {
+
+		UsePNGConverter(pngConverter)
+
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.IncludeFallbackPng = true
+		renderRequest.Title = `Born "abroad"`
+		renderRequest.Choropleth.Units = "percent"
+		renderRequest.Data = renderRequest.Data[:len(renderRequest.Data)-2] // 7 areas have no data in the example
+
+		result := RenderSVG(PrepareSVGRequest(renderRequest))
+		So(result, ShouldContainSubstring, `<img alt="Map of Born &#34;abroad&#34;, in percent, showing 380 areas. Values range from `)
+		So(result, ShouldContainSubstring, `. 9 areas have no data." `)
 	})
 }
 
@@ -174,7 +199,7 @@ func TestSVGHasWidthAndHeight(t *testing.T) {
 
 		renderRequest := &models.RenderRequest{
 			Filename:  "testname",
-			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: "code", NameProperty: "name"},
+			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
 		}
 
 		result := RenderSVG(PrepareSVGRequest(renderRequest))
@@ -192,7 +217,7 @@ func TestSVGContainsClassName(t *testing.T) {
 
 		renderRequest := &models.RenderRequest{
 			Filename:  "testname",
-			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: "code", NameProperty: "name"},
+			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
 		}
 		renderRequest.Geography.Topojson.Objects["simplegeojson"].Geometries[0].Properties["class"] = "foo"
 
@@ -204,6 +229,316 @@ func TestSVGContainsClassName(t *testing.T) {
 		So(svg.Paths[0].Class, ShouldEqual, RegionClassName+" foo")
 		So(svg.Paths[1].Class, ShouldEqual, RegionClassName)
 	})
+
+	Convey("simpleSVG should add the value of the class property to the class of each map region that has it", t, func() {
+
+		renderRequest := &models.RenderRequest{
+			Filename:  "testname",
+			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name", ClassProperty: "country"},
+		}
+		renderRequest.Geography.Topojson.Objects["simplegeojson"].Geometries[0].Properties["country"] = "Northern Ireland"
+		renderRequest.Geography.Topojson.Objects["simplegeojson"].Geometries[0].Properties["class"] = "foo"
+
+		result := RenderSVG(PrepareSVGRequest(renderRequest))
+
+		svg, e := unmarshalSimpleSVG(result)
+		So(e, ShouldBeNil)
+		So(len(svg.Paths), ShouldEqual, 2)
+		So(svg.Paths[0].Class, ShouldEqual, RegionClassName+" Northern-Ireland foo")
+		So(svg.Paths[1].Class, ShouldEqual, RegionClassName)
+	})
+}
+
+func TestSVGFiltersFeatures(t *testing.T) {
+	Convey("Given a simple topology with a country property", t, func() {
+		renderRequest := &models.RenderRequest{
+			Filename:  "testname",
+			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
+		}
+		renderRequest.Geography.Topojson.Objects["simplegeojson"].Geometries[0].Properties["country"] = "England"
+		renderRequest.Geography.Topojson.Objects["simplegeojson"].Geometries[1].Properties["country"] = "Wales"
+
+		Convey("Only the regions in the include list should be rendered", func() {
+			renderRequest.Geography.Include = &models.FeatureFilter{IDs: []string{"f1"}}
+			svg, e := unmarshalSimpleSVG(RenderSVG(PrepareSVGRequest(renderRequest)))
+			So(e, ShouldBeNil)
+			So(len(svg.Paths), ShouldEqual, 1)
+			So(svg.Paths[0].DataID, ShouldEqual, "f1")
+		})
+
+		Convey("Regions with an excluded property value should not be rendered", func() {
+			renderRequest.Geography.Exclude = &models.FeatureFilter{Property: "country", Values: []string{"Wales"}}
+			svg, e := unmarshalSimpleSVG(RenderSVG(PrepareSVGRequest(renderRequest)))
+			So(e, ShouldBeNil)
+			So(len(svg.Paths), ShouldEqual, 1)
+			So(svg.Paths[0].DataID, ShouldEqual, "f0")
+		})
+
+		Convey("Regions matching both filters should not be rendered", func() {
+			renderRequest.Geography.Include = &models.FeatureFilter{Property: "country", Values: []string{"England", "Wales"}}
+			renderRequest.Geography.Exclude = &models.FeatureFilter{IDs: []string{"f0"}}
+			svg, e := unmarshalSimpleSVG(RenderSVG(PrepareSVGRequest(renderRequest)))
+			So(e, ShouldBeNil)
+			So(len(svg.Paths), ShouldEqual, 1)
+			So(svg.Paths[0].DataID, ShouldEqual, "f1")
+		})
+	})
+}
+
+func TestSVGRendersSelectedObject(t *testing.T) {
+	Convey("Given a topology with its regions in two objects", t, func() {
+		topology := simpleTopology()
+		regions := topology.Objects["simplegeojson"]
+		topology.Objects = map[string]*topojson.Geometry{
+			"lad":    {Type: regions.Type, Geometries: regions.Geometries[:1]},
+			"region": {Type: regions.Type, Geometries: regions.Geometries[1:]},
+		}
+		renderRequest := &models.RenderRequest{
+			Filename:  "testname",
+			Geography: &models.Geography{Topojson: topology, IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
+		}
+
+		Convey("The regions of every object should be rendered by default", func() {
+			svg, e := unmarshalSimpleSVG(RenderSVG(PrepareSVGRequest(renderRequest)))
+			So(e, ShouldBeNil)
+			So(len(svg.Paths), ShouldEqual, 2)
+		})
+
+		Convey("Only the regions of the named object should be rendered", func() {
+			renderRequest.Geography.ObjectName = "region"
+			svg, e := unmarshalSimpleSVG(RenderSVG(PrepareSVGRequest(renderRequest)))
+			So(e, ShouldBeNil)
+			So(len(svg.Paths), ShouldEqual, 1)
+			So(svg.Paths[0].DataID, ShouldEqual, "f1")
+		})
+	})
+}
+
+func TestSVGGeographyCache(t *testing.T) {
+	Convey("Given a geography cache and a request with data", t, func() {
+		UseGeographyCache(1)
+		defer UseGeographyCache(0)
+		request := func(value float64) *models.RenderRequest {
+			return &models.RenderRequest{
+				Filename:   "testname",
+				Geography:  &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
+				Choropleth: &models.Choropleth{Breaks: []*models.ChoroplethBreak{{LowerBound: 0, Colour: "red"}, {LowerBound: 11, Colour: "green"}}},
+				Data:       []*models.DataRow{{ID: "f1", Value: value}},
+			}
+		}
+		first := RenderSVG(PrepareSVGRequest(request(1)))
+
+		Convey("Rendering the same geography again should give the same svg", func() {
+			So(RenderSVG(PrepareSVGRequest(request(1))), ShouldEqual, first)
+		})
+
+		Convey("Rendering the same geography with different data should not be affected by the earlier renders", func() {
+			cached := RenderSVG(PrepareSVGRequest(request(20)))
+			So(RenderSVG(PrepareSVGRequest(request(1))), ShouldEqual, first)
+			UseGeographyCache(0)
+			So(cached, ShouldEqual, RenderSVG(PrepareSVGRequest(request(20))))
+			So(cached, ShouldNotEqual, first)
+		})
+
+		Convey("A different geography should not be taken from the cache", func() {
+			r := request(1)
+			r.Geography.Include = &models.FeatureFilter{IDs: []string{"f0"}}
+			svg, e := unmarshalSimpleSVG(RenderSVG(PrepareSVGRequest(r)))
+			So(e, ShouldBeNil)
+			So(len(svg.Paths), ShouldEqual, 1)
+		})
+	})
+}
+
+// mapCache is a SharedCache held in a map
+type mapCache map[string][]byte
+
+func (c mapCache) Get(key string) ([]byte, error) {
+	return c[key], nil
+}
+
+func (c mapCache) Set(key string, value []byte) error {
+	c[key] = value
+	return nil
+}
+
+func TestSVGSharedGeographyCache(t *testing.T) {
+	Convey("Given a geography cache shared with other instances", t, func() {
+		shared := mapCache{}
+		UseGeographyCache(1)
+		UseSharedCache(shared)
+		defer UseGeographyCache(0)
+		defer UseSharedCache(nil)
+		request := &models.RenderRequest{
+			Filename:   "testname",
+			Geography:  &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
+			Choropleth: &models.Choropleth{Breaks: []*models.ChoroplethBreak{{LowerBound: 0, Colour: "red"}, {LowerBound: 11, Colour: "green"}}},
+			Data:       []*models.DataRow{{ID: "f1", Value: 1}},
+		}
+		first := RenderSVG(PrepareSVGRequest(request))
+
+		Convey("The converted geojson is added to the shared cache", func() {
+			So(len(shared), ShouldEqual, 1)
+		})
+
+		Convey("Another instance renders the same svg from the geojson in the shared cache", func() {
+			UseGeographyCache(1) // a new, empty in-memory cache
+			for key, value := range shared {
+				shared[key] = bytes.Replace(value, []byte(`"f0"`), []byte(`"cached"`), -1)
+			}
+			svg := RenderSVG(PrepareSVGRequest(request))
+			So(svg, ShouldContainSubstring, "cached")
+			So(strings.Replace(svg, "cached", "f0", -1), ShouldEqual, first)
+		})
+	})
+}
+
+func TestSVGCache(t *testing.T) {
+	Convey("Given an svg cache and a responsive map rendered with a fallback image", t, func() {
+		UseSVGCache(2)
+		defer UseSVGCache(0)
+		UsePNGConverter(pngConverter)
+		request := func(value float64) *models.RenderRequest {
+			return &models.RenderRequest{
+				Filename:           "testname",
+				Geography:          &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
+				Choropleth:         &models.Choropleth{Breaks: []*models.ChoroplethBreak{{LowerBound: 0, Colour: "red"}, {LowerBound: 11, Colour: "green"}}},
+				Data:               []*models.DataRow{{ID: "f1", Value: value}},
+				MinWidth:           300,
+				MaxWidth:           500,
+				IncludeFallbackPng: true,
+			}
+		}
+		html, err := RenderHTMLWithSVG(context.Background(), request(1))
+		So(err, ShouldBeNil)
+		So(string(html), ShouldContainSubstring, "<foreignObject>")
+
+		Convey("The same map rendered as a standalone document (as converted to png) should be unchanged by the cache", func() {
+			cached, err := RenderSVGDocument(context.Background(), request(1))
+			So(err, ShouldBeNil)
+			UseSVGCache(0)
+			uncached, _ := RenderSVGDocument(context.Background(), request(1))
+			So(string(cached), ShouldEqual, string(uncached))
+			So(string(cached), ShouldNotContainSubstring, "<foreignObject>")
+		})
+
+		Convey("A map with different data should not be taken from the cache", func() {
+			cached, err := RenderSVGDocument(context.Background(), request(20))
+			So(err, ShouldBeNil)
+			UseSVGCache(0)
+			uncached, _ := RenderSVGDocument(context.Background(), request(20))
+			So(string(cached), ShouldEqual, string(uncached))
+			So(string(cached), ShouldContainSubstring, "fill: green;")
+		})
+
+		Convey("A map that differs only in its suppressed rows should not be taken from the cache", func() {
+			// with a geography cache, rendering doesn't modify the request's topology
+			UseGeographyCache(2)
+			defer UseGeographyCache(0)
+			suppressed := func(rows ...*models.DataRow) *models.RenderRequest {
+				r := request(1)
+				r.Choropleth.SuppressedValues = []string{"c"}
+				r.Suppressed = rows
+				return r
+			}
+			withoutSuppression, err := RenderSVGDocument(context.Background(), suppressed())
+			So(err, ShouldBeNil)
+			withSuppression, err := RenderSVGDocument(context.Background(), suppressed(&models.DataRow{ID: "f0", Value: math.NaN(), Marker: "c"}))
+			So(err, ShouldBeNil)
+			So(string(withSuppression), ShouldNotEqual, string(withoutSuppression))
+			So(string(withSuppression), ShouldContainSubstring, "-suppressed);")
+			So(string(withoutSuppression), ShouldNotContainSubstring, "-suppressed);")
+		})
+	})
+}
+
+func TestSVGHighlightsRegions(t *testing.T) {
+	Convey("Given a request highlighting the first region", t, func() {
+		renderRequest := &models.RenderRequest{
+			Filename:   "testname",
+			Geography:  &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
+			Choropleth: &models.Choropleth{Breaks: []*models.ChoroplethBreak{{LowerBound: 0, Colour: "red"}, {LowerBound: 11, Colour: "green"}}},
+			Data:       []*models.DataRow{{ID: "f0", Value: 1}, {ID: "f1", Value: 2}},
+			Highlight:  &models.Highlight{IDs: []string{"f0"}, Colour: "#ff0000"},
+		}
+
+		Convey("The highlighted region should be drawn last, with an outline and the highlight class", func() {
+			svg, e := unmarshalSimpleSVG(RenderSVG(PrepareSVGRequest(renderRequest)))
+			So(e, ShouldBeNil)
+			So(len(svg.Paths), ShouldEqual, 2)
+			So(svg.Paths[0].DataID, ShouldEqual, "f1")
+			So(svg.Paths[0].Class, ShouldEqual, "mapRegion")
+			So(svg.Paths[1].DataID, ShouldEqual, "f0")
+			So(svg.Paths[1].Class, ShouldEqual, "mapRegion mapRegion--highlighted")
+			So(svg.Paths[1].Style, ShouldContainSubstring, "stroke: #ff0000; stroke-width: 2;")
+			So(svg.Paths[1].Style, ShouldContainSubstring, "fill: ")
+		})
+
+		Convey("Highlighted regions should not be labelled by default", func() {
+			So(RenderSVG(PrepareSVGRequest(renderRequest)), ShouldNotContainSubstring, HighlightLabelClass)
+		})
+
+		Convey("Labelled regions should have their name drawn within the map", func() {
+			renderRequest.Highlight = &models.Highlight{IDs: []string{"f1"}, Label: true}
+			svgRequest := PrepareSVGRequest(renderRequest)
+			result := RenderSVG(svgRequest)
+			So(result, ShouldContainSubstring, `class="mapRegion mapRegion--highlighted"`)
+			So(result, ShouldContainSubstring, "stroke: black;")
+
+			label := &struct {
+				Texts []struct {
+					Class string  `xml:"class,attr"`
+					X     float64 `xml:"x,attr"`
+					Y     float64 `xml:"y,attr"`
+					Value string  `xml:",chardata"`
+				} `xml:"text"`
+			}{}
+			So(xml.Unmarshal([]byte(result), label), ShouldBeNil)
+			So(len(label.Texts), ShouldEqual, 1)
+			So(label.Texts[0].Class, ShouldEqual, HighlightLabelClass)
+			So(label.Texts[0].Value, ShouldEqual, "feature 1")
+			So(label.Texts[0].X, ShouldAlmostEqual, svgRequest.ViewBoxWidth/2, 1)
+			So(label.Texts[0].Y, ShouldAlmostEqual, svgRequest.ViewBoxHeight/2, 1)
+		})
+	})
+}
+
+func TestSVGFitsRegions(t *testing.T) {
+	Convey("Given a topology of a square region beside a region twice as wide", t, func() {
+		topology, _ := topojson.UnmarshalTopology([]byte(`{"type":"Topology","objects":{"regions":{"type":"GeometryCollection","geometries":[` +
+			`{"type":"Polygon","arcs":[[0]],"properties":{"code":"f0","name":"feature 0"}},{"type":"Polygon","arcs":[[1]],"properties":{"code":"f1","name":"feature 1"}}]}},` +
+			`"arcs":[[[0,0],[1,0],[1,1],[0,1],[0,0]],[[1,0],[3,0],[3,1],[1,1],[1,0]]]}`))
+		renderRequest := &models.RenderRequest{
+			Filename:  "testname",
+			Geography: &models.Geography{Topojson: topology, IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
+			Data:      []*models.DataRow{{ID: "f0", Value: 1}},
+		}
+
+		Convey("The whole topology should be framed by default", func() {
+			svgRequest := PrepareSVGRequest(renderRequest)
+			So(svgRequest.ViewBoxWidth, ShouldEqual, 400)
+			So(svgRequest.ViewBoxHeight, ShouldEqual, 133)
+		})
+
+		Convey("The regions with data should be framed with the data fit, with the other regions still drawn", func() {
+			renderRequest.Fit = models.FitData
+			svgRequest := PrepareSVGRequest(renderRequest)
+			So(svgRequest.ViewBoxWidth, ShouldEqual, 400)
+			So(svgRequest.ViewBoxHeight, ShouldEqual, 400)
+
+			svg, e := unmarshalSimpleSVG(RenderSVG(svgRequest))
+			So(e, ShouldBeNil)
+			So(len(svg.Paths), ShouldEqual, 2)
+		})
+
+		Convey("The highlighted regions should be framed with the highlight fit", func() {
+			renderRequest.Fit = models.FitHighlight
+			renderRequest.Highlight = &models.Highlight{IDs: []string{"f1"}}
+			svgRequest := PrepareSVGRequest(renderRequest)
+			So(svgRequest.ViewBoxWidth, ShouldEqual, 400)
+			So(svgRequest.ViewBoxHeight, ShouldEqual, 200)
+		})
+	})
 }
 
 func TestSVGContainsIDs(t *testing.T) {
@@ -212,7 +547,7 @@ func TestSVGContainsIDs(t *testing.T) {
 
 		renderRequest := &models.RenderRequest{
 			Filename:  "testname",
-			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: "code", NameProperty: "name"},
+			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
 		}
 
 		result := RenderSVG(PrepareSVGRequest(renderRequest))
@@ -231,7 +566,7 @@ func TestSVGContainsTitles(t *testing.T) {
 
 		renderRequest := &models.RenderRequest{
 			Filename:  "testname",
-			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: "code", NameProperty: "name"},
+			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
 		}
 
 		result := RenderSVG(PrepareSVGRequest(renderRequest))
@@ -251,7 +586,7 @@ func TestSVGContainsChoroplethColours(t *testing.T) {
 
 		renderRequest := &models.RenderRequest{
 			Filename:   "testname",
-			Geography:  &models.Geography{Topojson: simpleTopology(), IDProperty: "code", NameProperty: "name"},
+			Geography:  &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
 			Choropleth: &models.Choropleth{Breaks: []*models.ChoroplethBreak{{LowerBound: 0, Colour: "red"}, {LowerBound: 11, Colour: "green"}}},
 			Data:       []*models.DataRow{{ID: "f0", Value: 10}, {ID: "f1", Value: 20}},
 		}
@@ -273,7 +608,7 @@ func TestSVGHasMissingValuePatternAndCorrectTitle(t *testing.T) {
 
 		renderRequest := &models.RenderRequest{
 			Filename:  "testname",
-			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: "code", NameProperty: "name"},
+			Geography: &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
 			Choropleth: &models.Choropleth{
 				Breaks:      []*models.ChoroplethBreak{{LowerBound: 0, Colour: "red"}, {LowerBound: 11, Colour: "green"}},
 				ValuePrefix: "prefix-",
@@ -296,6 +631,31 @@ func TestSVGHasMissingValuePatternAndCorrectTitle(t *testing.T) {
 	})
 }
 
+func TestSVGHasDataAttributes(t *testing.T) {
+
+	Convey("simpleSVG should add the id, name and value of each region as data attributes", t, func() {
+
+		renderRequest := &models.RenderRequest{
+			Filename:   "testname",
+			Geography:  &models.Geography{Topojson: simpleTopology(), IDProperty: models.PropertyNames{"code"}, NameProperty: "name"},
+			Choropleth: &models.Choropleth{Breaks: []*models.ChoroplethBreak{{LowerBound: 0, Colour: "red"}, {LowerBound: 11, Colour: "green"}}},
+			Data:       []*models.DataRow{{ID: "f1", Value: 20.5}},
+		}
+
+		result := RenderSVG(PrepareSVGRequest(renderRequest))
+
+		svg, e := unmarshalSimpleSVG(result)
+		So(e, ShouldBeNil)
+		So(len(svg.Paths), ShouldEqual, 2)
+		So(svg.Paths[0].DataID, ShouldEqual, "f0")
+		So(svg.Paths[0].DataLabel, ShouldEqual, "feature 0")
+		So(svg.Paths[0].DataValue, ShouldBeNil)
+		So(svg.Paths[1].DataID, ShouldEqual, "f1")
+		So(svg.Paths[1].DataLabel, ShouldEqual, "feature 1")
+		So(*svg.Paths[1].DataValue, ShouldEqual, "20.5")
+	})
+}
+
 func TestRenderVerticalKey(t *testing.T) {
 	Convey("RenderVerticalKey should render an svg", t, func() {
 
@@ -530,6 +890,60 @@ func TestRenderVerticalKeyClassChangesWhenHorizontalKeyAlsoPresent(t *testing.T)
 
 }
 
+func TestRenderSVGWithKeyInsideMap(t *testing.T) {
+	Convey("RenderSVG should draw the vertical key inside the map when positioned inside-top-right", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+		renderRequest.Choropleth.HorizontalLegendPosition = "none"
+
+		svgRequest := PrepareSVGRequest(renderRequest)
+		result := RenderSVG(svgRequest)
+
+		x := svgRequest.ViewBoxWidth - svgRequest.VerticalLegendWidth*0.5
+		So(result, ShouldContainSubstring, fmt.Sprintf(`<g id="map-abcd1234-legend-vertical-overlay" class="map_key_vertical map_key_overlay" transform="translate(%f, 0.000000) scale(0.5)">`, x))
+		So(result, ShouldContainSubstring, `<g id="map-abcd1234-legend-vertical-container">`)
+		So(result, ShouldNotContainSubstring, `legend-horizontal`)
+		So(result, ShouldEndWith, `</g></svg>`)
+	})
+
+	Convey("RenderSVG should draw the horizontal key inside the map when positioned inside-bottom-left", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.VerticalLegendPosition = "none"
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+
+		svgRequest := PrepareSVGRequest(renderRequest)
+		result := RenderSVG(svgRequest)
+
+		y := svgRequest.ViewBoxHeight - 90*0.5
+		So(result, ShouldContainSubstring, fmt.Sprintf(`<g id="map-abcd1234-legend-horizontal-overlay" class="map_key_horizontal map_key_overlay" transform="translate(0.000000, %f) scale(0.5)">`, y))
+		So(result, ShouldContainSubstring, `<g id="map-abcd1234-legend-horizontal-container">`)
+		So(result, ShouldNotContainSubstring, `legend-vertical`)
+	})
+
+	Convey("RenderSVG should not draw a key inside the map when positioned before or after", t, func() {
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := RenderSVG(PrepareSVGRequest(renderRequest))
+
+		So(result, ShouldNotContainSubstring, `map_key_overlay`)
+	})
+}
+
 func TestRenderHorizontalKeyHasFallbackPng(t *testing.T) {
 	Convey("RenderHorizontalKey should render a fallback png", t, func() {
 
@@ -552,6 +966,228 @@ func TestRenderHorizontalKeyHasFallbackPng(t *testing.T) {
 
 }
 
+func TestRenderSVGIncludesFallbackImageInRequestedFormat(t *testing.T) {
+	Convey("Given a webp converter", t, func() {
+
+		UsePNGConverter(pngConverter)
+		UseRasterConverter(geojson2svg.ImageFormatWebP, geojson2svg.NewRasterConverter(geojson2svg.ImageFormatWebP, "sh", []string{"-c", `echo "test" >> ` + geojson2svg.ArgImageFilename}))
+		defer UseRasterConverter(geojson2svg.ImageFormatWebP, nil)
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.IncludeFallbackPng = true
+
+		Convey("A request for a webp fallback image includes a webp image", func() {
+			renderRequest.FallbackImageFormat = geojson2svg.ImageFormatWebP
+			result := RenderSVG(PrepareSVGRequest(renderRequest))
+			So(result, ShouldContainSubstring, `src="data:image/webp;base64,dGVzdAo="`)
+		})
+
+		Convey("A request for high-DPI fallback images includes an image of twice the size in a srcset", func() {
+			renderRequest.FallbackImageFormat = geojson2svg.ImageFormatWebP
+			renderRequest.HighDPIFallback = true
+			result := RenderSVG(PrepareSVGRequest(renderRequest))
+			So(result, ShouldContainSubstring, `src="data:image/webp;base64,dGVzdAo=" srcset="data:image/webp;base64,dGVzdAo= 2x"`)
+		})
+
+		Convey("A request for a picture wraps the svg in a picture element with webp and png fallbacks", func() {
+			renderRequest.FallbackImageFormat = geojson2svg.ImageFormatWebP
+			renderRequest.FallbackStructure = models.FallbackStructurePicture
+			result := RenderSVG(PrepareSVGRequest(renderRequest))
+			So(result, ShouldStartWith, `<picture><source type="image/svg+xml" srcset="data:image/svg+xml;base64,`)
+			So(result, ShouldContainSubstring, `<source type="image/webp" srcset="data:image/webp;base64,dGVzdAo=" />`)
+			So(result, ShouldContainSubstring, `src="data:image/png;base64,dGVzdAo=" /></picture>`)
+			So(result, ShouldNotContainSubstring, `<switch>`)
+		})
+
+		Convey("A request for a format without a converter includes a png image", func() {
+			renderRequest.FallbackImageFormat = geojson2svg.ImageFormatAVIF
+			result := RenderSVG(PrepareSVGRequest(renderRequest))
+			So(result, ShouldContainSubstring, expectedFallbackImage)
+		})
+	})
+}
+
+func TestRenderSVGFallbackImageBackground(t *testing.T) {
+	Convey("Given a png converter that returns the svg it is given", t, func() {
+
+		UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename}))
+		defer UsePNGConverter(pngConverter)
+
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.IncludeFallbackPng = true
+
+		Convey("The fallback image has the request's background, but the svg does not", func() {
+			renderRequest.Background = "#123456"
+			result := RenderSVG(PrepareSVGRequest(renderRequest))
+			image := fallbackImageData(result, geojson2svg.ImageFormatPNG)
+			So(image, ShouldContainSubstring, `fill="#123456"/>`)
+			So(strings.Replace(result, image, "", 1), ShouldNotContainSubstring, `#123456`)
+		})
+
+		Convey("The fallback image is transparent by default", func() {
+			result := RenderSVG(PrepareSVGRequest(renderRequest))
+			So(fallbackImageData(result, geojson2svg.ImageFormatPNG), ShouldNotContainSubstring, `<rect x="0" y="0"`)
+		})
+
+		Convey("A jpeg fallback image has a white background by default", func() {
+			UseRasterConverter(geojson2svg.ImageFormatJPEG, geojson2svg.NewRasterConverter(geojson2svg.ImageFormatJPEG, "sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgImageFilename}))
+			defer UseRasterConverter(geojson2svg.ImageFormatJPEG, nil)
+			renderRequest.FallbackImageFormat = geojson2svg.ImageFormatJPEG
+
+			result := RenderSVG(PrepareSVGRequest(renderRequest))
+			So(fallbackImageData(result, geojson2svg.ImageFormatJPEG), ShouldContainSubstring, `fill="#ffffff"/>`)
+
+			renderRequest.Background = "#123456"
+			result = RenderSVG(PrepareSVGRequest(renderRequest))
+			So(fallbackImageData(result, geojson2svg.ImageFormatJPEG), ShouldContainSubstring, `fill="#123456"/>`)
+		})
+	})
+}
+
+// fallbackImageData returns the decoded data of the first fallback image in the svg, which must be in the given format
+func fallbackImageData(svg string, format string) string {
+	data := regexp.MustCompile(`src="data:image/` + format + `;base64,([^"]*)"`).FindStringSubmatch(svg)
+	So(data, ShouldHaveLength, 2)
+	image, err := base64.StdEncoding.DecodeString(data[1])
+	So(err, ShouldBeNil)
+	return string(image)
+}
+
+func TestRenderEPS(t *testing.T) {
+	Convey("Given an eps converter that returns the svg it is given", t, func() {
+
+		UseEPSConverter(geojson2svg.NewRasterConverter("eps", "sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgImageFilename}))
+		defer UseEPSConverter(nil)
+
+		reader := bytes.NewReader(testdata.LoadExampleRequest(t))
+		renderRequest, err := models.CreateRenderRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.DefaultWidth = 400
+
+		Convey("A horizontal legend after the map is drawn below it", func() {
+			renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionAfter
+			renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionBefore
+
+			result, err := RenderEPS(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldStartWith, `<svg xmlns="http://www.w3.org/2000/svg" width="400" height="838" viewBox="0 0 400 838">`)
+			So(svg, ShouldContainSubstring, `<svg x="0" y="0" width="400" height="748" id="map-abcd1234-map-svg"`)
+			So(svg, ShouldContainSubstring, `<svg x="0" y="748" id="map-abcd1234-legend-horizontal-svg"`)
+			So(svg, ShouldNotContainSubstring, "legend-vertical")
+		})
+
+		Convey("A vertical legend before the map is drawn to its left", func() {
+			renderRequest.Choropleth.HorizontalLegendPosition = ""
+			renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionBefore
+
+			result, err := RenderEPS(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldContainSubstring, `<svg x="0" y="0" id="map-abcd1234-legend-vertical-svg"`)
+			So(svg, ShouldNotContainSubstring, `<svg x="0" y="0" width="400" height="748" id="map-abcd1234-map-svg"`)
+			So(svg, ShouldContainSubstring, `height="748" id="map-abcd1234-map-svg"`)
+		})
+	})
+
+	Convey("RenderEPS returns an error when there is no eps converter", t, func() {
+		UseEPSConverter(nil)
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = RenderEPS(context.Background(), renderRequest)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRenderSVGMinified(t *testing.T) {
+	Convey("Given the example request with minify set", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		original, err := RenderSVGDocument(context.Background(), renderRequest)
+		So(err, ShouldBeNil)
+
+		renderRequest, _ = models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		renderRequest.Minify = true
+
+		Convey("The svg should be smaller, with short ids and coordinates", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(len(svg), ShouldBeLessThan, len(original)*2/3)
+			So(svg, ShouldNotContainSubstring, "map-abcd1234")
+			So(svg, ShouldNotContainSubstring, "\n")
+			So(svg, ShouldNotContainSubstring, "> <")
+			So(svg, ShouldNotContainSubstring, ".000000")
+			So(svg, ShouldNotContainSubstring, ` x="0"`)
+			So(svg, ShouldContainSubstring, `<pattern id="m`)
+		})
+
+		Convey("The ids of the html and css should match the shortened svg ids", func() {
+			result, err := RenderHTMLWithSVG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			html := string(result)
+			So(html, ShouldNotContainSubstring, "map-abcd1234")
+			So(strings.Count(html, `id="m`), ShouldBeGreaterThan, 2)
+		})
+	})
+}
+
+func TestRenderOfficePNG(t *testing.T) {
+	Convey("Given a png converter that returns the svg it is given", t, func() {
+
+		UsePNGConverter(geojson2svg.NewPNGConverter("sh", []string{"-c", "cat " + geojson2svg.ArgSVGFilename + " >> " + geojson2svg.ArgPNGFilename}))
+		defer UsePNGConverter(nil)
+
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.DefaultWidth = 400
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionAfter
+
+		Convey("The map and legend are scaled to fit a 300dpi widescreen slide by default", func() {
+			result, err := RenderOfficePNG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldStartWith, `<svg xmlns="http://www.w3.org/2000/svg" width="4000" height="2250" viewBox="0 0 4000 2250"><rect x="0" y="0" width="4000" height="2250" fill="#ffffff"/>`)
+			So(svg, ShouldContainSubstring, `<svg x="112" y="112" width="3775" height="2025" viewBox="0 0 400 838">`)
+			So(svg, ShouldContainSubstring, `id="map-abcd1234-legend-horizontal-svg"`)
+		})
+
+		Convey("The page has the request's background, or is transparent", func() {
+			renderRequest.Background = "#eeeeee"
+			result, err := RenderOfficePNG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `viewBox="0 0 4000 2250"><rect x="0" y="0" width="4000" height="2250" fill="#eeeeee"/>`)
+
+			renderRequest.Background = models.BackgroundTransparent
+			result, err = RenderOfficePNG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `viewBox="0 0 4000 2250"><svg x=`)
+		})
+
+		Convey("An A4 portrait preset produces a portrait page", func() {
+			renderRequest.OfficePreset = models.OfficePresetA4Portrait
+			result, err := RenderOfficePNG(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldStartWith, `<svg xmlns="http://www.w3.org/2000/svg" width="2480" height="3508"`)
+		})
+	})
+}
+
 func TestRenderHorizontalKeyDoesNotHaveFallbackPng(t *testing.T) {
 	Convey("RenderHorizontalKey should not render a fallback png", t, func() {
 
@@ -589,6 +1225,7 @@ func TestRenderVerticalKeyHasFallbackPng(t *testing.T) {
 		So(result, ShouldNotBeNil)
 		So(result, ShouldContainSubstring, `<foreignObject>`)
 		So(result, ShouldContainSubstring, expectedFallbackImage)
+		So(result, ShouldContainSubstring, `<img alt="Legend: 0% non-UK born to 6% non-UK born, 6% non-UK born to 11% non-UK born, 11% non-UK born to 20% non-UK born, 20% non-UK born to 33% non-UK born, 33% non-UK born to 54% non-UK born" `)
 
 	})
 
@@ -727,11 +1364,14 @@ type simpleSVG struct {
 }
 
 type path struct {
-	D     string `xml:"d,attr"`
-	ID    string `xml:"id,attr"`
-	Style string `xml:"style,attr"`
-	Class string `xml:"class,attr"`
-	Title title  `xml:"title"`
+	D         string  `xml:"d,attr"`
+	ID        string  `xml:"id,attr"`
+	Style     string  `xml:"style,attr"`
+	Class     string  `xml:"class,attr"`
+	DataID    string  `xml:"data-id,attr"`
+	DataLabel string  `xml:"data-label,attr"`
+	DataValue *string `xml:"data-value,attr"`
+	Title     title   `xml:"title"`
 }
 
 type title struct {
@@ -743,3 +1383,507 @@ func unmarshalSimpleSVG(source string) (*simpleSVG, error) {
 	err := xml.Unmarshal([]byte(source), svg)
 	return svg, err
 }
+
+func TestRenderSVGDeterministic(t *testing.T) {
+	Convey("Given the example request with its regions split across several topology objects", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		topology := renderRequest.Geography.Topojson
+		objects := make(map[string]*topojson.Geometry)
+		var ids []string
+		for _, o := range topology.Objects {
+			for i, g := range o.Geometries {
+				objects[fmt.Sprintf("object-%03d", i)] = g
+				ids = append(ids, g.Properties[renderRequest.Geography.IDProperty[0]].(string))
+			}
+		}
+		topology.Objects = objects
+		renderRequest.Deterministic = true
+
+		Convey("Every render should be identical, with regions in order of object name", func() {
+			first, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			for i := 0; i < 10; i++ {
+				result, err := RenderSVGDocument(context.Background(), renderRequest)
+				So(err, ShouldBeNil)
+				So(string(result), ShouldEqual, string(first))
+			}
+			svg := string(first)
+			So(strings.Index(svg, `data-id="`+ids[0]+`"`), ShouldBeLessThan, strings.Index(svg, `data-id="`+ids[1]+`"`))
+			So(strings.Index(svg, `data-id="`+ids[len(ids)-2]+`"`), ShouldBeLessThan, strings.Index(svg, `data-id="`+ids[len(ids)-1]+`"`))
+		})
+	})
+}
+
+func TestRenderSVGWithPatterns(t *testing.T) {
+	Convey("Given the example request with patterns on two breaks", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.Breaks[0].Pattern = models.PatternHatching
+		renderRequest.Choropleth.Breaks[1].Pattern = `<circle cx="4" cy="4" r="2"></circle><script>alert(1)</script>`
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+
+		Convey("The map and legend should define the patterns and fill regions and swatches with them", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldContainSubstring, `<pattern id="map-abcd1234-pattern-0" width="8" height="8" patternUnits="userSpaceOnUse"><rect width="8" height="8" style="fill: rgb(241, 238, 246);"></rect><path`)
+			So(svg, ShouldContainSubstring, `<pattern id="map-abcd1234-pattern-1" width="8" height="8" patternUnits="userSpaceOnUse"><rect width="8" height="8" style="fill: rgb(189, 201, 225);"></rect><circle cx="4" cy="4" r="2"></circle></pattern>`)
+			So(svg, ShouldContainSubstring, `data-id="E06000001" data-label="Hartlepool" data-value="3" id="map-abcd1234-E06000001" style="fill: url(#map-abcd1234-pattern-0);"`)
+			So(svg, ShouldContainSubstring, `<pattern id="map-abcd1234-horizontal-pattern-0"`)
+			So(svg, ShouldContainSubstring, `fill: url(#map-abcd1234-horizontal-pattern-1);`)
+			So(svg, ShouldNotContainSubstring, "<script>")
+			So(svg, ShouldNotContainSubstring, "pattern-2")
+		})
+	})
+}
+
+func TestRenderSVGGreyscale(t *testing.T) {
+	Convey("Given the example request with greyscale set", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Greyscale = true
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+
+		Convey("The colours of the map and legend should be replaced by greys, from lightest to darkest", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldNotContainSubstring, "rgb(")
+			So(svg, ShouldContainSubstring, `id="map-abcd1234-E06000001" style="fill: #f1f1f1;"`)
+			So(svg, ShouldContainSubstring, `stroke: black; fill: #f1f1f1;`)
+			So(svg, ShouldContainSubstring, `stroke: black; fill: #3b3b3b;`)
+		})
+
+		Convey("Greys should follow the lightness of the colours rather than the order of the breaks", func() {
+			breaks := renderRequest.Choropleth.Breaks
+			breaks[0].Colour, breaks[4].Colour = breaks[4].Colour, breaks[0].Colour
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `id="map-abcd1234-E06000001" style="fill: #3b3b3b;"`)
+		})
+	})
+}
+
+func TestRenderSVGMissingDataKey(t *testing.T) {
+	Convey("Given the example request with both legends inside the map", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+
+		Convey("The missing data entries should use the choropleth's missing data text", func() {
+			renderRequest.Choropleth.MissingDataText = "not applicable"
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldNotContainSubstring, MissingDataText)
+			So(strings.Count(svg, `<g class="missingPattern"`), ShouldEqual, 2)
+			So(svg, ShouldContainSubstring, `class="keyText" textLength="86" lengthAdjust="spacingAndGlyphs">not applicable</text>`)
+		})
+
+		Convey("The missing data entries should be omitted when hidden", func() {
+			renderRequest.Choropleth.HideMissingDataKey = true
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldNotContainSubstring, `<g class="missingPattern"`)
+		})
+	})
+}
+
+func TestRenderSVGSuppressedData(t *testing.T) {
+	Convey("Given the example request with Hartlepool's value suppressed and both legends inside the map", t, func() {
+		example := strings.Replace(string(testdata.LoadExampleRequest(t)), `"value": 3 }`, `"value": "c" }`, 1)
+		example = strings.Replace(example, `"choropleth": {`, `"choropleth": {"suppressed_values": ["-999", "c"], "suppressed_text": "confidential",`, 1)
+		renderRequest, err := models.CreateRenderRequest(strings.NewReader(example))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+		So(renderRequest.Suppressed, ShouldHaveLength, 1)
+
+		result, err := RenderSVGDocument(context.Background(), renderRequest)
+		So(err, ShouldBeNil)
+		svg := string(result)
+
+		Convey("The region should be filled with the suppressed data pattern, without a value", func() {
+			So(svg, ShouldContainSubstring, `<pattern id="map-abcd1234-suppressed"`)
+			So(svg, ShouldContainSubstring, `data-id="E06000001" data-label="Hartlepool" id="map-abcd1234-E06000001" style="fill: url(#map-abcd1234-suppressed);"`)
+			So(svg, ShouldContainSubstring, `<title>Hartlepool confidential</title>`)
+		})
+
+		Convey("Both legends should have a suppressed data entry alongside the missing data entry", func() {
+			So(strings.Count(svg, `<g class="missingPattern"`), ShouldEqual, 2)
+			So(strings.Count(svg, `<g class="suppressedPattern"`), ShouldEqual, 2)
+			So(svg, ShouldContainSubstring, `fill: url(#map-abcd1234-horizontal-suppressed);`)
+			So(svg, ShouldContainSubstring, `fill: url(#map-abcd1234-vertical-suppressed);`)
+		})
+	})
+}
+
+func TestRenderSVGReferenceStyle(t *testing.T) {
+	Convey("Given the example request with both legends inside the map and a styled reference tick", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+		renderRequest.Choropleth.ReferenceColour = "#c00"
+		renderRequest.Choropleth.ReferenceDash = "4 2"
+
+		Convey("Both reference ticks should use the colour and dash pattern", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(strings.Count(svg, `style="stroke-width: 1; stroke: #c00; stroke-dasharray: 4 2;"></line>`), ShouldEqual, 2)
+			So(strings.Count(svg, `fill: #c00;" class="keyText"`), ShouldEqual, 4)
+		})
+
+		Convey("The reference text should be placed before the tick", func() {
+			renderRequest.Choropleth.ReferenceLabelPlacement = models.ReferenceLabelBefore
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldContainSubstring, `style="text-anchor: end; fill: #c00;" class="keyText">UK avg.</text>`)
+			So(svg, ShouldContainSubstring, `style="text-anchor: start; fill: #c00;" class="keyText">13</text>`)
+			So(svg, ShouldContainSubstring, `<text x="18" dy="-.32em" style="text-anchor: start; fill: #c00;" class="keyText" textLength=`)
+		})
+
+		Convey("The reference text should be placed after the tick", func() {
+			renderRequest.Choropleth.ReferenceLabelPlacement = models.ReferenceLabelAfter
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldContainSubstring, `style="text-anchor: end; fill: #c00;" class="keyText">13</text>`)
+			So(svg, ShouldContainSubstring, `style="text-anchor: start; fill: #c00;" class="keyText">UK avg.</text>`)
+			So(svg, ShouldContainSubstring, `<text x="18" dy="1em" style="text-anchor: start; fill: #c00;" class="keyText" textLength=`)
+		})
+	})
+}
+
+func TestRenderSVGMultipleReferences(t *testing.T) {
+	Convey("Given the example request with a second reference value close to the first, and both legends inside the map", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+		renderRequest.Choropleth.References = []*models.Reference{{Value: 14, Text: "England average"}}
+
+		result, err := RenderSVGDocument(context.Background(), renderRequest)
+		So(err, ShouldBeNil)
+		svg := string(result)
+
+		Convey("Both references should be drawn on both legends", func() {
+			So(strings.Count(svg, `class="keyText">UK avg.</text>`), ShouldEqual, 1)
+			So(strings.Count(svg, `>UK avg.</text>`), ShouldEqual, 2)
+			So(strings.Count(svg, `>England average</text>`), ShouldEqual, 2)
+			So(strings.Count(svg, `class="keyText">14</text>`), ShouldEqual, 2)
+		})
+
+		Convey("The labels of the second reference should be moved to a second row of the horizontal legend, above the missing data entry", func() {
+			So(svg, ShouldContainSubstring, `<line x2="0" y1="8" y2="56" style="stroke-width: 1; stroke: DimGrey;"></line>`)
+			So(svg, ShouldContainSubstring, `<text x="0" y="44" dx="0.1em"`)
+			So(svg, ShouldContainSubstring, `<g class="missingPattern" transform="translate(0.000000, 66.000000)">`)
+		})
+
+		Convey("The labels of the lower reference should be moved down in the vertical legend", func() {
+			So(svg, ShouldContainSubstring, `<text x="18" y="`)
+			So(strings.Count(svg, `<text x="18" dy="-.32em"`), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRenderSVGReverseLegend(t *testing.T) {
+	Convey("Given the example request with a reversed legend and both legends inside the map", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+		renderRequest.Choropleth.ReverseLegend = true
+
+		result, err := RenderSVGDocument(context.Background(), renderRequest)
+		So(err, ShouldBeNil)
+		svg := string(result)
+
+		Convey("The horizontal key should run from the highest value on the left", func() {
+			So(svg, ShouldContainSubstring, `<g class="map__tick" transform="translate(0.000000, 0)"><line x2="0" y2="15" style="stroke-width: 1; stroke: Black;"></line><text x="0" y="18" dy=".74em" style="text-anchor: middle;" class="keyText">54</text>`)
+		})
+
+		Convey("The vertical key should run from the lowest value at the top", func() {
+			So(svg, ShouldContainSubstring, `<g class="map__tick" transform="translate(0, 0.000000)"><line x1="8" x2="-15" style="stroke-width: 1; stroke: Black;"></line><text x="-18" y="0" dy="0.32em" style="text-anchor: end;" class="keyText">0</text>`)
+			So(svg, ShouldContainSubstring, `<rect class="keyColour" height="66.488889" width="8" y="0.000000" style="stroke-width: 0.5; stroke: black; fill: rgb(241, 238, 246);">`)
+		})
+	})
+}
+
+func TestRenderSVGHorizontalKeyWrapping(t *testing.T) {
+	Convey("Given the example request with a horizontal legend inside the map", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+
+		Convey("Tick labels that fit should be drawn in a single row", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldNotContainSubstring, `<line x2="0" y2="26"`)
+			So(svg, ShouldContainSubstring, `<g class="missingPattern" transform="translate(0.000000, 55.000000)">`)
+		})
+
+		Convey("Tick labels that would overlap should be wrapped onto two rows, moving the rest of the legend down", func() {
+			renderRequest.Choropleth.Breaks[0].Label = "fewer than 6 percent"
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldContainSubstring, `<line x2="0" y2="26" style="stroke-width: 1; stroke: Black;"></line><text x="0" y="29" dy=".74em" style="text-anchor: middle;" class="keyText">6</text>`)
+			So(svg, ShouldContainSubstring, `<line x2="0" y2="15" style="stroke-width: 1; stroke: Black;"></line><text x="0" y="18" dy=".74em" style="text-anchor: middle;" class="keyText">11</text>`)
+			So(svg, ShouldContainSubstring, `<text x="0" y="44" dx="-0.1em"`)
+			So(svg, ShouldContainSubstring, `<g class="missingPattern" transform="translate(0.000000, 66.000000)">`)
+		})
+	})
+}
+
+func TestRenderSVGSwatches(t *testing.T) {
+	Convey("Given the example request with both legends inside the map", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+
+		Convey("A thicker bar should move the ticks and the rest of the legend to make room", func() {
+			renderRequest.Choropleth.SwatchSize = 14
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(strings.Count(svg, `<rect class="keyColour" height="14" width="`), ShouldEqual, 5)
+			So(strings.Count(svg, `<rect class="keyColour" height="`), ShouldEqual, 12) // 5 in each key, plus 2 missing data entries
+			So(svg, ShouldContainSubstring, `<g transform="translate(0, 6)"><g class="map__tick"`)
+			So(svg, ShouldContainSubstring, `<g class="missingPattern" transform="translate(0.000000, 61.000000)">`)
+			So(svg, ShouldContainSubstring, `<line x1="14" x2="-15" style="stroke-width: 1; stroke: Black;"></line>`)
+			So(svg, ShouldContainSubstring, `<line x2="51" x1="14" style="stroke-width: 1; stroke: DimGrey;"></line>`)
+		})
+
+		Convey("Circles should be drawn in the middle of each break", func() {
+			renderRequest.Choropleth.SwatchShape = models.SwatchShapeCircle
+			renderRequest.Choropleth.SwatchSize = 10
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(strings.Count(svg, `<circle class="keyColour" r="5" cx="`), ShouldEqual, 10)
+			So(svg, ShouldContainSubstring, `<circle class="keyColour" r="5" cx="5" cy="`)
+		})
+
+		Convey("Squares should be drawn in the middle of each break", func() {
+			renderRequest.Choropleth.SwatchShape = models.SwatchShapeSquare
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(strings.Count(string(result), `<rect class="keyColour" height="8" width="8" `), ShouldEqual, 12)
+		})
+	})
+}
+
+func TestRenderSVGLogKeyScale(t *testing.T) {
+	Convey("Given the example request with positive values and both legends inside the map", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+		for _, row := range renderRequest.Data {
+			row.Value++
+		}
+		renderRequest.Choropleth.Breaks[0].LowerBound = 1
+
+		Convey("A log key scale should size each break by the logarithm of its bounds", func() {
+			renderRequest.Choropleth.KeyScale = models.KeyScaleLog
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldContainSubstring, `<rect class="keyColour" height="8" width="161.703682" x="0.000000"`)
+			So(svg, ShouldContainSubstring, `<rect class="keyColour" height="73.877941" width="8" y="0.000000"`)
+		})
+
+		Convey("A log key scale should not be used when the legends include values that are not positive", func() {
+			renderRequest.Choropleth.KeyScale = models.KeyScaleLog
+			renderRequest.Choropleth.Breaks[0].LowerBound = 0
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `<rect class="keyColour" height="8" width="40.000000" x="0.000000"`)
+		})
+	})
+}
+
+func TestRenderSVGOpenEndedLabels(t *testing.T) {
+	Convey("Given the example request with labels on the first and last breaks", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		breaks := renderRequest.Choropleth.Breaks
+		breaks[0].Label = "under 10"
+		breaks[len(breaks)-1].Label = "40+"
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+
+		Convey("The labels should replace the computed minimum and maximum ticks in both legends", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(strings.Count(svg, `class="keyText">under 10</text>`), ShouldEqual, 2)
+			So(strings.Count(svg, `class="keyText">40+</text>`), ShouldEqual, 2)
+			So(strings.Count(svg, fmt.Sprintf(`class="keyText">%g</text>`, breaks[1].LowerBound)), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestRenderSVGDecimalPlaces(t *testing.T) {
+	Convey("Given the example request with a value that can't be represented exactly", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Data[0].Value = 33.299999999999
+
+		Convey("Region titles should show the value in full by default", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `<title>Hartlepool 33.299999999999% non-UK born</title>`)
+		})
+
+		Convey("Region titles should show the value to the requested decimal places", func() {
+			decimalPlaces := 2
+			renderRequest.Choropleth.DecimalPlaces = &decimalPlaces
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `<title>Hartlepool 33.30% non-UK born</title>`)
+		})
+	})
+}
+
+func TestRenderSVGEmbedTitle(t *testing.T) {
+	Convey("Given the example request with a title and subtitle", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Title = "Non-UK born"
+		renderRequest.Subtitle = "2011 census"
+
+		Convey("The title should not be drawn in the svg by default", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldNotContainSubstring, ">Non-UK born</text>")
+		})
+
+		Convey("The title and subtitle should be drawn above the map when embedded", func() {
+			renderRequest.EmbedTitle = true
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			So(svg, ShouldStartWith, `<svg xmlns="http://www.w3.org/2000/svg" width="400" height="798" viewBox="0 0 400 798">`)
+			So(svg, ShouldContainSubstring, `<text x="0" y="21" font-size="16" font-weight="bold">Non-UK born</text>`)
+			So(svg, ShouldContainSubstring, `<text x="0" y="44" font-size="12">2011 census</text>`)
+			So(svg, ShouldContainSubstring, `<svg x="0" y="50" width="400" height="748"`)
+		})
+	})
+}
+
+func TestRenderSVGTooltipTemplate(t *testing.T) {
+	Convey("Given the example request with a tooltip template", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.TooltipTemplate = "{name}: {value}{suffix} ({class_label})"
+
+		Convey("Region titles should be built from the template, with the range of the region's break", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `<title>Hartlepool: 3% non-UK born (0% non-UK born to 6% non-UK born)</title>`)
+		})
+
+		Convey("The class label should be the label of the region's break if it has one", func() {
+			renderRequest.Choropleth.Breaks[0].Label = "under 6"
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `<title>Hartlepool: 3% non-UK born (under 6)</title>`)
+		})
+	})
+}
+
+func TestRenderSVGUnits(t *testing.T) {
+	Convey("Given the example request with units", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		renderRequest.Choropleth.Units = "percentage points"
+
+		Convey("The legends should be titled with the units", func() {
+			svgRequest := PrepareSVGRequest(renderRequest)
+			So(RenderHorizontalKey(svgRequest), ShouldContainSubstring, `class="keyText">percentage points</text>`)
+			So(RenderVerticalKey(svgRequest), ShouldContainSubstring, `>percentage points</text>`)
+		})
+
+		Convey("The map should have alt text with the title and units", func() {
+			result := RenderSVG(PrepareSVGRequest(renderRequest))
+			So(result, ShouldContainSubstring, `aria-label="Map of Non-UK born population, Great Britain, 2015, in percentage points"`)
+		})
+
+		Convey("Region titles should still be formatted with the prefix and suffix", func() {
+			result := RenderSVG(PrepareSVGRequest(renderRequest))
+			So(result, ShouldContainSubstring, `<title>Hartlepool 3% non-UK born</title>`)
+		})
+	})
+}
+
+func TestRenderSVGBreakLabels(t *testing.T) {
+	Convey("Given the example request with a label on every break", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		labels := []string{"Very low", "Low", "Medium", "High", "Very high"}
+		for i, b := range renderRequest.Choropleth.Breaks {
+			b.Label = labels[i]
+		}
+		renderRequest.Choropleth.HorizontalLegendPosition = models.LegendPositionInsideBottomLeft
+		renderRequest.Choropleth.VerticalLegendPosition = models.LegendPositionInsideTopRight
+
+		Convey("The labels should be drawn alongside each break in both legends in place of the numeric bounds", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			svg := string(result)
+			for _, label := range labels {
+				So(strings.Count(svg, `class="keyText">`+label+`</text>`), ShouldEqual, 2)
+			}
+			So(svg, ShouldNotContainSubstring, `class="keyText">54</text>`)
+			So(svg, ShouldNotContainSubstring, `class="keyText">20</text>`)
+		})
+
+		Convey("Region titles should still show the numeric value", func() {
+			result, err := RenderSVGDocument(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+			So(string(result), ShouldContainSubstring, `<title>Hartlepool 3% non-UK born</title>`)
+		})
+	})
+}
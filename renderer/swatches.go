@@ -0,0 +1,56 @@
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// defaultSwatchSize is the thickness of the bar of colours in the legends, and the size the rest of the legend is laid out for
+const defaultSwatchSize = 8.0
+
+// swatchStyle is the style of each swatch in the legends, other than its fill
+const swatchStyle = "stroke-width: 0.5; stroke: black;"
+
+// swatchSize returns the thickness of the bar, or the size of the squares or circles, of the choropleth's legends
+func swatchSize(choropleth *models.Choropleth) float64 {
+	if choropleth.SwatchSize > 0 {
+		return choropleth.SwatchSize
+	}
+	return defaultSwatchSize
+}
+
+// swatchExtra returns the space needed for swatches larger than defaultSwatchSize - the distance the ticks are moved away from the start of the swatches
+func swatchExtra(choropleth *models.Choropleth) float64 {
+	return math.Max(swatchSize(choropleth)-defaultSwatchSize, 0)
+}
+
+// writeHorizontalKeySwatch draws the swatch of a break that spans the given width from xPos in the horizontal key -
+// a bar the width of the break, or a square or circle centred within it
+func writeHorizontalKeySwatch(w *bytes.Buffer, choropleth *models.Choropleth, xPos float64, width float64, fill string) {
+	size := swatchSize(choropleth)
+	switch choropleth.SwatchShape {
+	case models.SwatchShapeSquare:
+		fmt.Fprintf(w, `<rect class="keyColour" height="%g" width="%g" x="%f" style="%s fill: %s;"></rect>`, size, size, xPos+(width-size)/2, swatchStyle, fill)
+	case models.SwatchShapeCircle:
+		fmt.Fprintf(w, `<circle class="keyColour" r="%g" cx="%f" cy="%g" style="%s fill: %s;"></circle>`, size/2, xPos+width/2, size/2, swatchStyle, fill)
+	default:
+		fmt.Fprintf(w, `<rect class="keyColour" height="%g" width="%f" x="%f" style="%s fill: %s;"></rect>`, size, width, xPos, swatchStyle, fill)
+	}
+}
+
+// writeVerticalKeySwatch draws the swatch of a break that spans the given height from yPos in the vertical key -
+// a bar the height of the break, or a square or circle centred within it
+func writeVerticalKeySwatch(w *bytes.Buffer, choropleth *models.Choropleth, yPos float64, height float64, fill string) {
+	size := swatchSize(choropleth)
+	switch choropleth.SwatchShape {
+	case models.SwatchShapeSquare:
+		fmt.Fprintf(w, `<rect class="keyColour" height="%g" width="%g" y="%f" style="%s fill: %s;"></rect>`, size, size, yPos+(height-size)/2, swatchStyle, fill)
+	case models.SwatchShapeCircle:
+		fmt.Fprintf(w, `<circle class="keyColour" r="%g" cx="%g" cy="%f" style="%s fill: %s;"></circle>`, size/2, size/2, yPos+height/2, swatchStyle, fill)
+	default:
+		fmt.Fprintf(w, `<rect class="keyColour" height="%f" width="%g" y="%f" style="%s fill: %s;"></rect>`, height, size, yPos, swatchStyle, fill)
+	}
+}
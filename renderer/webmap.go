@@ -0,0 +1,78 @@
+package renderer
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+)
+
+// MissingDataColour is the fill of regions without data in formats that can't use the svg's missing data pattern - web maps, KML and GeoTIFF
+const MissingDataColour = "#cccccc"
+
+// webMapOutlineColour is the colour of the region boundaries in web maps
+const webMapOutlineColour = "#ffffff"
+
+// RenderWebMap returns a json models.WebMapResponse - the regions as geojson with their id, name, value, class and colour (classified in the same way as the svg map)
+// and a fill property, along with Leaflet and MapLibre GL styles that fill each region from that property, and a legend of the breaks.
+func RenderWebMap(ctx context.Context, request *models.RenderRequest) ([]byte, error) {
+	features := classifyFeatures(request)
+	fc := classifiedFeatureCollection(features)
+	for i, f := range features {
+		fill := f.Colour
+		if len(fill) == 0 {
+			fill = MissingDataColour
+		}
+		fc.Features[i].SetProperty("fill", fill)
+	}
+
+	id := idPrefix(request)
+	response := &models.WebMapResponse{
+		ID:      id,
+		Title:   request.Title,
+		GeoJSON: fc,
+		Style: &models.WebMapStyle{
+			Leaflet: &models.LeafletStyle{Color: webMapOutlineColour, Weight: 1, FillOpacity: 1},
+			MapLibre: &models.MapLibreLayer{
+				ID:     id,
+				Type:   "fill",
+				Source: id,
+				Paint: map[string]interface{}{
+					"fill-color":         []string{"get", "fill"},
+					"fill-outline-color": webMapOutlineColour,
+				},
+			},
+		},
+	}
+	if minLon, minLat, maxLon, maxLat, ok := classifiedBounds(features); ok {
+		response.Bounds = []float64{minLon, minLat, maxLon, maxLat}
+	}
+	if request.Choropleth != nil && len(request.Choropleth.Breaks) > 0 && len(request.Data) > 0 {
+		response.Legend = webMapLegend(request)
+	}
+	return json.Marshal(response)
+}
+
+// webMapLegend returns the legend of the request's choropleth, with the same bounds as the svg legends
+func webMapLegend(request *models.RenderRequest) *models.WebMapLegend {
+	choropleth := request.Choropleth
+	breaks, _ := getSortedBreakInfo(request)
+	legend := &models.WebMapLegend{}
+	if showMissingDataKey(choropleth) {
+		legend.MissingColour, legend.MissingText = MissingDataColour, missingDataText(choropleth)
+	}
+	for i, b := range breaks {
+		label := b.Label
+		if len(label) == 0 {
+			label = rangeLabel(choropleth, b.LowerBound, b.UpperBound)
+		}
+		legend.Breaks = append(legend.Breaks, &models.WebMapLegendBreak{
+			Class:      i,
+			LowerBound: b.LowerBound,
+			UpperBound: b.UpperBound,
+			Colour:     b.Colour,
+			Label:      label,
+		})
+	}
+	return legend
+}
@@ -0,0 +1,54 @@
+package renderer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	. "github.com/ONSdigital/dp-map-renderer/renderer"
+	"github.com/ONSdigital/dp-map-renderer/testdata"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderWebMap(t *testing.T) {
+	Convey("Given the example request", t, func() {
+		renderRequest, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Convey("RenderWebMap should return the regions with their classification, a style filling them with their colour, and a legend", func() {
+			result, err := RenderWebMap(context.Background(), renderRequest)
+			So(err, ShouldBeNil)
+
+			var response models.WebMapResponse
+			So(json.Unmarshal(result, &response), ShouldBeNil)
+			So(response.ID, ShouldEqual, "map-"+renderRequest.Filename)
+			So(response.Bounds, ShouldHaveLength, 4)
+			So(response.Bounds[0], ShouldBeLessThan, response.Bounds[2])
+			So(response.Style.MapLibre.Source, ShouldEqual, response.ID)
+			So(response.Style.MapLibre.Paint["fill-color"], ShouldResemble, []interface{}{"get", "fill"})
+
+			So(response.Legend, ShouldNotBeNil)
+			So(response.Legend.Breaks, ShouldHaveLength, len(renderRequest.Choropleth.Breaks))
+			So(response.Legend.MissingColour, ShouldEqual, MissingDataColour)
+
+			withData := 0
+			for _, feature := range response.GeoJSON.Features {
+				if _, exists := feature.Properties["value"]; exists {
+					withData++
+					class := int(feature.Properties["class"].(float64))
+					So(feature.Properties["fill"], ShouldEqual, response.Legend.Breaks[class].Colour)
+					So(feature.Properties["colour"], ShouldEqual, feature.Properties["fill"])
+				} else {
+					So(feature.Properties["fill"], ShouldEqual, MissingDataColour)
+				}
+				So(feature.Properties["id"], ShouldNotBeEmpty)
+				So(feature.Properties["name"], ShouldNotBeEmpty)
+			}
+			So(withData, ShouldBeGreaterThan, 0)
+		})
+	})
+}
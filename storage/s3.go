@@ -0,0 +1,178 @@
+// Package storage persists rendered maps to an S3 bucket, so that workflows that store figures (such as a CMS) can refer to a stored object rather than render the map again
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxErrorSize is the maximum number of bytes of an error response from S3 included in a PutError
+const maxErrorSize = 1024
+
+// Credentials are the AWS credentials used to sign requests to S3. If AccessKeyID is empty, requests are not signed.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // only required for temporary credentials
+}
+
+// PutError describes a failure to store an object - the request failed or timed out, or S3 rejected it
+type PutError struct {
+	Key     string
+	Message string
+}
+
+// Error returns the key and the reason the object couldn't be stored
+func (e *PutError) Error() string {
+	return fmt.Sprintf("Unable to store %s: %s", e.Key, e.Message)
+}
+
+// S3Store stores objects in an S3 bucket, with keys derived from their content - so storing the same output twice gives the same object,
+// and an object never changes once its url has been returned.
+type S3Store struct {
+	bucket      string
+	region      string
+	endpoint    string
+	prefix      string
+	credentials Credentials
+	client      *http.Client
+	now         func() time.Time
+}
+
+// NewS3Store creates an S3Store of objects in the bucket, with keys starting with the prefix (e.g. "maps/"), which fails requests that take longer than the timeout.
+// If endpoint is empty, objects are stored at https://{bucket}.s3.{region}.amazonaws.com, otherwise at {endpoint}/{bucket} (e.g. for an S3-compatible service).
+func NewS3Store(bucket, region, endpoint, prefix string, credentials Credentials, timeout time.Duration) *S3Store {
+	return &S3Store{
+		bucket:      bucket,
+		region:      region,
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		prefix:      prefix,
+		credentials: credentials,
+		client:      &http.Client{Timeout: timeout},
+		now:         time.Now,
+	}
+}
+
+// Key returns the key of the object with the given content - the prefix, followed by the sha256 hash of the content and the extension (e.g. ".svg")
+func (s *S3Store) Key(content []byte, extension string) string {
+	hash := sha256.Sum256(content)
+	return s.prefix + hex.EncodeToString(hash[:]) + extension
+}
+
+// Put stores the content with the given content type and extension, returning the url of the object. Returns a PutError if it can't be stored.
+func (s *S3Store) Put(ctx context.Context, content []byte, contentType string, extension string) (string, error) {
+	key := s.Key(content, extension)
+	objectURL := s.objectURL(key)
+
+	request, err := http.NewRequest("PUT", objectURL, bytes.NewReader(content))
+	if err != nil {
+		return "", &PutError{Key: key, Message: err.Error()}
+	}
+	request.Header.Set("Content-Type", contentType)
+	s.sign(request, content)
+
+	response, err := s.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return "", &PutError{Key: key, Message: err.Error()}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorSize))
+		return "", &PutError{Key: key, Message: strings.TrimSpace(fmt.Sprintf("%s %s", response.Status, b))}
+	}
+	return objectURL, nil
+}
+
+// objectURL returns the url of the object with the given key, using the virtual-hosted style for AWS and the path style for other endpoints
+func (s *S3Store) objectURL(key string) string {
+	if len(s.endpoint) == 0 {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, escapePath(key))
+	}
+	return fmt.Sprintf("%s/%s/%s", s.endpoint, escapePath(s.bucket), escapePath(key))
+}
+
+// sign adds the headers of AWS signature version 4 to the request, unless the store has no credentials
+func (s *S3Store) sign(request *http.Request, content []byte) {
+	if len(s.credentials.AccessKeyID) == 0 {
+		return
+	}
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(content)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if len(s.credentials.SessionToken) > 0 {
+		request.Header.Set("X-Amz-Security-Token", s.credentials.SessionToken)
+	}
+
+	// the signed headers must be in order of their (lower case) names
+	names := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if len(s.credentials.SessionToken) > 0 {
+		names = append(names, "x-amz-security-token")
+	}
+	var headers bytes.Buffer
+	for _, name := range names {
+		value := request.Header.Get(name)
+		if name == "host" {
+			value = request.URL.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+	signature := hmacSHA256(signingKey(s.credentials.SecretAccessKey, date, s.region, "s3"), stringToSign)
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.credentials.AccessKeyID, scope, signedHeaders, hex.EncodeToString(signature)))
+}
+
+// signingKey derives the key that signs requests to the service in the region on the given date (yyyymmdd)
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, value string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
+
+// escapePath escapes each segment of the path as AWS requires - every byte other than letters, digits and -._~ is percent-encoded
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
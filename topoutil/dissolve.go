@@ -0,0 +1,217 @@
+package topoutil
+
+import (
+	"fmt"
+
+	"github.com/paulmach/go.geojson"
+	"github.com/rubenv/topojson"
+)
+
+// Dissolve returns a copy of the topology in which the polygons of each object with the same value of the given property (e.g. the region of a local authority)
+// are merged into a single multipolygon, with the arcs between them removed. The properties of a merged geometry are its id and name properties and the dissolve property,
+// all set to the shared value (every one of the id properties, if there are several). Geometries without the property, and geometries other than polygons, are unchanged. Arcs are shared with the original.
+func Dissolve(topology *topojson.Topology, property string, idProperties []string, nameProperty string) *topojson.Topology {
+	arcs := absoluteArcs(topology)
+	result := &topojson.Topology{Type: topology.Type, Transform: topology.Transform, BoundingBox: topology.BoundingBox, Arcs: topology.Arcs,
+		Objects: make(map[string]*topojson.Geometry, len(topology.Objects))}
+	for name, o := range topology.Objects {
+		if o == nil || o.Type != geojson.GeometryCollection {
+			result.Objects[name] = o
+			continue
+		}
+		c := *o
+		c.Geometries = dissolveGeometries(o.Geometries, arcs, property, idProperties, nameProperty)
+		result.Objects[name] = &c
+	}
+	return result
+}
+
+// DissolvedValue returns the value of the dissolve property of the geometry as a string, and whether the geometry has it
+func DissolvedValue(g *topojson.Geometry, property string) (string, bool) {
+	value, ok := g.Properties[property]
+	if !ok || value == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%v", value), true
+}
+
+// dissolveGeometries merges the polygons with the same value of the property, in the order in which each value first occurs
+func dissolveGeometries(geometries []*topojson.Geometry, arcs [][][]float64, property string, idProperties []string, nameProperty string) []*topojson.Geometry {
+	var result []*topojson.Geometry
+	groups := make(map[string]*topojson.Geometry)
+	polygons := make(map[string][][][]int)
+	for _, g := range geometries {
+		value, ok := DissolvedValue(g, property)
+		if !ok || (g.Type != geojson.GeometryPolygon && g.Type != geojson.GeometryMultiPolygon) {
+			result = append(result, g)
+			continue
+		}
+		if _, exists := groups[value]; !exists {
+			groups[value] = &topojson.Geometry{ID: value, Type: geojson.GeometryMultiPolygon,
+				Properties: map[string]interface{}{property: value, nameProperty: value}}
+			for _, idProperty := range idProperties {
+				groups[value].Properties[idProperty] = value
+			}
+			result = append(result, groups[value])
+		}
+		if g.Type == geojson.GeometryPolygon {
+			polygons[value] = append(polygons[value], g.Polygon)
+		} else {
+			polygons[value] = append(polygons[value], g.MultiPolygon...)
+		}
+	}
+	for value, g := range groups {
+		g.MultiPolygon = mergePolygons(polygons[value], arcs)
+	}
+	return result
+}
+
+// mergePolygons returns the polygons merged into as few polygons as possible - the arcs used by two of the polygons (their shared boundaries) are removed,
+// and the remaining arcs joined into rings. Falls back to the original polygons if the remaining arcs can't be joined into closed rings.
+func mergePolygons(polygons [][][]int, arcs [][][]float64) [][][]int {
+	if len(polygons) < 2 {
+		return polygons
+	}
+	uses := make(map[int]int)
+	for _, polygon := range polygons {
+		for _, ring := range polygon {
+			for _, a := range ring {
+				uses[arcIndex(a)]++
+			}
+		}
+	}
+
+	// the remaining (outer) arcs, indexed by the position they start from
+	var outer []int
+	starts := make(map[[2]float64][]int)
+	for _, polygon := range polygons {
+		for _, ring := range polygon {
+			for _, a := range ring {
+				if uses[arcIndex(a)] == 1 {
+					outer = append(outer, a)
+					start := arcStart(a, arcs)
+					starts[start] = append(starts[start], a)
+				}
+			}
+		}
+	}
+
+	used := make(map[int]bool)
+	var rings [][]int
+	for _, first := range outer {
+		if used[first] {
+			continue
+		}
+		ring := []int{first}
+		used[first] = true
+		origin, end := arcStart(first, arcs), arcEnd(first, arcs)
+		for end != origin {
+			next, found := 0, false
+			for _, a := range starts[end] {
+				if !used[a] {
+					next, found = a, true
+					break
+				}
+			}
+			if !found {
+				return polygons
+			}
+			ring = append(ring, next)
+			used[next] = true
+			end = arcEnd(next, arcs)
+		}
+		rings = append(rings, ring)
+	}
+	return assignHoles(rings, ringArea(polygons[0][0], arcs) > 0, arcs)
+}
+
+// assignHoles returns polygons of the rings with the same orientation as an exterior ring (anticlockwise if exteriorPositive), each followed by the other rings (holes) within it
+func assignHoles(rings [][]int, exteriorPositive bool, arcs [][][]float64) [][][]int {
+	var polygons [][][]int
+	var exteriors [][][]float64
+	var holes [][]int
+	for _, ring := range rings {
+		if (ringArea(ring, arcs) > 0) == exteriorPositive {
+			polygons = append(polygons, [][]int{ring})
+			exteriors = append(exteriors, ringPoints(ring, arcs))
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	for _, hole := range holes {
+		p := arcStart(hole[0], arcs)
+		assigned := false
+		for i, exterior := range exteriors {
+			if pointInRing(p, exterior) {
+				polygons[i] = append(polygons[i], hole)
+				assigned = true
+				break
+			}
+		}
+		if !assigned {
+			polygons = append(polygons, [][]int{hole})
+		}
+	}
+	return polygons
+}
+
+// arcIndex returns the index of the arc referenced by a, which is ~i (-i-1) if arc i is reversed
+func arcIndex(a int) int {
+	if a < 0 {
+		return ^a
+	}
+	return a
+}
+
+// arcStart returns the first position of the (possibly reversed) arc
+func arcStart(a int, arcs [][][]float64) [2]float64 {
+	arc := arcs[arcIndex(a)]
+	if a < 0 {
+		return [2]float64{arc[len(arc)-1][0], arc[len(arc)-1][1]}
+	}
+	return [2]float64{arc[0][0], arc[0][1]}
+}
+
+// arcEnd returns the last position of the (possibly reversed) arc
+func arcEnd(a int, arcs [][][]float64) [2]float64 {
+	return arcStart(^a, arcs)
+}
+
+// ringPoints returns the positions of the ring of arcs, in order
+func ringPoints(ring []int, arcs [][][]float64) [][]float64 {
+	var points [][]float64
+	for _, a := range ring {
+		arc := arcs[arcIndex(a)]
+		for j := range arc {
+			if a < 0 {
+				points = append(points, arc[len(arc)-1-j])
+			} else {
+				points = append(points, arc[j])
+			}
+		}
+	}
+	return points
+}
+
+// ringArea returns the signed area of the ring - positive if it is anticlockwise
+func ringArea(ring []int, arcs [][][]float64) float64 {
+	points := ringPoints(ring, arcs)
+	area := 0.0
+	for i := range points {
+		p, q := points[i], points[(i+1)%len(points)]
+		area += p[0]*q[1] - q[0]*p[1]
+	}
+	return area / 2
+}
+
+// pointInRing returns true if the position is inside the ring, using the even-odd rule
+func pointInRing(p [2]float64, ring [][]float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,59 @@
+package topoutil_test
+
+import (
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
+	"github.com/paulmach/go.geojson"
+	"github.com/rubenv/topojson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// three unit squares side by side - a and b in region r1 (sharing arc 0), and c in region r2
+const squaresTopology = `{"type":"Topology","objects":{"areas":{"type":"GeometryCollection","geometries":[
+{"type":"Polygon","arcs":[[0,1]],"properties":{"code":"a","region":"r1"}},
+{"type":"Polygon","arcs":[[2,-1]],"properties":{"code":"b","region":"r1"}},
+{"type":"Polygon","arcs":[[3]],"properties":{"code":"c","region":"r2"}},
+{"type":"Polygon","arcs":[[4]],"properties":{"code":"d"}}]}},
+"arcs":[[[1,0],[1,1]],[[1,1],[0,1],[0,0],[1,0]],[[1,0],[2,0],[2,1],[1,1]],[[2,0],[3,0],[3,1],[2,1],[2,0]],[[5,0],[6,0],[6,1],[5,1],[5,0]]]}`
+
+func TestDissolve(t *testing.T) {
+	Convey("Given a topology of squares in two regions", t, func() {
+		topology, err := topojson.UnmarshalTopology([]byte(squaresTopology))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dissolved := topoutil.Dissolve(topology, "region", []string{"code"}, "name")
+		geometries := dissolved.Objects["areas"].Geometries
+
+		Convey("Regions with the same value of the property should be merged, without their shared boundary", func() {
+			So(geometries, ShouldHaveLength, 3)
+			So(geometries[0].Type, ShouldEqual, geojson.GeometryMultiPolygon)
+			So(geometries[0].Properties["code"], ShouldEqual, "r1")
+			So(geometries[0].Properties["name"], ShouldEqual, "r1")
+			So(geometries[0].MultiPolygon, ShouldResemble, [][][]int{{{1, 2}}})
+			So(geometries[1].Properties["code"], ShouldEqual, "r2")
+			So(geometries[1].MultiPolygon, ShouldResemble, [][][]int{{{3}}})
+		})
+
+		Convey("Regions without the property should be unchanged", func() {
+			So(geometries[2], ShouldEqual, topology.Objects["areas"].Geometries[3])
+		})
+
+		Convey("The original topology should be unchanged", func() {
+			So(topology.Objects["areas"].Geometries, ShouldHaveLength, 4)
+		})
+
+		Convey("The merged region should convert to a single polygon", func() {
+			fc := dissolved.ToGeoJSON()
+			So(fc.Features, ShouldHaveLength, 3)
+			for _, f := range fc.Features {
+				if f.Properties["code"] == "r1" {
+					So(f.Geometry.MultiPolygon, ShouldHaveLength, 1)
+					So(f.Geometry.MultiPolygon[0], ShouldHaveLength, 1)
+				}
+			}
+		})
+	})
+}
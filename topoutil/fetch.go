@@ -0,0 +1,193 @@
+package topoutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/rubenv/topojson"
+)
+
+// ErrURLNotAllowed is returned when a topology is requested from a url that isn't on a host the Fetcher allows, or isn't http(s)
+var ErrURLNotAllowed = errors.New("Topojson url is not allowed")
+
+// FetchError describes a failure to fetch a topology - the request failed or timed out, the response wasn't 200 OK,
+// or the topology was too large or couldn't be decoded
+type FetchError struct {
+	URL     string
+	Message string
+}
+
+// Error returns the url and the reason the topology couldn't be fetched
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("Unable to fetch topojson from %s: %s", e.URL, e.Message)
+}
+
+// Fetcher fetches topologies by url from a list of allowed hosts, so that requests can refer to a published topology rather than include it.
+// Topologies are cached by url, so a topology is only fetched again once it has been evicted - urls should change when their topology does.
+// When the cache is full, the oldest topology is evicted to make room for a new one.
+type Fetcher struct {
+	client     *http.Client
+	hosts      map[string]bool
+	maxSize    int64
+	mutex      sync.Mutex
+	entries    map[string]*fetchedTopology
+	order      []string
+	maxEntries int
+}
+
+// fetchedTopology is a topology that has been fetched, with a hash of the topojson it was decoded from
+type fetchedTopology struct {
+	topology *topojson.Topology
+	hash     string
+}
+
+// NewFetcher creates a Fetcher of topologies from the given hosts (e.g. "cdn.ons.gov.uk", or "localhost:8080" to allow only that port),
+// which fails fetches that take longer than the timeout or that are larger than maxSize bytes, and caches up to maxEntries topologies.
+// A maxSize or maxEntries of zero (or less) disables the limit or cache.
+func NewFetcher(hosts []string, timeout time.Duration, maxSize int64, maxEntries int) *Fetcher {
+	f := &Fetcher{hosts: make(map[string]bool), maxSize: maxSize, entries: make(map[string]*fetchedTopology), maxEntries: maxEntries}
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); len(host) > 0 {
+			f.hosts[host] = true
+		}
+	}
+	f.client = &http.Client{Timeout: timeout, CheckRedirect: f.checkRedirect}
+	return f
+}
+
+// Fetch returns the topology at the url, from the cache if it has been fetched before, with a hash (hex sha256) of its topojson - which differs if the topology does.
+// Returns ErrURLNotAllowed if the url isn't on an allowed host, or a FetchError if it can't be fetched. The topology is a copy, which the caller may modify.
+func (f *Fetcher) Fetch(ctx context.Context, topojsonURL string) (*topojson.Topology, string, error) {
+	if !f.allows(topojsonURL) {
+		return nil, "", ErrURLNotAllowed
+	}
+	if fetched := f.get(topojsonURL); fetched != nil {
+		return copyTopology(fetched.topology), fetched.hash, nil
+	}
+
+	request, err := http.NewRequest("GET", topojsonURL, nil)
+	if err != nil {
+		return nil, "", &FetchError{URL: topojsonURL, Message: err.Error()}
+	}
+	response, err := f.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, "", &FetchError{URL: topojsonURL, Message: err.Error()}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, "", &FetchError{URL: topojsonURL, Message: response.Status}
+	}
+
+	var body io.Reader = response.Body
+	if f.maxSize > 0 {
+		if response.ContentLength > f.maxSize {
+			return nil, "", &FetchError{URL: topojsonURL, Message: fmt.Sprintf("Topojson exceeds the maximum size of %d bytes", f.maxSize)}
+		}
+		body = io.LimitReader(response.Body, f.maxSize+1)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, "", &FetchError{URL: topojsonURL, Message: err.Error()}
+	}
+	if f.maxSize > 0 && int64(len(b)) > f.maxSize {
+		return nil, "", &FetchError{URL: topojsonURL, Message: fmt.Sprintf("Topojson exceeds the maximum size of %d bytes", f.maxSize)}
+	}
+
+	var topology topojson.Topology
+	if err = jsoniter.Unmarshal(b, &topology); err != nil {
+		return nil, "", &FetchError{URL: topojsonURL, Message: err.Error()}
+	}
+	hash := sha256.Sum256(b)
+	fetched := &fetchedTopology{topology: &topology, hash: hex.EncodeToString(hash[:])}
+	f.put(topojsonURL, fetched)
+	return copyTopology(fetched.topology), fetched.hash, nil
+}
+
+// checkRedirect stops the client following a redirect to a url the fetcher doesn't allow
+func (f *Fetcher) checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !f.allows(request.URL.String()) {
+		return ErrURLNotAllowed
+	}
+	return nil
+}
+
+// allows returns true if the url is http or https, on one of the fetcher's hosts (with or without its port)
+func (f *Fetcher) allows(topojsonURL string) bool {
+	u, err := url.Parse(topojsonURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	return f.hosts[host] || f.hosts[strings.ToLower(u.Hostname())]
+}
+
+// get returns the cached topology for the url, or nil if there is none
+func (f *Fetcher) get(topojsonURL string) *fetchedTopology {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.entries[topojsonURL]
+}
+
+// put adds the topology to the cache, evicting the oldest entry if the cache is full
+func (f *Fetcher) put(topojsonURL string, topology *fetchedTopology) {
+	if f.maxEntries <= 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, exists := f.entries[topojsonURL]; exists {
+		return
+	}
+	if len(f.order) >= f.maxEntries {
+		delete(f.entries, f.order[0])
+		f.order = f.order[1:]
+	}
+	f.entries[topojsonURL] = topology
+	f.order = append(f.order, topojsonURL)
+}
+
+// copyTopology returns a copy of the topology whose objects (and their properties) can be modified without affecting the original.
+// The arcs, which aren't modified when rendering, are shared.
+func copyTopology(t *topojson.Topology) *topojson.Topology {
+	result := *t
+	result.Objects = make(map[string]*topojson.Geometry, len(t.Objects))
+	for name, o := range t.Objects {
+		result.Objects[name] = copyGeometry(o)
+	}
+	return &result
+}
+
+// copyGeometry returns a copy of the geometry with copies of its properties and of the geometries it contains
+func copyGeometry(g *topojson.Geometry) *topojson.Geometry {
+	if g == nil {
+		return nil
+	}
+	c := *g
+	if g.Properties != nil {
+		c.Properties = make(map[string]interface{}, len(g.Properties))
+		for k, v := range g.Properties {
+			c.Properties[k] = v
+		}
+	}
+	if g.Geometries != nil {
+		c.Geometries = make([]*topojson.Geometry, len(g.Geometries))
+		for i, child := range g.Geometries {
+			c.Geometries[i] = copyGeometry(child)
+		}
+	}
+	return &c
+}
@@ -0,0 +1,107 @@
+package topoutil_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFetch(t *testing.T) {
+	Convey("Given a server of a topology", t, func() {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			switch r.URL.Path {
+			case "/squares.json":
+				fmt.Fprint(w, squaresTopology)
+			case "/elsewhere":
+				http.Redirect(w, r, "http://example.com/squares.json", http.StatusFound)
+			case "/changed.json":
+				fmt.Fprint(w, strings.Replace(squaresTopology, `"code":"a"`, `"code":"z"`, 1))
+			case "/slow.json":
+				time.Sleep(100 * time.Millisecond)
+				fmt.Fprint(w, squaresTopology)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+		host := mustParse(server.URL).Host
+		fetcher := topoutil.NewFetcher([]string{host}, time.Second, 0, 10)
+
+		Convey("The topology is fetched, and cached, with a hash of its topojson", func() {
+			topology, hash, err := fetcher.Fetch(context.Background(), server.URL+"/squares.json")
+			So(err, ShouldBeNil)
+			So(topology.Objects["areas"].Geometries, ShouldHaveLength, 4)
+			So(requests, ShouldEqual, 1)
+			expected := sha256.Sum256([]byte(squaresTopology))
+			So(hash, ShouldEqual, hex.EncodeToString(expected[:]))
+
+			Convey("Each topology returned is a copy", func() {
+				topology.Objects["areas"].Geometries[0].Properties["code"] = "changed"
+
+				cached, cachedHash, err := fetcher.Fetch(context.Background(), server.URL+"/squares.json")
+				So(err, ShouldBeNil)
+				So(requests, ShouldEqual, 1)
+				So(cached.Objects["areas"].Geometries[0].Properties["code"], ShouldEqual, "a")
+				So(cachedHash, ShouldEqual, hash)
+			})
+
+			Convey("A different topology has a different hash", func() {
+				changed, changedHash, err := fetcher.Fetch(context.Background(), server.URL+"/changed.json")
+				So(err, ShouldBeNil)
+				So(changed.Objects["areas"].Geometries[0].Properties["code"], ShouldEqual, "z")
+				So(changedHash, ShouldNotEqual, hash)
+			})
+		})
+
+		Convey("A url on another host isn't allowed", func() {
+			_, _, err := fetcher.Fetch(context.Background(), "http://example.com/squares.json")
+			So(err, ShouldEqual, topoutil.ErrURLNotAllowed)
+
+			_, _, err = fetcher.Fetch(context.Background(), "file:///etc/passwd")
+			So(err, ShouldEqual, topoutil.ErrURLNotAllowed)
+			So(requests, ShouldEqual, 0)
+		})
+
+		Convey("A redirect to another host isn't followed", func() {
+			_, _, err := fetcher.Fetch(context.Background(), server.URL+"/elsewhere")
+			So(err, ShouldHaveSameTypeAs, &topoutil.FetchError{})
+		})
+
+		Convey("A missing topology isn't fetched", func() {
+			_, _, err := fetcher.Fetch(context.Background(), server.URL+"/missing.json")
+			So(err, ShouldHaveSameTypeAs, &topoutil.FetchError{})
+			So(err.Error(), ShouldContainSubstring, "404")
+		})
+
+		Convey("A topology larger than the maximum size isn't fetched", func() {
+			fetcher = topoutil.NewFetcher([]string{host}, time.Second, 100, 10)
+			_, _, err := fetcher.Fetch(context.Background(), server.URL+"/squares.json")
+			So(err, ShouldHaveSameTypeAs, &topoutil.FetchError{})
+			So(err.Error(), ShouldContainSubstring, "maximum size")
+		})
+
+		Convey("A topology that takes longer than the timeout isn't fetched", func() {
+			fetcher = topoutil.NewFetcher([]string{host}, 10*time.Millisecond, 0, 10)
+			_, _, err := fetcher.Fetch(context.Background(), server.URL+"/slow.json")
+			So(err, ShouldHaveSameTypeAs, &topoutil.FetchError{})
+		})
+	})
+}
+
+func mustParse(s string) *url.URL {
+	u, err := url.Parse(s)
+	So(err, ShouldBeNil)
+	return u
+}
@@ -0,0 +1,135 @@
+// Package topoutil provides operations on topojson topologies, for endpoints that return topojson.
+package topoutil
+
+import (
+	"math"
+
+	"github.com/paulmach/go.geojson"
+	"github.com/rubenv/topojson"
+)
+
+// DefaultQuantisation is the quantisation factor used when none is given - the number of distinct values of x and y across the extent of the topology
+const DefaultQuantisation = 1e5
+
+// Quantize returns a copy of the topology with its coordinates quantised to integers on a grid of the given number of points (e.g. 1e4) across its extent,
+// and its arcs delta-encoded, minimising the size of the json. The topology may already be quantised, in which case it is re-quantised.
+// Consecutive points of an arc that are quantised to the same position are removed. Objects and their properties are shared with the original.
+func Quantize(topology *topojson.Topology, quantisation float64) *topojson.Topology {
+	if quantisation < 2 {
+		quantisation = DefaultQuantisation
+	}
+	arcs := absoluteArcs(topology)
+
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	extend := func(p []float64) {
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+	for _, arc := range arcs {
+		for _, p := range arc {
+			extend(p)
+		}
+	}
+	objects := make(map[string]*topojson.Geometry, len(topology.Objects))
+	for name, o := range topology.Objects {
+		objects[name] = mapPoints(o, func(p []float64) []float64 {
+			p = untransform(topology.Transform, p)
+			extend(p)
+			return p
+		})
+	}
+	if math.IsInf(minX, 1) {
+		return &topojson.Topology{Type: topology.Type, Objects: topology.Objects, Arcs: topology.Arcs, Transform: topology.Transform, BoundingBox: topology.BoundingBox}
+	}
+
+	kx, ky := 1.0, 1.0
+	if maxX > minX {
+		kx = (quantisation - 1) / (maxX - minX)
+	}
+	if maxY > minY {
+		ky = (quantisation - 1) / (maxY - minY)
+	}
+	quantize := func(p []float64) []float64 {
+		return []float64{math.Floor((p[0]-minX)*kx + .5), math.Floor((p[1]-minY)*ky + .5)}
+	}
+
+	result := &topojson.Topology{
+		Type:        "Topology",
+		Transform:   &topojson.Transform{Scale: [2]float64{1 / kx, 1 / ky}, Translate: [2]float64{minX, minY}},
+		BoundingBox: []float64{minX, minY, maxX, maxY},
+		Objects:     make(map[string]*topojson.Geometry, len(objects)),
+		Arcs:        make([][][]float64, len(arcs)),
+	}
+	for name, o := range objects {
+		result.Objects[name] = mapPoints(o, quantize)
+	}
+	for i, arc := range arcs {
+		result.Arcs[i] = deltaEncode(arc, quantize)
+	}
+	return result
+}
+
+// absoluteArcs returns the arcs of the topology in untransformed coordinates, decoding delta-encoded arcs
+func absoluteArcs(topology *topojson.Topology) [][][]float64 {
+	arcs := make([][][]float64, len(topology.Arcs))
+	for i, arc := range topology.Arcs {
+		arcs[i] = make([][]float64, len(arc))
+		x, y := 0.0, 0.0
+		for j, p := range arc {
+			if topology.Transform == nil {
+				arcs[i][j] = []float64{p[0], p[1]}
+				continue
+			}
+			x, y = x+p[0], y+p[1]
+			arcs[i][j] = untransform(topology.Transform, []float64{x, y})
+		}
+	}
+	return arcs
+}
+
+// deltaEncode quantises the arc, removing consecutive duplicate points (but keeping at least two), and encodes each point relative to the previous point
+func deltaEncode(arc [][]float64, quantize func([]float64) []float64) [][]float64 {
+	encoded := make([][]float64, 0, len(arc))
+	var x, y float64
+	for j, p := range arc {
+		q := quantize(p)
+		dx, dy := q[0]-x, q[1]-y
+		if j > 0 && dx == 0 && dy == 0 && !(j == len(arc)-1 && len(encoded) < 2) {
+			continue
+		}
+		encoded = append(encoded, []float64{dx, dy})
+		x, y = q[0], q[1]
+	}
+	return encoded
+}
+
+// untransform converts a quantised position to its original coordinates, if the topology has a transform
+func untransform(transform *topojson.Transform, p []float64) []float64 {
+	if transform == nil {
+		return []float64{p[0], p[1]}
+	}
+	return []float64{p[0]*transform.Scale[0] + transform.Translate[0], p[1]*transform.Scale[1] + transform.Translate[1]}
+}
+
+// mapPoints returns a copy of the geometry with the coordinates of its points (which, unlike arcs, are not delta-encoded) converted by the given function
+func mapPoints(g *topojson.Geometry, convert func([]float64) []float64) *topojson.Geometry {
+	if g == nil {
+		return nil
+	}
+	c := *g
+	switch g.Type {
+	case geojson.GeometryPoint:
+		c.Point = convert(g.Point)
+	case geojson.GeometryMultiPoint:
+		c.MultiPoint = make([][]float64, len(g.MultiPoint))
+		for i, p := range g.MultiPoint {
+			c.MultiPoint[i] = convert(p)
+		}
+	case geojson.GeometryCollection:
+		c.Geometries = make([]*topojson.Geometry, len(g.Geometries))
+		for i, child := range g.Geometries {
+			c.Geometries[i] = mapPoints(child, convert)
+		}
+	}
+	return &c
+}
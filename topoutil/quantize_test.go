@@ -0,0 +1,59 @@
+package topoutil_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/ONSdigital/dp-map-renderer/models"
+	"github.com/ONSdigital/dp-map-renderer/testdata"
+	"github.com/ONSdigital/dp-map-renderer/topoutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuantize(t *testing.T) {
+	Convey("Given the topology of the example request", t, func() {
+		request, err := models.CreateRenderRequest(bytes.NewReader(testdata.LoadExampleRequest(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		topology := request.Geography.Topojson
+		original, _ := json.Marshal(topology)
+
+		Convey("Quantize should re-quantise it to a smaller topology with the same shapes", func() {
+			quantised := topoutil.Quantize(topology, 1e3)
+			b, err := json.Marshal(quantised)
+			So(err, ShouldBeNil)
+			So(len(b), ShouldBeLessThan, len(original))
+			So(quantised.Arcs, ShouldHaveLength, len(topology.Arcs))
+
+			for _, arc := range quantised.Arcs {
+				So(len(arc), ShouldBeGreaterThanOrEqualTo, 2)
+				for _, p := range arc {
+					So(p[0], ShouldEqual, math.Floor(p[0]))
+				}
+			}
+
+			// each point should be within a grid cell of its original position
+			before, after := topology.ToGeoJSON(), quantised.ToGeoJSON()
+			tolerance := (quantised.BoundingBox[2] - quantised.BoundingBox[0]) / 1e3
+			So(after.Features, ShouldHaveLength, len(before.Features))
+			p, q := firstPoint(before.Features[0].Geometry.MultiPolygon, before.Features[0].Geometry.Polygon), firstPoint(after.Features[0].Geometry.MultiPolygon, after.Features[0].Geometry.Polygon)
+			So(q[0], ShouldAlmostEqual, p[0], tolerance)
+			So(q[1], ShouldAlmostEqual, p[1], tolerance)
+		})
+
+		Convey("Quantize should use the default quantisation if none is given", func() {
+			quantised := topoutil.Quantize(topology, 0)
+			So(quantised.Transform.Scale[0], ShouldAlmostEqual, (quantised.BoundingBox[2]-quantised.BoundingBox[0])/(topoutil.DefaultQuantisation-1))
+		})
+	})
+}
+
+func firstPoint(multiPolygon [][][][]float64, polygon [][][]float64) []float64 {
+	if len(multiPolygon) > 0 {
+		return multiPolygon[0][0][0]
+	}
+	return polygon[0][0]
+}
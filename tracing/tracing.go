@@ -0,0 +1,165 @@
+// Package tracing records spans around the phases of the render pipeline, so that slow renders can be diagnosed.
+//
+// The Tracer and Span interfaces follow the shape of the OpenTelemetry trace API (Start returns a derived context and a span,
+// which must be ended), so that an OpenTelemetry tracer can be plugged in with a thin adapter using UseTracer.
+// Trace and span ids use the W3C Trace Context format, and an incoming traceparent header is honoured by Middleware.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// TraceparentHeader is the W3C Trace Context header used to propagate a trace between services
+const TraceparentHeader = "traceparent"
+
+// Tracer creates spans
+type Tracer interface {
+	// Start creates a span that is a child of any span in ctx, returning the span and a context containing it
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span represents a single timed operation within a trace
+type Span interface {
+	// SetAttribute records a key-value pair against the span
+	SetAttribute(key string, value interface{})
+	// End completes the span
+	End()
+}
+
+var (
+	tracer   Tracer = noopTracer{}
+	tracerMu sync.RWMutex
+
+	traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+)
+
+// UseTracer sets the Tracer used to create spans. A nil tracer disables tracing.
+func UseTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracerMu.Lock()
+	defer tracerMu.Unlock()
+	tracer = t
+}
+
+// Start creates a span using the configured Tracer
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	tracerMu.RLock()
+	t := tracer
+	tracerMu.RUnlock()
+	return t.Start(ctx, name)
+}
+
+// Middleware starts a span for each http request, continuing the trace given in any traceparent header
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := parseTraceparent(r.Header.Get(TraceparentHeader)); ok {
+			ctx = context.WithValue(ctx, spanContextKey, sc)
+		}
+		ctx, span := Start(ctx, r.Method+" "+r.URL.Path)
+		defer span.End()
+		if sc, ok := ctx.Value(spanContextKey).(*spanContext); ok {
+			w.Header().Set(TraceparentHeader, sc.traceparent())
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// noopTracer creates spans that do nothing
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) End()                                       {}
+
+type contextKey string
+
+const spanContextKey = contextKey("span")
+
+// spanContext identifies a span within a trace
+type spanContext struct {
+	traceID string
+	spanID  string
+}
+
+func (sc *spanContext) traceparent() string {
+	return "00-" + sc.traceID + "-" + sc.spanID + "-01"
+}
+
+// parseTraceparent parses a W3C traceparent header
+func parseTraceparent(header string) (*spanContext, bool) {
+	m := traceparentPattern.FindStringSubmatch(header)
+	if m == nil {
+		return nil, false
+	}
+	return &spanContext{traceID: m[1], spanID: m[2]}, true
+}
+
+// NewLogTracer returns a Tracer that writes each span to the log when it ends, including its trace id, parent span id and duration
+func NewLogTracer() Tracer {
+	return logTracer{}
+}
+
+type logTracer struct{}
+
+func (logTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &logSpan{name: name, start: time.Now(), attributes: log.Data{}}
+	span.spanContext = &spanContext{spanID: randomHex(8)}
+	if parent, ok := ctx.Value(spanContextKey).(*spanContext); ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		span.traceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanContextKey, span.spanContext), span
+}
+
+// logSpan is a span that is logged when it ends
+type logSpan struct {
+	*spanContext
+	name       string
+	parentID   string
+	start      time.Time
+	attributes log.Data
+	mu         sync.Mutex
+}
+
+func (s *logSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+func (s *logSpan) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes["trace_id"] = s.traceID
+	s.attributes["span_id"] = s.spanID
+	if len(s.parentID) > 0 {
+		s.attributes["parent_span_id"] = s.parentID
+	}
+	s.attributes["duration"] = time.Since(s.start).String()
+	log.Trace(s.name, s.attributes)
+}
+
+// randomHex returns a random hex string representing n bytes
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
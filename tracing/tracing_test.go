@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	Convey("A valid traceparent header should be parsed", t, func() {
+		sc, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		So(ok, ShouldBeTrue)
+		So(sc.traceID, ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+		So(sc.spanID, ShouldEqual, "00f067aa0ba902b7")
+	})
+
+	Convey("An invalid traceparent header should be ignored", t, func() {
+		_, ok := parseTraceparent("00-4bf92f3577b34da6-00f067aa0ba902b7-01")
+		So(ok, ShouldBeFalse)
+		_, ok = parseTraceparent("")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestLogTracerCreatesChildSpans(t *testing.T) {
+	Convey("A span started from the context of another span should share its trace id", t, func() {
+		tracer := NewLogTracer()
+		ctx, parent := tracer.Start(context.Background(), "parent")
+		_, child := tracer.Start(ctx, "child")
+
+		p := parent.(*logSpan)
+		c := child.(*logSpan)
+		So(c.traceID, ShouldEqual, p.traceID)
+		So(c.parentID, ShouldEqual, p.spanID)
+		So(c.spanID, ShouldNotEqual, p.spanID)
+		So(len(p.traceID), ShouldEqual, 32)
+		So(len(p.spanID), ShouldEqual, 16)
+		child.End()
+		parent.End()
+	})
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	Convey("Middleware should continue the trace given in the traceparent header", t, func() {
+		UseTracer(NewLogTracer())
+		defer UseTracer(nil)
+
+		var handlerCtx context.Context
+		handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerCtx = r.Context()
+		}))
+
+		r := httptest.NewRequest("POST", "/render/svg", nil)
+		r.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		sc, ok := handlerCtx.Value(spanContextKey).(*spanContext)
+		So(ok, ShouldBeTrue)
+		So(sc.traceID, ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+		So(w.Header().Get(TraceparentHeader), ShouldStartWith, "00-4bf92f3577b34da6a3ce929d0e0e4736-")
+		So(strings.Contains(w.Header().Get(TraceparentHeader), "00f067aa0ba902b7"), ShouldBeFalse)
+	})
+
+	Convey("Middleware should not add a traceparent header when tracing is disabled", t, func() {
+		handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/render/svg", nil))
+
+		So(w.Header().Get(TraceparentHeader), ShouldBeEmpty)
+	})
+}